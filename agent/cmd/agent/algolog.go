@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 算法日志：配置 algo_log.enabled 后，算法（及 apps 中各应用）的 stdout / stderr 不再输出到 agent 的
+// 控制台，而是追加写入 <dir>/algorithm.log（应用为 app-<name>.log，dir 缺省为 <state_dir>/logs）。
+// 进程直接写文件而不是经管道交给 agent，shutdown_policy detach 时 agent 退出后照常记录；每次启动前
+// 写入一行标记，记下启动时间与版本。agent 每 algoLogPoll 读取新增内容，文件超过 max_size_mb 时
+// 轮转为 .1 … .<max_files>（复制后截断，截断瞬间写入的少量输出可能丢失）。
+// upload 为真时读到的行带上读取时间、应用与版本，每 upload_every_seconds 批量发送到服务端
+// POST /api/v1/logs；服务端不可达时在内存中最多保留 algoLogBuffer 行，超出丢弃最旧的并在下一批中
+// 报告丢弃数。agent 未运行期间的输出只保存在设备上的日志文件中。
+
+const (
+	algoLogPoll    = time.Second
+	algoLogBuffer  = 5000
+	algoLogBatch   = 500
+	algoLogMaxLine = 4096
+
+	defaultAlgoLogSizeMB   = 10
+	defaultAlgoLogFiles    = 3
+	defaultAlgoLogUploadEv = 30
+)
+
+// AlgoLogConfig 配置算法输出的采集、轮转与上传。
+type AlgoLogConfig struct {
+	Enabled     bool   `json:"enabled"`
+	Dir         string `json:"dir"`                  // 缺省 <state_dir>/logs
+	MaxSizeMB   int    `json:"max_size_mb"`          // 单个文件的上限，默认 10
+	MaxFiles    int    `json:"max_files"`            // 保留的轮转文件数，默认 3
+	Upload      bool   `json:"upload"`               // 上传到服务端 /api/v1/logs
+	UploadEvery int    `json:"upload_every_seconds"` // 默认 30
+}
+
+// algoLogLine 对应服务端的 AlgoLogLine。
+type algoLogLine struct {
+	Time    time.Time `json:"time"`
+	App     string    `json:"app,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Line    string    `json:"line"`
+}
+
+// algoLogMark 是启动标记行，tailer 据此得知之后的输出属于哪个版本。
+var algoLogMark = regexp.MustCompile(`^=== ota-agent .*, version (\S+) ===$`)
+
+// algoLogFile 是一个进程的日志文件；off、version 与 partial 只由 tailer 访问。
+type algoLogFile struct {
+	sup  *supervisor
+	path string
+
+	mu      sync.Mutex // 串行化启动标记与轮转
+	off     int64
+	version string
+	partial []byte
+}
+
+type algoLogs struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+	upload   bool
+	every    time.Duration
+	files    []*algoLogFile
+
+	mu      sync.Mutex
+	url     string
+	device  string
+	pending []algoLogLine
+	dropped int
+}
+
+// algoLog 在未开启 algo_log 时为 nil，进程输出到 agent 的控制台。
+var algoLog *algoLogs
+
+func configureAlgoLog(cfg *Config) error {
+	lc := cfg.AlgoLog
+	algoLog = nil
+	if !lc.Enabled {
+		return nil
+	}
+	if lc.MaxSizeMB == 0 {
+		lc.MaxSizeMB = defaultAlgoLogSizeMB
+	}
+	if lc.MaxFiles == 0 {
+		lc.MaxFiles = defaultAlgoLogFiles
+	}
+	if lc.UploadEvery == 0 {
+		lc.UploadEvery = defaultAlgoLogUploadEv
+	}
+	switch {
+	case lc.MaxSizeMB < 0:
+		return fmt.Errorf("algo_log.max_size_mb must not be negative")
+	case lc.MaxFiles < 0:
+		return fmt.Errorf("algo_log.max_files must not be negative")
+	case lc.UploadEvery < 0:
+		return fmt.Errorf("algo_log.upload_every_seconds must not be negative")
+	case lc.Upload && cfg.ServerURL == "":
+		return fmt.Errorf("algo_log.upload needs server_url")
+	}
+	if lc.Dir == "" {
+		lc.Dir = filepath.Join(cfg.StateDir, "logs")
+	}
+	if err := os.MkdirAll(lc.Dir, 0o755); err != nil {
+		return fmt.Errorf("algo_log: %w", err)
+	}
+	cfg.AlgoLog = lc
+	algoLog = &algoLogs{
+		dir:      lc.Dir,
+		maxSize:  int64(lc.MaxSizeMB) << 20,
+		maxFiles: lc.MaxFiles,
+		upload:   lc.Upload,
+		every:    time.Duration(lc.UploadEvery) * time.Second,
+	}
+	sup.log = algoLog.file(sup)
+	return nil
+}
+
+// file registers the log of a supervised process, nil when algo_log is
+// off. Output written before the agent started is left to the file.
+func (l *algoLogs) file(s *supervisor) *algoLogFile {
+	if l == nil {
+		return nil
+	}
+	name := "algorithm.log"
+	if s.app != "" {
+		name = "app-" + s.app + ".log"
+	}
+	f := &algoLogFile{sup: s, path: filepath.Join(l.dir, name)}
+	if fi, err := os.Stat(f.path); err == nil {
+		f.off = fi.Size()
+	}
+	l.files = append(l.files, f)
+	return f
+}
+
+// setTarget sets where lines are uploaded once the device ID is known, and
+// follows a new server URL or a reassigned device ID.
+func (l *algoLogs) setTarget(serverURL, device string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.url, l.device = serverURL+"/logs", device
+	l.mu.Unlock()
+}
+
+// open appends the start marker of version and returns the file the process
+// writes to. The caller closes it once the process has started.
+func (f *algoLogFile) open(version string) (*os.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		version = "-"
+	}
+	fmt.Fprintf(out, "=== ota-agent %s: starting %s, version %s ===\n", clk.Now().UTC().Format(time.RFC3339), f.sup.name(), version)
+	return out, nil
+}
+
+// run tails the log files and uploads what they gain.
+func (l *algoLogs) run() {
+	if l == nil {
+		return
+	}
+	poll := clk.NewTicker(algoLogPoll)
+	defer poll.Stop()
+	next := clk.Now().Add(l.every)
+	for range poll.C() {
+		for _, f := range l.files {
+			l.collect(f.read(l.maxSize, l.maxFiles))
+		}
+		if l.upload && !clk.Now().Before(next) {
+			l.flush()
+			next = clk.Now().Add(l.every)
+		}
+	}
+}
+
+// read returns the complete lines added since the last call and rotates the
+// file once it exceeds maxSize.
+func (f *algoLogFile) read(maxSize int64, maxFiles int) []algoLogLine {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	in, err := os.Open(f.path)
+	if err != nil {
+		return nil
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return nil
+	}
+	if fi.Size() < f.off {
+		// 被外部截断或替换
+		f.off, f.partial = 0, nil
+	}
+	var out []algoLogLine
+	if fi.Size() > f.off {
+		b, err := io.ReadAll(io.NewSectionReader(in, f.off, fi.Size()-f.off))
+		if err != nil {
+			log.Printf("algo_log: %s: %v", f.path, err)
+			return nil
+		}
+		f.off += int64(len(b))
+		out = f.lines(b)
+	}
+	if maxSize > 0 && f.off >= maxSize {
+		if err := rotateAlgoLog(f.path, maxFiles); err != nil {
+			log.Printf("algo_log: rotate %s: %v", f.path, err)
+		} else {
+			f.off = 0
+		}
+	}
+	return out
+}
+
+// lines splits b into lines, keeping an unterminated tail for the next read;
+// overlong lines are split every algoLogMaxLine bytes.
+func (f *algoLogFile) lines(b []byte) []algoLogLine {
+	now := clk.Now()
+	if f.version == "" {
+		// 标记在 agent 启动前写入（接管的进程），版本以记录的当前版本为准
+		f.version = f.sup.version()
+	}
+	b = append(f.partial, b...)
+	f.partial = nil
+	var out []algoLogLine
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			if len(b) < algoLogMaxLine {
+				f.partial = append([]byte(nil), b...)
+				break
+			}
+			i = algoLogMaxLine
+		}
+		i = min(i, algoLogMaxLine)
+		line := string(bytes.TrimRight(b[:i], "\r"))
+		if i < len(b) && b[i] == '\n' {
+			i++
+		}
+		b = b[i:]
+		if m := algoLogMark.FindStringSubmatch(line); m != nil {
+			f.version = m[1]
+			if f.version == "-" {
+				f.version = ""
+			}
+		}
+		out = append(out, algoLogLine{Time: now, App: f.sup.app, Version: f.version, Line: line})
+	}
+	return out
+}
+
+// rotateAlgoLog copies the log to .1 (shifting older copies up to .<keep>)
+// and truncates it; the process keeps appending to the same file.
+func rotateAlgoLog(fp string, keep int) error {
+	if keep == 0 {
+		return os.Truncate(fp, 0)
+	}
+	for i := keep - 1; i >= 1; i-- {
+		_ = os.Rename(fp+"."+strconv.Itoa(i), fp+"."+strconv.Itoa(i+1))
+	}
+	in, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(fp + ".1")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Truncate(fp, 0)
+}
+
+// collect queues lines for upload, dropping the oldest beyond algoLogBuffer.
+func (l *algoLogs) collect(lines []algoLogLine) {
+	if len(lines) == 0 || !l.upload {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = append(l.pending, lines...)
+	if n := len(l.pending) - algoLogBuffer; n > 0 {
+		l.pending = append(l.pending[:0], l.pending[n:]...)
+		l.dropped += n
+	}
+}
+
+// flush uploads the pending lines in batches, stopping at the first failure.
+func (l *algoLogs) flush() {
+	for {
+		l.mu.Lock()
+		n := min(len(l.pending), algoLogBatch)
+		if n == 0 {
+			l.mu.Unlock()
+			return
+		}
+		batch := append([]algoLogLine(nil), l.pending[:n]...)
+		u, device, dropped := l.url, l.device, l.dropped
+		l.mu.Unlock()
+		if err := postAlgoLog(u, device, batch, dropped); err != nil {
+			log.Printf("algo_log upload: %v", err)
+			return
+		}
+		l.mu.Lock()
+		l.pending = l.pending[n:]
+		l.dropped -= dropped
+		l.mu.Unlock()
+	}
+}
+
+func postAlgoLog(u, device string, lines []algoLogLine, dropped int) error {
+	body, err := json.Marshal(map[string]any{"device_id": device, "lines": lines, "dropped": dropped})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return nil
+}
+
+// binVersion is the version a binary-backend path runs: algo_<version>
+// behind the algo_current link, otherwise the recorded current version.
+func (s *supervisor) binVersion(bin string) string {
+	if t, err := filepath.EvalSymlinks(bin); err == nil {
+		if v, ok := strings.CutPrefix(filepath.Base(t), "algo_"); ok && v != "" {
+			return v
+		}
+	}
+	return s.version()
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/launch"
+)
+
+// 多应用：避障、跟踪、降落等算法是各自独立的程序。顶层配置描述主应用，走完整的更新流程（影子运行、
+// 更新后健康检查与回滚、暂存目录、本地 API 的取消与强制回滚）；apps 列出其余应用，每个应用有自己的渠道、
+// 安装目录、进程与版本文件（其 state_dir 下的 current_version、algo_current、algo.pid），
+// 由同一个主循环在主应用之后依次检查，检查带 app 参数，服务端按应用分别维护各渠道的最新版本。
+// 其余应用沿用设备级的门控（本地 API 暂停、安全影响、温度与负载、电量、飞行状态）与制品校验
+// （sha256、制品签名、cosign、透明日志），进程同样按 shutdown_policy 在 agent 重启后接管；
+// 只支持 server 来源与 binary 后端（单个二进制或带启动模板的 tar.gz / zip 包），不做影子运行与更新后健康检查；
+// 进程意外退出后同样按 restart 自动重启，但崩溃循环时不回滚。
+// 飞行状态门控关闭时整次推迟，不先下载。
+
+// AppConfig 是 apps 中的一个应用。
+type AppConfig struct {
+	Name       string `json:"name"`        // 服务端的应用名，如 landing
+	Channel    string `json:"channel"`     // 缺省同顶层 channel
+	InstallDir string `json:"install_dir"` // 必填，与主应用及其它应用互不相同
+	StateDir   string `json:"state_dir"`   // 缺省同 install_dir
+}
+
+var appNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// appSlot 是 apps 中一个应用的运行状态。
+type appSlot struct {
+	name string
+	cfg  *Config // 顶层配置的副本，渠道与目录换成应用自己的
+	src  updateSource
+	inst installer
+	sup  *supervisor
+	// slots 是保留的已安装版本（keep_versions），应用不回滚，只用于清理旧版本
+	slots *slotList
+
+	mu   sync.Mutex // 保护 last 与热加载时修改的 cfg.Channel
+	last *checkResult
+}
+
+var apps []*appSlot
+
+// configureApps validates cfg.Apps and creates their slots; it runs after
+// the primary app's state_dir is settled.
+func configureApps(cfg *Config) error {
+	names := map[string]bool{}
+	dirs := map[string]string{filepath.Clean(cfg.InstallDir): "the primary app", filepath.Clean(cfg.StateDir): "the primary app"}
+	for i, ac := range cfg.Apps {
+		switch {
+		case !appNameRe.MatchString(ac.Name):
+			return fmt.Errorf("apps[%d]: invalid name %q (want lowercase letters, digits, _ and -)", i, ac.Name)
+		case names[ac.Name]:
+			return fmt.Errorf("apps[%d]: duplicate name %q", i, ac.Name)
+		case ac.InstallDir == "":
+			return fmt.Errorf("app %s: install_dir is required", ac.Name)
+		case cfg.Source != "" && cfg.Source != sourceServer:
+			return fmt.Errorf("app %s: apps need the server update source", ac.Name)
+		}
+		names[ac.Name] = true
+		c := *cfg
+		c.app, c.Apps = ac.Name, nil
+		c.InstallDir, c.StateDir, c.InstallBackend = ac.InstallDir, ac.StateDir, backendBinary
+		if ac.Channel != "" {
+			c.Channel = ac.Channel
+		}
+		if err := checkStateDir(&c); err != nil {
+			return fmt.Errorf("app %s: %w", ac.Name, err)
+		}
+		for _, d := range []string{c.InstallDir, c.StateDir} {
+			if owner, ok := dirs[filepath.Clean(d)]; ok && owner != "app "+ac.Name {
+				return fmt.Errorf("app %s: %s is already used by %s", ac.Name, d, owner)
+			}
+			dirs[filepath.Clean(d)] = "app " + ac.Name
+		}
+		a := &appSlot{name: ac.Name, cfg: &c, src: &serverSource{cfg: &c}, sup: newSupervisor(ac.Name)}
+		a.sup.setDirs(c.StateDir, c.InstallDir)
+		a.sup.setRestart(c.Restart)
+		a.sup.log = algoLog.file(a.sup)
+		a.inst = &binaryInstaller{dir: c.StateDir, base: c.InstallDir, restart: a.restart}
+		a.slots = &slotList{}
+		a.slots.configure(c.StateDir, c.KeepVersions)
+		apps = append(apps, a)
+	}
+	return nil
+}
+
+// startApps starts (or adopts) the installed version of every app.
+func startApps() {
+	for _, a := range apps {
+		go a.sup.run()
+		bin := overlayFile(a.cfg, "algo_current")
+		if _, err := os.Stat(bin); err != nil {
+			a.sup.stopDetached()
+			log.Printf("app %s: no version installed yet", a.name)
+			continue
+		}
+		if err := a.sup.startOrAdopt(bin); err != nil {
+			log.Printf("app %s: start: %v", a.name, err)
+		}
+	}
+}
+
+// stopApps stops the app processes (shutdown_policy stop).
+func stopApps() {
+	for _, a := range apps {
+		if err := a.sup.do(opStop, ""); err != nil {
+			log.Printf("app %s: stop: %v", a.name, err)
+		}
+	}
+}
+
+func (a *appSlot) restart(bin string) error {
+	if err := a.sup.do(opStop, ""); err != nil {
+		return err
+	}
+	clk.Sleep(300 * time.Millisecond)
+	return a.sup.do(opStart, bin)
+}
+
+// runApps checks every app once, after the primary app.
+func runApps(ctx context.Context, cfg *Config) {
+	for _, a := range apps {
+		if ctx.Err() != nil {
+			return
+		}
+		// 主应用的检查可能已改用服务端重新分配的设备 ID
+		a.cfg.DeviceID = cfg.DeviceID
+		if err := a.runOnce(ctx); err != nil {
+			log.Printf("app %s: check/update error: %v", a.name, err)
+		}
+	}
+}
+
+func (a *appSlot) runOnce(ctx context.Context) (err error) {
+	current := a.sup.version()
+	ck, err := a.src.Check(ctx, current)
+	a.checked(ck, err)
+	metrics.checked(a.name, err)
+	if err != nil {
+		return err
+	}
+	if (ck.Approval != "" || ck.Window != nil || ck.Freeze != nil) && ck.Latest != nil {
+		log.Printf("app %s: %s withheld: %s", a.name, ck.Latest.Version, ck.Message)
+		return nil
+	}
+	if !ck.UpdateAvailable || ck.Latest == nil {
+		return nil
+	}
+	rel := ck.Latest
+	rel.App = a.name
+	rel.mandatory = ck.Mandatory
+	log.Printf("app %s: new version: %s (%s)", a.name, rel.Version, rel.Channel)
+	if reason := control.paused(); reason != "" {
+		log.Printf("app %s: deferring %s: %s", a.name, rel.Version, reason)
+		reportDeferral(current, rel, "paused", reason)
+		return nil
+	}
+	if !safetyAllowed(a.cfg, ck.Safety) {
+		log.Printf("app %s: holding %s: safety impact %s exceeds max_safety_impact %s", a.name, rel.Version, ck.Safety, maxSafety(a.cfg))
+		return nil
+	}
+	if reason := gate.busy(); reason != "" {
+		log.Printf("app %s: deferring %s: %s", a.name, rel.Version, reason)
+		return nil
+	}
+	if reason := batteryHold(a.cfg, ck.Mandatory); reason != "" {
+		log.Printf("app %s: deferring %s: %s", a.name, rel.Version, reason)
+		reportDeferral(current, rel, "battery", reason)
+		return nil
+	}
+	if reason := gateHold(); reason != "" {
+		log.Printf("app %s: deferring %s: %s", a.name, rel.Version, reason)
+		reportDeferral(current, rel, "update_gate", reason)
+		return nil
+	}
+	// 设备上已有相同 sha256 的制品时不下载，链路门控与流量上限不适用（见 artifactcache.go）
+	local := findArtifact(ctx, a.cfg, rel.Sha256) != ""
+	if reason := downloadHold(); reason != "" && !local {
+		log.Printf("app %s: deferring %s: %s", a.name, rel.Version, reason)
+		reportDeferral(current, rel, "download_gate", reason)
+		return nil
+	}
+	if reason := dataHold(rel); reason != "" && !local {
+		log.Printf("app %s: deferring %s: %s", a.name, rel.Version, reason)
+		reportDeferral(current, rel, "data_cap", reason)
+		return nil
+	}
+	started := clk.Now()
+	held := false
+	defer func() {
+		if !held {
+			a.report(current, rel, started, err)
+		}
+	}()
+	if f := releaseFormat(rel); f != backendBinary {
+		return fmt.Errorf("release %s is a %s artifact, apps install binaries", rel.Version, f)
+	}
+	if rel.ArtifactType != "" {
+		return fmt.Errorf("release %s is an %s image, apps install binaries", rel.Version, rel.ArtifactType)
+	}
+	if rel.Launch != "" {
+		if _, err := launch.Parse(rel.Launch); err != nil {
+			return fmt.Errorf("release %s: %w", rel.Version, err)
+		}
+	}
+	if err := verifyArtifactSignature(rel); err != nil {
+		return err
+	}
+	tmpFile := filepath.Join(a.cfg.StateDir, "download_"+rel.Version)
+	defer func() { _ = os.Remove(tmpFile) }()
+	prunePartials(tmpFile)
+	if local && reuseArtifact(ctx, a.cfg, rel, tmpFile) {
+		// 不下载，照常校验
+	} else if err := a.src.Fetch(ctx, rel, tmpFile); err != nil {
+		if reason, ok := downloadHeld(err); ok {
+			log.Printf("app %s: stopped downloading %s, resuming later: %s", a.name, rel.Version, reason)
+			held = true
+			reportDeferral(current, rel, "download_gate", reason)
+			return nil
+		}
+		if reason, ok := diskShort(err); ok {
+			log.Printf("app %s: deferring download of %s: %s", a.name, rel.Version, reason)
+			held = true
+			reportDeferral(current, rel, "disk_space", reason)
+			return nil
+		}
+		if reason, ok := dataCapped(err); ok {
+			log.Printf("app %s: deferring download of %s: %s", a.name, rel.Version, reason)
+			held = true
+			reportDeferral(current, rel, "data_cap", reason)
+			return nil
+		}
+		return err
+	}
+	ok, err := verifySha256(ctx, tmpFile, rel.Sha256)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("sha256 mismatch")
+	}
+	if err := verifyCosign(a.cfg, rel); err != nil {
+		return err
+	}
+	if err := verifyTransparency(ctx, a.cfg, rel); err != nil {
+		return err
+	}
+	if err := preflightInstall(a.cfg.StateDir, rel, tmpFile); err != nil {
+		reason, _ := diskShort(err)
+		log.Printf("app %s: deferring install of %s: %s", a.name, rel.Version, reason)
+		held = true
+		reportDeferral(current, rel, "disk_space", reason)
+		return nil
+	}
+	if err := gate.wait(ctx, "install"); err != nil {
+		return err
+	}
+	if err := a.inst.Install(ctx, rel, tmpFile); err != nil {
+		return err
+	}
+	if err := a.sup.setVersion(rel.Version); err != nil {
+		return err
+	}
+	a.inst.Prune(a.slots.installed(rel.Version, current))
+	log.Printf("app %s: updated to %s", a.name, rel.Version)
+	return nil
+}
+
+func (a *appSlot) checked(ck *CheckResp, err error) {
+	res := &checkResult{At: clk.Now()}
+	switch {
+	case err != nil:
+		res.Error = err.Error()
+	case ck.UpdateAvailable && ck.Latest != nil:
+		res.Latest = ck.Latest.Version
+	default:
+		res.Freeze = ck.Freeze
+	}
+	a.mu.Lock()
+	a.last = res
+	a.mu.Unlock()
+}
+
+// report queues the outcome of an app update; identical failures share a key
+// as for the primary app.
+func (a *appSlot) report(from string, rel *Release, started time.Time, err error) {
+	status, msg := "success", ""
+	if err != nil {
+		status, msg = "failure", err.Error()
+	}
+	metrics.installed(a.name, status)
+	sum := sha256.Sum256([]byte(msg))
+	reports.enqueue(queuedEvent{
+		Key:     fmt.Sprintf("install:%s:%s:%s:%x", from, rel.Version, status, sum[:4]),
+		Type:    "report",
+		Channel: rel.Channel,
+		// 与推迟上报一样，事件的 version 是主应用的版本
+		Version: readCurrentVersion(),
+		Data: map[string]any{
+			"app":           a.name,
+			"status":        status,
+			"from":          from,
+			"to":            rel.Version,
+			"error":         msg,
+			"duration_ms":   clk.Since(started).Milliseconds(),
+			"download_host": rel.fetchedFrom,
+		},
+	})
+}
+
+// appVersions returns the installed version of every app, nil without apps.
+func appVersions() map[string]string {
+	if len(apps) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(apps))
+	for _, a := range apps {
+		out[a.name] = a.sup.version()
+	}
+	return out
+}
+
+// appsStatus is the apps section of the local API status.
+func appsStatus() []map[string]any {
+	out := []map[string]any{}
+	for _, a := range apps {
+		a.mu.Lock()
+		last, channel := a.last, a.cfg.Channel
+		a.mu.Unlock()
+		out = append(out, map[string]any{
+			"name":       a.name,
+			"channel":    channel,
+			"version":    a.sup.version(),
+			"restarts":   a.sup.restartCount(),
+			"last_check": last,
+		})
+	}
+	return out
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 按摘要复用本地制品：下载前先在设备上已保留的制品中查找 sha256 相同的文件——槽位中的 algo_<version>
+// （二进制与容器镜像归档，含 install_dir 中出厂预装的版本），deb / rpm 后端为 packages/ 中的包文件。
+// 旧构建被重新发布为新版本、回滚后再次安装同一份制品时，直接硬链接（跨文件系统时复制）为下载文件，
+// 不经蜂窝链路重新下载，不计流量也不受链路门控；之后的 sha256、cosign、透明日志校验与安装照常进行，
+// 安装报告的 download_host 为 local。带启动模板的版本解压安装，不保留原始制品，无法复用。
+// 算过的摘要按路径、大小与修改时间记在 <state_dir>/artifact_hashes.json，每个文件只读一遍。
+
+const artifactHashesFile = "artifact_hashes.json"
+
+// hashEntry 是一个已保留文件的摘要，大小或修改时间变化即失效。
+type hashEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Sha256  string    `json:"sha256"`
+}
+
+// artifactHashesMu 串行化 artifact_hashes.json 的读写。
+var artifactHashesMu sync.Mutex
+
+// keptArtifacts lists the artifact files kept on the device by cfg's
+// install backend.
+func keptArtifacts(cfg *Config) []string {
+	if backendName(cfg) != backendBinary {
+		matches, _ := filepath.Glob(filepath.Join(cfg.StateDir, "packages", "*"))
+		return matches
+	}
+	dirs := []string{cfg.StateDir}
+	if cfg.InstallDir != "" && filepath.Clean(cfg.InstallDir) != filepath.Clean(cfg.StateDir) {
+		dirs = append(dirs, cfg.InstallDir)
+	}
+	var out []string
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "algo_*"))
+		for _, fp := range matches {
+			name := filepath.Base(fp)
+			if name == "algo_current" || strings.HasSuffix(name, launchSuffix) || strings.HasSuffix(name, imageSuffix) || strings.HasSuffix(name, ".tmp") {
+				continue
+			}
+			out = append(out, fp)
+		}
+	}
+	return out
+}
+
+// findArtifact returns a kept file whose sha256 is want, "" when there is
+// none. Files not hashed before are read once and remembered.
+func findArtifact(ctx context.Context, cfg *Config, want string) string {
+	if want == "" {
+		return ""
+	}
+	artifactHashesMu.Lock()
+	defer artifactHashesMu.Unlock()
+	file := filepath.Join(cfg.StateDir, artifactHashesFile)
+	index := map[string]hashEntry{}
+	if b, err := os.ReadFile(file); err == nil {
+		if err := json.Unmarshal(b, &index); err != nil {
+			log.Printf("%s: %v", file, err)
+		}
+	}
+	changed := false
+	for fp := range index {
+		if _, err := os.Stat(fp); err != nil {
+			delete(index, fp)
+			changed = true
+		}
+	}
+	found := ""
+	for _, fp := range keptArtifacts(cfg) {
+		fi, err := os.Lstat(fp)
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		e, ok := index[fp]
+		if !ok || e.Size != fi.Size() || !e.ModTime.Equal(fi.ModTime()) {
+			sum, err := fileSha256(ctx, fp)
+			if err != nil {
+				if ctx.Err() != nil {
+					break
+				}
+				continue
+			}
+			e = hashEntry{Size: fi.Size(), ModTime: fi.ModTime(), Sha256: sum}
+			index[fp] = e
+			changed = true
+		}
+		if e.Sha256 == want {
+			found = fp
+			break
+		}
+	}
+	if changed {
+		b, _ := json.Marshal(index)
+		if err := os.WriteFile(file, b, 0o644); err != nil {
+			log.Printf("%s: %v", file, err)
+		}
+	}
+	return found
+}
+
+func fileSha256(ctx context.Context, fp string) (string, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, &gatedReader{ctx: ctx, r: f, op: "artifact lookup"}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reuseArtifact puts a kept file with rel's sha256 at dst instead of
+// downloading it and reports whether it did.
+func reuseArtifact(ctx context.Context, cfg *Config, rel *Release, dst string) bool {
+	src := findArtifact(ctx, cfg, rel.Sha256)
+	if src == "" {
+		return false
+	}
+	_ = os.Remove(dst)
+	if err := os.Link(src, dst); err != nil {
+		// install_dir 可能在另一个（只读）文件系统上
+		if err := copyLocal(src, dst, rel); err != nil {
+			log.Printf("reuse %s for %s: %v", src, rel.Version, err)
+			_ = os.Remove(dst)
+			return false
+		}
+	}
+	_ = os.Remove(dst + partSuffix)
+	_ = os.Remove(dst + partSuffix + ".etag")
+	rel.fetchedFrom = "local"
+	log.Printf("%s has the sha256 of %s on the device, not downloading", rel.Version, src)
+	return true
+}
+
+func copyLocal(src, dst string, rel *Release) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err := preflightDownload(filepath.Dir(dst), rel, fi.Size(), 0); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
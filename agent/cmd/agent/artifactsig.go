@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/von0000/dronealgo-ota/internal/artifactsig"
+	"github.com/von0000/dronealgo-ota/internal/tlog"
+)
+
+// 制品签名：sha256 只能发现传输损坏，服务端被攻破时摘要与制品可以一起被替换。服务端配置
+// -signing-key 后在发布时用 ed25519 对制品的应用、版本与 sha256 签名（见 internal/artifactsig），
+// /check 随版本下发 signature 与 key_id；签名绑定版本号，旧制品不能被冒充为新版本下发。
+// agent 配置 artifact_public_key（base64 ed25519 公钥）后只安装用该密钥签名的版本：
+// 未签名、密钥不符或签名无效的版本在下载前即被拒绝，并作为安装失败上报。影子部署与侧载同样校验。
+// 只签摘要的旧签名不再被接受，升级服务端后运行一次重新签名任务（POST /api/v1/maintenance/resign）。
+
+// artifactPub 为空表示不校验制品签名。
+var artifactPub ed25519.PublicKey
+
+func loadArtifactKey(cfg *Config) (ed25519.PublicKey, error) {
+	if cfg.ArtifactPublicKey == "" {
+		return nil, nil
+	}
+	pub, err := base64.StdEncoding.DecodeString(cfg.ArtifactPublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("artifact_public_key is not a base64 ed25519 public key")
+	}
+	return pub, nil
+}
+
+// verifyArtifactSignature checks the detached signature of rel over its app,
+// version and sha256; the downloaded file is tied to the digest by
+// verifySha256.
+func verifyArtifactSignature(rel *Release) error {
+	if artifactPub == nil {
+		return nil
+	}
+	if rel.Signature == "" {
+		return fmt.Errorf("release %s is not signed; artifact_public_key requires signed artifacts", rel.Version)
+	}
+	if want := tlog.KeyID(artifactPub); rel.KeyID != "" && rel.KeyID != want {
+		return fmt.Errorf("release %s is signed by key %s, want %s", rel.Version, rel.KeyID, want)
+	}
+	sig, err := base64.StdEncoding.DecodeString(rel.Signature)
+	if err != nil {
+		return fmt.Errorf("release %s: malformed signature: %w", rel.Version, err)
+	}
+	msg, err := artifactsig.Message(rel.App, rel.Version, rel.Sha256)
+	if err != nil {
+		return fmt.Errorf("release %s: %w", rel.Version, err)
+	}
+	if !ed25519.Verify(artifactPub, msg, sig) {
+		return fmt.Errorf("release %s: invalid artifact signature", rel.Version)
+	}
+	return nil
+}
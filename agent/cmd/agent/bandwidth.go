@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// 下载限速与链路空闲门控：制品下载与遥测、图传共用机载链路，大版本下载曾挤占图传带宽。
+//   - max_download_kbps 以令牌桶限制制品下载速率（kbit/s，突发不超过 1 秒的量），服务端、区域镜像与 OCI
+//     来源都受限；主应用、其它应用与影子版本的下载共享同一配额。0（默认）不限速。
+//   - download_gate 配置“链路空闲”条件，来源与 update_gate 相同（http / file / window，见 updategate.go）。
+//     条件不成立时不开始下载，上报一次 status=deferred（reason 为门控原因）；下载中每 downloadGatePoll
+//     复查一次，条件不再成立时停止下载并保留部分文件，之后条件成立的检查从断点续传（见 download.go）。
+// 已下载并校验、等待 update_gate 的制品不受 download_gate 限制。
+
+// downloadGatePoll 是下载过程中复查 download_gate 的间隔。
+const downloadGatePoll = 5 * time.Second
+
+// downloadGate 在未配置 download_gate 时为 nil。
+var downloadGate updateGate
+
+// tokenBucket 是下载共享的令牌桶，单位为字节。
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒补充的字节数，0 为不限速
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+var dlLimit tokenBucket
+
+func (b *tokenBucket) configure(kbps int) error {
+	if kbps < 0 {
+		return fmt.Errorf("max_download_kbps must not be negative")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = float64(kbps) * 1000 / 8
+	b.burst, b.tokens, b.last = b.rate, b.rate, clk.Now()
+	return nil
+}
+
+// chunk is the largest read worth issuing at once, 0 when unlimited.
+func (b *tokenBucket) chunk() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int(b.burst)
+}
+
+// take consumes n bytes and returns how long the caller must wait to stay
+// within the rate.
+func (b *tokenBucket) take(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate == 0 {
+		return 0
+	}
+	now := clk.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// downloadHeldError stops a download when the link is no longer idle.
+type downloadHeldError struct{ reason string }
+
+func (e *downloadHeldError) Error() string { return "download paused: " + e.reason }
+
+// downloadHeld reports whether err stopped a download for download_gate, and why.
+func downloadHeld(err error) (string, bool) {
+	var h *downloadHeldError
+	if errors.As(err, &h) {
+		return h.reason, true
+	}
+	return "", false
+}
+
+// downloadHold returns why a download must not start now, "" when it may.
+func downloadHold() string {
+	if downloadGate == nil {
+		return ""
+	}
+	if open, reason := downloadGate.Open(); !open {
+		return reason
+	}
+	return ""
+}
+
+// downloadReader paces an artifact stream to max_download_kbps and stops it
+// once download_gate closes.
+type downloadReader struct {
+	ctx     context.Context
+	r       io.Reader
+	checked time.Time
+}
+
+func newDownloadReader(ctx context.Context, r io.Reader) *downloadReader {
+	return &downloadReader{ctx: ctx, r: r, checked: clk.Now()}
+}
+
+func (d *downloadReader) Read(p []byte) (int, error) {
+	if downloadGate != nil && clk.Since(d.checked) >= downloadGatePoll {
+		d.checked = clk.Now()
+		if reason := downloadHold(); reason != "" {
+			return 0, &downloadHeldError{reason}
+		}
+	}
+	if max := dlLimit.chunk(); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+	n, err := d.r.Read(p)
+	metrics.downloaded(n)
+	if wait := dlLimit.take(n); wait > 0 {
+		if serr := sleepCtx(d.ctx, wait); serr != nil {
+			return n, serr
+		}
+	}
+	return n, err
+}
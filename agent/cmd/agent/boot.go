@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// BootConfig 控制冷启动时的依赖等待顺序。超时时间为 0 表示不等待该依赖。
+type BootConfig struct {
+	WaitNetworkSeconds int    `json:"wait_network_seconds"` // 首次检查前等待服务端可达
+	WaitNTPSeconds     int    `json:"wait_ntp_seconds"`     // 首次检查前等待系统时钟同步
+	WaitFCLinkSeconds  int    `json:"wait_fc_link_seconds"` // 启动算法前等待飞控链路
+	FCLinkURL          string `json:"fc_link_url"`          // 飞控链路探测：HTTP 200 即视为就绪
+	FCLinkFile         string `json:"fc_link_file"`         // 或：文件/设备节点存在即视为就绪（如 /dev/ttyACM0）
+}
+
+const (
+	phaseInit           = "init"
+	phaseWaitingFCLink  = "waiting_fc_link"
+	phaseWaitingNetwork = "waiting_network"
+	phaseWaitingNTP     = "waiting_ntp"
+	phaseRunning        = "running"
+)
+
+type bootStep struct {
+	Name     string    `json:"name"`
+	Result   string    `json:"result"` // ok | timeout | skipped
+	Started  time.Time `json:"started"`
+	Duration string    `json:"duration"`
+}
+
+type bootStatus struct {
+	mu    sync.RWMutex
+	Phase string
+	Steps []bootStep
+}
+
+var boot = &bootStatus{Phase: phaseInit}
+
+func (b *bootStatus) snapshot() map[string]any {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	steps := make([]bootStep, len(b.Steps))
+	copy(steps, b.Steps)
+	return map[string]any{"phase": b.Phase, "steps": steps}
+}
+
+func (b *bootStatus) setPhase(p string) {
+	b.mu.Lock()
+	b.Phase = p
+	b.mu.Unlock()
+}
+
+// waitFor polls ready() once a second until it succeeds or timeoutSec elapses,
+// recording the outcome as a boot step. It never blocks startup forever.
+func (b *bootStatus) waitFor(phase string, timeoutSec int, ready func() bool) bool {
+	started := clk.Now()
+	result := "skipped"
+	ok := true
+	if timeoutSec > 0 {
+		b.setPhase(phase)
+		deadline := started.Add(time.Duration(timeoutSec) * time.Second)
+		for {
+			if ok = ready(); ok {
+				result = "ok"
+				break
+			}
+			if clk.Now().After(deadline) {
+				result = "timeout"
+				log.Printf("boot: %s timed out after %ds, continuing", phase, timeoutSec)
+				break
+			}
+			clk.Sleep(time.Second)
+		}
+	}
+	b.mu.Lock()
+	b.Steps = append(b.Steps, bootStep{
+		Name:     phase,
+		Result:   result,
+		Started:  started,
+		Duration: clk.Since(started).Round(time.Millisecond).String(),
+	})
+	b.mu.Unlock()
+	return ok
+}
+
+// networkReady reports whether the OTA server accepts TCP connections.
+func networkReady(serverURL string) func() bool {
+	return func() bool {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return false
+		}
+		host := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			host = net.JoinHostPort(u.Hostname(), port)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		conn, err := serverDialer.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}
+}
+
+// fcLinkReady probes the flight controller link via URL or device file.
+func fcLinkReady(bc BootConfig) func() bool {
+	client := &http.Client{Timeout: 2 * time.Second}
+	return func() bool {
+		if bc.FCLinkFile != "" {
+			if _, err := os.Stat(bc.FCLinkFile); err != nil {
+				return false
+			}
+		}
+		if bc.FCLinkURL != "" {
+			resp, err := client.Get(bc.FCLinkURL)
+			if err != nil {
+				return false
+			}
+			_ = resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		}
+		return true
+	}
+}
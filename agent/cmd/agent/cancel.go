@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 可取消的更新：检查、下载、校验与安装都在一次更新的 context 下进行。agent 收到 SIGINT / SIGTERM，
+// 或本地 API 收到 POST /update/cancel 时取消该 context，进行中的下载立即中止并删除临时文件。
+// 切换一旦开始（重命名制品、包管理器安装、重启算法）就不再响应取消，避免留下半装的版本。
+
+// updateCtl 持有进行中更新的取消函数。
+type updateCtl struct {
+	mu      sync.Mutex
+	version string // 进行中的更新的目标版本，空表示没有
+	cancel  context.CancelCauseFunc
+}
+
+var inflight updateCtl
+
+// begin derives the context of an update attempt from parent; the returned
+// func must be called when the attempt ends.
+func (u *updateCtl) begin(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	u.mu.Lock()
+	u.version, u.cancel = "", cancel
+	u.mu.Unlock()
+	return ctx, func() {
+		u.mu.Lock()
+		u.version, u.cancel = "", nil
+		u.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// target records the version the running attempt is updating to.
+func (u *updateCtl) target(version string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.version = version
+}
+
+// updating returns the target version of the running update, "" when no
+// update is downloading or installing.
+func (u *updateCtl) updating() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.version
+}
+
+// abort cancels the running update and returns its target version, "" when
+// no update is downloading or installing.
+func (u *updateCtl) abort() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.version == "" {
+		return ""
+	}
+	u.cancel(errors.New("cancelled via the local API"))
+	return u.version
+}
+
+// stoppedError 表示更新因取消而中止，安装报告记为 cancelled 而非 failure。
+type stoppedError struct {
+	version string
+	cause   error
+}
+
+func (e *stoppedError) Error() string {
+	return "update to " + e.version + " stopped: " + e.cause.Error()
+}
+func (e *stoppedError) Unwrap() error { return e.cause }
+
+// cancelled replaces err with a stoppedError when the attempt's context was
+// cancelled.
+func cancelled(ctx context.Context, rel *Release, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	return &stoppedError{version: rel.Version, cause: context.Cause(ctx)}
+}
+
+// sleepCtx sleeps on the agent clock and returns early with ctx's error.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clk.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+)
+
+// configPubKey 是构建时注入的配置校验公钥（base64 编码的 ed25519 公钥）：
+//
+//	go build -ldflags "-X main.configPubKey=<base64>" ./agent/cmd/agent
+//
+// 为空时不校验配置签名；非空时要求 <config>.sig 存在且签名有效。
+var configPubKey = ""
+
+func configSigPath(fp string) string {
+	return fp + ".sig"
+}
+
+// verifyConfigSignature checks the detached signature of the raw config bytes
+// against the baked-in public key.
+func verifyConfigSignature(fp string, data []byte) error {
+	if configPubKey == "" {
+		return nil
+	}
+	pub, err := base64.StdEncoding.DecodeString(configPubKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("invalid baked-in config public key")
+	}
+	raw, err := os.ReadFile(configSigPath(fp))
+	if err != nil {
+		return errors.New("config signature required: " + err.Error())
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return errors.New("malformed config signature: " + err.Error())
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		return errors.New("config signature mismatch, refusing to run")
+	}
+	return nil
+}
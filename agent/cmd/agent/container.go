@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/von0000/dronealgo-ota/internal/oci"
+)
+
+// 容器运行：artifact_type 为 oci 的版本，制品是容器镜像归档（docker save 或 OCI image layout 的 tar，
+// 见 internal/oci/archive.go）。配置 container.runtime（docker、nerdctl 或 podman）后，binary 后端把
+// 归档导入运行时并打 tag ota-algo:<version>，归档本身保留为 algo_<version>（回滚时镜像已被清理则重新
+// 导入），镜像记录保存在 algo_<version>.image，algo_current 照常指向 algo_<version>。启动时以
+// `<runtime> run --rm --name ota-algo` 在前台运行容器，按 container 配置加上 CPU、内存与进程数限制，
+// agent 监管的是前台的 run 进程：停止时信号由它转发给容器，容器退出即进程退出，重启、崩溃循环回滚与
+// 健康检查与二进制版本相同，因此不使用运行时自己的重启策略。启动前删除上次遗留的同名容器。
+// 容器内没有 agent 为算法进程设置的环境变量与工作目录；需要时在 container.args 中以 -e / -v 传入。
+// 只用于主应用，影子部署与新旧进程交接不支持容器版本（交接配置对它们不生效，按停止再启动的方式重启）。
+
+const (
+	artifactOCI = "oci"
+	// imageSuffix 是保存版本镜像记录的文件后缀。
+	imageSuffix   = ".image"
+	containerName = "ota-algo"
+	imageRepo     = "ota-algo"
+)
+
+// ContainerConfig 是运行容器镜像版本的配置，runtime 为空时不接受这类版本。
+type ContainerConfig struct {
+	Runtime   string   `json:"runtime"`    // docker | nerdctl | podman
+	CPUs      float64  `json:"cpus"`       // 0 不限
+	MemoryMB  int      `json:"memory_mb"`  // 0 不限
+	PidsLimit int      `json:"pids_limit"` // 0 不限
+	Args      []string `json:"args"`       // 追加给 run 的参数，如 --network=host、--device=/dev/video0
+}
+
+var containerRuntimes = map[string]bool{"docker": true, "nerdctl": true, "podman": true}
+
+// containers 是生效的容器配置，由 setup 设置。
+var containers ContainerConfig
+
+func checkContainerConfig(cfg *Config) error {
+	c := cfg.Container
+	if c.Runtime == "" {
+		containers = c
+		return nil
+	}
+	if !containerRuntimes[c.Runtime] {
+		return fmt.Errorf("container.runtime %q: want docker, nerdctl or podman", c.Runtime)
+	}
+	if c.CPUs < 0 || c.MemoryMB < 0 || c.PidsLimit < 0 {
+		return fmt.Errorf("container: limits must not be negative")
+	}
+	if backendName(cfg) != backendBinary {
+		return fmt.Errorf("container images need the binary install backend")
+	}
+	if _, err := exec.LookPath(c.Runtime); err != nil {
+		return fmt.Errorf("container.runtime: %w", err)
+	}
+	containers = c
+	return nil
+}
+
+// checkImageRelease rejects, before the download, a release this agent
+// cannot run: an unknown artifact type, or an image without a runtime.
+func checkImageRelease(rel *Release) error {
+	switch rel.ArtifactType {
+	case "", backendBinary:
+		return nil
+	case artifactOCI:
+	default:
+		return fmt.Errorf("release %s has unknown artifact type %q", rel.Version, rel.ArtifactType)
+	}
+	if containers.Runtime == "" {
+		return fmt.Errorf("release %s is an OCI image; set container.runtime to run it", rel.Version)
+	}
+	if rel.Launch != "" {
+		return fmt.Errorf("release %s: OCI images take no launch template", rel.Version)
+	}
+	return nil
+}
+
+// imageRecord 是 algo_<version>.image 的内容。
+type imageRecord struct {
+	Ref string `json:"ref"` // ota-algo:<version>
+	ID  string `json:"id"`  // sha256:<config 摘要>
+}
+
+// invalidTagChars 是镜像 tag 不允许的字符（如 semver 的 +build）。
+var invalidTagChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// installImage loads the image archive of rel into the runtime, tags it and
+// keeps the archive as dst with the image record next to it.
+func installImage(rel *Release, file, dst string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	id, err := oci.ImageID(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", rel.Version, err)
+	}
+	if rel.ImageID != "" && id != rel.ImageID {
+		return fmt.Errorf("verify %s: image %s, the release says %s", rel.Version, id, rel.ImageID)
+	}
+	rec := imageRecord{Ref: imageRepo + ":" + invalidTagChars.ReplaceAllString(rel.Version, "_"), ID: id}
+	if err := loadImage(file, rec); err != nil {
+		return err
+	}
+	b, _ := json.Marshal(rec)
+	if err := os.WriteFile(dst+imageSuffix, b, 0o644); err != nil {
+		return err
+	}
+	_ = os.Remove(dst + launchSuffix)
+	return os.Rename(file, dst)
+}
+
+// loadImage imports archive and tags its image as rec.Ref.
+func loadImage(archive string, rec imageRecord) error {
+	rt := containers.Runtime
+	if _, err := run([]string{rt, "load", "-i", archive}); err != nil {
+		return fmt.Errorf("%s load: %w", rt, err)
+	}
+	if _, err := run([]string{rt, "tag", rec.ID, rec.Ref}); err != nil {
+		return fmt.Errorf("%s tag %s: %w", rt, rec.Ref, err)
+	}
+	return nil
+}
+
+func readImageRecord(target string) (*imageRecord, error) {
+	b, err := os.ReadFile(target + imageSuffix)
+	if err != nil {
+		return nil, err
+	}
+	var rec imageRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("%s: %w", target+imageSuffix, err)
+	}
+	return &rec, nil
+}
+
+// ensureImage makes sure the image of the version installed as target is
+// still in the runtime, loading it again from the kept archive when it was
+// removed; targets that are not images are left alone.
+func ensureImage(target string) error {
+	rec, err := readImageRecord(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if containers.Runtime == "" {
+		return fmt.Errorf("%s is an OCI image; set container.runtime to run it", filepath.Base(target))
+	}
+	if _, err := run([]string{containers.Runtime, "image", "inspect", rec.Ref}); err == nil {
+		return nil
+	}
+	log.Printf("image %s is gone, loading it again from %s", rec.Ref, target)
+	return loadImage(target, *rec)
+}
+
+// isImage reports whether bin resolves to a version that runs as a
+// container.
+func isImage(bin string) bool {
+	target, err := filepath.EvalSymlinks(bin)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(target + imageSuffix)
+	return err == nil
+}
+
+// imageArgv returns the run command of the image installed as target; ok
+// is false when target is not an image.
+func imageArgv(target string) (argv []string, ok bool, err error) {
+	rec, err := readImageRecord(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	c := containers
+	if c.Runtime == "" {
+		return nil, true, fmt.Errorf("%s is an OCI image; set container.runtime to run it", filepath.Base(target))
+	}
+	argv = []string{c.Runtime, "run", "--rm", "--name", containerName}
+	if c.CPUs > 0 {
+		argv = append(argv, "--cpus="+strconv.FormatFloat(c.CPUs, 'f', -1, 64))
+	}
+	if c.MemoryMB > 0 {
+		argv = append(argv, "--memory="+strconv.Itoa(c.MemoryMB)+"m")
+	}
+	if c.PidsLimit > 0 {
+		argv = append(argv, "--pids-limit="+strconv.Itoa(c.PidsLimit))
+	}
+	argv = append(argv, c.Args...)
+	return append(argv, rec.Ref), true, nil
+}
+
+// removeContainer deletes a container left by an earlier run that was
+// killed before it could clean up.
+func removeContainer() {
+	if containers.Runtime == "" {
+		return
+	}
+	// 容器不存在时返回错误，忽略即可
+	_, _ = run([]string{containers.Runtime, "rm", "-f", containerName})
+}
+
+// removeImage untags the image of a pruned version; layers still used by
+// other versions stay.
+func removeImage(record string) {
+	rec, err := readImageRecord(record[:len(record)-len(imageSuffix)])
+	if err != nil || containers.Runtime == "" {
+		return
+	}
+	if _, err := run([]string{containers.Runtime, "rmi", rec.Ref}); err != nil {
+		log.Printf("remove image %s: %v", rec.Ref, err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 本地控制：现场技术人员经本地 API 查询并操纵 agent，无需通过 SSH 翻日志。
+//   - 暂停 / 恢复更新：暂停期间照常检查与上报，但不下载、不安装（上报一次 status=deferred，reason 为暂停原因），
+//     进行中的更新不受影响（用 /update/cancel 取消）。暂停状态写入 <state_dir>/updates_paused.json，重启后仍然有效。
+//   - 立即检查：唤醒主循环，不等下一个检查间隔。
+//   - 强制回滚：切回上一个版本槽位（见 slots.go），并把当前版本记为坏版本，之后不再自动安装（二分定位指定的除外）。
+//     更新进行中时拒绝。服务端召回当前版本时，主循环以同样的方式回滚；算法崩溃循环时同样回滚（见 restart.go）。
+
+const (
+	pausedFile = "updates_paused.json"
+	// previousFile 是版本槽位之前只记录上一个版本的文件，只在升级时读取（见 slots.go）。
+	previousFile = "previous_version"
+)
+
+// pauseState 是暂停的时间与原因。
+type pauseState struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// checkResult 是最近一次检查的结果。
+type checkResult struct {
+	At     time.Time `json:"at"`
+	Error  string    `json:"error,omitempty"`
+	Latest string    `json:"latest,omitempty"` // 有可用更新时的目标版本
+	// Freeze 是服务端的更新冻结，现场人员据此知道为什么拿不到新版本
+	Freeze *FreezeNotice `json:"freeze,omitempty"`
+}
+
+// FreezeNotice 是检查响应中的更新冻结。
+type FreezeNotice struct {
+	ID     string    `json:"id"`
+	Scope  string    `json:"scope"` // global | project | channel | group
+	Reason string    `json:"reason"`
+	EndsAt time.Time `json:"ends_at"`
+}
+
+type agentControl struct {
+	mu    sync.Mutex
+	dir   string
+	pause *pauseState
+	last  *checkResult
+	// busy 在一次检查（及其更新）期间持有，强制回滚不能与之并行
+	busy sync.Mutex
+	wake chan struct{}
+}
+
+var control = agentControl{wake: make(chan struct{}, 1)}
+
+func (a *agentControl) configure(cfg *Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dir = cfg.StateDir
+	b, err := os.ReadFile(filepath.Join(a.dir, pausedFile))
+	if err != nil {
+		return
+	}
+	var p pauseState
+	if err := json.Unmarshal(b, &p); err != nil {
+		log.Printf("%s: %v", pausedFile, err)
+		return
+	}
+	a.pause = &p
+	log.Printf("updates paused since %s: %s", p.Since.Format(time.RFC3339), p.Reason)
+}
+
+// paused returns why updates are paused, "" when they are not.
+func (a *agentControl) paused() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pause == nil {
+		return ""
+	}
+	if a.pause.Reason == "" {
+		return "updates paused via the local API"
+	}
+	return "updates paused via the local API: " + a.pause.Reason
+}
+
+// setPaused pauses (p != nil) or resumes updates and persists the state.
+func (a *agentControl) setPaused(p *pauseState) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fp := filepath.Join(a.dir, pausedFile)
+	if p == nil {
+		if err := os.Remove(fp); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else {
+		b, _ := json.Marshal(p)
+		if err := os.WriteFile(fp, b, 0o644); err != nil {
+			return err
+		}
+	}
+	a.pause = p
+	return nil
+}
+
+// checked records the outcome of a check.
+func (a *agentControl) checked(ck *CheckResp, err error) {
+	res := &checkResult{At: clk.Now()}
+	switch {
+	case err != nil:
+		res.Error = err.Error()
+	case ck.UpdateAvailable && ck.Latest != nil:
+		res.Latest = ck.Latest.Version
+	default:
+		res.Freeze = ck.Freeze
+	}
+	a.mu.Lock()
+	a.last = res
+	a.mu.Unlock()
+}
+
+func (a *agentControl) status() map[string]any {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return map[string]any{"paused": a.pause, "last_check": a.last}
+}
+
+// checkNow wakes the main loop; a wake-up already pending is enough.
+func (a *agentControl) checkNow() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// rollbackCause 是切回上一个槽位的原因。
+type rollbackCause int
+
+const (
+	rollbackLocal     rollbackCause = iota // 本地 API 强制回滚
+	rollbackRecall                         // 服务端召回了当前版本
+	rollbackCrashLoop                      // 崩溃循环，见 restart.go
+)
+
+// rollBack switches back to the previous slot on request of the local API.
+func (a *agentControl) rollBack() (from, to string, err error) {
+	if !a.busy.TryLock() {
+		return readCurrentVersion(), "", errors.New("an update is in progress")
+	}
+	defer a.busy.Unlock()
+	return a.switchBack(rollbackLocal)
+}
+
+// switchBack switches back to the previous slot and marks the current
+// version bad so it is not installed again automatically. The caller holds
+// a.busy.
+func (a *agentControl) switchBack(cause rollbackCause) (from, to string, err error) {
+	from = readCurrentVersion()
+	to = slots.previous(from)
+	if to == "" {
+		return from, "", fmt.Errorf("no previous version to roll back to from %q", from)
+	}
+	ready.setPhase(updateRollingBack, to)
+	res := updateResult{From: from, To: to, Status: "rolled_back", At: clk.Now()}
+	reason, key := "forced via the local API", fmt.Sprintf("rollback:%s:%s:%d", from, to, res.At.Unix())
+	// 召回与崩溃循环回滚失败时会重试，相同的结果只上报一次
+	switch cause {
+	case rollbackRecall:
+		reason, key = "recalled by the server", fmt.Sprintf("recall:%s:%s", from, to)
+	case rollbackCrashLoop:
+		rc := sup.restartConfig()
+		reason = fmt.Sprintf("crash loop: more than %d crashes within %d minutes", rc.MaxCrashes, rc.WindowMinutes)
+		key = fmt.Sprintf("crashloop:%s:%s", from, to)
+	}
+	defer func() {
+		if err != nil {
+			res.Status, res.Error = "rollback_failed", err.Error()
+		}
+		ready.finished(res)
+		metrics.installed("", res.Status)
+		sum := sha256.Sum256([]byte(res.Error))
+		reports.enqueue(queuedEvent{
+			Key:     fmt.Sprintf("%s:%x", key, sum[:4]),
+			Type:    "report",
+			Version: from,
+			Data: map[string]any{
+				"status": res.Status,
+				"from":   from,
+				"to":     to,
+				"error":  res.Error,
+				"reason": reason,
+			},
+		})
+	}()
+	if err = scratch.prepare(to); err != nil {
+		return from, to, err
+	}
+	if err = inst.Rollback(to); err != nil {
+		return from, to, fmt.Errorf("roll back to %s: %w", to, err)
+	}
+	if err = sup.setVersion(to); err != nil {
+		return from, to, err
+	}
+	bad.add(from)
+	slots.drop(from)
+	scratch.prune(slots.list()...)
+	log.Printf("rolled back from %s to %s: %s", from, to, reason)
+	return from, to, nil
+}
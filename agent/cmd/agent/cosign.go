@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/von0000/dronealgo-ota/internal/cosign"
+)
+
+// cosignV 为空表示未配置 cosign 校验。
+var cosignV *cosign.Verifier
+
+func newCosignVerifier(cfg *Config) (*cosign.Verifier, error) {
+	if cfg.CosignPublicKey == "" && cfg.CosignRoots == "" {
+		if cfg.RequireCosign {
+			return nil, errors.New("require_cosign needs cosign_public_key or cosign_roots")
+		}
+		return nil, nil
+	}
+	v := &cosign.Verifier{Identity: cfg.CosignIdentity, Issuer: cfg.CosignIssuer}
+	var err error
+	if cfg.CosignPublicKey != "" {
+		if v.Key, err = cosign.LoadPublicKey(cfg.CosignPublicKey); err != nil {
+			return nil, fmt.Errorf("cosign_public_key: %w", err)
+		}
+	}
+	if cfg.CosignRoots != "" {
+		if v.Roots, err = cosign.LoadRoots(cfg.CosignRoots); err != nil {
+			return nil, fmt.Errorf("cosign_roots: %w", err)
+		}
+	}
+	if cfg.RekorPublicKey != "" {
+		if v.Rekor, err = cosign.LoadPublicKey(cfg.RekorPublicKey); err != nil {
+			return nil, fmt.Errorf("rekor_public_key: %w", err)
+		}
+	}
+	if err := v.Check(); err != nil {
+		return nil, fmt.Errorf("cosign_roots needs rekor_public_key: %w", err)
+	}
+	return v, nil
+}
+
+// verifyCosign checks the release's cosign bundle against the downloaded
+// artifact digest (already verified against rel.Sha256).
+func verifyCosign(cfg *Config, rel *Release) error {
+	if cosignV == nil {
+		return nil
+	}
+	if len(rel.CosignBundle) == 0 {
+		if cfg.RequireCosign {
+			return fmt.Errorf("release %s has no cosign signature", rel.Version)
+		}
+		return nil
+	}
+	if err := cosignV.Verify(rel.Sha256, rel.CosignBundle); err != nil {
+		return fmt.Errorf("release %s: %w", rel.Version, err)
+	}
+	return nil
+}
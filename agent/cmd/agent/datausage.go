@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 流量统计与月度上限：不少客户的每架飞机有固定的蜂窝流量配额，超出后或停卡或高额计费。agent 经由
+// 服务端客户端的全部流量（检查、制品下载、上报、遥测、OCI registry）按 HTTP 消息体计入当月的下载与上传
+// 字节数（不含报头与 TLS 开销，实际计费略高），保存在 state_dir/data_usage.json，agent 重启后继续累计。
+// 计费周期从 data_cap_reset_day（1–28，默认 1）的本地零点开始。用量随心跳上报（data_usage），
+// 本地 API /status 与 /metrics 也给出。
+// 配置 monthly_data_cap_mb 后，当月用量达到上限，或制品下载（服务端来源在响应头给出大小后，OCI 来源按
+// 层描述符）会使用量超过上限时，不下载非强制版本：以 data_cap 推迟上报（不计为安装失败），下个计费周期
+// 或版本改为强制后再下载。强制版本（安全修复）不受上限限制；检查、上报与遥测照常进行并计入用量。
+
+const dataUsageFile = "data_usage.json"
+
+// dataUsageSave 是两次保存用量之间的最短间隔，agent 崩溃时最多少计这段时间的流量。
+const dataUsageSave = time.Minute
+
+// dataPeriod 是一个计费周期的用量。
+type dataPeriod struct {
+	Start    string `json:"period_start"` // 周期开始日期，如 2026-10-01
+	Download int64  `json:"download_bytes"`
+	Upload   int64  `json:"upload_bytes"`
+}
+
+type dataUsage struct {
+	mu       sync.Mutex
+	path     string
+	capBytes int64 // 0 为不限
+	resetDay int
+	cur      dataPeriod
+	dirty    bool
+	saved    time.Time
+	// refused 记录因上限被拒绝的下载还需的字节数（按 sha256），之后的检查不必再发起请求即可推迟
+	refused map[string]int64
+}
+
+var dataUse dataUsage
+
+// checkDataUsage validates the cap settings and loads the usage counted so
+// far in this billing period.
+func checkDataUsage(cfg *Config) error {
+	if cfg.MonthlyDataCapMB < 0 {
+		return fmt.Errorf("monthly_data_cap_mb must not be negative")
+	}
+	if cfg.DataCapResetDay == 0 {
+		cfg.DataCapResetDay = 1
+	}
+	if cfg.DataCapResetDay < 1 || cfg.DataCapResetDay > 28 {
+		return fmt.Errorf("data_cap_reset_day must be between 1 and 28")
+	}
+	u := &dataUse
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.path = filepath.Join(cfg.StateDir, dataUsageFile)
+	u.capBytes = int64(cfg.MonthlyDataCapMB) << 20
+	u.resetDay = cfg.DataCapResetDay
+	u.cur = dataPeriod{}
+	if b, err := os.ReadFile(u.path); err == nil {
+		if err := json.Unmarshal(b, &u.cur); err != nil {
+			return fmt.Errorf("%s: %w", dataUsageFile, err)
+		}
+	}
+	u.rollLocked()
+	return nil
+}
+
+// periodStart returns the first day of the billing period containing now.
+func (u *dataUsage) periodStart(now time.Time) string {
+	y, m, d := now.Date()
+	if d < u.resetDay {
+		m--
+	}
+	return time.Date(y, m, u.resetDay, 0, 0, 0, 0, now.Location()).Format(time.DateOnly)
+}
+
+// rollLocked starts a new period once the current one is over.
+func (u *dataUsage) rollLocked() {
+	if start := u.periodStart(clk.Now()); start != u.cur.Start {
+		if u.cur.Start != "" {
+			log.Printf("data usage %s: %d bytes down, %d bytes up; starting a new period", u.cur.Start, u.cur.Download, u.cur.Upload)
+		}
+		u.cur = dataPeriod{Start: start}
+		u.dirty = true
+	}
+}
+
+// add counts bytes received and sent.
+func (u *dataUsage) add(down, up int64) {
+	if down <= 0 && up <= 0 {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollLocked()
+	u.cur.Download += max(down, 0)
+	u.cur.Upload += max(up, 0)
+	u.dirty = true
+	if clk.Since(u.saved) >= dataUsageSave {
+		u.saveLocked()
+	}
+}
+
+// flush writes unsaved usage to state_dir.
+func (u *dataUsage) flush() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollLocked()
+	u.saveLocked()
+}
+
+func (u *dataUsage) saveLocked() {
+	if !u.dirty || u.path == "" {
+		return
+	}
+	u.saved = clk.Now()
+	b, _ := json.Marshal(u.cur)
+	tmp := u.path + ".tmp"
+	err := os.WriteFile(tmp, b, 0o644)
+	if err == nil {
+		err = os.Rename(tmp, u.path)
+	}
+	if err != nil {
+		log.Printf("data usage: %v", err)
+		return
+	}
+	u.dirty = false
+}
+
+// snapshot is the usage reported with heartbeats and by the local API.
+func (u *dataUsage) snapshot() map[string]any {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollLocked()
+	m := map[string]any{
+		"period_start":   u.cur.Start,
+		"download_bytes": u.cur.Download,
+		"upload_bytes":   u.cur.Upload,
+	}
+	if u.capBytes > 0 {
+		m["cap_bytes"] = u.capBytes
+		m["capped"] = u.cur.Download+u.cur.Upload >= u.capBytes
+	}
+	return m
+}
+
+// counts returns this period's bytes down and up and the cap, 0 when none.
+func (u *dataUsage) counts() (down, up, capBytes int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollLocked()
+	return u.cur.Download, u.cur.Upload, u.capBytes
+}
+
+// dataCapError 表示下载会超出月度流量上限，下载没有开始。
+type dataCapError struct{ reason string }
+
+func (e *dataCapError) Error() string { return "data cap: " + e.reason }
+
+// dataCapped reports whether err stopped a download at the monthly data
+// cap, and why.
+func dataCapped(err error) (string, bool) {
+	var d *dataCapError
+	if errors.As(err, &d) {
+		return d.reason, true
+	}
+	return "", false
+}
+
+// dataHold returns why rel must not be downloaded now, "" when it may; a
+// download refused before counts with the size it needed then.
+func dataHold(rel *Release) string {
+	dataUse.mu.Lock()
+	need := dataUse.refused[rel.Sha256]
+	dataUse.mu.Unlock()
+	if err := admitDownload(rel, need); err != nil {
+		reason, _ := dataCapped(err)
+		return reason
+	}
+	return ""
+}
+
+// admitDownload checks that downloading need more bytes of rel stays within
+// the monthly data cap; mandatory releases are always admitted.
+func admitDownload(rel *Release, need int64) error {
+	u := &dataUse
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.rollLocked()
+	used := u.cur.Download + u.cur.Upload
+	switch {
+	case u.capBytes == 0 || rel.mandatory:
+		return nil
+	case used >= u.capBytes:
+		return &dataCapError{fmt.Sprintf("%d MiB of the %d MiB monthly data cap used", used>>20, u.capBytes>>20)}
+	case used+need > u.capBytes:
+		if u.refused == nil {
+			u.refused = map[string]int64{}
+		}
+		u.refused[rel.Sha256] = need
+		return &dataCapError{fmt.Sprintf("the %d MiB download would exceed the monthly data cap (%d of %d MiB used)",
+			(need+1<<20-1)>>20, used>>20, u.capBytes>>20)}
+	}
+	delete(u.refused, rel.Sha256)
+	return nil
+}
+
+// countingTransport counts the request and response bodies of every
+// request made with the agent's HTTP client.
+type countingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = &countingBody{ReadCloser: req.Body, up: true}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &countingBody{ReadCloser: resp.Body}
+	return resp, nil
+}
+
+type countingBody struct {
+	io.ReadCloser
+	up bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.up {
+		dataUse.add(0, int64(n))
+	} else {
+		dataUse.add(int64(n), 0)
+	}
+	return n, err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 出厂镜像可通过 ldflags 注入默认值，无需随镜像携带配置文件：
+//
+//	go build -ldflags "-X main.defaultServerURL=https://ota.example.com/api/v1 -X main.defaultChannel=stable" ./agent/cmd/agent
+//
+// 或使用 -tags embedconfig 将 embedded/defaults.json 与 embedded/ca.pem 编译进二进制。
+// 优先级（低 -> 高）：内置默认值 < embedded/defaults.json < ldflags < 运行时配置文件。
+var (
+	defaultServerURL  = ""
+	defaultDeviceID   = ""
+	defaultChannel    = ""
+	defaultInstallDir = ""
+	defaultCheckEvery = ""
+)
+
+// embeddedDefaults / embeddedCA 仅在 embedconfig 构建下非空（见 defaults_embed.go）。
+var (
+	embeddedDefaults []byte
+	embeddedCA       []byte
+)
+
+// httpClient is used for every request to the server; serverDialer is its
+// cached resolver, shared with the boot-time reachability probe.
+var (
+	httpClient   = http.DefaultClient
+	serverDialer *dnsCache
+)
+
+func defaultConfig() (*Config, error) {
+	c := &Config{
+		Channel:    "stable",
+		InstallDir: "/var/lib/dronealgo-ota",
+		CheckEvery: 10,
+
+		DNSCacheTTL:  300,
+		LocalAPIAddr: "127.0.0.1:7080",
+	}
+	if len(embeddedDefaults) > 0 {
+		if err := json.Unmarshal(embeddedDefaults, c); err != nil {
+			return nil, errors.New("embedded defaults: " + err.Error())
+		}
+	}
+	if defaultServerURL != "" {
+		c.ServerURL = defaultServerURL
+	}
+	if defaultDeviceID != "" {
+		c.DeviceID = defaultDeviceID
+	}
+	if defaultChannel != "" {
+		c.Channel = defaultChannel
+	}
+	if defaultInstallDir != "" {
+		c.InstallDir = defaultInstallDir
+	}
+	if n, err := strconv.Atoi(defaultCheckEvery); err == nil && n > 0 {
+		c.CheckEvery = n
+	}
+	return c, nil
+}
+
+// newHTTPClient builds the shared client: DNS goes through the agent's cache.
+// Requests to the server trust the configured CA file or, failing that, the
+// embedded CA, and present the device certificate when one is configured;
+// requests to other hosts use the system roots.
+func newHTTPClient(cfg *Config) (*http.Client, error) {
+	tlsCfg := &tls.Config{ServerName: cfg.TLSServerName}
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return nil, errors.New("client_cert and client_key must be set together")
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	pem := embeddedCA
+	if cfg.CAFile != "" {
+		b, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pem = b
+	}
+	if len(pem) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no valid certificate in CA bundle")
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	u, err := url.Parse(cfg.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+	serverDialer = newDNSCache(
+		time.Duration(cfg.DNSCacheTTL)*time.Second,
+		filepath.Join(cfg.StateDir, "dns_cache.json"),
+		u.Hostname(),
+		cfg.ServerIP,
+		cfg.IPFamily,
+	)
+	// 服务端的 ServerName、私有 CA 与设备证书只用于 server_url 的主机；registry、区域镜像等
+	// 其它主机用系统根证书校验
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = serverDialer.DialContext
+	server := tr.Clone()
+	server.TLSClientConfig = tlsCfg
+	st := &serverTransport{
+		server:   &countingTransport{base: server},
+		base:     &countingTransport{base: tr},
+		token:    cfg.AuthToken,
+		instance: instanceID(cfg.StateDir),
+	}
+	st.host.Store(&u.Host)
+	return &http.Client{Transport: st}, nil
+}
+
+// serverTransport 只给发往 OTA 服务端的请求附加令牌与实例指纹，并用服务端的 TLS 配置；
+// registry 等其他主机不会收到它们。
+type serverTransport struct {
+	server   http.RoundTripper      // 发往 OTA 服务端
+	base     http.RoundTripper      // 其它主机
+	host     atomic.Pointer[string] // 热加载 server_url 时替换，见 reload.go
+	token    string
+	instance string
+}
+
+func (t *serverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != *t.host.Load() {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if t.token != "" && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	if t.instance != "" {
+		req.Header.Set(instanceHeader, t.instance)
+	}
+	return t.server.RoundTrip(req)
+}
@@ -0,0 +1,16 @@
+//go:build embedconfig
+
+package main
+
+import _ "embed"
+
+//go:embed embedded/defaults.json
+var embeddedDefaultsFile []byte
+
+//go:embed embedded/ca.pem
+var embeddedCAFile []byte
+
+func init() {
+	embeddedDefaults = embeddedDefaultsFile
+	embeddedCA = embeddedCAFile
+}
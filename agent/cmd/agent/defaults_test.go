@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerTLSOnlyForServerHost(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	server := httptest.NewTLSServer(http.HandlerFunc(ok))
+	defer server.Close()
+	other := httptest.NewTLSServer(http.HandlerFunc(ok))
+	defer other.Close()
+
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := newHTTPClient(&Config{ServerURL: server.URL, CAFile: ca, StateDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatalf("server host with the private CA: %v", err)
+	}
+	resp.Body.Close()
+	// 其它主机用系统根证书，不信任服务端的私有 CA
+	if resp, err := c.Get(other.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("other host verified against the server's private CA")
+	}
+}
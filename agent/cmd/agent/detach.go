@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 维护时停止 agent 不应连带停掉飞行算法。shutdown_policy 为 detach（默认）时，agent 收到
+// SIGINT / SIGTERM 后只退出自己，算法继续运行；为 stop 时先停止算法再退出。算法在独立的进程组中
+// 启动，终端的 Ctrl-C 不会波及它；用 systemd 管理 agent 时需设置 KillMode=process。
+// 算法的 PID 与启动时间（/proc/<pid>/stat 的 starttime，防止 PID 复用误判）记在
+// <install_dir>/algo.pid。agent 重启后若该进程仍在运行、且运行的正是当前版本，就接管它而不是
+// 再拉起一份；版本不符（如 agent 在切换途中退出）时先停止它再启动当前版本。
+
+const (
+	shutdownDetach = "detach"
+	shutdownStop   = "stop"
+
+	algoPIDFile = "algo.pid"
+	// stopGrace 是发出 SIGINT 后等待算法退出的时间，超时后 SIGKILL。
+	stopGrace = 10 * time.Second
+	// adoptPoll 是检查接管的进程是否仍在运行的间隔（它不是 agent 的子进程，无法 Wait）。
+	adoptPoll = time.Second
+)
+
+// algoPID 是 algo.pid 的内容。
+type algoPID struct {
+	PID     int       `json:"pid"`
+	Start   uint64    `json:"start_ticks"` // 进程启动时间（开机以来的 clock ticks）
+	Bin     string    `json:"bin"`
+	Target  string    `json:"target"` // bin 解析符号链接后的程序，用于判断是否仍是当前版本
+	Started time.Time `json:"started"`
+}
+
+func checkShutdownPolicy(cfg *Config) error {
+	switch cfg.ShutdownPolicy {
+	case "":
+		cfg.ShutdownPolicy = shutdownDetach
+	case shutdownDetach, shutdownStop:
+	default:
+		return fmt.Errorf("shutdown_policy %q: want detach or stop", cfg.ShutdownPolicy)
+	}
+	return nil
+}
+
+// recordPID persists the identity of a started algorithm process.
+func (s *supervisor) recordPID(pid int, bin string) {
+	start, err := processStart(pid)
+	if err != nil {
+		log.Printf("algo.pid: %v", err)
+		return
+	}
+	target, _ := filepath.EvalSymlinks(bin)
+	b, _ := json.Marshal(algoPID{PID: pid, Start: start, Bin: bin, Target: target, Started: clk.Now()})
+	if err := os.WriteFile(s.file(algoPIDFile), b, 0o644); err != nil {
+		log.Printf("algo.pid: %v", err)
+	}
+}
+
+func (s *supervisor) clearPID() { _ = os.Remove(s.file(algoPIDFile)) }
+
+// detached returns the recorded algorithm process if it is still the same
+// process, nil otherwise.
+func (s *supervisor) detached() *algoPID {
+	b, err := os.ReadFile(s.file(algoPIDFile))
+	if err != nil {
+		return nil
+	}
+	var p algoPID
+	if json.Unmarshal(b, &p) != nil || p.PID <= 0 || !p.alive() {
+		return nil
+	}
+	return &p
+}
+
+// alive reports whether the PID still belongs to the recorded process.
+// Processes started before an agent self-update exec are still our children
+// and are reaped here once they exit, or they would linger as zombies.
+func (p *algoPID) alive() bool {
+	if reaped(p.PID) {
+		return false
+	}
+	start, err := processStart(p.PID)
+	return err == nil && start == p.Start
+}
+
+// startOrAdopt adopts the algorithm left running by a previous agent when it
+// runs the current version of bin, and starts bin otherwise.
+func (s *supervisor) startOrAdopt(bin string) error {
+	if p := s.detached(); p != nil {
+		target, err := filepath.EvalSymlinks(bin)
+		if err == nil && p.Bin == bin && p.Target == target {
+			return s.adopt(p)
+		}
+		log.Printf("%s left running (pid=%d) runs %s, current is %s", s.name(), p.PID, p.Target, target)
+	}
+	s.stopDetached()
+	return s.do(opStart, bin)
+}
+
+// stopDetached stops an algorithm left running by a previous agent, so it is
+// not run twice once this agent starts its own.
+func (s *supervisor) stopDetached() {
+	p := s.detached()
+	if p == nil {
+		s.clearPID()
+		return
+	}
+	log.Printf("stopping %s left running by the previous agent (pid=%d)", s.name(), p.PID)
+	proc, err := os.FindProcess(p.PID)
+	if err != nil {
+		return
+	}
+	_ = proc.Signal(os.Interrupt)
+	deadline := clk.Now().Add(stopGrace)
+	for p.alive() && clk.Now().Before(deadline) {
+		clk.Sleep(100 * time.Millisecond)
+	}
+	if p.alive() {
+		_ = proc.Kill()
+	}
+	s.clearPID()
+}
+
+// shutdown applies the shutdown policy before the agent exits.
+func shutdown(cfg *Config) {
+	shadow.shutdown()
+	if cfg.ShutdownPolicy == shutdownStop {
+		stopApps()
+	}
+	pid := algo.process()
+	if pid == 0 {
+		return
+	}
+	if cfg.ShutdownPolicy == shutdownStop {
+		log.Printf("stopping algorithm (pid=%d) before exit", pid)
+		if err := stopAlgorithm(); err != nil {
+			log.Printf("stop algorithm: %v", err)
+		}
+		return
+	}
+	log.Printf("leaving algorithm running (pid=%d)", pid)
+}
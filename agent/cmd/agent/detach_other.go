@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// reaped 在非 Unix 平台上没有 wait4，遗留进程是否仍在运行只看 processStart。
+func reaped(pid int) bool {
+	return false
+}
+
+// ownGroupAttr 在非 Unix 平台上没有进程组，算法与 agent 同组启动。
+func ownGroupAttr() *syscall.SysProcAttr {
+	return nil
+}
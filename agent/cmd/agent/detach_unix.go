@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// reaped collects pid if it is an exited child of the agent and reports
+// whether it did.
+func reaped(pid int) bool {
+	var ws syscall.WaitStatus
+	got, _ := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil)
+	return got == pid
+}
+
+// ownGroupAttr starts the algorithm in a process group of its own.
+func ownGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
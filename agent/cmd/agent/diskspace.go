@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/von0000/dronealgo-ota/internal/bundle"
+)
+
+// 磁盘空间预检：SD 卡写满后下载与解压在中途失败，只留下难以理解的 I/O 错误。下载开始前（服务端来源在
+// 响应头给出制品大小之后，OCI 来源按层描述符的大小）检查下载目录所在文件系统能否放下剩余的下载、
+// 安装另需的空间，并保留 min_free_mb（默认 64）的余量：binary 制品安装时原地改名，不另占空间；
+// tar.gz / zip 包与 deb / rpm 包按制品大小估计，tar.gz / zip 包在解压前再按包内文件的实际大小检查一次。
+// 空间不足时不下载、不安装，以 disk_space 推迟上报（不计为安装失败），下一次检查再试；主应用已
+// 下载的包保留到那时，不重复下载。旧版本在每次更新成功后按 keep_versions 清理（见 slots.go）。
+
+const defaultMinFreeMB = 64
+
+// diskMargin 是下载与安装之后至少保留的空闲字节数。
+var diskMargin int64 = defaultMinFreeMB << 20
+
+// errFreeSpaceUnknown 表示平台不支持查询剩余空间（见 freespace_other.go）。
+var errFreeSpaceUnknown = errors.New("free space is not known on this platform")
+
+// diskSpaceError 表示空间不足，下载或安装没有开始。
+type diskSpaceError struct{ reason string }
+
+func (e *diskSpaceError) Error() string { return "not enough disk space: " + e.reason }
+
+// diskShort reports whether err stopped an update for lack of disk space,
+// and why.
+func diskShort(err error) (string, bool) {
+	var d *diskSpaceError
+	if errors.As(err, &d) {
+		return d.reason, true
+	}
+	return "", false
+}
+
+// checkMinFree defaults min_free_mb and sets the margin kept free.
+func checkMinFree(cfg *Config) error {
+	if cfg.MinFreeMB == 0 {
+		cfg.MinFreeMB = defaultMinFreeMB
+	}
+	if cfg.MinFreeMB < 0 {
+		return fmt.Errorf("min_free_mb must not be negative")
+	}
+	diskMargin = int64(cfg.MinFreeMB) << 20
+	return nil
+}
+
+// installSpace estimates the space installing an artifact of size bytes
+// takes besides the downloaded file.
+func installSpace(rel *Release, size int64) int64 {
+	if releaseFormat(rel) == backendBinary && rel.Launch == "" {
+		return 0
+	}
+	return size
+}
+
+// preflightDownload checks that dir has room for the rest of a download of
+// rel (size bytes, have of them already on disk) and its install.
+func preflightDownload(dir string, rel *Release, size, have int64) error {
+	return needSpace(dir, max(size-have, 0)+installSpace(rel, size))
+}
+
+// preflightInstall checks that dir has room to extract a downloaded
+// bundle; other artifacts were accounted for before the download.
+func preflightInstall(dir string, rel *Release, file string) error {
+	if releaseFormat(rel) != backendBinary || rel.Launch == "" {
+		return nil
+	}
+	n, err := bundleSize(file)
+	if err != nil {
+		// 损坏的包由解压报告
+		return nil
+	}
+	return needSpace(dir, n)
+}
+
+func needSpace(dir string, need int64) error {
+	free, err := freeSpace(dir)
+	if err != nil {
+		// 无法判断时照常进行，写入失败时再报告
+		return nil
+	}
+	if int64(free) < need+diskMargin {
+		return &diskSpaceError{fmt.Sprintf("%s has %d MiB free, the update needs %d MiB and min_free_mb is %d",
+			dir, free>>20, (need+1<<20-1)>>20, diskMargin>>20)}
+	}
+	return nil
+}
+
+// bundleSize sums the sizes of the files in a tar.gz or zip bundle.
+func bundleSize(file string) (int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return bundle.Size(f, fi.Size())
+}
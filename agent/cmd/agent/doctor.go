@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 自检：启动时（以及 `agent doctor`）逐项检查配置、服务端 DNS/TLS 可达性、磁盘空间、时钟、
+// algo_current 符号链接与算法程序可执行性。启动时的结果只记录日志并通过本地 API /doctor 查询，
+// 不阻止 agent 运行；`agent doctor` 在有 fail 项时以状态码 1 退出，便于产线与现场脚本使用。
+
+const (
+	diagOK   = "ok"
+	diagWarn = "warn"
+	diagFail = "fail"
+)
+
+const (
+	// minFreeBytes 以下无法完成一次下载与切换；lowFreeBytes 以下给出警告。
+	minFreeBytes = 64 << 20
+	lowFreeBytes = 256 << 20
+	// maxClockSkew 超过后签名与证书有效期判断可能出错。
+	maxClockSkew = 2 * time.Minute
+	diagTimeout  = 10 * time.Second
+)
+
+type diagCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // ok | warn | fail
+	Detail string `json:"detail,omitempty"`
+}
+
+type diagReport struct {
+	Time   time.Time   `json:"time"`
+	OK     bool        `json:"ok"` // 没有 fail 项
+	Checks []diagCheck `json:"checks"`
+}
+
+func (r *diagReport) add(name, status, format string, args ...any) {
+	r.Checks = append(r.Checks, diagCheck{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+	if status == diagFail {
+		r.OK = false
+	}
+}
+
+// runDiagnostics checks cfg; setupErr is what setup returned for it.
+func runDiagnostics(cfg *Config, setupErr error) diagReport {
+	r := diagReport{Time: clk.Now(), OK: true}
+	if setupErr != nil {
+		r.add("config", diagFail, "%v", setupErr)
+	} else if (cfg.Source == "" || cfg.Source == sourceServer) && cfg.ServerURL == "" {
+		r.add("config", diagFail, "server_url is empty")
+	} else {
+		r.add("config", diagOK, "source %s, backend %s, channel %s", sourceName(cfg), backendName(cfg), cfg.Channel)
+	}
+	checkDeviceID(&r, cfg)
+
+	var serverDate time.Time
+	if src != nil {
+		serverDate = checkServer(&r, cfg)
+	}
+	checkClock(&r, serverDate)
+	checkDisk(&r, cfg.StateDir)
+	checkAlgorithm(&r, cfg)
+	return r
+}
+
+func sourceName(cfg *Config) string {
+	if cfg.Source == "" {
+		return sourceServer
+	}
+	return cfg.Source
+}
+
+func checkDeviceID(r *diagReport, cfg *Config) {
+	if cfg.DeviceID != "" {
+		r.add("device_id", diagOK, "%s", cfg.DeviceID)
+		return
+	}
+	id, source, saved, err := derivedDeviceID(cfg)
+	switch {
+	case err != nil:
+		r.add("device_id", diagFail, "%v", err)
+	case saved:
+		r.add("device_id", diagOK, "%s (derived earlier)", id)
+	default:
+		r.add("device_id", diagOK, "not configured, will derive %s from %s", id, source)
+	}
+}
+
+// checkServer resolves and contacts the update source and returns the
+// server's Date header (zero when unavailable) for the clock check.
+func checkServer(r *diagReport, cfg *Config) time.Time {
+	u, err := url.Parse(src.ProbeURL())
+	if err != nil || u.Host == "" {
+		r.add("dns", diagFail, "invalid server address %q", src.ProbeURL())
+		return time.Time{}
+	}
+	host := u.Hostname()
+	ctx, cancel := context.WithTimeout(context.Background(), diagTimeout)
+	defer cancel()
+	switch {
+	case net.ParseIP(host) != nil:
+		r.add("dns", diagOK, "%s is an IP address", host)
+	case cfg.ServerIP != "" && sourceName(cfg) == sourceServer:
+		r.add("dns", diagOK, "server_ip %s pinned, DNS not used", cfg.ServerIP)
+	default:
+		// 直接查询解析器：agent 的缓存会掩盖 DNS 故障
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			r.add("dns", diagFail, "%v", err)
+		} else {
+			r.add("dns", diagOK, "%s -> %s", host, strings.Join(addrs, ", "))
+		}
+	}
+
+	probe := src.ProbeURL()
+	if sourceName(cfg) == sourceServer {
+		// 列表接口同时验证令牌，且不会像 /check 那样记入设备事件
+		probe = strings.TrimRight(cfg.ServerURL, "/") + "/releases?channel=" + url.QueryEscape(cfg.Channel)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe, nil)
+	if err != nil {
+		r.add("server", diagFail, "%v", err)
+		return time.Time{}
+	}
+	started := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		var unknownCA x509.UnknownAuthorityError
+		if errors.As(err, &certErr) || errors.As(err, &unknownCA) {
+			r.add("server", diagFail, "TLS: %v (check ca_file / tls_server_name)", err)
+		} else {
+			r.add("server", diagFail, "%v", err)
+		}
+		return time.Time{}
+	}
+	resp.Body.Close()
+	rtt := time.Since(started).Round(time.Millisecond)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		r.add("server", diagFail, "%s rejected the auth token (%s)", u.Host, resp.Status)
+	case resp.StatusCode >= 500:
+		r.add("server", diagWarn, "%s answered %s in %s", u.Host, resp.Status, rtt)
+	default:
+		tlsState := "plain HTTP"
+		if resp.TLS != nil {
+			tlsState = "TLS " + tls.VersionName(resp.TLS.Version)
+		}
+		r.add("server", diagOK, "%s reachable over %s, %s in %s", u.Host, tlsState, resp.Status, rtt)
+	}
+	date, _ := http.ParseTime(resp.Header.Get("Date"))
+	return date
+}
+
+func checkClock(r *diagReport, serverDate time.Time) {
+	now := time.Now()
+	if now.Year() < 2024 {
+		r.add("clock", diagFail, "system time %s is not set (no RTC and no NTP yet?)", now.UTC().Format(time.RFC3339))
+		return
+	}
+	if !serverDate.IsZero() {
+		skew := now.Sub(serverDate)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > maxClockSkew {
+			r.add("clock", diagWarn, "clock differs from the server by %s", skew.Round(time.Second))
+			return
+		}
+	}
+	if !clockSynced() {
+		r.add("clock", diagWarn, "kernel clock is not NTP-synchronized")
+		return
+	}
+	r.add("clock", diagOK, "%s", now.UTC().Format(time.RFC3339))
+}
+
+func checkDisk(r *diagReport, dir string) {
+	free, err := freeSpace(dir)
+	if errors.Is(err, errFreeSpaceUnknown) {
+		r.add("disk", diagWarn, "%v", err)
+		return
+	}
+	if err != nil {
+		r.add("disk", diagFail, "%v", err)
+		return
+	}
+	f, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		r.add("disk", diagFail, "%s is not writable: %v", dir, err)
+		return
+	}
+	f.Close()
+	_ = os.Remove(f.Name())
+	switch {
+	case free < minFreeBytes:
+		r.add("disk", diagFail, "only %d MiB free in %s", free>>20, dir)
+	case free < lowFreeBytes:
+		r.add("disk", diagWarn, "%d MiB free in %s", free>>20, dir)
+	default:
+		r.add("disk", diagOK, "%d MiB free in %s", free>>20, dir)
+	}
+}
+
+// checkAlgorithm verifies algo_current (binary backend) and that whatever the
+// agent would start is an executable file.
+func checkAlgorithm(r *diagReport, cfg *Config) {
+	exe := cfg.PackageExec
+	if backendName(cfg) == backendBinary {
+		link := overlayFile(cfg, "algo_current")
+		target, err := os.Readlink(link)
+		switch {
+		case os.IsNotExist(err):
+			r.add("algo_current", diagOK, "no algorithm installed yet")
+			return
+		case err != nil:
+			r.add("algo_current", diagFail, "%s is not a symlink: %v", link, err)
+			return
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(link), target)
+		}
+		if _, err := os.Stat(target); err != nil {
+			r.add("algo_current", diagFail, "dangling link to %s", target)
+			return
+		}
+		b, _ := os.ReadFile(overlayFile(cfg, "current_version"))
+		if v := strings.TrimSpace(string(b)); v != "" && filepath.Base(target) != "algo_"+v {
+			r.add("algo_current", diagWarn, "points to %s but current_version is %s", filepath.Base(target), v)
+		} else {
+			r.add("algo_current", diagOK, "-> %s", target)
+		}
+		if rec, err := readImageRecord(target); err == nil {
+			// 容器版本：检查镜像仍在运行时中
+			if containers.Runtime == "" {
+				r.add("algo_exec", diagFail, "%s is an OCI image but container.runtime is not set", filepath.Base(target))
+			} else if _, err := run([]string{containers.Runtime, "image", "inspect", rec.Ref}); err != nil {
+				r.add("algo_exec", diagFail, "image %s is not loaded in %s: %v", rec.Ref, containers.Runtime, err)
+			} else {
+				r.add("algo_exec", diagOK, "%s image %s (%s)", containers.Runtime, rec.Ref, rec.ID)
+			}
+			return
+		}
+		if _, err := os.Stat(target + launchSuffix); err == nil {
+			// 带启动模板的版本：检查模板的程序（解释器或包内可执行文件）
+			argv, err := algoArgv(link)
+			if err == nil {
+				err = checkProgram(argv[0], target, target)
+			}
+			if err != nil {
+				r.add("algo_exec", diagFail, "%v", err)
+			} else {
+				r.add("algo_exec", diagOK, "%s", strings.Join(argv, " "))
+			}
+			return
+		}
+		exe = target
+	}
+	if exe == "" {
+		r.add("algo_exec", diagOK, "the package manager runs the algorithm")
+		return
+	}
+	fi, err := os.Stat(exe)
+	switch {
+	case err != nil:
+		r.add("algo_exec", diagFail, "%v", err)
+	case !fi.Mode().IsRegular():
+		r.add("algo_exec", diagFail, "%s is not a regular file", exe)
+	case fi.Mode().Perm()&0o111 == 0:
+		r.add("algo_exec", diagFail, "%s is not executable (mode %s)", exe, fi.Mode().Perm())
+	default:
+		r.add("algo_exec", diagOK, "%s", exe)
+	}
+}
+
+// preflight 保存最近一次自检结果，供本地 API 查询。
+var preflight struct {
+	mu   sync.Mutex
+	cfg  *Config
+	last *diagReport
+}
+
+// runPreflight runs the startup diagnostics and logs every problem found.
+func runPreflight(cfg *Config) {
+	rep := runDiagnostics(cfg, nil)
+	preflight.mu.Lock()
+	preflight.cfg, preflight.last = cfg, &rep
+	preflight.mu.Unlock()
+	for _, c := range rep.Checks {
+		if c.Status != diagOK {
+			log.Printf("preflight %s %s: %s", c.Status, c.Name, c.Detail)
+		}
+	}
+}
+
+// doctorMain implements `agent doctor [-json] [config]` and returns the exit code.
+func doctorMain(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	_ = fs.Parse(args)
+
+	rep := diagReport{Time: clk.Now(), OK: true}
+	cfg, err := loadConfig(fs.Arg(0))
+	if err != nil {
+		rep.add("config", diagFail, "%v", err)
+	} else {
+		err = setup(cfg)
+		loadAssignedDeviceID(cfg)
+		rep = runDiagnostics(cfg, err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(rep)
+	} else {
+		for _, c := range rep.Checks {
+			fmt.Printf("[%-4s] %-12s %s\n", c.Status, c.Name, c.Detail)
+		}
+	}
+	if !rep.OK {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// freeSpace 在非 Unix 平台上无法查询剩余空间：下载前的空间预检照常放行，迁移不预先检查目标空间。
+func freeSpace(dir string) (uint64, error) {
+	return 0, errFreeSpaceUnknown
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// 无缝交接：重启默认是停止旧进程、等待 300ms、启动新进程，新版本启动慢时无人机在这段时间内没有避障。
+// 配置 handover.ready_url 后，更新与回滚改为交接：
+//  1. 以 handover.args（如 --port=9101）与环境变量 OTA_HANDOVER=standby 启动新进程，它在备用端口上
+//     提供就绪探针，但不输出执行器指令；
+//  2. 等待 ready_url 返回 2xx（最长 ready_timeout_seconds，默认 60）；
+//  3. 向旧进程发送 SIGTERM，drain_seconds（默认 10）内未退出则 SIGKILL；
+//  4. 向新进程发送 SIGUSR1 提升为现役，它此后接管主端口与执行器，随后照常做更新后健康检查。
+// 新进程在就绪前退出、超时，或本地 API 收到 POST /update/cancel 时中止交接：停止新进程，旧进程不受影响，
+// algo_current 指回旧版本，该版本记为坏版本（不再自动安装）。提升后健康检查失败时照常回滚，回滚同样以
+// 交接方式切回旧版本。只用于主应用与 binary 安装后端；没有 SIGUSR1 的平台上（见 handover_other.go）
+// 忽略 handover 配置，按停止再启动的方式重启。
+
+const (
+	handoverEnv = "OTA_HANDOVER"
+	// handoverPoll 是就绪探针的请求间隔。
+	handoverPoll = 500 * time.Millisecond
+)
+
+// HandoverConfig 是新旧算法进程的交接配置，ready_url 为空时按停止再启动的方式重启。
+type HandoverConfig struct {
+	ReadyURL     string   `json:"ready_url"`             // 新进程在备用端口上的就绪探针，如 http://127.0.0.1:9101/ready
+	Args         []string `json:"args"`                  // 交接时追加给新进程的参数
+	ReadyTimeout int      `json:"ready_timeout_seconds"` // 缺省 60
+	DrainTimeout int      `json:"drain_seconds"`         // 旧进程收到 SIGTERM 后的退出期限，缺省 10
+}
+
+func checkHandoverConfig(cfg *Config) error {
+	c := &cfg.Handover
+	if c.ReadyURL == "" {
+		return nil
+	}
+	if promoteSignal == nil {
+		log.Printf("handover: no promote signal on this platform, restarting the algorithm by stop and start")
+		c.ReadyURL = ""
+		return nil
+	}
+	if backendName(cfg) != backendBinary {
+		return fmt.Errorf("handover needs the binary install backend")
+	}
+	if c.ReadyTimeout < 0 || c.DrainTimeout < 0 {
+		return fmt.Errorf("handover: values must not be negative")
+	}
+	if c.ReadyTimeout == 0 {
+		c.ReadyTimeout = 60
+	}
+	if c.DrainTimeout == 0 {
+		c.DrainTimeout = 10
+	}
+	return nil
+}
+
+// handoverError 表示交接在提升之前中止，或提升失败。
+type handoverError struct {
+	stage string // start | ready | promote
+	err   error
+	// running 为真时旧进程仍在运行，未受影响
+	running bool
+	aborted bool // 经本地 API 中止
+}
+
+func (e *handoverError) Error() string { return "handover " + e.stage + ": " + e.err.Error() }
+func (e *handoverError) Unwrap() error { return e.err }
+
+// handoverFailed reports whether err comes from an aborted or failed
+// handover.
+func handoverFailed(err error) (*handoverError, bool) {
+	var he *handoverError
+	return he, errors.As(err, &he)
+}
+
+func (s *supervisor) setHandover(c HandoverConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handover = c
+}
+
+func (s *supervisor) handoverConfig() HandoverConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handover
+}
+
+// abortHandover aborts a handover waiting for its standby process and
+// returns the standby's version, "" when none is waiting.
+func (s *supervisor) abortHandover() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.standby == "" {
+		return ""
+	}
+	v := s.standby
+	close(s.abort)
+	s.standby, s.abort = "", nil
+	return v
+}
+
+// handOver replaces old with bin: the new process starts as a standby,
+// old is drained once it is ready, then the new one is promoted. It runs in
+// the owner goroutine and returns the process that is current afterwards.
+func (s *supervisor) handOver(old *child, bin string) (*child, error) {
+	h := s.handoverConfig()
+	if old == nil {
+		// 没有在运行的进程，无需交接
+		return s.startChild(bin)
+	}
+	ver := s.binVersion(bin)
+	next, err := s.spawn(bin, h.Args, handoverEnv+"=standby")
+	if err != nil {
+		return old, &handoverError{stage: "start", err: err, running: true}
+	}
+	abort := make(chan struct{})
+	s.mu.Lock()
+	s.standby, s.abort = ver, abort
+	s.mu.Unlock()
+	err = waitStandby(next, h, abort)
+	aborted := isClosed(abort)
+	s.mu.Lock()
+	s.standby, s.abort = "", nil
+	s.mu.Unlock()
+	// 旧进程可能在等待期间意外退出
+	gone := isClosed(old.exited)
+	if err != nil {
+		s.terminate(next, stopGrace)
+		if gone {
+			s.clearPID()
+			algo.stop()
+			return nil, &handoverError{stage: "ready", err: err, aborted: aborted}
+		}
+		log.Printf("handover to %s aborted, %s keeps running: %v", ver, s.name(), err)
+		return old, &handoverError{stage: "ready", err: err, running: true, aborted: aborted}
+	}
+	if !gone {
+		log.Printf("%s %s is ready, draining pid=%d", s.name(), ver, old.proc.Pid)
+		s.terminate(old, time.Duration(h.DrainTimeout)*time.Second)
+	}
+	s.clearPID()
+	algo.stop()
+	if err := next.proc.Signal(promoteSignal); err != nil {
+		return nil, &handoverError{stage: "promote", err: err}
+	}
+	s.track(next, bin)
+	log.Printf("%s %s promoted (pid=%d)", s.name(), ver, next.proc.Pid)
+	return next, nil
+}
+
+// waitStandby polls the ready URL until the standby answers 2xx; it fails
+// when the standby exits, the timeout passes or the handover is aborted.
+func waitStandby(next *child, h HandoverConfig, abort <-chan struct{}) error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	deadline := clk.After(time.Duration(h.ReadyTimeout) * time.Second)
+	for {
+		if probeHealth(context.Background(), client, h.ReadyURL) == nil {
+			return nil
+		}
+		select {
+		case <-next.exited:
+			return errors.New("the new process exited before it was ready")
+		case <-abort:
+			return errors.New("aborted via the local API")
+		case <-deadline:
+			return fmt.Errorf("%s not ready within %ds", h.ReadyURL, h.ReadyTimeout)
+		case <-clk.After(handoverPoll):
+		}
+	}
+}
+
+// terminate sends SIGTERM to c and kills it when it has not exited within
+// grace.
+func (s *supervisor) terminate(c *child, grace time.Duration) {
+	if err := c.proc.Signal(syscall.SIGTERM); err != nil {
+		_ = c.proc.Kill()
+	}
+	select {
+	case <-c.exited:
+	case <-clk.After(grace):
+		log.Printf("%s (pid=%d) still running %s after SIGTERM, killing it", s.name(), c.proc.Pid, grace)
+		_ = c.proc.Kill()
+		<-c.exited
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
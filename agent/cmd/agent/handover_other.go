@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// promoteSignal 在非 Unix 平台上不存在，交接不可用。
+var promoteSignal os.Signal
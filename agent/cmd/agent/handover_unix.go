@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// promoteSignal 通知备用进程成为现役。
+var promoteSignal os.Signal = syscall.SIGUSR1
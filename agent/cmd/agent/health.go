@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 算法运行状况：随每次检查上报（algo_health / algo_uptime / algo_crashes），服务端据此区分
+// “已是最新且运行正常”与“已是最新但算法反复崩溃”。服务端要求时（collect_diagnostics）
+// agent 立即自检，把结果与运行状况作为一条 diagnostics 事件上报。
+
+const (
+	algoRunning  = "running"
+	algoCrashing = "crashing" // crashWindow 内有过意外退出
+	algoStopped  = "stopped"  // 尚未启动或被 agent 停止
+
+	crashWindow = time.Hour
+)
+
+type algoTracker struct {
+	mu      sync.Mutex
+	running bool
+	pid     int
+	started time.Time
+	crashes []time.Time // crashWindow 内的意外退出时间
+}
+
+var algo algoTracker
+
+// start records a running process; started is when it was launched, which
+// precedes now for a process adopted after an agent restart.
+func (t *algoTracker) start(pid int, started time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running, t.pid, t.started = true, pid, started
+}
+
+// process returns the PID of the running algorithm, 0 when it is not running.
+func (t *algoTracker) process() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.running {
+		return 0
+	}
+	return t.pid
+}
+
+func (t *algoTracker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = false
+}
+
+func (t *algoTracker) crash() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = false
+	t.crashes = append(t.crashes, clk.Now())
+}
+
+// health returns the state, uptime of the running process and the number of
+// crashes within crashWindow.
+func (t *algoTracker) health() (state string, uptime time.Duration, crashes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cutoff := clk.Now().Add(-crashWindow)
+	for len(t.crashes) > 0 && t.crashes[0].Before(cutoff) {
+		t.crashes = t.crashes[1:]
+	}
+	crashes = len(t.crashes)
+	if t.running {
+		uptime = clk.Since(t.started)
+	}
+	switch {
+	case crashes > 0:
+		state = algoCrashing
+	case t.running:
+		state = algoRunning
+	default:
+		state = algoStopped
+	}
+	return state, uptime, crashes
+}
+
+// healthQuery renders the health as /check query parameters.
+func (t *algoTracker) healthQuery() string {
+	state, uptime, crashes := t.health()
+	return "&algo_health=" + state + "&algo_uptime=" + strconv.Itoa(int(uptime.Seconds())) + "&algo_crashes=" + strconv.Itoa(crashes)
+}
+
+func (t *algoTracker) healthData() map[string]any {
+	state, uptime, crashes := t.health()
+	return map[string]any{"state": state, "uptime_s": int(uptime.Seconds()), "crashes": crashes, "restarts": sup.restartCount()}
+}
+
+// reportDiagnostics runs the self-check and queues it with the algorithm
+// health for the server.
+func reportDiagnostics(cfg *Config) {
+	rep := runDiagnostics(cfg, nil)
+	log.Printf("server requested diagnostics: ok=%v", rep.OK)
+	reports.enqueue(queuedEvent{
+		Type:    "diagnostics",
+		Channel: cfg.Channel,
+		Version: readCurrentVersion(),
+		Data:    map[string]any{"doctor": rep, "algorithm": algo.healthData()},
+	})
+}
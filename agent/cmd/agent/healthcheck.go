@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 更新后健康检查与自动回滚：算法重启后，配置了 health_check.url（如 http://127.0.0.1:8080/healthz）
+// 时每 interval_seconds 请求一次，timeout_seconds 内返回 2xx 即视为健康，最多尝试 retries 次；
+// 未配置 url 时以算法连续运行 healthConfirmUptime 为准。期间算法崩溃或退出立即判定失败。
+// 失败时切回上一个版本（binary 后端重新指向 algo_<上一版本>，包管理器后端降级安装保留的上一个包）
+// 并重启，该版本记入 <install_dir>/bad_versions.json，之后的检查不再自动安装它（二分定位指定的除外）。
+
+// HealthCheckConfig 是更新后健康检查的配置。
+type HealthCheckConfig struct {
+	URL      string `json:"url"`
+	Timeout  int    `json:"timeout_seconds"`  // 单次请求超时，缺省 5
+	Retries  int    `json:"retries"`          // 缺省 10
+	Interval int    `json:"interval_seconds"` // 两次请求的间隔，缺省 3
+}
+
+const (
+	badVersionsFile = "bad_versions.json"
+	// maxBadVersions 限制记录的坏版本数，最早的先淘汰。
+	maxBadVersions = 20
+)
+
+func checkHealthCheckConfig(c *HealthCheckConfig) error {
+	if c.Timeout < 0 || c.Retries < 0 || c.Interval < 0 {
+		return fmt.Errorf("health_check: values must not be negative")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5
+	}
+	if c.Retries == 0 {
+		c.Retries = 10
+	}
+	if c.Interval == 0 {
+		c.Interval = 3
+	}
+	return nil
+}
+
+// confirmHealthy waits for the restarted algorithm to become healthy: the
+// health URL answers 2xx when one is configured, otherwise the process stays
+// up for healthConfirmUptime. A crash or exit fails the check at once, as does
+// ctx ending (the agent is stopping).
+func confirmHealthy(ctx context.Context, c HealthCheckConfig) bool {
+	_, _, crashesBefore := algo.health()
+	down := func() bool {
+		state, _, crashes := algo.health()
+		return crashes > crashesBefore || state == algoStopped
+	}
+	if c.URL == "" {
+		deadline := clk.Now().Add(healthConfirmTimeout)
+		for clk.Now().Before(deadline) {
+			if down() {
+				return false
+			}
+			if _, uptime, _ := algo.health(); uptime >= healthConfirmUptime {
+				return true
+			}
+			if sleepCtx(ctx, time.Second) != nil {
+				return false
+			}
+		}
+		return false
+	}
+	client := &http.Client{Timeout: time.Duration(c.Timeout) * time.Second}
+	for i := 0; i < c.Retries; i++ {
+		if i > 0 && sleepCtx(ctx, time.Duration(c.Interval)*time.Second) != nil {
+			return false
+		}
+		if down() {
+			return false
+		}
+		err := probeHealth(ctx, client, c.URL)
+		if err == nil {
+			return true
+		}
+		log.Printf("health check %d/%d: %v", i+1, c.Retries, err)
+	}
+	return false
+}
+
+func probeHealth(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// badVersions 是更新后健康检查失败、已被回滚的版本。
+type badVersions struct {
+	mu       sync.Mutex
+	file     string
+	versions []string
+}
+
+var bad badVersions
+
+func (b *badVersions) load(dir string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.file = filepath.Join(dir, badVersionsFile)
+	data, err := os.ReadFile(b.file)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &b.versions); err != nil {
+		log.Printf("%s: %v", b.file, err)
+	}
+}
+
+func (b *badVersions) has(v string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, x := range b.versions {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// add remembers v so it is not installed again automatically.
+func (b *badVersions) add(v string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, x := range b.versions {
+		if x == v {
+			return
+		}
+	}
+	b.versions = append(b.versions, v)
+	if n := len(b.versions); n > maxBadVersions {
+		b.versions = b.versions[n-maxBadVersions:]
+	}
+	data, _ := json.Marshal(b.versions)
+	if err := os.WriteFile(b.file, data, 0o644); err != nil {
+		log.Printf("%s: %v", b.file, err)
+	}
+}
+
+// rollBack switches back to prev after rel failed its health check and
+// remembers rel as bad.
+func rollBack(rel *Release, prev string) error {
+	bad.add(rel.Version)
+	if prev == "" {
+		return fmt.Errorf("post-update health check of %s failed; no previous version to roll back to", rel.Version)
+	}
+	ready.setPhase(updateRollingBack, prev)
+	if err := scratch.prepare(prev); err != nil {
+		return err
+	}
+	if err := inst.Rollback(prev); err != nil {
+		return fmt.Errorf("post-update health check of %s failed; rollback to %s: %w", rel.Version, prev, err)
+	}
+	if err := sup.setVersion(prev); err != nil {
+		return err
+	}
+	scratch.prune(append(slots.list(), prev)...)
+	log.Printf("rolled back to %s after %s failed its health check", prev, rel.Version)
+	return fmt.Errorf("post-update health check of %s failed; rolled back to %s", rel.Version, prev)
+}
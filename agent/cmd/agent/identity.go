@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 设备身份：同一镜像烧录出的多台设备会共用配置里的 device_id。agent 在发往服务端的请求上
+// 携带由硬件派生的实例指纹（X-Device-Instance），供服务端发现重复 ID；服务端拆分后在检查
+// 响应头 X-Device-ID 中下发新 ID，agent 将其写入 <install_dir>/device_id，此后优先于配置文件。
+// 配置中没有 device_id 时由本机标识派生一个（见 deriveDeviceID），否则服务端收到的全是空 ID。
+
+const instanceHeader = "X-Device-Instance"
+
+// instanceID fingerprints the hardware (board UUID, CPU serial, physical MACs).
+// Without any of those it falls back to a random ID kept in installDir.
+func instanceID(installDir string) string {
+	var parts []string
+	if b, err := os.ReadFile("/sys/class/dmi/id/product_uuid"); err == nil {
+		parts = append(parts, "uuid="+strings.TrimSpace(string(b)))
+	}
+	if s := cpuSerial(); s != "" {
+		parts = append(parts, "serial="+s)
+	}
+	for _, mac := range hardwareMACs() {
+		parts = append(parts, "mac="+mac)
+	}
+	if len(parts) > 0 {
+		sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+		return hex.EncodeToString(sum[:8])
+	}
+
+	fp := filepath.Join(installDir, "instance_id")
+	if b, err := os.ReadFile(fp); err == nil && len(strings.TrimSpace(string(b))) > 0 {
+		return strings.TrimSpace(string(b))
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(b)
+	if err := os.WriteFile(fp, []byte(id+"\n"), 0o644); err != nil {
+		log.Printf("instance id: %v", err)
+	}
+	return id
+}
+
+// cpuSerial returns the SoC serial from /proc/cpuinfo (Raspberry Pi, Jetson, ...).
+func cpuSerial() string {
+	b, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(k) == "Serial" {
+			v = strings.TrimSpace(v)
+			if strings.Trim(v, "0") != "" {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// hardwareMACs lists the MACs of physical interfaces, sorted. Virtual ones
+// (bridges, veth, tun) have no /sys/class/net/<if>/device and change freely.
+func hardwareMACs() []string {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, ifc := range ifs {
+		if ifc.Flags&net.FlagLoopback != 0 || len(ifc.HardwareAddr) == 0 {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join("/sys/class/net", ifc.Name, "device")); err != nil {
+			continue
+		}
+		out = append(out, ifc.HardwareAddr.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func assignedIDFile(cfg *Config) string { return filepath.Join(cfg.StateDir, "device_id") }
+
+// loadAssignedDeviceID applies a device ID previously assigned by the server.
+func loadAssignedDeviceID(cfg *Config) {
+	b, err := os.ReadFile(assignedIDFile(cfg))
+	if err != nil {
+		return
+	}
+	if id := strings.TrimSpace(string(b)); id != "" && id != cfg.DeviceID {
+		log.Printf("using server-assigned device id %s (configured: %s)", id, cfg.DeviceID)
+		cfg.DeviceID = id
+	}
+}
+
+// adoptDeviceID switches to the device ID the server assigned after splitting
+// a duplicated one, and persists it across restarts.
+func adoptDeviceID(cfg *Config, id string) {
+	fp := assignedIDFile(cfg)
+	tmp := fp + ".tmp"
+	err := os.WriteFile(tmp, []byte(id+"\n"), 0o644)
+	if err == nil {
+		err = os.Rename(tmp, fp)
+	}
+	if err != nil {
+		log.Printf("persist device id: %v", err)
+	}
+	log.Printf("server reassigned device id %s -> %s (duplicate id)", cfg.DeviceID, id)
+	cfg.DeviceID = id
+	reports.setURL(eventsURL(cfg))
+	algoLog.setTarget(cfg.ServerURL, id)
+}
+
+// idSources 把 device_id_sources 中的名称映射到读取函数与派生 ID 的前缀。
+var idSources = map[string]struct {
+	prefix string
+	read   func() string
+}{
+	"machine-id": {"mid", machineID},
+	"cpu-serial": {"cpu", cpuSerial},
+	"mac": {"mac", func() string {
+		if macs := hardwareMACs(); len(macs) > 0 {
+			return macs[0]
+		}
+		return ""
+	}},
+}
+
+// defaultIDSources 先取硬件标识：同一镜像烧录的设备共用 /etc/machine-id（镜像未清空它时），
+// 以它派生会让整批设备得到同一个 ID。
+var defaultIDSources = []string{"cpu-serial", "mac", "machine-id"}
+
+func machineID() string {
+	for _, fp := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if b, err := os.ReadFile(fp); err == nil {
+			if id := strings.TrimSpace(string(b)); id != "" && id != "uninitialized" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+func derivedIDFile(cfg *Config) string { return filepath.Join(cfg.StateDir, "derived_device_id") }
+
+// derivedDeviceID returns the ID a device without configured device_id uses:
+// the one derived earlier (saved), or the first available source in
+// device_id_sources hashed into "<prefix>-<12 hex>" so the raw machine-id is
+// never sent. It does not write anything.
+func derivedDeviceID(cfg *Config) (id, source string, saved bool, err error) {
+	sources, err := deviceIDSources(cfg)
+	if err != nil {
+		return "", "", false, err
+	}
+	if b, err := os.ReadFile(derivedIDFile(cfg)); err == nil {
+		if id := strings.TrimSpace(string(b)); id != "" {
+			return id, "", true, nil
+		}
+	}
+	for _, name := range sources {
+		src := idSources[name]
+		if v := src.read(); v != "" {
+			sum := sha256.Sum256([]byte(v))
+			return src.prefix + "-" + hex.EncodeToString(sum[:6]), name, false, nil
+		}
+	}
+	return "", "", false, fmt.Errorf("no device_id configured and none of %s is available on this machine", strings.Join(sources, ", "))
+}
+
+func deviceIDSources(cfg *Config) ([]string, error) {
+	if len(cfg.DeviceIDSources) == 0 {
+		return defaultIDSources, nil
+	}
+	for _, name := range cfg.DeviceIDSources {
+		if _, ok := idSources[name]; !ok {
+			return nil, fmt.Errorf("device_id_sources: unknown source %q (want machine-id, cpu-serial or mac)", name)
+		}
+	}
+	return cfg.DeviceIDSources, nil
+}
+
+// certDeviceID returns the CommonName of the configured device certificate,
+// the identity the server maps it to by default.
+func certDeviceID(cfg *Config) string {
+	if cfg.ClientCert == "" {
+		return ""
+	}
+	b, err := os.ReadFile(cfg.ClientCert)
+	if err != nil {
+		return ""
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		return ""
+	}
+	return cert.Subject.CommonName
+}
+
+// deriveDeviceID fills in cfg.DeviceID when none is configured and persists a
+// newly derived ID, returning the register event announcing it. A device
+// certificate's CommonName takes precedence over the machine sources.
+func deriveDeviceID(cfg *Config) (*queuedEvent, error) {
+	if cfg.DeviceID == "" {
+		if id := certDeviceID(cfg); id != "" {
+			log.Printf("no device_id configured, using %s from the client certificate", id)
+			cfg.DeviceID = id
+		}
+	}
+	if _, err := deviceIDSources(cfg); err != nil || cfg.DeviceID != "" {
+		return nil, err
+	}
+	id, source, saved, err := derivedDeviceID(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DeviceID = id
+	if saved {
+		return nil, nil
+	}
+	fp := derivedIDFile(cfg)
+	tmp := fp + ".tmp"
+	err = os.WriteFile(tmp, []byte(id+"\n"), 0o644)
+	if err == nil {
+		err = os.Rename(tmp, fp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("persist derived device id: %w", err)
+	}
+	log.Printf("no device_id configured, derived %s from %s", id, source)
+	data := telemetryData(cfg)
+	if data == nil {
+		data = map[string]any{}
+	}
+	data["id_source"] = source
+	return &queuedEvent{Key: "register:" + id, Type: "register", Channel: cfg.Channel, Data: data}, nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// 安装后端：binary（默认）直接运行下载的二进制；deb / rpm 将制品交给系统包管理器安装，
+// 适用于用 apt / rpm 统一管理机载计算机的客户。服务端的渠道定位与事件记录对两者一致。
+const (
+	backendBinary = "binary"
+	backendDeb    = "deb"
+	backendRPM    = "rpm"
+)
+
+// installer 把已通过 sha256 校验的制品安装为 rel.Version 并使其生效。ctx 只在改动设备之前检查，
+// 切换开始后即使取消也会完成，避免留下半装的版本。Rollback 切回仍保留在设备上的旧版本并重启算法，
+// 用于更新后健康检查失败、本地 API 强制回滚与服务端召回时（见 slots.go）。Prune 删除 keep 之外的已安装版本。
+type installer interface {
+	Install(ctx context.Context, rel *Release, file string) error
+	Rollback(version string) error
+	Prune(keep []string)
+}
+
+func newInstaller(cfg *Config) (installer, error) {
+	switch cfg.InstallBackend {
+	case "", backendBinary:
+		return &binaryInstaller{dir: cfg.StateDir, base: cfg.InstallDir, restart: restartAlgorithm}, nil
+	case backendDeb:
+		return newPackageInstaller(cfg, debTool)
+	case backendRPM:
+		return newPackageInstaller(cfg, rpmTool)
+	}
+	return nil, fmt.Errorf("unknown install_backend %q (want binary, deb or rpm)", cfg.InstallBackend)
+}
+
+func backendName(cfg *Config) string {
+	if cfg.InstallBackend == "" {
+		return backendBinary
+	}
+	return cfg.InstallBackend
+}
+
+// releaseFormat 返回制品格式，旧版本服务端未下发 format 时视为 binary。
+func releaseFormat(rel *Release) string {
+	if rel.Format == "" {
+		return backendBinary
+	}
+	return rel.Format
+}
+
+// binaryInstaller 安装为 algo_<version> 并原子切换 algo_current。
+type binaryInstaller struct {
+	dir  string // state_dir：新版本与 algo_current
+	base string // install_dir：出厂镜像预装的版本，可能只读
+	// restart 以新的 algo_current 重启进程：主应用为 restartAlgorithm，其它应用见 apps.go
+	restart func(bin string) error
+}
+
+func (b *binaryInstaller) Install(ctx context.Context, rel *Release, file string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dst := filepath.Join(b.dir, "algo_"+rel.Version)
+	switch {
+	case rel.ArtifactType == artifactOCI:
+		// 容器镜像导入运行时，归档保留为 algo_<version>（见 container.go）
+		if err := installImage(rel, file, dst); err != nil {
+			return err
+		}
+	case rel.Launch != "":
+		// 带启动模板的版本是 tar.gz 或 zip 包，解压为目录（见 launch.go）
+		if err := installBundle(rel, file, dst); err != nil {
+			return err
+		}
+		_ = os.Remove(dst + imageSuffix)
+	default:
+		if err := os.Rename(file, dst); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, 0o755); err != nil { // 确保可执行
+			return err
+		}
+		_ = os.Remove(dst + launchSuffix)
+		_ = os.Remove(dst + imageSuffix)
+	}
+
+	// 原子切换符号链接
+	currLink := filepath.Join(b.dir, "algo_current")
+	prev, _ := os.Readlink(currLink)
+	_ = os.Remove(currLink)
+	if err := os.Symlink(dst, currLink); err != nil {
+		return err
+	}
+
+	// 平滑重启；新版本没有运行起来时指回原来的版本
+	if err := b.restart(currLink); err != nil {
+		b.revert(currLink, prev, err)
+		return err
+	}
+	return nil
+}
+
+// revert points algo_current back at prev after restarting the new version
+// failed, and restarts prev unless an aborted handover left it running.
+func (b *binaryInstaller) revert(currLink, prev string, err error) {
+	if prev == "" {
+		return
+	}
+	_ = os.Remove(currLink)
+	if err := os.Symlink(prev, currLink); err != nil {
+		log.Printf("revert algo_current to %s: %v", prev, err)
+		return
+	}
+	if he, ok := handoverFailed(err); ok && he.running {
+		return
+	}
+	if err := b.restart(currLink); err != nil {
+		log.Printf("restart %s: %v", prev, err)
+	}
+}
+
+// Rollback points algo_current back at algo_<version>, a binary, bundle
+// directory or image archive kept from the previous install or preinstalled
+// in install_dir, and restarts it.
+func (b *binaryInstaller) Rollback(version string) error {
+	dst := filepath.Join(b.dir, "algo_"+version)
+	if _, err := os.Stat(dst); err != nil {
+		dst = filepath.Join(b.base, "algo_"+version)
+		if _, err := os.Stat(dst); err != nil {
+			return err
+		}
+	}
+	if err := ensureImage(dst); err != nil {
+		return err
+	}
+	currLink := filepath.Join(b.dir, "algo_current")
+	_ = os.Remove(currLink)
+	if err := os.Symlink(dst, currLink); err != nil {
+		return err
+	}
+	return b.restart(currLink)
+}
+
+// Prune removes algo_<version> binaries, bundles and images in state_dir
+// other than keep; install_dir is left alone.
+func (b *binaryInstaller) Prune(keep []string) {
+	matches, _ := filepath.Glob(filepath.Join(b.dir, "algo_*"))
+	for _, fp := range matches {
+		name := filepath.Base(fp)
+		if name == "algo_current" {
+			continue
+		}
+		v := strings.TrimPrefix(name, "algo_")
+		for _, suffix := range []string{launchSuffix, imageSuffix, ".tmp"} {
+			v = strings.TrimSuffix(v, suffix)
+		}
+		if contains(keep, v) {
+			continue
+		}
+		if strings.HasSuffix(name, imageSuffix) {
+			removeImage(fp)
+		}
+		if err := os.RemoveAll(fp); err != nil {
+			log.Printf("prune %s: %v", name, err)
+		}
+	}
+}
+
+// pkgTool 描述一种包管理器的命令行。
+type pkgTool struct {
+	name      string
+	ext       string
+	install   []string // 追加包文件路径
+	downgrade []string // 回滚到旧包时使用
+	field     func(file, field string) []string
+	installed func(pkg string) []string // 输出已安装版本
+}
+
+var debTool = pkgTool{
+	name:      "dpkg",
+	ext:       ".deb",
+	install:   []string{"dpkg", "-i"},
+	downgrade: []string{"dpkg", "-i"},
+	field: func(file, field string) []string {
+		return []string{"dpkg-deb", "-f", file, map[string]string{"name": "Package", "version": "Version"}[field]}
+	},
+	installed: func(pkg string) []string {
+		return []string{"dpkg-query", "-W", "-f=${Version}", pkg}
+	},
+}
+
+var rpmTool = pkgTool{
+	name:      "rpm",
+	ext:       ".rpm",
+	install:   []string{"rpm", "-U", "--replacepkgs"},
+	downgrade: []string{"rpm", "-U", "--replacepkgs", "--oldpackage"},
+	field: func(file, field string) []string {
+		qf := map[string]string{"name": "%{NAME}", "version": "%{VERSION}-%{RELEASE}"}[field]
+		return []string{"rpm", "-qp", "--qf", qf, file}
+	},
+	installed: func(pkg string) []string {
+		return []string{"rpm", "-q", "--qf", "%{VERSION}-%{RELEASE}", pkg}
+	},
+}
+
+// packageInstaller 通过包管理器安装，并在 <state_dir>/packages/ 保留槽位中各版本的包用于回滚。
+type packageInstaller struct {
+	tool    pkgTool
+	dir     string
+	pkgName string // 为空时从包文件读取
+	exec    string // 可选：安装后由 agent 拉起的程序路径
+}
+
+func newPackageInstaller(cfg *Config, tool pkgTool) (installer, error) {
+	if _, err := exec.LookPath(tool.install[0]); err != nil {
+		return nil, fmt.Errorf("install_backend %s: %s not found: %w", cfg.InstallBackend, tool.install[0], err)
+	}
+	dir := filepath.Join(cfg.StateDir, "packages")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &packageInstaller{tool: tool, dir: dir, pkgName: cfg.PackageName, exec: cfg.PackageExec}, nil
+}
+
+func (p *packageInstaller) Install(ctx context.Context, rel *Release, file string) error {
+	prev := p.previousPackage()
+
+	dst := filepath.Join(p.dir, rel.Version+p.tool.ext)
+	if err := os.Rename(file, dst); err != nil {
+		return err
+	}
+	name := p.pkgName
+	if name == "" {
+		out, err := run(p.tool.field(dst, "name"))
+		if err != nil {
+			return fmt.Errorf("read package name: %w", err)
+		}
+		name = out
+	}
+	want, err := run(p.tool.field(dst, "version"))
+	if err != nil {
+		return fmt.Errorf("read package version: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := run(append(p.tool.install, dst)); err != nil {
+		p.rollback(prev)
+		return fmt.Errorf("%s install %s: %w", p.tool.name, rel.Version, err)
+	}
+	// 以包管理器数据库为准确认安装结果
+	if got, err := run(p.tool.installed(name)); err != nil || got != want {
+		p.rollback(prev)
+		if err == nil {
+			err = fmt.Errorf("installed version %q, want %q", got, want)
+		}
+		return fmt.Errorf("verify %s: %w", name, err)
+	}
+
+	if p.exec != "" {
+		if err := restartAlgorithm(p.exec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// previousPackage 返回当前版本对应的包文件（若仍保留）。
+func (p *packageInstaller) previousPackage() string {
+	cur := readCurrentVersion()
+	if cur == "" {
+		return ""
+	}
+	fp := filepath.Join(p.dir, cur+p.tool.ext)
+	if _, err := os.Stat(fp); err != nil {
+		return ""
+	}
+	return fp
+}
+
+func (p *packageInstaller) rollback(prev string) {
+	if prev == "" {
+		log.Printf("%s: no previous package to roll back to", p.tool.name)
+		return
+	}
+	if _, err := run(append(p.tool.downgrade, prev)); err != nil {
+		log.Printf("%s: rollback to %s failed: %v", p.tool.name, filepath.Base(prev), err)
+		return
+	}
+	log.Printf("%s: rolled back to %s", p.tool.name, filepath.Base(prev))
+}
+
+// Rollback downgrades to the package kept for version and restarts the
+// algorithm when the agent runs it.
+func (p *packageInstaller) Rollback(version string) error {
+	prev := filepath.Join(p.dir, version+p.tool.ext)
+	if _, err := os.Stat(prev); err != nil {
+		return err
+	}
+	if _, err := run(append(p.tool.downgrade, prev)); err != nil {
+		return fmt.Errorf("%s downgrade to %s: %w", p.tool.name, version, err)
+	}
+	if p.exec != "" {
+		return restartAlgorithm(p.exec)
+	}
+	return nil
+}
+
+// Prune keeps only the packages of the versions in keep.
+func (p *packageInstaller) Prune(keep []string) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !contains(keep, strings.TrimSuffix(e.Name(), p.tool.ext)) {
+			_ = os.Remove(filepath.Join(p.dir, e.Name()))
+		}
+	}
+}
+
+// run executes argv and returns its trimmed stdout; stderr is folded into
+// the error so package manager failures are visible in the agent log.
+func run(argv []string) (string, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = errors.New(err.Error() + ": " + msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/von0000/dronealgo-ota/internal/bundle"
+	"github.com/von0000/dronealgo-ota/internal/launch"
+)
+
+// 多语言算法：版本带启动模板（launch，如 python3 {dir}/main.py --model {dir}/model.onnx）时，
+// 制品是 tar.gz 或 zip 包，binary 后端把它解压到 algo_<version>/ 目录，algo_current 指向该目录，
+// 模板保存在 algo_<version>.launch。启动时按模板展开变量直接执行解释器，不经过 shell，
+// agent 监管的就是算法进程本身。模板在服务端发布时与 agent 安装时各校验一次。
+// 多文件包（算法与模型权重、配置文件一起发布）在根目录带 manifest.json（入口与每个文件的 sha256，
+// 见 internal/bundle），服务端以清单的入口作为启动模板；解压后逐个校验文件，有文件不符、缺失或
+// 未列入清单时安装失败，algo_current 仍指向旧版本。
+
+// launchSuffix 是保存版本启动模板的文件后缀。
+const launchSuffix = ".launch"
+
+// installBundle extracts the tar.gz or zip artifact of a release with a
+// launch template into dst, verifies it against its manifest and saves the
+// template next to it.
+func installBundle(rel *Release, file, dst string) error {
+	t, err := launch.Parse(rel.Launch)
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	_ = os.RemoveAll(tmp)
+	if err := extractBundle(file, tmp); err != nil {
+		_ = os.RemoveAll(tmp)
+		return fmt.Errorf("extract %s: %w", rel.Version, err)
+	}
+	if err := verifyBundle(rel, tmp); err != nil {
+		_ = os.RemoveAll(tmp)
+		return fmt.Errorf("verify %s: %w", rel.Version, err)
+	}
+	if err := checkProgram(expandLaunch(t, dst)[0], dst, tmp); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.WriteFile(dst+launchSuffix, []byte(t.String()), 0o644); err != nil {
+		_ = os.RemoveAll(tmp)
+		return err
+	}
+	_ = os.RemoveAll(dst)
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	_ = os.Remove(file)
+	return nil
+}
+
+// extractBundle unpacks regular files and directories; links and entries
+// escaping dst are rejected.
+func extractBundle(file, dst string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return bundle.Extract(f, fi.Size(), dst)
+}
+
+// verifyBundle checks every file extracted into dir against the bundle's
+// manifest. Bundles without one (launch template only) were verified as a
+// whole by the artifact sha256.
+func verifyBundle(rel *Release, dir string) error {
+	m, err := bundle.ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		if rel.Package != "" {
+			return fmt.Errorf("the %s package has no %s", rel.Package, bundle.ManifestName)
+		}
+		return nil
+	}
+	return m.VerifyDir(dir)
+}
+
+// expandLaunch fills in the template variables for the version installed
+// in dir (<install_dir>/algo_<version>).
+func expandLaunch(t *launch.Template, dir string) []string {
+	return t.Expand(map[string]string{
+		"dir":         dir,
+		"version":     strings.TrimPrefix(filepath.Base(dir), "algo_"),
+		"install_dir": filepath.Dir(dir),
+	})
+}
+
+// checkProgram verifies the program of a launch template can be executed: a
+// command on PATH, or an executable path. While installing, the bundle is
+// still unpacked in tmp rather than dir.
+func checkProgram(prog, dir, tmp string) error {
+	if !strings.Contains(prog, "/") {
+		if _, err := exec.LookPath(prog); err != nil {
+			return fmt.Errorf("launch template: %w", err)
+		}
+		return nil
+	}
+	fp := prog
+	if rest, ok := strings.CutPrefix(prog, dir+"/"); ok {
+		fp = filepath.Join(tmp, rest)
+	}
+	fi, err := os.Stat(fp)
+	switch {
+	case err != nil:
+		return fmt.Errorf("launch template: %s: %w", prog, err)
+	case !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0:
+		return fmt.Errorf("launch template: %s is not an executable file", prog)
+	}
+	return nil
+}
+
+// algoArgv returns the command that starts bin: the container run command
+// when bin resolves to an image (see container.go), the expanded launch
+// template when it resolves to a bundle directory, bin itself otherwise.
+func algoArgv(bin string) ([]string, error) {
+	target, err := filepath.EvalSymlinks(bin)
+	if err != nil {
+		return nil, err
+	}
+	if argv, ok, err := imageArgv(target); ok || err != nil {
+		return argv, err
+	}
+	b, err := os.ReadFile(target + launchSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{bin}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := launch.Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", target+launchSuffix, err)
+	}
+	return expandLaunch(t, target), nil
+}
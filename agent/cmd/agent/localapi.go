@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+)
+
+// startLocalAPI 在本机地址上暴露 agent 状态，供现场技术人员与飞控检查使用。
+// 改变 agent 行为的 POST 接口拒绝带 Origin 头的请求（浏览器中的网页不能借本机地址暂停、回滚或固定版本），
+// 配置了 local_api_token 时还要求 Authorization: Bearer <token>；只读的 GET 接口不受影响。
+func startLocalAPI(addr, token string) {
+	if addr == "" {
+		return
+	}
+	mutating := func(w http.ResponseWriter, r *http.Request) bool {
+		return requirePost(w, r) && allowLocalMutation(w, r, token)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		st := ready.snapshot()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"version": readCurrentVersion(),
+			// 保留在设备上的版本槽位，最近安装的在前；回滚切回当前版本之后的那个
+			"installed_versions": slots.list(),
+			"boot":               boot.snapshot(),
+			// 进行中的更新阶段与最近一次更新结果
+			"update":      st.Update,
+			"last_update": st.LastUpdate,
+			// 最近一次检查与暂停状态
+			"control": control.status(),
+			// 算法进程的运行状况，与检查时上报给服务端的一致
+			"algorithm": algo.healthData(),
+			// apps 中其它应用的渠道、版本与最近一次检查
+			"apps": appsStatus(),
+			// 固定版本与跳过列表，及其来源（config | local_api）
+			"pins": pins.status(),
+			// 正在进行的影子部署
+			"shadow": shadow.status(),
+			// 温度与负载门控，未配置时为空
+			"throttle": gate.status(),
+			// 尚未送达服务端的上报数
+			"reports_pending": reports.pending(),
+			// 当前计费周期的流量与月度上限
+			"data_usage": dataUse.snapshot(),
+		})
+	})
+	// 起飞联锁：就绪 200，否则 503，内容与 ready_file 相同
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		st := ready.snapshot()
+		code := http.StatusOK
+		if !st.Go {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, st)
+	})
+	// 取消进行中的下载与校验；切换开始后不能再取消，但可以中止等待新进程就绪的交接（见 handover.go）
+	mux.HandleFunc("/update/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(w, r) {
+			return
+		}
+		v := inflight.abort()
+		if v == "" {
+			v = sup.abortHandover()
+		}
+		if v == "" {
+			writeJSON(w, http.StatusConflict, map[string]any{"error": "no cancellable update in progress"})
+			return
+		}
+		log.Printf("update to %s cancelled via local API", v)
+		writeJSON(w, http.StatusOK, map[string]any{"cancelled": v})
+	})
+	// 暂停更新（?reason= 记录原因），重启后仍然有效；进行中的更新不受影响
+	mux.HandleFunc("/update/pause", func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(w, r) {
+			return
+		}
+		p := &pauseState{Since: clk.Now(), Reason: r.URL.Query().Get("reason")}
+		if err := control.setPaused(p); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		log.Printf("updates paused via local API: %s", p.Reason)
+		writeJSON(w, http.StatusOK, map[string]any{"paused": p})
+	})
+	mux.HandleFunc("/update/resume", func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(w, r) {
+			return
+		}
+		if err := control.setPaused(nil); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		log.Printf("updates resumed via local API")
+		writeJSON(w, http.StatusOK, map[string]any{"paused": nil})
+	})
+	// 固定版本（?version=）与跳过列表，写入 version_pins.json 覆盖配置文件，之后立即检查一次（见 pins.go）
+	mux.HandleFunc("/update/pin", func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("version")
+		if !mutating(w, r) {
+			return
+		}
+		if v == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "version is required"})
+			return
+		}
+		setPins(w, "pinned to "+v, func(p *versionPins) { p.Pinned = v })
+	})
+	mux.HandleFunc("/update/unpin", func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(w, r) {
+			return
+		}
+		setPins(w, "unpinned", func(p *versionPins) { p.Pinned = "" })
+	})
+	mux.HandleFunc("/update/skip", func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("version")
+		if !mutating(w, r) {
+			return
+		}
+		if v == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "version is required"})
+			return
+		}
+		setPins(w, "skipping "+v, func(p *versionPins) {
+			if !slices.Contains(p.Skip, v) {
+				p.Skip = append(p.Skip, v)
+			}
+		})
+	})
+	mux.HandleFunc("/update/unskip", func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("version")
+		if !mutating(w, r) {
+			return
+		}
+		setPins(w, "no longer skipping "+v, func(p *versionPins) {
+			p.Skip = slices.DeleteFunc(p.Skip, func(x string) bool { return x == v })
+		})
+	})
+	// 立即检查，不等下一个检查间隔；结果见 /status 的 control.last_check
+	mux.HandleFunc("/update/check", func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(w, r) {
+			return
+		}
+		control.checkNow()
+		writeJSON(w, http.StatusAccepted, map[string]any{"check": "requested"})
+	})
+	// 强制回滚到上一次成功更新前的版本；更新进行中时 409
+	mux.HandleFunc("/update/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(w, r) {
+			return
+		}
+		from, to, err := control.rollBack()
+		switch {
+		case err != nil && to == "": // 更新进行中，或没有可回滚的版本
+			writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error(), "from": from, "to": to})
+		default:
+			writeJSON(w, http.StatusOK, map[string]any{"from": from, "to": to})
+		}
+	})
+	// 启动自检结果；?refresh=1 立即重新检查
+	mux.HandleFunc("/doctor", func(w http.ResponseWriter, r *http.Request) {
+		preflight.mu.Lock()
+		cfg, rep := preflight.cfg, preflight.last
+		preflight.mu.Unlock()
+		if cfg != nil && r.URL.Query().Get("refresh") == "1" {
+			fresh := runDiagnostics(cfg, nil)
+			rep = &fresh
+		}
+		if rep == nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{"error": "diagnostics still running"})
+			return
+		}
+		writeJSON(w, http.StatusOK, rep)
+	})
+	go func() {
+		log.Printf("local api listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("local api: %v", err)
+		}
+	}()
+}
+
+// setPins applies a pin change requested through the local API and checks
+// right away so it takes effect.
+func setPins(w http.ResponseWriter, what string, fn func(*versionPins)) {
+	p, err := pins.update(fn)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	log.Printf("version pins via local API: %s", what)
+	control.checkNow()
+	writeJSON(w, http.StatusOK, map[string]any{"pinned_version": p.Pinned, "skip_versions": p.Skip})
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{"error": "use POST"})
+		return false
+	}
+	return true
+}
+
+// allowLocalMutation rejects browser requests, which always carry an Origin
+// header on POST, and requests without the configured token.
+func allowLocalMutation(w http.ResponseWriter, r *http.Request, token string) bool {
+	if r.Header.Get("Origin") != "" {
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": "cross-origin requests are not allowed"})
+		return false
+	}
+	if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "missing or wrong local API token"})
+		return false
+	}
+	return true
+}
+
+// loopbackAddr reports whether a listen address only accepts local
+// connections.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowLocalMutation(t *testing.T) {
+	for _, tc := range []struct {
+		name, token, origin, auth string
+		want                      int
+	}{
+		{"no token", "", "", "", http.StatusOK},
+		{"browser", "", "http://evil.example", "", http.StatusForbidden},
+		{"browser with token", "s3cret", "http://evil.example", "Bearer s3cret", http.StatusForbidden},
+		{"token", "s3cret", "", "Bearer s3cret", http.StatusOK},
+		{"missing token", "s3cret", "", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "", "Bearer guess", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/update/pause", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.auth != "" {
+				r.Header.Set("Authorization", tc.auth)
+			}
+			w := httptest.NewRecorder()
+			ok := allowLocalMutation(w, r, tc.token)
+			if ok != (tc.want == http.StatusOK) || (!ok && w.Code != tc.want) {
+				t.Fatalf("allowed %v with status %d, want %d", ok, w.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:7080": true,
+		"[::1]:7080":     true,
+		"localhost:7080": true,
+		"0.0.0.0:7080":   false,
+		":7080":          false,
+		"10.0.0.5:7080":  false,
+		"127.0.0.1":      false,
+	} {
+		if got := loopbackAddr(addr); got != want {
+			t.Errorf("loopbackAddr(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
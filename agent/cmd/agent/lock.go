@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// daemonEnv 标记当前进程已是 -daemon 拉起的后台子进程，避免重复 re-exec。
+const daemonEnv = "DRONEALGO_AGENT_DAEMONIZED"
+
+// errLocked 表示安装目录上的锁已被另一个进程持有。
+var errLocked = errors.New("locked")
+
+// installLock 持有安装目录上的排他 flock，进程生命周期内不释放。
+type installLock struct {
+	f       *os.File
+	pidFile string
+}
+
+// acquireInstallLock takes an exclusive, non-blocking flock on the install dir
+// so two agents can never race on algo_current, then records our PID.
+func acquireInstallLock(installDir, pidFile string) (*installLock, error) {
+	fp := filepath.Join(installDir, ".agent.lock")
+	f, err := os.OpenFile(fp, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		if errors.Is(err, errLocked) {
+			msg := "another agent is managing " + installDir
+			if pid := readPIDFile(pidFile); pid > 0 {
+				msg += " (pid=" + strconv.Itoa(pid) + ")"
+			}
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	// 锁是权威的：持锁后直接覆盖可能残留的旧 PID 文件
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &installLock{f: f, pidFile: pidFile}, nil
+}
+
+func (l *installLock) Release() {
+	if l == nil {
+		return
+	}
+	_ = os.Remove(l.pidFile)
+	unlockFile(l.f)
+	_ = l.f.Close()
+}
+
+func readPIDFile(fp string) int {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+	return pid
+}
+
+// daemonize re-executes the agent detached from the terminal in its own
+// session, with output appended to logFile, and returns the child's PID.
+func daemonize(logFile string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = detachedAttr()
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
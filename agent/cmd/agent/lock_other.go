@@ -0,0 +1,20 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile 在非 Unix 平台上没有 flock，不加锁：只写 PID 文件，不能阻止两个 agent 管理同一个安装目录。
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) {}
+
+// detachedAttr 在非 Unix 平台上没有会话，-daemon 拉起的子进程只是不再等待。
+func detachedAttr() *syscall.SysProcAttr {
+	return nil
+}
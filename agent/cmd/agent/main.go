@@ -1,234 +1,867 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"math/rand/v2"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/clock"
+	"github.com/von0000/dronealgo-ota/internal/launch"
 )
 
 type Config struct {
 	ServerURL  string `json:"server_url"`
 	DeviceID   string `json:"device_id"`
 	Channel    string `json:"channel"`
+	Alias      string `json:"alias"` // 可选：订阅的版本别名（如 stable-eu），主应用跟随它指向的版本而不是渠道最新版本
 	InstallDir string `json:"install_dir"`
+	// state_dir 是 agent 写入状态与新版本的目录，缺省为 install_dir；根文件系统只读时指向可写分区，见 statedir.go。
+	StateDir   string `json:"state_dir"`
 	CheckEvery int    `json:"check_every_seconds"`
+	CAFile     string `json:"ca_file"` // 可选：服务端 CA 证书（PEM），缺省使用构建时内嵌 CA
+	// check_jitter_percent 让每次的检查间隔在 check_every_seconds 上下随机浮动（缺省 ±10%，最多 ±50%，负数关闭），
+	// 同时开机或同时恢复联网的机队不会在同一时刻检查。
+	CheckJitter int `json:"check_jitter_percent"`
+
+	// auth_token 是服务端 API 令牌（服务端配置了 -auth-tokens 时需要），也可通过环境变量 OTA_AUTH_TOKEN 提供。
+	AuthToken string `json:"auth_token"`
+	// 双向 TLS：设备证书与私钥（PEM），服务端配置了 -client-ca 时以证书识别设备，device_id 须与证书身份一致。
+	ClientCert string `json:"client_cert"`
+	ClientKey  string `json:"client_key"`
+
+	// DNS 容灾：解析结果缓存 dns_cache_ttl_seconds 秒，解析失败回退到最近一次成功的地址；
+	// server_ip 直接指定拨号地址（URL 中的主机名仍用于 Host/SNI），tls_server_name 覆盖 SNI。
+	DNSCacheTTL   int    `json:"dns_cache_ttl_seconds"`
+	ServerIP      string `json:"server_ip"`
+	TLSServerName string `json:"tls_server_name"`
+	IPFamily      string `json:"ip_family"` // auto（默认，IPv6 优先 + Happy Eyeballs）| ipv4 | ipv6
+
+	// 更新来源：server（默认）| oci；oci 时从 oci_repository 的 <channel> tag 拉取，
+	// 口令也可通过环境变量 OCI_PASSWORD 提供。
+	Source        string `json:"source"`
+	OCIRepository string `json:"oci_repository"`
+	OCIUsername   string `json:"oci_username"`
+	OCIPassword   string `json:"oci_password"`
+	// 离线安装：sideload_dir 中有更新的 .ota 包时从包安装（U 盘等，见 sideload.go）。
+	SideloadDir string `json:"sideload_dir"`
+
+	// 安装后端：binary（默认）| deb | rpm；包管理器后端可指定包名（缺省从包文件读取）
+	// 与安装后需要由 agent 拉起的程序（缺省由包自带的服务管理）。
+	InstallBackend string `json:"install_backend"`
+	PackageName    string `json:"package_name"`
+	PackageExec    string `json:"package_exec"`
+
+	// cosign 校验：cosign_public_key（密钥签名）或 cosign_roots（keyless，Fulcio 根证书），
+	// 可再限定证书身份与 OIDC issuer；配置 rekor_public_key 时还要求有效的透明日志条目。
+	// require_cosign 时拒绝没有签名包的版本。
+	CosignPublicKey string `json:"cosign_public_key"`
+	CosignRoots     string `json:"cosign_roots"`
+	CosignIdentity  string `json:"cosign_identity"`
+	CosignIssuer    string `json:"cosign_issuer"`
+	RekorPublicKey  string `json:"rekor_public_key"`
+	RequireCosign   bool   `json:"require_cosign"`
+
+	// 透明日志：log_public_key（base64 ed25519 公钥）非空时，安装前要求版本已记入服务端透明日志，
+	// 且日志是本机上次所见树头的延续。
+	LogPublicKey string `json:"log_public_key"`
+
+	// artifact_public_key（base64 ed25519 公钥）非空时只安装服务端用对应私钥签名的制品，见 artifactsig.go。
+	ArtifactPublicKey string `json:"artifact_public_key"`
+
+	// 上报队列：安装结果、心跳与崩溃事件离线时缓存在本地，最多 report_queue_max 条（默认 500）；
+	// heartbeat_every_seconds 默认 60，小于 0 关闭心跳。遥测每 telemetry_upload_seconds（默认 60）
+	// 合并上传一次，telemetry_compression 为 gzip（默认）或 none。
+	ReportQueueMax       int    `json:"report_queue_max"`
+	HeartbeatEvery       int    `json:"heartbeat_every_seconds"`
+	TelemetryEvery       int    `json:"telemetry_upload_seconds"`
+	TelemetryCompression string `json:"telemetry_compression"`
+
+	// 遥测隐私（见 telemetry.go）：telemetry_fields 开启 hostname / ip / gps，gps 取自 gps_file，
+	// gps_decimals 限制坐标小数位；telemetry_sampling 按事件类型采样，如 {"heartbeat": 0.1}。
+	TelemetryFields   []string           `json:"telemetry_fields"`
+	TelemetrySampling map[string]float64 `json:"telemetry_sampling"`
+	GPSFile           string             `json:"gps_file"`
+	GPSDecimals       *int               `json:"gps_decimals"`
+
+	// max_safety_impact 是自动安装允许的最高安全影响等级（none | low | high | experimental），
+	// 缺省时 beta 渠道为 experimental，其它渠道为 high——experimental 版本不会在 beta 机队之外自动安装。
+	MaxSafetyImpact string `json:"max_safety_impact"`
+	// 版本固定与跳过（只作用于主应用，本地 API 可以修改，见 pins.go）：pinned_version 把设备固定在一个版本，
+	// skip_versions 中的版本不安装。
+	PinnedVersion string   `json:"pinned_version"`
+	SkipVersions  []string `json:"skip_versions"`
+
+	// 未配置 device_id 时按 device_id_sources 的顺序（默认 cpu-serial、mac、machine-id）派生稳定 ID，
+	// 写入 <install_dir>/derived_device_id 并在首次派生时向服务端登记。
+	DeviceIDSources []string `json:"device_id_sources"`
+
+	// region / site 随检查上报，服务端据此给出就近的制品镜像；未配置时服务端按来源 IP 推断。
+	Region string `json:"region"`
+	Site   string `json:"site"`
+
+	// locale（如 zh-CN）随检查上报，服务端按它选择检查响应中 message 的语言，供地面站界面显示。
+	Locale string `json:"locale"`
+
+	// 温度与负载门控（见 throttle.go）：板温或每核负载超过阈值时推迟校验、安装等 CPU 密集操作。
+	Throttle ThrottleConfig `json:"throttle"`
+	// 电量门控（见 power.go）：电量低于门限时不开始安装，强制版本除外。
+	Power PowerConfig `json:"power"`
+	// 飞行状态门控（见 updategate.go）：下载随时进行，安装与重启等到可以安全更新时。
+	UpdateGate UpdateGateConfig `json:"update_gate"`
+	// 下载限速（kbit/s，0 不限速）与链路空闲门控（见 bandwidth.go）：下载不挤占遥测与图传链路。
+	MaxDownloadKbps int              `json:"max_download_kbps"`
+	DownloadGate    UpdateGateConfig `json:"download_gate"`
+	// 月度流量上限（MiB，0 不限）与计费周期起始日（见 datausage.go）：达到上限后只下载强制版本。
+	MonthlyDataCapMB int `json:"monthly_data_cap_mb"`
+	DataCapResetDay  int `json:"data_cap_reset_day"`
+	// 容器运行时与资源限制（见 container.go）：配置后可安装以容器镜像发布的版本。
+	Container ContainerConfig `json:"container"`
+
+	// ready_file 是供起飞前检查读取的就绪状态文件，缺省 <install_dir>/ready.json，见 ready.go。
+	ReadyFile string `json:"ready_file"`
+	// shutdown_policy：agent 收到 SIGINT / SIGTERM 时 detach（默认，算法继续运行）或 stop（先停止算法），见 detach.go。
+	ShutdownPolicy string `json:"shutdown_policy"`
+	// 更新后健康检查：不通过时自动回滚到上一个版本，见 healthcheck.go。
+	HealthCheck HealthCheckConfig `json:"health_check"`
+	// 算法意外退出后按退避自动重启，崩溃循环时回滚到上一个版本，见 restart.go。
+	Restart RestartConfig `json:"restart"`
+	// 更新与回滚时新进程就绪后再停止旧进程，见 handover.go。
+	Handover HandoverConfig `json:"handover"`
+	// keep_versions 是设备上保留的已安装版本数（默认 2：当前与上一个），回滚不重新下载，见 slots.go。
+	KeepVersions int `json:"keep_versions"`
+	// scratch_max_mb 是每个版本暂存目录的大小上限（默认 512），见 scratch.go。
+	ScratchMaxMB int `json:"scratch_max_mb"`
+	// min_free_mb 是下载与安装之后至少保留的空闲空间（默认 64），不足时推迟更新，见 diskspace.go。
+	MinFreeMB int `json:"min_free_mb"`
+	// algo_log 把算法输出写入轮转的日志文件，可上传到服务端，见 algolog.go。
+	AlgoLog AlgoLogConfig `json:"algo_log"`
+
+	LocalAPIAddr string `json:"local_api_addr"` // 本地控制 API，置空关闭
+	// local_api_token 非空时本地 API 的 POST 接口要求 Authorization: Bearer <token>，也可通过环境变量
+	// OTA_LOCAL_API_TOKEN 提供；local_api_addr 不是回环地址时必须配置。
+	LocalAPIToken string     `json:"local_api_token"`
+	MetricsAddr   string     `json:"metrics_addr"` // Prometheus 指标（GET /metrics），置空关闭，见 metrics.go
+	Boot          BootConfig `json:"boot"`
+
+	// self_update 开启 agent 自身的更新（服务端 app=agent 组件），见 selfupdate.go。
+	SelfUpdate SelfUpdateConfig `json:"self_update"`
+
+	// apps 是同一设备上由本 agent 管理的其它算法（如跟踪、降落），顶层配置即主应用，见 apps.go。
+	Apps []AppConfig `json:"apps"`
+	// app 是应用名，只在 apps 派生的配置副本中非空。
+	app string
 }
 
 type Release struct {
 	Version string `json:"version"`
+	App     string `json:"app"` // 所属应用，主应用为空（见 apps.go）
 	Channel string `json:"channel"`
 	URL     string `json:"url"`
 	Sha256  string `json:"sha256"`
 	Notes   string `json:"notes"`
+	Format  string `json:"format"` // binary | deb | rpm
+
+	CosignBundle json.RawMessage `json:"cosign_bundle,omitempty"`
+	Signature    string          `json:"signature"` // base64，服务端对应用、版本与 sha256 的 ed25519 分离签名
+	KeyID        string          `json:"key_id"`
+	ShadowArgs   []string        `json:"shadow_args"`   // 影子运行时追加的参数（见 shadow.go）
+	Launch       string          `json:"launch"`        // 启动模板，非空时制品是 tar.gz 或 zip 包（见 launch.go）
+	Package      string          `json:"package"`       // tar.gz | zip：带 manifest.json 的多文件包，旧服务端与 OCI 来源为空
+	ArtifactType string          `json:"artifact_type"` // oci 时制品是容器镜像归档（见 container.go），二进制为空
+	ImageID      string          `json:"image_id"`      // 容器镜像的 config 摘要，OCI 来源为空
+
+	// mirrors 是检查响应给出的下载地址（就近优先），fetchedFrom 是实际下载所用的主机。
+	mirrors     []string
+	fetchedFrom string
+	// size 是检查时已知的制品大小（OCI 层描述符），0 为未知，见 diskspace.go。
+	size int64
+	// mandatory 取自检查响应，强制版本不受月度流量上限限制，见 datausage.go。
+	mandatory bool
+	// sideload 是离线安装包的路径，sideloadProof 是包内的透明日志证明，见 sideload.go。
+	sideload      string
+	sideloadProof []byte
 }
 
 type CheckResp struct {
 	UpdateAvailable bool     `json:"update_available"`
 	Latest          *Release `json:"latest"`
 	Message         string   `json:"message"`
+	Safety          string   `json:"safety"` // 安全影响：none | low | high | experimental，旧服务端为空
+	Breaking        bool     `json:"breaking"`
+	Approval        string   `json:"approval"`  // 设备组策略要求人工批准时为 pending | rejected
+	Mandatory       bool     `json:"mandatory"` // 强制版本不受电量门限限制
+	DownloadURLs    []string `json:"download_urls"`
+	// CollectDiagnostics 由服务端在算法运行异常时置位，要求 agent 上报一次自检结果。
+	CollectDiagnostics bool `json:"collect_diagnostics"`
+	// Pinned 表示 Latest 是要求安装的固定版本（可能比当前版本旧）：服务端二分定位回归时 bisect_id 非空，
+	// 否则是本机的 pinned_version（见 pins.go）。
+	Pinned *CheckPin `json:"pinned"`
+	// Shadow 是设备参与的影子部署，不参与时为空。
+	Shadow *ShadowAssignment `json:"shadow"`
+	// Rollback 表示服务端召回了当前版本，要求切回上一个版本槽位。
+	Rollback bool `json:"rollback"`
+	// Window 表示设备当地时间不在维护时段内，新版本延后到 next_open 下发。
+	Window *struct {
+		NextOpen time.Time `json:"next_open"`
+	} `json:"window"`
+	// Freeze 表示服务端冻结了更新（监管审计、公开活动等），ends_at 前不下发非强制版本。
+	Freeze *FreezeNotice `json:"freeze"`
+}
+
+// CheckPin 是检查响应中的固定版本。
+type CheckPin struct {
+	BisectID string `json:"bisect_id"`
+	Version  string `json:"version"`
 }
 
 var (
-	currentCmd   *exec.Cmd
-	currentVerFP string
+	inst installer
+	src  updateSource
+)
+
+// clk 驱动轮询、启动等待与 DNS 缓存过期等所有计时行为；仿真时可加速。
+var clk = clock.System()
+
+var (
+	daemonMode = flag.Bool("daemon", false, "detach and run in the background")
+	foreground = flag.Bool("foreground", false, "stay in the foreground (default; overrides -daemon)")
+	pidFile    = flag.String("pidfile", "", "PID file path, default: <state_dir>/agent.pid")
+	logFile    = flag.String("logfile", "", "log file in daemon mode, default: <state_dir>/agent.log")
+	timeScale  = flag.Float64("time-scale", 1, "run the agent clock this many times faster than real time (fleet simulation)")
+	showVer    = flag.Bool("version", false, "print the agent version and exit")
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatalf("Usage: %s /path/to/config.json", os.Args[0])
+	flag.Parse()
+	if *showVer {
+		fmt.Println(agentVersion)
+		return
+	}
+	clk = clock.Scaled(*timeScale)
+	switch flag.Arg(0) {
+	case "doctor":
+		os.Exit(doctorMain(flag.Args()[1:]))
+	case "migrate":
+		os.Exit(migrateMain(flag.Args()[1:]))
 	}
-	cfg, err := loadConfig(os.Args[1])
+
+	// 配置文件可选：缺省时完全使用构建时注入的默认值
+	cfgPath := flag.Arg(0)
+	cfg, err := loadConfig(cfgPath)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if cfg.CheckEvery <= 0 {
-		cfg.CheckEvery = 10
+	if err := setup(cfg); err != nil {
+		log.Fatal(err)
 	}
-	if err := os.MkdirAll(cfg.InstallDir, 0o755); err != nil {
+	loadAssignedDeviceID(cfg)
+	registerID, err := deriveDeviceID(cfg)
+	if err != nil {
 		log.Fatal(err)
 	}
-	currentVerFP = filepath.Join(cfg.InstallDir, "current_version")
+	if *pidFile == "" {
+		*pidFile = filepath.Join(cfg.StateDir, "agent.pid")
+	}
+
+	if *daemonMode && !*foreground && os.Getenv(daemonEnv) == "" {
+		// 先试探一次锁，避免后台子进程启动后才发现冲突
+		lk, err := acquireInstallLock(cfg.StateDir, *pidFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		lk.Release()
+		if *logFile == "" {
+			*logFile = filepath.Join(cfg.StateDir, "agent.log")
+		}
+		pid, err := daemonize(*logFile)
+		if err != nil {
+			log.Fatalf("daemonize: %v", err)
+		}
+		log.Printf("agent running in background (pid=%d, log=%s)", pid, *logFile)
+		return
+	}
 
-	// 启动已有版本（若存在）
-	currLink := filepath.Join(cfg.InstallDir, "algo_current")
-	if _, err := os.Stat(currLink); err == nil {
-		if err := startAlgorithm(currLink); err != nil {
+	lock, err := acquireInstallLock(cfg.StateDir, *pidFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer lock.Release()
+	// 使用自更新安装的 agent 或回滚未能启动的新 agent，可能 exec 到另一版本
+	selfUpd.boot()
+
+	// 队列文件位于 install_dir，须在持有安装锁后打开
+	if reports, err = newReportQueue(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if registerID != nil {
+		reports.enqueue(*registerID)
+	}
+	go reports.run()
+	algoLog.setTarget(cfg.ServerURL, cfg.DeviceID)
+	go algoLog.run()
+	go sup.run()
+	ready.refresh()
+	go usage.run()
+	go scratch.run()
+
+	startLocalAPI(cfg.LocalAPIAddr, cfg.LocalAPIToken)
+	startMetrics(cfg.MetricsAddr)
+	// 自检包含网络探测，不阻塞启动
+	go runPreflight(cfg)
+
+	// 启动已有版本（若存在），可选等待飞控链路就绪
+	currLink := overlayFile(cfg, "algo_current")
+	if backendName(cfg) != backendBinary {
+		// 包管理器后端：仅在配置了 package_exec 时由 agent 拉起程序
+		currLink = cfg.PackageExec
+	}
+	boot.waitFor(phaseWaitingFCLink, cfg.Boot.WaitFCLinkSeconds, fcLinkReady(cfg.Boot))
+	if _, err := os.Stat(currLink); currLink != "" && err == nil {
+		if err := scratch.prepare(readCurrentVersion()); err != nil {
+			log.Printf("scratch: %v", err)
+		}
+		if err := sup.startOrAdopt(currLink); err != nil {
 			log.Printf("start current algo failed: %v", err)
 		}
 	} else {
+		sup.stopDetached()
 		log.Printf("no current algo yet, waiting for first update...")
 	}
+	startApps()
+
+	// 首次检查前等待网络与时钟同步，避免冷启动时与系统服务竞争
+	boot.waitFor(phaseWaitingNetwork, cfg.Boot.WaitNetworkSeconds, networkReady(src.ProbeURL()))
+	boot.waitFor(phaseWaitingNTP, cfg.Boot.WaitNTPSeconds, clockSynced)
+	boot.setPhase(phaseRunning)
+	ready.refresh()
 
-	ticker := time.NewTicker(time.Duration(cfg.CheckEvery) * time.Second)
+	// SIGINT / SIGTERM 取消进行中的更新并退出主循环；再收到一次时按默认行为立即退出
+	ctx, stop := context.WithCancelCause(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		log.Printf("received %v", sig)
+		stop(fmt.Errorf("agent received %v", sig))
+	}()
+
+	// SIGHUP 或配置文件变化时在两次检查之间热加载部分配置（见 reload.go）
+	cfgWatch := watchConfig(cfgPath)
+	ticker := clk.NewTicker(checkInterval(cfg))
 	defer ticker.Stop()
 
+	selfUpd.confirm()
+	var lastHeartbeat time.Time
 	for {
-		if err := runOnce(cfg, readCurrentVersion()); err != nil {
+		control.busy.Lock()
+		if err := runOnce(ctx, cfg, readCurrentVersion()); err != nil {
 			log.Printf("check/update error: %v", err)
 		}
-		<-ticker.C
+		control.busy.Unlock()
+		runApps(ctx, cfg)
+		control.busy.Lock()
+		selfUpd.run(ctx, cfg)
+		control.busy.Unlock()
+		ready.refresh()
+		if cfg.HeartbeatEvery > 0 && clk.Since(lastHeartbeat) >= time.Duration(cfg.HeartbeatEvery)*time.Second {
+			lastHeartbeat = clk.Now()
+			data := telemetryData(cfg)
+			if res := usage.take(); res != nil {
+				if data == nil {
+					data = map[string]any{}
+				}
+				data["resources"] = res
+			}
+			if st := scratch.stats(); st != nil {
+				if data == nil {
+					data = map[string]any{}
+				}
+				data["scratch"] = st
+			}
+			if v := appVersions(); v != nil {
+				if data == nil {
+					data = map[string]any{}
+				}
+				data["apps"] = v
+			}
+			if data == nil {
+				data = map[string]any{}
+			}
+			data["data_usage"] = dataUse.snapshot()
+			dataUse.flush()
+			reports.enqueue(queuedEvent{Type: "heartbeat", Channel: cfg.Channel, Version: readCurrentVersion(), Data: data})
+		}
+		select {
+		case <-ctx.Done():
+			shutdown(cfg)
+			dataUse.flush()
+			ready.stop()
+			log.Printf("agent stopped")
+			return
+		case <-ticker.C():
+			ticker.Reset(checkInterval(cfg))
+		case <-control.wake:
+			log.Printf("check requested via the local API")
+		case <-cfgWatch.reload:
+			control.busy.Lock()
+			if cfgWatch.apply(cfg) {
+				ticker.Reset(checkInterval(cfg))
+			}
+			control.busy.Unlock()
+		}
 	}
 }
 
-func runOnce(cfg *Config, current string) error {
-	u := cfg.ServerURL + "/check?channel=" + cfg.Channel + "&current=" + current + "&device_id=" + cfg.DeviceID
-	resp, err := http.Get(u)
+// defaultCheckJitter 是检查间隔缺省的随机浮动比例（百分比）。
+const defaultCheckJitter = 10
+
+// checkJitter defaults check_jitter_percent and rejects a spread that could
+// make the interval vanish.
+func checkJitter(cfg *Config) error {
+	if cfg.CheckJitter == 0 {
+		cfg.CheckJitter = defaultCheckJitter
+	}
+	if cfg.CheckJitter > 50 {
+		return fmt.Errorf("check_jitter_percent %d: at most 50", cfg.CheckJitter)
+	}
+	return nil
+}
+
+// checkInterval returns the wait before the next check: check_every_seconds
+// moved by a random amount within check_jitter_percent.
+func checkInterval(cfg *Config) time.Duration {
+	d := time.Duration(cfg.CheckEvery) * time.Second
+	if cfg.CheckJitter <= 0 {
+		return d
+	}
+	spread := d * time.Duration(cfg.CheckJitter) / 100
+	return d - spread + time.Duration(rand.Int64N(int64(2*spread)+1))
+}
+
+func runOnce(parent context.Context, cfg *Config, current string) (err error) {
+	ctx, done := inflight.begin(parent)
+	defer done()
+	ck, err := src.Check(ctx, current)
+	control.checked(ck, err)
+	metrics.checked("", err)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		b, _ := io.ReadAll(resp.Body)
-		return errors.New("check failed: " + string(b))
+	// 检查成功说明网络已恢复，立即发送积压的上报
+	reports.online()
+	if ck.CollectDiagnostics {
+		go reportDiagnostics(cfg)
 	}
-	var ck CheckResp
-	if err := json.NewDecoder(resp.Body).Decode(&ck); err != nil {
+	shadow.update(ctx, cfg, ck.Shadow)
+	if ck.Rollback {
+		// 服务端召回了当前版本：切回上一个槽位，不下载；之后的检查再照常比较
+		log.Printf("%s was recalled: %s", current, ck.Message)
+		_, _, err := control.switchBack(rollbackRecall)
 		return err
 	}
+	if (ck.Approval != "" || ck.Window != nil || ck.Freeze != nil) && ck.Latest != nil {
+		log.Printf("%s withheld: %s", ck.Latest.Version, ck.Message)
+		return nil
+	}
 	if !ck.UpdateAvailable || ck.Latest == nil {
 		log.Printf("no update. current=%s", current)
 		return nil
 	}
 	log.Printf("new version: %s (%s)", ck.Latest.Version, ck.Latest.Channel)
-
-	// 下载到临时文件
-	downloadURL := cfg.ServerURL + ck.Latest.URL
-	tmpFile := filepath.Join(cfg.InstallDir, "download_"+ck.Latest.Version)
-	if err := downloadToFile(downloadURL, tmpFile); err != nil {
+	ck.Latest.mandatory = ck.Mandatory
+	if reason := control.paused(); reason != "" {
+		log.Printf("deferring %s: %s", ck.Latest.Version, reason)
+		reportDeferral(current, ck.Latest, "paused", reason)
+		return nil
+	}
+	bisecting := ck.Pinned != nil && ck.Pinned.BisectID != ""
+	if !bisecting {
+		// 本机的固定版本与跳过列表，二分定位指定的版本除外（见 pins.go）
+		if pin := pins.pinned(); pin != "" && ck.Latest.Version != pin {
+			log.Printf("holding %s: pinned to %s", ck.Latest.Version, pin)
+			return nil
+		}
+		if pins.skipped(ck.Latest.Version) {
+			log.Printf("skipping %s: listed in skip_versions", ck.Latest.Version)
+			return nil
+		}
+		if bad.has(ck.Latest.Version) {
+			log.Printf("skipping %s: it was rolled back or its handover failed", ck.Latest.Version)
+			return nil
+		}
+	}
+	if ck.Pinned != nil {
+		// 操作员明确指定了版本（二分定位或 pinned_version），不受安全影响门控
+		if bisecting {
+			log.Printf("pinned to %s by bisection %s", ck.Pinned.Version, ck.Pinned.BisectID)
+		} else {
+			log.Printf("pinned to %s by pinned_version", ck.Pinned.Version)
+		}
+	} else if !safetyAllowed(cfg, ck.Safety) {
+		log.Printf("holding %s: safety impact %s exceeds max_safety_impact %s", ck.Latest.Version, ck.Safety, maxSafety(cfg))
+		return nil
+	}
+	if ck.Breaking {
+		log.Printf("%s contains breaking changes", ck.Latest.Version)
+	}
+	// 过热或负载过高时整次推迟，不下载，下一次检查再试
+	if reason := gate.busy(); reason != "" {
+		log.Printf("deferring %s: %s", ck.Latest.Version, reason)
+		return nil
+	}
+	if reason := batteryHold(cfg, ck.Mandatory); reason != "" {
+		log.Printf("deferring %s: %s", ck.Latest.Version, reason)
+		reportDeferral(current, ck.Latest, "battery", reason)
+		return nil
+	}
+	inflight.target(ck.Latest.Version)
+	ready.setPhase(updateDownloading, ck.Latest.Version)
+	timer := newPhaseTimer()
+	defer reportInstall(current, ck.Latest, timer, &err)
+	if f := releaseFormat(ck.Latest); f != backendName(cfg) {
+		return fmt.Errorf("release %s is a %s artifact, install backend is %s", ck.Latest.Version, f, backendName(cfg))
+	}
+	if ck.Latest.Launch != "" {
+		if _, err := launch.Parse(ck.Latest.Launch); err != nil {
+			return fmt.Errorf("release %s: %w", ck.Latest.Version, err)
+		}
+	}
+	if err := checkImageRelease(ck.Latest); err != nil {
+		return err
+	}
+	// 制品签名覆盖 sha256 摘要，下载前即可拒绝未签名或签名无效的版本
+	if err := verifyArtifactSignature(ck.Latest); err != nil {
 		return err
 	}
 
+	// 下载到临时文件；安装成功时它已被移走，失败或取消时删除。未下载完的部分保留到下次检查续传（见 download.go）
+	tmpFile := filepath.Join(cfg.StateDir, "download_"+ck.Latest.Version)
+	defer func() { _ = os.Remove(tmpFile) }()
+	prunePartials(tmpFile)
+	staged := tmpFile + stagedSuffix
+	if _, serr := os.Stat(staged); serr == nil {
+		// 已下载并校验、等待更新门控的制品：门控仍关闭时不重复校验，打开后照常校验再安装
+		if reason := gateHold(); reason != "" {
+			timer.deferred = true
+			return nil
+		}
+		log.Printf("using %s downloaded before the install was deferred", ck.Latest.Version)
+		if err := os.Rename(staged, tmpFile); err != nil {
+			return err
+		}
+	} else if reuseArtifact(ctx, cfg, ck.Latest, tmpFile) {
+		// 设备上已有相同 sha256 的制品，不下载（见 artifactcache.go）
+	} else if reason := downloadHold(); reason != "" && ck.Latest.sideload == "" {
+		// 链路不空闲时不下载（离线安装包不经网络），下一次检查再试
+		log.Printf("deferring download of %s: %s", ck.Latest.Version, reason)
+		timer.deferred = true
+		reportDeferral(current, ck.Latest, "download_gate", reason)
+		return nil
+	} else if reason := dataHold(ck.Latest); reason != "" && ck.Latest.sideload == "" {
+		// 当月流量已用完：只下载强制版本，下个计费周期再试
+		log.Printf("deferring download of %s: %s", ck.Latest.Version, reason)
+		timer.deferred = true
+		reportDeferral(current, ck.Latest, "data_cap", reason)
+		return nil
+	} else if err := src.Fetch(ctx, ck.Latest, tmpFile); err != nil {
+		if reason, ok := downloadHeld(err); ok {
+			log.Printf("stopped downloading %s, resuming later: %s", ck.Latest.Version, reason)
+			timer.deferred = true
+			reportDeferral(current, ck.Latest, "download_gate", reason)
+			return nil
+		}
+		if reason, ok := diskShort(err); ok {
+			log.Printf("deferring download of %s: %s", ck.Latest.Version, reason)
+			timer.deferred = true
+			reportDeferral(current, ck.Latest, "disk_space", reason)
+			return nil
+		}
+		if reason, ok := dataCapped(err); ok {
+			log.Printf("deferring download of %s: %s", ck.Latest.Version, reason)
+			timer.deferred = true
+			reportDeferral(current, ck.Latest, "data_cap", reason)
+			return nil
+		}
+		return cancelled(ctx, ck.Latest, err)
+	}
+	timer.mark("download")
+	ready.setPhase(updateVerifying, ck.Latest.Version)
+
 	// 校验 sha256
-	ok, err := verifySha256(tmpFile, ck.Latest.Sha256)
+	ok, err := verifySha256(ctx, tmpFile, ck.Latest.Sha256)
 	if err != nil {
-		return err
+		return cancelled(ctx, ck.Latest, err)
 	}
 	if !ok {
-		_ = os.Remove(tmpFile)
 		return errors.New("sha256 mismatch")
 	}
 
-	// 安装为 algo_<version>
-	dst := filepath.Join(cfg.InstallDir, "algo_"+ck.Latest.Version)
-	if err := os.Rename(tmpFile, dst); err != nil {
+	// 校验 CI 的 cosign 签名（及 Rekor 透明日志条目）
+	if err := verifyCosign(cfg, ck.Latest); err != nil {
 		return err
 	}
-	if err := os.Chmod(dst, 0o755); err != nil { // 确保可执行
-		return err
+
+	// 校验版本已记入透明日志，且日志未被分叉或回退
+	if err := verifyTransparency(ctx, cfg, ck.Latest); err != nil {
+		return cancelled(ctx, ck.Latest, err)
 	}
+	timer.mark("verify")
 
-	// 原子切换符号链接
-	currLink := filepath.Join(cfg.InstallDir, "algo_current")
-	_ = os.Remove(currLink)
-	if err := os.Symlink(dst, currLink); err != nil {
-		return err
+	// 飞行中不安装：保留已校验的制品，门控打开后的检查再安装（见 updategate.go）
+	if reason := gateHold(); reason != "" {
+		log.Printf("deferring install of %s: %s", ck.Latest.Version, reason)
+		if err := os.Rename(tmpFile, tmpFile+stagedSuffix); err != nil {
+			return err
+		}
+		timer.deferred = true
+		reportDeferral(current, ck.Latest, "update_gate", reason)
+		return nil
 	}
 
-	// 平滑重启
-	if err := restartAlgorithm(currLink); err != nil {
+	// tar.gz / zip 包按解压后的大小再检查一次空间；不足时同样保留已校验的制品（见 diskspace.go）
+	if err := preflightInstall(cfg.StateDir, ck.Latest, tmpFile); err != nil {
+		reason, _ := diskShort(err)
+		log.Printf("deferring install of %s: %s", ck.Latest.Version, reason)
+		if err := os.Rename(tmpFile, staged); err != nil {
+			return err
+		}
+		timer.deferred = true
+		reportDeferral(current, ck.Latest, "disk_space", reason)
+		return nil
+	}
+
+	// 按安装后端安装并生效（binary：algo_<version> + algo_current；deb/rpm：包管理器）
+	if err := gate.wait(ctx, "install"); err != nil {
+		return cancelled(ctx, ck.Latest, err)
+	}
+	// 切换开始后不再响应取消
+	inflight.target("")
+	ready.setPhase(updateInstalling, ck.Latest.Version)
+	restartTook = 0
+	if err := scratch.prepare(ck.Latest.Version); err != nil {
 		return err
 	}
+	if err := inst.Install(ctx, ck.Latest, tmpFile); err != nil {
+		_ = scratch.prepare(current)
+		// 交接中止时新版本没有生效，同健康检查失败一样不再自动安装；经本地 API 中止的记为取消
+		if he, ok := handoverFailed(err); ok {
+			bad.add(ck.Latest.Version)
+			if he.aborted {
+				return &stoppedError{version: ck.Latest.Version, cause: err}
+			}
+		}
+		return cancelled(ctx, ck.Latest, err)
+	}
+	timer.mark("install")
+	timer.split("install", "restart", restartTook)
 
 	// 记录当前版本
-	if err := os.WriteFile(currentVerFP, []byte(ck.Latest.Version), 0o644); err != nil {
+	if err := sup.setVersion(ck.Latest.Version); err != nil {
 		return err
 	}
 	log.Printf("updated to %s", ck.Latest.Version)
+	// 算法由 agent 拉起时等它通过健康检查，计入 health_confirm；不通过则回滚（见 healthcheck.go）
+	if restartTook > 0 {
+		ready.setPhase(updateConfirming, ck.Latest.Version)
+		ok := confirmHealthy(parent, cfg.HealthCheck)
+		timer.mark("health_confirm")
+		timer.healthy = &ok
+		// agent 退出打断的确认不算失败，保留新版本
+		if !ok && parent.Err() == nil {
+			err := rollBack(ck.Latest, current)
+			timer.mark("rollback")
+			if readCurrentVersion() == current {
+				timer.rolledBack = current
+			}
+			return err
+		}
+	}
+	// 只保留版本槽位中的版本（当前与回滚目标）及其暂存目录
+	kept := slots.installed(ck.Latest.Version, current)
+	inst.Prune(kept)
+	scratch.prune(kept...)
 	return nil
 }
 
-func loadConfig(fp string) (*Config, error) {
-	b, err := os.ReadFile(fp)
-	if err != nil {
-		return nil, err
+// setup applies config defaults, validates cfg and creates the server
+// client, update source, installer and verifiers.
+func setup(cfg *Config) error {
+	var err error
+	if cfg.CheckEvery <= 0 {
+		cfg.CheckEvery = 10
 	}
-	var c Config
-	if err := json.Unmarshal(b, &c); err != nil {
-		return nil, err
+	if err := checkJitter(cfg); err != nil {
+		return err
 	}
-	return &c, nil
-}
-
-func readCurrentVersion() string {
-	b, err := os.ReadFile(currentVerFP)
-	if err != nil {
-		return ""
+	if cfg.HeartbeatEvery == 0 {
+		cfg.HeartbeatEvery = 60
+	}
+	if err := checkStateDir(cfg); err != nil {
+		return err
+	}
+	if err := checkTelemetryConfig(cfg); err != nil {
+		return err
+	}
+	if err := gate.configure(cfg.Throttle); err != nil {
+		return err
+	}
+	if power, err = newPowerProvider(cfg.Power); err != nil {
+		return err
+	}
+	if flightGate, err = newUpdateGate(cfg.UpdateGate); err != nil {
+		return err
+	}
+	if downloadGate, err = newUpdateGate(cfg.DownloadGate); err != nil {
+		return fmt.Errorf("download_gate: %w", err)
+	}
+	if err := dlLimit.configure(cfg.MaxDownloadKbps); err != nil {
+		return err
+	}
+	if err := checkDataUsage(cfg); err != nil {
+		return err
+	}
+	if err := checkContainerConfig(cfg); err != nil {
+		return err
+	}
+	if err := checkShutdownPolicy(cfg); err != nil {
+		return err
+	}
+	if err := checkKeepVersions(cfg); err != nil {
+		return err
+	}
+	if err := checkMinFree(cfg); err != nil {
+		return err
+	}
+	if cfg.MetricsAddr != "" && cfg.MetricsAddr == cfg.LocalAPIAddr {
+		return fmt.Errorf("metrics_addr must differ from local_api_addr")
+	}
+	if cfg.LocalAPIToken == "" {
+		cfg.LocalAPIToken = os.Getenv("OTA_LOCAL_API_TOKEN")
+	}
+	if cfg.LocalAPIAddr != "" && cfg.LocalAPIToken == "" && !loopbackAddr(cfg.LocalAPIAddr) {
+		return fmt.Errorf("local_api_addr %s is not a loopback address; set local_api_token", cfg.LocalAPIAddr)
+	}
+	ready.configure(cfg)
+	scratch.configure(cfg)
+	control.configure(cfg)
+	if err := pins.configure(cfg); err != nil {
+		return err
+	}
+	if err := checkHealthCheckConfig(&cfg.HealthCheck); err != nil {
+		return err
+	}
+	if err := checkRestartConfig(&cfg.Restart); err != nil {
+		return err
+	}
+	if err := checkHandoverConfig(cfg); err != nil {
+		return err
+	}
+	if _, ok := safetyRank[cfg.MaxSafetyImpact]; cfg.MaxSafetyImpact != "" && !ok {
+		return fmt.Errorf("max_safety_impact %q: want none, low, high or experimental", cfg.MaxSafetyImpact)
+	}
+	if cfg.AuthToken == "" {
+		cfg.AuthToken = os.Getenv("OTA_AUTH_TOKEN")
+	}
+	if httpClient, err = newHTTPClient(cfg); err != nil {
+		return err
+	}
+	if cfg.OCIPassword == "" {
+		cfg.OCIPassword = os.Getenv("OCI_PASSWORD")
+	}
+	if src, err = newUpdateSource(cfg); err != nil {
+		return fmt.Errorf("update source: %w", err)
+	}
+	if inst, err = newInstaller(cfg); err != nil {
+		return err
+	}
+	if cosignV, err = newCosignVerifier(cfg); err != nil {
+		return err
+	}
+	if logPub, err = loadLogKey(cfg); err != nil {
+		return err
+	}
+	if artifactPub, err = loadArtifactKey(cfg); err != nil {
+		return err
+	}
+	if cfg.SideloadDir != "" {
+		if err := checkSideloadConfig(); err != nil {
+			return err
+		}
+		src = &sideloadSource{dir: cfg.SideloadDir, next: src}
+	}
+	sup.setDirs(cfg.StateDir, cfg.InstallDir)
+	sup.setRestart(cfg.Restart)
+	sup.setHandover(cfg.Handover)
+	bad.load(cfg.StateDir)
+	slots.configure(cfg.StateDir, cfg.KeepVersions)
+	if err := configureAlgoLog(cfg); err != nil {
+		return err
+	}
+	if err := configureApps(cfg); err != nil {
+		return err
 	}
-	return string(b)
+	return configureSelfUpdate(cfg)
 }
 
-func downloadToFile(url, dst string) error {
-	resp, err := http.Get(url)
+// loadConfig 在构建时默认值之上叠加运行时配置：文件中出现的字段覆盖默认值。
+func loadConfig(fp string) (*Config, error) {
+	c, err := defaultConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		b, _ := io.ReadAll(resp.Body)
-		return errors.New("download failed: " + string(b))
+	if fp == "" {
+		return c, nil
 	}
-	f, err := os.Create(dst)
+	b, err := os.ReadFile(fp)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
-	_, err = io.Copy(f, resp.Body)
-	return err
+	if err := verifyConfigSignature(fp, b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-func verifySha256(fp, want string) (bool, error) {
+func verifySha256(ctx context.Context, fp, want string) (bool, error) {
 	f, err := os.Open(fp)
 	if err != nil {
 		return false, err
 	}
 	defer f.Close()
 	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
+	if _, err := io.Copy(h, &gatedReader{ctx: ctx, r: f, op: "sha256 verification"}); err != nil {
 		return false, err
 	}
 	got := hex.EncodeToString(h.Sum(nil))
 	return got == want, nil
 }
 
-func startAlgorithm(bin string) error {
-	cmd := exec.Command(bin)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-	currentCmd = cmd
-	log.Printf("algorithm started (pid=%d)", cmd.Process.Pid)
-	go func() {
-		err := cmd.Wait()
-		log.Printf("algorithm exited: %v", err)
-	}()
-	return nil
-}
-
-func stopAlgorithm() error {
-	if currentCmd == nil || currentCmd.Process == nil {
-		return nil
-	}
-	if err := currentCmd.Process.Signal(os.Interrupt); err != nil {
-		_ = currentCmd.Process.Kill()
-	}
-	currentCmd = nil
-	return nil
-}
-
 func restartAlgorithm(bin string) error {
+	start := clk.Now()
+	defer func() { restartTook = clk.Since(start) }()
+	// 容器版本同名的容器只能有一个，不做新旧进程交接（见 container.go）
+	if sup.handoverConfig().ReadyURL != "" && !isImage(bin) {
+		return sup.do(opHandover, bin)
+	}
 	if err := stopAlgorithm(); err != nil {
 		return err
 	}
-	time.Sleep(300 * time.Millisecond)
+	clk.Sleep(300 * time.Millisecond)
 	return startAlgorithm(bin)
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus 指标：配置 metrics_addr 后在该地址提供 GET /metrics（文本格式 0.0.4），供机队监控抓取。
+// 与本地控制 API 分开监听：本地 API 可以只绑定回环地址，指标端口按需开放给监控网络，且只读。
+// app 标签区分主应用（空）、apps 中的应用与 agent 自身（agent）。计数器从 agent 启动时算起，
+// agent 重启（含自更新）后归零，Prometheus 按计数器重置处理。
+
+// downloadBuckets 是下载耗时直方图的上界（秒）；制品从几 MB 到上百 MB，链路从以太网到 4G。
+var downloadBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800}
+
+// appMetrics 是一个应用的计数。
+type appMetrics struct {
+	checks        int
+	checkFailures int
+	lastCheckOK   time.Time
+	installs      map[string]int // status -> 次数
+	downloads     []int          // 各 downloadBuckets 区间的完成次数（不累计），末项为 +Inf
+	downloadSum   float64
+	restarts      int
+	crashes       int
+}
+
+type agentMetrics struct {
+	mu        sync.Mutex
+	apps      map[string]*appMetrics
+	downBytes int64
+}
+
+var metrics = &agentMetrics{apps: map[string]*appMetrics{}}
+
+func (m *agentMetrics) app(name string) *appMetrics {
+	a := m.apps[name]
+	if a == nil {
+		a = &appMetrics{installs: map[string]int{}, downloads: make([]int, len(downloadBuckets)+1)}
+		m.apps[name] = a
+	}
+	return a
+}
+
+// checked counts a check of app and whether it reached the update source.
+func (m *agentMetrics) checked(app string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.app(app)
+	a.checks++
+	if err != nil {
+		a.checkFailures++
+	} else {
+		a.lastCheckOK = clk.Now()
+	}
+}
+
+// installed counts the outcome of an update attempt.
+func (m *agentMetrics) installed(app, status string) {
+	m.mu.Lock()
+	m.app(app).installs[status]++
+	m.mu.Unlock()
+}
+
+// fetched records how long a completed download of rel took; it is
+// deferred by the update sources' Fetch.
+func (m *agentMetrics) fetched(rel *Release, started time.Time, errp *error) {
+	if *errp != nil {
+		return
+	}
+	d := clk.Since(started).Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.app(rel.App)
+	i := sort.SearchFloat64s(downloadBuckets, d)
+	a.downloads[i]++
+	a.downloadSum += d
+}
+
+// downloaded counts artifact bytes received, including retried and
+// abandoned downloads.
+func (m *agentMetrics) downloaded(n int) {
+	if n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	m.downBytes += int64(n)
+	m.mu.Unlock()
+}
+
+func (m *agentMetrics) restarted(app string) {
+	m.mu.Lock()
+	m.app(app).restarts++
+	m.mu.Unlock()
+}
+
+func (m *agentMetrics) crashed(app string) {
+	m.mu.Lock()
+	m.app(app).crashes++
+	m.mu.Unlock()
+}
+
+// write renders the metrics in the Prometheus text format.
+func (m *agentMetrics) write(b *strings.Builder) {
+	versions := map[string]string{"": readCurrentVersion(), agentApp: agentVersion}
+	for name, v := range appVersions() {
+		versions[name] = v
+	}
+	now := clk.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name := range versions {
+		// 未开启自更新时 agent 只有版本
+		if name != agentApp || selfUpd != nil {
+			m.app(name)
+		}
+	}
+	names := make([]string, 0, len(m.apps))
+	for name := range m.apps {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	family := func(name, typ, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	family("ota_agent_version_info", "gauge", "Installed version of each app (1 per app), agent for the agent itself.")
+	vnames := make([]string, 0, len(versions))
+	for name := range versions {
+		vnames = append(vnames, name)
+	}
+	slices.Sort(vnames)
+	for _, name := range vnames {
+		if v := versions[name]; v != "" {
+			fmt.Fprintf(b, "ota_agent_version_info{app=%q,version=%q} 1\n", name, v)
+		}
+	}
+	family("ota_agent_checks_total", "counter", "Update checks.")
+	for _, name := range names {
+		fmt.Fprintf(b, "ota_agent_checks_total{app=%q} %d\n", name, m.apps[name].checks)
+	}
+	family("ota_agent_check_failures_total", "counter", "Update checks that failed to reach the update source.")
+	for _, name := range names {
+		fmt.Fprintf(b, "ota_agent_check_failures_total{app=%q} %d\n", name, m.apps[name].checkFailures)
+	}
+	family("ota_agent_seconds_since_last_successful_check", "gauge", "Time since the last successful update check; absent before the first one.")
+	for _, name := range names {
+		if t := m.apps[name].lastCheckOK; !t.IsZero() {
+			fmt.Fprintf(b, "ota_agent_seconds_since_last_successful_check{app=%q} %s\n", name, formatFloat(now.Sub(t).Seconds()))
+		}
+	}
+	family("ota_agent_installs_total", "counter", "Update attempts and rollbacks by outcome (success, failure, cancelled, rolled_back, rollback_failed).")
+	for _, name := range names {
+		a := m.apps[name]
+		statuses := []string{"success", "failure"}
+		for s := range a.installs {
+			if !slices.Contains(statuses, s) {
+				statuses = append(statuses, s)
+			}
+		}
+		for _, s := range statuses {
+			fmt.Fprintf(b, "ota_agent_installs_total{app=%q,status=%q} %d\n", name, s, a.installs[s])
+		}
+	}
+	family("ota_agent_download_bytes_total", "counter", "Artifact bytes downloaded.")
+	fmt.Fprintf(b, "ota_agent_download_bytes_total %d\n", m.downBytes)
+	down, up, capBytes := dataUse.counts()
+	family("ota_agent_data_usage_bytes", "gauge", "HTTP body bytes sent and received in the current billing period.")
+	fmt.Fprintf(b, "ota_agent_data_usage_bytes{direction=\"download\"} %d\n", down)
+	fmt.Fprintf(b, "ota_agent_data_usage_bytes{direction=\"upload\"} %d\n", up)
+	if capBytes > 0 {
+		family("ota_agent_data_cap_bytes", "gauge", "Monthly data cap; only mandatory releases are downloaded beyond it.")
+		fmt.Fprintf(b, "ota_agent_data_cap_bytes %d\n", capBytes)
+	}
+	family("ota_agent_download_duration_seconds", "histogram", "Duration of completed artifact downloads.")
+	for _, name := range names {
+		a := m.apps[name]
+		n := 0
+		for i, le := range downloadBuckets {
+			n += a.downloads[i]
+			fmt.Fprintf(b, "ota_agent_download_duration_seconds_bucket{app=%q,le=%q} %d\n", name, formatFloat(le), n)
+		}
+		n += a.downloads[len(downloadBuckets)]
+		fmt.Fprintf(b, "ota_agent_download_duration_seconds_bucket{app=%q,le=\"+Inf\"} %d\n", name, n)
+		fmt.Fprintf(b, "ota_agent_download_duration_seconds_sum{app=%q} %s\n", name, formatFloat(a.downloadSum))
+		fmt.Fprintf(b, "ota_agent_download_duration_seconds_count{app=%q} %d\n", name, n)
+	}
+	family("ota_agent_algorithm_restarts_total", "counter", "Automatic restarts of the algorithm after it exited unexpectedly.")
+	for _, name := range names {
+		if name != agentApp {
+			fmt.Fprintf(b, "ota_agent_algorithm_restarts_total{app=%q} %d\n", name, m.apps[name].restarts)
+		}
+	}
+	family("ota_agent_algorithm_crashes_total", "counter", "Unexpected exits of the algorithm.")
+	for _, name := range names {
+		if name != agentApp {
+			fmt.Fprintf(b, "ota_agent_algorithm_crashes_total{app=%q} %d\n", name, m.apps[name].crashes)
+		}
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// startMetrics serves /metrics on addr; nothing when addr is empty.
+func startMetrics(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		metrics.write(&b)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(b.String()))
+	})
+	go func() {
+		log.Printf("metrics listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("metrics: %v", err)
+		}
+	}()
+}
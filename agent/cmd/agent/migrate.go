@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 安装目录迁移：agent migrate -to /new/path config.json 把 agent 写入的目录（state_dir，未配置时即
+// install_dir）整体搬到新位置，例如从 eMMC 换到 NVMe。迁移期间持有安装锁，agent 在运行时拒绝迁移。
+// 步骤：检查目标为空且空间足够 → 复制版本、符号链接、current_version、上报队列、暂存目录与各类缓存
+// （指向旧目录内的符号链接改指新目录）→ 逐个比对 sha256 并确认 algo_current 可解析 → 改写配置文件
+// 中的 install_dir（或 state_dir）→ 删除旧目录中的内容。配置需要签名（构建时注入了 configPubKey）
+// 或未给出配置文件时不改写配置，也保留旧目录，由操作员更新配置后自行删除。
+
+// migrateSkip 是不随目录迁移的文件：锁与 PID 文件属于本次迁移，下载临时文件下次检查会重新下载。
+var migrateSkip = []string{".agent.lock", "agent.pid", "download_*"}
+
+func migrateMain(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := flags.String("to", "", "directory to move the agent's installed versions and state to")
+	keep := flags.Bool("keep-old", false, "keep the old directory after a verified migration")
+	_ = flags.Parse(args)
+	if *to == "" {
+		fmt.Fprintln(os.Stderr, "usage: agent migrate -to /new/path [-keep-old] [config.json]")
+		return 2
+	}
+	if err := migrate(flags.Arg(0), *to, *keep); err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func migrate(cfgPath, to string, keep bool) error {
+	cfg, err := loadConfig(cfgPath)
+	if err != nil {
+		return err
+	}
+	if err := checkStateDir(cfg); err != nil {
+		return err
+	}
+	from, err := filepath.Abs(cfg.StateDir)
+	if err != nil {
+		return err
+	}
+	if to, err = filepath.Abs(to); err != nil {
+		return err
+	}
+	if err := checkMigrateTarget(from, to); err != nil {
+		return err
+	}
+	// 持锁期间 agent 无法启动，也不会有更新写入旧目录
+	lock, err := acquireInstallLock(from, filepath.Join(from, "agent.pid"))
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	size, err := dirSize(from)
+	if err != nil {
+		return err
+	}
+	if free, err := freeSpace(to); err == nil && free < uint64(size)+64<<20 {
+		return fmt.Errorf("%s has %d MiB free, need %d MiB", to, free>>20, (size>>20)+64)
+	}
+	fmt.Printf("copying %s (%d MiB) to %s\n", from, size>>20, to)
+	if err := copyTree(from, to); err != nil {
+		return err
+	}
+	if err := verifyMigration(from, to); err != nil {
+		return fmt.Errorf("verify %s: %w (the old directory is untouched)", to, err)
+	}
+	fmt.Println("verified: file contents and symlinks match")
+
+	key := "install_dir"
+	if cfg.StateDir != cfg.InstallDir {
+		key = "state_dir"
+	}
+	switch {
+	case cfgPath == "":
+		fmt.Printf("no config file given: set %q to %q in the agent config, then remove %s\n", key, to, from)
+		return nil
+	case configPubKey != "":
+		fmt.Printf("config is signed: set %q to %q in %s and re-sign it, then remove %s\n", key, to, cfgPath, from)
+		return nil
+	}
+	if err := setConfigKey(cfgPath, key, to); err != nil {
+		return fmt.Errorf("update %s: %w (set %q to %q by hand)", cfgPath, err, key, to)
+	}
+	fmt.Printf("updated %s: %s = %s\n", cfgPath, key, to)
+	if keep {
+		fmt.Printf("kept %s\n", from)
+		return nil
+	}
+	if err := removeMigrated(from); err != nil {
+		return fmt.Errorf("remove old files: %w", err)
+	}
+	fmt.Printf("removed the old files from %s\n", from)
+	return nil
+}
+
+// checkMigrateTarget requires to to be a new or empty directory outside from.
+func checkMigrateTarget(from, to string) error {
+	if to == from || strings.HasPrefix(to, from+string(filepath.Separator)) || strings.HasPrefix(from, to+string(filepath.Separator)) {
+		return fmt.Errorf("%s and %s overlap", from, to)
+	}
+	entries, err := os.ReadDir(to)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return os.MkdirAll(to, 0o755)
+	case err != nil:
+		return err
+	case len(entries) > 0:
+		return fmt.Errorf("%s is not empty", to)
+	}
+	return nil
+}
+
+func skipMigrate(rel string) bool {
+	if strings.Contains(rel, string(filepath.Separator)) {
+		return false
+	}
+	for _, p := range migrateSkip {
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func dirSize(dir string) (int64, error) {
+	var n int64
+	err := filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			n += fi.Size()
+		}
+		return nil
+	})
+	return n, err
+}
+
+// copyTree copies from into to, keeping modes; symlinks into from are
+// rewritten to point into to.
+func copyTree(from, to string) error {
+	return filepath.WalkDir(from, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(from, fp)
+		if rel == "." {
+			return nil
+		}
+		if skipMigrate(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dst := filepath.Join(to, rel)
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(dst, fi.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(fp)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(relocate(target, from, to), dst)
+		case d.Type().IsRegular():
+			return copyFile(fp, dst, fi.Mode().Perm())
+		}
+		fmt.Printf("skipping %s: not a regular file\n", fp)
+		return nil
+	})
+}
+
+// relocate maps an absolute path inside from to the same path inside to.
+func relocate(target, from, to string) string {
+	if rest, ok := strings.CutPrefix(target, from+string(filepath.Separator)); ok {
+		return filepath.Join(to, rest)
+	}
+	return target
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// verifyMigration compares every copied file by sha256, checks the symlinks
+// and that algo_current resolves in the new directory.
+func verifyMigration(from, to string) error {
+	err := filepath.WalkDir(from, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(from, fp)
+		if rel == "." {
+			return nil
+		}
+		if skipMigrate(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dst := filepath.Join(to, rel)
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			want, _ := os.Readlink(fp)
+			got, err := os.Readlink(dst)
+			if err != nil {
+				return err
+			}
+			if got != relocate(want, from, to) {
+				return fmt.Errorf("%s -> %s, want %s", dst, got, relocate(want, from, to))
+			}
+		case d.Type().IsRegular():
+			a, err := fileSum(fp)
+			if err != nil {
+				return err
+			}
+			b, err := fileSum(dst)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(a, b) {
+				return fmt.Errorf("%s: sha256 mismatch", dst)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	link := filepath.Join(to, "algo_current")
+	if _, err := os.Lstat(link); err == nil {
+		if _, err := os.Stat(link); err != nil {
+			return fmt.Errorf("algo_current: %w", err)
+		}
+	}
+	return nil
+}
+
+func fileSum(fp string) ([]byte, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// setConfigKey rewrites one top-level key of the JSON config file. Keys are
+// written in sorted order; values are kept as they were.
+func setConfigKey(fp, key, value string) error {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return err
+	}
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	m[key], _ = json.Marshal(value)
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, append(out, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fp)
+}
+
+// removeMigrated deletes the migrated entries of from; the directory itself
+// (possibly a mount point) and the lock file stay.
+func removeMigrated(from string) error {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == ".agent.lock" || e.Name() == "agent.pid" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(from, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import "syscall"
+
+// staUnsync 对应内核 timex.status 中的 STA_UNSYNC 位。
+const staUnsync = 0x0040
+
+// clockSynced reports whether the kernel considers the clock NTP-synchronized.
+func clockSynced() bool {
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	if err != nil {
+		return false
+	}
+	const timeError = 5 // TIME_ERROR
+	return state != timeError && tx.Status&staUnsync == 0
+}
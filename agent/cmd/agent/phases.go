@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+)
+
+// 安装各阶段耗时：download、verify（sha256、cosign、透明日志）、install、restart 与 health_confirm
+// （重启后算法连续运行 healthConfirmUptime 的等待时间）随安装报告上报（phases_ms）。服务端据此
+// 找出持续超出 SLO 的设备——多半是 SD 卡老化。
+
+const (
+	healthConfirmUptime  = 10 * time.Second
+	healthConfirmTimeout = 2 * time.Minute
+)
+
+// restartTook 是最近一次 restartAlgorithm 的耗时，由安装流程读取后清零。
+var restartTook time.Duration
+
+// phaseTimer 记录一次更新尝试中各阶段的耗时。
+type phaseTimer struct {
+	started time.Time
+	last    time.Time
+	phases  map[string]int64 // 毫秒
+	// healthy 是 health_confirm 的结果，未确认（算法不由 agent 管理或安装失败）时为 nil
+	healthy *bool
+	// rolledBack 是健康检查失败后回滚到的版本（见 healthcheck.go）
+	rolledBack string
+	// deferred 表示制品已就绪但更新门控关闭，本次不安装也不上报安装结果
+	deferred bool
+}
+
+func newPhaseTimer() *phaseTimer {
+	now := clk.Now()
+	return &phaseTimer{started: now, last: now, phases: map[string]int64{}}
+}
+
+// mark ends phase at the current time.
+func (t *phaseTimer) mark(phase string) {
+	now := clk.Now()
+	t.phases[phase] = now.Sub(t.last).Milliseconds()
+	t.last = now
+}
+
+// split moves d of the time recorded for from into phase.
+func (t *phaseTimer) split(from, phase string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t.phases[from] -= d.Milliseconds()
+	t.phases[phase] = d.Milliseconds()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// 版本固定与跳过：操作员可以把一台无人机固定在已知良好的版本（pinned_version），或跳过在这台硬件上表现
+// 异常的版本（skip_versions）。固定时检查带上 pin=<版本>（OCI 来源改拉该版本的 tag），服务端以它代替渠道
+// 最新版本，可以比当前版本旧；已是该版本后不再安装其它版本（强制版本也不例外），来源给出的不是固定版本时
+// （旧服务端）同样不安装。固定的版本由操作员指定，不受 max_safety_impact 限制。跳过的版本不下载、不安装，
+// 之后更新的版本照常安装。二者只作用于主应用；二分定位指定的版本与召回回滚不受影响，回滚过的版本
+// （bad_versions.json）即使被固定也不再安装，避免反复安装、回滚。
+// 本地 API 可以修改这两项（/update/pin、/update/unpin、/update/skip、/update/unskip），修改后以
+// <state_dir>/version_pins.json 为准，覆盖配置文件中的值，重启后仍然有效；删除该文件即恢复配置文件中的值。
+
+const pinsFile = "version_pins.json"
+
+// versionPins 是生效的固定版本与跳过列表。
+type versionPins struct {
+	Pinned string   `json:"pinned_version,omitempty"`
+	Skip   []string `json:"skip_versions,omitempty"`
+}
+
+func (v versionPins) check() error {
+	for _, s := range v.Skip {
+		if strings.TrimSpace(s) == "" {
+			return errors.New("skip_versions has an empty version")
+		}
+	}
+	if v.Pinned != "" && slices.Contains(v.Skip, v.Pinned) {
+		return fmt.Errorf("pinned_version %s is also in skip_versions", v.Pinned)
+	}
+	return nil
+}
+
+type pinState struct {
+	mu    sync.Mutex
+	file  string
+	cur   versionPins
+	local bool // 来自本地 API 写入的 version_pins.json
+}
+
+var pins pinState
+
+// configure takes the configured pins, replaced by those set through the
+// local API if any.
+func (p *pinState) configure(cfg *Config) error {
+	cur := versionPins{Pinned: cfg.PinnedVersion, Skip: cfg.SkipVersions}
+	if err := cur.check(); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.file, p.cur, p.local = filepath.Join(cfg.StateDir, pinsFile), cur, false
+	b, err := os.ReadFile(p.file)
+	if err != nil {
+		return nil
+	}
+	var saved versionPins
+	if err := json.Unmarshal(b, &saved); err != nil {
+		log.Printf("%s: %v", p.file, err)
+		return nil
+	}
+	p.cur, p.local = saved, true
+	if saved.Pinned != cur.Pinned || !slices.Equal(saved.Skip, cur.Skip) {
+		log.Printf("version pins set via the local API override the config: pinned %q, skip %v", saved.Pinned, saved.Skip)
+	}
+	return nil
+}
+
+// pinned returns the version the primary app is pinned to, "" when none.
+func (p *pinState) pinned() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cur.Pinned
+}
+
+func (p *pinState) skipped(v string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Contains(p.cur.Skip, v)
+}
+
+// update changes the pins and persists them as set through the local API.
+func (p *pinState) update(fn func(*versionPins)) (versionPins, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := versionPins{Pinned: p.cur.Pinned, Skip: slices.Clone(p.cur.Skip)}
+	fn(&next)
+	if err := next.check(); err != nil {
+		return p.cur, err
+	}
+	b, _ := json.Marshal(next)
+	if err := os.WriteFile(p.file, b, 0o644); err != nil {
+		return p.cur, err
+	}
+	p.cur, p.local = next, true
+	return next, nil
+}
+
+func (p *pinState) status() map[string]any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	source := "config"
+	if p.local {
+		source = "local_api"
+	}
+	return map[string]any{"pinned_version": p.cur.Pinned, "skip_versions": p.cur.Skip, "source": source}
+}
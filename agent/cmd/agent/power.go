@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 电量门控：电池电量低于 power.min_battery_pct 时不开始安装与切换，除非版本被标记为强制（mandatory）；
+// 推迟的原因作为 status=deferred 的安装报告上报服务端。电量来自可插拔的电源状态来源：
+//   - file：外部程序写入的文件，内容为百分比数字或 {"percent": 57, "charging": false}；
+//   - sysfs：Linux 电源子系统目录（capacity / status），缺省 /sys/class/power_supply/BAT0；
+//   - mavlink：在 UDP 地址上接收飞控的 MAVLink SYS_STATUS（battery_remaining），见 power_mavlink.go；
+//   - http：本机服务返回与 file 相同格式的 JSON。
+// 读不到电量时按电量不足处理：宁可推迟更新，也不在电量未知时切换飞行算法。
+
+const (
+	powerFile    = "file"
+	powerSysfs   = "sysfs"
+	powerMAVLink = "mavlink"
+	powerHTTP    = "http"
+
+	defaultSysfsBattery = "/sys/class/power_supply/BAT0"
+	defaultMAVLinkAddr  = ":14550"
+)
+
+// PowerConfig 选择电源状态来源并设置电量门限，provider 为空时关闭。
+type PowerConfig struct {
+	Provider      string  `json:"provider"`     // file | sysfs | mavlink | http
+	Path          string  `json:"path"`         // file 的文件路径，或 sysfs 的电源目录
+	URL           string  `json:"url"`          // http
+	MAVLinkAddr   string  `json:"mavlink_addr"` // mavlink 的 UDP 监听地址，缺省 :14550
+	MinBatteryPct float64 `json:"min_battery_pct"`
+	// AllowCharging 时正在充电的设备不受电量门限限制。
+	AllowCharging bool `json:"allow_when_charging"`
+}
+
+// powerState 是一次电量读数。
+type powerState struct {
+	Percent  float64 `json:"percent"`
+	Charging bool    `json:"charging"`
+}
+
+// powerProvider 读取当前电量。
+type powerProvider interface {
+	Battery() (powerState, error)
+}
+
+// power 在未配置 provider 时为 nil。
+var power powerProvider
+
+func newPowerProvider(c PowerConfig) (powerProvider, error) {
+	if c.MinBatteryPct < 0 || c.MinBatteryPct > 100 {
+		return nil, fmt.Errorf("power.min_battery_pct must be between 0 and 100")
+	}
+	switch c.Provider {
+	case "":
+		return nil, nil
+	case powerFile:
+		if c.Path == "" {
+			return nil, errors.New("power provider file needs path")
+		}
+		return filePower{path: c.Path}, nil
+	case powerSysfs:
+		if c.Path == "" {
+			c.Path = defaultSysfsBattery
+		}
+		return sysfsPower{dir: c.Path}, nil
+	case powerMAVLink:
+		if c.MAVLinkAddr == "" {
+			c.MAVLinkAddr = defaultMAVLinkAddr
+		}
+		return listenMAVLink(c.MAVLinkAddr)
+	case powerHTTP:
+		if c.URL == "" {
+			return nil, errors.New("power provider http needs url")
+		}
+		return httpPower{url: c.URL, client: &http.Client{Timeout: 2 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("unknown power provider %q (want file, sysfs, mavlink or http)", c.Provider)
+}
+
+// parsePowerState accepts a bare percentage or the JSON form.
+func parsePowerState(b []byte) (powerState, error) {
+	s := strings.TrimSpace(string(b))
+	if pct, err := strconv.ParseFloat(s, 64); err == nil {
+		return powerState{Percent: pct}, nil
+	}
+	var ps powerState
+	if err := json.Unmarshal([]byte(s), &ps); err != nil {
+		return powerState{}, fmt.Errorf("battery state %q: want a percentage or {\"percent\": …}", s)
+	}
+	return ps, nil
+}
+
+type filePower struct{ path string }
+
+func (f filePower) Battery() (powerState, error) {
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return powerState{}, err
+	}
+	return parsePowerState(b)
+}
+
+type sysfsPower struct{ dir string }
+
+func (s sysfsPower) Battery() (powerState, error) {
+	b, err := os.ReadFile(filepath.Join(s.dir, "capacity"))
+	if err != nil {
+		return powerState{}, err
+	}
+	pct, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+	if err != nil {
+		return powerState{}, fmt.Errorf("%s/capacity: %w", s.dir, err)
+	}
+	ps := powerState{Percent: pct}
+	if st, err := os.ReadFile(filepath.Join(s.dir, "status")); err == nil {
+		ps.Charging = strings.TrimSpace(string(st)) == "Charging"
+	}
+	return ps, nil
+}
+
+type httpPower struct {
+	url    string
+	client *http.Client
+}
+
+func (h httpPower) Battery() (powerState, error) {
+	resp, err := h.client.Get(h.url)
+	if err != nil {
+		return powerState{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return powerState{}, fmt.Errorf("%s: %s", h.url, resp.Status)
+	}
+	var ps powerState
+	if err := json.NewDecoder(resp.Body).Decode(&ps); err != nil {
+		return powerState{}, fmt.Errorf("%s: %w", h.url, err)
+	}
+	return ps, nil
+}
+
+// batteryHold returns why an install must not begin now, "" when it may.
+func batteryHold(cfg *Config, mandatory bool) string {
+	if power == nil || cfg.Power.MinBatteryPct == 0 {
+		return ""
+	}
+	ps, err := power.Battery()
+	switch {
+	case err != nil:
+		log.Printf("power: %v", err)
+		if mandatory {
+			return ""
+		}
+		return "battery level unknown"
+	case ps.Percent >= cfg.Power.MinBatteryPct, ps.Charging && cfg.Power.AllowCharging:
+		return ""
+	case mandatory:
+		log.Printf("battery at %.0f%%, below %.0f%%, but the release is mandatory", ps.Percent, cfg.Power.MinBatteryPct)
+		return ""
+	}
+	return fmt.Sprintf("battery at %.0f%%, below %.0f%%", ps.Percent, cfg.Power.MinBatteryPct)
+}
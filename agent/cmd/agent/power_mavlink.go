@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// MAVLink 电量来源：监听飞控（或 mavlink-router 转发）发往本机的 UDP 报文，解析 v1 / v2 帧中的
+// SYS_STATUS（消息 1）的 battery_remaining。只读不发，不影响飞控链路上的其它组件。
+
+const (
+	mavlinkV1Magic = 0xFE
+	mavlinkV2Magic = 0xFD
+
+	mavlinkSysStatus = 1
+	// sysStatusCRCExtra 是 SYS_STATUS 的 CRC_EXTRA 种子（由消息定义派生）。
+	sysStatusCRCExtra = 124
+	// batteryRemainingOffset 是 battery_remaining（int8，-1 表示未知）在载荷中的位置。
+	batteryRemainingOffset = 30
+
+	// mavlinkStale 内没有收到 SYS_STATUS 视为读不到电量。
+	mavlinkStale = 30 * time.Second
+)
+
+type mavlinkPower struct {
+	mu      sync.Mutex
+	percent float64
+	at      time.Time
+}
+
+func listenMAVLink(addr string) (*mavlinkPower, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("power provider mavlink: %w", err)
+	}
+	m := &mavlinkPower{}
+	go m.run(conn)
+	return m, nil
+}
+
+func (m *mavlinkPower) run(conn net.PacketConn) {
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			log.Printf("mavlink: %v", err)
+			return
+		}
+		for _, pct := range sysStatusBattery(buf[:n]) {
+			m.mu.Lock()
+			m.percent, m.at = pct, clk.Now()
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *mavlinkPower) Battery() (powerState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.at.IsZero() || clk.Since(m.at) > mavlinkStale {
+		return powerState{}, errors.New("no SYS_STATUS battery report from the flight controller")
+	}
+	return powerState{Percent: m.percent}, nil
+}
+
+// sysStatusBattery returns the battery_remaining values of the valid
+// SYS_STATUS frames in a datagram.
+func sysStatusBattery(b []byte) []float64 {
+	var out []float64
+	for len(b) > 0 {
+		var hdr int
+		switch b[0] {
+		case mavlinkV1Magic:
+			hdr = 6
+		case mavlinkV2Magic:
+			hdr = 10
+		default:
+			b = b[1:]
+			continue
+		}
+		if len(b) < hdr {
+			break
+		}
+		n := int(b[1])
+		end := hdr + n + 2
+		if b[0] == mavlinkV2Magic && b[2]&0x01 != 0 {
+			end += 13 // 签名
+		}
+		if len(b) < hdr+n+2 {
+			break
+		}
+		var msgID uint32
+		if b[0] == mavlinkV1Magic {
+			msgID = uint32(b[5])
+		} else {
+			msgID = uint32(b[7]) | uint32(b[8])<<8 | uint32(b[9])<<16
+		}
+		payload := b[hdr : hdr+n]
+		if msgID == mavlinkSysStatus && mavlinkCRC(b[1:hdr+n], sysStatusCRCExtra) == binary.LittleEndian.Uint16(b[hdr+n:]) {
+			// v2 会截掉载荷末尾的零字节，缺失的 battery_remaining 即为 0
+			remaining := 0
+			if len(payload) > batteryRemainingOffset {
+				remaining = int(int8(payload[batteryRemainingOffset]))
+			}
+			if remaining >= 0 {
+				out = append(out, float64(remaining))
+			}
+		}
+		if end > len(b) {
+			break
+		}
+		b = b[end:]
+	}
+	return out
+}
+
+// mavlinkCRC is the X.25 checksum MAVLink uses, seeded with the message's
+// CRC_EXTRA byte.
+func mavlinkCRC(b []byte, extra byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, c := range append(b[:len(b):len(b)], extra) {
+		tmp := c ^ byte(crc)
+		tmp ^= tmp << 4
+		crc = crc>>8 ^ uint16(tmp)<<8 ^ uint16(tmp)<<3 ^ uint16(tmp)>>4
+	}
+	return crc
+}
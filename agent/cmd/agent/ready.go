@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 起飞联锁：agent 把“算法是否就绪”写成机器可读的状态文件（ready_file，默认
+// <install_dir>/ready.json），本地 API GET /ready 返回同样的内容（就绪 200，否则 503），
+// 供飞控的起飞前检查读取。更新或回滚进行中、agent 仍在启动、算法未运行或最近一小时内崩溃过时
+// go 为 false，reasons 给出原因，地面流程据此阻止起飞。文件在状态变化时原子替换，并随每次检查
+// 刷新；agent 正常退出时写入 agent stopped，written_at 过旧说明 agent 异常退出，消费方都应视为未就绪。
+
+const (
+	updateIdle        = "idle"
+	updateDownloading = "downloading"
+	updateVerifying   = "verifying"
+	updateInstalling  = "installing"
+	updateConfirming  = "confirming"   // 重启后等待算法通过健康检查
+	updateRollingBack = "rolling_back" // 健康检查失败，切回上一个版本
+)
+
+// readyState 是 ready.json 与 GET /ready 的内容。
+type readyState struct {
+	Go        bool           `json:"go"`
+	Reasons   []string       `json:"reasons"`
+	Version   string         `json:"version"`
+	Update    updateProgress `json:"update"`
+	Algorithm map[string]any `json:"algorithm"`
+	// LastUpdate 是本次运行中最近一次更新尝试的结果，尚未尝试时为空。
+	LastUpdate *updateResult `json:"last_update"`
+	WrittenAt  time.Time     `json:"written_at"`
+}
+
+type updateProgress struct {
+	Phase  string `json:"phase"`
+	Target string `json:"target,omitempty"`
+}
+
+type updateResult struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Status string    `json:"status"` // success | failure | cancelled | rolled_back | rollback_failed
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+type readiness struct {
+	mu      sync.Mutex
+	file    string
+	managed bool // 算法进程由 agent 拉起；否则不以算法状态判定
+	update  updateProgress
+	last    *updateResult
+	stopped bool
+}
+
+var ready = readiness{update: updateProgress{Phase: updateIdle}}
+
+func (r *readiness) configure(cfg *Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.file = cfg.ReadyFile
+	if r.file == "" {
+		r.file = filepath.Join(cfg.StateDir, "ready.json")
+	}
+	r.managed = backendName(cfg) == backendBinary || cfg.PackageExec != ""
+}
+
+// setPhase records the progress of the running update and rewrites the file.
+func (r *readiness) setPhase(phase, target string) {
+	r.mu.Lock()
+	r.update = updateProgress{Phase: phase, Target: target}
+	r.mu.Unlock()
+	r.refresh()
+}
+
+// finished records the outcome of an update attempt.
+func (r *readiness) finished(res updateResult) {
+	r.mu.Lock()
+	r.update = updateProgress{Phase: updateIdle}
+	r.last = &res
+	r.mu.Unlock()
+	r.refresh()
+}
+
+// stop marks the agent as gone; the state no longer tracks the algorithm.
+func (r *readiness) stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+	r.refresh()
+}
+
+func (r *readiness) snapshot() readyState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshotLocked()
+}
+
+func (r *readiness) snapshotLocked() readyState {
+	st := readyState{
+		Reasons:    []string{},
+		Version:    readCurrentVersion(),
+		Update:     r.update,
+		Algorithm:  algo.healthData(),
+		LastUpdate: r.last,
+		WrittenAt:  clk.Now(),
+	}
+	if r.stopped {
+		st.Reasons = append(st.Reasons, "agent stopped")
+	} else if p, _ := boot.snapshot()["phase"].(string); p != phaseRunning {
+		st.Reasons = append(st.Reasons, "agent starting: "+p)
+	}
+	// 配置了更新门控时，下载与校验不会紧接着切换算法，不阻止起飞
+	predeploy := r.update.Phase == updateDownloading || r.update.Phase == updateVerifying
+	if r.update.Phase != updateIdle && !(predeploy && flightGate != nil) {
+		st.Reasons = append(st.Reasons, "update in progress: "+r.update.Phase+" "+r.update.Target)
+	}
+	if st.Version == "" {
+		st.Reasons = append(st.Reasons, "no algorithm installed")
+	}
+	if r.managed {
+		switch st.Algorithm["state"] {
+		case algoStopped:
+			st.Reasons = append(st.Reasons, "algorithm not running")
+		case algoCrashing:
+			st.Reasons = append(st.Reasons, "algorithm crashed within the last hour")
+		}
+	}
+	st.Go = len(st.Reasons) == 0
+	return st
+}
+
+// refresh rewrites the ready file with the current state.
+func (r *readiness) refresh() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == "" {
+		return
+	}
+	b, _ := json.MarshalIndent(r.snapshotLocked(), "", "  ")
+	tmp := r.file + ".tmp"
+	err := os.WriteFile(tmp, b, 0o644)
+	if err == nil {
+		err = os.Rename(tmp, r.file)
+	}
+	if err != nil {
+		log.Printf("ready file: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 配置热加载：修改 channel、alias、check_every_seconds、check_jitter_percent、server_url 不必重启 agent。收到 SIGHUP，或配置文件
+// （及其签名文件）的修改时间、大小变化（每 configPoll 检查一次）时重新读取配置，签名校验与启动时相同
+// （见 configsig.go）。新配置在两次检查之间、持有 control.busy 时整体应用，之后立即检查一次：
+//   - channel：主应用与没有单独配置渠道的应用改用新渠道；
+//   - alias：主应用改为跟随新的版本别名，清空后回到渠道最新版本；
+//   - check_every_seconds、check_jitter_percent：检查定时器按新间隔重置；
+//   - server_url：检查、下载、上报与透明日志校验改用新地址，令牌只附加在发往新主机的请求上。
+// 运行中的算法不重启，进行中的更新与下载在结束后才会看到新配置。其它字段的修改需要重启 agent，
+// 热加载时逐项记录后忽略；新配置无法读取、签名无效或取值不合法时整体放弃，保留当前配置。
+
+// configPoll 是检查配置文件是否变化的间隔。
+const configPoll = 5 * time.Second
+
+// hotFields 是无需重启即可生效的配置项（json 名）。
+var hotFields = map[string]bool{
+	"channel":              true,
+	"alias":                true,
+	"check_every_seconds":  true,
+	"check_jitter_percent": true,
+	"server_url":           true,
+}
+
+// configWatcher 在收到 SIGHUP 或配置文件变化时通知主循环重新加载。
+type configWatcher struct {
+	path   string
+	loaded *Config // 上次加载的配置（未经 setup），用于找出改动的字段
+	stamp  string
+	hup    chan os.Signal
+	reload chan struct{}
+}
+
+// watchConfig watches the config file at fp (none when empty) and SIGHUP.
+func watchConfig(fp string) *configWatcher {
+	w := &configWatcher{path: fp, hup: make(chan os.Signal, 1), reload: make(chan struct{}, 1)}
+	signal.Notify(w.hup, syscall.SIGHUP)
+	if fp != "" {
+		var err error
+		if w.loaded, err = loadConfig(fp); err != nil {
+			log.Printf("config reload disabled: %v", err)
+			w.path = ""
+		}
+		w.stamp = configStamp(fp)
+	}
+	go w.run()
+	return w
+}
+
+func (w *configWatcher) run() {
+	var poll <-chan time.Time
+	if w.path != "" {
+		t := clk.NewTicker(configPoll)
+		defer t.Stop()
+		poll = t.C()
+	}
+	for {
+		select {
+		case <-w.hup:
+			if w.path == "" {
+				log.Printf("received SIGHUP, but there is no config file to reload")
+				continue
+			}
+			log.Printf("received SIGHUP, reloading %s", w.path)
+		case <-poll:
+			st := configStamp(w.path)
+			if st == w.stamp {
+				continue
+			}
+			w.stamp = st
+			log.Printf("%s changed, reloading", w.path)
+		}
+		select {
+		case w.reload <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// configStamp identifies the current content of the config file and its
+// signature by modification time and size.
+func configStamp(fp string) string {
+	var b strings.Builder
+	for _, p := range []string{fp, configSigPath(fp)} {
+		if fi, err := os.Stat(p); err == nil {
+			fmt.Fprintf(&b, "%d/%d;", fi.ModTime().UnixNano(), fi.Size())
+		} else {
+			b.WriteString("-;")
+		}
+	}
+	return b.String()
+}
+
+// apply reloads the config file and applies its hot fields to the running
+// cfg. The caller must hold control.busy. It reports whether the check
+// interval changed.
+func (w *configWatcher) apply(cfg *Config) (intervalChanged bool) {
+	n, err := loadConfig(w.path)
+	if err != nil {
+		log.Printf("config reload: %v; keeping the current config", err)
+		return false
+	}
+	changed, err := configChanges(w.loaded, n)
+	if err != nil {
+		log.Printf("config reload: %v; keeping the current config", err)
+		return false
+	}
+	if n.CheckEvery <= 0 {
+		n.CheckEvery = 10
+	}
+	if err := checkJitter(n); err != nil {
+		log.Printf("config reload: %v; keeping the current config", err)
+		return false
+	}
+	var host string
+	if slices.Contains(changed, "server_url") {
+		if host, err = checkServerURL(cfg, n.ServerURL); err != nil {
+			log.Printf("config reload: %v; keeping the current config", err)
+			return false
+		}
+		// server_ip 只在启动时绑定到 server_url 的主机（见 resolver.go），换主机需要重启
+		if cfg.ServerIP != "" && !sameHostname(cfg.ServerURL, n.ServerURL) {
+			log.Printf("config reload: server_url host changed while server_ip is set, restart the agent to apply it; keeping the current config")
+			return false
+		}
+	}
+	if n.Alias != "" && sourceName(cfg) != sourceServer {
+		log.Printf("config reload: alias needs the server update source; keeping the current config")
+		return false
+	}
+	w.loaded = n
+	for _, k := range changed {
+		if !hotFields[k] {
+			log.Printf("config reload: %s changed, restart the agent to apply it", k)
+		}
+	}
+	if slices.Contains(changed, "channel") && n.Channel != cfg.Channel {
+		log.Printf("config reload: channel %s -> %s", cfg.Channel, n.Channel)
+		cfg.Channel = n.Channel
+	}
+	if n.Alias != cfg.Alias {
+		log.Printf("config reload: alias %q -> %q", cfg.Alias, n.Alias)
+		cfg.Alias = n.Alias
+	}
+	if n.CheckEvery != cfg.CheckEvery {
+		log.Printf("config reload: check_every_seconds %d -> %d", cfg.CheckEvery, n.CheckEvery)
+		cfg.CheckEvery = n.CheckEvery
+		intervalChanged = true
+	}
+	if n.CheckJitter != cfg.CheckJitter {
+		log.Printf("config reload: check_jitter_percent %d -> %d", cfg.CheckJitter, n.CheckJitter)
+		cfg.CheckJitter = n.CheckJitter
+		intervalChanged = true
+	}
+	if host != "" && n.ServerURL != cfg.ServerURL {
+		log.Printf("config reload: server_url %s -> %s", cfg.ServerURL, n.ServerURL)
+		cfg.ServerURL = n.ServerURL
+		if t, ok := httpClient.Transport.(*serverTransport); ok {
+			t.host.Store(&host)
+		}
+		reports.setURL(eventsURL(cfg))
+		algoLog.setTarget(cfg.ServerURL, cfg.DeviceID)
+	}
+	reloadApps(cfg)
+	return intervalChanged
+}
+
+// checkServerURL validates a reloaded server_url and returns its host.
+func checkServerURL(cfg *Config, u string) (string, error) {
+	if u == "" {
+		if cfg.Source == "" || cfg.Source == sourceServer {
+			return "", errors.New("server_url must not be empty with the server update source")
+		}
+		return "", nil
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", fmt.Errorf("server_url: %w", err)
+	}
+	if pu.Host == "" {
+		return "", fmt.Errorf("server_url %q has no host", u)
+	}
+	return pu.Host, nil
+}
+
+// sameHostname reports whether two server URLs name the same host, ignoring
+// the port.
+func sameHostname(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && strings.EqualFold(ua.Hostname(), ub.Hostname())
+}
+
+// reloadApps copies the reloaded server URL and channel into the apps' and
+// the agent self-update configs; those with their own channel keep it.
+func reloadApps(cfg *Config) {
+	for _, a := range apps {
+		own := ""
+		for _, ac := range cfg.Apps {
+			if ac.Name == a.name {
+				own = ac.Channel
+			}
+		}
+		a.mu.Lock()
+		a.cfg.ServerURL = cfg.ServerURL
+		if own == "" {
+			a.cfg.Channel = cfg.Channel
+		}
+		a.mu.Unlock()
+	}
+	if selfUpd != nil {
+		selfUpd.cfg.ServerURL = cfg.ServerURL
+		if cfg.SelfUpdate.Channel == "" {
+			selfUpd.cfg.Channel = cfg.Channel
+		}
+	}
+}
+
+// configChanges returns the json names of the fields that differ between
+// two configs, sorted.
+func configChanges(old, cur *Config) ([]string, error) {
+	fields := func(c *Config) (map[string]json.RawMessage, error) {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]json.RawMessage
+		return m, json.Unmarshal(b, &m)
+	}
+	a, err := fields(old)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fields(cur)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for k, v := range b {
+		if !bytes.Equal(a[k], v) {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
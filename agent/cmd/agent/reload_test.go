@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadKeepsServerHostPinnedByServerIP(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "agent.json")
+	write := func(body string) {
+		if err := os.WriteFile(fp, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"server_url": "https://ota.example.com/api/v1", "server_ip": "10.0.0.5"}`)
+	loaded, err := loadConfig(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := *loaded
+	w := &configWatcher{path: fp, loaded: loaded}
+
+	// server_ip 绑定的是原来的主机，换主机的热加载被拒绝
+	write(`{"server_url": "https://ota2.example.com/api/v1", "server_ip": "10.0.0.5"}`)
+	w.apply(&cfg)
+	if cfg.ServerURL != "https://ota.example.com/api/v1" {
+		t.Fatalf("server_url reloaded to %s while server_ip is set", cfg.ServerURL)
+	}
+}
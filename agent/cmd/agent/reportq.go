@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 上报队列：安装结果、心跳与崩溃事件先写入 <install_dir>/report_queue.json，再由后台批量发送到
+// 服务端 /devices/<id>/events。服务端不可达时事件留在磁盘上按退避重试，agent 重启也不丢失；
+// 每条事件带去重键，确认丢失导致的重传只会被服务端记录一次。
+// 心跳等遥测按 telemetry_upload_seconds 周期合并上传，安装结果与崩溃立即发送；请求体默认 gzip 压缩。
+
+const (
+	defaultReportQueueMax = 500
+	reportBatch           = 100
+	reportRetryMin        = 5 * time.Second
+	reportRetryMax        = 10 * time.Minute
+
+	defaultTelemetryEvery = 60 * time.Second
+)
+
+// queuedEvent 对应服务端的 DeviceEvent。
+type queuedEvent struct {
+	Key     string         `json:"key"`
+	Type    string         `json:"type"` // report | heartbeat | crash | diagnostics | shadow
+	Time    time.Time      `json:"time"`
+	Channel string         `json:"channel,omitempty"`
+	Version string         `json:"version,omitempty"`
+	Data    map[string]any `json:"data,omitempty"`
+}
+
+type reportQueue struct {
+	mu     sync.Mutex
+	path   string
+	max    int
+	url    string
+	events []queuedEvent
+	wake   chan struct{}
+	every  time.Duration // 周期上传间隔
+	gzip   bool
+	sample map[string]float64
+	// backoff 期间新事件不触发重试，只有 kick（网络已恢复的迹象）会提前重试
+	backingOff atomic.Bool
+}
+
+// reports 在没有 server_url 或 device_id 时为 nil，此时不上报。
+var reports *reportQueue
+
+func eventsURL(cfg *Config) string {
+	return cfg.ServerURL + "/devices/" + url.PathEscape(cfg.DeviceID) + "/events"
+}
+
+func newReportQueue(cfg *Config) (*reportQueue, error) {
+	if cfg.ServerURL == "" || cfg.DeviceID == "" {
+		return nil, nil
+	}
+	q := &reportQueue{
+		path:   filepath.Join(cfg.StateDir, "report_queue.json"),
+		max:    cfg.ReportQueueMax,
+		url:    eventsURL(cfg),
+		wake:   make(chan struct{}, 1),
+		every:  time.Duration(cfg.TelemetryEvery) * time.Second,
+		sample: cfg.TelemetrySampling,
+	}
+	if q.max <= 0 {
+		q.max = defaultReportQueueMax
+	}
+	if q.every <= 0 {
+		q.every = defaultTelemetryEvery
+	}
+	switch cfg.TelemetryCompression {
+	case "", "gzip":
+		q.gzip = true
+	case "none":
+	default:
+		return nil, fmt.Errorf("telemetry_compression %q: want gzip or none", cfg.TelemetryCompression)
+	}
+	b, err := os.ReadFile(q.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &q.events); err != nil {
+			// 队列损坏不应阻止 agent 启动，丢弃并从头开始
+			log.Printf("report queue %s unreadable, starting empty: %v", q.path, err)
+			q.events = nil
+		}
+	}
+	return q, nil
+}
+
+func newEventKey() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("t%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// enqueue persists ev (replacing a queued event with the same key). Reports
+// and crashes wake the sender; heartbeats wait for the periodic upload. When
+// the queue is full the oldest events are dropped.
+func (q *reportQueue) enqueue(ev queuedEvent) {
+	if q == nil || !sampled(q.sample, ev.Type) {
+		return
+	}
+	if ev.Key == "" {
+		ev.Key = newEventKey()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = clk.Now()
+	}
+	q.mu.Lock()
+	if ev.Type == "heartbeat" && q.backingOff.Load() {
+		// 离线期间只保留最新的心跳
+		kept := q.events[:0]
+		for _, e := range q.events {
+			if e.Type != "heartbeat" {
+				kept = append(kept, e)
+			}
+		}
+		q.events = kept
+	}
+	replaced := false
+	for i := range q.events {
+		if q.events[i].Key == ev.Key {
+			q.events[i], replaced = ev, true
+			break
+		}
+	}
+	if !replaced {
+		q.events = append(q.events, ev)
+	}
+	for len(q.events) > q.max {
+		log.Printf("report queue full, dropping %s event from %s", q.events[0].Type, q.events[0].Time.Format(time.RFC3339))
+		q.events = q.events[1:]
+	}
+	err := q.saveLocked()
+	q.mu.Unlock()
+	if err != nil {
+		log.Printf("report queue save: %v", err)
+	}
+	if ev.Type != "heartbeat" && !q.backingOff.Load() {
+		q.kick()
+	}
+}
+
+// online cuts a pending backoff short once the server answered again.
+func (q *reportQueue) online() {
+	if q != nil && q.backingOff.Load() {
+		q.kick()
+	}
+}
+
+// kick asks the sender to upload now.
+func (q *reportQueue) kick() {
+	if q == nil {
+		return
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *reportQueue) saveLocked() error {
+	b, err := json.Marshal(q.events)
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+// run uploads queued events every q.every (or when woken), backing off
+// exponentially while the server is unreachable.
+func (q *reportQueue) run() {
+	if q == nil {
+		return
+	}
+	backoff := reportRetryMin
+	for {
+		err := q.flush()
+		wait := time.Duration(0)
+		if err != nil {
+			log.Printf("report upload failed (retry in %s): %v", backoff, err)
+			wait = backoff
+			if backoff *= 2; backoff > reportRetryMax {
+				backoff = reportRetryMax
+			}
+		} else {
+			backoff = reportRetryMin
+		}
+		q.backingOff.Store(wait > 0)
+		if wait == 0 {
+			wait = q.every
+		}
+		select {
+		case <-clk.After(wait):
+		case <-q.wake:
+		}
+	}
+}
+
+// flush uploads batches until the queue is empty or a request fails.
+func (q *reportQueue) flush() error {
+	for {
+		q.mu.Lock()
+		n := len(q.events)
+		if n > reportBatch {
+			n = reportBatch
+		}
+		batch := append([]queuedEvent(nil), q.events[:n]...)
+		u := q.url
+		q.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if err := q.post(u, batch); err != nil {
+			return err
+		}
+		// 发送期间可能有同键事件被替换，只移除发送时的那一份
+		sent := map[string]time.Time{}
+		for _, e := range batch {
+			sent[e.Key] = e.Time
+		}
+		q.mu.Lock()
+		kept := q.events[:0]
+		for _, e := range q.events {
+			if t, ok := sent[e.Key]; ok && t.Equal(e.Time) {
+				continue
+			}
+			kept = append(kept, e)
+		}
+		q.events = kept
+		err := q.saveLocked()
+		q.mu.Unlock()
+		if err != nil {
+			log.Printf("report queue save: %v", err)
+		}
+	}
+}
+
+func (q *reportQueue) post(u string, batch []queuedEvent) error {
+	body, err := json.Marshal(map[string]any{"events": batch})
+	if err != nil {
+		return err
+	}
+	if q.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if q.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return nil
+}
+
+// setURL redirects uploads after the server assigned a new device ID.
+func (q *reportQueue) setURL(u string) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.url = u
+	q.mu.Unlock()
+}
+
+// pending returns the number of queued events (local status API).
+func (q *reportQueue) pending() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events)
+}
+
+// reportInstall queues the outcome of an update attempt. Identical failures
+// share a key, so a release that keeps failing every check is reported once.
+func reportInstall(from string, rel *Release, t *phaseTimer, errp *error) {
+	if t.deferred && *errp == nil {
+		ready.setPhase(updateIdle, "")
+		return
+	}
+	status, msg := "success", ""
+	var stopped *stoppedError
+	switch {
+	case errors.As(*errp, &stopped):
+		status, msg = "cancelled", (*errp).Error()
+	case *errp != nil:
+		status, msg = "failure", (*errp).Error()
+	}
+	ready.finished(updateResult{From: from, To: rel.Version, Status: status, Error: msg, At: clk.Now()})
+	metrics.installed("", status)
+	sum := sha256.Sum256([]byte(msg))
+	data := map[string]any{
+		"status":      status,
+		"from":        from,
+		"to":          rel.Version,
+		"error":       msg,
+		"duration_ms": clk.Since(t.started).Milliseconds(),
+		// 各阶段耗时（见 phases.go），失败时只有已完成的阶段
+		"phases_ms": t.phases,
+		// 实际提供制品的主机，用于评估区域镜像的效果
+		"download_host": rel.fetchedFrom,
+	}
+	if t.healthy != nil {
+		data["health_confirmed"] = *t.healthy
+	}
+	if t.rolledBack != "" {
+		data["rolled_back_to"] = t.rolledBack
+	}
+	reports.enqueue(queuedEvent{
+		Key:     fmt.Sprintf("install:%s:%s:%s:%x", from, rel.Version, status, sum[:4]),
+		Type:    "report",
+		Channel: rel.Channel,
+		Version: from,
+		Data:    data,
+	})
+}
+
+// reportDeferral queues why an update to rel was not started. Deferrals of
+// the same kind share a key, so a device waiting for its battery to charge
+// reports it once per release.
+func reportDeferral(from string, rel *Release, kind, reason string) {
+	data := map[string]any{
+		"status": "deferred",
+		"from":   from,
+		"to":     rel.Version,
+		"reason": reason,
+	}
+	ev := queuedEvent{
+		Key:     fmt.Sprintf("defer:%s:%s:%s", from, rel.Version, kind),
+		Type:    "report",
+		Channel: rel.Channel,
+		Version: from,
+		Data:    data,
+	}
+	if rel.App != "" {
+		// 事件的 version 是主应用的版本，服务端据此统计设备版本；应用的版本在 from / to 中
+		data["app"] = rel.App
+		ev.Version = readCurrentVersion()
+	}
+	reports.enqueue(ev)
+}
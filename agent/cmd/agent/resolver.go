@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fallbackDelay 是 Happy Eyeballs（RFC 8305）中启动另一地址族的延迟。
+const fallbackDelay = 300 * time.Millisecond
+
+type dnsEntry struct {
+	Addrs    []string  `json:"addrs"`
+	Resolved time.Time `json:"resolved"`
+}
+
+// dnsCache 缓存服务端域名解析结果并持久化到磁盘；解析失败时回退到最近一次成功的地址，
+// 保证 DNS 故障期间仍能下发紧急回滚。
+type dnsCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	file string
+	// 配置了 server_ip 时拨 server_url 的主机直接用该地址，跳过 DNS；
+	// 同一拨号器也连 registry、镜像等其它主机，它们照常解析
+	staticIP   string
+	staticHost string
+	family     string // auto | ipv4 | ipv6
+	entries    map[string]*dnsEntry
+}
+
+func newDNSCache(ttl time.Duration, file, staticHost, staticIP, family string) *dnsCache {
+	c := &dnsCache{
+		ttl:        ttl,
+		file:       file,
+		staticIP:   staticIP,
+		staticHost: staticHost,
+		family:     family,
+		entries:    map[string]*dnsEntry{},
+	}
+	if b, err := os.ReadFile(file); err == nil {
+		_ = json.Unmarshal(b, &c.entries)
+	}
+	return c
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	if c.staticIP != "" && strings.EqualFold(host, c.staticHost) {
+		return []string{c.staticIP}, nil
+	}
+
+	c.mu.Lock()
+	e := c.entries[host]
+	c.mu.Unlock()
+	if e != nil && clk.Since(e.Resolved) < c.ttl {
+		return e.Addrs, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err == nil && len(addrs) > 0 {
+		c.mu.Lock()
+		c.entries[host] = &dnsEntry{Addrs: addrs, Resolved: clk.Now()}
+		c.saveLocked()
+		c.mu.Unlock()
+		return addrs, nil
+	}
+	if e != nil {
+		log.Printf("dns: resolve %s failed (%v), using last-known-good %v", host, err, e.Addrs)
+		return e.Addrs, nil
+	}
+	if err == nil {
+		err = errors.New("no addresses for " + host)
+	}
+	return nil, err
+}
+
+func (c *dnsCache) saveLocked() {
+	if c.file == "" {
+		return
+	}
+	b, err := json.Marshal(c.entries)
+	if err != nil {
+		return
+	}
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return
+	}
+	_ = os.Rename(tmp, c.file)
+}
+
+// DialContext resolves through the cache and connects Happy-Eyeballs style:
+// IPv6 addresses are tried first and IPv4 is raced in after fallbackDelay,
+// unless the configured family restricts dialing to one of them.
+func (c *dnsCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var v6, v4 []string
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+	switch c.family {
+	case "ipv4":
+		network, v6 = "tcp4", nil
+	case "ipv6":
+		network, v4 = "tcp6", nil
+	}
+	primaries, fallbacks := v6, v4
+	if len(primaries) == 0 {
+		primaries, fallbacks = v4, nil
+	}
+	if len(primaries) == 0 {
+		return nil, errors.New("no usable " + c.family + " address for " + host)
+	}
+
+	d := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	return dialHappyEyeballs(ctx, d, network, port, primaries, fallbacks)
+}
+
+func dialSerial(ctx context.Context, d *net.Dialer, network, port string, addrs []string) (net.Conn, error) {
+	var lastErr error
+	for _, a := range addrs {
+		conn, err := d.DialContext(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func dialHappyEyeballs(ctx context.Context, d *net.Dialer, network, port string, primaries, fallbacks []string) (net.Conn, error) {
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, d, network, port, primaries)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	race := func(addrs []string) {
+		go func() {
+			conn, err := dialSerial(ctx, d, network, port, addrs)
+			results <- result{conn, err}
+		}()
+	}
+
+	race(primaries)
+	pending, fallbackStarted := 1, false
+	fallback := clk.After(fallbackDelay)
+
+	var firstErr error
+	for {
+		select {
+		case <-fallback:
+			if !fallbackStarted {
+				race(fallbacks)
+				pending, fallbackStarted = pending+1, true
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					// 关闭竞速失败一方可能建立的连接
+					go func() {
+						if l := <-results; l.conn != nil {
+							_ = l.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !fallbackStarted {
+				race(fallbacks)
+				pending, fallbackStarted = pending+1, true
+				continue
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
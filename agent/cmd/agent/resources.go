@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// 算法进程资源占用：每 10 秒采样一次算法进程的 CPU、常驻内存（RSS）与打开的文件描述符数，
+// 两次心跳之间的样本汇总为均值与最大值随心跳上报（resources），服务端据此给出按版本的资源画像。
+// 仅 Linux 支持采样（读取 /proc），其它平台的心跳不带 resources。
+
+const resourceSampleEvery = 10 * time.Second
+
+type resourceUsage struct {
+	mu sync.Mutex
+	// 上一次采样的进程与 CPU 时间，用于计算区间内的 CPU 占用
+	lastPID   int
+	lastTicks uint64
+	lastAt    time.Time
+
+	samples        int
+	cpuSum, cpuMax float64 // 百分比，多核时可超过 100
+	rssSum, rssMax int64   // KB
+	fdsSum, fdsMax int
+}
+
+var usage resourceUsage
+
+// run samples the algorithm process until the agent exits.
+func (u *resourceUsage) run() {
+	t := clk.NewTicker(resourceSampleEvery)
+	defer t.Stop()
+	for {
+		<-t.C()
+		u.sample(algo.process())
+	}
+}
+
+func (u *resourceUsage) sample(pid int) {
+	if pid == 0 {
+		return
+	}
+	ticks, rss, fds, err := sampleProcess(pid)
+	if err != nil {
+		return
+	}
+	now := clk.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// 进程刚启动或换了进程时只记下基线，下一次采样才有 CPU 占用
+	if pid != u.lastPID || ticks < u.lastTicks || !now.After(u.lastAt) {
+		u.lastPID, u.lastTicks, u.lastAt = pid, ticks, now
+		return
+	}
+	cpu := float64(ticks-u.lastTicks) / clkTck / now.Sub(u.lastAt).Seconds() * 100
+	u.lastTicks, u.lastAt = ticks, now
+	u.samples++
+	u.cpuSum += cpu
+	u.cpuMax = math.Max(u.cpuMax, cpu)
+	u.rssSum += rss
+	u.rssMax = max(u.rssMax, rss)
+	u.fdsSum += fds
+	u.fdsMax = max(u.fdsMax, fds)
+}
+
+// take returns the aggregates since the previous call for a heartbeat and
+// starts a new window; nil when there were no samples.
+func (u *resourceUsage) take() map[string]any {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.samples == 0 {
+		return nil
+	}
+	n := float64(u.samples)
+	out := map[string]any{
+		"samples":     u.samples,
+		"cpu_pct":     math.Round(u.cpuSum/n*10) / 10,
+		"cpu_pct_max": math.Round(u.cpuMax*10) / 10,
+		"rss_kb":      u.rssSum / int64(u.samples),
+		"rss_kb_max":  u.rssMax,
+		"fds":         u.fdsSum / u.samples,
+		"fds_max":     u.fdsMax,
+	}
+	u.samples, u.cpuSum, u.cpuMax, u.rssSum, u.rssMax, u.fdsSum, u.fdsMax = 0, 0, 0, 0, 0, 0, 0
+	return out
+}
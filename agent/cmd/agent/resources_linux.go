@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clkTck 是 /proc/<pid>/stat 中 CPU 时间的单位（USER_HZ），Linux 上各架构均为 100。
+const clkTck = 100
+
+// procStat returns the fields of /proc/<pid>/stat after the command name,
+// so f[0] is field 3 (state) in proc(5).
+func procStat(pid int) ([]string, error) {
+	fp := "/proc/" + strconv.Itoa(pid) + "/stat"
+	stat, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	// 进程名可能包含空格与括号，从最后一个 ')' 之后开始按字段切分
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return nil, errors.New("malformed " + fp)
+	}
+	f := strings.Fields(string(stat[i+1:]))
+	if len(f) < 20 {
+		return nil, errors.New("malformed " + fp)
+	}
+	return f, nil
+}
+
+// processStart returns the start time of pid in clock ticks since boot; with
+// the PID it identifies a process across PID reuse.
+func processStart(pid int) (uint64, error) {
+	f, err := procStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	// 僵尸进程已经退出，只是尚未被回收
+	if f[0] == "Z" {
+		return 0, errors.New("process " + strconv.Itoa(pid) + " has exited")
+	}
+	return strconv.ParseUint(f[19], 10, 64)
+}
+
+// sampleProcess reads the CPU time (in clock ticks), resident set size (KB)
+// and number of open file descriptors of pid from /proc.
+func sampleProcess(pid int) (ticks uint64, rssKB int64, fds int, err error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	f, err := procStat(pid)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	utime, err1 := strconv.ParseUint(f[11], 10, 64)
+	stime, err2 := strconv.ParseUint(f[12], 10, 64)
+	if err := errors.Join(err1, err2); err != nil {
+		return 0, 0, 0, err
+	}
+
+	status, err := os.Open(dir + "/status")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer status.Close()
+	sc := bufio.NewScanner(status)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "VmRSS:"); ok {
+			rssKB, _ = strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			break
+		}
+	}
+
+	ents, err := os.ReadDir(dir + "/fd")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return utime + stime, rssKB, len(ents), nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+const clkTck = 100
+
+// sampleProcess 在非 Linux 平台上没有 /proc 可读，不采样。
+func sampleProcess(pid int) (ticks uint64, rssKB int64, fds int, err error) {
+	return 0, 0, 0, errors.New("process sampling is only supported on linux")
+}
+
+// processStart 在非 Linux 平台上无法确认进程身份，调用方视为进程已不存在。
+func processStart(pid int) (uint64, error) {
+	return 0, errors.New("process identity is only supported on linux")
+}
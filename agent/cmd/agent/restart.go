@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// 崩溃重启：算法（及 apps 中的应用）意外退出后由 supervisor 自动重启，等待时间从 initial_backoff_seconds
+// （默认 1）起每次加倍，最长 max_backoff_seconds（默认 300）；进程连续运行 restartStableUptime 后恢复初始等待。
+// 重启次数计入本地 API /status 的 algorithm.restarts 与崩溃事件。主应用在 window_minutes（默认 10）内
+// 崩溃超过 max_crashes（默认 5）次即判为崩溃循环：切回上一个版本槽位（同强制回滚，见 slots.go），
+// 把该版本记为坏版本并上报 status=rolled_back；没有上一个版本时继续按退避重启。更新进行中时由更新后
+// 健康检查负责回滚，这次崩溃循环不算已处理，之后的崩溃再次尝试；其它检查或操作持有 control.busy 时等它结束。
+// agent 停止、替换或重新启动进程后重新计数；其它应用只重启，不回滚。
+
+// RestartConfig 是意外退出后的自动重启与崩溃循环回滚。
+type RestartConfig struct {
+	Disabled       bool `json:"disabled"`                // 为 true 时不自动重启
+	InitialBackoff int  `json:"initial_backoff_seconds"` // 缺省 1
+	MaxBackoff     int  `json:"max_backoff_seconds"`     // 缺省 300
+	MaxCrashes     int  `json:"max_crashes"`             // 缺省 5
+	WindowMinutes  int  `json:"window_minutes"`          // 缺省 10
+}
+
+// restartStableUptime 之后的退出不再沿用上一次的退避时间。
+const restartStableUptime = 5 * time.Minute
+
+func checkRestartConfig(c *RestartConfig) error {
+	if c.InitialBackoff < 0 || c.MaxBackoff < 0 || c.MaxCrashes < 0 || c.WindowMinutes < 0 {
+		return fmt.Errorf("restart: values must not be negative")
+	}
+	if c.InitialBackoff == 0 {
+		c.InitialBackoff = 1
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 300
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		return fmt.Errorf("restart: max_backoff_seconds %d is below initial_backoff_seconds %d", c.MaxBackoff, c.InitialBackoff)
+	}
+	if c.MaxCrashes == 0 {
+		c.MaxCrashes = 5
+	}
+	if c.WindowMinutes == 0 {
+		c.WindowMinutes = 10
+	}
+	return nil
+}
+
+func (c RestartConfig) window() time.Duration { return time.Duration(c.WindowMinutes) * time.Minute }
+
+// restarter 是 owner goroutine 独占的重启状态。
+type restarter struct {
+	cfg     RestartConfig
+	bin     string        // 当前程序，停止后为空
+	backoff time.Duration // 下一次重启前的等待
+	crashes []time.Time   // window 内的意外退出
+	handled bool          // 当前程序的崩溃循环已处理（已回滚或没有可回滚的版本）
+	looping bool          // 崩溃循环处理正在进行
+	timer   <-chan time.Time
+}
+
+// reset follows a start, adoption or stop by the agent: bin ("" after a
+// stop) starts with a clean crash history and no pending restart.
+func (r *restarter) reset(bin string) {
+	r.bin, r.backoff, r.crashes, r.handled, r.looping, r.timer = bin, 0, nil, false, false, nil
+}
+
+// looped records the outcome of the crash loop handling started for bin;
+// an unhandled loop is tried again at the next crash.
+func (r *restarter) looped(bin string, handled bool) {
+	if bin != r.bin || !r.looping {
+		return // 期间程序被停止或替换，已重新计数
+	}
+	r.looping, r.handled = false, handled
+}
+
+// crashed records an unexpected exit of a process that ran for uptime,
+// schedules the restart and reports whether the crashes now form a loop
+// that is neither handled nor being handled.
+func (r *restarter) crashed(uptime time.Duration) (delay time.Duration, loop bool) {
+	if r.cfg.Disabled || r.bin == "" {
+		return 0, false
+	}
+	now := clk.Now()
+	cutoff := now.Add(-r.cfg.window())
+	for len(r.crashes) > 0 && r.crashes[0].Before(cutoff) {
+		r.crashes = r.crashes[1:]
+	}
+	r.crashes = append(r.crashes, now)
+	switch {
+	case r.backoff == 0 || uptime >= restartStableUptime:
+		r.backoff = time.Duration(r.cfg.InitialBackoff) * time.Second
+	default:
+		r.backoff = min(2*r.backoff, time.Duration(r.cfg.MaxBackoff)*time.Second)
+	}
+	r.timer = clk.After(r.backoff)
+	if len(r.crashes) > r.cfg.MaxCrashes && !r.handled && !r.looping {
+		r.looping = true
+		return r.backoff, true
+	}
+	return r.backoff, false
+}
+
+// crashLoop switches the primary app back to the previous slot after
+// version crashed too often and reports whether the loop is handled. During
+// an update the post-update health check rolls back instead; anything else
+// holding control.busy is waited for.
+func crashLoop(version string, cfg RestartConfig) bool {
+	if v := inflight.updating(); v != "" {
+		log.Printf("algorithm %s is crash-looping during the update to %s; the post-update health check handles it", version, v)
+		return false
+	}
+	control.busy.Lock()
+	defer control.busy.Unlock()
+	if readCurrentVersion() != version {
+		return true // 期间已被更新或回滚
+	}
+	if slots.previous(version) == "" {
+		log.Printf("algorithm %s is crash-looping (more than %d crashes within %d minutes) and there is no previous version; restarting with backoff",
+			version, cfg.MaxCrashes, cfg.WindowMinutes)
+		return true
+	}
+	_, to, err := control.switchBack(rollbackCrashLoop)
+	if err != nil {
+		log.Printf("algorithm %s is crash-looping (more than %d crashes within %d minutes): %v; retrying at the next crash",
+			version, cfg.MaxCrashes, cfg.WindowMinutes, err)
+		return false
+	}
+	log.Printf("algorithm %s was crash-looping, switched back to %s", version, to)
+	return true
+}
@@ -0,0 +1,26 @@
+package main
+
+// 安全影响等级，与服务端结构化发布说明一致，按影响从低到高排序。
+var safetyRank = map[string]int{"none": 0, "low": 1, "high": 2, "experimental": 3}
+
+// maxSafety returns the highest safety impact the agent installs unattended.
+func maxSafety(cfg *Config) string {
+	if cfg.MaxSafetyImpact != "" {
+		return cfg.MaxSafetyImpact
+	}
+	if cfg.Channel == "beta" {
+		return "experimental"
+	}
+	return "high"
+}
+
+// safetyAllowed reports whether a release of the given safety impact may be
+// installed automatically. Releases without one (older servers) count as
+// "none"; levels this agent does not know are held.
+func safetyAllowed(cfg *Config, safety string) bool {
+	if safety == "" {
+		safety = "none"
+	}
+	rank, ok := safetyRank[safety]
+	return ok && rank <= safetyRank[maxSafety(cfg)]
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 版本暂存目录：算法曾把临时文件写在二进制旁边，安装目录只读时直接出错。每个版本安装时创建独立的
+// 可写目录 <install_dir>/scratch/<version>/，算法以它为工作目录启动，路径同时经环境变量
+// ALGO_SCRATCH_DIR 与 TMPDIR 传入。目录大小限制为 scratch_max_mb（默认 512），每 30 秒检查一次，
+// 超出时按修改时间从旧到新删除文件直到低于上限；用量随心跳上报（scratch）。更新成功后只保留
+// 当前与上一个版本的目录，回滚后删除坏版本的目录。
+
+const (
+	scratchEnv           = "ALGO_SCRATCH_DIR"
+	defaultScratchMaxMB  = 512
+	scratchCheckInterval = 30 * time.Second
+)
+
+type scratchSpace struct {
+	mu       sync.Mutex
+	root     string // <install_dir>/scratch
+	maxBytes int64
+	active   string // 算法当前（或即将）运行的版本
+	used     int64  // 最近一次检查时 active 目录的大小
+}
+
+var scratch scratchSpace
+
+func (s *scratchSpace) configure(cfg *Config) {
+	if cfg.ScratchMaxMB <= 0 {
+		cfg.ScratchMaxMB = defaultScratchMaxMB
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.root = filepath.Join(cfg.StateDir, "scratch")
+	s.maxBytes = int64(cfg.ScratchMaxMB) << 20
+}
+
+// prepare creates the scratch directory of version and makes it the one
+// handed to the algorithm when it is next started.
+func (s *scratchSpace) prepare(version string) error {
+	if version == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Join(s.root, version), 0o755); err != nil {
+		return err
+	}
+	s.active, s.used = version, 0
+	return nil
+}
+
+// dir returns the scratch directory of the active version, "" before any
+// version was prepared.
+func (s *scratchSpace) dir() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == "" {
+		return ""
+	}
+	return filepath.Join(s.root, s.active)
+}
+
+// prune wipes the scratch directories of all versions but keep.
+func (s *scratchSpace) prune(keep ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		kept := false
+		for _, k := range keep {
+			kept = kept || e.Name() == k
+		}
+		if !kept {
+			if err := os.RemoveAll(filepath.Join(s.root, e.Name())); err != nil {
+				log.Printf("scratch: %v", err)
+			}
+		}
+	}
+}
+
+// run enforces the size cap until the agent exits.
+func (s *scratchSpace) run() {
+	t := clk.NewTicker(scratchCheckInterval)
+	defer t.Stop()
+	for {
+		<-t.C()
+		s.enforce()
+	}
+}
+
+// enforce measures the active directory and deletes its oldest files while
+// it is over the cap.
+func (s *scratchSpace) enforce() {
+	dir := s.dir()
+	if dir == "" {
+		return
+	}
+	type file struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var files []file
+	var total int64
+	_ = filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			files = append(files, file{fp, fi.Size(), fi.ModTime()})
+			total += fi.Size()
+		}
+		return nil
+	})
+	s.mu.Lock()
+	limit := s.maxBytes
+	s.mu.Unlock()
+	if total > limit {
+		sort.Slice(files, func(i, j int) bool { return files[i].mod.Before(files[j].mod) })
+		before, removed := total, 0
+		for _, f := range files {
+			if total <= limit {
+				break
+			}
+			if os.Remove(f.path) == nil {
+				total -= f.size
+				removed++
+			}
+		}
+		log.Printf("scratch %s: %d bytes over the %d byte cap, removed %d oldest files", dir, before-limit, limit, removed)
+	}
+	s.mu.Lock()
+	if filepath.Join(s.root, s.active) == dir {
+		s.used = total
+	}
+	s.mu.Unlock()
+}
+
+// stats is the scratch usage reported with heartbeats.
+func (s *scratchSpace) stats() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == "" {
+		return nil
+	}
+	return map[string]any{"version": s.active, "bytes": s.used, "max_bytes": s.maxBytes}
+}
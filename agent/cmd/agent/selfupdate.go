@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// agent 自更新：服务端以 app=agent 组件托管 agent 自身的程序（见服务端 apps.go）。配置 self_update.enabled
+// 后，主循环每 check_every_minutes（默认 60）以构建时注入的版本（-ldflags "-X main.agentVersion=1.4.0"）
+// 检查一次，渠道缺省同顶层 channel。新程序与算法一样经 sha256、制品签名、cosign 与透明日志校验后保存为
+// <state_dir>/agent/ota-agent-<version>，出厂程序本身不修改（根文件系统可以只读）。
+//   - 切换前先以 -version 试运行新程序，无法运行或报告的版本不符即放弃，该版本记为坏版本；
+//   - 随后在 self_update.json 中记下待确认的新版本与回滚目标，原地 exec 新程序：进程号不变，init 系统无感知，
+//     算法与其它应用不重启，由新 agent 按 algo.pid 接管（见 detach.go），影子进程先停止；
+//   - 新 agent 进入主循环即确认，上报 status=success 的安装报告（data.app 为 agent）；
+//   - 新 agent 确认前退出时，init 系统重新拉起的出厂程序发现待确认的版本已启动过，即回滚：该版本记为坏版本，
+//     不再安装，改用回滚目标，上报 status=rolled_back。因此用 systemd 管理时须配置 Restart=always。
+// 出厂程序每次启动时若记有已确认的版本，就 exec 到该版本，重启与断电后仍运行更新后的 agent；
+// 关闭 self_update 后运行出厂程序。
+
+const (
+	// agentApp 是服务端托管 agent 程序的组件名。
+	agentApp      = "agent"
+	selfStateFile = "self_update.json"
+	// selfExecEnv 记录 exec 的目标版本，程序报告的版本与之不符时不再反复 exec。
+	selfExecEnv = "DRONEALGO_AGENT_EXEC"
+	// selfTrialTimeout 限制切换前试运行新程序的时间。
+	selfTrialTimeout = 10 * time.Second
+	// maxBadAgents 是记住的坏版本数。
+	maxBadAgents = 10
+)
+
+// agentVersion 由构建时注入，为 dev 时不自更新。
+var agentVersion = "dev"
+
+// SelfUpdateConfig 是 agent 自更新的配置。
+type SelfUpdateConfig struct {
+	Enabled           bool   `json:"enabled"`
+	Channel           string `json:"channel"`             // 缺省同顶层 channel
+	CheckEveryMinutes int    `json:"check_every_minutes"` // 默认 60
+}
+
+// selfUpdateState 是 <state_dir>/agent/self_update.json 的内容。
+type selfUpdateState struct {
+	Current    string   `json:"current"`               // 使用中的版本，空为出厂程序
+	Previous   string   `json:"previous"`              // 回滚目标，空为出厂程序
+	From       string   `json:"from"`                  // 切换前运行的版本
+	Pending    bool     `json:"pending"`               // Current 尚未确认启动成功
+	Attempts   int      `json:"attempts"`              // 已 exec 待确认版本的次数
+	RolledBack string   `json:"rolled_back,omitempty"` // 启动时回滚掉、尚未上报的版本
+	Bad        []string `json:"bad,omitempty"`
+}
+
+// selfUpdater 是自更新的运行状态，只在主循环中使用。
+type selfUpdater struct {
+	dir     string
+	cfg     *Config // 顶层配置的副本，app 为 agent
+	src     updateSource
+	every   time.Duration
+	checked time.Time
+	st      selfUpdateState
+}
+
+// selfUpd 在未开启自更新时为 nil。
+var selfUpd *selfUpdater
+
+func configureSelfUpdate(cfg *Config) error {
+	sc := cfg.SelfUpdate
+	if !sc.Enabled {
+		selfUpd = nil
+		return nil
+	}
+	if cfg.Source != "" && cfg.Source != sourceServer {
+		return errors.New("self_update needs the server update source")
+	}
+	if agentVersion == "" || agentVersion == "dev" {
+		log.Printf("self_update: this agent was built without a version (-X main.agentVersion), not updating it")
+		selfUpd = nil
+		return nil
+	}
+	if sc.CheckEveryMinutes <= 0 {
+		sc.CheckEveryMinutes = 60
+	}
+	c := *cfg
+	c.app, c.Apps = agentApp, nil
+	if sc.Channel != "" {
+		c.Channel = sc.Channel
+	}
+	u := &selfUpdater{
+		dir:   filepath.Join(cfg.StateDir, "agent"),
+		cfg:   &c,
+		src:   &serverSource{cfg: &c},
+		every: time.Duration(sc.CheckEveryMinutes) * time.Minute,
+	}
+	if err := os.MkdirAll(u.dir, 0o755); err != nil {
+		return err
+	}
+	if b, err := os.ReadFile(filepath.Join(u.dir, selfStateFile)); err == nil {
+		if err := json.Unmarshal(b, &u.st); err != nil {
+			return fmt.Errorf("%s: %w", selfStateFile, err)
+		}
+	}
+	selfUpd = u
+	return nil
+}
+
+func (u *selfUpdater) binary(version string) string {
+	return filepath.Join(u.dir, "ota-agent-"+version)
+}
+
+func (u *selfUpdater) save() {
+	fp := filepath.Join(u.dir, selfStateFile)
+	tmp := fp + ".tmp"
+	b, _ := json.MarshalIndent(u.st, "", "  ")
+	err := os.WriteFile(tmp, b, 0o644)
+	if err == nil {
+		err = os.Rename(tmp, fp)
+	}
+	if err != nil {
+		log.Printf("self_update: %v", err)
+	}
+}
+
+// boot runs before the agent takes the install lock: it rolls back a new
+// agent that started before without confirming, and execs the agent version
+// in use when it is not this program. It returns only when this program
+// should keep running.
+func (u *selfUpdater) boot() {
+	if u == nil {
+		return
+	}
+	st := &u.st
+	// 待确认的版本由 exec 它的一方计数：新程序可能根本运行不到这里
+	if st.Pending && st.Current != agentVersion && st.Attempts >= 1 {
+		log.Printf("self_update: agent %s exited before confirming its start, rolling back to %s", st.Current, agentLabel(st.Previous))
+		u.markBad(st.Current)
+		st.RolledBack = st.Current
+		st.Current, st.Pending, st.Attempts = st.Previous, false, 0
+		u.save()
+		u.prune()
+	}
+	if st.Current == "" || st.Current == agentVersion {
+		return
+	}
+	if !st.Pending && version.Newer(agentVersion, st.Current) {
+		// 出厂程序已随系统镜像升级，比记录的版本更新
+		log.Printf("self_update: factory agent %s is newer than %s, using it", agentVersion, st.Current)
+		st.Current, st.Previous = "", ""
+		u.save()
+		u.prune()
+		return
+	}
+	if _, err := os.Stat(u.binary(st.Current)); err != nil {
+		log.Printf("self_update: agent %s is missing (%v), running %s", st.Current, err, agentVersion)
+		st.Current, st.Pending = "", false
+		u.save()
+		return
+	}
+	if st.Pending {
+		// 切换途中断电：待确认的版本还没有启动过
+		st.Attempts++
+		u.save()
+	}
+	log.Printf("self_update: starting agent %s", st.Current)
+	err := u.exec(st.Current)
+	log.Printf("self_update: %v, running %s", err, agentVersion)
+}
+
+// exec replaces this process with the agent of version. It returns only on
+// failure.
+func (u *selfUpdater) exec(version string) error {
+	bin := u.binary(version)
+	if os.Getenv(selfExecEnv) == version {
+		return fmt.Errorf("%s reports version %s, not %s", bin, agentVersion, version)
+	}
+	env := []string{selfExecEnv + "=" + version}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, selfExecEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return fmt.Errorf("exec %s: %w", bin, syscall.Exec(bin, append([]string{bin}, os.Args[1:]...), env))
+}
+
+// confirm marks the running agent as started and reports the outcome of the
+// last switch.
+func (u *selfUpdater) confirm() {
+	if u == nil {
+		return
+	}
+	st := &u.st
+	changed := false
+	if st.Pending && st.Current == agentVersion {
+		log.Printf("self_update: agent %s started, switch from %s confirmed", agentVersion, agentLabel(st.From))
+		u.report(st.From, agentVersion, "success", "")
+		st.Pending, st.Attempts, changed = false, 0, true
+	}
+	if st.RolledBack != "" {
+		u.report(st.RolledBack, agentVersion, "rolled_back", "agent "+st.RolledBack+" did not start")
+		st.RolledBack, changed = "", true
+	}
+	if changed {
+		u.save()
+	}
+}
+
+// run checks for a new agent at most every check_every_minutes and switches
+// to it; the caller holds control.busy. It returns unless the switch happened.
+func (u *selfUpdater) run(ctx context.Context, cfg *Config) {
+	if u == nil || (!u.checked.IsZero() && clk.Since(u.checked) < u.every) {
+		return
+	}
+	u.checked = clk.Now()
+	// 主应用的检查可能已改用服务端重新分配的设备 ID
+	u.cfg.DeviceID = cfg.DeviceID
+	if err := u.runOnce(ctx); err != nil {
+		log.Printf("self_update: %v", err)
+	}
+}
+
+func (u *selfUpdater) runOnce(ctx context.Context) (err error) {
+	ck, err := u.src.Check(ctx, agentVersion)
+	metrics.checked(agentApp, err)
+	if err != nil {
+		return err
+	}
+	if !ck.UpdateAvailable || ck.Latest == nil {
+		return nil
+	}
+	rel := ck.Latest
+	rel.App = agentApp
+	rel.mandatory = ck.Mandatory
+	if slices.Contains(u.st.Bad, rel.Version) {
+		log.Printf("self_update: skipping agent %s: it failed to start before", rel.Version)
+		return nil
+	}
+	log.Printf("self_update: new agent version: %s (%s)", rel.Version, rel.Channel)
+	for _, h := range []struct{ kind, reason string }{
+		{"paused", control.paused()},
+		{"update_gate", gateHold()},
+		{"download_gate", downloadHold()},
+		{"data_cap", dataHold(rel)},
+	} {
+		if h.reason != "" {
+			log.Printf("self_update: deferring agent %s: %s", rel.Version, h.reason)
+			reportDeferral(agentVersion, rel, h.kind, h.reason)
+			return nil
+		}
+	}
+	if reason := gate.busy(); reason != "" {
+		log.Printf("self_update: deferring agent %s: %s", rel.Version, reason)
+		return nil
+	}
+	held := false
+	defer func() {
+		if err != nil && !held {
+			u.report(agentVersion, rel.Version, "failure", err.Error())
+		}
+	}()
+	if releaseFormat(rel) != backendBinary || rel.Launch != "" || rel.ArtifactType != "" {
+		return fmt.Errorf("agent release %s must be a plain binary", rel.Version)
+	}
+	if err := verifyArtifactSignature(rel); err != nil {
+		return err
+	}
+	tmp := filepath.Join(u.dir, "download_"+rel.Version)
+	defer func() { _ = os.Remove(tmp) }()
+	prunePartials(tmp)
+	if err := u.src.Fetch(ctx, rel, tmp); err != nil {
+		if reason, ok := downloadHeld(err); ok {
+			log.Printf("self_update: stopped downloading agent %s, resuming later: %s", rel.Version, reason)
+			held = true
+			reportDeferral(agentVersion, rel, "download_gate", reason)
+			return nil
+		}
+		if reason, ok := dataCapped(err); ok {
+			log.Printf("self_update: deferring agent %s: %s", rel.Version, reason)
+			held = true
+			reportDeferral(agentVersion, rel, "data_cap", reason)
+			return nil
+		}
+		return err
+	}
+	ok, err := verifySha256(ctx, tmp, rel.Sha256)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("sha256 mismatch")
+	}
+	if err := verifyCosign(u.cfg, rel); err != nil {
+		return err
+	}
+	if err := verifyTransparency(ctx, u.cfg, rel); err != nil {
+		return err
+	}
+	bin := u.binary(rel.Version)
+	if err := os.Chmod(tmp, 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, bin); err != nil {
+		return err
+	}
+	if err := trialRun(ctx, bin, rel.Version); err != nil {
+		_ = os.Remove(bin)
+		u.markBad(rel.Version)
+		u.save()
+		return err
+	}
+	return u.switchTo(rel.Version)
+}
+
+// trialRun checks that bin runs on this device and is the agent of version.
+func trialRun(ctx context.Context, bin, version string) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTrialTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin, "-version").Output()
+	if err != nil {
+		return fmt.Errorf("agent %s does not run: %w", version, err)
+	}
+	if got := strings.TrimSpace(string(out)); got != version {
+		return fmt.Errorf("agent %s reports version %q", version, got)
+	}
+	return nil
+}
+
+// switchTo records version as the pending agent and execs it. It returns
+// only when the exec failed, after restoring the state.
+func (u *selfUpdater) switchTo(version string) error {
+	old := u.st
+	prev := ""
+	if u.st.Current == agentVersion {
+		prev = agentVersion
+	}
+	u.st.Current, u.st.Previous, u.st.From, u.st.Pending, u.st.Attempts = version, prev, agentVersion, true, 1
+	u.save()
+	u.prune()
+	log.Printf("self_update: switching agent %s -> %s", agentVersion, version)
+	shadow.shutdown()
+	err := u.exec(version)
+	u.st = old
+	u.save()
+	return err
+}
+
+// prune removes agent binaries other than the current and rollback versions.
+func (u *selfUpdater) prune() {
+	matches, _ := filepath.Glob(filepath.Join(u.dir, "ota-agent-*"))
+	for _, m := range matches {
+		v := strings.TrimPrefix(filepath.Base(m), "ota-agent-")
+		if v != u.st.Current && v != u.st.Previous {
+			_ = os.Remove(m)
+		}
+	}
+}
+
+func (u *selfUpdater) markBad(version string) {
+	if !slices.Contains(u.st.Bad, version) {
+		u.st.Bad = append(u.st.Bad, version)
+	}
+	if n := len(u.st.Bad); n > maxBadAgents {
+		u.st.Bad = u.st.Bad[n-maxBadAgents:]
+	}
+}
+
+// report queues the outcome of an agent update; the event's version is the
+// primary app's, as for other apps.
+func (u *selfUpdater) report(from, to, status, msg string) {
+	metrics.installed(agentApp, status)
+	reports.enqueue(queuedEvent{
+		Key:     fmt.Sprintf("agent:%s:%s:%s", from, to, status),
+		Type:    "report",
+		Channel: u.cfg.Channel,
+		Version: readCurrentVersion(),
+		Data: map[string]any{
+			"app":    agentApp,
+			"status": status,
+			"from":   from,
+			"to":     to,
+			"error":  msg,
+		},
+	})
+}
+
+// agentLabel names an agent version, "" being the factory-installed one.
+func agentLabel(version string) string {
+	if version == "" {
+		return "the factory agent"
+	}
+	return version
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 影子部署：服务端在检查响应中给出 shadow 时，agent 下载并校验候选版本（sha256、cosign、透明日志），
+// 以 shadow_args 作为被动的第二个进程与现役版本并行运行（换端口、不输出执行器指令），不切换 algo_current。
+// 两个进程各自把指标（扁平的 JSON 数值对象）写到环境变量 OTA_METRICS_FILE 指定的文件，影子进程另有
+// OTA_SHADOW=1；agent 每 5 分钟把两边的指标作为一条 shadow 事件上报，跑满 soak 时长后上报最终结果
+// 并停止影子进程。服务端不再下发该部署（提升或中止）时立即停止。只支持 binary 安装后端。
+
+const (
+	metricsEnv = "OTA_METRICS_FILE"
+	shadowEnv  = "OTA_SHADOW"
+
+	shadowReportEvery = 5 * time.Minute
+)
+
+// ShadowAssignment 是检查响应中的影子部署。
+type ShadowAssignment struct {
+	ID           string   `json:"id"`
+	SoakMinutes  int      `json:"soak_minutes"`
+	Release      *Release `json:"release"`
+	DownloadURLs []string `json:"download_urls"`
+}
+
+// metricsFile is where the algorithm running in role (active | shadow)
+// writes its metrics.
+func metricsFile(role string) string {
+	return sup.file("metrics_" + role + ".json")
+}
+
+// readMetrics returns the numeric metrics in file, nil when there are none.
+func readMetrics(file string) map[string]float64 {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		log.Printf("metrics %s: %v", file, err)
+		return nil
+	}
+	out := map[string]float64{}
+	for k, v := range raw {
+		if f, ok := v.(float64); ok {
+			out[k] = f
+		}
+	}
+	return out
+}
+
+type shadowRunner struct {
+	mu       sync.Mutex
+	id       string
+	version  string
+	args     []string
+	bin      string
+	cmd      *exec.Cmd
+	started  time.Time
+	soak     time.Duration
+	crashes  int
+	reported time.Time
+	ignored  string // 因安装后端不支持而忽略的部署，只记录一次日志
+}
+
+var shadow shadowRunner
+
+// update follows the shadow deployment in a check response: starts,
+// reports on, finishes or withdraws the shadow process.
+func (s *shadowRunner) update(ctx context.Context, cfg *Config, a *ShadowAssignment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != "" && (a == nil || a.ID != s.id) {
+		log.Printf("shadow %s (%s) withdrawn by server", s.id, s.version)
+		s.stopLocked()
+	}
+	if a == nil || a.Release == nil || a.ID == soakedShadow(cfg) {
+		return
+	}
+	if backendName(cfg) != backendBinary {
+		if s.ignored != a.ID {
+			log.Printf("shadow %s ignored: needs the binary install backend", a.ID)
+			s.ignored = a.ID
+		}
+		return
+	}
+	if s.id == "" {
+		if err := s.prepareLocked(ctx, cfg, a); err != nil {
+			log.Printf("shadow %s: %v", a.ID, err)
+			return
+		}
+	}
+	// 影子进程意外退出后在下一次检查时重新拉起
+	if s.cmd == nil {
+		if err := s.launchLocked(); err != nil {
+			log.Printf("shadow %s: start %s: %v", s.id, s.version, err)
+			return
+		}
+	}
+	now := clk.Now()
+	switch {
+	case now.Sub(s.started) >= s.soak:
+		s.reportLocked(cfg, true)
+		log.Printf("shadow %s: %s soaked for %s", s.id, s.version, s.soak)
+		if err := os.WriteFile(soakedFile(cfg), []byte(s.id), 0o644); err != nil {
+			log.Printf("shadow %s: %v", s.id, err)
+		}
+		s.stopLocked()
+	case now.Sub(s.reported) >= shadowReportEvery:
+		s.reportLocked(cfg, false)
+	}
+}
+
+// prepareLocked downloads and verifies the candidate like an install, but
+// keeps it next to the active version.
+func (s *shadowRunner) prepareLocked(ctx context.Context, cfg *Config, a *ShadowAssignment) error {
+	rel := a.Release
+	// 服务端只允许带 shadow_args 的版本影子部署，这里再确认一次，绝不以现役方式运行候选版本
+	if len(rel.ShadowArgs) == 0 {
+		return fmt.Errorf("%s has no shadow_args, refusing to run it next to the active version", rel.Version)
+	}
+	if f := releaseFormat(rel); f != backendBinary {
+		return fmt.Errorf("%s is a %s artifact", rel.Version, f)
+	}
+	if rel.Launch != "" {
+		return fmt.Errorf("%s is a bundle with a launch template; shadow deployments run single binaries only", rel.Version)
+	}
+	if rel.ArtifactType != "" {
+		return fmt.Errorf("%s is an %s image; shadow deployments run single binaries only", rel.Version, rel.ArtifactType)
+	}
+	// 候选版本与正式安装一样只接受用 artifact_public_key 签名的制品，在下载前拒绝
+	if err := verifyArtifactSignature(rel); err != nil {
+		return err
+	}
+	if reason := gate.busy(); reason != "" {
+		return errors.New("deferred: " + reason)
+	}
+	if reason := downloadHold(); reason != "" {
+		return errors.New("deferred: " + reason)
+	}
+	if reason := dataHold(rel); reason != "" {
+		return errors.New("deferred: " + reason)
+	}
+	rel.mirrors = a.DownloadURLs
+	tmp := filepath.Join(cfg.StateDir, "download_shadow_"+rel.Version)
+	if err := src.Fetch(ctx, rel, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	ok, err := verifySha256(ctx, tmp, rel.Sha256)
+	if err == nil && !ok {
+		err = errors.New("sha256 mismatch")
+	}
+	if err == nil {
+		err = verifyCosign(cfg, rel)
+	}
+	if err == nil {
+		err = verifyTransparency(ctx, cfg, rel)
+	}
+	bin := filepath.Join(cfg.StateDir, "shadow_"+rel.Version)
+	if err == nil {
+		err = os.Rename(tmp, bin)
+	}
+	if err == nil {
+		err = os.Chmod(bin, 0o755)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	soak := time.Duration(a.SoakMinutes) * time.Minute
+	s.id, s.version, s.args, s.bin, s.soak = a.ID, rel.Version, rel.ShadowArgs, bin, soak
+	s.started, s.reported, s.crashes = clk.Now(), clk.Now(), 0
+	log.Printf("shadow %s: running %s next to the active version for %s", s.id, s.version, soak)
+	return nil
+}
+
+func (s *shadowRunner) launchLocked() error {
+	cmd := exec.Command(s.bin, s.args...)
+	cmd.Env = append(os.Environ(), metricsEnv+"="+metricsFile("shadow"), shadowEnv+"=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.cmd = cmd
+	log.Printf("shadow %s started (pid=%d)", s.version, cmd.Process.Pid)
+	go func() {
+		err := cmd.Wait()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// stopLocked 会先清空 s.cmd，仍指向 cmd 说明是意外退出
+		if s.cmd == cmd {
+			s.cmd = nil
+			s.crashes++
+			log.Printf("shadow %s exited: %v", s.version, err)
+		}
+	}()
+	return nil
+}
+
+func (s *shadowRunner) stopLocked() {
+	if s.cmd != nil && s.cmd.Process != nil {
+		if err := s.cmd.Process.Signal(os.Interrupt); err != nil {
+			_ = s.cmd.Process.Kill()
+		}
+	}
+	_ = os.Remove(s.bin)
+	_ = os.Remove(metricsFile("shadow"))
+	s.id, s.version, s.args, s.bin, s.cmd = "", "", nil, "", nil
+}
+
+// shutdown stops the shadow process when the agent exits; unlike the active
+// version it is never left running unsupervised.
+func (s *shadowRunner) shutdown() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != "" {
+		s.stopLocked()
+	}
+}
+
+func (s *shadowRunner) reportLocked(cfg *Config, final bool) {
+	s.reported = clk.Now()
+	reports.enqueue(queuedEvent{
+		Type:    "shadow",
+		Channel: cfg.Channel,
+		Version: readCurrentVersion(),
+		Data: map[string]any{
+			"shadow_id":      s.id,
+			"shadow_version": s.version,
+			"active":         readMetrics(metricsFile("active")),
+			"shadow":         readMetrics(metricsFile("shadow")),
+			"uptime_s":       int(clk.Since(s.started).Seconds()),
+			"crashes":        s.crashes,
+			"final":          final,
+		},
+	})
+}
+
+// status is the shadow deployment for the local API, nil when none runs.
+func (s *shadowRunner) status() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id == "" {
+		return nil
+	}
+	return map[string]any{
+		"id":       s.id,
+		"version":  s.version,
+		"running":  s.cmd != nil,
+		"soak_s":   int(s.soak.Seconds()),
+		"uptime_s": int(clk.Since(s.started).Seconds()),
+		"crashes":  s.crashes,
+	}
+}
+
+// soakedFile 记录最近一次跑满 soak 的部署，agent 重启后不再重复影子运行。
+func soakedFile(cfg *Config) string {
+	return filepath.Join(cfg.StateDir, "shadow_soaked")
+}
+
+func soakedShadow(cfg *Config) string {
+	b, err := os.ReadFile(soakedFile(cfg))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 离线安装（sideload）：野外没有网络的设备配置 sideload_dir（如 U 盘挂载点 /media/usb）后，每次检查先在
+// 其中查找 .ota 包（服务端 GET /api/v1/export/<version>/sideload 导出的 tar：release.json、服务端配置透明
+// 日志时的 transparency.json 与 artifact）。有比当前版本新的主应用版本时以它作为检查结果，之后与在线更新
+// 走同一条流程：格式与启动模板检查、制品签名、sha256、cosign、透明日志、门控、安装、健康检查与回滚，
+// 安装报告照常排队，联网后上报。包的渠道不限，插入介质即是操作员的选择；回滚过的版本与 skip_versions
+// 中的版本不从包安装，固定版本（pinned_version，见 pins.go）时只安装该版本。没有可用的包时照常向更新来源
+// 检查。透明日志的包含证明取自包内，与设备保存的树头之间的一致性证明需要服务端，离线时推迟到之后联网的
+// 安装（保存的树头不变，届时从它开始验证）。
+// 插入的介质谁都能写，sideload_dir 只能与签名或透明日志校验一起配置（artifact_public_key、cosign 或
+// log_public_key 至少一项），包内必须带有每个已配置校验所需的签名或证明，缺少的包被跳过，不会退回只校验
+// 包内自带的 sha256。
+
+// sideloadExt 是离线安装包的扩展名，条目名与服务端 export_formats.go 一致。
+const (
+	sideloadExt      = ".ota"
+	sideloadRelease  = "release.json"
+	sideloadProof    = "transparency.json"
+	sideloadArtifact = "artifact"
+)
+
+// checkSideloadConfig refuses sideload_dir when nothing would tell a
+// bundle from the server apart from one written by anyone with the medium.
+func checkSideloadConfig() error {
+	if artifactPub == nil && cosignV == nil && logPub == nil {
+		return errors.New("sideload_dir needs artifact_public_key, cosign_public_key / cosign_roots or log_public_key; the bundle's own sha256 proves nothing")
+	}
+	return nil
+}
+
+// sideloadSigned reports which signature or proof a configured verifier
+// needs and the bundle lacks.
+func sideloadSigned(rel *Release, proof []byte) error {
+	switch {
+	case artifactPub != nil && rel.Signature == "":
+		return fmt.Errorf("%s carries no artifact signature", rel.Version)
+	case cosignV != nil && len(rel.CosignBundle) == 0:
+		return fmt.Errorf("%s carries no cosign bundle", rel.Version)
+	case logPub != nil && proof == nil:
+		return fmt.Errorf("%s carries no %s", rel.Version, sideloadProof)
+	}
+	return nil
+}
+
+// sideloadSource 在 sideload_dir 中有更新的包时以它回答检查，否则交给 next。
+type sideloadSource struct {
+	dir  string
+	next updateSource
+
+	mu     sync.Mutex
+	warned map[string]bool // 已记录过错误的包，避免每次检查重复
+}
+
+func (s *sideloadSource) ProbeURL() string { return s.next.ProbeURL() }
+
+func (s *sideloadSource) Check(ctx context.Context, current string) (*CheckResp, error) {
+	if ck := s.find(current); ck != nil {
+		return ck, nil
+	}
+	return s.next.Check(ctx, current)
+}
+
+// find returns the newest bundle in dir newer than current as a check
+// response, nil when there is none.
+func (s *sideloadSource) find(current string) *CheckResp {
+	matches, _ := filepath.Glob(filepath.Join(s.dir, "*"+sideloadExt))
+	pin := pins.pinned()
+	var best *CheckResp
+	for _, fp := range matches {
+		ck, err := readSideload(fp)
+		if err == nil && ck.Latest.App != "" {
+			err = fmt.Errorf("%s is a release of app %s; only the primary algorithm is sideloaded", ck.Latest.Version, ck.Latest.App)
+		}
+		if err != nil {
+			s.warn(fp, err)
+			continue
+		}
+		v := ck.Latest.Version
+		switch {
+		case pin != "" && (v != pin || v == current):
+			// 固定版本时只从包安装该版本（见 pins.go）
+			continue
+		case pin == "" && current != "" && !version.Newer(v, current):
+			continue
+		case bad.has(v) || pins.skipped(v):
+			continue
+		}
+		if pin != "" {
+			ck.Pinned = &CheckPin{Version: v}
+		}
+		if best == nil || version.Newer(v, best.Latest.Version) {
+			best = ck
+		}
+	}
+	if best != nil {
+		log.Printf("sideload: %s in %s", best.Latest.Version, best.Latest.sideload)
+	}
+	return best
+}
+
+func (s *sideloadSource) warn(fp string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.warned == nil {
+		s.warned = map[string]bool{}
+	}
+	if !s.warned[fp] {
+		s.warned[fp] = true
+		log.Printf("sideload: skipping %s: %v", fp, err)
+	}
+}
+
+// readSideload reads the release record and transparency proof at the
+// start of the bundle fp.
+func readSideload(fp string) (*CheckResp, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// release.json 的内容即服务端的版本记录，另取 /check 放在顶层的字段
+	var meta struct {
+		Release
+		Mandatory    bool `json:"mandatory"`
+		ReleaseNotes *struct {
+			Safety   string `json:"safety"`
+			Breaking bool   `json:"breaking"`
+		} `json:"release_notes"`
+	}
+	var proof []byte
+	found := false
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch h.Name {
+		case sideloadRelease:
+			if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&meta); err != nil {
+				return nil, fmt.Errorf("%s: %w", sideloadRelease, err)
+			}
+			found = true
+		case sideloadProof:
+			if proof, err = io.ReadAll(io.LimitReader(tr, 1<<20)); err != nil {
+				return nil, err
+			}
+		}
+		// 元数据在制品之前，读到制品即可停止
+		if h.Name == sideloadArtifact {
+			break
+		}
+	}
+	if !found || meta.Version == "" || meta.Sha256 == "" {
+		return nil, fmt.Errorf("no %s with a version and sha256", sideloadRelease)
+	}
+	rel := meta.Release
+	if err := sideloadSigned(&rel, proof); err != nil {
+		return nil, err
+	}
+	rel.sideload, rel.sideloadProof = fp, proof
+	ck := &CheckResp{
+		UpdateAvailable: true,
+		Latest:          &rel,
+		Message:         "sideload " + filepath.Base(fp),
+		Mandatory:       meta.Mandatory,
+		Safety:          "none",
+	}
+	if n := meta.ReleaseNotes; n != nil {
+		ck.Breaking = n.Breaking
+		if n.Safety != "" {
+			ck.Safety = n.Safety
+		}
+	}
+	return ck, nil
+}
+
+func (s *sideloadSource) Fetch(ctx context.Context, rel *Release, dst string) error {
+	if rel.sideload == "" {
+		return s.next.Fetch(ctx, rel, dst)
+	}
+	f, err := os.Open(rel.sideload)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s has no %s", rel.sideload, sideloadArtifact)
+		}
+		if err != nil {
+			return err
+		}
+		if h.Name != sideloadArtifact {
+			continue
+		}
+		// 不经网络，不计流量也不受链路门控，只检查空间
+		if err := preflightDownload(filepath.Dir(dst), rel, h.Size, 0); err != nil {
+			return err
+		}
+		out, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, &ctxReader{ctx: ctx, r: tr})
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		rel.fetchedFrom = "sideload"
+		return err
+	}
+}
+
+// ctxReader stops a copy once ctx is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
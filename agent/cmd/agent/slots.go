@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 版本槽位（A/B）：设备上保留最近 keep_versions 个（默认 2，即当前与上一个）成功安装的版本，
+// 最近的在前，记录在 <state_dir>/installed_versions.json；更新成功后删除更早版本的 algo_<version>
+// （deb / rpm 后端为 packages/ 中的包文件）与暂存目录。回滚不重新下载：本地 API /update/rollback
+// 或检查响应中的 rollback（服务端召回了当前版本）把 algo_current 切回上一个槽位并重启算法，
+// 回滚掉的版本记为坏版本并移出槽位，再次回滚继续切回更早的槽位。state_dir 与 install_dir 分开时，
+// 出厂预装的版本不会被删除。
+
+const (
+	slotsFile = "installed_versions.json"
+	// defaultKeepVersions 是当前版本与一个回滚目标。
+	defaultKeepVersions = 2
+)
+
+// slotList 是按安装先后排列的已安装版本。
+type slotList struct {
+	mu   sync.Mutex
+	file string
+	keep int
+}
+
+var slots slotList
+
+// checkKeepVersions defaults keep_versions and rejects values that leave no
+// rollback target.
+func checkKeepVersions(cfg *Config) error {
+	if cfg.KeepVersions == 0 {
+		cfg.KeepVersions = defaultKeepVersions
+	}
+	if cfg.KeepVersions < 2 {
+		return fmt.Errorf("keep_versions %d: want at least 2 (the current version and a rollback target)", cfg.KeepVersions)
+	}
+	return nil
+}
+
+func (s *slotList) configure(dir string, keep int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file, s.keep = filepath.Join(dir, slotsFile), keep
+}
+
+// list returns the kept versions, the most recently installed first.
+func (s *slotList) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *slotList) load() []string {
+	var out []string
+	b, err := os.ReadFile(s.file)
+	if err == nil {
+		if err := json.Unmarshal(b, &out); err != nil {
+			log.Printf("%s: %v", s.file, err)
+		}
+		return out
+	}
+	// 升级前只记录了上一个版本
+	if b, err := os.ReadFile(filepath.Join(filepath.Dir(s.file), previousFile)); err == nil {
+		if cur := readCurrentVersion(); cur != "" {
+			out = append(out, cur)
+		}
+		if prev := strings.TrimSpace(string(b)); prev != "" {
+			out = append(out, prev)
+		}
+	}
+	return out
+}
+
+func (s *slotList) save(list []string) {
+	b, _ := json.Marshal(list)
+	if err := os.WriteFile(s.file, b, 0o644); err != nil {
+		log.Printf("%s: %v", s.file, err)
+	}
+}
+
+// installed records a successful update from from to version and returns
+// the versions to keep; the older ones are dropped.
+func (s *slotList) installed(version, from string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.load()
+	if from != "" && !contains(list, from) {
+		list = append([]string{from}, list...)
+	}
+	out := []string{version}
+	for _, v := range list {
+		if v != version && len(out) < s.keep {
+			out = append(out, v)
+		}
+	}
+	s.save(out)
+	return out
+}
+
+// previous returns the slot before current, "" when none is kept.
+func (s *slotList) previous(current string) string {
+	list := s.list()
+	for i, v := range list {
+		if v == current && i+1 < len(list) {
+			return list[i+1]
+		}
+	}
+	return ""
+}
+
+// drop removes a version rolled back from.
+func (s *slotList) drop(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.load()
+	out := make([]string, 0, len(list))
+	for _, v := range list {
+		if v != version {
+			out = append(out, v)
+		}
+	}
+	s.save(out)
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/oci"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 更新来源：server（默认）向 OTA 服务端 /check 并从 /download 下载；
+// oci 直接从 OCI registry 拉取服务端镜像过去的制品（渠道 tag -> manifest 注解 -> blob），
+// 复用现有 registry 的复制与鉴权基础设施。
+const (
+	sourceServer = "server"
+	sourceOCI    = "oci"
+)
+
+// updateSource 查询最新版本并下载其制品。
+type updateSource interface {
+	Check(ctx context.Context, current string) (*CheckResp, error)
+	Fetch(ctx context.Context, rel *Release, dst string) error
+	// ProbeURL is dialed by the boot-time network check.
+	ProbeURL() string
+}
+
+func newUpdateSource(cfg *Config) (updateSource, error) {
+	switch cfg.Source {
+	case "", sourceServer:
+		if cfg.ServerURL == "" {
+			return nil, errors.New("no server_url configured or embedded")
+		}
+		return &serverSource{cfg: cfg}, nil
+	case sourceOCI:
+		if cfg.Alias != "" {
+			return nil, errors.New("alias needs the server update source")
+		}
+		if cfg.OCIRepository == "" {
+			return nil, errors.New("source oci needs oci_repository")
+		}
+		c, err := oci.NewClient(cfg.OCIRepository, cfg.OCIUsername, cfg.OCIPassword, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		return &ociSource{cfg: cfg, client: c}, nil
+	}
+	return nil, fmt.Errorf("unknown source %q (want server or oci)", cfg.Source)
+}
+
+type serverSource struct {
+	cfg *Config
+
+	// 上一次检查响应的 ETag 与内容：下次检查带 If-None-Match，服务端返回 304 时沿用该内容
+	mu       sync.Mutex
+	etag     string
+	lastBody []byte
+}
+
+func (s *serverSource) ProbeURL() string { return s.cfg.ServerURL }
+
+func (s *serverSource) Check(ctx context.Context, current string) (*CheckResp, error) {
+	cfg := s.cfg
+	u := cfg.ServerURL + "/check?channel=" + cfg.Channel + "&current=" + current + "&device_id=" + cfg.DeviceID + "&backend=" + backendName(cfg)
+	if cfg.Region != "" {
+		u += "&region=" + url.QueryEscape(cfg.Region)
+	}
+	if cfg.Site != "" {
+		u += "&site=" + url.QueryEscape(cfg.Site)
+	}
+	if cfg.Locale != "" {
+		u += "&locale=" + url.QueryEscape(cfg.Locale)
+	}
+	// 维护时段由服务端按设备当地时间把关，时段外不下发更新（下载也不开始）
+	u += windowQuery(cfg.UpdateGate)
+	if cfg.app != "" {
+		u += "&app=" + url.QueryEscape(cfg.app)
+	} else {
+		if cfg.Alias != "" {
+			u += "&alias=" + url.QueryEscape(cfg.Alias)
+		}
+		// 固定版本时服务端以它代替渠道（或别名）的最新版本
+		if pin := pins.pinned(); pin != "" {
+			u += "&pin=" + url.QueryEscape(pin)
+		}
+		// 进程健康只统计主应用
+		u += algo.healthQuery()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	etag, body := s.etag, s.lastBody
+	s.mu.Unlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.etag, s.lastBody = resp.Header.Get("ETag"), body
+		s.mu.Unlock()
+	case http.StatusNotModified:
+		// 结果与上一次相同，服务端没有发送响应体
+	default:
+		b, _ := io.ReadAll(resp.Body)
+		return nil, errors.New("check failed: " + string(b))
+	}
+	if id := resp.Header.Get("X-Device-ID"); id != "" && id != cfg.DeviceID {
+		adoptDeviceID(cfg, id)
+	}
+	var ck CheckResp
+	if err := json.Unmarshal(body, &ck); err != nil {
+		return nil, err
+	}
+	if ck.Latest != nil {
+		ck.Latest.mirrors = ck.DownloadURLs
+	}
+	return &ck, nil
+}
+
+// Fetch tries the download URLs the server suggested, closest first, then
+// the configured server URL; the sha256 check covers whichever served it.
+func (s *serverSource) Fetch(ctx context.Context, rel *Release, dst string) (err error) {
+	defer metrics.fetched(rel, clk.Now(), &err)
+	urls := rel.mirrors
+	// 服务端列出的源站地址是它看到的外部地址，可能与配置的地址不同，配置的地址总是最后一次尝试
+	if origin := s.cfg.ServerURL + rel.URL; !slices.Contains(urls, origin) {
+		urls = append(urls[:len(urls):len(urls)], origin)
+	}
+	for _, u := range urls {
+		if err = downloadToFile(ctx, rel, u, dst); err == nil {
+			if pu, perr := url.Parse(u); perr == nil {
+				rel.fetchedFrom = pu.Host
+			}
+			return nil
+		}
+		_, held := downloadHeld(err)
+		// 空间不足与流量上限与下载地址无关，不再尝试其它地址
+		_, short := diskShort(err)
+		if _, capped := dataCapped(err); ctx.Err() != nil || held || short || capped {
+			return err
+		}
+		log.Printf("download %s: %v", u, err)
+	}
+	return err
+}
+
+type ociSource struct {
+	cfg    *Config
+	client *oci.Client
+}
+
+// ociTimeout 限制一次 registry 查询或下载的时长。
+const ociTimeout = 10 * time.Minute
+
+func (s *ociSource) ProbeURL() string {
+	if strings.Contains(s.cfg.OCIRepository, "://") {
+		return s.cfg.OCIRepository
+	}
+	return "https://" + s.cfg.OCIRepository
+}
+
+func (s *ociSource) Check(ctx context.Context, current string) (*CheckResp, error) {
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
+	// 服务端镜像时每个版本另有一个 tag，固定版本时拉取它
+	tag, pin := s.cfg.Channel, ""
+	if s.cfg.app == "" {
+		pin = pins.pinned()
+	}
+	if pin != "" {
+		tag = invalidTagChars.ReplaceAllString(pin, "_")
+	}
+	m, err := s.client.Manifest(ctx, tag)
+	if errors.Is(err, oci.ErrNotFound) {
+		if pin != "" {
+			return &CheckResp{Message: "pinned version " + pin + " not found"}, nil
+		}
+		return &CheckResp{Message: "no release in channel"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Layers) != 1 {
+		return nil, fmt.Errorf("oci: %s:%s has %d layers, want 1", s.client.Ref(), tag, len(m.Layers))
+	}
+	rel := &Release{
+		Version:      m.Annotations[oci.AnnotationVersion],
+		Channel:      m.Annotations[oci.AnnotationChannel],
+		Sha256:       m.Annotations[oci.AnnotationSha256],
+		Notes:        m.Annotations[oci.AnnotationNotes],
+		Format:       m.Annotations[oci.AnnotationFormat],
+		Launch:       m.Annotations[oci.AnnotationLaunch],
+		ArtifactType: m.Annotations[oci.AnnotationArtifactType],
+		URL:          m.Layers[0].Digest,
+		size:         m.Layers[0].Size,
+
+		Signature: m.Annotations[oci.AnnotationSignature],
+		KeyID:     m.Annotations[oci.AnnotationKeyID],
+	}
+	if b := m.Annotations[oci.AnnotationCosign]; b != "" {
+		rel.CosignBundle = json.RawMessage(b)
+	}
+	// 以内容寻址的层摘要为准，注解与之不符说明 manifest 被篡改
+	if rel.Version == "" || "sha256:"+rel.Sha256 != rel.URL {
+		return nil, fmt.Errorf("oci: %s:%s is not a dronealgo artifact", s.client.Ref(), tag)
+	}
+	ck := &CheckResp{
+		Latest:    rel,
+		Message:   "up to date",
+		Safety:    m.Annotations[oci.AnnotationSafety],
+		Breaking:  m.Annotations[oci.AnnotationBreaking] == "true",
+		Mandatory: m.Annotations[oci.AnnotationMandatory] == "true",
+	}
+	switch {
+	case pin != "":
+		ck.Pinned = &CheckPin{Version: rel.Version}
+		if rel.Version != current {
+			ck.UpdateAvailable = true
+			ck.Message = "pinned to " + rel.Version
+		}
+	case current == "" || version.Newer(rel.Version, current):
+		ck.UpdateAvailable = true
+		ck.Message = "new version available"
+	}
+	return ck, nil
+}
+
+func (s *ociSource) Fetch(ctx context.Context, rel *Release, dst string) (err error) {
+	defer metrics.fetched(rel, clk.Now(), &err)
+	if rel.size > 0 {
+		if err := preflightDownload(filepath.Dir(dst), rel, rel.size, 0); err != nil {
+			return err
+		}
+		if err := admitDownload(rel, rel.size); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, ociTimeout)
+	defer cancel()
+	body, err := s.client.Blob(ctx, rel.URL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, newDownloadReader(ctx, body))
+	return err
+}
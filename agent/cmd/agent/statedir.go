@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 只读根文件系统：机载计算机的根分区只读时，install_dir 里只有出厂镜像预装的版本（algo_<version>、
+// algo_current、current_version）。配置 state_dir（可写分区上的目录）后，agent 写入的一切——
+// 新安装的版本与 algo_current、current_version、上报队列、ready.json、暂存目录、下载临时文件、
+// 锁与 PID 文件——都放在 state_dir。读取时 state_dir 覆盖 install_dir：state_dir 中还没有
+// algo_current / current_version 时使用出厂版本，首次更新后切到 state_dir 中的版本，回滚时
+// 也可以回到出厂版本。未配置 state_dir 时它就是 install_dir，行为与以前相同。
+
+// checkStateDir defaults state_dir to install_dir and makes sure the agent
+// can write to it.
+func checkStateDir(cfg *Config) error {
+	if cfg.StateDir == "" {
+		cfg.StateDir = cfg.InstallDir
+	}
+	if err := os.MkdirAll(cfg.StateDir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(cfg.StateDir, ".write-test-")
+	if err != nil {
+		if cfg.StateDir == cfg.InstallDir {
+			return fmt.Errorf("install_dir %s is not writable; on a read-only root filesystem set state_dir to a writable directory: %w", cfg.InstallDir, err)
+		}
+		return fmt.Errorf("state_dir %s is not writable: %w", cfg.StateDir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// overlayFile returns name in state_dir if it exists there, in install_dir
+// otherwise; symlinks are not followed.
+func overlayFile(cfg *Config, name string) string {
+	fp := filepath.Join(cfg.StateDir, name)
+	if _, err := os.Lstat(fp); err != nil {
+		return filepath.Join(cfg.InstallDir, name)
+	}
+	return fp
+}
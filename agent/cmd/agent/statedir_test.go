@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCheckStateDirDefaultsToInstallDir(t *testing.T) {
+	cfg := &Config{InstallDir: t.TempDir()}
+	if err := checkStateDir(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.StateDir != cfg.InstallDir {
+		t.Fatalf("state_dir = %q, want install_dir %q", cfg.StateDir, cfg.InstallDir)
+	}
+	// 写入检查不留下临时文件
+	entries, _ := os.ReadDir(cfg.StateDir)
+	if len(entries) != 0 {
+		t.Fatalf("state_dir not empty after the write test: %v", entries)
+	}
+}
+
+func TestCheckStateDirCreatesStateDir(t *testing.T) {
+	root := t.TempDir()
+	cfg := &Config{InstallDir: filepath.Join(root, "ro"), StateDir: filepath.Join(root, "data", "ota")}
+	if err := checkStateDir(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(cfg.StateDir); err != nil || !fi.IsDir() {
+		t.Fatalf("state_dir not created: %v", err)
+	}
+}
+
+func TestCheckStateDirNotWritable(t *testing.T) {
+	root := t.TempDir()
+	// state_dir 的父路径是普通文件，目录无法创建
+	blocker := filepath.Join(root, "file")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{InstallDir: root, StateDir: filepath.Join(blocker, "state")}
+	if err := checkStateDir(cfg); err == nil {
+		t.Fatal("unusable state_dir accepted")
+	}
+
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("directory permissions are not enforced")
+	}
+	ro := filepath.Join(root, "ro")
+	if err := os.Mkdir(ro, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	cfg = &Config{InstallDir: ro}
+	err := checkStateDir(cfg)
+	if err == nil || !strings.Contains(err.Error(), "set state_dir") {
+		t.Fatalf("read-only install_dir: got %v, want a hint to set state_dir", err)
+	}
+}
+
+func TestOverlayFile(t *testing.T) {
+	cfg := &Config{InstallDir: t.TempDir(), StateDir: t.TempDir()}
+	factory := filepath.Join(cfg.InstallDir, "current_version")
+	if err := os.WriteFile(factory, []byte("1.0.0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// state_dir 中还没有时使用出厂版本
+	if got := overlayFile(cfg, "current_version"); got != factory {
+		t.Fatalf("overlayFile = %s, want %s", got, factory)
+	}
+	state := filepath.Join(cfg.StateDir, "current_version")
+	if err := os.WriteFile(state, []byte("1.1.0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := overlayFile(cfg, "current_version"); got != state {
+		t.Fatalf("overlayFile = %s, want %s", got, state)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// 指向不存在目标的 algo_current 也算 state_dir 中已有
+	link := filepath.Join(cfg.StateDir, "algo_current")
+	if err := os.Symlink(filepath.Join(cfg.StateDir, "algo_missing"), link); err != nil {
+		t.Fatal(err)
+	}
+	if got := overlayFile(cfg, "algo_current"); got != link {
+		t.Fatalf("overlayFile = %s, want dangling link %s", got, link)
+	}
+}
+
+func TestRollbackToFactoryVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs symlinks")
+	}
+	cfg := &Config{InstallDir: t.TempDir(), StateDir: t.TempDir()}
+	factory := filepath.Join(cfg.InstallDir, "algo_1.0.0")
+	installed := filepath.Join(cfg.StateDir, "algo_1.1.0")
+	for _, fp := range []string{factory, installed} {
+		if err := os.WriteFile(fp, []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var restarted string
+	b := &binaryInstaller{dir: cfg.StateDir, base: cfg.InstallDir, restart: func(bin string) error {
+		restarted = bin
+		return nil
+	}}
+
+	// 回滚可以回到 install_dir 中的出厂版本，algo_current 写在 state_dir
+	if err := b.Rollback("1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(cfg.StateDir, "algo_current")
+	if target, err := os.Readlink(link); err != nil || target != factory {
+		t.Fatalf("algo_current -> %q (%v), want %s", target, err, factory)
+	}
+	if restarted != link {
+		t.Fatalf("restarted %q, want %s", restarted, link)
+	}
+	if err := b.Rollback("0.9.0"); err == nil {
+		t.Fatal("rollback to a missing version succeeded")
+	}
+
+	// 清理只删 state_dir 中的旧版本，出厂版本保留
+	b.Prune([]string{"1.0.0"})
+	if _, err := os.Stat(installed); !os.IsNotExist(err) {
+		t.Fatalf("pruned version still in state_dir: %v", err)
+	}
+	if _, err := os.Stat(factory); err != nil {
+		t.Fatalf("factory version removed: %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 算法进程与当前版本：主循环（安装、重启）、子进程的 wait goroutine 与本地 API 的 HTTP handler
+// 都会访问。子进程由 supervisor 的 owner goroutine 独占：启动、停止以命令发送，子进程退出以消息
+// 送达，全部在 owner 中串行处理，其它 goroutine 不再直接读写 *exec.Cmd。install_dir 与
+// current_version 文件的读写由互斥锁保护，本地 API 不会读到写了一半的版本号。
+
+type supervisorOp int
+
+const (
+	opStart supervisorOp = iota
+	opStop
+	opAdopt
+	opHandover
+)
+
+type supervisorCmd struct {
+	op    supervisorOp
+	bin   string
+	adopt *algoPID
+	done  chan error
+}
+
+// child 是 owner 管理的算法进程：自己启动的，或上一个 agent 留下、重启后接管的。
+// exited 在进程退出后关闭。
+type child struct {
+	proc    *os.Process
+	started time.Time
+	exited  chan struct{}
+}
+
+// childExit 由子进程的 wait goroutine 发给 owner。
+type childExit struct {
+	c   *child
+	err error
+}
+
+// loopResult 由崩溃循环处理 goroutine 发给 owner：bin 的崩溃循环是否已处理。
+type loopResult struct {
+	bin     string
+	handled bool
+}
+
+type supervisor struct {
+	// app 为空即主应用；其它应用（见 apps.go）的进程不计入算法健康统计，也没有指标文件与暂存目录
+	app   string
+	cmds  chan supervisorCmd
+	exits chan childExit
+	loops chan loopResult
+
+	mu      sync.Mutex
+	dir     string // state_dir，由 setup 设置
+	base    string // install_dir，只读根文件系统上的出厂版本（见 statedir.go）
+	restart RestartConfig
+	// restarts 是 agent 启动以来的自动重启次数
+	restarts int
+	// log 是进程输出写入的日志文件（见 algolog.go），为 nil 时输出到 agent 的控制台
+	log *algoLogFile
+	// handover 是交接配置，standby 与 abort 是等待就绪的备用进程的版本与中止信号（见 handover.go）
+	handover HandoverConfig
+	standby  string
+	abort    chan struct{}
+}
+
+var sup = newSupervisor("")
+
+func newSupervisor(app string) *supervisor {
+	return &supervisor{app: app, cmds: make(chan supervisorCmd), exits: make(chan childExit), loops: make(chan loopResult)}
+}
+
+// name is how logs refer to the supervised process.
+func (s *supervisor) name() string {
+	if s.app == "" {
+		return "algorithm"
+	}
+	return "app " + s.app
+}
+
+// run is the owner goroutine of the algorithm process.
+func (s *supervisor) run() {
+	var cur *child
+	r := restarter{cfg: s.restartConfig()}
+	for {
+		select {
+		case c := <-s.cmds:
+			var err error
+			switch c.op {
+			case opStart:
+				s.stopChild(cur)
+				cur, err = s.startChild(c.bin)
+				r.reset(c.bin)
+			case opStop:
+				s.stopChild(cur)
+				cur = nil
+				r.reset("")
+			case opHandover:
+				cur, err = s.handOver(cur, c.bin)
+				r.reset(c.bin)
+			case opAdopt:
+				s.stopChild(cur)
+				cur, err = s.adoptChild(c.adopt)
+				r.reset(c.adopt.Bin)
+			}
+			c.done <- err
+			ready.refresh()
+		case e := <-s.exits:
+			log.Printf("%s exited: %v", s.name(), e.err)
+			// 被停止或替换的进程退出是预期的，仍是当前进程说明是意外退出
+			if e.c == cur {
+				cur = nil
+				s.clearPID()
+				s.crashed(&r, clk.Since(e.c.started), fmt.Sprint(e.err))
+				ready.refresh()
+			}
+		case res := <-s.loops:
+			r.looped(res.bin, res.handled)
+		case <-r.timer:
+			r.timer = nil
+			if cur != nil || r.bin == "" {
+				continue
+			}
+			s.mu.Lock()
+			s.restarts++
+			n := s.restarts
+			s.mu.Unlock()
+			log.Printf("restarting %s (restart %d)", s.name(), n)
+			metrics.restarted(s.app)
+			var err error
+			if cur, err = s.startChild(r.bin); err != nil {
+				log.Printf("restart %s: %v", s.name(), err)
+				s.crashed(&r, 0, err.Error())
+			}
+			ready.refresh()
+		}
+	}
+}
+
+// crashed reports an unexpected exit (or a failed restart) and schedules
+// the next restart; a crash loop of the primary app is handed to crashLoop.
+func (s *supervisor) crashed(r *restarter, uptime time.Duration, exit string) {
+	data := map[string]any{"exit": exit, "restarts": s.restartCount()}
+	metrics.crashed(s.app)
+	if s.app == "" {
+		algo.crash()
+	} else {
+		// 事件的 version 是主应用的版本（见 apps.go）
+		data["app"], data["version"] = s.app, s.version()
+	}
+	reports.enqueue(queuedEvent{Type: "crash", Version: readCurrentVersion(), Data: data})
+	delay, loop := r.crashed(uptime)
+	if r.timer != nil {
+		log.Printf("%s will be restarted in %s", s.name(), delay)
+	}
+	if loop {
+		if s.app == "" {
+			bin, version, cfg := r.bin, s.version(), r.cfg
+			go func() { s.loops <- loopResult{bin: bin, handled: crashLoop(version, cfg)} }()
+		} else {
+			log.Printf("%s is crash-looping (more than %d crashes within %d minutes)", s.name(), r.cfg.MaxCrashes, r.cfg.WindowMinutes)
+		}
+	}
+}
+
+// do sends a command to the owner goroutine and waits for its result.
+func (s *supervisor) do(op supervisorOp, bin string) error {
+	done := make(chan error, 1)
+	s.cmds <- supervisorCmd{op: op, bin: bin, done: done}
+	return <-done
+}
+
+func (s *supervisor) startChild(bin string) (*child, error) {
+	c, err := s.spawn(bin, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.track(c, bin)
+	return c, nil
+}
+
+// spawn starts bin with extra arguments and environment; the process is
+// not yet the supervised one (see track).
+func (s *supervisor) spawn(bin string, args []string, env ...string) (*child, error) {
+	argv, err := algoArgv(bin)
+	if err != nil {
+		return nil, err
+	}
+	argv = append(argv, args...)
+	if s.app == "" && isImage(bin) {
+		// 上次的容器没来得及清理时同名容器会使 run 失败（见 container.go）
+		removeContainer()
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	if s.app == "" {
+		cmd.Env = append(cmd.Env, metricsEnv+"="+metricsFile("active"))
+		// 以版本暂存目录为工作目录，安装目录只读时算法仍可写临时文件（见 scratch.go）
+		if dir := scratch.dir(); dir != "" {
+			cmd.Dir = dir
+			cmd.Env = append(cmd.Env, scratchEnv+"="+dir, "TMPDIR="+dir)
+		}
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if s.log != nil {
+		out, err := s.log.open(s.binVersion(bin))
+		if err != nil {
+			return nil, fmt.Errorf("algo_log: %w", err)
+		}
+		// 子进程持有自己的描述符
+		defer out.Close()
+		cmd.Stdout, cmd.Stderr = out, out
+	}
+	// 独立的进程组：发给 agent 的终端信号不会波及算法，agent 退出后算法可继续运行
+	cmd.SysProcAttr = ownGroupAttr()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c := &child{proc: cmd.Process, started: clk.Now(), exited: make(chan struct{})}
+	log.Printf("%s started (pid=%d)", s.name(), cmd.Process.Pid)
+	go func() {
+		err := cmd.Wait()
+		close(c.exited)
+		s.exits <- childExit{c: c, err: err}
+	}()
+	return c, nil
+}
+
+// track makes c the supervised process: its health is tracked and its PID
+// recorded for a later agent to adopt.
+func (s *supervisor) track(c *child, bin string) {
+	if s.app == "" {
+		algo.start(c.proc.Pid, c.started)
+	}
+	s.recordPID(c.proc.Pid, bin)
+}
+
+// adoptChild takes over a process started by a previous agent. It is not
+// our child, so its exit is noticed by polling instead of Wait.
+func (s *supervisor) adoptChild(p *algoPID) (*child, error) {
+	proc, err := os.FindProcess(p.PID)
+	if err != nil {
+		return nil, err
+	}
+	c := &child{proc: proc, started: p.Started, exited: make(chan struct{})}
+	if s.app == "" {
+		algo.start(p.PID, p.Started)
+	}
+	log.Printf("adopted %s left running by the previous agent (pid=%d)", s.name(), p.PID)
+	go func() {
+		for p.alive() {
+			clk.Sleep(adoptPoll)
+		}
+		close(c.exited)
+		s.exits <- childExit{c: c, err: errors.New("adopted process exited, status unknown")}
+	}()
+	return c, nil
+}
+
+// stopChild interrupts the process and waits up to stopGrace for it to exit
+// before killing it.
+func (s *supervisor) stopChild(c *child) {
+	if c == nil {
+		return
+	}
+	if err := c.proc.Signal(os.Interrupt); err != nil {
+		_ = c.proc.Kill()
+	}
+	select {
+	case <-c.exited:
+	case <-clk.After(stopGrace):
+		log.Printf("%s (pid=%d) still running %s after SIGINT, killing it", s.name(), c.proc.Pid, stopGrace)
+		_ = c.proc.Kill()
+		<-c.exited
+	}
+	s.clearPID()
+	if s.app == "" {
+		algo.stop()
+	}
+}
+
+func (s *supervisor) setDirs(dir, base string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dir, s.base = dir, base
+}
+
+// setRestart configures automatic restarts; it is called before run.
+func (s *supervisor) setRestart(c RestartConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restart = c
+}
+
+func (s *supervisor) restartConfig() RestartConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restart
+}
+
+// restartCount is the number of automatic restarts since the agent started.
+func (s *supervisor) restartCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restarts
+}
+
+// file returns the path of name in the state directory.
+func (s *supervisor) file(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return filepath.Join(s.dir, name)
+}
+
+func (s *supervisor) version() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := os.ReadFile(filepath.Join(s.dir, "current_version"))
+	if errors.Is(err, os.ErrNotExist) && s.base != "" {
+		// 尚未更新过：出厂镜像中的版本
+		b, err = os.ReadFile(filepath.Join(s.base, "current_version"))
+	}
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (s *supervisor) setVersion(v string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		return errors.New("state directory not set")
+	}
+	return os.WriteFile(filepath.Join(s.dir, "current_version"), []byte(v), 0o644)
+}
+
+func readCurrentVersion() string { return sup.version() }
+
+func startAlgorithm(bin string) error { return sup.do(opStart, bin) }
+
+func stopAlgorithm() error { return sup.do(opStop, "") }
+
+// adopt makes the owner goroutine supervise a process left running by a
+// previous agent.
+func (s *supervisor) adopt(p *algoPID) error {
+	done := make(chan error, 1)
+	s.cmds <- supervisorCmd{op: opAdopt, adopt: p, done: done}
+	return <-done
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+)
+
+// 遥测隐私：心跳默认只带版本与渠道；主机名、本机 IP 与 GPS 位置需在 telemetry_fields 中显式开启，
+// 位置可在发送前按 gps_decimals 降低精度。telemetry_sampling 按事件类型设置采样率（0–1），
+// 未列出的类型全部发送。
+
+const (
+	fieldHostname = "hostname"
+	fieldIP       = "ip"
+	fieldGPS      = "gps"
+)
+
+var telemetryFieldNames = map[string]bool{fieldHostname: true, fieldIP: true, fieldGPS: true}
+
+// gpsFix 是飞控桥接程序写入 gps_file 的最新定位。
+type gpsFix struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	Alt float64 `json:"alt,omitempty"`
+}
+
+func checkTelemetryConfig(cfg *Config) error {
+	for _, f := range cfg.TelemetryFields {
+		if !telemetryFieldNames[f] {
+			return fmt.Errorf("telemetry_fields: unknown field %q (want hostname, ip or gps)", f)
+		}
+		if f == fieldGPS && cfg.GPSFile == "" {
+			return fmt.Errorf("telemetry_fields: gps needs gps_file")
+		}
+	}
+	for typ, rate := range cfg.TelemetrySampling {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("telemetry_sampling[%s]: rate must be between 0 and 1", typ)
+		}
+	}
+	return nil
+}
+
+// sampled reports whether an event of type typ should be sent.
+func sampled(rates map[string]float64, typ string) bool {
+	rate, ok := rates[typ]
+	if !ok || rate >= 1 {
+		return true
+	}
+	return rand.Float64() < rate
+}
+
+// telemetryData collects the opt-in identifying fields for a heartbeat.
+func telemetryData(cfg *Config) map[string]any {
+	data := map[string]any{}
+	for _, f := range cfg.TelemetryFields {
+		switch f {
+		case fieldHostname:
+			if h, err := os.Hostname(); err == nil {
+				data[fieldHostname] = h
+			}
+		case fieldIP:
+			if ips := localIPs(); len(ips) > 0 {
+				data["device_ips"] = ips
+			}
+		case fieldGPS:
+			if fix, err := readGPS(cfg); err != nil {
+				log.Printf("gps: %v", err)
+			} else {
+				data[fieldGPS] = fix
+			}
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+func localIPs() []string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && !n.IP.IsLinkLocalUnicast() {
+			out = append(out, n.IP.String())
+		}
+	}
+	return out
+}
+
+func readGPS(cfg *Config) (*gpsFix, error) {
+	b, err := os.ReadFile(cfg.GPSFile)
+	if err != nil {
+		return nil, err
+	}
+	var fix gpsFix
+	if err := json.Unmarshal(b, &fix); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.GPSFile, err)
+	}
+	if cfg.GPSDecimals != nil {
+		p := math.Pow(10, float64(*cfg.GPSDecimals))
+		fix.Lat = math.Round(fix.Lat*p) / p
+		fix.Lon = math.Round(fix.Lon*p) / p
+	}
+	return &fix, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 温度与负载门控：更新中的 CPU 密集操作（sha256 校验、签名校验、包管理器解包安装）曾导致机载计算机
+// 过热降频，拖慢视觉管线。配置 throttle 后，板温（thermal zone，毫摄氏度）或每核 1 分钟平均负载
+// 超过阈值时推迟更新，下一次检查再试；进行中的校验在每读完一段后检查，超阈值则暂停，
+// 降到恢复阈值以下（带回差，避免在阈值附近反复启停）后继续。读不到温度或负载时不门控。
+
+// ThrottleConfig 是温度与负载门控的阈值，均为 0 时关闭。
+type ThrottleConfig struct {
+	MaxTempC    float64 `json:"max_temp_c"`
+	ResumeTempC float64 `json:"resume_temp_c"` // 缺省比 max_temp_c 低 5 °C
+	TempFile    string  `json:"temp_file"`     // 缺省 /sys/class/thermal/thermal_zone0/temp
+	MaxLoad     float64 `json:"max_load_per_cpu"`
+	ResumeLoad  float64 `json:"resume_load_per_cpu"` // 缺省为 max_load_per_cpu 的 80%
+}
+
+const (
+	defaultTempFile = "/sys/class/thermal/thermal_zone0/temp"
+	throttlePoll    = 5 * time.Second
+	// throttleChunk 是两次检查之间校验读取的字节数。
+	throttleChunk = 8 << 20
+)
+
+type throttle struct {
+	mu     sync.Mutex
+	cfg    ThrottleConfig
+	hot    bool   // 超过阈值后直到降到恢复阈值以下
+	reason string // 最近一次超阈值的原因
+}
+
+var gate throttle
+
+func (t *throttle) configure(c ThrottleConfig) error {
+	if c.MaxTempC < 0 || c.ResumeTempC < 0 || c.MaxLoad < 0 || c.ResumeLoad < 0 {
+		return fmt.Errorf("throttle: thresholds must not be negative")
+	}
+	if c.MaxTempC > 0 && c.ResumeTempC == 0 {
+		c.ResumeTempC = c.MaxTempC - 5
+	}
+	if c.MaxLoad > 0 && c.ResumeLoad == 0 {
+		c.ResumeLoad = c.MaxLoad * 0.8
+	}
+	if c.ResumeTempC > c.MaxTempC || c.ResumeLoad > c.MaxLoad {
+		return fmt.Errorf("throttle: resume thresholds must not exceed the maximums")
+	}
+	if c.TempFile == "" {
+		c.TempFile = defaultTempFile
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfg = c
+	return nil
+}
+
+// busy reports why CPU-heavy work should wait, "" when it may run.
+func (t *throttle) busy() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.cfg
+	maxTemp, maxLoad := c.MaxTempC, c.MaxLoad
+	if t.hot {
+		maxTemp, maxLoad = c.ResumeTempC, c.ResumeLoad
+	}
+	reason := ""
+	if c.MaxTempC > 0 {
+		if temp, ok := boardTemp(c.TempFile); ok && temp > maxTemp {
+			reason = fmt.Sprintf("board temperature %.1f°C above %.1f°C", temp, maxTemp)
+		}
+	}
+	if c.MaxLoad > 0 && reason == "" {
+		if load, ok := loadPerCPU(); ok && load > maxLoad {
+			reason = fmt.Sprintf("load %.2f per CPU above %.2f", load, maxLoad)
+		}
+	}
+	t.hot, t.reason = reason != "", reason
+	return reason
+}
+
+// wait blocks until op may run or ctx ends, logging when it pauses and
+// resumes.
+func (t *throttle) wait(ctx context.Context, op string) error {
+	reason := t.busy()
+	if reason == "" {
+		return nil
+	}
+	log.Printf("%s paused: %s", op, reason)
+	start := clk.Now()
+	for t.busy() != "" {
+		if err := sleepCtx(ctx, throttlePoll); err != nil {
+			return err
+		}
+	}
+	log.Printf("%s resumed after %s", op, clk.Since(start).Round(time.Second))
+	return nil
+}
+
+// status is the gate state for the local API.
+func (t *throttle) status() map[string]any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cfg.MaxTempC == 0 && t.cfg.MaxLoad == 0 {
+		return nil
+	}
+	return map[string]any{"deferring": t.hot, "reason": t.reason}
+}
+
+// gatedReader pauses every throttleChunk bytes while the gate is closed and
+// fails once ctx ends.
+type gatedReader struct {
+	ctx context.Context
+	r   io.Reader
+	op  string
+	n   int
+}
+
+func (g *gatedReader) Read(p []byte) (int, error) {
+	if err := g.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if g.n >= throttleChunk {
+		if err := gate.wait(g.ctx, g.op); err != nil {
+			return 0, err
+		}
+		g.n = 0
+	}
+	n, err := g.r.Read(p)
+	g.n += n
+	return n, err
+}
+
+func boardTemp(file string) (float64, bool) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	milli, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+	if err != nil {
+		return 0, false
+	}
+	return milli / 1000, true
+}
+
+func loadPerCPU() (float64, bool) {
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	f := strings.Fields(string(b))
+	if len(f) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return load / float64(runtime.NumCPU()), true
+}
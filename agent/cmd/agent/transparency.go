@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/von0000/dronealgo-ota/internal/tlog"
+)
+
+// logPub 为空表示不校验透明日志。
+var logPub ed25519.PublicKey
+
+func loadLogKey(cfg *Config) (ed25519.PublicKey, error) {
+	if cfg.LogPublicKey == "" {
+		return nil, nil
+	}
+	pub, err := base64.StdEncoding.DecodeString(cfg.LogPublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("log_public_key is not a base64 ed25519 public key")
+	}
+	if cfg.ServerURL == "" {
+		return nil, errors.New("log_public_key needs server_url to fetch proofs")
+	}
+	return pub, nil
+}
+
+// treeHeadPath 保存最近一次校验通过的树头，下次只接受它的延续（一致性证明）。
+func treeHeadPath(cfg *Config) string {
+	return filepath.Join(cfg.StateDir, "log_tree_head.json")
+}
+
+func readTreeHead(cfg *Config) (*tlog.TreeHead, error) {
+	b, err := os.ReadFile(treeHeadPath(cfg))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var th tlog.TreeHead
+	if err := json.Unmarshal(b, &th); err != nil {
+		return nil, err
+	}
+	return &th, nil
+}
+
+func getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// verifyTransparency checks that rel is recorded in the release transparency
+// log and that the log is an append-only extension of what this device saw
+// before, so a server showing different histories to different devices is
+// caught.
+func verifyTransparency(ctx context.Context, cfg *Config, rel *Release) error {
+	if logPub == nil {
+		return nil
+	}
+	var proof struct {
+		LeafIndex uint64        `json:"leaf_index"`
+		Leaf      []byte        `json:"leaf"`
+		AuditPath []string      `json:"audit_path"`
+		TreeHead  tlog.TreeHead `json:"tree_head"`
+	}
+	switch {
+	case rel.sideload != "":
+		// 离线安装包自带包含证明
+		if rel.sideloadProof == nil {
+			return fmt.Errorf("transparency log proof: %s has no %s", rel.sideload, sideloadProof)
+		}
+		if err := json.Unmarshal(rel.sideloadProof, &proof); err != nil {
+			return fmt.Errorf("transparency log proof: %w", err)
+		}
+	default:
+		if err := getJSON(ctx, cfg.ServerURL+"/log/proof?version="+url.QueryEscape(rel.Version), &proof); err != nil {
+			return fmt.Errorf("transparency log proof: %w", err)
+		}
+	}
+	th := &proof.TreeHead
+	root, err := th.Verify(logPub)
+	if err != nil {
+		return err
+	}
+	var entry struct {
+		Version string `json:"version"`
+		Sha256  string `json:"sha256"`
+	}
+	if err := json.Unmarshal(proof.Leaf, &entry); err != nil {
+		return fmt.Errorf("transparency log leaf: %w", err)
+	}
+	if entry.Version != rel.Version || entry.Sha256 != rel.Sha256 {
+		return fmt.Errorf("transparency log records %s with sha256 %s, server served %s", entry.Version, entry.Sha256, rel.Sha256)
+	}
+	path, err := tlog.ParseHashes(proof.AuditPath)
+	if err != nil {
+		return err
+	}
+	if err := tlog.VerifyInclusion(tlog.LeafHash(proof.Leaf), proof.LeafIndex, th.TreeSize, path, root); err != nil {
+		return err
+	}
+
+	prev, err := readTreeHead(cfg)
+	if err != nil {
+		return fmt.Errorf("read saved tree head: %w", err)
+	}
+	if prev != nil && !prev.Equal(th) {
+		prevRoot, err := prev.Verify(logPub)
+		if err != nil {
+			return fmt.Errorf("saved tree head: %w", err)
+		}
+		if prev.TreeSize > th.TreeSize {
+			return fmt.Errorf("transparency log shrank from %d to %d entries", prev.TreeSize, th.TreeSize)
+		}
+		var cons struct {
+			Proof []string `json:"proof"`
+		}
+		u := cfg.ServerURL + "/log/consistency?first=" + strconv.FormatUint(prev.TreeSize, 10) + "&second=" + strconv.FormatUint(th.TreeSize, 10)
+		if err := getJSON(ctx, u, &cons); err != nil {
+			if rel.sideload != "" {
+				// 离线时无法取得一致性证明：不保存新树头，下次联网安装时从原树头开始验证
+				log.Printf("sideload %s: transparency log consistency deferred: %v", rel.Version, err)
+				return nil
+			}
+			return fmt.Errorf("transparency log consistency: %w", err)
+		}
+		hashes, err := tlog.ParseHashes(cons.Proof)
+		if err != nil {
+			return err
+		}
+		if err := tlog.VerifyConsistency(prev.TreeSize, th.TreeSize, prevRoot, root, hashes); err != nil {
+			return fmt.Errorf("transparency log forked: %w", err)
+		}
+	}
+	b, _ := json.Marshal(th)
+	return os.WriteFile(treeHeadPath(cfg), b, 0o644)
+}
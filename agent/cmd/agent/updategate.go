@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 飞行状态门控：飞行中更新避障算法是危险的。配置 update_gate 后，下载与校验随时进行，但安装与重启
+// 只在门控打开时开始；门控关闭时把已校验的制品保存为 download_<version>.staged，上报一次
+// status=deferred（reason 为门控原因），下一次检查时直接使用它，不再重新下载。门控来源可插拔：
+//   - http：探测飞控桥接服务，返回 200 即可更新，其它状态码或请求失败视为不可更新；
+//   - file：外部程序或 GPIO（如 /sys/class/gpio/gpio17/value）给出的标志，内容等于 safe_value（缺省 1）时可更新；
+//   - window：维护时段，如 ["02:00-04:00"]，按 timezone（缺省系统时区）计，可跨午夜。
+// 读不到状态时按不可更新处理。强制版本同样受门控限制。
+
+const (
+	gateHTTP   = "http"
+	gateFile   = "file"
+	gateWindow = "window"
+
+	stagedSuffix = ".staged"
+)
+
+// UpdateGateConfig 选择“可以安装”的判定来源，provider 为空时关闭。
+type UpdateGateConfig struct {
+	Provider  string   `json:"provider"`   // http | file | window
+	URL       string   `json:"url"`        // http
+	Path      string   `json:"path"`       // file
+	SafeValue string   `json:"safe_value"` // file 内容等于它时可更新，缺省 "1"
+	Windows   []string `json:"windows"`    // window：本地时间段，如 "02:00-04:00"
+	Timezone  string   `json:"timezone"`   // window 使用的时区（IANA 名称），缺省系统时区
+}
+
+// updateGate 判断现在能否安装，不能时给出原因。
+type updateGate interface {
+	Open() (bool, string)
+}
+
+// flightGate 在未配置 provider 时为 nil。
+var flightGate updateGate
+
+func newUpdateGate(c UpdateGateConfig) (updateGate, error) {
+	switch c.Provider {
+	case "":
+		return nil, nil
+	case gateHTTP:
+		if c.URL == "" {
+			return nil, errors.New("update_gate provider http needs url")
+		}
+		return httpGate{url: c.URL, client: &http.Client{Timeout: 2 * time.Second}}, nil
+	case gateFile:
+		if c.Path == "" {
+			return nil, errors.New("update_gate provider file needs path")
+		}
+		if c.SafeValue == "" {
+			c.SafeValue = "1"
+		}
+		return fileGate{path: c.Path, safe: c.SafeValue}, nil
+	case gateWindow:
+		return newWindowGate(c.Windows, c.Timezone)
+	}
+	return nil, fmt.Errorf("unknown update_gate provider %q (want http, file or window)", c.Provider)
+}
+
+type httpGate struct {
+	url    string
+	client *http.Client
+}
+
+func (h httpGate) Open() (bool, string) {
+	resp, err := h.client.Get(h.url)
+	if err != nil {
+		return false, "flight state unknown: " + err.Error()
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, "flight controller reports not safe to update (" + resp.Status + ")"
+	}
+	return true, ""
+}
+
+type fileGate struct {
+	path string
+	safe string
+}
+
+func (f fileGate) Open() (bool, string) {
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return false, "flight state unknown: " + err.Error()
+	}
+	if v := strings.TrimSpace(string(b)); v != f.safe {
+		return false, fmt.Sprintf("%s is %q, not %q", f.path, v, f.safe)
+	}
+	return true, ""
+}
+
+// window 是一天中的时段，以午夜起的分钟计；end < start 时跨午夜。
+type window struct {
+	start, end int
+	text       string
+}
+
+type windowGate struct {
+	windows []window
+	loc     *time.Location
+}
+
+func newWindowGate(specs []string, tz string) (updateGate, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("update_gate provider window needs windows")
+	}
+	g := windowGate{loc: time.Local}
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("update_gate timezone: %w", err)
+		}
+		g.loc = loc
+	}
+	for _, s := range specs {
+		from, to, ok := strings.Cut(s, "-")
+		start, err1 := parseClock(from)
+		end, err2 := parseClock(to)
+		if !ok || err1 != nil || err2 != nil || start == end {
+			return nil, fmt.Errorf("update_gate window %q: want HH:MM-HH:MM", s)
+		}
+		g.windows = append(g.windows, window{start: start, end: end, text: strings.TrimSpace(s)})
+	}
+	return g, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hh < 0 || hh > 24 || mm < 0 || mm > 59 || hh*60+mm > 24*60 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return hh*60 + mm, nil
+}
+
+func (g windowGate) Open() (bool, string) {
+	now := clk.Now().In(g.loc)
+	m := now.Hour()*60 + now.Minute()
+	var texts []string
+	for _, w := range g.windows {
+		in := m >= w.start && m < w.end
+		if w.end < w.start {
+			in = m >= w.start || m < w.end
+		}
+		if in {
+			return true, ""
+		}
+		texts = append(texts, w.text)
+	}
+	return false, fmt.Sprintf("outside maintenance windows %s (now %s)", strings.Join(texts, ", "), now.Format("15:04 MST"))
+}
+
+// windowQuery reports the windows of a window update gate as /check
+// parameters, so the server offers updates only inside them.
+func windowQuery(c UpdateGateConfig) string {
+	if c.Provider != gateWindow {
+		return ""
+	}
+	tz := c.Timezone
+	if tz == "" {
+		// 系统时区没有可靠的 IANA 名称，上报当前 UTC 偏移
+		tz = clk.Now().Format("-07:00")
+	}
+	return "&window=" + url.QueryEscape(strings.Join(c.Windows, ",")) + "&tz=" + url.QueryEscape(tz)
+}
+
+// gateHold returns why an install must wait, "" when it may start now.
+func gateHold() string {
+	if flightGate == nil {
+		return ""
+	}
+	if open, reason := flightGate.Open(); !open {
+		return reason
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	genKey  = flag.Bool("gen-key", false, "generate a new ed25519 key pair and print it")
+	keyFile = flag.String("key", "", "file holding the base64 ed25519 private key")
+)
+
+// cfgsign 用于在出厂/运维侧为 agent 配置文件生成签名：
+//
+//	cfgsign -gen-key
+//	cfgsign -key priv.key /path/to/config.json   # 写出 config.json.sig
+func main() {
+	flag.Parse()
+
+	if *genKey {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("public: ", base64.StdEncoding.EncodeToString(pub))
+		fmt.Println("private:", base64.StdEncoding.EncodeToString(priv))
+		return
+	}
+
+	if *keyFile == "" || flag.NArg() != 1 {
+		log.Fatalf("Usage: %s -key priv.key /path/to/config.json", os.Args[0])
+	}
+	raw, err := os.ReadFile(*keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	priv, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(priv) != ed25519.PrivateKeySize {
+		log.Fatal("invalid private key")
+	}
+
+	cfgPath := flag.Arg(0)
+	data, err := os.ReadFile(cfgPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sig := ed25519.Sign(ed25519.PrivateKey(priv), data)
+	if err := os.WriteFile(cfgPath+".sig", []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("signed %s", cfgPath)
+}
@@ -0,0 +1,32 @@
+// Package artifactsig defines the message covered by the server's detached
+// artifact signature. It is shared by the platform and the agent so both
+// sides sign and verify the same bytes.
+package artifactsig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Scheme 是当前的签名方案版本，记录在发布记录中；旧记录（0）只对 sha256 摘要签名，
+// 重新签名任务会把它们升级到当前方案。
+const Scheme = 2
+
+// domain 区分制品签名与同一密钥可能签的其它内容。
+const domain = "dronealgo-ota artifact v2\n"
+
+// Message returns the bytes signed for a release: the app, version and
+// sha256 of its artifact. Binding the version keeps a signed artifact from
+// being replayed under another (e.g. newer) version or app.
+func Message(app, version, sha256hex string) ([]byte, error) {
+	digest, err := hex.DecodeString(sha256hex)
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("malformed sha256 %q", sha256hex)
+	}
+	if strings.ContainsRune(app, '\n') || strings.ContainsRune(version, '\n') {
+		return nil, fmt.Errorf("app and version must not contain newlines")
+	}
+	return []byte(domain + app + "\n" + version + "\n" + strings.ToLower(sha256hex)), nil
+}
@@ -0,0 +1,374 @@
+// Package bundle reads the archives multi-file algorithm releases ship as: a
+// tar.gz or zip holding the algorithm together with its model weights and
+// config files, described by a manifest.json (entrypoint and per-file
+// sha256). It is shared by the platform, which validates packages at
+// publish time, and the agent, which extracts and verifies them on install.
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/von0000/dronealgo-ota/internal/launch"
+)
+
+// manifest.json 位于包的根目录：
+//
+//	{
+//	  "entrypoint": "bin/avoid --model {dir}/model.onnx",
+//	  "files": [
+//	    {"path": "bin/avoid", "sha256": "..."},
+//	    {"path": "model.onnx", "sha256": "..."}
+//	  ]
+//	}
+//
+// entrypoint 是启动模板（见 internal/launch），第一个参数是包内文件时按包目录解析（即 {dir}/bin/avoid），
+// 也可以是 PATH 中的解释器（python3 {dir}/main.py）。files 必须恰好列出包内除 manifest.json 以外的
+// 全部普通文件；包内不能有链接或越出包目录的条目。
+
+// ManifestName 是包根目录下清单文件的名字。
+const ManifestName = "manifest.json"
+
+// 包的压缩格式。
+const (
+	TarGz = "tar.gz"
+	Zip   = "zip"
+)
+
+// maxManifest 限制清单文件的大小。
+const maxManifest = 1 << 20
+
+// Manifest 描述包的入口与内容。
+type Manifest struct {
+	Entrypoint string `json:"entrypoint"`
+	Files      []File `json:"files"`
+}
+
+// File 是包内的一个文件及其 sha256。
+type File struct {
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+}
+
+// Entry 是包内的一个普通文件。
+type Entry struct {
+	Name string // 斜杠分隔的相对路径
+	Mode fs.FileMode
+	Size int64
+}
+
+// Detect returns the archive format of r by its magic bytes, "" when it is
+// neither tar.gz nor zip.
+func Detect(r io.ReaderAt) string {
+	var b [4]byte
+	n, _ := r.ReadAt(b[:], 0)
+	switch {
+	case n >= 2 && b[0] == 0x1f && b[1] == 0x8b:
+		return TarGz
+	case n == 4 && string(b[:]) == "PK\x03\x04":
+		return Zip
+	}
+	return ""
+}
+
+// Walk calls fn for every regular file of the archive r; directories are
+// skipped, links and entries escaping the archive are rejected.
+func Walk(r io.ReaderAt, size int64, fn func(e Entry, body io.Reader) error) error {
+	switch Detect(r) {
+	case TarGz:
+		return walkTarGz(io.NewSectionReader(r, 0, size), fn)
+	case Zip:
+		return walkZip(r, size, fn)
+	}
+	return errors.New("bundle is not a tar.gz or zip archive")
+}
+
+func walkTarGz(r io.Reader, fn func(e Entry, body io.Reader) error) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, err := cleanName(h.Name)
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg:
+			if err := fn(Entry{Name: name, Mode: fs.FileMode(h.Mode).Perm(), Size: h.Size}, tr); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("bundle entry %q: unsupported type %c", h.Name, h.Typeflag)
+		}
+	}
+}
+
+func walkZip(r io.ReaderAt, size int64, fn func(e Entry, body io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		name, err := cleanName(f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(Entry{Name: name, Mode: mode.Perm(), Size: int64(f.UncompressedSize64)}, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("bundle entry %q: unsupported type %s", f.Name, mode.Type())
+		}
+	}
+	return nil
+}
+
+// cleanName returns an entry name relative to the bundle root.
+func cleanName(name string) (string, error) {
+	n := path.Clean(name)
+	if path.IsAbs(n) || n == ".." || strings.HasPrefix(n, "../") {
+		return "", fmt.Errorf("bundle entry %q is outside the bundle", name)
+	}
+	return n, nil
+}
+
+// Size sums the sizes of the files in the archive r.
+func Size(r io.ReaderAt, size int64) (int64, error) {
+	var n int64
+	err := Walk(r, size, func(e Entry, _ io.Reader) error {
+		n += e.Size
+		return nil
+	})
+	return n, err
+}
+
+// Extract unpacks the archive r into dst, keeping the executable bits.
+func Extract(r io.ReaderAt, size int64, dst string) error {
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	return Walk(r, size, func(e Entry, body io.Reader) error {
+		fp := filepath.Join(dst, filepath.FromSlash(e.Name))
+		if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(fp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, e.Mode&0o755|0o644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, body)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+// ParseManifest decodes and validates a manifest.json.
+func ParseManifest(b []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestName, err)
+	}
+	if len(m.Files) == 0 {
+		return nil, fmt.Errorf("%s lists no files", ManifestName)
+	}
+	seen := map[string]bool{}
+	for _, f := range m.Files {
+		if n, err := cleanName(f.Path); err != nil || n != f.Path || n == "." {
+			return nil, fmt.Errorf("%s: invalid path %q (want a clean relative path)", ManifestName, f.Path)
+		}
+		if f.Path == ManifestName {
+			return nil, fmt.Errorf("%s must not list itself", ManifestName)
+		}
+		if seen[f.Path] {
+			return nil, fmt.Errorf("%s lists %s twice", ManifestName, f.Path)
+		}
+		seen[f.Path] = true
+		if b, err := hex.DecodeString(f.Sha256); err != nil || len(b) != sha256.Size || f.Sha256 != strings.ToLower(f.Sha256) {
+			return nil, fmt.Errorf("%s: %s: sha256 must be 64 lowercase hex digits", ManifestName, f.Path)
+		}
+	}
+	if _, err := m.Launch(); err != nil {
+		return nil, fmt.Errorf("%s: entrypoint: %w", ManifestName, err)
+	}
+	return &m, nil
+}
+
+// program returns the bundle file the entrypoint runs, "" when it runs a
+// command from PATH.
+func (m *Manifest) program() string {
+	args := strings.Fields(m.Entrypoint)
+	if len(args) == 0 {
+		return ""
+	}
+	for _, f := range m.Files {
+		if f.Path == args[0] {
+			return f.Path
+		}
+	}
+	return ""
+}
+
+// Launch returns the entrypoint as a launch template, a bundle file as its
+// program resolved into {dir}.
+func (m *Manifest) Launch() (string, error) {
+	s := m.Entrypoint
+	if m.program() != "" {
+		s = "{dir}/" + strings.TrimSpace(s)
+	}
+	t, err := launch.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return t.String(), nil
+}
+
+// Inspect reads the manifest of the archive r and checks the archive
+// against it: every listed file present with its sha256, nothing unlisted,
+// and an executable entrypoint. It returns nil without error when the
+// archive has no manifest.
+func Inspect(r io.ReaderAt, size int64) (*Manifest, error) {
+	var raw []byte
+	found := false
+	sums := map[string]string{}
+	modes := map[string]fs.FileMode{}
+	err := Walk(r, size, func(e Entry, body io.Reader) error {
+		if e.Name == ManifestName {
+			b, err := io.ReadAll(io.LimitReader(body, maxManifest+1))
+			if err != nil {
+				return err
+			}
+			if len(b) > maxManifest {
+				return fmt.Errorf("%s is larger than %d bytes", ManifestName, maxManifest)
+			}
+			raw, found = b, true
+			return nil
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, body); err != nil {
+			return err
+		}
+		sums[e.Name], modes[e.Name] = hex.EncodeToString(h.Sum(nil)), e.Mode
+		return nil
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	m, err := ParseManifest(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.check(sums); err != nil {
+		return nil, err
+	}
+	if prog := m.program(); prog != "" && modes[prog]&0o111 == 0 {
+		return nil, fmt.Errorf("entrypoint %s is not executable", prog)
+	}
+	return m, nil
+}
+
+// ReadManifest reads the manifest of a bundle extracted into dir; it
+// returns nil without error when there is none.
+func ReadManifest(dir string) (*Manifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(b)
+}
+
+// VerifyDir hashes the files of a bundle extracted into dir and checks them
+// against the manifest.
+func (m *Manifest) VerifyDir(dir string) error {
+	sums := map[string]string{}
+	err := filepath.WalkDir(dir, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, fp)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == ManifestName {
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", name)
+		}
+		f, err := os.Open(fp)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		sums[name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return m.check(sums)
+}
+
+// check compares the sha256 of every file found in a bundle with the
+// manifest.
+func (m *Manifest) check(sums map[string]string) error {
+	listed := map[string]bool{}
+	for _, f := range m.Files {
+		listed[f.Path] = true
+		got, ok := sums[f.Path]
+		switch {
+		case !ok:
+			return fmt.Errorf("%s lists %s, which is not in the bundle", ManifestName, f.Path)
+		case got != f.Sha256:
+			return fmt.Errorf("%s: sha256 %s, %s says %s", f.Path, got, ManifestName, f.Sha256)
+		}
+	}
+	for name := range sums {
+		if !listed[name] {
+			return fmt.Errorf("%s is in the bundle but not listed in %s", name, ManifestName)
+		}
+	}
+	return nil
+}
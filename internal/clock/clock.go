@@ -0,0 +1,41 @@
+// Package clock abstracts time so scheduling, expiry, backoff and retention
+// can be driven deterministically in tests and faster than real time in
+// simulations. Both the agent and the platform use it.
+package clock
+
+import "time"
+
+// Clock 提供当前时间与定时器。
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker 与 time.Ticker 对应，但以方法暴露通道以便替换实现。
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// System returns the wall clock.
+func System() Clock { return systemClock{} }
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) NewTicker(d time.Duration) Ticker       { return &realTicker{t: time.NewTicker(d)} }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (r *realTicker) C() <-chan time.Time   { return r.t.C }
+func (r *realTicker) Reset(d time.Duration) { r.t.Reset(d) }
+func (r *realTicker) Stop()                 { r.t.Stop() }
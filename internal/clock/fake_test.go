@@ -0,0 +1,125 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	f := NewFake(epoch)
+	c := f.After(time.Minute)
+	if f.Pending() != 1 {
+		t.Fatalf("pending = %d, want 1", f.Pending())
+	}
+	f.Advance(59 * time.Second)
+	if _, ok := fired(c); ok {
+		t.Fatal("timer fired early")
+	}
+	f.Advance(time.Second)
+	at, ok := fired(c)
+	if !ok || !at.Equal(epoch.Add(time.Minute)) {
+		t.Fatalf("timer fired %v at %v, want at %v", ok, at, epoch.Add(time.Minute))
+	}
+	if f.Pending() != 0 {
+		t.Fatalf("pending = %d after firing, want 0", f.Pending())
+	}
+	// 非正的时长立即触发
+	if _, ok := fired(f.After(0)); !ok {
+		t.Fatal("zero timer did not fire")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	tk := f.NewTicker(10 * time.Second)
+	f.Advance(10 * time.Second)
+	if _, ok := fired(tk.C()); !ok {
+		t.Fatal("ticker did not fire")
+	}
+	// 接收方跟不上时多余的触发被丢弃，下一次触发仍按周期对齐
+	f.Advance(35 * time.Second)
+	if at, ok := fired(tk.C()); !ok || !at.Equal(epoch.Add(45*time.Second)) {
+		t.Fatalf("ticker fired %v at %v", ok, at)
+	}
+	if _, ok := fired(tk.C()); ok {
+		t.Fatal("missed ticks were queued")
+	}
+	f.Advance(4 * time.Second)
+	if _, ok := fired(tk.C()); ok {
+		t.Fatal("ticker fired off period")
+	}
+	f.Advance(time.Second)
+	if _, ok := fired(tk.C()); !ok {
+		t.Fatal("ticker did not fire at 50s")
+	}
+
+	tk.Stop()
+	if f.Pending() != 0 {
+		t.Fatalf("pending = %d after Stop, want 0", f.Pending())
+	}
+	f.Advance(time.Minute)
+	if _, ok := fired(tk.C()); ok {
+		t.Fatal("stopped ticker fired")
+	}
+
+	// Reset 重新启用已停止的 ticker，周期从当前时间算起
+	tk.Reset(time.Minute)
+	f.Advance(59 * time.Second)
+	if _, ok := fired(tk.C()); ok {
+		t.Fatal("reset ticker fired early")
+	}
+	f.Advance(time.Second)
+	if _, ok := fired(tk.C()); !ok {
+		t.Fatal("reset ticker did not fire")
+	}
+}
+
+func TestFakeSet(t *testing.T) {
+	f := NewFake(epoch)
+	c := f.After(time.Hour)
+	f.Set(epoch.Add(-time.Hour))
+	if _, ok := fired(c); ok {
+		t.Fatal("timer fired when the clock went back")
+	}
+	if got := f.Since(epoch); got != -time.Hour {
+		t.Fatalf("Since = %v, want -1h", got)
+	}
+	f.Set(epoch.Add(2 * time.Hour))
+	if _, ok := fired(c); !ok {
+		t.Fatal("timer did not fire after jumping forward")
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Second)
+		close(done)
+	}()
+	// 等到 Sleep 已登记定时器再推进
+	deadline := time.Now().Add(5 * time.Second)
+	for f.Pending() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Sleep never registered a timer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	f.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep did not return after Advance")
+	}
+}
@@ -0,0 +1,79 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Scaled returns a clock running factor times faster than real time,
+// starting at the current wall-clock time. factor <= 1 yields System().
+func Scaled(factor float64) Clock {
+	if factor <= 1 {
+		return System()
+	}
+	return &scaledClock{factor: factor, epoch: time.Now()}
+}
+
+type scaledClock struct {
+	factor float64
+	epoch  time.Time
+}
+
+func (s *scaledClock) real(d time.Duration) time.Duration {
+	r := time.Duration(float64(d) / s.factor)
+	if r <= 0 && d > 0 {
+		r = 1
+	}
+	return r
+}
+
+func (s *scaledClock) Now() time.Time {
+	elapsed := time.Since(s.epoch)
+	return s.epoch.Add(time.Duration(float64(elapsed) * s.factor))
+}
+
+func (s *scaledClock) Since(t time.Time) time.Duration { return s.Now().Sub(t) }
+func (s *scaledClock) Sleep(d time.Duration)           { time.Sleep(s.real(d)) }
+
+func (s *scaledClock) After(d time.Duration) <-chan time.Time {
+	out := make(chan time.Time, 1)
+	time.AfterFunc(s.real(d), func() { out <- s.Now() })
+	return out
+}
+
+func (s *scaledClock) NewTicker(d time.Duration) Ticker {
+	st := &scaledTicker{clock: s, t: time.NewTicker(s.real(d)), c: make(chan time.Time, 1), done: make(chan struct{})}
+	go st.run()
+	return st
+}
+
+// scaledTicker 把真实 ticker 的触发时间换算为模拟时间后转发。
+type scaledTicker struct {
+	clock *scaledClock
+	t     *time.Ticker
+	c     chan time.Time
+	done  chan struct{}
+	stop  sync.Once
+}
+
+func (st *scaledTicker) run() {
+	for {
+		select {
+		case <-st.done:
+			return
+		case <-st.t.C:
+			select {
+			case st.c <- st.clock.Now():
+			default:
+			}
+		}
+	}
+}
+
+func (st *scaledTicker) C() <-chan time.Time   { return st.c }
+func (st *scaledTicker) Reset(d time.Duration) { st.t.Reset(st.clock.real(d)) }
+
+func (st *scaledTicker) Stop() {
+	st.t.Stop()
+	st.stop.Do(func() { close(st.done) })
+}
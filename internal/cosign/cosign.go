@@ -0,0 +1,259 @@
+// Package cosign verifies `cosign sign-blob --bundle` signatures over
+// artifacts, including the Rekor signed entry timestamp, without pulling in
+// the sigstore client libraries. Both key-based signatures and keyless
+// (Fulcio certificate) signatures are supported.
+package cosign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Bundle 是 cosign sign-blob --bundle 输出的 JSON。
+type Bundle struct {
+	Base64Signature string       `json:"base64Signature"`
+	Cert            string       `json:"cert,omitempty"` // keyless：base64 编码的 PEM 证书
+	RekorBundle     *RekorBundle `json:"rekorBundle,omitempty"`
+}
+
+type RekorBundle struct {
+	SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+	Payload              RekorPayload `json:"Payload"`
+}
+
+// RekorPayload 字段按键名排序声明，json.Marshal 的结果即 Rekor 签名使用的规范 JSON。
+type RekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// Verifier holds the trust policy. Set Key for key-based signing, or Roots
+// (plus optional Identity / Issuer) and Rekor for keyless. With Rekor set, a
+// valid transparency-log entry for the artifact is required.
+type Verifier struct {
+	Key      crypto.PublicKey
+	Roots    *x509.CertPool
+	Identity string // keyless：证书 SAN（邮箱或 URI）须与之相等
+	Issuer   string // keyless：Fulcio OIDC issuer 扩展须与之相等
+	Rekor    crypto.PublicKey
+}
+
+// Fulcio 证书中记录 OIDC issuer 的扩展：旧版为原始字符串，新版（.1.8）为 DER UTF8String。
+var (
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// Check rejects a policy that would accept keyless signatures without a
+// transparency-log entry: a Fulcio certificate only proves its key was valid
+// for ten minutes, and only the Rekor integrated time shows the signature was
+// made within them.
+func (v *Verifier) Check() error {
+	if v.Roots != nil && v.Rekor == nil {
+		return errors.New("cosign: keyless verification (roots) needs a Rekor public key")
+	}
+	return nil
+}
+
+// Verify checks bundle against the artifact's sha256 (hex).
+func (v *Verifier) Verify(sha256hex string, bundle []byte) error {
+	var b Bundle
+	if err := json.Unmarshal(bundle, &b); err != nil {
+		return fmt.Errorf("cosign: parse bundle: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(b.Base64Signature)
+	if err != nil || len(sig) == 0 {
+		return errors.New("cosign: bundle has no valid signature")
+	}
+	digest, err := hex.DecodeString(sha256hex)
+	if err != nil || len(digest) != sha256.Size {
+		return errors.New("cosign: invalid artifact digest")
+	}
+
+	keyless := b.Cert != "" && v.Roots != nil
+	if v.Rekor == nil {
+		if keyless {
+			return errors.New("cosign: keyless signatures need a Rekor public key")
+		}
+	} else if b.RekorBundle == nil {
+		return errors.New("cosign: bundle has no Rekor entry")
+	}
+	var integrated time.Time
+	if v.Rekor != nil {
+		if integrated, err = v.verifyRekor(b.RekorBundle, sha256hex, b.Base64Signature); err != nil {
+			return err
+		}
+	}
+
+	key := v.Key
+	if keyless {
+		// keyless 证书只有十分钟有效期：以 Rekor 记录的记入时间校验证书链与有效期，过期或被盗的证书无法事后签名
+		cert, err := v.verifyCert(b.Cert, integrated)
+		if err != nil {
+			return err
+		}
+		key = cert.PublicKey
+	}
+	if key == nil {
+		return errors.New("cosign: no trusted key or certificate for this bundle")
+	}
+	return verifyDigest(key, digest, sig)
+}
+
+// verifyCert checks the Fulcio certificate chain as of at, the time Rekor
+// integrated the signature.
+func (v *Verifier) verifyCert(b64 string, at time.Time) (*x509.Certificate, error) {
+	raw, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("cosign: decode certificate: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("cosign: certificate is not PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	// 证书在签名当时有效即可，由 Rekor 的记入时间证明；该时间不在证书有效期内时链校验失败
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:       v.Roots,
+		CurrentTime: at,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cosign: certificate chain: %w", err)
+	}
+	if v.Identity != "" && !certHasIdentity(cert, v.Identity) {
+		return nil, fmt.Errorf("cosign: certificate identity does not match %q", v.Identity)
+	}
+	if v.Issuer != "" {
+		issuer := ""
+		for _, ext := range cert.Extensions {
+			switch {
+			case ext.Id.Equal(oidIssuerV2):
+				_, _ = asn1.UnmarshalWithParams(ext.Value, &issuer, "utf8")
+			case ext.Id.Equal(oidIssuerV1) && issuer == "":
+				issuer = string(ext.Value)
+			}
+		}
+		if issuer != v.Issuer {
+			return nil, fmt.Errorf("cosign: certificate issuer %q, want %q", issuer, v.Issuer)
+		}
+	}
+	return cert, nil
+}
+
+func certHasIdentity(cert *x509.Certificate, id string) bool {
+	for _, e := range cert.EmailAddresses {
+		if e == id {
+			return true
+		}
+	}
+	for _, u := range cert.URIs {
+		if u.String() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyRekor 校验 SignedEntryTimestamp，并确认条目记录的正是这个制品与签名。
+func (v *Verifier) verifyRekor(rb *RekorBundle, sha256hex, b64sig string) (time.Time, error) {
+	payload, _ := json.Marshal(rb.Payload)
+	h := sha256.Sum256(payload)
+	if err := verifyDigest(v.Rekor, h[:], rb.SignedEntryTimestamp); err != nil {
+		return time.Time{}, errors.New("cosign: Rekor signed entry timestamp is invalid")
+	}
+	body, err := base64.StdEncoding.DecodeString(rb.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cosign: decode Rekor entry: %w", err)
+	}
+	var entry struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Data struct {
+				Hash struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content string `json:"content"`
+			} `json:"signature"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("cosign: parse Rekor entry: %w", err)
+	}
+	if entry.Kind != "hashedrekord" || entry.Spec.Data.Hash.Algorithm != "sha256" ||
+		entry.Spec.Data.Hash.Value != sha256hex || entry.Spec.Signature.Content != b64sig {
+		return time.Time{}, errors.New("cosign: Rekor entry does not match this artifact and signature")
+	}
+	return time.Unix(rb.Payload.IntegratedTime, 0), nil
+}
+
+func verifyDigest(key crypto.PublicKey, digest, sig []byte) error {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(k, digest, sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig) == nil {
+			return nil
+		}
+	case ed25519.PublicKey:
+		// ed25519 对原文签名，这里只拿得到摘要；cosign 的 ed25519 blob 签名不受支持
+		return errors.New("cosign: ed25519 keys are not supported for blob signatures")
+	default:
+		return fmt.Errorf("cosign: unsupported key type %T", key)
+	}
+	return errors.New("cosign: signature verification failed")
+}
+
+// ParsePublicKey parses a PEM "PUBLIC KEY" block (cosign.pub, Rekor key).
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("cosign: public key is not PEM")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// LoadPublicKey reads and parses a PEM public key file.
+func LoadPublicKey(fp string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(b)
+}
+
+// LoadRoots reads a PEM bundle of trusted Fulcio root/intermediate certificates.
+func LoadRoots(fp string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("cosign: no certificates in " + fp)
+	}
+	return pool, nil
+}
@@ -0,0 +1,95 @@
+// Package launch parses the launch templates that start interpreted
+// algorithms, e.g. "python3 {dir}/main.py --model {dir}/model.onnx". It is
+// shared by the platform, which rejects bad templates at publish time, and
+// the agent, which validates them again and expands them into an argv.
+package launch
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// 模板按空白切分为参数，不经过 shell 直接执行，因此参数中不能含空格，也不支持管道、重定向等 shell 语法。
+// 可用的变量：
+//   - {dir}：版本的安装目录（制品包解压后的目录）；
+//   - {version}：版本号；
+//   - {install_dir}：agent 的安装目录。
+//
+// 程序本身不能是 shell：用 sh -c 包一层会让 agent 监管的是 shell 而不是算法进程。
+
+// Vars 是模板可用的变量名。
+var Vars = []string{"dir", "version", "install_dir"}
+
+// shells 不能作为模板的程序。
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "ash": true, "zsh": true, "ksh": true, "busybox": true, "env": true}
+
+// Template 是解析后的启动模板。
+type Template struct {
+	args []string
+}
+
+// Parse validates a launch template.
+func Parse(s string) (*Template, error) {
+	args := strings.Fields(s)
+	if len(args) == 0 {
+		return nil, errors.New("launch template is empty")
+	}
+	for _, a := range args {
+		if strings.ContainsAny(a, "|&;<>`$\"'\\") {
+			return nil, fmt.Errorf("launch template argument %q uses shell syntax; the template is executed without a shell", a)
+		}
+		if err := checkVars(a); err != nil {
+			return nil, err
+		}
+	}
+	if prog := path.Base(args[0]); shells[prog] {
+		return nil, fmt.Errorf("launch template runs %s; start the interpreter directly so the agent supervises the algorithm process", prog)
+	}
+	return &Template{args: args}, nil
+}
+
+// checkVars reports unknown or unbalanced {variables} in one argument.
+func checkVars(a string) error {
+	for rest := a; rest != ""; {
+		i := strings.IndexAny(rest, "{}")
+		if i < 0 {
+			return nil
+		}
+		if rest[i] == '}' {
+			return fmt.Errorf("launch template argument %q has an unmatched }", a)
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return fmt.Errorf("launch template argument %q has an unmatched {", a)
+		}
+		name := rest[i+1 : i+j]
+		known := false
+		for _, v := range Vars {
+			known = known || v == name
+		}
+		if !known {
+			return fmt.Errorf("launch template argument %q: unknown variable {%s} (want %s)", a, name, "{"+strings.Join(Vars, "}, {")+"}")
+		}
+		rest = rest[i+j+1:]
+	}
+	return nil
+}
+
+// String returns the template as published.
+func (t *Template) String() string { return strings.Join(t.args, " ") }
+
+// Expand substitutes vars into the template and returns the argv.
+func (t *Template) Expand(vars map[string]string) []string {
+	pairs := make([]string, 0, 2*len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, "{"+k+"}", v)
+	}
+	r := strings.NewReplacer(pairs...)
+	argv := make([]string, len(t.args))
+	for i, a := range t.args {
+		argv[i] = r.Replace(a)
+	}
+	return argv
+}
@@ -0,0 +1,148 @@
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// 容器镜像归档：artifact_type 为 oci 的版本，制品是 docker save（manifest.json）或 OCI image layout
+// （index.json + blobs/sha256/）格式的 tar 包，可以再经 gzip 压缩，docker / nerdctl / podman 的 load
+// 都能导入。归档里必须恰好有一个镜像；镜像 ID 是其 config 的摘要，导入后以它给镜像打 tag。
+
+// maxArchiveJSON 限制归档中读入内存的 JSON 文件（manifest、index、config）的大小。
+const maxArchiveJSON = 1 << 20
+
+// ImageID reads the image archive r and returns the ID ("sha256:<hex>",
+// the digest of its config) of the one image it holds.
+func ImageID(r io.Reader) (string, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+	// JSON 文件都不大，层可能很大：只把小文件读入内存
+	files := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("image archive: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg || h.Size > maxArchiveJSON {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return "", fmt.Errorf("image archive: %w", err)
+		}
+		files[path.Clean(h.Name)] = b
+	}
+	if b, ok := files["manifest.json"]; ok {
+		return dockerImageID(files, b)
+	}
+	if b, ok := files["index.json"]; ok {
+		return layoutImageID(files, b)
+	}
+	return "", errors.New("image archive: neither manifest.json (docker save) nor index.json (OCI image layout) found")
+}
+
+// dockerImageID reads a docker save archive.
+func dockerImageID(files map[string][]byte, manifest []byte) (string, error) {
+	var images []struct {
+		Config string `json:"Config"`
+	}
+	if err := json.Unmarshal(manifest, &images); err != nil {
+		return "", fmt.Errorf("image archive: manifest.json: %w", err)
+	}
+	if len(images) != 1 {
+		return "", fmt.Errorf("image archive holds %d images, want exactly one", len(images))
+	}
+	name := path.Clean(images[0].Config)
+	// 旧格式为 <hex>.json，新格式为 blobs/sha256/<hex>
+	return configDigest(files, name, "sha256:"+strings.TrimSuffix(path.Base(name), ".json"))
+}
+
+// layoutImageID reads an OCI image layout archive, following a nested index
+// (as docker writes) to the image manifest.
+func layoutImageID(files map[string][]byte, index []byte) (string, error) {
+	b := index
+	for depth := 0; depth < 3; depth++ {
+		var m struct {
+			Manifests []Descriptor `json:"manifests"`
+			Config    *Descriptor  `json:"config"`
+		}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return "", fmt.Errorf("image archive: %w", err)
+		}
+		if m.Config != nil {
+			return configDigest(files, blobPath(m.Config.Digest), m.Config.Digest)
+		}
+		if len(m.Manifests) != 1 {
+			return "", fmt.Errorf("image archive holds %d images, want exactly one", len(m.Manifests))
+		}
+		var err error
+		if b, err = blob(files, m.Manifests[0].Digest); err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("image archive: index nested too deeply")
+}
+
+func blobPath(digest string) string {
+	alg, hexDigest, _ := strings.Cut(digest, ":")
+	return path.Join("blobs", alg, hexDigest)
+}
+
+// blob returns the content of digest, checking it matches.
+func blob(files map[string][]byte, digest string) ([]byte, error) {
+	b, ok := files[blobPath(digest)]
+	if !ok {
+		return nil, fmt.Errorf("image archive: blob %s missing", digest)
+	}
+	if err := checkDigest(b, digest); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// configDigest checks that the config stored at name has the digest id.
+func configDigest(files map[string][]byte, name, id string) (string, error) {
+	b, ok := files[name]
+	if !ok {
+		return "", fmt.Errorf("image archive: config %s missing", name)
+	}
+	if err := checkDigest(b, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func checkDigest(b []byte, digest string) error {
+	alg, want, _ := strings.Cut(digest, ":")
+	if alg != "sha256" {
+		return fmt.Errorf("image archive: unsupported digest %q", digest)
+	}
+	sum := sha256.Sum256(b)
+	if hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("image archive: %s does not match its content", digest)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+// Package oci is a minimal OCI distribution client: enough to push a
+// single-layer artifact with annotations and to pull it back. It speaks the
+// registry v2 API with basic or bearer-token auth, so existing registry
+// replication and access control can be reused for algorithm artifacts.
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// 制品使用的媒体类型（OCI 1.1 artifact：空 config + 单层）。
+const (
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ArtifactType      = "application/vnd.dronealgo.algorithm.v1"
+	LayerMediaType    = "application/vnd.dronealgo.algorithm.layer.v1"
+	EmptyMediaType    = "application/vnd.oci.empty.v1+json"
+)
+
+// 写入 manifest 的注解键。
+const (
+	AnnotationVersion = "org.opencontainers.image.version"
+	AnnotationCreated = "org.opencontainers.image.created"
+	AnnotationTitle   = "org.opencontainers.image.title"
+	AnnotationChannel = "io.dronealgo.channel"
+	AnnotationSha256  = "io.dronealgo.sha256"
+	AnnotationNotes   = "io.dronealgo.notes"
+	AnnotationFormat  = "io.dronealgo.format"
+	AnnotationCosign  = "io.dronealgo.cosign.bundle"
+	// 结构化发布说明中 agent 安装策略用到的字段
+	AnnotationSafety   = "io.dronealgo.safety"
+	AnnotationBreaking = "io.dronealgo.breaking"
+	// 强制版本不受设备端电量门限限制
+	AnnotationMandatory = "io.dronealgo.mandatory"
+	// 启动模板，非空时制品是 tar.gz 或 zip 包
+	AnnotationLaunch = "io.dronealgo.launch"
+	// 制品类型：oci 时制品是容器镜像归档（见 archive.go），缺省为 binary
+	AnnotationArtifactType = "io.dronealgo.artifact_type"
+	// 服务端对 sha256 摘要的 ed25519 分离签名（base64）与签名密钥 ID
+	AnnotationSignature = "io.dronealgo.signature"
+	AnnotationKeyID     = "io.dronealgo.signature.key_id"
+)
+
+// emptyConfig 是 OCI 规范定义的空 config blob（"{}"）。
+var emptyConfig = Descriptor{
+	MediaType: EmptyMediaType,
+	Digest:    "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+	Size:      2,
+}
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Client talks to one repository of one registry.
+type Client struct {
+	base     string // scheme://host[:port]
+	repo     string
+	username string
+	password string
+	http     *http.Client
+
+	mu    sync.Mutex
+	token string // bearer token，401 时按 WWW-Authenticate 重新获取
+}
+
+// NewClient parses ref ("[http[s]://]host[:port]/repo/path", https by
+// default) and returns a client for that repository.
+func NewClient(ref, username, password string, hc *http.Client) (*Client, error) {
+	scheme := "https"
+	if s, rest, ok := strings.Cut(ref, "://"); ok {
+		scheme, ref = s, rest
+	}
+	host, repo, ok := strings.Cut(strings.TrimSuffix(ref, "/"), "/")
+	if !ok || host == "" || repo == "" {
+		return nil, fmt.Errorf("oci: invalid repository reference %q (want host/repo)", ref)
+	}
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{base: scheme + "://" + host, repo: repo, username: username, password: password, http: hc}, nil
+}
+
+// Ref returns the repository reference without scheme, e.g. for logs.
+func (c *Client) Ref() string {
+	return strings.SplitN(c.base, "://", 2)[1] + "/" + c.repo
+}
+
+// Push uploads the artifact blob (if missing) and tags a manifest carrying
+// annotations with each of tags.
+func (c *Client) Push(ctx context.Context, blob io.ReadSeeker, size int64, sha256hex string, annotations map[string]string, tags ...string) error {
+	digest := "sha256:" + sha256hex
+	if err := c.pushBlob(ctx, emptyConfig.Digest, bytes.NewReader([]byte("{}"))); err != nil {
+		return fmt.Errorf("push config: %w", err)
+	}
+	if err := c.pushBlob(ctx, digest, blob); err != nil {
+		return fmt.Errorf("push blob: %w", err)
+	}
+	layer := Descriptor{MediaType: LayerMediaType, Digest: digest, Size: size, Annotations: map[string]string{AnnotationTitle: "algorithm"}}
+	m := Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		ArtifactType:  ArtifactType,
+		Config:        emptyConfig,
+		Layers:        []Descriptor{layer},
+		Annotations:   annotations,
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		resp, err := c.do(ctx, http.MethodPut, "/manifests/"+tag, bytes.NewReader(body), "push",
+			http.Header{"Content-Type": {ManifestMediaType}})
+		if err != nil {
+			return fmt.Errorf("push manifest %s: %w", tag, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("push manifest %s: %s", tag, resp.Status)
+		}
+	}
+	return nil
+}
+
+// Tag points each of tags at the manifest tagged src. It returns ErrNotFound
+// when src is unknown.
+func (c *Client) Tag(ctx context.Context, src string, tags ...string) error {
+	resp, err := c.do(ctx, http.MethodGet, "/manifests/"+src, nil, "push", http.Header{"Accept": {ManifestMediaType}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get manifest %s: %s", src, resp.Status)
+	}
+	// 原样重放 manifest 字节，digest 不变
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		resp, err := c.do(ctx, http.MethodPut, "/manifests/"+tag, bytes.NewReader(body), "push",
+			http.Header{"Content-Type": {ManifestMediaType}})
+		if err != nil {
+			return fmt.Errorf("tag %s: %w", tag, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("tag %s: %s", tag, resp.Status)
+		}
+	}
+	return nil
+}
+
+func (c *Client) pushBlob(ctx context.Context, digest string, r io.ReadSeeker) error {
+	resp, err := c.do(ctx, http.MethodHead, "/blobs/"+digest, nil, "push", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil // 已存在（例如同一二进制发布到多个版本）
+	}
+
+	resp, err = c.do(ctx, http.MethodPost, "/blobs/uploads/", nil, "push", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("start upload: %s", resp.Status)
+	}
+	loc, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("upload location: %w", err)
+	}
+	q := loc.Query()
+	q.Set("digest", digest)
+	loc.RawQuery = q.Encode()
+
+	// 单次 PUT 上传整个 blob（monolithic upload）
+	resp, err = c.doURL(ctx, http.MethodPut, loc.String(), r, "push", http.Header{
+		"Content-Type": {"application/octet-stream"},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload blob: %s", resp.Status)
+	}
+	return nil
+}
+
+// Manifest fetches the manifest for tag (or digest).
+func (c *Client) Manifest(ctx context.Context, tag string) (*Manifest, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/manifests/"+tag, nil, "pull", http.Header{"Accept": {ManifestMediaType}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get manifest %s: %s", tag, resp.Status)
+	}
+	var m Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Blob streams a blob. Registries usually redirect to object storage;
+// net/http follows the redirect and drops Authorization across hosts.
+func (c *Client) Blob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/blobs/"+digest, nil, "pull", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("get blob %s: %s", digest, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// ErrNotFound is returned for unknown tags and blobs.
+var ErrNotFound = errors.New("oci: not found")
+
+func (c *Client) do(ctx context.Context, method, path string, body io.ReadSeeker, action string, h http.Header) (*http.Response, error) {
+	return c.doURL(ctx, method, c.base+"/v2/"+c.repo+path, body, action, h)
+}
+
+// doURL 发送请求；收到 401 时按 challenge 获取凭据后重试一次（body 需可 Seek）。
+func (c *Client) doURL(ctx context.Context, method, u string, body io.ReadSeeker, action string, h http.Header) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var rd io.Reader
+		var size int64
+		if body != nil {
+			n, err := body.Seek(0, io.SeekEnd)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			rd, size = body, n
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, rd)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		for k, v := range h {
+			req.Header[k] = v
+		}
+		c.authorize(req.Header)
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.login(ctx, challenge, action); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) authorize(h http.Header) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.token != "":
+		h.Set("Authorization", "Bearer "+c.token)
+	case c.username != "":
+		h.Set("Authorization", "Basic "+basicAuth(c.username, c.password))
+	}
+}
+
+// login 处理 registry 的 Bearer token 流程（Docker token auth）；Basic 直接使用账号口令。
+func (c *Client) login(ctx context.Context, challenge, action string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		if c.username == "" {
+			return errors.New("oci: registry requires credentials")
+		}
+		return nil
+	}
+	p := parseChallenge(params)
+	if p["realm"] == "" {
+		return errors.New("oci: bearer challenge without realm")
+	}
+	scope := "repository:" + c.repo + ":pull"
+	if action == "push" {
+		scope += ",push"
+	}
+	q := url.Values{"scope": {scope}}
+	if p["service"] != "" {
+		q.Set("service", p["service"])
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oci: token endpoint: %s", resp.Status)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.token = tok.Token
+	if c.token == "" {
+		c.token = tok.AccessToken
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+func parseChallenge(s string) map[string]string {
+	out := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			out[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return out
+}
+
+func basicAuth(user, pass string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+}
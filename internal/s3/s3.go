@@ -0,0 +1,258 @@
+// Package s3 is a minimal S3 client: enough to put and get objects in one
+// bucket with AWS Signature Version 4. It works with AWS S3 and with
+// S3-compatible stores (MinIO, Ceph RGW), so artifacts can be replicated to
+// a bucket in another region without pulling in an SDK.
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned by Get for a missing object.
+var ErrNotFound = errors.New("s3: object not found")
+
+// unsignedPayload 表示请求体不参与签名：制品流式上传，无法预先计算摘要，完整性由复制后的回读校验保证。
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Client talks to one bucket, optionally below a key prefix.
+type Client struct {
+	base      string // scheme://host[:port]，路径风格时桶名在路径中
+	bucket    string
+	prefix    string // 不含首尾的 /
+	region    string
+	pathStyle bool
+
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	http         *http.Client
+	now          func() time.Time
+}
+
+// NewClient parses ref ("s3://bucket[/prefix][?region=eu-west-1][&endpoint=https://minio.example.com:9000]")
+// and returns a client for that bucket. Without an endpoint it talks to AWS
+// (virtual-hosted style); with one it uses path-style requests, which
+// S3-compatible stores expect.
+func NewClient(ref, accessKey, secretKey, sessionToken string, hc *http.Client) (*Client, error) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid reference %q (want s3://bucket/prefix)", ref)
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("s3: access key and secret key are required")
+	}
+	q := u.Query()
+	c := &Client{
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       q.Get("region"),
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		http:         hc,
+		now:          time.Now,
+	}
+	if c.region == "" {
+		c.region = "us-east-1"
+	}
+	if ep := q.Get("endpoint"); ep != "" {
+		e, err := url.Parse(ep)
+		if err != nil || (e.Scheme != "http" && e.Scheme != "https") || e.Host == "" {
+			return nil, fmt.Errorf("s3: invalid endpoint %q", ep)
+		}
+		c.base, c.pathStyle = e.Scheme+"://"+e.Host, true
+	} else if strings.Contains(c.bucket, ".") {
+		// 带点的桶名与虚拟主机证书不匹配
+		c.base, c.pathStyle = "https://s3."+c.region+".amazonaws.com", true
+	} else {
+		c.base = "https://" + c.bucket + ".s3." + c.region + ".amazonaws.com"
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	return c, nil
+}
+
+// Ref returns the bucket and prefix, e.g. for logs.
+func (c *Client) Ref() string {
+	if c.prefix == "" {
+		return "s3://" + c.bucket
+	}
+	return "s3://" + c.bucket + "/" + c.prefix
+}
+
+// Put uploads size bytes from body to key, with meta as x-amz-meta-* headers.
+func (c *Client) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string, meta map[string]string) error {
+	req, err := c.newRequest(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range meta {
+		req.Header.Set("X-Amz-Meta-"+k, v)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get opens key for reading; the caller closes the body.
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	if c.prefix != "" {
+		key = c.prefix + "/" + key
+	}
+	path := "/" + key
+	if c.pathStyle {
+		path = "/" + c.bucket + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base, body)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Path, req.URL.RawPath = path, encodePath(path)
+	return req, nil
+}
+
+// do signs and sends req; a non-2xx response becomes an error carrying
+// S3's error code.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.sign(req, c.now())
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, fmt.Errorf("s3: %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, errorCode(b))
+}
+
+// errorCode extracts <Code> from an S3 XML error body, falling back to the
+// raw text.
+func errorCode(b []byte) string {
+	s := string(b)
+	if _, rest, ok := strings.Cut(s, "<Code>"); ok {
+		if code, _, ok := strings.Cut(rest, "</Code>"); ok {
+			return code
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header covering the
+// host and every x-amz-* and content-type header.
+func (c *Client) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signed,
+		unsignedPayload,
+	}, "\n")
+	scope := day + "/" + c.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), day)
+	for _, part := range []string{c.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+hex.EncodeToString(hmacSHA256(key, toSign)))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+func canonicalQuery(q url.Values) string {
+	var parts []string
+	for k, vs := range q {
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
+
+func encodePath(p string) string { return uriEncode(p, false) }
+
+// uriEncode percent-encodes everything but the unreserved characters (and
+// "/" unless slash is set), as SigV4 requires.
+func uriEncode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~', ch == '/' && !slash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,266 @@
+// Package tlog implements the RFC 6962 / RFC 9162 Merkle tree used by the
+// release transparency log: leaf and node hashing, inclusion and consistency
+// proofs, and ed25519-signed tree heads. The server builds proofs from the
+// full list of leaf hashes; agents and auditors only need the Verify
+// functions and the log's public key.
+package tlog
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Hash 是树中的一个节点哈希。
+type Hash [sha256.Size]byte
+
+func (h Hash) String() string { return hex.EncodeToString(h[:]) }
+
+// ParseHash decodes a hex hash.
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, fmt.Errorf("tlog: invalid hash %q", s)
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// LeafHash returns SHA-256(0x00 || data).
+func LeafHash(data []byte) Hash {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	var out Hash
+	h.Sum(out[:0])
+	return out
+}
+
+// NodeHash returns SHA-256(0x01 || left || right).
+func NodeHash(left, right Hash) Hash {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left[:])
+	h.Write(right[:])
+	var out Hash
+	h.Sum(out[:0])
+	return out
+}
+
+// split 返回小于 n 的最大 2 的幂（n >= 2）。
+func split(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// RootHash is the Merkle tree hash of leaves.
+func RootHash(leaves []Hash) Hash {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+	k := split(len(leaves))
+	return NodeHash(RootHash(leaves[:k]), RootHash(leaves[k:]))
+}
+
+// InclusionProof returns the audit path for leaf index in the tree formed by
+// leaves.
+func InclusionProof(leaves []Hash, index int) ([]Hash, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, errors.New("tlog: leaf index out of range")
+	}
+	var path []Hash
+	for len(leaves) > 1 {
+		k := split(len(leaves))
+		if index < k {
+			path = append(path, RootHash(leaves[k:]))
+			leaves = leaves[:k]
+		} else {
+			path = append(path, RootHash(leaves[:k]))
+			leaves, index = leaves[k:], index-k
+		}
+	}
+	// 自顶向下收集，校验时自底向上使用
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// ConsistencyProof proves that the tree of the first m leaves is a prefix of
+// the tree formed by leaves.
+func ConsistencyProof(leaves []Hash, m int) ([]Hash, error) {
+	if m < 0 || m > len(leaves) {
+		return nil, errors.New("tlog: tree size out of range")
+	}
+	if m == 0 || m == len(leaves) {
+		return nil, nil
+	}
+	return subProof(leaves, m, true), nil
+}
+
+func subProof(leaves []Hash, m int, complete bool) []Hash {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return nil
+		}
+		return []Hash{RootHash(leaves)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(subProof(leaves[:k], m, complete), RootHash(leaves[k:]))
+	}
+	return append(subProof(leaves[k:], m-k, false), RootHash(leaves[:k]))
+}
+
+// VerifyInclusion checks that leaf is at index in the tree of size with root.
+func VerifyInclusion(leaf Hash, index, size uint64, path []Hash, root Hash) error {
+	if index >= size {
+		return errors.New("tlog: leaf index out of range")
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range path {
+		if sn == 0 {
+			return errors.New("tlog: inclusion proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = NodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = NodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || r != root {
+		return errors.New("tlog: inclusion proof does not match root")
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree (size1, root1) is a prefix of the
+// tree (size2, root2).
+func VerifyConsistency(size1, size2 uint64, root1, root2 Hash, proof []Hash) error {
+	switch {
+	case size1 > size2:
+		return errors.New("tlog: tree shrank")
+	case size1 == size2:
+		if len(proof) != 0 || root1 != root2 {
+			return errors.New("tlog: different roots for the same tree size")
+		}
+		return nil
+	case size1 == 0:
+		return nil
+	}
+	if size1&(size1-1) == 0 {
+		// 旧树是完全二叉树时，其根即证明的起点
+		proof = append([]Hash{root1}, proof...)
+	}
+	if len(proof) == 0 {
+		return errors.New("tlog: empty consistency proof")
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errors.New("tlog: consistency proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = NodeHash(c, fr)
+			sr = NodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = NodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || fr != root1 || sr != root2 {
+		return errors.New("tlog: consistency proof does not match roots")
+	}
+	return nil
+}
+
+// TreeHead is a signed commitment to the log at a given size.
+type TreeHead struct {
+	TreeSize  uint64 `json:"tree_size"`
+	RootHash  string `json:"root_hash"` // hex
+	Timestamp int64  `json:"timestamp"` // Unix 毫秒
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"` // base64 ed25519
+}
+
+// message 是被签名的内容，纯文本便于审计方用任意语言复现。
+func (h *TreeHead) message() []byte {
+	return []byte(fmt.Sprintf("dronealgo-ota transparency log\n%d\n%s\n%d\n", h.TreeSize, h.RootHash, h.Timestamp))
+}
+
+// KeyID identifies a log key: the first 8 bytes of SHA-256(pub), hex.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// SignTreeHead signs the tree head for (size, root) at timestamp ms.
+func SignTreeHead(key ed25519.PrivateKey, size uint64, root Hash, ms int64) TreeHead {
+	h := TreeHead{TreeSize: size, RootHash: root.String(), Timestamp: ms, KeyID: KeyID(key.Public().(ed25519.PublicKey))}
+	h.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, h.message()))
+	return h
+}
+
+// Verify checks the tree head signature and returns the decoded root.
+func (h *TreeHead) Verify(pub ed25519.PublicKey) (Hash, error) {
+	if h.KeyID != KeyID(pub) {
+		return Hash{}, fmt.Errorf("tlog: tree head signed by unknown key %s", h.KeyID)
+	}
+	sig, err := base64.StdEncoding.DecodeString(h.Signature)
+	if err != nil || !ed25519.Verify(pub, h.message(), sig) {
+		return Hash{}, errors.New("tlog: invalid tree head signature")
+	}
+	return ParseHash(h.RootHash)
+}
+
+// Equal reports whether two tree heads commit to the same tree.
+func (h *TreeHead) Equal(o *TreeHead) bool {
+	return h.TreeSize == o.TreeSize && h.RootHash == o.RootHash
+}
+
+// ParseHashes decodes a list of hex hashes (proof paths on the wire).
+func ParseHashes(list []string) ([]Hash, error) {
+	out := make([]Hash, 0, len(list))
+	for _, s := range list {
+		h, err := ParseHash(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, nil
+}
+
+// FormatHashes encodes hashes as hex strings.
+func FormatHashes(list []Hash) []string {
+	out := make([]string, 0, len(list))
+	for _, h := range list {
+		out = append(out, h.String())
+	}
+	return out
+}
@@ -0,0 +1,45 @@
+// Package version compares the SemVer-like version strings used for
+// releases. It is shared by the platform and the agent so both sides agree
+// on what "newer" means.
+package version
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parse splits "MAJ.MIN.PATCH[-extra]" into its numeric parts (very simple).
+func parse(s string) (int, int, int) {
+	s = strings.SplitN(s, "-", 2)[0]
+	parts := strings.Split(s, ".")
+	get := func(i int) int {
+		if i >= len(parts) {
+			return 0
+		}
+		n, _ := strconv.Atoi(parts[i])
+		return n
+	}
+	return get(0), get(1), get(2)
+}
+
+// Newer reports whether a is newer than b.
+func Newer(a, b string) bool {
+	amaj, amin, apat := parse(a)
+	bmaj, bmin, bpat := parse(b)
+
+	if amaj != bmaj {
+		return amaj > bmaj
+	}
+	if amin != bmin {
+		return amin > bmin
+	}
+	return apat > bpat
+}
+
+// SameMinor reports whether a and b share major and minor version, i.e. an
+// update between them is patch-level only.
+func SameMinor(a, b string) bool {
+	amaj, amin, _ := parse(a)
+	bmaj, bmin, _ := parse(b)
+	return amaj == bmaj && amin == bmin
+}
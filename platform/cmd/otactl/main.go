@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otactl 是面向运维的命令行工具，通过服务端 API 工作，令牌取自 -token 或环境变量 OTA_AUTH_TOKEN：
+//
+//	otactl support-bundle -device drone-01 [-days 30] [-o bundle.tar.gz]
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "support-bundle":
+		os.Exit(supportBundle(os.Args[2:]))
+	}
+	usage()
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: otactl support-bundle -device <id> [-days N] [-o file] [-server URL] [-token TOKEN]")
+	os.Exit(2)
+}
+
+// client 持有服务端地址与令牌。
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func clientFlags(flags *flag.FlagSet) func() *client {
+	server := flags.String("server", "http://127.0.0.1:1573", "OTA server base URL")
+	token := flags.String("token", "", "API token (default $OTA_AUTH_TOKEN)")
+	return func() *client {
+		c := &client{server: strings.TrimRight(*server, "/"), token: *token, http: &http.Client{Timeout: 5 * time.Minute}}
+		if c.token == "" {
+			c.token = os.Getenv("OTA_AUTH_TOKEN")
+		}
+		return c
+	}
+}
+
+// get performs an authenticated GET and turns error responses into errors.
+func (c *client) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var body struct {
+			Detail string `json:"detail"`
+		}
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(b, &body) == nil && body.Detail != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, body.Detail)
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+func supportBundle(args []string) int {
+	flags := flag.NewFlagSet("support-bundle", flag.ExitOnError)
+	device := flags.String("device", "", "device ID")
+	days := flags.Int("days", 30, "days of history to include")
+	out := flags.String("o", "", "output file (default support-<device>.tar.gz)")
+	newClient := clientFlags(flags)
+	_ = flags.Parse(args)
+	if *device == "" {
+		usage()
+	}
+	if *out == "" {
+		*out = "support-" + *device + ".tar.gz"
+	}
+	resp, err := newClient().get("/api/v1/devices/" + url.PathEscape(*device) + "/support-bundle?days=" + strconv.Itoa(*days))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+		return 1
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(*out)
+		fmt.Fprintf(os.Stderr, "support-bundle: %v\n", err)
+		return 1
+	}
+	fmt.Printf("wrote %s (%d KiB)\n", *out, n>>10)
+	return 0
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+var alertClient = &http.Client{Timeout: 5 * time.Second}
+
+// emitAlert logs the event and posts it to the webhook in the background;
+// without a webhook alerts only go to the log.
+func (p *Platform) emitAlert(event, detail string) {
+	log.Printf("ALERT %s: %s", event, detail)
+	if p.alertWebhook == "" {
+		return
+	}
+	body, _ := json.Marshal(map[string]any{
+		"event":  event,
+		"detail": detail,
+		"time":   p.clock.Now(),
+	})
+	go func() {
+		resp, err := alertClient.Post(p.alertWebhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("alert webhook: %v", err)
+			return
+		}
+		_ = resp.Body.Close()
+	}()
+}
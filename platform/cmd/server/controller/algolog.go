@@ -0,0 +1,403 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 算法日志：agent 开启 algo_log.upload 后把算法输出按批上传到 POST /api/v1/logs
+// （{"device_id", "lines", "dropped"}），每行带读取时间、应用与运行的版本。文件后端下按设备、按接收日
+// （UTC）追加到 <DataDir>/algo_logs/d-<device>/<YYYY-MM-DD>.jsonl，内存后端下每台设备只保留最近
+// maxMemAlgoLogLines 行。超过 AlgoLogRetention（默认 7 天）的日志随事件日志的后台清理删除，
+// /api/v1/purge 一并删除设备的日志。GET /api/v1/devices/<id>/logs（管理员）按时间、应用、版本与
+// 关键字筛选，返回最近的 limit 行，远程排查有问题的版本时无需登录设备。
+
+const (
+	defaultAlgoLogRetention = 7 * 24 * time.Hour
+	maxAlgoLogBatch         = 1000
+	maxAlgoLogLine          = 4096
+	maxMemAlgoLogLines      = 10000
+
+	defaultAlgoLogLimit = 1000
+	maxAlgoLogLimit     = 10000
+)
+
+// AlgoLogLine 是算法输出的一行。
+type AlgoLogLine struct {
+	Time     time.Time `json:"time"` // agent 读到这一行的时间
+	Received time.Time `json:"received"`
+	App      string    `json:"app,omitempty"` // 为空即主应用
+	Version  string    `json:"version,omitempty"`
+	Line     string    `json:"line"`
+}
+
+// algoLogStore 保存设备上传的算法日志；dir 为空时只在内存中。
+type algoLogStore struct {
+	mu        sync.Mutex
+	dir       string
+	fsync     bool
+	retention time.Duration
+	mem       map[string][]AlgoLogLine
+}
+
+func openAlgoLogStore(dir string, retention time.Duration, fsync bool) (*algoLogStore, error) {
+	if retention <= 0 {
+		retention = defaultAlgoLogRetention
+	}
+	s := &algoLogStore{dir: dir, fsync: fsync, retention: retention, mem: map[string][]AlgoLogLine{}}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// deviceDir is the directory of a device's logs; escaping keeps IDs such as
+// "../x" inside the store.
+func (s *algoLogStore) deviceDir(device string) string {
+	return filepath.Join(s.dir, "d-"+url.PathEscape(device))
+}
+
+func (s *algoLogStore) append(device string, lines []AlgoLogLine, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		all := append(s.mem[device], lines...)
+		if n := len(all) - maxMemAlgoLogLines; n > 0 {
+			all = append([]AlgoLogLine(nil), all[n:]...)
+		}
+		s.mem[device] = all
+		return nil
+	}
+	dir := s.deviceDir(device)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, now.UTC().Format("2006-01-02")+".jsonl"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range lines {
+		if err := enc.Encode(&lines[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if s.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// algoLogFilter 是日志查询条件，零值不限制。
+type algoLogFilter struct {
+	since, until time.Time
+	app, version string
+	appSpecified bool
+	contains     string
+	limit        int
+}
+
+func (f *algoLogFilter) match(l *AlgoLogLine) bool {
+	return (f.since.IsZero() || !l.Time.Before(f.since)) &&
+		(f.until.IsZero() || l.Time.Before(f.until)) &&
+		(!f.appSpecified || l.App == f.app) &&
+		(f.version == "" || l.Version == f.version) &&
+		(f.contains == "" || strings.Contains(l.Line, f.contains))
+}
+
+// query returns the last f.limit matching lines, oldest first, and whether
+// older matches were left out.
+func (s *algoLogStore) query(device string, f *algoLogFilter) ([]AlgoLogLine, bool, error) {
+	var out []AlgoLogLine
+	truncated := false
+	keep := func(l *AlgoLogLine) {
+		if !f.match(l) {
+			return
+		}
+		out = append(out, *l)
+		if len(out) > f.limit {
+			out, truncated = out[1:], true
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir == "" {
+		for i := range s.mem[device] {
+			keep(&s.mem[device][i])
+		}
+		return out, truncated, nil
+	}
+	days, err := s.days(device)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, day := range days {
+		// 按接收日分文件，读取时间不晚于接收时间，早于 since 当天的文件不必读
+		if !f.since.IsZero() && day < f.since.UTC().Format("2006-01-02") {
+			continue
+		}
+		if err := scanAlgoLog(filepath.Join(s.deviceDir(device), day+".jsonl"), keep); err != nil {
+			return nil, false, err
+		}
+	}
+	return out, truncated, nil
+}
+
+// days lists the dates of a device's log files, oldest first.
+func (s *algoLogStore) days(device string) ([]string, error) {
+	ents, err := os.ReadDir(s.deviceDir(device))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range ents {
+		if day, ok := strings.CutSuffix(e.Name(), ".jsonl"); ok {
+			out = append(out, day)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func scanAlgoLog(fp string, fn func(*AlgoLogLine)) error {
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var l AlgoLogLine
+		// 写入中途崩溃留下的半行跳过
+		if json.Unmarshal(sc.Bytes(), &l) == nil {
+			fn(&l)
+		}
+	}
+	return sc.Err()
+}
+
+// compact removes the logs received before the retention window.
+func (s *algoLogStore) compact(now time.Time) (int, error) {
+	cutoff := now.Add(-s.retention)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	if s.dir == "" {
+		for dev, lines := range s.mem {
+			i := sort.Search(len(lines), func(i int) bool { return !lines[i].Received.Before(cutoff) })
+			n += i
+			if i == len(lines) {
+				delete(s.mem, dev)
+			} else if i > 0 {
+				s.mem[dev] = append([]AlgoLogLine(nil), lines[i:]...)
+			}
+		}
+		return n, nil
+	}
+	devs, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	// 文件按接收日命名，整天都早于保留期时删除
+	last := cutoff.UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	for _, d := range devs {
+		dir := filepath.Join(s.dir, d.Name())
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		left := len(ents)
+		for _, e := range ents {
+			if day, ok := strings.CutSuffix(e.Name(), ".jsonl"); ok && day <= last {
+				if err := os.Remove(filepath.Join(dir, e.Name())); err == nil {
+					n++
+					left--
+				}
+			}
+		}
+		if left == 0 {
+			_ = os.Remove(dir)
+		}
+	}
+	return n, nil
+}
+
+// purge deletes every log of the devices and returns how many of them had any.
+func (s *algoLogStore) purge(devices map[string]bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for dev := range devices {
+		if s.dir == "" {
+			if _, ok := s.mem[dev]; ok {
+				delete(s.mem, dev)
+				n++
+			}
+			continue
+		}
+		dir := s.deviceDir(dev)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (p *Platform) compactAlgoLogs() {
+	if n, err := p.algoLogs.compact(p.clock.Now()); err != nil {
+		log.Printf("algorithm log compact: %v", err)
+	} else if n > 0 {
+		log.Printf("algorithm log compact: removed %d expired files", n)
+	}
+}
+
+// IngestLogs godoc
+// @Summary      Upload algorithm logs
+// @Description  Accept a batch of algorithm output lines captured by the agent (algo_log.upload), tagged with the app and the version that wrote them. dropped is the number of lines the agent discarded while it could not upload; it is stored as a marker line. Lines longer than 4096 bytes are cut. The body may be sent with Content-Encoding: gzip.
+// @Tags         device
+// @Accept       json
+// @Produce      json
+// @Param        Content-Encoding  header  string  false  "gzip"
+// @Param        body  body  object  true  "{\"device_id\": \"...\", \"lines\": [{\"time\", \"app\", \"version\", \"line\"}], \"dropped\": 0}"
+// @Success      200  {object}  map[string]any  "accepted"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      415  {object}  map[string]any  "unsupported Content-Encoding"
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/logs [post]
+func (c *EventController) IngestLogs(g *gin.Context) {
+	var body struct {
+		DeviceID string        `json:"device_id"`
+		Lines    []AlgoLogLine `json:"lines"`
+		Dropped  int           `json:"dropped"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	if len(body.Lines) > maxAlgoLogBatch {
+		c.ResponseFailure(g, ErrParam, "at most "+strconv.Itoa(maxAlgoLogBatch)+" lines per upload")
+		return
+	}
+	id, ok := c.p.bindDevice(g, body.DeviceID)
+	if !ok {
+		return
+	}
+	if id == "" {
+		c.ResponseFailure(g, ErrParam, "device_id is required")
+		return
+	}
+	device := c.p.resolveDevice(id, g.GetHeader(instanceHeader))
+	now := c.p.clock.Now()
+	lines := make([]AlgoLogLine, 0, len(body.Lines)+1)
+	if body.Dropped > 0 {
+		mark := AlgoLogLine{Time: now, Line: fmt.Sprintf("[ota] %d lines dropped by the agent while offline", body.Dropped)}
+		if len(body.Lines) > 0 {
+			mark.Time, mark.App, mark.Version = body.Lines[0].Time, body.Lines[0].App, body.Lines[0].Version
+		}
+		lines = append(lines, mark)
+	}
+	lines = append(lines, body.Lines...)
+	for i := range lines {
+		l := &lines[i]
+		l.Received = now
+		// 设备时钟可能不准：缺省或来自未来的时间以服务端接收时间为准
+		if l.Time.IsZero() || l.Time.After(now) {
+			l.Time = now
+		}
+		if len(l.Line) > maxAlgoLogLine {
+			l.Line = l.Line[:maxAlgoLogLine]
+		}
+	}
+	if err := c.p.algoLogs.append(device, lines, now); err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "append algorithm log"), fsErr(err, "append algorithm log").Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"accepted": len(body.Lines)})
+}
+
+// Logs godoc
+// @Summary      Device algorithm logs
+// @Description  The most recent algorithm output lines uploaded by a device, oldest first. truncated is true when older lines also matched.
+// @Tags         device
+// @Produce      json
+// @Param        id        path   string  true   "Device ID"
+// @Param        since     query  string  false  "RFC 3339 time; only lines read at or after it"
+// @Param        until     query  string  false  "RFC 3339 time; only lines read before it"
+// @Param        app       query  string  false  "Only this app (\"\" is the primary algorithm); all apps when omitted"
+// @Param        version   query  string  false  "Only lines written by this version"
+// @Param        q         query  string  false  "Only lines containing this text"
+// @Param        limit     query  int     false  "At most this many lines, default 1000, at most 10000"
+// @Success      200  {object}  map[string]any  "lines, truncated"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/devices/{id}/logs [get]
+func (c *EventController) Logs(g *gin.Context) {
+	f := algoLogFilter{version: g.Query("version"), contains: g.Query("q"), limit: defaultAlgoLogLimit}
+	f.app, f.appSpecified = g.GetQuery("app")
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &f.since}, {"until", &f.until}} {
+		if v := g.Query(t.name); v != "" {
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.ResponseFailure(g, ErrParam, t.name+" must be an RFC 3339 time")
+				return
+			}
+			*t.dst = ts
+		}
+	}
+	if v := g.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAlgoLogLimit {
+			c.ResponseFailure(g, ErrParam, "limit must be between 1 and "+strconv.Itoa(maxAlgoLogLimit))
+			return
+		}
+		f.limit = n
+	}
+	lines, truncated, err := c.p.algoLogs.query(g.Param("id"), &f)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	if lines == nil {
+		lines = []AlgoLogLine{}
+	}
+	g.JSON(http.StatusOK, gin.H{"lines": lines, "truncated": truncated})
+}
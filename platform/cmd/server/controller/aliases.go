@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 版本别名：stable-eu、demo、v2-lts 这类具名指针指向一个具体版本，管理员可以随时原子地改指
+// （PUT /api/v1/aliases/<name>），不必为每个用途新开渠道。agent 配置 alias 后检查带 alias 参数，
+// 跟随别名指向的版本而不是渠道最新版本；令牌的渠道范围、更新策略与召回都按目标版本所在的渠道与版本判断。
+// 别名与应用绑定：只能改指同一应用的版本。与渠道一样只向更新的版本升级，别名改指更旧的版本不会让设备
+// 降级（需要时召回）。设置、改指与删除都记入审计日志。
+
+var aliasNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// Alias 是指向一个具体版本的具名指针。
+type Alias struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	App       string    `json:"app,omitempty"` // 目标版本所属应用，改指不能跨应用
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by"`
+}
+
+// aliasTarget resolves the alias a device of app subscribes to. Callers
+// hold p.store.mu.
+func (s *Store) aliasTarget(name, app string) (*Release, ErrCode, error) {
+	a := s.Aliases[name]
+	if a == nil {
+		return nil, ErrNotFound, errors.New("unknown alias " + name)
+	}
+	if a.App != app {
+		return nil, ErrParam, errors.New("alias " + name + " belongs to " + appLabel(a.App) + ", not " + appLabel(app))
+	}
+	rel := s.ReleasesByVersion[a.Version]
+	if rel == nil {
+		return nil, ErrNotFound, errors.New("alias " + name + " points at missing version " + a.Version)
+	}
+	if rel.Quarantine != nil {
+		return nil, ErrNotFound, errors.New("alias " + name + " points at quarantined version " + a.Version)
+	}
+	return rel, OK, nil
+}
+
+// Aliases godoc
+// @Summary      List version aliases
+// @Description  Named pointers to concrete versions, sorted by name, with the channel of each target.
+// @Tags         release
+// @Produce      json
+// @Success      200  {object}  map[string]any  "aliases"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/aliases [get]
+func (c *FileController) Aliases(g *gin.Context) {
+	c.p.reloadIfChanged()
+	type aliasView struct {
+		Alias
+		Channel string `json:"channel,omitempty"`
+	}
+	c.p.store.mu.RLock()
+	out := make([]aliasView, 0, len(c.p.store.Aliases))
+	for _, a := range c.p.store.Aliases {
+		v := aliasView{Alias: *a}
+		if rel := c.p.store.ReleasesByVersion[a.Version]; rel != nil {
+			v.Channel = rel.Channel
+		}
+		out = append(out, v)
+	}
+	c.p.store.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	g.JSON(http.StatusOK, gin.H{"aliases": out})
+}
+
+// PutAlias godoc
+// @Summary      Create or retarget a version alias
+// @Description  Point the alias at a version in one step; agents subscribed to it are offered the version on their next check if it is newer than what they run. An alias stays with the app of its first target. With expect, the alias is only moved while it still points at that version. Audited.
+// @Tags         release
+// @Accept       json
+// @Produce      json
+// @Param        name  path  string  true  "Alias name (letters, digits, ., _ and -), e.g. stable-eu"
+// @Param        body  body  object  true  "{\"version\": \"1.2.0\", \"expect\": \"1.1.0\", \"reason\": \"...\"}"
+// @Success      200  {object}  controller.Alias
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/aliases/{name} [put]
+func (c *FileController) PutAlias(g *gin.Context) {
+	var body struct {
+		Version string  `json:"version"`
+		Expect  *string `json:"expect"`
+		Reason  string  `json:"reason"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	name := g.Param("name")
+	if !aliasNameRe.MatchString(name) {
+		c.ResponseFailure(g, ErrParam, "invalid alias name (want letters, digits, ., _ and -)")
+		return
+	}
+	v := strings.TrimSpace(body.Version)
+	actor := c.p.principal(g).Name
+
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	rel := c.p.store.ReleasesByVersion[v]
+	old := c.p.store.Aliases[name]
+	cur := ""
+	if old != nil {
+		cur = old.Version
+	}
+	var fail string
+	code := ErrParam
+	switch {
+	case rel == nil:
+		code, fail = ErrNotFound, "unknown version "+v
+	case rel.Quarantine != nil:
+		fail = v + " is quarantined"
+	case old != nil && old.App != rel.App:
+		fail = "alias " + name + " belongs to " + appLabel(old.App) + "; " + v + " belongs to " + appLabel(rel.App)
+	case body.Expect != nil && *body.Expect != cur:
+		fail = "alias " + name + " points at " + strconv.Quote(cur) + ", not " + strconv.Quote(*body.Expect)
+	}
+	if fail != "" {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, code, fail)
+		return
+	}
+	a := &Alias{Name: name, Version: v, App: rel.App, UpdatedAt: c.p.clock.Now(), UpdatedBy: actor}
+	prev := c.p.store.Aliases
+	next := make(map[string]*Alias, len(prev)+1)
+	for k, x := range prev {
+		next[k] = x
+	}
+	next[name] = a
+	c.p.store.Aliases = next
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		c.p.store.Aliases = prev
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save alias"), fsErr(err, "save alias").Error())
+		return
+	}
+	c.p.kickMirror()
+	data := map[string]any{"alias": name, "version": v, "channel": rel.Channel}
+	if rel.App != "" {
+		data["app"] = rel.App
+	}
+	if old != nil {
+		data["from"] = old.Version
+	}
+	_ = c.p.audit(actor, "alias_set", strings.TrimSpace(body.Reason), data)
+	g.JSON(http.StatusOK, a)
+}
+
+// DeleteAlias godoc
+// @Summary      Delete a version alias
+// @Description  Agents still subscribed to the alias fail their checks until it is recreated. Audited.
+// @Tags         release
+// @Produce      json
+// @Param        name  path  string  true  "Alias name"
+// @Success      200  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/aliases/{name} [delete]
+func (c *FileController) DeleteAlias(g *gin.Context) {
+	name := g.Param("name")
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	prev := c.p.store.Aliases
+	old := prev[name]
+	if old == nil {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "no alias "+name)
+		return
+	}
+	next := make(map[string]*Alias, len(prev))
+	for k, x := range prev {
+		if k != name {
+			next[k] = x
+		}
+	}
+	c.p.store.Aliases = next
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		c.p.store.Aliases = prev
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save alias"), fsErr(err, "save alias").Error())
+		return
+	}
+	_ = c.p.audit(c.p.principal(g).Name, "alias_deleted", "", map[string]any{"alias": name, "version": old.Version})
+	g.JSON(http.StatusOK, gin.H{"deleted": name})
+}
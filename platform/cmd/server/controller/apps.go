@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// 多应用：一台无人机上避障、跟踪、降落等算法是各自独立的程序，agent 按应用分别检查与安装。
+// 发布与检查带 app 参数，为空即主应用（原有的单应用部署，旧 agent 不带该参数）。
+// 各应用的渠道互相独立：渠道最新版本按 “<app>/<channel>” 索引（主应用仍是 “<channel>”），
+// 令牌的渠道范围只看渠道部分。版本号在所有应用间唯一（制品按版本号存放），
+// 其它应用的版本建议带后缀区分，如 1.2.0-landing（比较版本时忽略后缀）。
+// 二分定位与影子部署只针对主应用。设备事件的 version 始终是主应用的版本：其它应用的检查记为
+// app_check 事件，应用名与版本在 data（app、current）中；agent 上报的安装结果与崩溃同样在 data 中带 app。
+//
+// AgentApp 是保留的组件名：它的版本是 agent 自身的程序，开启自更新的 agent 以自己的版本检查它，
+// 下载、校验后原地切换（见 agent 的 selfupdate.go）。agent 版本只能是普通二进制制品。
+
+// AgentApp 是托管 agent 程序的组件。
+const AgentApp = "agent"
+
+var appNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// checkAppName validates an app name; "" is the primary app.
+func checkAppName(app string) error {
+	if app != "" && !appNameRe.MatchString(app) {
+		return errors.New("invalid app (want lowercase letters, digits, _ and -)")
+	}
+	return nil
+}
+
+func appLabel(app string) string {
+	if app == "" {
+		return "the primary app"
+	}
+	return "app " + app
+}
+
+// latestKey is the LatestByChannel key of an app's channel.
+func latestKey(app, channel string) string {
+	if app == "" {
+		return channel
+	}
+	return app + "/" + channel
+}
+
+// splitLatestKey is the inverse of latestKey.
+func splitLatestKey(key string) (app, channel string) {
+	if app, channel, ok := strings.Cut(key, "/"); ok {
+		return app, channel
+	}
+	return "", key
+}
+
+// latestKey is the LatestByChannel key of the release's channel.
+func (r *Release) latestKey() string {
+	return latestKey(r.App, r.Channel)
+}
@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArtifactStore 保存各版本的制品二进制。
+type ArtifactStore interface {
+	// Stage copies r into a staging area; nothing is visible until Commit.
+	Stage(r io.Reader) (StagedArtifact, error)
+	// Open returns the artifact of version for serving.
+	Open(version string) (ArtifactReader, error)
+	// Describe names the backend for logs.
+	Describe() string
+}
+
+// StagedArtifact 是已写入但尚未对外可见的制品。
+type StagedArtifact interface {
+	// Commit atomically makes the staged bytes the artifact of version,
+	// restoring any previous artifact of that version on failure.
+	Commit(version string) error
+	// Discard drops the staged bytes; it is a no-op after Commit.
+	Discard()
+}
+
+// ArtifactReader 支持随机读取，交给 http.ServeContent 处理 Range 请求，也用于读取包内文件。
+type ArtifactReader interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	Size() int64
+	ModTime() time.Time
+}
+
+var errArtifactNotFound = errors.New("artifact not found")
+
+// fsArtifactStore 按 <dir>/<version>/algorithm 布局存放制品。
+type fsArtifactStore struct {
+	dir   string
+	fsync bool
+}
+
+// NewFSArtifactStore stores artifacts under dir, fsyncing writes if asked.
+func NewFSArtifactStore(dir string, fsync bool) ArtifactStore {
+	return &fsArtifactStore{dir: dir, fsync: fsync}
+}
+
+func (s *fsArtifactStore) Describe() string { return s.dir }
+
+func (s *fsArtifactStore) path(version string) string {
+	return filepath.Join(s.dir, version, "algorithm")
+}
+
+func (s *fsArtifactStore) Stage(r io.Reader) (StagedArtifact, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	tmp := f.Name()
+	_, err = io.Copy(f, r)
+	if err == nil && s.fsync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	return &fsStaged{store: s, tmp: tmp}, nil
+}
+
+type fsStaged struct {
+	store     *fsArtifactStore
+	tmp       string
+	committed bool
+}
+
+func (st *fsStaged) Commit(version string) error {
+	dst := st.store.path(version)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	backup := ""
+	if _, err := os.Stat(dst); err == nil {
+		backup = dst + ".bak"
+		if err := os.Rename(dst, backup); err != nil {
+			return errors.New("backup previous artifact: " + err.Error())
+		}
+	}
+	if err := os.Rename(st.tmp, dst); err != nil {
+		if backup != "" {
+			_ = os.Rename(backup, dst)
+		}
+		return errors.New("move artifact into place: " + err.Error())
+	}
+	st.committed = true
+	if backup != "" {
+		_ = os.Remove(backup)
+	}
+	return syncDir(filepath.Dir(dst), st.store.fsync)
+}
+
+func (st *fsStaged) Discard() {
+	if !st.committed {
+		_ = os.Remove(st.tmp)
+	}
+}
+
+func (s *fsArtifactStore) Versions(staleAge time.Duration) (versions, stale []string, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".upload-") {
+			if fi, err := e.Info(); err == nil && time.Since(fi.ModTime()) > staleAge {
+				stale = append(stale, name)
+			}
+			continue
+		}
+		if _, err := os.Stat(s.path(name)); e.IsDir() && err == nil {
+			versions = append(versions, name)
+		}
+	}
+	return versions, stale, nil
+}
+
+func (s *fsArtifactStore) Remove(version string) error {
+	if version == "" || strings.ContainsAny(version, `/\`) || strings.HasPrefix(version, ".") {
+		return errors.New("invalid version " + version)
+	}
+	return os.RemoveAll(filepath.Join(s.dir, version))
+}
+
+func (s *fsArtifactStore) RemoveStale(name string) error {
+	if !strings.HasPrefix(name, ".upload-") || strings.ContainsAny(name, `/\`) {
+		return errors.New("not a staging file: " + name)
+	}
+	return os.Remove(filepath.Join(s.dir, name))
+}
+
+type fsArtifact struct {
+	*os.File
+	fi os.FileInfo
+}
+
+func (a *fsArtifact) Size() int64        { return a.fi.Size() }
+func (a *fsArtifact) ModTime() time.Time { return a.fi.ModTime() }
+
+func (s *fsArtifactStore) Open(version string) (ArtifactReader, error) {
+	f, err := os.Open(s.path(version))
+	if os.IsNotExist(err) {
+		return nil, errArtifactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fsArtifact{File: f, fi: fi}, nil
+}
+
+// memoryArtifactStore 把制品保存在内存中，用于测试与演示。
+type memoryArtifactStore struct {
+	mu    sync.RWMutex
+	blobs map[string]*memBlob
+}
+
+type memBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemoryArtifactStore returns an artifact store that never touches disk.
+func NewMemoryArtifactStore() ArtifactStore {
+	return &memoryArtifactStore{blobs: map[string]*memBlob{}}
+}
+
+func (s *memoryArtifactStore) Describe() string { return "memory" }
+
+func (s *memoryArtifactStore) Stage(r io.Reader) (StagedArtifact, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &memStaged{store: s, data: b}, nil
+}
+
+type memStaged struct {
+	store *memoryArtifactStore
+	data  []byte
+}
+
+func (st *memStaged) Commit(version string) error {
+	st.store.mu.Lock()
+	defer st.store.mu.Unlock()
+	st.store.blobs[version] = &memBlob{data: st.data, modTime: time.Now()}
+	return nil
+}
+
+func (st *memStaged) Discard() {}
+
+type memArtifact struct {
+	*bytes.Reader
+	blob *memBlob
+}
+
+func (a *memArtifact) Close() error       { return nil }
+func (a *memArtifact) Size() int64        { return int64(len(a.blob.data)) }
+func (a *memArtifact) ModTime() time.Time { return a.blob.modTime }
+
+func (s *memoryArtifactStore) Open(version string) (ArtifactReader, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.blobs[version]
+	if !ok {
+		return nil, errArtifactNotFound
+	}
+	return &memArtifact{Reader: bytes.NewReader(b.data), blob: b}, nil
+}
+
+func (s *memoryArtifactStore) Versions(time.Duration) (versions, stale []string, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for v := range s.blobs {
+		versions = append(versions, v)
+	}
+	return versions, nil, nil
+}
+
+func (s *memoryArtifactStore) Remove(version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, version)
+	return nil
+}
+
+// RemoveStale is a no-op: staged uploads are never stored.
+func (s *memoryArtifactStore) RemoveStale(string) error { return nil }
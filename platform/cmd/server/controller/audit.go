@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditRecord 是一次需要留痕的操作（提权、提权期间的请求……），只追加，不修改。
+type AuditRecord struct {
+	Time   time.Time      `json:"time"`
+	Actor  string         `json:"actor"`
+	Action string         `json:"action"`
+	Reason string         `json:"reason,omitempty"`
+	Data   map[string]any `json:"data,omitempty"`
+}
+
+// auditLog 把审计记录追加到 <DataDir>/audit.jsonl（内存后端下只保存在内存中）。
+// 内存中只保留每条记录的索引（文件中的位置、时间、操作者与动作），足以过滤与排序；
+// 记录正文（原因与附加数据）在返回时按位置从文件读取，日志再长也不会全部载入内存。
+type auditLog struct {
+	mu     sync.Mutex
+	path   string
+	fsync  bool
+	size   int64 // 文件长度，即下一条记录的位置
+	torn   bool  // 文件以写了一半的记录结尾，下一条记录先换行
+	index  []auditIndex
+	memory []AuditRecord // 内存后端的记录，与 index 一一对应
+}
+
+// auditIndex 是一条审计记录在内存中的索引。
+type auditIndex struct {
+	off    int64
+	length int
+	time   time.Time
+	actor  string
+	action string
+}
+
+func openAuditLog(path string, fsync bool) (*auditLog, error) {
+	a := &auditLog{path: path, fsync: fsync}
+	if path == "" {
+		return a, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var r AuditRecord
+			if json.Unmarshal(line, &r) == nil {
+				a.index = append(a.index, auditIndex{off: a.size, length: len(line), time: r.Time, actor: r.Actor, action: r.Action})
+			}
+			a.size += int64(len(line))
+			a.torn = line[len(line)-1] != '\n'
+		}
+		if err == io.EOF {
+			return a, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (a *auditLog) append(r AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, _ := json.Marshal(r)
+	b = append(b, '\n')
+	if a.path == "" {
+		a.index = append(a.index, auditIndex{length: len(b), time: r.Time, actor: r.Actor, action: r.Action})
+		a.memory = append(a.memory, r)
+		return nil
+	}
+	if a.torn {
+		b = append([]byte{'\n'}, b...)
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if a.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	off := a.size
+	if a.torn {
+		off, b = off+1, b[1:]
+		a.size, a.torn = a.size+1, false
+	}
+	a.index = append(a.index, auditIndex{off: off, length: len(b), time: r.Time, actor: r.Actor, action: r.Action})
+	a.size += int64(len(b))
+	return nil
+}
+
+// list returns records newest first, at most limit of them.
+func (a *auditLog) list(since time.Time, limit int) ([]AuditRecord, error) {
+	a.mu.Lock()
+	var out []auditEntry
+	for i := len(a.index) - 1; i >= 0 && len(out) < limit; i-- {
+		if a.index[i].time.Before(since) {
+			break
+		}
+		out = append(out, auditEntry{n: i, idx: a.index[i]})
+	}
+	a.mu.Unlock()
+	return a.records(out)
+}
+
+// auditEntry 是带位置的审计记录索引，位置在只追加的日志中唯一，用作分页标识。
+type auditEntry struct {
+	n   int
+	idx auditIndex
+}
+
+// auditList 是审计记录的分页方式。
+var auditList = listSpec[auditEntry]{
+	id: func(e auditEntry) string { return seqKey(uint64(e.n)) },
+	sorts: map[string]sortField[auditEntry]{
+		"time":   {key: func(e auditEntry) string { return timeKey(e.idx.time) }},
+		"actor":  {key: func(e auditEntry) string { return e.idx.actor }},
+		"action": {key: func(e auditEntry) string { return e.idx.action }},
+	},
+	defaultSort: "-time",
+}
+
+// entries returns the index of the records since since, optionally only
+// those of actor and action; records loads them.
+func (a *auditLog) entries(since time.Time, actor, action string) []auditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := []auditEntry{}
+	for i, r := range a.index {
+		if r.time.Before(since) || (actor != "" && r.actor != actor) || (action != "" && r.action != action) {
+			continue
+		}
+		out = append(out, auditEntry{n: i, idx: r})
+	}
+	return out
+}
+
+// records reads the records of entries, in the same order.
+func (a *auditLog) records(entries []auditEntry) ([]AuditRecord, error) {
+	out := make([]AuditRecord, len(entries))
+	if len(entries) == 0 {
+		return out, nil
+	}
+	if a.path == "" {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for i, e := range entries {
+			out[i] = a.memory[e.n]
+		}
+		return out, nil
+	}
+	// 记录只追加，已索引的位置不会再变，读文件无需持有 a.mu
+	f, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	for i, e := range entries {
+		b := make([]byte, e.idx.length)
+		if _, err := f.ReadAt(b, e.idx.off); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &out[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// audit records an auditable action. Callers decide whether a write
+// failure blocks the action (granting access) or is only logged.
+func (p *Platform) audit(actor, action, reason string, data map[string]any) error {
+	r := AuditRecord{Time: p.clock.Now(), Actor: actor, Action: action, Reason: reason, Data: data}
+	log.Printf("AUDIT %s %s %v", actor, action, data)
+	if err := p.auditLog.append(r); err != nil {
+		log.Printf("audit log append: %v", err)
+		return err
+	}
+	return nil
+}
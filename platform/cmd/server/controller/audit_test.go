@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuditLogReadsRecordsFromDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a, err := openAuditLog(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, action := range []string{"recall", "approve", "recall"} {
+		r := AuditRecord{Time: start.Add(time.Duration(i) * time.Hour), Actor: "ops", Action: action, Data: map[string]any{"i": i}}
+		if err := a.append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 写到一半崩溃的记录被跳过，之后的记录另起一行
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2026-03-01T03:00:00Z","actor":"o`)
+	f.Close()
+
+	a, err = openAuditLog(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.append(AuditRecord{Time: start.Add(4 * time.Hour), Actor: "system", Action: "recall"}); err != nil {
+		t.Fatal(err)
+	}
+	a, err = openAuditLog(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := a.records(a.entries(start.Add(time.Hour), "", "recall"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].Data["i"] != 2.0 || recs[1].Actor != "system" {
+		t.Fatalf("recall records since 01:00 = %+v", recs)
+	}
+	recs, err = a.list(start, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 4 || recs[0].Actor != "system" || recs[3].Action != "recall" || recs[3].Data["i"] != 0.0 {
+		t.Fatalf("list = %+v, want 4 records newest first", recs)
+	}
+}
+
+func TestAuditLogInMemory(t *testing.T) {
+	a, err := openAuditLog("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := a.append(AuditRecord{Time: now, Actor: "ops", Action: "approve", Reason: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := a.list(now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Reason != "ok" {
+		t.Fatalf("list = %+v", recs)
+	}
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 调用方角色：admin 可发布与查看设备数据，device 只能检查与下载授权渠道内的版本，
+// breakglass 是值班人员的个人令牌，本身没有权限，只能换取短期 admin 令牌（见 breakglass.go）。
+const (
+	RoleAdmin      = "admin"
+	RoleDevice     = "device"
+	RoleBreakGlass = "breakglass"
+)
+
+// Token 是一个 API 令牌及其授权范围。Channels 支持 path.Match 通配（如 partner-*），
+// 一个项目的渠道按前缀命名即可整体授权；admin 令牌不受渠道限制。
+type Token struct {
+	Name        string   `json:"name"`
+	Token       string   `json:"token,omitempty"`        // 明文令牌
+	TokenSha256 string   `json:"token_sha256,omitempty"` // 或其 sha256（hex），避免配置文件泄露令牌
+	Role        string   `json:"role"`
+	Channels    []string `json:"channels,omitempty"`
+}
+
+// LoadTokens reads a tokens file: {"tokens": [...]}.
+func LoadTokens(fp string) ([]Token, error) {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Tokens []Token `json:"tokens"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", fp, err)
+	}
+	return f.Tokens, nil
+}
+
+// Principal 是已认证的调用方。
+type Principal struct {
+	Name     string
+	Role     string
+	Channels []string
+	Device   string // 客户端证书标识的设备，只能以该设备 ID 调用设备端点
+}
+
+// IsAdmin reports whether p has admin rights.
+func (p *Principal) IsAdmin() bool { return p.Role == RoleAdmin }
+
+// CanAccess reports whether p may see releases of channel.
+func (p *Principal) CanAccess(channel string) bool {
+	if p.IsAdmin() {
+		return true
+	}
+	for _, pat := range p.Channels {
+		if ok, _ := path.Match(pat, channel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// anonymous 在未配置令牌时代表所有调用方，保持旧部署的行为。
+var anonymous = &Principal{Name: "anonymous", Role: RoleAdmin}
+
+// tokenIndex 以令牌 sha256 为键。
+type tokenIndex map[string]*Principal
+
+func newTokenIndex(tokens []Token) (tokenIndex, error) {
+	idx := tokenIndex{}
+	for i, t := range tokens {
+		if t.Name == "" {
+			return nil, fmt.Errorf("token %d has no name", i)
+		}
+		if t.Role != RoleAdmin && t.Role != RoleDevice && t.Role != RoleBreakGlass {
+			return nil, fmt.Errorf("token %s: role must be admin, device or breakglass", t.Name)
+		}
+		sum := strings.ToLower(t.TokenSha256)
+		if t.Token != "" {
+			h := sha256.Sum256([]byte(t.Token))
+			sum = hex.EncodeToString(h[:])
+		}
+		if len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("token %s needs token or token_sha256", t.Name)
+		}
+		if _, dup := idx[sum]; dup {
+			return nil, errors.New("duplicate token for " + t.Name)
+		}
+		idx[sum] = &Principal{Name: t.Name, Role: t.Role, Channels: t.Channels}
+	}
+	return idx, nil
+}
+
+// bearerToken 取请求携带的令牌：Authorization: Bearer，或 hawkBit 客户端使用的
+// TargetToken / GatewayToken 方案。
+func bearerToken(g *gin.Context) string {
+	scheme, tok, ok := strings.Cut(g.GetHeader("Authorization"), " ")
+	if !ok {
+		return ""
+	}
+	switch strings.ToLower(scheme) {
+	case "bearer", "targettoken", "gatewaytoken":
+		return strings.TrimSpace(tok)
+	}
+	return ""
+}
+
+const principalKey = "principal"
+
+// Authenticate resolves the caller from its client certificate, token (or
+// admin session cookie) and aborts with 401 when auth is enabled but no valid
+// credential is presented.
+func (p *Platform) Authenticate(g *gin.Context) {
+	dev := p.certPrincipal(g)
+	if p.tokens == nil {
+		switch {
+		case dev != nil:
+			g.Set(principalKey, dev)
+		case p.requireCert():
+			BaseController{}.ResponseFailure(g, ErrUnauthorized, "client certificate required")
+		default:
+			g.Set(principalKey, anonymous)
+		}
+		return
+	}
+	tok := bearerToken(g)
+	if tok == "" {
+		if dev != nil {
+			g.Set(principalKey, dev)
+			return
+		}
+		if !p.authenticateSession(g) {
+			BaseController{}.ResponseFailure(g, ErrUnauthorized, "missing bearer token")
+		}
+		return
+	}
+	h := sha256.Sum256([]byte(tok))
+	sum := hex.EncodeToString(h[:])
+	pr, ok := p.tokens[sum]
+	if !ok {
+		if !p.authenticateBreakGlass(g, sum) {
+			BaseController{}.ResponseFailure(g, ErrUnauthorized, "invalid token")
+		}
+		return
+	}
+	// 设备令牌与证书同时出现时以证书标识设备；要求证书时设备令牌不能代替证书
+	if pr.Role == RoleDevice {
+		if dev != nil {
+			pr = dev
+		} else if p.requireCert() {
+			BaseController{}.ResponseFailure(g, ErrUnauthorized, "client certificate required for device "+pr.Name)
+			return
+		}
+	}
+	g.Set(principalKey, pr)
+}
+
+// RequireAdmin aborts with 403 unless the caller is an admin. It must run
+// after Authenticate.
+func (p *Platform) RequireAdmin(g *gin.Context) {
+	if !p.principal(g).IsAdmin() {
+		BaseController{}.ResponseFailure(g, ErrForbidden, "admin role required")
+	}
+}
+
+func (p *Platform) principal(g *gin.Context) *Principal {
+	if v, ok := g.Get(principalKey); ok {
+		return v.(*Principal)
+	}
+	if p.tokens == nil {
+		return anonymous
+	}
+	return &Principal{Name: "unauthenticated"}
+}
+
+// allowChannel answers 403 and returns false when the caller's token is not
+// scoped to channel.
+func (p *Platform) allowChannel(g *gin.Context, channel string) bool {
+	pr := p.principal(g)
+	if pr.CanAccess(channel) {
+		return true
+	}
+	BaseController{}.ResponseFailure(g, ErrForbidden, fmt.Sprintf("token %s is not authorized for channel %s", pr.Name, channel))
+	return false
+}
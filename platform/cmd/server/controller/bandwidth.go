@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按发布活动统计下载流量：本服务送出的每次制品下载（/download、hawkBit 制品、导出的更新包与 TUF 目标文件）按天（UTC）、
+// 发布活动与设备区域累计字节数，每分钟把增量追加到 <DataDir>/bandwidth.jsonl（内存后端下只保存在内存中）。
+// 发布活动是发布时的 campaign 字段（如 "vision/2.3-rollout"），未填写时即版本号。
+// 区域镜像送出的流量不经过本服务，由镜像自己计费，不在统计之内。
+
+const bytesPerGB = 1e9
+
+// BandwidthUsage 是某一天一个发布活动在一个区域送出的下载流量。
+type BandwidthUsage struct {
+	Date     string `json:"date"` // YYYY-MM-DD（UTC）
+	Campaign string `json:"campaign"`
+	Version  string `json:"version"`
+	Region   string `json:"region"` // 设备未上报且无法推断时为 unknown
+	Bytes    int64  `json:"bytes"`
+	Requests int    `json:"requests"`
+}
+
+type usageKey struct{ date, campaign, version, region string }
+
+type bandwidthLog struct {
+	mu      sync.Mutex
+	path    string
+	fsync   bool
+	totals  map[usageKey]*BandwidthUsage
+	pending map[usageKey]*BandwidthUsage // 尚未落盘的增量
+}
+
+func openBandwidthLog(path string, fsync bool) (*bandwidthLog, error) {
+	l := &bandwidthLog{path: path, fsync: fsync, totals: map[usageKey]*BandwidthUsage{}, pending: map[usageKey]*BandwidthUsage{}}
+	if path == "" {
+		return l, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var u BandwidthUsage
+		if err := json.Unmarshal(sc.Bytes(), &u); err == nil {
+			addUsage(l.totals, u)
+		}
+	}
+	return l, sc.Err()
+}
+
+// addUsage merges u into the row with the same key.
+func addUsage(m map[usageKey]*BandwidthUsage, u BandwidthUsage) {
+	k := usageKey{u.Date, u.Campaign, u.Version, u.Region}
+	if r := m[k]; r != nil {
+		r.Bytes += u.Bytes
+		r.Requests += u.Requests
+		return
+	}
+	m[k] = &u
+}
+
+func (l *bandwidthLog) add(u BandwidthUsage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	addUsage(l.totals, u)
+	if l.path != "" {
+		addUsage(l.pending, u)
+	}
+}
+
+// flush appends the pending increments; they are kept for the next attempt
+// when the write fails.
+func (l *bandwidthLog) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) == 0 {
+		return nil
+	}
+	var buf []byte
+	for _, u := range l.pending {
+		b, _ := json.Marshal(u)
+		buf = append(append(buf, b...), '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if l.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	l.pending = map[usageKey]*BandwidthUsage{}
+	return nil
+}
+
+// list returns the daily rows of campaign (all when empty) whose date lies
+// in [since, until]; empty bounds are open.
+func (l *bandwidthLog) list(campaign, since, until string) []BandwidthUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []BandwidthUsage
+	for _, u := range l.totals {
+		if (campaign == "" || u.Campaign == campaign) && (since == "" || u.Date >= since) && (until == "" || u.Date <= until) {
+			out = append(out, *u)
+		}
+	}
+	return out
+}
+
+// recordDownload attributes the bytes just written for rel to its campaign
+// and the requesting device's region.
+func (p *Platform) recordDownload(g *gin.Context, rel *Release) {
+	n := g.Writer.Size()
+	if n <= 0 {
+		return
+	}
+	region, _ := p.deviceRegion(g)
+	if region == "" {
+		region = "unknown"
+	}
+	p.bandwidth.add(BandwidthUsage{
+		Date:     p.clock.Now().UTC().Format(time.DateOnly),
+		Campaign: rel.campaign(),
+		Version:  rel.Version,
+		Region:   region,
+		Bytes:    int64(n),
+		Requests: 1,
+	})
+}
+
+func (p *Platform) flushBandwidth() {
+	if err := p.bandwidth.flush(); err != nil {
+		log.Printf("bandwidth log: %v", err)
+	}
+}
+
+// campaign is the rollout the release's traffic is billed to.
+func (r *Release) campaign() string {
+	if r.Campaign != "" {
+		return r.Campaign
+	}
+	return r.Version
+}
+
+// CampaignCost 是一个发布活动在一个区域的流量与费用。
+type CampaignCost struct {
+	Campaign string   `json:"campaign"`
+	Region   string   `json:"region"`
+	Versions []string `json:"versions"`
+	Bytes    int64    `json:"bytes"`
+	Requests int      `json:"requests"`
+	GB       float64  `json:"gb"`
+	Cost     float64  `json:"cost"`
+}
+
+type ReportController struct {
+	BaseController
+	p *Platform
+}
+
+func NewReportController(p *Platform) *ReportController {
+	return &ReportController{p: p}
+}
+
+// Cost godoc
+// @Summary      Bandwidth and cost per campaign
+// @Description  Bytes of artifacts served by this server per campaign and device region, priced at the configured $/GB (1 GB = 10^9 bytes). Traffic served by region mirrors is not included.
+// @Tags         device
+// @Produce      json
+// @Param        campaign      query  string  false  "Only this campaign"
+// @Param        since         query  string  false  "First date (YYYY-MM-DD)"
+// @Param        until         query  string  false  "Last date (YYYY-MM-DD)"
+// @Param        price_per_gb  query  number  false  "Price per GB, default: the server's -cost-per-gb"
+// @Param        daily         query  bool    false  "Also return the daily rows"
+// @Success      200  {object}  map[string]any  "price_per_gb, campaigns (per campaign and region), totals (per campaign), days when daily"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/reports/cost [get]
+func (c *ReportController) Cost(g *gin.Context) {
+	price := c.p.costPerGB
+	if v := g.Query("price_per_gb"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			c.ResponseFailure(g, ErrParam, "price_per_gb must be a non-negative number")
+			return
+		}
+		price = f
+	}
+	since, until, ok := c.dateRange(g)
+	if !ok {
+		return
+	}
+	days := c.p.bandwidth.list(g.Query("campaign"), since, until)
+
+	rows := map[[2]string]*CampaignCost{}
+	totals := map[string]*CampaignCost{}
+	for _, u := range days {
+		for _, r := range []*CampaignCost{
+			costRow(rows, [2]string{u.Campaign, u.Region}, u.Campaign, u.Region),
+			costRow(totals, u.Campaign, u.Campaign, ""),
+		} {
+			r.Bytes += u.Bytes
+			r.Requests += u.Requests
+			if i := sort.SearchStrings(r.Versions, u.Version); i == len(r.Versions) || r.Versions[i] != u.Version {
+				r.Versions = append(r.Versions[:i], append([]string{u.Version}, r.Versions[i:]...)...)
+			}
+		}
+	}
+	resp := gin.H{
+		"price_per_gb": price,
+		"campaigns":    pricedRows(rows, price),
+		"totals":       pricedRows(totals, price),
+	}
+	if g.Query("daily") == "true" {
+		sort.Slice(days, func(i, j int) bool {
+			a, b := days[i], days[j]
+			if a.Date != b.Date {
+				return a.Date < b.Date
+			}
+			if a.Campaign != b.Campaign {
+				return a.Campaign < b.Campaign
+			}
+			return a.Region+"/"+a.Version < b.Region+"/"+b.Version
+		})
+		if days == nil {
+			days = []BandwidthUsage{}
+		}
+		resp["days"] = days
+	}
+	g.JSON(http.StatusOK, resp)
+}
+
+func costRow[K comparable](m map[K]*CampaignCost, k K, campaign, region string) *CampaignCost {
+	r := m[k]
+	if r == nil {
+		r = &CampaignCost{Campaign: campaign, Region: region}
+		m[k] = r
+	}
+	return r
+}
+
+// pricedRows prices the rows and orders them by traffic, largest first.
+func pricedRows[K comparable](m map[K]*CampaignCost, price float64) []CampaignCost {
+	out := make([]CampaignCost, 0, len(m))
+	for _, r := range m {
+		r.GB = float64(r.Bytes) / bytesPerGB
+		r.Cost = r.GB * price
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bytes != out[j].Bytes {
+			return out[i].Bytes > out[j].Bytes
+		}
+		return out[i].Campaign+"/"+out[i].Region < out[j].Campaign+"/"+out[j].Region
+	})
+	return out
+}
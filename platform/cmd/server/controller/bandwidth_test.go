@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/clock"
+)
+
+func TestExportAndTUFDownloadsCounted(t *testing.T) {
+	st, err := NewMemoryStorage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPlatform(Options{
+		Storage:   st,
+		Artifacts: NewMemoryArtifactStore(),
+		Events:    NewMemoryEventLog(time.Hour),
+		Clock:     clock.NewFake(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)),
+		TUFKey:    testKey(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	in := publishInput{Version: "1.0.0", Channel: "stable", Format: "binary", Campaign: "spring"}
+	if _, _, err := p.publishRelease(in, strings.NewReader("payload")); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(handler gin.HandlerFunc, url string, params gin.Params) int {
+		w := httptest.NewRecorder()
+		g, _ := gin.CreateTestContext(w)
+		g.Request = httptest.NewRequest("GET", url, nil)
+		g.Params = params
+		handler(g)
+		if w.Code != 200 {
+			t.Fatalf("GET %s: %d %s", url, w.Code, w.Body)
+		}
+		return w.Body.Len()
+	}
+	tufBytes := get(NewTUFController(p).Serve, "/tuf/targets/1.0.0/algorithm", gin.Params{{Key: "file", Value: "/targets/1.0.0/algorithm"}})
+	exportBytes := get(NewExportController(p).Export, "/api/v1/export/1.0.0/mender", gin.Params{{Key: "version", Value: "1.0.0"}, {Key: "format", Value: "mender"}})
+
+	rows := p.bandwidth.list("spring", "", "")
+	if len(rows) != 1 || rows[0].Requests != 2 || rows[0].Bytes != int64(tufBytes+exportBytes) {
+		t.Fatalf("bandwidth rows %+v, want 2 requests and %d bytes", rows, tufBytes+exportBytes)
+	}
+}
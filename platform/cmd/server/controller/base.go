@@ -12,7 +12,17 @@ type ErrCode int
 const (
 	OK ErrCode = iota
 	ErrParam
+	ErrNotFound
 	ErrInternal
+	ErrReadOnly
+	ErrNoSpace
+	ErrUnsupported
+	ErrUnauthorized
+	ErrForbidden
+	ErrUpstream
+	ErrRateLimited
+	ErrEncoding
+	ErrOverloaded
 )
 
 type errSpecItem = struct {
@@ -23,7 +33,20 @@ type errSpecItem = struct {
 var errSpec = map[ErrCode]errSpecItem{
 	OK:          {http.StatusOK, "OK"},
 	ErrParam:    {http.StatusBadRequest, "Bad Request"},
+	ErrNotFound: {http.StatusNotFound, "Not Found"},
 	ErrInternal: {http.StatusInternalServerError, "Internal Server Error"},
+	ErrReadOnly: {http.StatusServiceUnavailable, "Service Unavailable"},
+	ErrNoSpace:  {http.StatusInsufficientStorage, "Insufficient Storage"},
+
+	ErrUnsupported: {http.StatusNotImplemented, "Not Implemented"},
+
+	ErrUnauthorized: {http.StatusUnauthorized, "Unauthorized"},
+	ErrForbidden:    {http.StatusForbidden, "Forbidden"},
+
+	ErrUpstream:    {http.StatusBadGateway, "Bad Gateway"},
+	ErrRateLimited: {http.StatusTooManyRequests, "Too Many Requests"},
+	ErrEncoding:    {http.StatusUnsupportedMediaType, "Unsupported Media Type"},
+	ErrOverloaded:  {http.StatusServiceUnavailable, "Service Unavailable"},
 }
 
 func (c BaseController) ResponseFailure(g *gin.Context, e ErrCode, detail string) {
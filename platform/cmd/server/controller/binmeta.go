@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// 制品元数据：发布 binary 格式（不带启动模板）的制品时解析 ELF 头，记下 CPU 架构、是否去除了符号表、
+// 制品中是否含有发布的版本号字符串，Go 程序再记下构建信息（Go 版本、模块、VCS 修订）。
+// 声明的 arch（发布参数，缺省为服务端的 -artifact-arch）与 ELF 架构不符，或 Go 模块版本是正式的
+// vX.Y.Z 却与发布的版本号不符时拒绝发布——在一批 arm64 无人机下载之前拦下误传的 amd64 构建。
+// 脚本等非 ELF 制品不做检查，只有显式声明了 arch 时才拒绝。
+
+// BinaryInfo 是发布时从 ELF 制品中提取的元数据。
+type BinaryInfo struct {
+	Arch            string       `json:"arch"`             // GOARCH 风格：amd64、arm64、arm、386、riscv64……
+	Stripped        bool         `json:"stripped"`         // 没有符号表
+	VersionEmbedded bool         `json:"version_embedded"` // 制品中含有发布的版本号字符串
+	Go              *GoBuildInfo `json:"go,omitempty"`     // 非 Go 程序为空
+}
+
+// GoBuildInfo 是 Go 程序内嵌的构建信息。
+type GoBuildInfo struct {
+	GoVersion string `json:"go_version"`
+	Path      string `json:"path"`               // main 包路径
+	Module    string `json:"module"`             // main 模块
+	Version   string `json:"version"`            // main 模块版本，本地构建为 (devel)
+	Revision  string `json:"revision,omitempty"` // vcs.revision
+	Modified  bool   `json:"modified,omitempty"` // vcs.modified：构建时工作区有未提交的修改
+}
+
+// elfArches maps ELF machines to GOARCH names.
+var elfArches = map[elf.Machine]string{
+	elf.EM_X86_64:  "amd64",
+	elf.EM_386:     "386",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_ARM:     "arm",
+	elf.EM_RISCV:   "riscv64",
+	elf.EM_PPC64:   "ppc64le",
+	elf.EM_S390:    "s390x",
+}
+
+// knownArches are the arch values publish accepts.
+var knownArches = map[string]bool{
+	"amd64": true, "386": true, "arm64": true, "arm": true, "riscv64": true,
+	"ppc64le": true, "s390x": true, "mips": true, "mipsle": true, "mips64": true, "mips64le": true,
+}
+
+// inspectBinary extracts the metadata of an ELF artifact; nil when r is not
+// an ELF file.
+func inspectBinary(r io.ReaderAt, size int64, version string) (*BinaryInfo, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		var fe *elf.FormatError
+		if errors.As(err, &fe) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	info := &BinaryInfo{Arch: elfArch(f), Stripped: f.Section(".symtab") == nil}
+	if info.VersionEmbedded, err = containsString(r, size, version); err != nil {
+		return nil, err
+	}
+	if bi, err := buildinfo.Read(r); err == nil {
+		g := &GoBuildInfo{GoVersion: bi.GoVersion, Path: bi.Path, Module: bi.Main.Path, Version: bi.Main.Version}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				g.Revision = s.Value
+			case "vcs.modified":
+				g.Modified = s.Value == "true"
+			case "GOARCH":
+				// GOARCH 比 ELF 头更精确（mips 与 mipsle、mips64 等）
+				info.Arch = s.Value
+			}
+		}
+		info.Go = g
+	}
+	return info, nil
+}
+
+func elfArch(f *elf.File) string {
+	if f.Machine == elf.EM_MIPS {
+		a := "mips"
+		if f.Class == elf.ELFCLASS64 {
+			a = "mips64"
+		}
+		if f.ByteOrder == binary.LittleEndian {
+			a += "le"
+		}
+		return a
+	}
+	if a, ok := elfArches[f.Machine]; ok {
+		return a
+	}
+	return strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+}
+
+// containsString reports whether s occurs in the first size bytes of r.
+func containsString(r io.ReaderAt, size int64, s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	const chunk = 1 << 20
+	needle := []byte(s)
+	buf := make([]byte, chunk+len(needle)-1)
+	for off := int64(0); off < size; off += chunk {
+		// 相邻的块重叠 len(s)-1 字节，跨块的字符串也能找到
+		n, err := r.ReadAt(buf[:min(int64(len(buf)), size-off)], off)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if bytes.Contains(buf[:n], needle) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkBinary rejects an artifact whose metadata contradicts the declared
+// arch or the release version.
+func checkBinary(info *BinaryInfo, arch string, declared bool, version string) error {
+	if info == nil {
+		if declared {
+			return errors.New("arch " + arch + " was declared, but the artifact is not an ELF binary")
+		}
+		return nil
+	}
+	if arch != "" && info.Arch != arch {
+		return errors.New("the artifact is an ELF " + info.Arch + " binary, but arch " + arch + " was expected")
+	}
+	// 本地构建（(devel)）与未打标签的提交（伪版本）不比较
+	if g := info.Go; g != nil && tagVersionRe.MatchString(g.Version) && !sameVersion(g.Version, version) {
+		return errors.New("the artifact was built from module " + g.Module + " " + g.Version + ", not version " + version)
+	}
+	return nil
+}
+
+var tagVersionRe = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+// sameVersion compares a tagged Go module version with a release version,
+// which may carry an app suffix (1.2.0-landing).
+func sameVersion(mod, rel string) bool {
+	mod = strings.TrimPrefix(mod, "v")
+	return mod == rel || strings.HasPrefix(rel, mod+"-")
+}
+
+func binaryArch(r *Release) string {
+	if r.Binary == nil {
+		return ""
+	}
+	return r.Binary.Arch
+}
+
+func goVersion(r *Release) string {
+	if r.Binary == nil || r.Binary.Go == nil {
+		return ""
+	}
+	return r.Binary.Go.GoVersion
+}
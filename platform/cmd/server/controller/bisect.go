@@ -0,0 +1,457 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 跨版本二分定位现场回归：操作员指定一台测试设备、已知正常的版本 good 与出现回归的版本 bad，
+// 服务端在两者之间的已发布版本上做二分。每一步把设备固定（/check 的 pinned）到待测版本，
+// 设备安装并运行 soak 时长：期间出现崩溃事件，或检查时上报算法崩溃（algo_health），判为 bad，
+// 否则判为 good；安装失败或一小时内未装上的版本跳过。范围收敛后 first_bad 即引入回归的版本，
+// 设备解除固定，下一次检查恢复渠道的正常更新。后台每分钟推进一次，开始、结束与中止写入审计日志。
+
+const (
+	BisectRunning = "running"
+	BisectDone    = "done"
+	BisectAborted = "aborted"
+
+	BisectGood    = "good"
+	BisectBad     = "bad"
+	BisectSkipped = "skipped"
+
+	defaultBisectSoak = 30 * time.Minute
+	// bisectInstallTimeout 内设备未装上待测版本则跳过该版本。
+	bisectInstallTimeout = time.Hour
+)
+
+// BisectStep 是二分中的一次试装。
+type BisectStep struct {
+	Version     string     `json:"version"`
+	StartedAt   time.Time  `json:"started_at"`
+	InstalledAt *time.Time `json:"installed_at,omitempty"` // 设备首次以该版本检查的时间
+	Result      string     `json:"result,omitempty"`       // good | bad | skipped，进行中为空
+	Detail      string     `json:"detail,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+// Bisection 是一台测试设备上的一次二分定位。
+type Bisection struct {
+	ID          string       `json:"id"`
+	DeviceID    string       `json:"device_id"`
+	Good        string       `json:"good"`
+	Bad         string       `json:"bad"`
+	SoakMinutes int          `json:"soak_minutes"`
+	Candidates  []string     `json:"candidates"` // good 与 bad 之间的已发布版本，由旧到新
+	Steps       []BisectStep `json:"steps"`
+	State       string       `json:"state"` // running | done | aborted
+	FirstBad    string       `json:"first_bad,omitempty"`
+	// LastGood 是收敛时最后一个判为正常的版本；中间有跳过的版本时二者之间可能不止一个版本。
+	LastGood   string     `json:"last_good,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+}
+
+// clone copies b so it can be read without holding p.store.mu.
+func (b *Bisection) clone() Bisection {
+	out := *b
+	out.Candidates = append([]string{}, b.Candidates...)
+	out.Steps = append([]BisectStep{}, b.Steps...)
+	return out
+}
+
+// bounds returns the newest version judged good and the oldest judged bad.
+func (b *Bisection) bounds() (good, bad string) {
+	good, bad = b.Good, b.Bad
+	for _, s := range b.Steps {
+		switch s.Result {
+		case BisectGood:
+			if version.Newer(s.Version, good) {
+				good = s.Version
+			}
+		case BisectBad:
+			if version.Newer(bad, s.Version) {
+				bad = s.Version
+			}
+		}
+	}
+	return good, bad
+}
+
+// current is the step in progress, nil when none.
+func (b *Bisection) current() *BisectStep {
+	if b.State != BisectRunning || len(b.Steps) == 0 || b.Steps[len(b.Steps)-1].Result != "" {
+		return nil
+	}
+	return &b.Steps[len(b.Steps)-1]
+}
+
+// next starts the next step, or finishes when the range is narrowed down.
+// It reports whether the bisection finished.
+func (b *Bisection) next(now time.Time) bool {
+	good, bad := b.bounds()
+	tried := map[string]bool{}
+	for _, s := range b.Steps {
+		tried[s.Version] = true
+	}
+	var left []string
+	for _, v := range b.Candidates {
+		if !tried[v] && version.Newer(v, good) && version.Newer(bad, v) {
+			left = append(left, v)
+		}
+	}
+	if len(left) == 0 {
+		b.State, b.FirstBad, b.LastGood, b.FinishedAt = BisectDone, bad, good, &now
+		return true
+	}
+	b.Steps = append(b.Steps, BisectStep{Version: left[len(left)/2], StartedAt: now})
+	return false
+}
+
+func bisectID(device, good, bad string, now time.Time) string {
+	sum := sha256.Sum256([]byte(device + "\x00" + good + "\x00" + bad + "\x00" + now.String()))
+	return hex.EncodeToString(sum[:6])
+}
+
+// bisectPin returns the running bisection of device and the release it is
+// pinned to. Callers hold p.store.mu.
+func (p *Platform) bisectPin(device string) (string, *Release) {
+	if device == "" {
+		return "", nil
+	}
+	for _, b := range p.store.Bisections {
+		if b.DeviceID != device {
+			continue
+		}
+		if s := b.current(); s != nil {
+			return b.ID, p.store.ReleasesByVersion[s.Version]
+		}
+	}
+	return "", nil
+}
+
+// judgeStep evaluates the events of the step's device since it started,
+// oldest first, and returns the result ("" while undecided).
+func judgeStep(s *BisectStep, evs []*DeviceEvent, soak time.Duration, now time.Time) (result, detail string) {
+	for i := len(evs) - 1; i >= 0; i-- {
+		ev := evs[i]
+		if s.InstalledAt == nil {
+			switch {
+			case ev.Type == "check" && ev.Version == s.Version:
+				t := ev.Time
+				s.InstalledAt = &t
+			case ev.Type == "report" && ev.Data["to"] == s.Version && ev.Data["status"] == "failure":
+				return BisectSkipped, fmt.Sprintf("install failed: %v", ev.Data["error"])
+			}
+			continue
+		}
+		if ev.Version != s.Version {
+			continue
+		}
+		if ev.Type == "crash" {
+			return BisectBad, fmt.Sprintf("crash at %s: %v", ev.Time.Format(time.RFC3339), ev.Data["exit"])
+		}
+		if ev.Type == "check" && ev.Data["algo_health"] == algoCrashing {
+			return BisectBad, fmt.Sprintf("algorithm crashing at %s", ev.Time.Format(time.RFC3339))
+		}
+	}
+	switch {
+	case s.InstalledAt == nil && now.Sub(s.StartedAt) >= bisectInstallTimeout:
+		return BisectSkipped, "not installed within " + bisectInstallTimeout.String()
+	case s.InstalledAt != nil && now.Sub(*s.InstalledAt) >= soak:
+		return BisectGood, "no crash within " + soak.String()
+	}
+	return "", ""
+}
+
+// advanceBisections judges the steps in progress and starts the next ones.
+func (p *Platform) advanceBisections() {
+	type pending struct {
+		id, device string
+		step       BisectStep
+		soak       time.Duration
+	}
+	p.store.mu.RLock()
+	var work []pending
+	for _, b := range p.store.Bisections {
+		if s := b.current(); s != nil {
+			work = append(work, pending{b.ID, b.DeviceID, *s, time.Duration(b.SoakMinutes) * time.Minute})
+		}
+	}
+	p.store.mu.RUnlock()
+	if len(work) == 0 {
+		return
+	}
+
+	// 事件查询不持有 store 锁
+	events := map[string][]*DeviceEvent{}
+	for _, w := range work {
+		evs, err := p.events.Query(EventQuery{DeviceID: w.device, Since: w.step.StartedAt})
+		if err != nil {
+			log.Printf("bisect %s: %v", w.id, err)
+			continue
+		}
+		events[w.id] = evs
+	}
+
+	now := p.clock.Now()
+	var finished []Bisection
+	changed := false
+	p.store.mu.Lock()
+	for _, w := range work {
+		evs, ok := events[w.id]
+		b := p.store.Bisections[w.id]
+		s := b.current()
+		if !ok || s == nil || s.Version != w.step.Version {
+			continue // 查询失败或期间被中止
+		}
+		installed := s.InstalledAt != nil
+		result, detail := judgeStep(s, evs, w.soak, now)
+		changed = changed || (!installed && s.InstalledAt != nil)
+		if result == "" {
+			continue
+		}
+		s.Result, s.Detail, s.DecidedAt = result, detail, &now
+		changed = true
+		log.Printf("bisect %s: %s is %s (%s)", b.ID, s.Version, result, detail)
+		if b.next(now) {
+			finished = append(finished, b.clone())
+		}
+	}
+	var err error
+	if changed {
+		err = p.saveStore(p.store)
+	}
+	p.store.mu.Unlock()
+	if err != nil {
+		log.Printf("save bisections: %v", err)
+	}
+	for _, b := range finished {
+		_ = p.audit("system", "bisect_finished", "", map[string]any{"id": b.ID, "device_id": b.DeviceID, "first_bad": b.FirstBad, "last_good": b.LastGood})
+		p.emitAlert("bisect_finished", fmt.Sprintf("bisection %s on %s: first bad version %s (last good %s)", b.ID, b.DeviceID, b.FirstBad, b.LastGood))
+	}
+}
+
+type BisectController struct {
+	BaseController
+	p *Platform
+}
+
+func NewBisectController(p *Platform) *BisectController {
+	return &BisectController{p: p}
+}
+
+// Start godoc
+// @Summary      Start bisecting a field regression
+// @Description  Steps a test device through the releases between a known-good and a regressed version, binary-search style. Each candidate is pinned for the device via /check, installed and soaked; a crash or a crashing algorithm health report during the soak marks it bad, otherwise good. Candidates that fail to install, or are not installed within an hour, are skipped.
+// @Tags         bisect
+// @Accept       json
+// @Produce      json
+// @Param        body  body  object  true  "{\"device_id\": \"\", \"good\": \"2.0.0\", \"bad\": \"2.3.0\", \"soak_minutes\": 30}"
+// @Success      200  {object}  controller.Bisection
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/bisections [post]
+func (c *BisectController) Start(g *gin.Context) {
+	var body struct {
+		DeviceID    string `json:"device_id"`
+		Good        string `json:"good"`
+		Bad         string `json:"bad"`
+		SoakMinutes int    `json:"soak_minutes"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	body.DeviceID = strings.TrimSpace(body.DeviceID)
+	if body.DeviceID == "" || body.Good == "" || body.Bad == "" {
+		c.ResponseFailure(g, ErrParam, "device_id, good and bad are required")
+		return
+	}
+	if !version.Newer(body.Bad, body.Good) {
+		c.ResponseFailure(g, ErrParam, "bad must be newer than good")
+		return
+	}
+	if body.SoakMinutes < 0 {
+		c.ResponseFailure(g, ErrParam, "soak_minutes must not be negative")
+		return
+	}
+	if body.SoakMinutes == 0 {
+		body.SoakMinutes = int(defaultBisectSoak / time.Minute)
+	}
+	actor := c.p.principal(g).Name
+	now := c.p.clock.Now()
+
+	c.p.store.mu.Lock()
+	for _, v := range []string{body.Good, body.Bad} {
+		rel := c.p.store.ReleasesByVersion[v]
+		if rel == nil {
+			c.p.store.mu.Unlock()
+			c.ResponseFailure(g, ErrParam, "unknown version "+v)
+			return
+		}
+		if rel.App != "" {
+			c.p.store.mu.Unlock()
+			c.ResponseFailure(g, ErrParam, "bisection covers the primary algorithm only, "+v+" belongs to app "+rel.App)
+			return
+		}
+	}
+	if id, _ := c.p.bisectPin(body.DeviceID); id != "" {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, "device is already being bisected ("+id+")")
+		return
+	}
+	b := &Bisection{
+		ID:          bisectID(body.DeviceID, body.Good, body.Bad, now),
+		DeviceID:    body.DeviceID,
+		Good:        body.Good,
+		Bad:         body.Bad,
+		SoakMinutes: body.SoakMinutes,
+		Candidates:  []string{},
+		Steps:       []BisectStep{},
+		State:       BisectRunning,
+		CreatedBy:   actor,
+		CreatedAt:   now,
+	}
+	for v, rel := range c.p.store.ReleasesByVersion {
+		if rel.App == "" && version.Newer(v, b.Good) && version.Newer(b.Bad, v) {
+			b.Candidates = append(b.Candidates, v)
+		}
+	}
+	sort.Slice(b.Candidates, func(i, j int) bool { return version.Newer(b.Candidates[j], b.Candidates[i]) })
+	b.next(now)
+	if c.p.store.Bisections == nil {
+		c.p.store.Bisections = map[string]*Bisection{}
+	}
+	c.p.store.Bisections[b.ID] = b
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		delete(c.p.store.Bisections, b.ID)
+	}
+	out := b.clone()
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save bisection"), fsErr(err, "save bisection").Error())
+		return
+	}
+	_ = c.p.audit(actor, "bisect_started", "", map[string]any{"id": b.ID, "device_id": b.DeviceID, "good": b.Good, "bad": b.Bad, "candidates": len(b.Candidates)})
+	g.JSON(http.StatusOK, out)
+}
+
+// List godoc
+// @Summary      List bisections
+// @Description  Bisections with their steps, newest first.
+// @Tags         bisect
+// @Produce      json
+// @Param        state  query  string  false  "Only this state (running|done|aborted)"
+// @Success      200  {object}  map[string]any  "bisections"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/bisections [get]
+func (c *BisectController) List(g *gin.Context) {
+	state := g.Query("state")
+	c.p.store.mu.RLock()
+	out := make([]Bisection, 0, len(c.p.store.Bisections))
+	for _, b := range c.p.store.Bisections {
+		if state == "" || b.State == state {
+			out = append(out, b.clone())
+		}
+	}
+	c.p.store.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	g.JSON(http.StatusOK, gin.H{"bisections": out})
+}
+
+// Get godoc
+// @Summary      Show a bisection
+// @Tags         bisect
+// @Produce      json
+// @Param        id  path  string  true  "Bisection ID"
+// @Success      200  {object}  controller.Bisection
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/bisections/{id} [get]
+func (c *BisectController) Get(g *gin.Context) {
+	c.p.store.mu.RLock()
+	b := c.p.store.Bisections[g.Param("id")]
+	var out Bisection
+	if b != nil {
+		out = b.clone()
+	}
+	c.p.store.mu.RUnlock()
+	if b == nil {
+		c.ResponseFailure(g, ErrNotFound, "bisection not found")
+		return
+	}
+	g.JSON(http.StatusOK, out)
+}
+
+// Abort godoc
+// @Summary      Abort a bisection
+// @Description  Stops a running bisection and unpins the device; its next check resumes the channel's normal updates.
+// @Tags         bisect
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true   "Bisection ID"
+// @Param        body  body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  controller.Bisection
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/bisections/{id}/abort [post]
+func (c *BisectController) Abort(g *gin.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&body); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	actor := c.p.principal(g).Name
+	now := c.p.clock.Now()
+
+	c.p.store.mu.Lock()
+	b := c.p.store.Bisections[g.Param("id")]
+	if b == nil {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "bisection not found")
+		return
+	}
+	if b.State != BisectRunning {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, "bisection is already "+b.State)
+		return
+	}
+	prev := *b
+	b.State, b.FinishedAt, b.Reason = BisectAborted, &now, strings.TrimSpace(body.Reason)
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		*b = prev
+	}
+	out := b.clone()
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save bisection"), fsErr(err, "save bisection").Error())
+		return
+	}
+	_ = c.p.audit(actor, "bisect_aborted", out.Reason, map[string]any{"id": out.ID, "device_id": out.DeviceID})
+	g.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultBreakGlassTTL 是未指定时长时临时权限的有效期。
+	defaultBreakGlassTTL = 30 * time.Minute
+	// minBreakGlassReason 要求理由足够具体，事后复盘才有意义。
+	minBreakGlassReason = 10
+)
+
+// breakGlassSession 是一次应急提权：具名值班人员凭个人 breakglass 令牌换取的短期 admin 令牌。
+// 会话只保存在内存中，服务重启即全部失效。
+type breakGlassSession struct {
+	operator  string
+	reason    string
+	expires   time.Time
+	principal *Principal
+}
+
+type breakGlass struct {
+	mu       sync.Mutex
+	max      time.Duration
+	sessions map[string]*breakGlassSession // 以临时令牌 sha256 为键
+}
+
+// lookup returns the live session for the token hash; expired sessions are
+// removed and reported with expired=true.
+func (b *breakGlass) lookup(sum string, now time.Time) (s *breakGlassSession, expired bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s = b.sessions[sum]
+	if s == nil {
+		return nil, false
+	}
+	if !now.Before(s.expires) {
+		delete(b.sessions, sum)
+		return s, true
+	}
+	return s, false
+}
+
+// authenticateBreakGlass serves requests made with a temporary admin token and
+// audits each of them. It reports false when sum is not a break-glass token.
+func (p *Platform) authenticateBreakGlass(g *gin.Context, sum string) bool {
+	s, expired := p.breakGlass.lookup(sum, p.clock.Now())
+	if s == nil {
+		return false
+	}
+	if expired {
+		_ = p.audit(s.operator, "breakglass_expired", s.reason, nil)
+		BaseController{}.ResponseFailure(g, ErrUnauthorized, "break-glass session expired")
+		return true
+	}
+	g.Set(principalKey, s.principal)
+	g.Set(breakGlassKey, sum)
+	g.Next()
+	_ = p.audit(s.operator, "breakglass_request", s.reason, map[string]any{
+		"method": g.Request.Method,
+		"path":   g.Request.URL.RequestURI(),
+		"status": g.Writer.Status(),
+		"ip":     g.ClientIP(),
+	})
+	return true
+}
+
+// expireBreakGlass drops and audits sessions that ran out without being used
+// again, so every grant ends with an expiry or revoke record.
+func (p *Platform) expireBreakGlass() {
+	now := p.clock.Now()
+	var gone []*breakGlassSession
+	p.breakGlass.mu.Lock()
+	for sum, s := range p.breakGlass.sessions {
+		if !now.Before(s.expires) {
+			delete(p.breakGlass.sessions, sum)
+			gone = append(gone, s)
+		}
+	}
+	p.breakGlass.mu.Unlock()
+	for _, s := range gone {
+		_ = p.audit(s.operator, "breakglass_expired", s.reason, nil)
+	}
+}
+
+const breakGlassKey = "breakglass"
+
+type BreakGlassController struct {
+	BaseController
+	p *Platform
+}
+
+func NewBreakGlassController(p *Platform) *BreakGlassController {
+	return &BreakGlassController{p: p}
+}
+
+// Grant godoc
+// @Summary      Break-glass: request temporary admin rights
+// @Description  Exchange a personal breakglass-role token for a short-lived admin token. A reason is required; the grant, every request made with the temporary token and its expiry are audited, and an alert is sent.
+// @Tags         auth
+// @Accept       x-www-form-urlencoded
+// @Produce      json
+// @Param        reason    formData  string  true   "Why elevated access is needed (at least 10 characters)"
+// @Param        duration  formData  string  false  "Requested lifetime, e.g. 30m (default 30m, capped by -breakglass-max)"
+// @Success      200  {object}  map[string]any  "token, operator, expires_at"
+// @Failure      400  {object}  map[string]any
+// @Failure      401  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/breakglass [post]
+func (c *BreakGlassController) Grant(g *gin.Context) {
+	if c.p.tokens == nil {
+		c.ResponseFailure(g, ErrUnsupported, "break-glass needs -auth-tokens")
+		return
+	}
+	pr := c.p.principal(g)
+	if pr.Role != RoleBreakGlass {
+		c.ResponseFailure(g, ErrForbidden, "a personal breakglass token is required")
+		return
+	}
+	reason := strings.TrimSpace(g.PostForm("reason"))
+	if len(reason) < minBreakGlassReason {
+		c.ResponseFailure(g, ErrParam, "reason is required (at least "+strconv.Itoa(minBreakGlassReason)+" characters)")
+		return
+	}
+	ttl := defaultBreakGlassTTL
+	if v := g.PostForm("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			c.ResponseFailure(g, ErrParam, "invalid duration")
+			return
+		}
+		ttl = d
+	}
+	if ttl > c.p.breakGlass.max {
+		ttl = c.p.breakGlass.max
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	tok := "bg_" + hex.EncodeToString(raw)
+	sum := sha256.Sum256([]byte(tok))
+	s := &breakGlassSession{
+		operator:  pr.Name,
+		reason:    reason,
+		expires:   c.p.clock.Now().Add(ttl),
+		principal: &Principal{Name: pr.Name + " (break-glass)", Role: RoleAdmin},
+	}
+	// 审计写不进去就不授权
+	if err := c.p.audit(pr.Name, "breakglass_grant", reason, map[string]any{
+		"expires_at": s.expires,
+		"ip":         g.ClientIP(),
+	}); err != nil {
+		c.ResponseFailure(g, ErrInternal, "audit log unavailable: "+err.Error())
+		return
+	}
+	c.p.breakGlass.mu.Lock()
+	c.p.breakGlass.sessions[hex.EncodeToString(sum[:])] = s
+	c.p.breakGlass.mu.Unlock()
+	c.p.emitAlert("breakglass_granted", pr.Name+" until "+s.expires.UTC().Format(time.RFC3339)+": "+reason)
+
+	g.JSON(http.StatusOK, gin.H{
+		"token":      tok,
+		"operator":   pr.Name,
+		"expires_at": s.expires,
+	})
+}
+
+// Revoke godoc
+// @Summary      Break-glass: end the session
+// @Description  Revoke the temporary admin token used for this request before it expires.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  map[string]any
+// @Failure      400  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/breakglass [delete]
+func (c *BreakGlassController) Revoke(g *gin.Context) {
+	sum := g.GetString(breakGlassKey)
+	if sum == "" {
+		c.ResponseFailure(g, ErrParam, "not a break-glass session")
+		return
+	}
+	c.p.breakGlass.mu.Lock()
+	s := c.p.breakGlass.sessions[sum]
+	delete(c.p.breakGlass.sessions, sum)
+	c.p.breakGlass.mu.Unlock()
+	if s != nil {
+		_ = c.p.audit(s.operator, "breakglass_revoke", s.reason, nil)
+	}
+	g.JSON(http.StatusOK, gin.H{"revoked": true})
+}
+
+// Audit godoc
+// @Summary      Audit log
+// @Description  Audited actions (break-glass access, approvals, policies, bisections, shadow deployments, recalls, purges, support bundles…), newest first.
+// @Tags         auth
+// @Produce      json
+// @Param        since   query  string  false  "RFC3339 lower bound"
+// @Param        actor   query  string  false  "Only this actor"
+// @Param        action  query  string  false  "Only this action (e.g. breakglass_grant)"
+// @Param        limit   query  int     false  "Page size, default 100, at most 1000"
+// @Param        sort    query  string  false  "time, actor or action, - for descending (default -time)"
+// @Param        cursor  query  string  false  "next_cursor of the previous page"
+// @Param        total   query  bool    false  "Include the number of matching records"
+// @Success      200  {object}  map[string]any  "records, next_cursor, total"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/audit [get]
+func (c *BreakGlassController) Audit(g *gin.Context) {
+	pp, ok := parsePage(c.BaseController, g, auditList)
+	if !ok {
+		return
+	}
+	var since time.Time
+	if v := g.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid since: "+err.Error())
+			return
+		}
+		since = t
+	}
+	page, next, total := paginate(c.p.auditLog.entries(since, g.Query("actor"), g.Query("action")), auditList, pp)
+	recs, err := c.p.auditLog.records(page)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, "read audit log: "+err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, setPage(gin.H{"records": recs}, pp, next, total))
+}
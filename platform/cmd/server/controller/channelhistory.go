@@ -0,0 +1,286 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 渠道历史：渠道最新版本（LatestByChannel）的每次变化——发布、影子部署转正、渐进发布开始时移入渠道、一致性修复、隔离与恢复，以及
+// 在服务端之外修改 releases.json 后重新加载、副本接收主区域推送的元数据——都追加一条记录到 <DataDir>/channel_history.jsonl
+// （内存后端下只保存在内存中）。记录只追加、不修改，不受事件保留期与 purge 影响，用于事故复盘：
+// “周二险情发生时，无人机拿到的是哪个版本？”
+// 历史从本功能第一次运行时开始，已有的渠道在那次加载时各记一条 from 为空的 reload 记录。
+// 召回不改变渠道指针（见 recall.go），召回与撤销记在审计日志中。
+
+// 渠道历史记录的变化原因。
+const (
+	ChangePublish    = "publish"
+	ChangePromote    = "shadow_promote"
+	ChangeRollout    = "rollout"
+	ChangeRepair     = "repair"
+	ChangeQuarantine = "quarantine"
+	ChangeRestore    = "restore"
+	ChangeReload     = "reload"
+	// ChangeReplication 是副本接收主区域推送的元数据（见 replication.go）
+	ChangeReplication = "replication"
+)
+
+// ChannelChange 是一个渠道最新版本的一次变化。
+type ChannelChange struct {
+	Seq     int       `json:"seq"`
+	Time    time.Time `json:"time"`
+	App     string    `json:"app,omitempty"`
+	Channel string    `json:"channel"`
+	From    string    `json:"from,omitempty"` // 之前的最新版本，渠道新建时为空
+	To      string    `json:"to,omitempty"`   // 之后的最新版本，渠道指针被删除时为空
+	Cause   string    `json:"cause"`          // publish | shadow_promote | rollout | repair | quarantine | restore | reload | replication
+	Actor   string    `json:"actor,omitempty"`
+}
+
+type channelHistory struct {
+	mu    sync.Mutex
+	path  string
+	fsync bool
+	recs  []ChannelChange
+	cur   map[string]string // LatestByChannel 键 -> 历史中记录的最新版本
+}
+
+func openChannelHistory(path string, fsync bool) (*channelHistory, error) {
+	h := &channelHistory{path: path, fsync: fsync, cur: map[string]string{}}
+	if path == "" {
+		return h, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var c ChannelChange
+		if err := json.Unmarshal(sc.Bytes(), &c); err == nil {
+			h.recs = append(h.recs, c)
+			h.apply(c)
+		}
+	}
+	return h, sc.Err()
+}
+
+func (h *channelHistory) apply(c ChannelChange) {
+	key := latestKey(c.App, c.Channel)
+	if c.To == "" {
+		delete(h.cur, key)
+	} else {
+		h.cur[key] = c.To
+	}
+}
+
+// record appends a change for every channel whose latest version differs
+// from the history. Nothing is recorded when the write fails, so the next
+// call retries.
+func (h *channelHistory) record(latest map[string]string, now time.Time, cause, actor string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := map[string]bool{}
+	for k := range latest {
+		keys[k] = true
+	}
+	for k := range h.cur {
+		keys[k] = true
+	}
+	var changes []ChannelChange
+	seq := len(h.recs)
+	for _, k := range sortedKeys(keys) {
+		if latest[k] == h.cur[k] {
+			continue
+		}
+		seq++
+		app, ch := splitLatestKey(k)
+		changes = append(changes, ChannelChange{Seq: seq, Time: now, App: app, Channel: ch,
+			From: h.cur[k], To: latest[k], Cause: cause, Actor: actor})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := h.write(changes); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		h.recs = append(h.recs, c)
+		h.apply(c)
+	}
+	return nil
+}
+
+func (h *channelHistory) write(changes []ChannelChange) error {
+	if h.path == "" {
+		return nil
+	}
+	var buf []byte
+	for _, c := range changes {
+		b, _ := json.Marshal(c)
+		buf = append(append(buf, b...), '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if h.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// list returns the changes of an app's channel within [since, until],
+// oldest first; zero bounds are open.
+func (h *channelHistory) list(app, channel string, since, until time.Time) []ChannelChange {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := []ChannelChange{}
+	for _, c := range h.recs {
+		if c.App == app && c.Channel == channel && (since.IsZero() || !c.Time.Before(since)) && (until.IsZero() || !c.Time.After(until)) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// at returns the last change of an app's channel at or before t, nil when
+// the history has none.
+func (h *channelHistory) at(app, channel string, t time.Time) *ChannelChange {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// 记录按时间顺序追加
+	i := sort.Search(len(h.recs), func(i int) bool { return h.recs[i].Time.After(t) })
+	for i--; i >= 0; i-- {
+		if c := h.recs[i]; c.App == app && c.Channel == channel {
+			return &c
+		}
+	}
+	return nil
+}
+
+// first is the time of the oldest record, zero when the history is empty.
+func (h *channelHistory) first() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.recs) == 0 {
+		return time.Time{}
+	}
+	return h.recs[0].Time
+}
+
+// recordLatest appends the channel pointer changes of p.store to the channel
+// history. Callers hold p.store.mu; the store is already saved, so a failed
+// write is only logged and retried with the next change.
+func (p *Platform) recordLatest(cause, actor string) {
+	if err := p.chanHistory.record(p.store.LatestByChannel, p.clock.Now(), cause, actor); err != nil {
+		log.Printf("channel history: %v", err)
+	}
+	// 渠道指针变化尽快复制到其它区域与镜像
+	p.kickReplication()
+	p.kickMirror()
+}
+
+// ChannelHistory godoc
+// @Summary      Channel history
+// @Description  Every change of the channel's latest version, oldest first: when, from which version to which, why (publish, shadow_promote, rollout, repair, quarantine, restore, reload, replication) and by whom. The history is append-only and outlives event retention.
+// @Tags         release
+// @Produce      json
+// @Param        channel  path   string  true   "Channel"
+// @Param        app      query  string  false  "App, default: the primary algorithm"
+// @Param        since    query  string  false  "RFC 3339 time; only changes at or after it"
+// @Param        until    query  string  false  "RFC 3339 time; only changes at or before it"
+// @Success      200  {object}  map[string]any  "app, channel, changes"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/channels/{channel}/history [get]
+func (c *FileController) ChannelHistory(g *gin.Context) {
+	app := g.Query("app")
+	if err := checkAppName(app); err != nil {
+		c.ResponseFailure(g, ErrParam, err.Error())
+		return
+	}
+	var since, until time.Time
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if v := g.Query(t.name); v != "" {
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.ResponseFailure(g, ErrParam, t.name+" must be an RFC 3339 time")
+				return
+			}
+			*t.dst = ts
+		}
+	}
+	channel := g.Param("channel")
+	g.JSON(http.StatusOK, gin.H{"app": app, "channel": channel, "changes": c.p.chanHistory.list(app, channel, since, until)})
+}
+
+// ChannelLatestAt godoc
+// @Summary      Latest version of a channel at a point in time
+// @Description  The version /check offered as the channel's latest at the given time (unless it was recalled then; recalls are in the audit log), with the change that made it latest. version is empty when the channel had been removed by then. 404 when the history has no record of the channel at or before that time.
+// @Tags         release
+// @Produce      json
+// @Param        channel  path   string  true   "Channel"
+// @Param        app      query  string  false  "App, default: the primary algorithm"
+// @Param        at       query  string  false  "RFC 3339 time, default: now"
+// @Success      200  {object}  map[string]any  "app, channel, at, version, change"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/channels/{channel}/latest [get]
+func (c *FileController) ChannelLatestAt(g *gin.Context) {
+	app := g.Query("app")
+	if err := checkAppName(app); err != nil {
+		c.ResponseFailure(g, ErrParam, err.Error())
+		return
+	}
+	at := c.p.clock.Now()
+	if v := g.Query("at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.ResponseFailure(g, ErrParam, "at must be an RFC 3339 time")
+			return
+		}
+		at = t
+	}
+	channel := g.Param("channel")
+	ch := c.p.chanHistory.at(app, channel, at)
+	if ch == nil {
+		msg := "no record of channel " + channel + " of " + appLabel(app) + " at or before " + at.Format(time.RFC3339)
+		if first := c.p.chanHistory.first(); !first.IsZero() {
+			msg += " (the history starts at " + first.Format(time.RFC3339) + ")"
+		}
+		c.ResponseFailure(g, ErrNotFound, msg)
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"app": app, "channel": channel, "at": at, "version": ch.To, "change": ch})
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 检查的决定：设备该得到哪个版本、是否现在下发，由渠道（或别名）的最新版本、渐进发布、二分定位、
+// agent 固定的版本、召回、冻结、设备组策略的审批与维护时段依次决定。/check 与 hawkBit 兼容层
+// （hawkbit.go）走同一条路径，hawkBit 客户端同样受冻结、渐进发布、审批与维护时段约束；被召回与隔离的
+// 版本不会成为渠道的最新版本（见 recall.go、quarantine.go）。agent 报告了安装后端（backend）时只考虑该格式的
+// 制品：渠道最新版本的格式不同时改用渠道中该格式最新的可用版本，deb/rpm 设备不会拿到 binary 制品，反之亦然；
+// 找不到时什么都不下发。决定只含数据与消息 ID，响应由调用方组织。
+
+// checkRequest 是决定所需的设备侧输入。
+type checkRequest struct {
+	device, channel, app, current string
+	pin                           string          // agent 固定的版本（pinned_version），hawkBit 没有
+	format                        string          // agent 的安装后端（binary|deb|rpm），空为不限
+	aliased                       *Release        // 订阅的别名指向的版本
+	reported                      *windowSchedule // 设备上报的维护时段
+}
+
+// checkDecision 是对一次检查的决定。
+type checkDecision struct {
+	latest    *Release // 设备应得到的版本，渠道尚无发布时为 nil
+	offer     bool     // 现在下发 latest
+	rollout   gin.H    // 渐进发布把 latest 换成了基线版本
+	bisectID  string
+	pinned    *Release // 二分定位固定的版本
+	devicePin *Release // agent 固定且可用的版本
+	rollback  bool     // 设备当前的版本被召回，切回上一个版本
+	freeze    gin.H    // 冻结扣下了新版本
+	window    gin.H    // 设备不在维护时段内
+	held      *Approval
+	// msg 与 vars 是响应的本地化消息（见 messages.go）
+	msg  string
+	vars []string
+}
+
+// decide makes the update decision of a check and queues the approval it
+// waits for, if any.
+func (p *Platform) decide(in checkRequest, who *Principal) checkDecision {
+	p.store.mu.RLock()
+	d := p.decideLocked(in, who)
+	p.store.mu.RUnlock()
+	// 设备组策略要求人工批准时暂不下发，并在审批队列中登记
+	if d.held != nil {
+		p.requestApproval(d.held)
+	}
+	return d
+}
+
+// decideLocked is decide without the approval queue. Callers hold
+// p.store.mu.
+func (p *Platform) decideLocked(in checkRequest, who *Principal) checkDecision {
+	d := checkDecision{msg: msgUpToDate}
+	latest := p.store.ReleasesByVersion[p.store.LatestByChannel[latestKey(in.app, in.channel)]]
+	if in.aliased != nil {
+		// 按版本号重新取，期间的召回等变更也能看到
+		latest = p.store.ReleasesByVersion[in.aliased.Version]
+		if latest != nil && !in.installs(latest) {
+			latest = nil
+		}
+	} else if latest != nil && !in.installs(latest) {
+		latest = p.store.ReleasesByVersion[p.store.newestFormat(in.app, in.channel, in.format)]
+	}
+	// 渐进发布中不在当前比例内的设备得到基线版本；别名指向的版本不受影响
+	if in.app == "" && in.aliased == nil {
+		if ro, base := p.rolloutHold(in.device, in.channel, in.current, latest); ro != nil {
+			latest = base
+			if latest != nil && !in.installs(latest) {
+				latest = nil
+			}
+			d.rollout = gin.H{"id": ro.ID, "version": ro.Version, "percent": ro.Percent}
+		}
+	}
+	// 二分定位中的测试设备固定到待测版本，不受渠道最新版本与更新策略影响；二分定位只针对主应用
+	if in.app == "" {
+		d.bisectID, d.pinned = p.bisectPin(in.device)
+	}
+	if d.pinned != nil {
+		latest, d.rollout = d.pinned, nil
+	}
+	// agent 固定了版本（pinned_version）时以它代替渠道或别名的最新版本，可以比当前版本旧；二分定位优先
+	if in.pin != "" && d.pinned == nil {
+		rel := p.store.ReleasesByVersion[in.pin]
+		if rel != nil && rel.App == in.app && rel.Recall == nil && rel.Quarantine == nil && in.installs(rel) && who.CanAccess(rel.Channel) {
+			d.devicePin = rel
+			latest, d.rollout = rel, nil
+		}
+	}
+	d.latest = latest
+	if latest == nil {
+		d.msg = msgNoRelease
+		return d
+	}
+
+	switch {
+	case d.pinned != nil:
+		if in.current != d.pinned.Version {
+			d.offer = true
+			d.msg, d.vars = msgPinned, []string{"version", d.pinned.Version, "bisect_id", d.bisectID}
+		}
+	case in.app == "" && p.store.recalled(in.current):
+		// 被召回的版本切回设备上保留的上一个版本，不下载；回滚后的检查再照常比较
+		d.rollback = true
+		d.msg, d.vars = msgRollback, []string{"version", in.current}
+	case in.pin != "" && d.devicePin == nil:
+		// 固定的版本不存在、被召回或隔离：什么都不下发
+		d.msg, d.vars = msgPinUnavailable, []string{"version", in.pin}
+	case latest.Recall != nil:
+		d.msg, d.vars = msgRecalled, []string{"version", latest.Version}
+	case d.devicePin != nil && in.current == d.devicePin.Version:
+	case in.current == "" || version.Newer(latest.Version, in.current) || d.devicePin != nil:
+		d.offer, d.msg = true, msgNewVersion
+		if d.devicePin != nil {
+			d.msg, d.vars = msgDevicePinned, []string{"version", in.pin}
+		}
+		// 冻结期间不下发非强制版本，也不登记审批（见 freeze.go）
+		if f := p.activeFreeze(in.app, in.channel, in.device); f != nil && !latest.Mandatory {
+			d.offer, d.freeze = false, f.notice()
+			d.msg, d.vars = msgFrozen, []string{"until", f.EndsAt.Format(time.RFC3339), "reason", f.Reason}
+			break
+		}
+		if d.held = p.approvalGate(in.device, in.channel, in.current, latest); d.held != nil {
+			d.offer = false
+			break
+		}
+		// 设备当地时间不在维护时段内时延后下发，强制版本除外（见 windows.go）
+		if !latest.Mandatory {
+			if w, next := p.windowHold(in.device, in.reported); w != nil {
+				d.offer, d.window = false, w
+				d.msg, d.vars = msgOutsideWindow, []string{"version", latest.Version, "next_open", next.Format(time.RFC3339)}
+			}
+		}
+	}
+	return d
+}
+
+// installs reports whether the device's install backend takes rel.
+func (in checkRequest) installs(rel *Release) bool {
+	return in.format == "" || releaseFormat(rel) == in.format
+}
+
+// newestFormat is the newest release of the app's channel in the given
+// artifact format ("" for any) that is neither recalled nor quarantined.
+// Callers hold p.store.mu.
+func (s *Store) newestFormat(app, channel, format string) string {
+	newest := ""
+	for v, rel := range s.ReleasesByVersion {
+		if rel.App != app || rel.Channel != channel || rel.Recall != nil || rel.Quarantine != nil || (format != "" && releaseFormat(rel) != format) {
+			continue
+		}
+		if newest == "" || version.Newer(v, newest) {
+			newest = v
+		}
+	}
+	return newest
+}
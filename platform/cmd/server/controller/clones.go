@@ -0,0 +1,308 @@
+package controller
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 重复设备 ID 检测：同一镜像烧录的多架无人机共用 device_id，会在服务端互相覆盖状态。
+// agent 在每个请求上携带由硬件派生的实例指纹（X-Device-Instance），窗口期内同一 ID 出现
+// 不同指纹即判定为冲突；不带指纹的旧客户端退而检查来源 IP 是否来回交替（A→B→A）。
+// 冲突会告警并记入事件日志；拆分后各实例获得独立 ID，检查响应头 X-Device-ID 通知 agent 改用新 ID。
+
+const (
+	instanceHeader = "X-Device-Instance"
+	// conflictWindow 内的签到视为并发。
+	conflictWindow = 15 * time.Minute
+	// conflictAlertEvery 限制同一设备的告警频率。
+	conflictAlertEvery = time.Hour
+	maxSightings       = 8
+)
+
+type sighting struct {
+	instance string
+	ip       string
+	at       time.Time
+}
+
+// DeviceConflict 是一个疑似被多台设备共用的 ID。
+type DeviceConflict struct {
+	DeviceID  string    `json:"device_id"`
+	Instances []string  `json:"instances,omitempty"` // 各实例指纹，按首次出现排序
+	IPs       []string  `json:"ips"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type cloneDetector struct {
+	mu        sync.Mutex
+	recent    map[string][]sighting // device -> 最近的签到
+	conflicts map[string]*DeviceConflict
+	alerted   map[string]time.Time
+}
+
+func newCloneDetector() *cloneDetector {
+	return &cloneDetector{
+		recent:    map[string][]sighting{},
+		conflicts: map[string]*DeviceConflict{},
+		alerted:   map[string]time.Time{},
+	}
+}
+
+func appendUnique(list []string, s string) []string {
+	if s == "" {
+		return list
+	}
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// observe records a check-in and reports whether it reveals (or continues)
+// a conflict, plus whether an alert is due.
+func (d *cloneDetector) observe(device string, s sighting) (c *DeviceConflict, alert bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var list []sighting
+	for _, old := range d.recent[device] {
+		if s.at.Sub(old.at) <= conflictWindow {
+			list = append(list, old)
+		}
+	}
+	list = append(list, s)
+	if len(list) > maxSightings {
+		list = list[len(list)-maxSightings:]
+	}
+	d.recent[device] = list
+
+	clash := false
+	if s.instance != "" {
+		for _, old := range list {
+			if old.instance != "" && old.instance != s.instance {
+				clash = true
+			}
+		}
+	} else if n := len(list); n >= 3 {
+		// 没有指纹时只认来回交替的 IP，单纯换网（移动网络、NAT）不算
+		a, b := list[n-3], list[n-2]
+		clash = a.instance == "" && b.instance == "" && a.ip == s.ip && b.ip != s.ip
+	}
+	c = d.conflicts[device]
+	if !clash && c == nil {
+		return nil, false
+	}
+	if c == nil {
+		c = &DeviceConflict{DeviceID: device, FirstSeen: list[0].at}
+		d.conflicts[device] = c
+	}
+	for _, old := range list {
+		c.Instances = appendUnique(c.Instances, old.instance)
+		c.IPs = appendUnique(c.IPs, old.ip)
+	}
+	c.LastSeen = s.at
+	if !clash {
+		return c, false
+	}
+	if s.at.Sub(d.alerted[device]) >= conflictAlertEvery {
+		d.alerted[device] = s.at
+		alert = true
+	}
+	return c, alert
+}
+
+func (d *cloneDetector) list() []DeviceConflict {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]DeviceConflict, 0, len(d.conflicts))
+	for _, c := range d.conflicts {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastSeen.After(out[j].LastSeen) })
+	return out
+}
+
+func (d *cloneDetector) resolve(device string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.conflicts, device)
+	delete(d.recent, device)
+	delete(d.alerted, device)
+}
+
+func splitKey(device, instance string) string { return device + "/" + instance }
+
+// resolveDevice maps a device ID to the one assigned to instance by a split.
+func (p *Platform) resolveDevice(device, instance string) string {
+	if instance == "" {
+		return device
+	}
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+	if id, ok := p.store.DeviceSplits[splitKey(device, instance)]; ok {
+		return id
+	}
+	return device
+}
+
+// checkIn resolves the device ID a check-in should be recorded under (after
+// a split) and feeds the clone detector. It returns the effective ID and
+// whether it differs from what the device sent.
+func (p *Platform) checkIn(g *gin.Context, device string) (string, bool) {
+	if device == "" {
+		return "", false
+	}
+	instance := g.GetHeader(instanceHeader)
+	if id := p.resolveDevice(device, instance); id != device {
+		return id, true
+	}
+	c, alert := p.clones.observe(device, sighting{instance: instance, ip: g.ClientIP(), at: p.clock.Now()})
+	if alert {
+		detail := device + " is used by " + strings.Join(c.Instances, ", ")
+		if len(c.Instances) < 2 {
+			detail = device + " checks in alternately from " + strings.Join(c.IPs, ", ")
+		}
+		p.emitAlert("device_id_conflict", detail)
+		p.recordEvent(&DeviceEvent{
+			DeviceID: device,
+			Type:     "conflict",
+			Data:     map[string]any{"instances": c.Instances, "ips": c.IPs},
+		})
+	}
+	return device, false
+}
+
+// Conflicts godoc
+// @Summary      Duplicate device IDs
+// @Description  Device IDs that several physical devices appear to share (different instance fingerprints, or alternating IPs for agents without one).
+// @Tags         device
+// @Produce      json
+// @Param        limit   query  int     false  "Page size, default 100, at most 1000"
+// @Param        sort    query  string  false  "last_seen, first_seen or device_id, - for descending (default -last_seen)"
+// @Param        cursor  query  string  false  "next_cursor of the previous page"
+// @Param        total   query  bool    false  "Include the number of conflicts"
+// @Success      200  {object}  map[string]any  "conflicts, next_cursor, total"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/devices/conflicts [get]
+func (c *EventController) Conflicts(g *gin.Context) {
+	pp, ok := parsePage(c.BaseController, g, conflictList)
+	if !ok {
+		return
+	}
+	page, next, total := paginate(c.p.clones.list(), conflictList, pp)
+	g.JSON(http.StatusOK, setPage(gin.H{"conflicts": page}, pp, next, total))
+}
+
+// conflictList 是重复 ID 列表的分页方式。
+var conflictList = listSpec[DeviceConflict]{
+	id: func(c DeviceConflict) string { return c.DeviceID },
+	sorts: map[string]sortField[DeviceConflict]{
+		"last_seen":  {key: func(c DeviceConflict) string { return timeKey(c.LastSeen) }},
+		"first_seen": {key: func(c DeviceConflict) string { return timeKey(c.FirstSeen) }},
+		"device_id":  {key: func(c DeviceConflict) string { return c.DeviceID }},
+	},
+	defaultSort: "-last_seen",
+}
+
+// Split godoc
+// @Summary      Split a duplicated device ID
+// @Description  Give each instance fingerprint seen under the ID a distinct device ID. The first instance keeps the original ID unless mapped explicitly; the others default to <id>-<fingerprint prefix>. Past events carrying an instance fingerprint move to the new IDs, and the next check response tells each agent its new ID (X-Device-ID header).
+// @Tags         device
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true   "Device ID"
+// @Param        body  body  object  false  "{\"instances\": {\"<fingerprint>\": \"<new id>\"}}"
+// @Success      200  {object}  map[string]any  "device_id, assigned, events_moved"
+// @Failure      400  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/devices/{id}/split [post]
+func (c *EventController) Split(g *gin.Context) {
+	device := g.Param("id")
+	var body struct {
+		Instances map[string]string `json:"instances"`
+	}
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&body); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	var conflict *DeviceConflict
+	for _, cf := range c.p.clones.list() {
+		if cf.DeviceID == device {
+			cf := cf
+			conflict = &cf
+		}
+	}
+	assigned := map[string]string{}
+	if len(body.Instances) > 0 {
+		assigned = body.Instances
+	} else {
+		if conflict == nil {
+			c.ResponseFailure(g, ErrNotFound, "no conflict recorded for "+device+"; pass instances explicitly")
+			return
+		}
+		if len(conflict.Instances) < 2 {
+			c.ResponseFailure(g, ErrParam, "the devices sharing "+device+" send no instance fingerprint (old agents); give them distinct device_id values in their config")
+			return
+		}
+		for _, inst := range conflict.Instances[1:] {
+			short := inst
+			if len(short) > 8 {
+				short = short[:8]
+			}
+			assigned[inst] = device + "-" + short
+		}
+	}
+	for inst, id := range assigned {
+		if inst == "" || id == "" || id == device || strings.Contains(id, "/") {
+			c.ResponseFailure(g, ErrParam, "invalid mapping "+inst+" -> "+id)
+			return
+		}
+	}
+
+	c.p.store.mu.Lock()
+	if c.p.store.DeviceSplits == nil {
+		c.p.store.DeviceSplits = map[string]string{}
+	}
+	for inst, id := range assigned {
+		c.p.store.DeviceSplits[splitKey(device, inst)] = id
+	}
+	err := c.p.saveStore(c.p.store)
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+
+	// 把带有指纹的历史事件归到新 ID 下
+	moved, err := c.p.events.Edit(func(ev *DeviceEvent) EditAction {
+		if ev.DeviceID != device {
+			return EditKeep
+		}
+		inst, _ := ev.Data["instance"].(string)
+		if id, ok := assigned[inst]; ok {
+			ev.DeviceID = id
+			return EditChanged
+		}
+		return EditKeep
+	})
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	c.p.clones.resolve(device)
+	_ = c.p.audit(c.p.principal(g).Name, "device_split", "", map[string]any{"device_id": device, "assigned": assigned, "events_moved": moved})
+	g.JSON(http.StatusOK, gin.H{"device_id": device, "assigned": assigned, "events_moved": moved})
+}
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/bundle"
+)
+
+// 版本对比：管理端“即将发布什么”的审阅页用 GET /api/v1/releases/compare?from=&to= 对比两个版本：
+// 制品大小差、sha256 是否变化、元数据（渠道、格式、说明、安全影响、不兼容、强制、启动模板、影子参数、签名、架构、Go 版本……）
+// 逐项的新旧值、关联问题的增减。带启动模板的 tar.gz / zip 包中含 SBOM（CycloneDX 或 SPDX JSON）时，
+// 再给出依赖的新增、删除与版本变化；两个版本都没有 SBOM 时 sbom 为空。
+
+// sbomNames 是包内被识别为 SBOM 的文件名（按 base name 匹配）。
+var sbomNames = []string{"sbom.json", "bom.json", "*.cdx.json", "*.spdx.json"}
+
+// maxSBOMSize 限制读取的 SBOM 文件大小。
+const maxSBOMSize = 8 << 20
+
+// fieldChange 是一个元数据字段的新旧值。
+type fieldChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// depChange 是 SBOM 中一个依赖的版本变化；新增的 From 为空，删除的 To 为空。
+type depChange struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// sbomDiff 是两个版本 SBOM 依赖的差异。
+type sbomDiff struct {
+	From    bool        `json:"from"` // 旧版本是否带 SBOM
+	To      bool        `json:"to"`
+	Added   []depChange `json:"added"`
+	Removed []depChange `json:"removed"`
+	Changed []depChange `json:"changed"`
+}
+
+// Compare godoc
+// @Summary      Compare two releases
+// @Description  What changes between two releases: artifact size delta, whether the sha256 changed, old and new values of each changed metadata field (channel, format, notes, safety impact, breaking, mandatory, launch template, shadow args, signing, architecture, Go version…), issues added and removed, and, when the tar.gz bundles carry an SBOM (CycloneDX or SPDX JSON), the dependencies added, removed or changed.
+// @Tags         release
+// @Produce      json
+// @Param        from  query  string  true  "Old version (e.g. 1.2.0)"
+// @Param        to    query  string  true  "New version (e.g. 1.3.0)"
+// @Success      200  {object}  map[string]any  "from, to, size, sha256, changes, issues, sbom"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any  "channel outside token scope"
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/releases/compare [get]
+func (c *FileController) Compare(g *gin.Context) {
+	from, to := g.Query("from"), g.Query("to")
+	if from == "" || to == "" {
+		c.ResponseFailure(g, ErrParam, "from and to are required")
+		return
+	}
+	c.p.reloadIfChanged()
+	c.p.store.mu.RLock()
+	a, b := c.p.store.ReleasesByVersion[from], c.p.store.ReleasesByVersion[to]
+	c.p.store.mu.RUnlock()
+	switch {
+	case a == nil:
+		c.ResponseFailure(g, ErrNotFound, "unknown version "+from)
+		return
+	case b == nil:
+		c.ResponseFailure(g, ErrNotFound, "unknown version "+to)
+		return
+	}
+	if !c.p.allowChannel(g, a.Channel) || !c.p.allowChannel(g, b.Channel) {
+		return
+	}
+
+	sizeA, sbomA, err := c.p.inspectArtifact(a)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	sizeB, sbomB, err := c.p.inspectArtifact(b)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	added, removed := diffStrings(releaseIssues(a), releaseIssues(b))
+	g.JSON(http.StatusOK, gin.H{
+		"from": gin.H{"version": a.Version, "channel": a.Channel, "created_at": a.CreatedAt},
+		"to":   gin.H{"version": b.Version, "channel": b.Channel, "created_at": b.CreatedAt},
+		// 制品缺失时对应的大小为 -1
+		"size":    gin.H{"from": sizeA, "to": sizeB, "delta": sizeB - sizeA},
+		"sha256":  gin.H{"from": a.Sha256, "to": b.Sha256, "changed": a.Sha256 != b.Sha256},
+		"changes": releaseChanges(a, b),
+		"issues":  gin.H{"added": added, "removed": removed},
+		"sbom":    diffSBOM(sbomA, sbomB),
+	})
+}
+
+// releaseChanges lists the metadata fields that differ between a and b.
+func releaseChanges(a, b *Release) []fieldChange {
+	notes := func(r *Release) ReleaseNotes {
+		if r.ReleaseNotes == nil {
+			return ReleaseNotes{}
+		}
+		return *r.ReleaseNotes
+	}
+	na, nb := notes(a), notes(b)
+	fields := []struct {
+		name     string
+		from, to any
+	}{
+		{"app", a.App, b.App},
+		{"channel", a.Channel, b.Channel},
+		{"format", releaseFormatName(a), releaseFormatName(b)},
+		{"notes", a.Notes, b.Notes},
+		{"summary", na.Summary, nb.Summary},
+		{"safety", a.SafetyImpact(), b.SafetyImpact()},
+		{"breaking", na.Breaking, nb.Breaking},
+		{"mandatory", a.Mandatory, b.Mandatory},
+		{"launch", a.Launch, b.Launch},
+		{"package", a.Package, b.Package},
+		{"artifact_type", a.ArtifactType, b.ArtifactType},
+		{"image_id", a.ImageID, b.ImageID},
+		{"shadow_args", a.ShadowArgs, b.ShadowArgs},
+		{"campaign", a.Campaign, b.Campaign},
+		{"key_id", a.KeyID, b.KeyID},
+		{"cosign_signed", len(a.CosignBundle) > 0, len(b.CosignBundle) > 0},
+		{"arch", binaryArch(a), binaryArch(b)},
+		{"go_version", goVersion(a), goVersion(b)},
+	}
+	out := []fieldChange{}
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.from, f.to) {
+			out = append(out, fieldChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+	return out
+}
+
+func releaseFormatName(r *Release) string {
+	if r.Format == "" {
+		return "binary"
+	}
+	return r.Format
+}
+
+func releaseIssues(r *Release) []string {
+	if r.ReleaseNotes == nil {
+		return nil
+	}
+	return r.ReleaseNotes.Issues
+}
+
+// diffStrings returns the entries only in b (added) and only in a (removed).
+func diffStrings(a, b []string) (added, removed []string) {
+	in := func(list []string, s string) bool {
+		for _, x := range list {
+			if x == s {
+				return true
+			}
+		}
+		return false
+	}
+	added, removed = []string{}, []string{}
+	for _, s := range b {
+		if !in(a, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range a {
+		if !in(b, s) {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+// inspectArtifact returns the artifact size (-1 when missing) and, for
+// tar.gz bundles, the dependencies listed by an SBOM inside (nil if none).
+func (p *Platform) inspectArtifact(rel *Release) (int64, map[string]string, error) {
+	a, err := p.artifacts.Open(rel.Version)
+	if errors.Is(err, errArtifactNotFound) {
+		return -1, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	defer a.Close()
+	if rel.Launch == "" {
+		return a.Size(), nil, nil
+	}
+	// 包损坏不影响其它字段的对比
+	deps, _ := bundleSBOM(a, a.Size())
+	return a.Size(), deps, nil
+}
+
+// errFound stops a bundle walk early.
+var errFound = errors.New("found")
+
+// bundleSBOM scans a tar.gz or zip bundle for the first SBOM file and
+// returns its dependencies as name -> version.
+func bundleSBOM(r io.ReaderAt, size int64) (map[string]string, error) {
+	var doc []byte
+	err := bundle.Walk(r, size, func(e bundle.Entry, body io.Reader) error {
+		if e.Size > maxSBOMSize || !isSBOMName(path.Base(e.Name)) {
+			return nil
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		doc = b
+		return errFound
+	})
+	if doc == nil {
+		return nil, err
+	}
+	return parseSBOM(doc)
+}
+
+func isSBOMName(name string) bool {
+	for _, p := range sbomNames {
+		if ok, _ := path.Match(p, strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSBOM reads the components of a CycloneDX document or the packages of
+// an SPDX document.
+func parseSBOM(b []byte) (map[string]string, error) {
+	var doc struct {
+		Components []struct {
+			Name    string `json:"name"`
+			Group   string `json:"group"`
+			Version string `json:"version"`
+		} `json:"components"`
+		Packages []struct {
+			Name        string `json:"name"`
+			VersionInfo string `json:"versionInfo"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	deps := map[string]string{}
+	for _, c := range doc.Components {
+		name := c.Name
+		if c.Group != "" {
+			name = c.Group + "/" + c.Name
+		}
+		deps[name] = c.Version
+	}
+	for _, p := range doc.Packages {
+		deps[p.Name] = p.VersionInfo
+	}
+	return deps, nil
+}
+
+// diffSBOM compares the dependencies of two SBOMs; nil when neither
+// release has one.
+func diffSBOM(a, b map[string]string) *sbomDiff {
+	if a == nil && b == nil {
+		return nil
+	}
+	d := &sbomDiff{From: a != nil, To: b != nil, Added: []depChange{}, Removed: []depChange{}, Changed: []depChange{}}
+	for name, v := range b {
+		old, ok := a[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, depChange{Name: name, To: v})
+		case old != v:
+			d.Changed = append(d.Changed, depChange{Name: name, From: old, To: v})
+		}
+	}
+	for name, v := range a {
+		if _, ok := b[name]; !ok {
+			d.Removed = append(d.Removed, depChange{Name: name, From: v})
+		}
+	}
+	for _, list := range [][]depChange{d.Added, d.Removed, d.Changed} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	return d
+}
@@ -0,0 +1,256 @@
+package controller
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 响应压缩：机队规模的检查流量走蜂窝网络，JSON 响应（检查、列表、统计）压缩后通常只剩几分之一。
+// 按 Accept-Encoding 协商编码（服务端偏好顺序，q=0 表示拒绝），只压缩 Content-Type 在允许列表中、
+// 且不小于阈值的响应：小响应压缩省不了几个字节，反而多耗设备 CPU；制品下载、支持包等已压缩的内容不在列表中，
+// 原样透传，Range 请求的部分响应也不压缩。可能压缩的响应带 Vary: Accept-Encoding；ETag 不变，
+// 按未压缩内容计算，If-None-Match 照常生效。
+// 目前只内置 gzip；zstd 需要第三方库，未引入。
+
+// responseEncoders 是响应压缩支持的 Content-Encoding，编码器复用以免每个响应分配压缩窗口。
+var responseEncoders = map[string]*sync.Pool{
+	"gzip": {New: func() any { return gzip.NewWriter(io.Discard) }},
+}
+
+// resetWriter 是可复用的压缩编码器。
+type resetWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// CompressionConfig 控制响应压缩；Encodings 为空时不压缩。
+type CompressionConfig struct {
+	// Encodings 按服务端偏好排列，如 ["gzip"]。
+	Encodings []string
+	// MinSize 是压缩的最小响应体字节数，更小的响应原样发送。
+	MinSize int
+	// Types 是允许压缩的 Content-Type（不含参数，path.Match 通配，如 text/*）。
+	Types []string
+}
+
+// ParseCompression builds a CompressionConfig from comma-separated encodings
+// and content types; empty encodings disable compression.
+func ParseCompression(encodings, types string, minSize int) (CompressionConfig, error) {
+	var cfg CompressionConfig
+	for _, enc := range strings.Split(encodings, ",") {
+		enc = strings.ToLower(strings.TrimSpace(enc))
+		if enc == "" {
+			continue
+		}
+		if _, ok := responseEncoders[enc]; !ok {
+			return cfg, fmt.Errorf("unsupported encoding %q (supported: %s)", enc, strings.Join(supportedEncodings(), ", "))
+		}
+		cfg.Encodings = append(cfg.Encodings, enc)
+	}
+	for _, t := range strings.Split(types, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		if _, err := path.Match(t, ""); err != nil {
+			return cfg, fmt.Errorf("content type %q: %w", t, err)
+		}
+		cfg.Types = append(cfg.Types, t)
+	}
+	if minSize < 0 {
+		return cfg, fmt.Errorf("invalid minimum size %d", minSize)
+	}
+	cfg.MinSize = minSize
+	return cfg, nil
+}
+
+func supportedEncodings() []string {
+	out := make([]string, 0, len(responseEncoders))
+	for enc := range responseEncoders {
+		out = append(out, enc)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// allowType reports whether responses of content type ct may be compressed.
+func (cfg *CompressionConfig) allowType(ct string) bool {
+	ct, _, _ = strings.Cut(ct, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	for _, t := range cfg.Types {
+		if ok, _ := path.Match(t, ct); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks the offered encoding the client accepts with the
+// highest q-value; ties go to the earlier (server-preferred) one.
+func negotiateEncoding(header string, offered []string) string {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if enc = strings.ToLower(strings.TrimSpace(enc)); enc != "" {
+			accepted[enc] = q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range offered {
+		q, ok := accepted[enc]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// CompressResponses compresses eligible responses with the encoding
+// negotiated from Accept-Encoding.
+func (p *Platform) CompressResponses(g *gin.Context) {
+	if len(p.compression.Encodings) == 0 {
+		return
+	}
+	enc := negotiateEncoding(g.GetHeader("Accept-Encoding"), p.compression.Encodings)
+	if enc == "" {
+		return
+	}
+	w := &compressWriter{ResponseWriter: g.Writer, cfg: &p.compression, enc: enc}
+	g.Writer = w
+	g.Next()
+	w.finish()
+	g.Writer = w.ResponseWriter
+}
+
+const (
+	compressUndecided = iota // 尚未写出响应体
+	compressBuffering        // 类型允许，未达阈值，先缓冲
+	compressOn
+	compressOff
+)
+
+// compressWriter buffers the start of an eligible response until it reaches
+// the size threshold, then switches to compressed output; headers are held
+// back until that decision is made.
+type compressWriter struct {
+	gin.ResponseWriter
+	cfg   *CompressionConfig
+	enc   string
+	state int
+	buf   []byte
+	zw    resetWriter
+}
+
+func (w *compressWriter) decide() {
+	w.state = compressOff
+	h := w.Header()
+	status := w.Status()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" ||
+		status == http.StatusPartialContent || status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	if w.cfg.allowType(h.Get("Content-Type")) {
+		h.Add("Vary", "Accept-Encoding")
+		w.state = compressBuffering
+	}
+}
+
+// start switches to compressed output and writes the buffered prefix.
+func (w *compressWriter) start() error {
+	h := w.Header()
+	h.Set("Content-Encoding", w.enc)
+	h.Del("Content-Length")
+	w.zw = responseEncoders[w.enc].Get().(resetWriter)
+	w.zw.Reset(w.ResponseWriter)
+	w.state = compressOn
+	buf := w.buf
+	w.buf = nil
+	_, err := w.zw.Write(buf)
+	return err
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.state == compressUndecided {
+		w.decide()
+	}
+	switch w.state {
+	case compressBuffering:
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.cfg.MinSize {
+			if err := w.start(); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	case compressOn:
+		return w.zw.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow is deferred while the encoding is undecided; gin writes the
+// header of a bodiless response after the handlers return.
+func (w *compressWriter) WriteHeaderNow() {
+	if w.state == compressOn || w.state == compressOff {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// Flush sends what has been written so far; a streaming response is
+// compressed from its first flush regardless of size.
+func (w *compressWriter) Flush() {
+	switch w.state {
+	case compressUndecided:
+		w.state = compressOff
+	case compressBuffering:
+		if w.start() != nil {
+			return
+		}
+	}
+	if w.state == compressOn {
+		_ = w.zw.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish writes out a response that stayed below the threshold, or ends the
+// compressed stream.
+func (w *compressWriter) finish() {
+	switch w.state {
+	case compressBuffering:
+		w.state = compressOff
+		if len(w.buf) > 0 {
+			_, _ = w.ResponseWriter.Write(w.buf)
+		}
+	case compressOn:
+		_ = w.zw.Close()
+		w.zw.Reset(io.Discard)
+		responseEncoders[w.enc].Put(w.zw)
+		w.zw = nil
+	}
+}
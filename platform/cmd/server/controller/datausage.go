@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 设备流量：agent 统计当前计费周期经 OTA 客户端收发的字节数，随心跳上报（data.data_usage），配置了
+// 月度上限的设备同时给出上限与是否已达到。服务端取每台设备最近一次带用量的心跳，列出用量最高、
+// 已达上限（此后只下载强制版本）的设备，供按流量配额规划发布。
+
+// dataUsageWindow 是缺省查找心跳的时间范围。
+const dataUsageWindow = 7 * 24 * time.Hour
+
+// DeviceDataUsage 是一台设备最近上报的当期流量。
+type DeviceDataUsage struct {
+	DeviceID      string    `json:"device_id"`
+	Channel       string    `json:"channel"`
+	Version       string    `json:"version"`
+	PeriodStart   string    `json:"period_start"` // 计费周期开始日期
+	DownloadBytes int64     `json:"download_bytes"`
+	UploadBytes   int64     `json:"upload_bytes"`
+	CapBytes      int64     `json:"cap_bytes,omitempty"` // 未配置上限时省略
+	Capped        bool      `json:"capped"`
+	Reported      time.Time `json:"reported"`
+}
+
+// DataUsage godoc
+// @Summary      Device data usage against monthly caps
+// @Description  Lists the data each device's agent sent and received in its current billing period, from its latest heartbeat, heaviest users first, with the monthly cap where one is configured. Devices at their cap only download mandatory releases until the next period.
+// @Tags         device
+// @Produce      json
+// @Param        channel  query  string  false  "Only this channel"
+// @Param        since    query  string  false  "RFC 3339 start of the window searched for heartbeats, default: 7 days ago"
+// @Param        capped   query  bool    false  "Only devices at their cap"
+// @Success      200  {object}  map[string]any  "devices ([]controller.DeviceDataUsage), capped (count)"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/fleet/data-usage [get]
+func (c *FleetController) DataUsage(g *gin.Context) {
+	since := c.p.clock.Now().Add(-dataUsageWindow)
+	if v := g.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid since: "+err.Error())
+			return
+		}
+		since = t
+	}
+	evs, err := c.p.events.Query(EventQuery{Type: "heartbeat", Since: since})
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	only, onlyCapped := g.Query("channel"), g.Query("capped") == "true"
+	seen := map[string]bool{}
+	devices := []DeviceDataUsage{}
+	capped := 0
+	// Query 按时间倒序返回，设备第一次出现即是它最近一次上报
+	for _, ev := range evs {
+		du, ok := ev.Data["data_usage"].(map[string]any)
+		if !ok || ev.DeviceID == "" || seen[ev.DeviceID] || (only != "" && ev.Channel != only) {
+			continue
+		}
+		seen[ev.DeviceID] = true
+		num := func(k string) int64 {
+			f, _ := du[k].(float64)
+			return int64(f)
+		}
+		d := DeviceDataUsage{
+			DeviceID:      ev.DeviceID,
+			Channel:       ev.Channel,
+			Version:       ev.Version,
+			DownloadBytes: num("download_bytes"),
+			UploadBytes:   num("upload_bytes"),
+			CapBytes:      num("cap_bytes"),
+			Reported:      ev.Time,
+		}
+		d.PeriodStart, _ = du["period_start"].(string)
+		d.Capped, _ = du["capped"].(bool)
+		if d.Capped {
+			capped++
+		} else if onlyCapped {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].DownloadBytes+devices[i].UploadBytes > devices[j].DownloadBytes+devices[j].UploadBytes
+	})
+	g.JSON(http.StatusOK, gin.H{"devices": devices, "capped": capped})
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"crypto/ed25519"
+
+	"github.com/von0000/dronealgo-ota/internal/clock"
+	"github.com/von0000/dronealgo-ota/internal/tlog"
+)
+
+// Clock 抽象当前时间与定时器：调度、过期、退避与保留期都经由它计时，
+// 便于测试用 clock.Fake 精确推进，仿真时用 clock.Scaled 加速。
+type Clock = clock.Clock
+
+// SystemClock returns the wall-clock implementation.
+func SystemClock() Clock { return clock.System() }
+
+// Signer 在发布时为制品生成分离签名，签名内容见 internal/artifactsig；为 nil 时不签名。
+type Signer interface {
+	// Sign returns a detached signature over msg.
+	Sign(msg []byte) ([]byte, error)
+	// KeyID identifies the signing key so clients can pick the verifier.
+	KeyID() string
+}
+
+// ed25519Signer 用 ed25519 私钥签名，密钥 ID 与透明日志相同（公钥 SHA-256 的前 8 字节）。
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewEd25519Signer returns a Signer backed by key.
+func NewEd25519Signer(key ed25519.PrivateKey) Signer { return ed25519Signer{key: key} }
+
+func (s ed25519Signer) Sign(msg []byte) ([]byte, error) { return ed25519.Sign(s.key, msg), nil }
+
+func (s ed25519Signer) KeyID() string { return tlog.KeyID(s.key.Public().(ed25519.PublicKey)) }
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"syscall"
+)
+
+// minFreeBytes 是退出只读模式所需的最小剩余空间。
+const minFreeBytes = 200 << 20
+
+// errFreeSpaceUnknown 表示平台不支持查询剩余空间（见 diskguard_other.go）。
+var errFreeSpaceUnknown = errors.New("free space is not known on this platform")
+
+func isNoSpace(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+func (p *Platform) enterReadOnly(op string, err error) {
+	if p.readOnly.CompareAndSwap(false, true) {
+		p.emitAlert("disk_full", op+": "+err.Error()+"; server switched to read-only mode")
+	}
+}
+
+// stillReadOnly re-probes free space and leaves read-only mode once both the
+// data and artifact directories have room again.
+func (p *Platform) stillReadOnly() bool {
+	if !p.readOnly.Load() {
+		return false
+	}
+	for _, d := range []string{p.dataDir, p.artifactDir} {
+		free, err := freeBytes(d)
+		if errors.Is(err, errFreeSpaceUnknown) {
+			break // 无法查询时放行，磁盘仍满的话下一次写入会再次进入只读模式
+		}
+		if err != nil || free < minFreeBytes {
+			return true
+		}
+	}
+	if p.readOnly.CompareAndSwap(true, false) {
+		log.Printf("disk space recovered, leaving read-only mode")
+	}
+	return false
+}
+
+// fsErrCode maps a filesystem error to a response code, entering read-only
+// mode when the disk is full.
+func (p *Platform) fsErrCode(err error, op string) ErrCode {
+	if isNoSpace(err) {
+		p.enterReadOnly(op, err)
+		return ErrNoSpace
+	}
+	return ErrInternal
+}
+
+func fsErr(err error, op string) error {
+	if isNoSpace(err) {
+		return errors.New("disk full during " + op + "; free space on the artifact/data volume and retry")
+	}
+	return errors.New(op + ": " + err.Error())
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package controller
+
+// freeBytes 在非 Unix 平台上无法查询剩余空间。
+func freeBytes(dir string) (uint64, error) {
+	return 0, errFreeSpaceUnknown
+}
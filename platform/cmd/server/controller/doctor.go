@@ -0,0 +1,387 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 一致性检查：核对发布记录、制品存储与设备事件。发布记录指向缺失的制品、设备仍在运行已无记录的
+// 版本只报告，需要人工处理；渠道 latest 指向不存在或不可下载的版本时改指该渠道最新的完好版本，
+// 制品校验和不符的版本移入 quarantined 渠道（见 quarantine.go），无记录的制品与遗留的上传临时文件
+// 被删除——这些可以自动修复。副本（-accept-replication）上的无记录制品只报告：主区域先复制制品、
+// 全部就绪后才复制元数据（见 replication.go），其间的制品尚无记录，删除会让随后到达的索引引用缺失的制品。
+
+const (
+	IssueMissingArtifact = "missing_artifact"
+	IssueHashMismatch    = "hash_mismatch"
+	IssueOrphanArtifact  = "orphan_artifact"
+	IssueStaleUpload     = "stale_upload"
+	IssueDanglingLatest  = "dangling_latest"
+	IssueUnknownVersion  = "device_unknown_version"
+)
+
+// staleUploadAge 之前的上传临时文件不可能属于进行中的发布。
+const staleUploadAge = time.Hour
+
+// maxIssueDevices 限制单条问题中列出的设备数。
+const maxIssueDevices = 20
+
+// Inconsistency 是一致性检查发现的一个问题。
+type Inconsistency struct {
+	Kind    string   `json:"kind"`
+	Version string   `json:"version,omitempty"`
+	Channel string   `json:"channel,omitempty"`
+	Devices []string `json:"devices,omitempty"`
+	Detail  string   `json:"detail"`
+	Fixable bool     `json:"fixable"`
+	Fixed   bool     `json:"fixed,omitempty"`
+}
+
+// ConsistencyReport 是一次检查（及修复）的结果。
+type ConsistencyReport struct {
+	Time      time.Time       `json:"time"`
+	Releases  int             `json:"releases"`
+	Artifacts int             `json:"artifacts"` // 无法枚举制品的存储为 -1
+	Issues    []Inconsistency `json:"issues"`
+	Repaired  int             `json:"repaired"`
+}
+
+// artifactLister 是可以枚举与删除制品的存储，用于发现无记录的制品。
+type artifactLister interface {
+	// Versions lists stored artifact versions, plus leftover staging files
+	// older than the given age.
+	Versions(staleAge time.Duration) (versions, stale []string, err error)
+	Remove(version string) error
+	RemoveStale(name string) error
+}
+
+// CheckConsistency audits the store against the artifact store and device
+// events, repairing what can be fixed safely when repair is set.
+func (p *Platform) CheckConsistency(repair bool, actor string) (*ConsistencyReport, error) {
+	p.store.mu.RLock()
+	releases := make(map[string]*Release, len(p.store.ReleasesByVersion))
+	for v, rel := range p.store.ReleasesByVersion {
+		releases[v] = rel
+	}
+	latest := make(map[string]string, len(p.store.LatestByChannel))
+	for ch, v := range p.store.LatestByChannel {
+		latest[ch] = v
+	}
+	p.store.mu.RUnlock()
+
+	rep := &ConsistencyReport{Time: p.clock.Now(), Releases: len(releases), Artifacts: -1}
+	// 哈希制品不持有 store 锁，检查期间检查与下载照常服务
+	healthy := map[string]bool{}
+	// suspect 是校验和不符、修复时隔离的版本 -> 记录的 sha256
+	suspect := map[string]string{}
+	for _, v := range sortedKeys(releases) {
+		rel := releases[v]
+		sum, err := p.artifactSum(v)
+		switch {
+		case err == errArtifactNotFound:
+			rep.Issues = append(rep.Issues, Inconsistency{Kind: IssueMissingArtifact, Version: v, Channel: rel.Channel,
+				Detail: "release record has no artifact in " + p.artifacts.Describe()})
+		case err != nil:
+			return nil, fmt.Errorf("read artifact %s: %w", v, err)
+		case sum != rel.Sha256:
+			is := Inconsistency{Kind: IssueHashMismatch, Version: v, Channel: rel.Channel,
+				Detail: "artifact sha256 " + sum + " differs from the recorded " + rel.Sha256}
+			if rel.Quarantine == nil {
+				is.Detail += "; repair quarantines it"
+				is.Fixable = true
+				suspect[v] = rel.Sha256
+			}
+			rep.Issues = append(rep.Issues, is)
+		default:
+			healthy[v] = true
+		}
+	}
+
+	// 渠道 latest：记录缺失或制品损坏时改指同渠道最新的完好版本
+	repoint := map[string]latestFix{}
+	for _, ch := range sortedKeys(latest) {
+		v := latest[ch]
+		if healthy[v] {
+			continue
+		}
+		target := ""
+		for cand, rel := range releases {
+			if rel.latestKey() == ch && healthy[cand] && (target == "" || version.Newer(cand, target)) {
+				target = cand
+			}
+		}
+		detail := "latest " + v + " has no release record"
+		if releases[v] != nil {
+			detail = "latest " + v + " cannot be served (see " + v + ")"
+		}
+		fixable := target != "" || releases[v] == nil
+		if target != "" {
+			detail += "; repair points it at " + target
+		} else if releases[v] == nil {
+			detail += "; repair removes the channel pointer"
+		}
+		rep.Issues = append(rep.Issues, Inconsistency{Kind: IssueDanglingLatest, Version: v, Channel: ch, Detail: detail, Fixable: fixable})
+		if fixable {
+			repoint[ch] = latestFix{from: v, to: target}
+		}
+	}
+
+	lister, _ := p.artifacts.(artifactLister)
+	if lister != nil {
+		versions, stale, err := lister.Versions(staleUploadAge)
+		if err != nil {
+			return nil, fmt.Errorf("list artifacts: %w", err)
+		}
+		rep.Artifacts = len(versions)
+		for _, v := range versions {
+			if releases[v] == nil {
+				is := Inconsistency{Kind: IssueOrphanArtifact, Version: v,
+					Detail: "artifact has no release record; repair deletes it", Fixable: true}
+				if p.acceptReplication {
+					is.Detail, is.Fixable = "artifact has no release record yet; replicas keep it, the primary may still be replicating its metadata", false
+				}
+				rep.Issues = append(rep.Issues, is)
+			}
+		}
+		for _, name := range stale {
+			rep.Issues = append(rep.Issues, Inconsistency{Kind: IssueStaleUpload, Version: name,
+				Detail: "leftover upload from an interrupted publish; repair deletes it", Fixable: true})
+		}
+	}
+
+	unknown, err := p.deviceVersions(releases)
+	if err != nil {
+		return nil, fmt.Errorf("scan device events: %w", err)
+	}
+	for _, v := range sortedKeys(unknown) {
+		devs := unknown[v]
+		sort.Strings(devs)
+		detail := fmt.Sprintf("%d device(s) last reported running %s, which has no release record", len(devs), v)
+		if len(devs) > maxIssueDevices {
+			devs = devs[:maxIssueDevices]
+		}
+		rep.Issues = append(rep.Issues, Inconsistency{Kind: IssueUnknownVersion, Version: v, Devices: devs, Detail: detail})
+	}
+
+	if repair {
+		p.repairConsistency(rep, repoint, suspect, lister, actor)
+	}
+	return rep, nil
+}
+
+func (p *Platform) artifactSum(v string) (string, error) {
+	a, err := p.artifacts.Open(v)
+	if err != nil {
+		return "", err
+	}
+	defer a.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, a); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// deviceVersions returns, per version without a release record, the devices
+// whose latest reported version it is. The latest is the event with the
+// highest device sequence number, not the latest device time.
+func (p *Platform) deviceVersions(releases map[string]*Release) (map[string][]string, error) {
+	type seen struct {
+		version string
+		seq     uint64
+	}
+	last := map[string]seen{}
+	if _, err := p.events.Edit(func(ev *DeviceEvent) EditAction {
+		// 旧事件没有 DeviceSeq（为 0），按记录顺序后者为准
+		if ev.DeviceID != "" && ev.Version != "" && ev.DeviceSeq >= last[ev.DeviceID].seq {
+			last[ev.DeviceID] = seen{ev.Version, ev.DeviceSeq}
+		}
+		return EditKeep
+	}); err != nil {
+		return nil, err
+	}
+	out := map[string][]string{}
+	for dev, s := range last {
+		if releases[s.version] == nil {
+			out[s.version] = append(out[s.version], dev)
+		}
+	}
+	return out, nil
+}
+
+// latestFix 把渠道 latest 从 from 改为 to（to 为空表示删除该渠道指针）。
+type latestFix struct{ from, to string }
+
+func (p *Platform) repairConsistency(rep *ConsistencyReport, repoint map[string]latestFix, suspect map[string]string, lister artifactLister, actor string) {
+	// 持有 store 锁修复：发布在锁内提交制品并切换记录，此时看到的无记录制品不会属于进行中的发布
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	applied := map[string]bool{}
+	if len(repoint) > 0 {
+		next := p.store.cloneState()
+		for ch, fix := range repoint {
+			// 检查期间可能有新的发布，只修复仍然指向原版本的渠道
+			if next.LatestByChannel[ch] != fix.from {
+				continue
+			}
+			if fix.to == "" {
+				delete(next.LatestByChannel, ch)
+			} else {
+				next.LatestByChannel[ch] = fix.to
+			}
+			applied[ch] = true
+		}
+		err := p.saveStore(next)
+		if err == nil {
+			p.store.LatestByChannel = next.LatestByChannel
+			p.recordLatest(ChangeRepair, actor)
+			p.refreshTUF(p.store)
+		}
+		if err != nil {
+			log.Printf("consistency repair: save store: %v", err)
+			applied = nil
+		}
+	}
+	for i := range rep.Issues {
+		is := &rep.Issues[i]
+		if !is.Fixable {
+			continue
+		}
+		var err error
+		switch is.Kind {
+		case IssueDanglingLatest:
+			if !applied[is.Channel] {
+				continue
+			}
+		case IssueHashMismatch:
+			err = p.quarantineMismatch(is.Version, suspect[is.Version], Quarantine{Trigger: QuarantineConsistency,
+				Detail: strings.TrimSuffix(is.Detail, "; repair quarantines it"), Evidence: []string{"/api/v1/doctor"}}, actor)
+		case IssueOrphanArtifact:
+			if p.store.ReleasesByVersion[is.Version] != nil {
+				continue
+			}
+			err = lister.Remove(is.Version)
+		case IssueStaleUpload:
+			err = lister.RemoveStale(is.Version)
+		}
+		if err != nil {
+			log.Printf("consistency repair %s %s: %v", is.Kind, is.Version, err)
+			continue
+		}
+		is.Fixed = true
+		rep.Repaired++
+	}
+	if rep.Repaired > 0 {
+		fixed := []string{}
+		for _, is := range rep.Issues {
+			if is.Fixed {
+				fixed = append(fixed, is.Kind+":"+is.subject())
+			}
+		}
+		_ = p.audit(actor, "consistency_repair", "", map[string]any{"fixed": fixed})
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// subject is the channel for channel pointer issues, else the version.
+func (is *Inconsistency) subject() string {
+	if is.Kind == IssueDanglingLatest {
+		return is.Channel
+	}
+	return is.Version
+}
+
+// String renders the report for the -doctor command line.
+func (r *ConsistencyReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d releases", r.Releases)
+	if r.Artifacts >= 0 {
+		fmt.Fprintf(&b, ", %d artifacts", r.Artifacts)
+	}
+	fmt.Fprintf(&b, ", %d issues", len(r.Issues))
+	if r.Repaired > 0 {
+		fmt.Fprintf(&b, ", %d repaired", r.Repaired)
+	}
+	b.WriteString("\n")
+	for _, is := range r.Issues {
+		state := "manual"
+		switch {
+		case is.Fixed:
+			state = "fixed"
+		case is.Fixable:
+			state = "fixable"
+		}
+		fmt.Fprintf(&b, "[%-7s] %-22s %-12s %s\n", state, is.Kind, is.subject(), is.Detail)
+	}
+	return b.String()
+}
+
+type DoctorController struct {
+	BaseController
+	p *Platform
+}
+
+func NewDoctorController(p *Platform) *DoctorController {
+	return &DoctorController{p: p}
+}
+
+// Check godoc
+// @Summary      Check platform consistency
+// @Description  Audit release records against the artifact store and device events: missing artifacts, sha256 mismatches, artifacts without a record, leftover uploads, channels whose latest version is missing or unservable, and devices running versions without a record.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  controller.ConsistencyReport
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/doctor [get]
+func (c *DoctorController) Check(g *gin.Context) {
+	rep, err := c.p.CheckConsistency(false, "")
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, rep)
+}
+
+// Repair godoc
+// @Summary      Repair platform inconsistencies
+// @Description  Run the consistency check and fix what is safe to fix: repoint channels at their newest intact release, move releases whose artifact fails its sha256 to the quarantined channel, delete artifacts without a record and leftover uploads. Missing artifacts and devices on unknown versions are only reported. The repair is recorded in the audit log.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  controller.ConsistencyReport
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Failure      503  {object}  map[string]any  "server is read-only"
+// @Security     BearerAuth
+// @Router       /api/v1/doctor/repair [post]
+func (c *DoctorController) Repair(g *gin.Context) {
+	if c.p.stillReadOnly() {
+		c.ResponseFailure(g, ErrReadOnly, "server is read-only after running out of disk space")
+		return
+	}
+	rep, err := c.p.CheckConsistency(true, c.p.principal(g).Name)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, rep)
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxTelemetryBody 限制遥测上传压缩前后的大小，防止解压炸弹。
+	maxTelemetryBody = 8 << 20
+)
+
+// bodyDecoders 是遥测上传支持的 Content-Encoding。
+var bodyDecoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip": func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+}
+
+// limitedBody fails reads past the limit instead of silently truncating, so
+// an oversized upload surfaces as a JSON decode error.
+type limitedBody struct {
+	r io.ReadCloser
+	n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, errors.New("request body too large")
+	}
+	if int64(len(p)) > b.n {
+		p = p[:b.n]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error { return b.r.Close() }
+
+// DecodeBody transparently decompresses request bodies sent with
+// Content-Encoding (batched agent telemetry) and bounds their size.
+func DecodeBody(g *gin.Context) {
+	g.Request.Body = http.MaxBytesReader(g.Writer, g.Request.Body, maxTelemetryBody)
+	enc := strings.ToLower(strings.TrimSpace(g.GetHeader("Content-Encoding")))
+	if enc == "" || enc == "identity" {
+		return
+	}
+	dec, ok := bodyDecoders[enc]
+	if !ok {
+		BaseController{}.ResponseFailure(g, ErrEncoding, "unsupported Content-Encoding "+enc+" (supported: gzip)")
+		return
+	}
+	r, err := dec(g.Request.Body)
+	if err != nil {
+		BaseController{}.ResponseFailure(g, ErrParam, "invalid "+enc+" body: "+err.Error())
+		return
+	}
+	g.Request.Body = &limitedBody{r: r, n: maxTelemetryBody}
+	g.Request.Header.Del("Content-Encoding")
+	g.Request.ContentLength = -1
+}
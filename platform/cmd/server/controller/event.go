@@ -0,0 +1,434 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxIngestBatch 限制一次上传的事件数。
+	maxIngestBatch = 500
+	// dedupMaxKeys 是内存中最多记住的去重键数量，超过时最早的先淘汰。
+	dedupMaxKeys = 100000
+	// dedupMaxPerDevice 是单个设备最多占用的去重键数量，一台设备刷键不会把其它设备的键挤出去。
+	dedupMaxPerDevice = 1000
+	// defaultDedupWindow 是缺省的去重窗口，覆盖设备离线重传的时间。
+	defaultDedupWindow = 72 * time.Hour
+)
+
+// ingestTypes 是设备可以上报的事件类型；check 由服务端在检查时自行记录，
+// register 是 agent 首次使用自行派生的设备 ID 时的登记，diagnostics 是服务端要求时上报的自检结果，
+// shadow 是影子部署中现役与影子进程的对比指标。
+var ingestTypes = map[string]bool{"report": true, "heartbeat": true, "crash": true, "register": true, "diagnostics": true, "shadow": true}
+
+// eventDedup 记住去重窗口内见过的 (设备, 去重键) 及首次见到的时间，按时间先后淘汰，
+// 最多 dedupMaxKeys 个，每台设备最多 dedupMaxPerDevice 个（超出后该设备的新键不再记住，事件照常记录）。
+// 心跳不去重：重复的心跳无害，为它们记键只会占满配额。键在事件写入日志后才算见过，写入失败的事件
+// 重传时仍会记录。键只在内存中，启动时从事件日志中窗口内带 key 的事件恢复（见 seedEventKeys），
+// 服务重启后的重传同样只记录一次。
+type eventDedup struct {
+	mu       sync.Mutex
+	window   time.Duration
+	seen     map[dedupEntry]time.Time
+	order    []dedupEntry
+	byDevice map[string]int
+}
+
+// dedupEntry 是一台设备的一个去重键。
+type dedupEntry struct{ device, key string }
+
+func newEventDedup(window time.Duration) *eventDedup {
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+	return &eventDedup{window: window, seen: map[dedupEntry]time.Time{}, byDevice: map[string]int{}}
+}
+
+// deduped reports whether the events of type typ are deduplicated by key.
+func deduped(typ, key string) bool {
+	return key != "" && typ != "heartbeat"
+}
+
+// add reserves the key of device and reports false when it was already seen
+// within the window. A reserved key whose event is not recorded must be
+// released with forget.
+func (d *eventDedup) add(device, key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.order) > 0 && (len(d.order) >= dedupMaxKeys || now.Sub(d.seen[d.order[0]]) > d.window) {
+		d.evict(d.order[0])
+		d.order = d.order[1:]
+	}
+	e := dedupEntry{device, key}
+	if at, ok := d.seen[e]; ok && now.Sub(at) <= d.window {
+		return false
+	}
+	if d.byDevice[device] >= dedupMaxPerDevice {
+		return true
+	}
+	d.seen[e] = now
+	d.order = append(d.order, e)
+	d.byDevice[device]++
+	return true
+}
+
+// forget releases a key reserved by add, so a retransmission is recorded.
+func (d *eventDedup) forget(device, key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := dedupEntry{device, key}
+	if _, ok := d.seen[e]; !ok {
+		return
+	}
+	d.evict(e)
+	for i := len(d.order) - 1; i >= 0; i-- {
+		if d.order[i] == e {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (d *eventDedup) evict(e dedupEntry) {
+	if _, ok := d.seen[e]; !ok {
+		return
+	}
+	delete(d.seen, e)
+	if d.byDevice[e.device]--; d.byDevice[e.device] <= 0 {
+		delete(d.byDevice, e.device)
+	}
+}
+
+// seedEventKeys remembers the keys of events recorded within the dedup
+// window, so retransmissions after a restart are still recognized.
+func (p *Platform) seedEventKeys() error {
+	now := p.clock.Now()
+	evs, err := p.events.Query(EventQuery{Since: now.Add(-p.eventKeys.window), Keyed: true, Limit: dedupMaxKeys})
+	if err != nil {
+		return err
+	}
+	n := 0
+	// Query 由新到旧返回，按记录先后加入以保持淘汰顺序
+	for i := len(evs) - 1; i >= 0; i-- {
+		if ev := evs[i]; deduped(ev.Type, ev.Key) && p.eventKeys.add(ev.DeviceID, ev.Key, ev.recorded()) {
+			n++
+		}
+	}
+	if n > 0 {
+		log.Printf("restored %d event dedup keys from the last %s", n, p.eventKeys.window)
+	}
+	return nil
+}
+
+type EventController struct {
+	BaseController
+	p *Platform
+}
+
+func NewEventController(p *Platform) *EventController {
+	return &EventController{p: p}
+}
+
+// Timeline godoc
+// @Summary      Device event timeline
+// @Description  List a device's events (checks, reports, heartbeats, crashes, ID conflicts), newest first. Events are ordered by server-assigned sequence numbers: seq across the fleet and device_seq (1, 2, 3, ...) within the device; received is when the server recorded the event, and since/until filter on it. time is the device-reported time, informational only since device clocks may be wrong.
+// @Tags         device
+// @Produce      json
+// @Param        id      path   string  true   "Device ID"
+// @Param        type    query  string  false  "Event type (check|report|heartbeat|crash|register|diagnostics|shadow|conflict)"
+// @Param        since   query  string  false  "RFC3339 lower bound of the server record time"
+// @Param        until   query  string  false  "RFC3339 upper bound of the server record time"
+// @Param        limit   query  int     false  "Page size, default 100, at most 1000"
+// @Param        sort    query  string  false  "seq (recording order), - for descending (default -seq, newest first)"
+// @Param        cursor  query  string  false  "next_cursor of the previous page"
+// @Param        total   query  bool    false  "Include the number of matching events (scans the whole log)"
+// @Success      200  {object}  map[string]any  "device_id, events, next_cursor, total"
+// @Failure      400  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/devices/{id}/events [get]
+func (c *EventController) Timeline(g *gin.Context) {
+	q := EventQuery{
+		DeviceID: g.Param("id"),
+		Type:     g.Query("type"),
+	}
+	if !c.timeRange(g, &q) {
+		return
+	}
+	pp, ok := parsePage(c.BaseController, g, eventList)
+	if !ok {
+		return
+	}
+	list, next, total, code, err := c.p.queryEvents(q, pp)
+	if err != nil {
+		c.ResponseFailure(g, code, err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, setPage(gin.H{
+		"device_id": q.DeviceID,
+		"events":    list,
+	}, pp, next, total))
+}
+
+// Crashes godoc
+// @Summary      Algorithm crashes
+// @Description  List the algorithm crashes reported by agents across the fleet, newest first (by server-assigned seq; since/until filter on the server record time). Crashes of other apps on multi-app devices carry the app and its version in data.
+// @Tags         device
+// @Produce      json
+// @Param        device_id  query  string  false  "Only this device"
+// @Param        version    query  string  false  "Only devices running this version"
+// @Param        channel    query  string  false  "Only this channel"
+// @Param        since      query  string  false  "RFC3339 lower bound of the server record time"
+// @Param        until      query  string  false  "RFC3339 upper bound of the server record time"
+// @Param        limit      query  int     false  "Page size, default 100, at most 1000"
+// @Param        sort       query  string  false  "seq (recording order), - for descending (default -seq, newest first)"
+// @Param        cursor     query  string  false  "next_cursor of the previous page"
+// @Param        total      query  bool    false  "Include the number of matching crashes (scans the whole log)"
+// @Success      200  {object}  map[string]any  "crashes, next_cursor, total"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/crashes [get]
+func (c *EventController) Crashes(g *gin.Context) {
+	q := EventQuery{
+		DeviceID: g.Query("device_id"),
+		Type:     "crash",
+		Version:  g.Query("version"),
+		Channel:  g.Query("channel"),
+	}
+	if !c.timeRange(g, &q) {
+		return
+	}
+	pp, ok := parsePage(c.BaseController, g, eventList)
+	if !ok {
+		return
+	}
+	list, next, total, code, err := c.p.queryEvents(q, pp)
+	if err != nil {
+		c.ResponseFailure(g, code, err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, setPage(gin.H{"crashes": list}, pp, next, total))
+}
+
+// timeRange reads the since and until bounds into q.
+func (c *EventController) timeRange(g *gin.Context, q *EventQuery) bool {
+	for key, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := g.Query(key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.ResponseFailure(g, ErrParam, "invalid "+key+": "+err.Error())
+				return false
+			}
+			*dst = t
+		}
+	}
+	return true
+}
+
+// eventList 是事件列表的分页方式：按记录顺序（seq，唯一），缺省最新的在前。
+var eventList = listSpec[*DeviceEvent]{
+	id: func(ev *DeviceEvent) string { return seqKey(ev.Seq) },
+	sorts: map[string]sortField[*DeviceEvent]{
+		"seq": {key: func(ev *DeviceEvent) string { return seqKey(ev.Seq) }},
+	},
+	defaultSort: "-seq",
+}
+
+// queryEvents returns a page of the events matching q. Newest-first pages
+// without a total read only as far back as needed; the others scan the
+// whole log.
+func (p *Platform) queryEvents(q EventQuery, pp pageParams) ([]*DeviceEvent, string, int, ErrCode, error) {
+	if pp.Total || !pp.Desc {
+		all, err := p.events.Query(q)
+		if err != nil {
+			return nil, "", 0, ErrInternal, err
+		}
+		page, next, total := paginate(all, eventList, pp)
+		return page, next, total, OK, nil
+	}
+	if pp.After != nil {
+		n, err := strconv.ParseUint(pp.After.ID, 10, 64)
+		if err != nil {
+			return nil, "", 0, ErrParam, errors.New("invalid cursor")
+		}
+		q.BeforeSeq = n
+	}
+	// 多取一条以判断是否还有下一页
+	q.Limit = pp.Limit + 1
+	list, err := p.events.Query(q)
+	if err != nil {
+		return nil, "", 0, ErrInternal, err
+	}
+	next := ""
+	if len(list) > pp.Limit {
+		list = list[:pp.Limit]
+		next = encodeCursor(pageCursor{Sort: pp.sortName(), Key: seqKey(list[pp.Limit-1].Seq), ID: seqKey(list[pp.Limit-1].Seq)})
+	}
+	if list == nil {
+		list = []*DeviceEvent{}
+	}
+	return list, next, len(list), OK, nil
+}
+
+// reportStatuses 是安装报告的结果：agent 的更新尝试（success | failure | cancelled）、推迟与回滚。
+var reportStatuses = map[string]bool{"success": true, "failure": true, "cancelled": true, "deferred": true, "rolled_back": true, "rollback_failed": true}
+
+// installReport 是 POST /api/v1/report 的请求体，对应设备时间线中的一条 report 事件。
+type installReport struct {
+	DeviceID   string    `json:"device_id"`
+	Channel    string    `json:"channel"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error"`
+	DurationMS int64     `json:"duration_ms"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Key 是可选的去重键，重试同一份报告时不会重复记录。
+	Key string `json:"key"`
+}
+
+// Report godoc
+// @Summary      Report an install result
+// @Description  Record the outcome of one update attempt as a report event in the device's timeline, for updaters other than the agent (which batches its reports through the device events endpoint). Reports carrying a key (or Idempotency-Key header) already seen within the dedup window (-dedup-window, default 72h) are acknowledged but not recorded again.
+// @Tags         device
+// @Accept       json
+// @Produce      json
+// @Param        Idempotency-Key  header  string  false  "Deduplication key, used when the body has no key"
+// @Param        body  body  object  true  "{\"device_id\", \"channel\", \"from\", \"to\", \"status\" (success|failure|cancelled|deferred|rolled_back|rollback_failed), \"error\", \"duration_ms\", \"started_at\", \"finished_at\", \"key\"}"
+// @Success      200  {object}  map[string]any  "recorded"
+// @Failure      400  {object}  map[string]any
+// @Failure      500  {object}  map[string]any  "the event could not be recorded; send it again"
+// @Security     BearerAuth
+// @Router       /api/v1/report [post]
+func (c *EventController) Report(g *gin.Context) {
+	var r installReport
+	if err := g.ShouldBindJSON(&r); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	var ok bool
+	if r.DeviceID, ok = c.p.bindDevice(g, r.DeviceID); !ok {
+		return
+	}
+	if r.Key == "" {
+		r.Key = g.GetHeader("Idempotency-Key")
+	}
+	if r.DeviceID == "" || r.To == "" {
+		c.ResponseFailure(g, ErrParam, "device_id and to are required")
+		return
+	}
+	if !reportStatuses[r.Status] {
+		c.ResponseFailure(g, ErrParam, "unsupported status "+strconv.Quote(r.Status))
+		return
+	}
+	device := c.p.resolveDevice(r.DeviceID, g.GetHeader(instanceHeader))
+	if r.Key != "" && !c.p.eventKeys.add(device, r.Key, c.p.clock.Now()) {
+		g.JSON(http.StatusOK, gin.H{"recorded": false})
+		return
+	}
+	data := map[string]any{"status": r.Status, "from": r.From, "to": r.To, "error": r.Error}
+	if r.DurationMS > 0 {
+		data["duration_ms"] = r.DurationMS
+	}
+	if !r.StartedAt.IsZero() {
+		data["started_at"] = r.StartedAt
+	}
+	ev := &DeviceEvent{DeviceID: device, Type: "report", Channel: r.Channel, Version: r.From, Time: r.FinishedAt, Data: data, Key: r.Key}
+	// 设备时钟可能不准：缺省或来自未来的时间以服务端接收时间为准
+	if now := c.p.clock.Now(); ev.Time.IsZero() || ev.Time.After(now) {
+		ev.Time = now
+	}
+	if err := c.p.recordEvent(ev); err != nil {
+		if r.Key != "" {
+			c.p.eventKeys.forget(device, r.Key)
+		}
+		c.ResponseFailure(g, c.p.fsErrCode(err, "record event"), fsErr(err, "record event").Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"recorded": true})
+}
+
+// Ingest godoc
+// @Summary      Upload device events
+// @Description  Accept a batch of events (install reports, heartbeats, crashes, registrations, diagnostics, shadow comparison metrics) from a device, typically flushed from the agent's offline queue. Events carrying a key already seen within the dedup window (-dedup-window, default 72h) are acknowledged but not recorded again; with an Idempotency-Key header, events without a key get <header>/<index>. The body may be sent with Content-Encoding: gzip (at most 8 MB either way).
+// @Tags         device
+// @Accept       json
+// @Param        Content-Encoding  header  string  false  "gzip"
+// @Param        Idempotency-Key   header  string  false  "Batch key; events without a key are deduplicated as <key>/<index>"
+// @Produce      json
+// @Param        id    path  string  true  "Device ID"
+// @Param        body  body  object  true  "{\"events\": [{\"key\", \"type\", \"time\", \"channel\", \"version\", \"data\"}]}"
+// @Success      200  {object}  map[string]any  "accepted, duplicates"
+// @Failure      400  {object}  map[string]any
+// @Failure      415  {object}  map[string]any  "unsupported Content-Encoding"
+// @Failure      500  {object}  map[string]any  "an event could not be recorded; send the batch again"
+// @Security     BearerAuth
+// @Router       /api/v1/devices/{id}/events [post]
+func (c *EventController) Ingest(g *gin.Context) {
+	var body struct {
+		Events []*DeviceEvent `json:"events"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	if len(body.Events) > maxIngestBatch {
+		c.ResponseFailure(g, ErrParam, "at most "+strconv.Itoa(maxIngestBatch)+" events per upload")
+		return
+	}
+	id, ok := c.p.bindDevice(g, g.Param("id"))
+	if !ok {
+		return
+	}
+	instance := g.GetHeader(instanceHeader)
+	device := c.p.resolveDevice(id, instance)
+	now := c.p.clock.Now()
+	for _, ev := range body.Events {
+		if !ingestTypes[ev.Type] {
+			c.ResponseFailure(g, ErrParam, "unsupported event type "+strconv.Quote(ev.Type))
+			return
+		}
+	}
+	accepted, dups := 0, 0
+	batch := g.GetHeader("Idempotency-Key")
+	for i, ev := range body.Events {
+		if ev.Key == "" && batch != "" {
+			ev.Key = batch + "/" + strconv.Itoa(i)
+		}
+		keyed := deduped(ev.Type, ev.Key)
+		if keyed && !c.p.eventKeys.add(device, ev.Key, now) {
+			dups++
+			continue
+		}
+		ev.Seq, ev.DeviceID = 0, device
+		if instance != "" {
+			if ev.Data == nil {
+				ev.Data = map[string]any{}
+			}
+			ev.Data["instance"] = instance
+		}
+		// 设备时钟可能不准：缺省或来自未来的时间以服务端接收时间为准
+		if ev.Time.IsZero() || ev.Time.After(now) {
+			ev.Time = now
+		}
+		if err := c.p.recordEvent(ev); err != nil {
+			// 未记录的事件不算见过，agent 稍后重传整批时仍会记录
+			if keyed {
+				c.p.eventKeys.forget(device, ev.Key)
+			}
+			c.ResponseFailure(g, c.p.fsErrCode(err, "record event"), fsErr(err, "record event").Error())
+			return
+		}
+		accepted++
+	}
+	g.JSON(http.StatusOK, gin.H{"accepted": accepted, "duplicates": dups})
+}
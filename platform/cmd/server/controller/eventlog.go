@@ -0,0 +1,573 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxSegmentBytes 超过后封存当前段并新开一段。
+	maxSegmentBytes = 8 << 20
+	// defaultEventRetention 是默认的事件保留时长，超过的已封存段在压缩时删除。
+	defaultEventRetention = 30 * 24 * time.Hour
+	// maxEventLine 是段内一行（一条事件）的上限：遥测请求体最大 maxTelemetryBody，序列化后再留出余量，
+	// 读段时不会因为一条大事件而提前停止。
+	maxEventLine = maxTelemetryBody + 1<<20
+)
+
+// DeviceEvent 是设备侧发生的一次事件（检查、上报、心跳……），只追加，不修改。
+// 设备时钟不可靠（没有 RTC、未同步、离线重传），事件的先后与设备的最新状态以服务端分配的序号为准：
+// Seq 是全局记录顺序，DeviceSeq 是同一设备内从 1 起单调递增的序号；时间窗口（查询、保留、去重）
+// 按服务端记录时间 Received 计算。Time 是设备报告的时间，只作展示。
+type DeviceEvent struct {
+	Seq       uint64         `json:"seq"`
+	DeviceSeq uint64         `json:"device_seq,omitempty"`
+	Received  time.Time      `json:"received,omitempty"`
+	Time      time.Time      `json:"time"`
+	DeviceID  string         `json:"device_id"`
+	Type      string         `json:"type"` // check | report | heartbeat | crash | register | diagnostics | shadow | conflict
+	Channel   string         `json:"channel,omitempty"`
+	Version   string         `json:"version,omitempty"` // 设备当前版本
+	Data      map[string]any `json:"data,omitempty"`
+	// Key 是设备生成的去重键：离线队列重传的同一事件只记录一次。
+	Key string `json:"key,omitempty"`
+}
+
+// recorded is when the server recorded ev; events written before Received
+// existed fall back to their device time.
+func (ev *DeviceEvent) recorded() time.Time {
+	if ev.Received.IsZero() {
+		return ev.Time
+	}
+	return ev.Received
+}
+
+// deviceSeqs 是每台设备已分配的最大 DeviceSeq。
+type deviceSeqs map[string]uint64
+
+// next assigns ev the next sequence number of its device.
+func (d deviceSeqs) next(ev *DeviceEvent) {
+	d[ev.DeviceID]++
+	ev.DeviceSeq = d[ev.DeviceID]
+}
+
+// observe raises the device's counter to ev's sequence number; events moved
+// to another device by Edit keep their numbers, so the counter never goes
+// back.
+func (d deviceSeqs) observe(ev *DeviceEvent) {
+	if ev.DeviceSeq > d[ev.DeviceID] {
+		d[ev.DeviceID] = ev.DeviceSeq
+	}
+}
+
+// EventLog 是设备事件的只追加存储。
+type EventLog interface {
+	// Append assigns the next global and per-device sequence numbers and
+	// stores ev.
+	Append(ev *DeviceEvent) error
+	// Query returns matching events, newest first, at most q.Limit of them.
+	Query(q EventQuery) ([]*DeviceEvent, error)
+	// Scan passes matching events to fn, newest first, until fn returns
+	// false or q.Limit events were passed, without collecting them.
+	Scan(q EventQuery, fn func(ev *DeviceEvent) bool) error
+	// Compact drops events older than now minus the retention window,
+	// returning how many segments/entries were removed.
+	Compact(now time.Time) (int, error)
+	// Edit passes every stored event to fn and rewrites the log according to
+	// its answers, returning how many events were changed or removed.
+	Edit(fn func(ev *DeviceEvent) EditAction) (int, error)
+	// Sync flushes buffered events to stable storage.
+	Sync() error
+}
+
+// segmentLog 是按段切分的 JSONL 追加日志：<dataDir>/events/segment-000001.jsonl ……
+// 设备事件与版本元数据分离，心跳等高频写入不会重写 releases.json。
+type segmentLog struct {
+	mu        sync.Mutex
+	dir       string
+	retention time.Duration
+	syncEach  bool
+	fsync     bool
+	seq       uint64
+	devSeq    deviceSeqs
+	segment   int
+	f         *os.File
+	size      int64
+}
+
+// OpenSegmentLog opens (or creates) the segmented log in dir. syncEach
+// fsyncs after every append; otherwise Sync is left to the periodic flusher.
+func OpenSegmentLog(dir string, retention time.Duration, syncEach, fsync bool) (EventLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &segmentLog{dir: dir, retention: retention, syncEach: syncEach, fsync: fsync, devSeq: deviceSeqs{}}
+	segs, err := l.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segs) == 0 {
+		l.segment = 1
+	} else {
+		l.segment = segs[len(segs)-1]
+		// 从各段恢复全局与每台设备的序号
+		for _, n := range segs {
+			if err := l.scanSegment(n, func(ev *DeviceEvent) bool {
+				l.seq = max(l.seq, ev.Seq)
+				l.devSeq.observe(ev)
+				return true
+			}); err != nil {
+				log.Printf("event log: scan segment %d: %v", n, err)
+			}
+		}
+	}
+	if err := l.openSegment(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *segmentLog) segmentPath(n int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("segment-%06d.jsonl", n))
+}
+
+func (l *segmentLog) segments() ([]int, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []int
+	for _, e := range entries {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "segment-%06d.jsonl", &n); err == nil {
+			segs = append(segs, n)
+		}
+	}
+	sort.Ints(segs)
+	return segs, nil
+}
+
+func (l *segmentLog) openSegment() error {
+	f, err := os.OpenFile(l.segmentPath(l.segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+func (l *segmentLog) Append(ev *DeviceEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.size >= maxSegmentBytes {
+		_ = l.f.Close()
+		l.segment++
+		if err := l.openSegment(); err != nil {
+			return err
+		}
+	}
+	l.seq++
+	ev.Seq = l.seq
+	l.devSeq.next(ev)
+	if ev.Received.IsZero() {
+		ev.Received = time.Now()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = ev.Received
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if l.syncEach {
+		return l.f.Sync()
+	}
+	return nil
+}
+
+func (l *segmentLog) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.fsync {
+		return nil
+	}
+	return l.f.Sync()
+}
+
+func (l *segmentLog) scanSegment(n int, fn func(*DeviceEvent) bool) error {
+	f, err := os.Open(l.segmentPath(n))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), maxEventLine)
+	for sc.Scan() {
+		var ev DeviceEvent
+		// 崩溃可能留下半行，跳过即可
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			continue
+		}
+		if !fn(&ev) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// EventQuery 描述时间线查询条件，零值字段不参与过滤。
+type EventQuery struct {
+	DeviceID string
+	Type     string
+	Version  string
+	Channel  string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	// BeforeSeq 非零时只返回更早记录的事件（分页游标）。
+	BeforeSeq uint64
+	// Keyed 为真时只返回带去重键的事件。
+	Keyed bool
+}
+
+func (q EventQuery) match(ev *DeviceEvent) bool {
+	if q.DeviceID != "" && ev.DeviceID != q.DeviceID {
+		return false
+	}
+	if q.Type != "" && ev.Type != q.Type {
+		return false
+	}
+	if q.Version != "" && ev.Version != q.Version {
+		return false
+	}
+	if q.Channel != "" && ev.Channel != q.Channel {
+		return false
+	}
+	if q.Keyed && ev.Key == "" {
+		return false
+	}
+	if q.BeforeSeq != 0 && ev.Seq >= q.BeforeSeq {
+		return false
+	}
+	if !q.Since.IsZero() && ev.recorded().Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && ev.recorded().After(q.Until) {
+		return false
+	}
+	return true
+}
+
+func (l *segmentLog) Query(q EventQuery) ([]*DeviceEvent, error) {
+	var out []*DeviceEvent
+	err := l.Scan(q, func(ev *DeviceEvent) bool {
+		out = append(out, ev)
+		return true
+	})
+	return out, err
+}
+
+// Scan holds at most one segment's matching events in memory at a time.
+func (l *segmentLog) Scan(q EventQuery, fn func(ev *DeviceEvent) bool) error {
+	l.mu.Lock()
+	segs, err := l.segments()
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	n := 0
+	for i := len(segs) - 1; i >= 0; i-- {
+		var batch []*DeviceEvent
+		var newest time.Time
+		if err := l.scanSegment(segs[i], func(ev *DeviceEvent) bool {
+			newest = ev.recorded()
+			if q.match(ev) {
+				batch = append(batch, ev)
+			}
+			return true
+		}); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for j := len(batch) - 1; j >= 0; j-- {
+			n++
+			if !fn(batch[j]) || (q.Limit > 0 && n >= q.Limit) {
+				return nil
+			}
+		}
+		// 段内事件按记录时间追加，更早的段不会再有 Since 之后的事件
+		if !q.Since.IsZero() && !newest.IsZero() && newest.Before(q.Since) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Compact deletes sealed segments whose newest event is past retention.
+func (l *segmentLog) Compact(now time.Time) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	segs, err := l.segments()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := now.Add(-l.retention)
+	removed := 0
+	for _, n := range segs {
+		if n == l.segment {
+			break
+		}
+		var newest time.Time
+		if err := l.scanSegment(n, func(ev *DeviceEvent) bool {
+			newest = ev.recorded()
+			return true
+		}); err != nil {
+			return removed, fmt.Errorf("scan segment %d: %w", n, err)
+		}
+		if newest.After(cutoff) {
+			// 段内事件按记录时间追加，后面的段只会更新
+			break
+		}
+		if err := os.Remove(l.segmentPath(n)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// EditAction 是 Edit 回调对一条事件的处理结果。
+type EditAction int
+
+const (
+	EditKeep    EditAction = iota // 保持不变
+	EditChanged                   // 回调已就地修改，需要写回
+	EditDrop                      // 删除该事件
+)
+
+// Edit rewrites the segments in which fn changed or dropped events.
+// Segments are replaced via a temporary file, so a crash leaves either the
+// old or the new contents.
+func (l *segmentLog) Edit(fn func(ev *DeviceEvent) EditAction) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	segs, err := l.segments()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, n := range segs {
+		var evs []*DeviceEvent
+		edited := 0
+		// 读不完整的段不能重写，否则读到的位置之后的事件会被删掉
+		if err := l.scanSegment(n, func(ev *DeviceEvent) bool {
+			switch fn(ev) {
+			case EditChanged:
+				edited++
+				l.devSeq.observe(ev)
+			case EditDrop:
+				edited++
+				return true
+			}
+			evs = append(evs, ev)
+			return true
+		}); err != nil {
+			return total, fmt.Errorf("scan segment %d: %w", n, err)
+		}
+		if edited == 0 {
+			continue
+		}
+		if err := l.rewriteSegment(n, evs); err != nil {
+			return total, err
+		}
+		total += edited
+	}
+	return total, nil
+}
+
+func (l *segmentLog) rewriteSegment(n int, evs []*DeviceEvent) error {
+	path := l.segmentPath(n)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, ev := range evs {
+		b, _ := json.Marshal(ev)
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			f.Close()
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if l.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if n == l.segment {
+		// 正在写入的段：关闭后替换并重新打开
+		_ = l.f.Close()
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		if err := syncDir(l.dir, l.fsync); err != nil {
+			return err
+		}
+		return l.openSegment()
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(l.dir, l.fsync)
+}
+
+// memoryEventLog 是内存实现，用于 -store memory。
+type memoryEventLog struct {
+	mu        sync.Mutex
+	retention time.Duration
+	seq       uint64
+	devSeq    deviceSeqs
+	list      []*DeviceEvent
+}
+
+// NewMemoryEventLog returns an event log that never touches disk.
+func NewMemoryEventLog(retention time.Duration) EventLog {
+	return &memoryEventLog{retention: retention, devSeq: deviceSeqs{}}
+}
+
+func (m *memoryEventLog) Append(ev *DeviceEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	ev.Seq = m.seq
+	m.devSeq.next(ev)
+	if ev.Received.IsZero() {
+		ev.Received = time.Now()
+	}
+	if ev.Time.IsZero() {
+		ev.Time = ev.Received
+	}
+	cp := *ev
+	m.list = append(m.list, &cp)
+	return nil
+}
+
+func (m *memoryEventLog) Query(q EventQuery) ([]*DeviceEvent, error) {
+	var out []*DeviceEvent
+	err := m.Scan(q, func(ev *DeviceEvent) bool {
+		out = append(out, ev)
+		return true
+	})
+	return out, err
+}
+
+func (m *memoryEventLog) Scan(q EventQuery, fn func(ev *DeviceEvent) bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for i := len(m.list) - 1; i >= 0; i-- {
+		if q.match(m.list[i]) {
+			cp := *m.list[i]
+			n++
+			if !fn(&cp) || (q.Limit > 0 && n >= q.Limit) {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryEventLog) Compact(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff := now.Add(-m.retention)
+	i := 0
+	for i < len(m.list) && m.list[i].recorded().Before(cutoff) {
+		i++
+	}
+	m.list = append([]*DeviceEvent(nil), m.list[i:]...)
+	return i, nil
+}
+
+func (m *memoryEventLog) Edit(fn func(ev *DeviceEvent) EditAction) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	kept := m.list[:0]
+	for _, ev := range m.list {
+		switch fn(ev) {
+		case EditChanged:
+			n++
+			m.devSeq.observe(ev)
+		case EditDrop:
+			n++
+			continue
+		}
+		kept = append(kept, ev)
+	}
+	m.list = kept
+	return n, nil
+}
+
+func (m *memoryEventLog) Sync() error { return nil }
+
+// recordEvent appends to the device event log. Failures are logged and
+// returned; events the server records on its own are best-effort history and
+// ignore them, while uploads report them so the device sends the events again.
+func (p *Platform) recordEvent(ev *DeviceEvent) error {
+	if ev.DeviceID == "" {
+		return nil
+	}
+	ev.Received = p.clock.Now()
+	if ev.Time.IsZero() {
+		ev.Time = ev.Received
+	}
+	p.telemetry.sanitize(ev)
+	if err := p.events.Append(ev); err != nil {
+		if isNoSpace(err) {
+			p.enterReadOnly("append device event", err)
+		}
+		log.Printf("event log append: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (p *Platform) compactEvents() {
+	if n, err := p.events.Compact(p.clock.Now()); err != nil {
+		log.Printf("event log compact: %v", err)
+	} else if n > 0 {
+		log.Printf("event log compact: removed %d expired entries", n)
+	}
+	p.enforceRetention()
+	p.compactAlgoLogs()
+}
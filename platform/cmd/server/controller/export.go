@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 面向嵌入式更新器（SWUpdate / RAUC / Mender）的导出：同一份发布流水线产出的版本，
+// 可以被已标准化在这些更新器上的机队直接消费，而无需运行我们的 agent。sideload 格式则供没有网络的
+// 设备上的 agent 从 U 盘等离线介质安装（agent 的 sideload_dir），校验与安装流程与在线更新相同。
+
+type ExportController struct {
+	BaseController
+	p *Platform
+}
+
+func NewExportController(p *Platform) *ExportController {
+	return &ExportController{p: p}
+}
+
+// exportOpts 是导出包中写入的设备侧安装参数。
+type exportOpts struct {
+	Path   string // 设备上的安装路径（SWUpdate rawfile / Mender single-file）
+	Target string // RAUC compatible / Mender device_type
+	Slot   string // RAUC 槽位类别
+}
+
+// bundleFormats 是支持的导出格式及其文件扩展名。
+var bundleFormats = map[string]string{
+	"swupdate": ".swu",
+	"rauc":     ".raucb",
+	"mender":   ".mender",
+	"sideload": ".ota",
+}
+
+// Export godoc
+// @Summary      Export a release for an embedded updater
+// @Description  Package a release as a SWUpdate (.swu), RAUC (.raucb) or Mender (.mender) bundle, or as a sideload bundle (.ota: a tar of release.json, transparency.json when the server keeps a transparency log, and the artifact) that agents without connectivity install from their sideload_dir (e.g. a USB stick) with the same verification as online updates. RAUC export requires the rauc tool and a signing certificate configured on the server; recalled and quarantined releases cannot be exported in any format.
+// @Tags         export
+// @Produce      application/octet-stream
+// @Param        version  path   string  true   "Version (e.g. 1.1.0)"
+// @Param        format   path   string  true   "Bundle format (swupdate|rauc|mender|sideload)"
+// @Param        path     query  string  false  "Install path on the device, default: /opt/dronealgo/algorithm"
+// @Param        target   query  string  false  "RAUC compatible / Mender device type, default: dronealgo"
+// @Param        slot     query  string  false  "RAUC slot class, default: algo"
+// @Success      200  {file}  binary
+// @Failure      400  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      501  {object}  map[string]any
+// @Failure      403  {object}  map[string]any  "channel outside token scope"
+// @Security     BearerAuth
+// @Router       /api/v1/export/{version}/{format} [get]
+func (c *ExportController) Export(g *gin.Context) {
+	version, format := g.Param("version"), g.Param("format")
+	ext, ok := bundleFormats[format]
+	if !ok {
+		c.ResponseFailure(g, ErrParam, "unknown format (want swupdate, rauc, mender or sideload)")
+		return
+	}
+	c.p.store.mu.RLock()
+	rel := c.p.store.ReleasesByVersion[version]
+	c.p.store.mu.RUnlock()
+	if rel == nil {
+		c.ResponseFailure(g, ErrParam, "unknown version")
+		return
+	}
+	if !c.p.allowChannel(g, rel.Channel) {
+		return
+	}
+	opt := exportOpts{
+		Path:   g.DefaultQuery("path", "/opt/dronealgo/algorithm"),
+		Target: g.DefaultQuery("target", "dronealgo"),
+		Slot:   g.DefaultQuery("slot", "algo"),
+	}
+	if format == "rauc" && (c.p.raucCert == "" || c.p.raucKey == "") {
+		c.ResponseFailure(g, ErrUnsupported, "RAUC export needs -rauc-cert and -rauc-key on the server")
+		return
+	}
+	// 导出的包（离线安装、嵌入式更新器）都绕过 /check，召回与隔离只能在导出时把关
+	if rel.Recall != nil || rel.Quarantine != nil {
+		c.ResponseFailure(g, ErrParam, version+" is recalled or quarantined")
+		return
+	}
+
+	a, err := c.p.artifacts.Open(version)
+	if errors.Is(err, errArtifactNotFound) {
+		c.ResponseFailure(g, ErrNotFound, "artifact missing for version "+version)
+		return
+	}
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	defer a.Close()
+
+	name := "dronealgo-" + version + ext
+	g.Header("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+	switch format {
+	case "swupdate":
+		// cpio 头部需要制品的校验和，先算好再开始输出，之后的错误只能中断连接
+		sum, err := cpioChecksum(a)
+		if err != nil {
+			c.ResponseFailure(g, ErrInternal, err.Error())
+			return
+		}
+		g.Header("Content-Type", "application/octet-stream")
+		g.Status(http.StatusOK)
+		if err := writeSWU(g.Writer, rel, a, sum, opt); err != nil {
+			log.Printf("export %s %s: %v", version, format, err)
+			g.Abort()
+		}
+	case "mender":
+		b, err := buildMenderArtifact(rel, a, opt)
+		if err != nil {
+			c.ResponseFailure(g, ErrInternal, err.Error())
+			return
+		}
+		g.Data(http.StatusOK, "application/octet-stream", b)
+	case "rauc":
+		fp, cleanup, err := buildRAUCBundle(rel, a, opt, c.p.raucCert, c.p.raucKey)
+		if err != nil {
+			c.ResponseFailure(g, ErrInternal, err.Error())
+			return
+		}
+		defer cleanup()
+		g.File(fp)
+	case "sideload":
+		var proof *logProof
+		if c.p.tlog != nil {
+			if proof, err = c.p.tlog.inclusion(version); err != nil {
+				c.ResponseFailure(g, ErrInternal, "transparency log proof: "+err.Error())
+				return
+			}
+		}
+		g.Header("Content-Type", "application/x-tar")
+		g.Status(http.StatusOK)
+		if err := writeSideload(g.Writer, rel, a, proof); err != nil {
+			log.Printf("export %s %s: %v", version, format, err)
+			g.Abort()
+		}
+	}
+	// 导出的包（含嵌入式更新器经 Poll 拿到的下载地址）与 /download 一样计入流量统计
+	c.p.recordDownload(g, rel)
+}
+
+// Poll godoc
+// @Summary      Poll for updates (embedded updaters)
+// @Description  Minimal polling endpoint for SWUpdate/RAUC/Mender-based fleets: 204 when the device is up to date, otherwise the version and an absolute bundle URL.
+// @Tags         export
+// @Produce      json
+// @Param        format     path   string  true   "Bundle format (swupdate|rauc|mender)"
+// @Param        channel    query  string  false  "Channel (stable|beta), default: stable"
+// @Param        current    query  string  false  "Current version on device"
+// @Param        device_id  query  string  false  "Device ID, recorded in the device event log"
+// @Success      200  {object}  map[string]any  "version, format, notes, sha256, download_url"
+// @Success      204  "up to date, no release in channel, or the update is held (freeze, rollout, approval, maintenance window)"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any  "channel outside token scope"
+// @Security     BearerAuth
+// @Router       /api/v1/updater/{format} [get]
+func (c *ExportController) Poll(g *gin.Context) {
+	format := g.Param("format")
+	// sideload 包由人带到设备上，不经轮询
+	if _, ok := bundleFormats[format]; !ok || format == "sideload" {
+		c.ResponseFailure(g, ErrParam, "unknown format (want swupdate, rauc or mender)")
+		return
+	}
+	c.p.reloadIfChanged()
+
+	channel := g.DefaultQuery("channel", "stable")
+	if !c.p.allowChannel(g, channel) {
+		return
+	}
+	current := g.Query("current")
+	device, ok := c.p.bindDevice(g, g.Query("device_id"))
+	if !ok {
+		return
+	}
+	device, reassigned := c.p.checkIn(g, device)
+	if reassigned {
+		g.Header("X-Device-ID", device)
+	}
+	c.p.recordEvent(&DeviceEvent{
+		DeviceID: device,
+		Type:     "check",
+		Channel:  channel,
+		Version:  current,
+		Data:     map[string]any{"ip": g.ClientIP(), "updater": format},
+	})
+
+	// 与 /check、hawkBit 同一条决定路径：召回、隔离、冻结、渐进发布、二分定位、设备组策略的审批与维护时段
+	// 都在这里把关（见 checkdecision.go）。bundle 包装的是 binary 制品，deb/rpm 版本不经更新器下发。
+	in := checkRequest{device: device, channel: channel, current: current, format: "binary"}
+	d := c.p.decide(in, c.p.principal(g))
+	if !d.offer {
+		g.Status(http.StatusNoContent)
+		return
+	}
+	latest := d.latest
+
+	rel := path.Join(path.Dir(g.FullPath()), "..", "export", latest.Version, format)
+	g.JSON(http.StatusOK, gin.H{
+		"version":      latest.Version,
+		"format":       format,
+		"notes":        latest.Notes,
+		"sha256":       latest.Sha256, // 制品本身的摘要，非整个 bundle
+		"download_url": c.p.ExternalURL(g, rel),
+	})
+}
+
+// copyArtifact 把制品完整复制到 w 并检查长度，避免截断的制品被打进包。
+func copyArtifact(w io.Writer, a ArtifactReader) error {
+	if _, err := a.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, a)
+	if err != nil {
+		return err
+	}
+	if n != a.Size() {
+		return errors.New("artifact size changed while exporting")
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package controller
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ---- SWUpdate：cpio（crc 格式），首个条目为 sw-description ----
+
+// cpioChecksum 计算 070702 格式要求的校验和：所有数据字节之和（mod 2^32）。
+func cpioChecksum(a ArtifactReader) (uint32, error) {
+	if _, err := a.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var sum uint32
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := a.Read(buf)
+		for _, b := range buf[:n] {
+			sum += uint32(b)
+		}
+		if err == io.EOF {
+			return sum, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func bytesChecksum(b []byte) uint32 {
+	var sum uint32
+	for _, c := range b {
+		sum += uint32(c)
+	}
+	return sum
+}
+
+// libconfigString 按 libconfig 语法转义字符串。
+func libconfigString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+func swDescription(rel *Release, opt exportOpts) []byte {
+	var b strings.Builder
+	b.WriteString("software =\n{\n")
+	fmt.Fprintf(&b, "\tversion = %s;\n", libconfigString(rel.Version))
+	fmt.Fprintf(&b, "\tdescription = %s;\n", libconfigString(rel.Notes))
+	b.WriteString("\tfiles: (\n\t\t{\n")
+	b.WriteString("\t\t\tfilename = \"algorithm\";\n")
+	b.WriteString("\t\t\ttype = \"rawfile\";\n")
+	fmt.Fprintf(&b, "\t\t\tpath = %s;\n", libconfigString(opt.Path))
+	fmt.Fprintf(&b, "\t\t\tsha256 = %s;\n", libconfigString(rel.Sha256))
+	b.WriteString("\t\t}\n\t);\n}\n")
+	return []byte(b.String())
+}
+
+func writeSWU(w io.Writer, rel *Release, a ArtifactReader, sum uint32, opt exportOpts) error {
+	desc := swDescription(rel, opt)
+	mtime := rel.CreatedAt.Unix()
+	if err := writeCPIOEntry(w, 1, "sw-description", 0o100644, mtime, bytes.NewReader(desc), int64(len(desc)), bytesChecksum(desc)); err != nil {
+		return err
+	}
+	if err := writeCPIOEntry(w, 2, "algorithm", 0o100755, mtime, nil, a.Size(), sum); err != nil {
+		return err
+	}
+	if err := copyArtifact(w, a); err != nil {
+		return err
+	}
+	if err := cpioPad(w, a.Size()); err != nil {
+		return err
+	}
+	return writeCPIOEntry(w, 0, "TRAILER!!!", 0, 0, bytes.NewReader(nil), 0, 0)
+}
+
+// writeCPIOEntry 写一个 newc/crc 头；body 非 nil 时一并写入数据与对齐填充，
+// 否则由调用方随后写入 size 字节的数据。
+func writeCPIOEntry(w io.Writer, ino int, name string, mode uint32, mtime int64, body io.Reader, size int64, sum uint32) error {
+	hdr := fmt.Sprintf("070702%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		ino, mode, 0, 0, 1, mtime, size, 0, 0, 0, 0, len(name)+1, sum)
+	if _, err := io.WriteString(w, hdr+name+"\x00"); err != nil {
+		return err
+	}
+	if err := cpioPad(w, int64(len(hdr)+len(name)+1)); err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		return err
+	}
+	return cpioPad(w, size)
+}
+
+func cpioPad(w io.Writer, n int64) error {
+	if pad := (4 - n%4) % 4; pad > 0 {
+		_, err := w.Write(make([]byte, pad))
+		return err
+	}
+	return nil
+}
+
+// ---- Mender：artifact v3，single-file 更新模块 ----
+
+type tarFile struct {
+	name string
+	mode int64
+	data []byte
+}
+
+func writeTar(w io.Writer, files []tarFile, rel *Release) error {
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: f.mode, Size: int64(len(f.data)), ModTime: rel.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func tarGz(files []tarFile, rel *Release) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := writeTar(zw, files, rel); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func buildMenderArtifact(rel *Release, a ArtifactReader, opt exportOpts) ([]byte, error) {
+	var bin bytes.Buffer
+	if err := copyArtifact(&bin, a); err != nil {
+		return nil, err
+	}
+	dir, file := path.Split(opt.Path)
+	payload := []tarFile{
+		{"algorithm", 0o755, bin.Bytes()},
+		{"dest_dir", 0o644, []byte(path.Clean(dir))},
+		{"filename", 0o644, []byte(file)},
+		{"permissions", 0o644, []byte("755")},
+	}
+	data, err := tarGz(payload, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	headerInfo, _ := json.Marshal(map[string]any{
+		"payloads":          []map[string]string{{"type": "single-file"}},
+		"artifact_provides": map[string]string{"artifact_name": rel.Version},
+		"artifact_depends":  map[string][]string{"device_type": {opt.Target}},
+	})
+	typeInfo, _ := json.Marshal(map[string]any{
+		"type":                     "single-file",
+		"artifact_provides":        map[string]string{"rootfs-image.single-file.version": rel.Version},
+		"clears_artifact_provides": []string{"rootfs-image.single-file.*"},
+	})
+	header, err := tarGz([]tarFile{
+		{"header-info", 0o644, headerInfo},
+		{"headers/0000/type-info", 0o644, typeInfo},
+	}, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	version := []byte(`{"format":"mender","version":3}`)
+	sums := map[string]string{
+		"version":       sha256Hex(version),
+		"header.tar.gz": sha256Hex(header),
+	}
+	for _, f := range payload {
+		sums["data/0000/"+f.name] = sha256Hex(f.data)
+	}
+	names := make([]string, 0, len(sums))
+	for n := range sums {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var manifest strings.Builder
+	for _, n := range names {
+		fmt.Fprintf(&manifest, "%s  %s\n", sums[n], n)
+	}
+
+	var out bytes.Buffer
+	err = writeTar(&out, []tarFile{
+		{"version", 0o644, version},
+		{"manifest", 0o644, []byte(manifest.String())},
+		{"header.tar.gz", 0o644, header},
+		{"data/0000.tar.gz", 0o644, data},
+	}, rel)
+	return out.Bytes(), err
+}
+
+// ---- RAUC：调用 rauc 命令行生成签名 bundle ----
+
+func buildRAUCBundle(rel *Release, a ArtifactReader, opt exportOpts, cert, key string) (string, func(), error) {
+	if _, err := exec.LookPath("rauc"); err != nil {
+		return "", nil, fmt.Errorf("rauc tool not available: %w", err)
+	}
+	work, err := os.MkdirTemp("", "rauc-export-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(work) }
+
+	content := filepath.Join(work, "content")
+	if err := os.Mkdir(content, 0o755); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(content, "algorithm"), os.O_CREATE|os.O_WRONLY, 0o755)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	err = copyArtifact(f, a)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	manifest := fmt.Sprintf("[update]\ncompatible=%s\nversion=%s\ndescription=%s\n\n[bundle]\nformat=verity\n\n[image.%s]\nfilename=algorithm\n",
+		opt.Target, rel.Version, strings.ReplaceAll(rel.Notes, "\n", " "), opt.Slot)
+	if err := os.WriteFile(filepath.Join(content, "manifest.raucm"), []byte(manifest), 0o644); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	out := filepath.Join(work, "bundle.raucb")
+	cmd := exec.Command("rauc", "bundle", "--cert="+cert, "--key="+key, content, out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("rauc bundle: %v: %s", err, strings.TrimSpace(string(b)))
+	}
+	return out, cleanup, nil
+}
+
+// ---- sideload：agent 离线安装（U 盘等），tar 中依次为 release.json、transparency.json（可选）与 artifact ----
+
+// 条目名与 agent 的 sideload.go 一致。
+const (
+	sideloadRelease  = "release.json"
+	sideloadProof    = "transparency.json"
+	sideloadArtifact = "artifact"
+)
+
+// writeSideload writes the release record (as /check serves it, with the
+// digest, signatures and cosign bundle), the transparency log inclusion
+// proof when there is one, and the artifact. The metadata comes first so
+// the agent finds it without reading past the artifact.
+func writeSideload(w io.Writer, rel *Release, a ArtifactReader, proof *logProof) error {
+	meta, err := json.Marshal(rel)
+	if err != nil {
+		return err
+	}
+	files := []tarFile{{name: sideloadRelease, mode: 0o644, data: meta}}
+	if proof != nil {
+		b, err := json.Marshal(proof)
+		if err != nil {
+			return err
+		}
+		files = append(files, tarFile{name: sideloadProof, mode: 0o644, data: b})
+	}
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: f.mode, Size: int64(len(f.data)), ModTime: rel.CreatedAt}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	hdr := &tar.Header{Name: sideloadArtifact, Mode: 0o644, Size: a.Size(), ModTime: rel.CreatedAt}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if err := copyArtifact(tw, a); err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/clock"
+)
+
+// poll calls the embedded updater endpoint for a mender device and returns
+// the status code and the offered version.
+func poll(t *testing.T, p *Platform, device, current string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	g, _ := gin.CreateTestContext(w)
+	g.Request = httptest.NewRequest("GET", "/api/v1/updater/mender?device_id="+device+"&current="+current, nil)
+	g.Params = gin.Params{{Key: "format", Value: "mender"}}
+	NewExportController(p).Poll(g)
+	var out struct{ Version string }
+	// 204 没有正文，gin 不会把状态码写进 recorder
+	code := g.Writer.Status()
+	if code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return code, out.Version
+}
+
+func TestPollHonoursRollout(t *testing.T) {
+	p := newMemoryPlatform(t, clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		if _, _, err := p.publishRelease(publishInput{Version: v, Channel: "stable", Format: "binary"}, strings.NewReader(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 比例为 0 的渐进发布：设备停在基线版本
+	p.store.Rollouts = map[string]*Rollout{"r1": {ID: "r1", Channel: "stable", Version: "1.1.0", Baseline: "1.0.0", Steps: []int{0, 100}, State: RolloutRunning}}
+
+	if code, v := poll(t, p, "d1", "1.0.0"); code != 204 {
+		t.Fatalf("poll on baseline = %d %s, want 204", code, v)
+	}
+	if code, v := poll(t, p, "d1", "0.9.0"); code != 200 || v != "1.0.0" {
+		t.Fatalf("poll below baseline = %d %s, want the baseline", code, v)
+	}
+	p.store.Rollouts["r1"].State = RolloutCompleted
+	if code, v := poll(t, p, "d1", "1.0.0"); code != 200 || v != "1.1.0" {
+		t.Fatalf("poll after rollout = %d %s, want 1.1.0", code, v)
+	}
+}
+
+func TestPollQueuesApproval(t *testing.T) {
+	p := newMemoryPlatform(t, clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+	if _, _, err := p.publishRelease(publishInput{Version: "1.1.0", Channel: "stable", Format: "binary"}, strings.NewReader("v")); err != nil {
+		t.Fatal(err)
+	}
+	p.store.Policies = []*UpdatePolicy{{Name: "field", Devices: []string{"field-*"}, Mode: PolicyApproval}}
+
+	if code, v := poll(t, p, "field-1", "1.0.0"); code != 204 {
+		t.Fatalf("poll under approval policy = %d %s, want 204", code, v)
+	}
+	a := p.store.Approvals[approvalID("field-1", "1.1.0")]
+	if a == nil || a.State != ApprovalPending || a.Policy != "field" {
+		t.Fatalf("approval %+v, want pending under policy field", a)
+	}
+	if code, v := poll(t, p, "lab-1", "1.0.0"); code != 200 || v != "1.1.0" {
+		t.Fatalf("poll outside the policy = %d %s, want 1.1.0", code, v)
+	}
+}
+
+func TestPollHonoursWindowsQuarantineAndBisect(t *testing.T) {
+	p := newMemoryPlatform(t, clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+	for _, v := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		if _, _, err := p.publishRelease(publishInput{Version: v, Channel: "stable", Format: "binary"}, strings.NewReader(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 维护时段外（UTC 12:00）不下发
+	p.store.Windows = map[string]*MaintenanceWindow{"night": {DeviceID: "night", Windows: []string{"02:00-04:00"}}}
+	if code, v := poll(t, p, "night", "1.0.0"); code != 204 {
+		t.Fatalf("poll outside maintenance window = %d %s, want 204", code, v)
+	}
+
+	// 二分定位的测试设备固定到待测版本，可以比当前版本旧
+	p.store.Bisections = map[string]*Bisection{"b1": {ID: "b1", DeviceID: "bench", State: BisectRunning, Steps: []BisectStep{{Version: "1.1.0"}}}}
+	if code, v := poll(t, p, "bench", "1.2.0"); code != 200 || v != "1.1.0" {
+		t.Fatalf("poll on bisect device = %d %s, want 1.1.0", code, v)
+	}
+
+	// 隔离的最新版本不再下发，渠道改指上一个版本
+	if err := p.quarantine("1.2.0", Quarantine{Trigger: QuarantineIntegrity, Detail: "sha256 mismatch"}, "system"); err != nil {
+		t.Fatal(err)
+	}
+	if code, v := poll(t, p, "d1", "1.0.0"); code != 200 || v != "1.1.0" {
+		t.Fatalf("poll after quarantine = %d %s, want 1.1.0", code, v)
+	}
+	if code, v := poll(t, p, "d1", "1.1.0"); code != 204 {
+		t.Fatalf("poll on newest unquarantined = %d %s, want 204", code, v)
+	}
+}
@@ -2,148 +2,269 @@ package controller
 
 import (
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/artifactsig"
+	"github.com/von0000/dronealgo-ota/internal/bundle"
+	"github.com/von0000/dronealgo-ota/internal/launch"
+	"github.com/von0000/dronealgo-ota/internal/oci"
 )
 
 type FileController struct {
 	BaseController
+	p *Platform
+}
+
+func NewFileController(p *Platform) *FileController {
+	return &FileController{p: p}
 }
 
 type Release struct {
 	Version   string    `json:"version"`
-	Channel   string    `json:"channel"` // e.g. "stable", "beta"
-	URL       string    `json:"url"`     // relative: /download/<version>
+	App       string    `json:"app,omitempty"` // 所属应用，为空即主应用（见 apps.go）
+	Channel   string    `json:"channel"`       // e.g. "stable", "beta"
+	URL       string    `json:"url"`           // relative: /download/<version>
 	Sha256    string    `json:"sha256"`
+	Size      int64     `json:"size,omitempty"` // 制品字节数，发布时记录；旧记录为 0
 	Notes     string    `json:"notes"`
 	CreatedAt time.Time `json:"created_at"`
-	FilePath  string    `json:"-"`
+	Signature string    `json:"signature,omitempty"` // base64，对应用、版本与 sha256 的分离签名（见 internal/artifactsig）
+	KeyID     string    `json:"key_id,omitempty"`
+	SigScheme int       `json:"sig_scheme,omitempty"` // 签名方案，0 为只签 sha256 摘要的旧记录
+	Format    string    `json:"format,omitempty"`     // 制品格式：binary（缺省）| deb | rpm
+	Campaign  string    `json:"campaign,omitempty"`   // 下载流量计入的发布活动，为空时即版本号（见 bandwidth.go）
+
+	// ShadowArgs 是影子运行时追加的命令行参数（换端口、不输出执行器指令，见 shadow.go），为空的版本不能影子部署。
+	ShadowArgs []string `json:"shadow_args,omitempty"`
+	// Mandatory 的版本在设备端不受电量门限限制（如安全修复）。
+	Mandatory bool `json:"mandatory,omitempty"`
+	// Launch 是启动模板（如 python3 {dir}/main.py --model {dir}/model.onnx），非空时制品是 tar.gz 或 zip 包，
+	// agent 解压后按模板直接启动解释器，见 internal/launch。
+	Launch string `json:"launch,omitempty"`
+	// Package 非空（tar.gz | zip）时制品是带 manifest.json 的多文件包，Launch 取自清单的入口，
+	// agent 解压后按清单逐个校验文件，见 internal/bundle。
+	Package string `json:"package,omitempty"`
+	// ArtifactType 为 oci 时制品是容器镜像归档（docker save 或 OCI image layout 的 tar），agent 导入后以
+	// 容器运行，ImageID 是其中镜像的 ID（config 摘要），见 internal/oci/archive.go；为空即 binary。
+	ArtifactType string `json:"artifact_type,omitempty"`
+	ImageID      string `json:"image_id,omitempty"`
+
+	// ReleaseNotes 是结构化的发布说明（见 releasenotes.go），旧记录为空。
+	ReleaseNotes *ReleaseNotes `json:"release_notes,omitempty"`
+
+	// Recall 非空时版本已被召回，运行它的设备回滚到上一个版本（见 recall.go）。
+	Recall *Recall `json:"recall,omitempty"`
+	// Quarantine 非空时版本已被完整性检查自动移入 quarantined 渠道，不再下发（见 quarantine.go）。
+	Quarantine *Quarantine `json:"quarantine,omitempty"`
+
+	// CosignBundle 是 CI 用 cosign sign-blob --bundle 生成的签名包，agent 安装前可再次校验。
+	CosignBundle json.RawMessage `json:"cosign_bundle,omitempty" swaggertype:"object"`
+
+	// Binary 是发布时从 ELF 制品中提取的架构与构建信息（见 binmeta.go），其它制品与旧记录为空。
+	Binary *BinaryInfo `json:"binary,omitempty"`
 }
 
+// artifactFormats 是 agent 安装后端支持的制品格式。
+var artifactFormats = map[string]bool{"binary": true, "deb": true, "rpm": true}
+
+// ArtifactOCI 是以容器运行的版本的制品类型。
+const ArtifactOCI = "oci"
+
 type Store struct {
 	mu                sync.RWMutex
 	ReleasesByVersion map[string]*Release `json:"releases_by_version"`
 	LatestByChannel   map[string]string   `json:"latest_by_channel"` // channel -> version
-}
-
-var (
-	dataDir   = "../../data"
-	artDir    = "../../artifacts"
-	storeFile = filepath.Join(dataDir, "releases.json")
-	store     = &Store{
-		ReleasesByVersion: map[string]*Release{},
-		LatestByChannel:   map[string]string{},
-	}
-)
-
-func InitStore() error {
-	if err := loadStore(); err != nil {
-		return err
-	}
-	return nil
-}
-
-func loadStore() error {
-	f, err := os.Open(storeFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	tmp := &Store{}
-	tmp.ReleasesByVersion = map[string]*Release{}
-	tmp.LatestByChannel = map[string]string{}
-
-	if err := json.NewDecoder(f).Decode(tmp); err != nil {
-		return err
-	}
-
-	store.mu.Lock()
-	defer store.mu.Unlock()
-
-	store.ReleasesByVersion = tmp.ReleasesByVersion
-	store.LatestByChannel = tmp.LatestByChannel
-	return nil
-}
-
-func saveStore() error {
-	if err := os.MkdirAll(filepath.Dir(storeFile), 0755); err != nil {
-		return err
-	}
-	tmp := storeFile + ".tmp"
-	f, err := os.Create(tmp)
-	if err != nil {
-		return err
-	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(store); err != nil {
-		f.Close()
-		return err
-	}
-	_ = f.Close()
-	return os.Rename(tmp, storeFile)
+	// DeviceSplits 记录重复 ID 拆分后的新 ID："<原 ID>/<实例指纹>" -> 新 ID
+	DeviceSplits map[string]string `json:"device_splits,omitempty"`
+	// Policies 是按顺序匹配的设备组更新策略，Approvals 是待批/已决的安装审批（见 policy.go）
+	Policies  []*UpdatePolicy      `json:"policies,omitempty"`
+	Approvals map[string]*Approval `json:"approvals,omitempty"`
+	// Bisections 是在测试设备上定位现场回归的二分任务（见 bisect.go）
+	Bisections map[string]*Bisection `json:"bisections,omitempty"`
+	// Shadows 是候选版本与现役版本并行运行的影子部署（见 shadow.go）
+	Shadows map[string]*ShadowDeployment `json:"shadows,omitempty"`
+	// Aliases 是指向具体版本的具名指针（见 aliases.go）
+	Aliases map[string]*Alias `json:"aliases,omitempty"`
+	// Rollouts 是按比例逐级放量的渐进发布（见 rollout.go）
+	Rollouts map[string]*Rollout `json:"rollouts,omitempty"`
+	// Windows 是管理员按设备设置的维护时段（见 windows.go）
+	Windows map[string]*MaintenanceWindow `json:"windows,omitempty"`
+	// Freezes 是按范围暂停更新下发的冻结（见 freeze.go）
+	Freezes map[string]*Freeze `json:"freezes,omitempty"`
+	// HawkbitActions 是版本第一次经 hawkBit 兼容层下发时分配的 action ID（见 hawkbit.go）
+	HawkbitActions map[string]int64 `json:"hawkbit_actions,omitempty"`
 }
 
 // Publish godoc
 // @Summary      Publish an algorithm artifact
-// @Description  Upload the algorithm binary and create a release record.
+// @Description  Upload the algorithm binary and create a release record. ELF binaries are inspected on upload (architecture, stripped, embedded version string, Go build info, stored as binary) and rejected when they contradict the expected arch or, for tagged Go builds, the version.
 // @Tags         release
 // @Accept       mpfd
 // @Produce      json
 // @Param        version  formData  string  true   "Version (e.g. 1.1.0)"
 // @Param        channel  formData  string  false  "Channel (stable|beta), default: stable"
+// @Param        app      formData  string  false  "Application on multi-app devices (e.g. landing), or agent for the agent program itself (plain binary); default: the primary algorithm"
 // @Param        notes    formData  string  false  "Release notes"
-// @Param        file     formData  file    true   "Algorithm binary"
+// @Param        summary  formData  string  false  "Structured notes: one-line summary (also used as notes when notes is empty)"
+// @Param        breaking formData  bool    false  "Structured notes: the release contains breaking changes"
+// @Param        safety   formData  string  false  "Structured notes: safety impact (none|low|high|experimental), default: none"
+// @Param        issues   formData  string  false  "Structured notes: comma-separated linked issue IDs"
+// @Param        format   formData  string  false  "Artifact format (binary|deb|rpm), default: binary"
+// @Param        campaign formData  string  false  "Campaign the download traffic is billed to (e.g. vision/2.3-rollout), default: the version"
+// @Param        mandatory  formData  bool  false  "Install even on devices below their battery threshold (e.g. a safety fix)"
+// @Param        shadow_args  formData  string  false  "Space-separated arguments that run the algorithm passively for shadow deployments (e.g. --port=9101 --no-actuate)"
+// @Param        launch   formData  string  false  "Launch template for interpreted algorithms (e.g. python3 {dir}/main.py --model {dir}/model.onnx; variables {dir}, {version}, {install_dir}); the file is then a tar.gz or zip bundle"
+// @Param        artifact_type  formData  string  false  "Artifact type (binary|oci), default: binary; oci: the file is an image archive (docker save or OCI image layout tar, optionally gzipped) holding one image, which agents load and run as a container"
+// @Param        arch     formData  string  false  "Expected architecture of an ELF binary (GOARCH name, e.g. arm64), default: the server's -artifact-arch; the publish is rejected when the artifact differs"
+// @Param        file     formData  file    true   "Algorithm binary, or a tar.gz / zip package with a manifest.json (entrypoint and per-file sha256)"
+// @Param        cosign_bundle  formData  file  false  "cosign sign-blob --bundle output; required when the server enforces cosign"
 // @Success      200  {object}  controller.Release
 // @Failure      400  {object}  map[string]any
 // @Failure      500  {object}  map[string]any
+// @Failure      503  {object}  map[string]any  "read-only after disk full"
+// @Failure      507  {object}  map[string]any  "disk full"
+// @Security     BearerAuth
 // @Router       /api/v1/publish [post]
 func (c *FileController) Publish(g *gin.Context) {
 	// 可选：限制单接口上传大小（例如 50MB）
 	g.Request.Body = http.MaxBytesReader(g.Writer, g.Request.Body, 100<<20)
 
+	if c.p.stillReadOnly() {
+		c.ResponseFailure(g, ErrReadOnly, "server is read-only after running out of disk space; free space on the artifact/data volume and retry")
+		return
+	}
+
 	version := strings.TrimSpace(g.PostForm("version"))
 	if version == "" {
 		c.ResponseFailure(g, ErrParam, "version is required")
 		return
 	}
+	// version 会成为制品目录名，禁止路径分隔符
+	if strings.ContainsAny(version, `/\`) || version == "." || version == ".." {
+		c.ResponseFailure(g, ErrParam, "invalid version")
+		return
+	}
 
 	channel := strings.TrimSpace(g.PostForm("channel"))
 	if channel == "" {
 		channel = "stable"
 	}
+	if strings.Contains(channel, "/") {
+		c.ResponseFailure(g, ErrParam, "invalid channel")
+		return
+	}
+	if channel == QuarantineChannel {
+		c.ResponseFailure(g, ErrParam, "channel "+QuarantineChannel+" holds automatically demoted releases and cannot be published to")
+		return
+	}
+	app := strings.TrimSpace(g.PostForm("app"))
+	if err := checkAppName(app); err != nil {
+		c.ResponseFailure(g, ErrParam, err.Error())
+		return
+	}
 
 	notes := strings.TrimSpace(g.PostForm("notes"))
-
-	fileHeader, err := g.FormFile("file")
+	relNotes, err := parseReleaseNotes(g)
 	if err != nil {
-		c.ResponseFailure(g, ErrParam, "missing file: "+err.Error())
+		c.ResponseFailure(g, ErrParam, err.Error())
 		return
 	}
+	if notes == "" && relNotes != nil {
+		// 只读 notes 的旧客户端也能看到摘要
+		notes = relNotes.Summary
+	}
 
-	vDir := filepath.Join(artDir, version)
-	if err := os.MkdirAll(vDir, 0755); err != nil {
-		c.ResponseFailure(g, ErrInternal, err.Error())
+	format := strings.TrimSpace(g.PostForm("format"))
+	if format == "" {
+		format = "binary"
+	}
+	if !artifactFormats[format] {
+		c.ResponseFailure(g, ErrParam, "invalid format (want binary, deb or rpm)")
+		return
+	}
+
+	mandatory := false
+	if v := strings.TrimSpace(g.PostForm("mandatory")); v != "" {
+		if mandatory, err = strconv.ParseBool(v); err != nil {
+			c.ResponseFailure(g, ErrParam, "mandatory must be true or false")
+			return
+		}
+	}
+
+	launchTmpl := strings.TrimSpace(g.PostForm("launch"))
+	if launchTmpl != "" {
+		t, err := launch.Parse(launchTmpl)
+		if err != nil {
+			c.ResponseFailure(g, ErrParam, err.Error())
+			return
+		}
+		if format != "binary" {
+			c.ResponseFailure(g, ErrParam, "launch templates need the binary format (a tar.gz bundle)")
+			return
+		}
+		launchTmpl = t.String()
+	}
+
+	// 容器镜像版本：agent 以容器运行，不经过安装后端、启动模板与 ELF 检查
+	artifactType := strings.TrimSpace(g.PostForm("artifact_type"))
+	switch artifactType {
+	case "binary":
+		artifactType = ""
+	case "", ArtifactOCI:
+	default:
+		c.ResponseFailure(g, ErrParam, "invalid artifact_type (want binary or oci)")
+		return
+	}
+	if artifactType == ArtifactOCI && (format != "binary" || launchTmpl != "" || app != "") {
+		c.ResponseFailure(g, ErrParam, "OCI image releases are for the primary algorithm and take no format or launch template")
 		return
 	}
-	dstPath := filepath.Join(vDir, "algorithm")
-	dst, err := os.Create(dstPath)
+
+	// 声明的架构与 ELF 制品不符时拒绝发布（见 binmeta.go）
+	arch := strings.TrimSpace(g.PostForm("arch"))
+	if arch != "" {
+		if !knownArches[arch] {
+			c.ResponseFailure(g, ErrParam, "unknown arch "+arch+" (want a GOARCH name such as arm64, arm or amd64)")
+			return
+		}
+		if format != "binary" || launchTmpl != "" || artifactType != "" {
+			c.ResponseFailure(g, ErrParam, "arch is only checked for plain binaries (format binary, no launch template)")
+			return
+		}
+	}
+
+	fileHeader, err := g.FormFile("file")
 	if err != nil {
-		c.ResponseFailure(g, ErrInternal, "create dst: "+err.Error())
+		c.ResponseFailure(g, ErrParam, "missing file: "+err.Error())
 		return
 	}
-	defer dst.Close()
+
+	in := publishInput{Version: version, App: app, Channel: channel, Notes: notes, Format: format, ReleaseNotes: relNotes,
+		Campaign: strings.TrimSpace(g.PostForm("campaign")), ShadowArgs: strings.Fields(g.PostForm("shadow_args")), Mandatory: mandatory,
+		Launch: launchTmpl, ArtifactType: artifactType, Actor: c.p.principal(g).Name}
+	if fh, err := g.FormFile("cosign_bundle"); err == nil {
+		if in.CosignBundle, err = readFormFile(fh, 1<<20); err != nil {
+			c.ResponseFailure(g, ErrParam, "cosign_bundle: "+err.Error())
+			return
+		}
+		if !json.Valid(in.CosignBundle) {
+			c.ResponseFailure(g, ErrParam, "cosign_bundle is not JSON")
+			return
+		}
+	}
 
 	src, err := fileHeader.Open()
 	if err != nil {
@@ -151,118 +272,477 @@ func (c *FileController) Publish(g *gin.Context) {
 		return
 	}
 	defer src.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(io.MultiWriter(dst, h), src); err != nil {
-		c.ResponseFailure(g, ErrInternal, "hash: "+err.Error())
-		return
+	if artifactType == ArtifactOCI {
+		// 归档中必须恰好有一个镜像，其 ID 随版本下发，agent 导入后以它打 tag
+		if in.ImageID, err = oci.ImageID(io.NewSectionReader(src, 0, fileHeader.Size)); err != nil {
+			c.ResponseFailure(g, ErrParam, err.Error())
+			return
+		}
+	}
+	// 带 manifest.json 的 tar.gz / zip 包：发布时按清单校验包内每个文件，清单的入口即启动模板
+	if pkg := bundle.Detect(src); format == "binary" && artifactType == "" && pkg != "" {
+		m, err := bundle.Inspect(src, fileHeader.Size)
+		if err != nil {
+			c.ResponseFailure(g, ErrParam, "bundle: "+err.Error())
+			return
+		}
+		if m != nil {
+			if launchTmpl != "" {
+				c.ResponseFailure(g, ErrParam, "the bundle's "+bundle.ManifestName+" gives the entrypoint; publish it without launch")
+				return
+			}
+			if arch != "" {
+				c.ResponseFailure(g, ErrParam, "arch is only checked for plain binaries (format binary, no launch template)")
+				return
+			}
+			// ParseManifest 已校验过入口
+			launchTmpl, _ = m.Launch()
+			in.Launch, in.Package = launchTmpl, pkg
+		}
+	}
+	if format == "binary" && launchTmpl == "" && artifactType == "" {
+		if in.Binary, err = inspectBinary(src, fileHeader.Size, version); err != nil {
+			c.ResponseFailure(g, ErrInternal, "inspect artifact: "+err.Error())
+			return
+		}
+		expect := arch
+		if expect == "" {
+			expect = c.p.artifactArch
+		}
+		if err := checkBinary(in.Binary, expect, arch != "", version); err != nil {
+			c.ResponseFailure(g, ErrParam, err.Error())
+			return
+		}
 	}
-	sum := hex.EncodeToString(h.Sum(nil))
 
-	url := "/download/" + version
-	rel := &Release{
-		Version:   version,
-		Channel:   channel,
-		URL:       url,
-		Sha256:    sum,
-		Notes:     notes,
-		CreatedAt: time.Now(),
-		FilePath:  dstPath,
-	}
-
-	store.mu.Lock()
-	defer store.mu.Unlock()
-	store.ReleasesByVersion[version] = rel
-	store.LatestByChannel[channel] = version
-
-	if err := saveStore(); err != nil {
-		c.ResponseFailure(g, ErrInternal, "save metadata: "+err.Error())
+	rel, code, err := c.p.publishRelease(in, src)
+	if err != nil {
+		c.ResponseFailure(g, code, err.Error())
 		return
 	}
 
 	g.JSON(http.StatusOK, rel)
 }
 
-func isNewer(a, b string) bool {
-	// Compare SemVer-like: "MAJ.MIN.PATCH[-extra]" (very simple)
-	parse := func(s string) (int, int, int) {
-		s = strings.SplitN(s, "-", 2)[0]
-		parts := strings.Split(s, ".")
-		get := func(i int) int {
-			if i >= len(parts) {
-				return 0
-			}
-			n, _ := strconv.Atoi(parts[i])
-			return n
+// publishInput 是一次发布携带的元数据。
+type publishInput struct {
+	Version, App, Channel, Notes string
+	Format                       string
+	Campaign                     string
+	ShadowArgs                   []string
+	Mandatory                    bool
+	Launch                       string
+	Package                      string
+	ArtifactType, ImageID        string
+	ReleaseNotes                 *ReleaseNotes
+	CosignBundle                 []byte
+	Actor                        string      // 发布者，记入渠道历史
+	Binary                       *BinaryInfo // 从 ELF 制品中提取的元数据
+}
+
+// byteCount counts the bytes written to it.
+type byteCount int64
+
+func (n *byteCount) Write(b []byte) (int, error) {
+	*n += byteCount(len(b))
+	return len(b), nil
+}
+
+// readFormFile reads a small multipart attachment fully.
+func readFormFile(fh *multipart.FileHeader, limit int64) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, errors.New("too large")
+	}
+	return b, nil
+}
+
+// publishRelease 以事务方式发布：
+//  1. 制品先暂存到制品存储并计算 sha256（校验 cosign 签名，可选签名）；
+//  2. 基于当前 store 构造新状态并持久化；
+//  3. 提交制品（已有同版本制品先备份，失败时恢复）；
+//  4. 最后切换内存中的 store。
+//
+// 任一步失败都会回滚之前的步骤，内存、元数据与制品存储保持一致。
+func (p *Platform) publishRelease(in publishInput, src io.Reader) (*Release, ErrCode, error) {
+	version, channel := in.Version, in.Channel
+	if in.App == AgentApp && (in.Format != "binary" || in.Launch != "" || in.ArtifactType != "") {
+		return nil, ErrParam, errors.New("agent releases must be plain binaries (format binary, no launch template)")
+	}
+	h := sha256.New()
+	var size byteCount
+	staged, err := p.artifacts.Stage(io.TeeReader(src, io.MultiWriter(h, &size)))
+	if err != nil {
+		return nil, p.fsErrCode(err, "write artifact "+version), fsErr(err, "write artifact "+version)
+	}
+	defer staged.Discard()
+
+	digest := h.Sum(nil)
+	rel := &Release{
+		Version:      version,
+		App:          in.App,
+		Channel:      channel,
+		URL:          "/download/" + version,
+		Sha256:       hex.EncodeToString(digest),
+		Size:         int64(size),
+		Notes:        in.Notes,
+		CreatedAt:    p.clock.Now(),
+		Format:       in.Format,
+		Campaign:     in.Campaign,
+		ShadowArgs:   in.ShadowArgs,
+		Mandatory:    in.Mandatory,
+		Launch:       in.Launch,
+		Package:      in.Package,
+		ArtifactType: in.ArtifactType,
+		ImageID:      in.ImageID,
+		ReleaseNotes: in.ReleaseNotes,
+		CosignBundle: in.CosignBundle,
+		Binary:       in.Binary,
+	}
+	// 供应链策略：只有 CI 签名的制品才能发布
+	if p.cosign != nil && (in.CosignBundle != nil || p.requireCosign) {
+		if in.CosignBundle == nil {
+			return nil, ErrParam, errors.New("cosign_bundle is required by server policy")
+		}
+		if err := p.cosign.Verify(rel.Sha256, in.CosignBundle); err != nil {
+			return nil, ErrParam, err
 		}
-		return get(0), get(1), get(2)
+	}
+	if p.signer != nil {
+		msg, err := artifactsig.Message(rel.App, rel.Version, rel.Sha256)
+		if err != nil {
+			return nil, ErrParam, err
+		}
+		sig, err := p.signer.Sign(msg)
+		if err != nil {
+			return nil, ErrInternal, errors.New("sign artifact: " + err.Error())
+		}
+		rel.Signature = base64.StdEncoding.EncodeToString(sig)
+		rel.KeyID = p.signer.KeyID()
+		rel.SigScheme = artifactsig.Scheme
 	}
 
-	amaj, amin, apat := parse(a)
-	bmaj, bmin, bpat := parse(b)
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
 
-	if amaj != bmaj {
-		return amaj > bmaj
+	// 制品按版本号存放，同一版本号不能属于两个应用
+	if old := p.store.ReleasesByVersion[version]; old != nil && old.App != in.App {
+		return nil, ErrParam, errors.New("version " + version + " already belongs to " + appLabel(old.App) + "; versions are unique across apps, suffix them per app (e.g. 1.2.0-landing)")
 	}
-	if amin != bmin {
-		return amin > bmin
+
+	// 先记入透明日志：未记录的版本绝不会被下发（发布失败留下的条目只是多一条历史记录）
+	if p.tlog != nil {
+		if _, err := p.tlog.append(rel, p.clock.Now()); err != nil {
+			return nil, p.fsErrCode(err, "append transparency log"), fsErr(err, "append transparency log")
+		}
 	}
-	return apat > bpat
+
+	next := p.store.cloneState()
+	next.ReleasesByVersion[version] = rel
+	next.LatestByChannel[rel.latestKey()] = version
+	if err := p.saveStore(next); err != nil {
+		return nil, p.fsErrCode(err, "save metadata"), fsErr(err, "save metadata")
+	}
+
+	// 持久化成功后再提交制品；失败则恢复旧元数据
+	if err := staged.Commit(version); err != nil {
+		if rerr := p.saveStore(p.store); rerr != nil {
+			log.Printf("publish %s: rollback metadata failed: %v", version, rerr)
+		}
+		return nil, p.fsErrCode(err, "commit artifact"), fsErr(err, "commit artifact")
+	}
+
+	p.store.ReleasesByVersion = next.ReleasesByVersion
+	p.store.LatestByChannel = next.LatestByChannel
+	p.recordLatest(ChangePublish, in.Actor)
+	p.refreshTUF(p.store)
+	p.mirrorRelease(rel)
+	return rel, OK, nil
 }
 
 // Check godoc
 // @Summary      Check for updates
-// @Description  Check whether a newer version is available under the channel.
+// @Description  Check whether a newer version is available under the channel. Device-group update policies may withhold it until an operator approves the install, and a device's maintenance window until its local time is inside the window. Responses carry an ETag over their content; a poll with a matching If-None-Match is answered 304 without a body.
 // @Tags         release
 // @Produce      json
 // @Param        channel  query  string  false  "Channel (stable|beta), default: stable"
+// @Param        app      query  string  false  "Application on multi-app devices (e.g. landing), default: the primary algorithm"
+// @Param        alias    query  string  false  "Version alias the device follows instead of the channel's latest (e.g. stable-eu); token scope and policies use the channel of its target"
 // @Param        current  query  string  false  "Current version on device"
-// @Success      200  {object}  map[string]any  "update_available, latest, message"
+// @Param        pin      query  string  false  "Version the device is pinned to (agent pinned_version), offered instead of the channel's or alias's latest even when older; nothing is offered while it is unknown, recalled or quarantined"
+// @Param        device_id  query  string  false  "Device ID, recorded in the device event log"
+// @Param        backend  query  string  false  "Agent install backend (binary|deb|rpm); only releases of that artifact format are offered (the channel's newest one in the format when its latest is another), and it is recorded in the device event log"
+// @Param        region   query  string  false  "Device region (e.g. eu), selects region-pinned artifact mirrors; derived from the client IP when omitted"
+// @Param        site     query  string  false  "Device site within the region (e.g. hangar-3)"
+// @Param        algo_health   query  string   false  "Algorithm process state (running|crashing|stopped)"
+// @Param        algo_uptime   query  integer  false  "Seconds the algorithm process has been running"
+// @Param        algo_crashes  query  integer  false  "Algorithm crashes within the last hour"
+// @Param        window   query  string  false  "Device's preferred maintenance windows in its local time, comma-separated (e.g. 01:00-05:00); updates are offered only inside them unless a server-side window is set"
+// @Param        tz       query  string  false  "Time zone of window (IANA name or UTC offset such as +08:00), default UTC"
+// @Param        locale   query  string  false  "Device locale for message (e.g. zh-CN), preferred over Accept-Language"
+// @Param        Accept-Language  header  string  false  "Preferred languages for message"
+// @Param        X-Device-Instance  header  string  false  "Hardware-derived instance fingerprint, used to detect duplicated device IDs"
+// @Param        If-None-Match  header  string  false  "ETag of the previous check response; 304 while the decision is unchanged"
+// @Success      200  {object}  map[string]any  "update_available, latest, download_url (closest mirror), download_urls (closest first, origin last), message (localized), message_id, safety, breaking, mandatory, collect_diagnostics; approval, approval_id when a device-group policy withholds the update; pinned (version, plus bisect_id while the device is being bisected) when the device is bisected or pinned by its agent (pin); shadow (id, soak_minutes, release, download_urls) while the device takes part in a shadow deployment; rollback when the device's version was recalled (a recalled latest is not offered); alias (name, version) when following an alias; rollout (id, version, percent) while a progressive rollout holds the channel's latest back from the device; window (windows, timezone, source, next_open) while the device is outside its maintenance window; freeze (id, scope, reason, ends_at) while an update freeze covers the check"
+// @Header       200  {string}  X-Device-ID  "New device ID after a duplicate-ID split"
+// @Header       200  {string}  Content-Language  "Locale of message"
+// @Header       200,304  {string}  ETag  "digest of the response; unchanged while the channel's latest release and the device's decision are"
+// @Success      304  "decision unchanged since the If-None-Match ETag"
 // @Failure      400  {object}  map[string]any
+// @Failure      404  {object}  map[string]any  "unknown alias"
 // @Failure      500  {object}  map[string]any
+// @Failure      403  {object}  map[string]any  "channel outside token scope"
+// @Security     BearerAuth
 // @Router       /api/v1/check [get]
 func (c *FileController) Check(g *gin.Context) {
-	if err := loadStore(); err != nil {
-		log.Printf("loadStore warn: %v", err)
-	}
+	// 仅在 releases.json 被外部修改时重新加载，避免覆盖尚未落盘的批量变更
+	c.p.reloadIfChanged()
 
 	channel := g.DefaultQuery("channel", "stable")
+	app := g.Query("app")
+	if err := checkAppName(app); err != nil {
+		c.ResponseFailure(g, ErrParam, err.Error())
+		return
+	}
+	// 订阅别名的设备跟随别名指向的版本，令牌范围、更新策略与事件都按该版本所在的渠道
+	alias := g.Query("alias")
+	var aliased *Release
+	if alias != "" {
+		var code ErrCode
+		var err error
+		c.p.store.mu.RLock()
+		aliased, code, err = c.p.store.aliasTarget(alias, app)
+		c.p.store.mu.RUnlock()
+		if err != nil {
+			c.ResponseFailure(g, code, err.Error())
+			return
+		}
+		channel = aliased.Channel
+	}
+	if !c.p.allowChannel(g, channel) {
+		return
+	}
 	current := g.Query("current")
-
-	store.mu.RLock()
-	defer store.mu.RUnlock()
-
-	latestVersion, ok := store.LatestByChannel[channel]
+	backend := g.Query("backend")
+	if backend != "" && !artifactFormats[backend] {
+		c.ResponseFailure(g, ErrParam, "invalid backend (want binary, deb or rpm)")
+		return
+	}
+	device, ok := c.p.bindDevice(g, g.Query("device_id"))
 	if !ok {
-		c.ResponseFailure(g, ErrInternal, "no release in channel")
 		return
 	}
+	// 过载时直接返回设备上一次的检查决定
+	cacheKey := c.p.checkCacheKey(g, device, channel, app, current)
+	if c.p.serveCachedCheck(g, cacheKey) {
+		return
+	}
+	started := c.p.clock.Now()
+	device, reassigned := c.p.checkIn(g, device)
+	data := map[string]any{"ip": g.ClientIP(), "backend": backend}
+	if inst := g.GetHeader(instanceHeader); inst != "" {
+		data["instance"] = inst
+	}
+	region, site := c.p.deviceRegion(g)
+	if region != "" {
+		data["region"] = region
+	}
+	if site != "" {
+		data["site"] = site
+	}
+	if alias != "" {
+		data["alias"] = alias
+	}
+	pin := g.Query("pin")
+	if pin != "" {
+		data["pin"] = pin
+	}
+	reported := reportedWindow(g, data)
+	// 算法崩溃时要求 agent 上报诊断，随检查响应下发
+	diagnose := c.p.requestDiagnostics(device, checkHealth(g, data), c.p.clock.Now())
+	ev := &DeviceEvent{
+		DeviceID: device,
+		Type:     "check",
+		Channel:  channel,
+		Version:  current,
+		Data:     data,
+	}
+	if app != "" {
+		// 事件的 version 只表示主应用的版本（设备版本分布、机队健康据此统计），应用的版本记在 data 中
+		ev.Type, ev.Version = "app_check", ""
+		data["app"], data["current"] = app, current
+	}
+	c.p.recordEvent(ev)
+	// 重复 ID 拆分后告诉 agent 改用新 ID
+	if reassigned {
+		g.Header("X-Device-ID", device)
+	}
 
-	latest := store.ReleasesByVersion[latestVersion]
+	d := c.p.decide(checkRequest{device: device, channel: channel, app: app, current: current, pin: pin, format: backend, aliased: aliased, reported: reported}, c.p.principal(g))
+	latest := d.latest
+	// 影子部署只针对主应用，二分定位中的设备不参加
+	var shadow gin.H
+	if app == "" && d.pinned == nil {
+		c.p.store.mu.RLock()
+		if sd, cand := c.p.shadowFor(device, channel, current); sd != nil {
+			shadow = gin.H{
+				"id":            sd.ID,
+				"soak_minutes":  sd.SoakMinutes,
+				"release":       cand,
+				"download_urls": c.p.downloadURLs(region, site, cand, c.p.ExternalURL(g, path.Dir(g.FullPath())+cand.URL)),
+			}
+		}
+		c.p.store.mu.RUnlock()
+	}
+	if latest == nil {
+		resp := gin.H{
+			"update_available":    false,
+			"latest":              nil,
+			"collect_diagnostics": diagnose,
+			"shadow":              shadow,
+		}
+		if d.rollout != nil {
+			resp["rollout"] = d.rollout
+		}
+		c.p.setMessage(g, resp, d.msg, d.vars...)
+		c.p.rememberCheck(g, cacheKey, resp, started)
+		writeCheck(g, resp)
+		return
+	}
 
+	// 同时给出绝对下载地址，反向代理（TLS 终止、路径前缀）后面也能直接使用；
+	// 配置了区域镜像时优先给出离设备最近的镜像
+	urls := c.p.downloadURLs(region, site, latest, c.p.ExternalURL(g, path.Dir(g.FullPath())+latest.URL))
 	resp := gin.H{
-		"update_available": false,
+		"update_available": d.offer,
 		"latest":           latest,
-		"message":          "up to date",
+		"download_url":     urls[0],
+		"download_urls":    urls,
+		// 安全影响提到顶层，agent 无需解析发布说明即可执行安装策略
+		"safety":   latest.SafetyImpact(),
+		"breaking": latest.ReleaseNotes != nil && latest.ReleaseNotes.Breaking,
+		// 强制版本不受设备端电量门限限制
+		"mandatory": latest.Mandatory,
+
+		"collect_diagnostics": diagnose,
+		"shadow":              shadow,
+	}
+	if aliased != nil {
+		resp["alias"] = gin.H{"name": alias, "version": aliased.Version}
+	}
+	if d.rollout != nil {
+		resp["rollout"] = d.rollout
+	}
+	switch {
+	case d.pinned != nil:
+		resp["pinned"] = gin.H{"bisect_id": d.bisectID, "version": d.pinned.Version}
+	case d.devicePin != nil && !d.rollback:
+		resp["pinned"] = gin.H{"version": pin}
+	}
+	if d.rollback {
+		resp["rollback"] = true
+	}
+	if d.freeze != nil {
+		resp["freeze"] = d.freeze
+	}
+	if d.window != nil {
+		resp["window"] = d.window
+	}
+	c.p.setMessage(g, resp, d.msg, d.vars...)
+	if d.held != nil {
+		c.p.holdForApproval(g, resp, d.held)
 	}
+	c.p.rememberCheck(g, cacheKey, resp, started)
+	writeCheck(g, resp)
+}
 
-	if current == "" || isNewer(latest.Version, current) {
-		resp["update_available"] = true
-		resp["message"] = "new version available"
+// List godoc
+// @Summary      List releases
+// @Description  List the releases visible to the caller's token, newest first, with the latest version of each visible channel.
+// @Tags         release
+// @Produce      json
+// @Param        channel  query  string  false  "Only this channel"
+// @Param        app      query  string  false  "Only this application (\"\" lists every app)"
+// @Param        limit    query  int     false  "Page size, default 100, at most 1000"
+// @Param        sort     query  string  false  "created_at, version or channel, - for descending (default -created_at)"
+// @Param        cursor   query  string  false  "next_cursor of the previous page"
+// @Param        total    query  bool    false  "Include the number of matching releases"
+// @Success      200  {object}  map[string]any  "releases, latest_by_channel (keyed <app>/<channel> for apps other than the primary), next_cursor, total"
+// @Failure      400  {object}  map[string]any
+// @Failure      401  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/releases [get]
+func (c *FileController) List(g *gin.Context) {
+	c.p.reloadIfChanged()
+	channel := g.Query("channel")
+	if channel != "" && !c.p.allowChannel(g, channel) {
+		return
 	}
+	app, byApp := g.GetQuery("app")
+	pp, ok := parsePage(c.BaseController, g, releaseList)
+	if !ok {
+		return
+	}
+	pr := c.p.principal(g)
 
-	g.JSON(http.StatusOK, resp)
+	c.p.store.mu.RLock()
+	list := []*Release{}
+	for _, rel := range c.p.store.ReleasesByVersion {
+		if (channel == "" || rel.Channel == channel) && (!byApp || rel.App == app) && pr.CanAccess(rel.Channel) {
+			list = append(list, rel)
+		}
+	}
+	latest := map[string]string{}
+	for key, v := range c.p.store.LatestByChannel {
+		a, ch := splitLatestKey(key)
+		if (channel == "" || ch == channel) && (!byApp || a == app) && pr.CanAccess(ch) {
+			latest[key] = v
+		}
+	}
+	c.p.store.mu.RUnlock()
+
+	page, next, total := paginate(list, releaseList, pp)
+	g.JSON(http.StatusOK, setPage(gin.H{
+		"releases":          page,
+		"latest_by_channel": latest,
+	}, pp, next, total))
+}
+
+// releaseList 是版本列表的分页方式，版本号唯一。
+var releaseList = listSpec[*Release]{
+	id: func(r *Release) string { return r.Version },
+	sorts: map[string]sortField[*Release]{
+		"created_at": {key: func(r *Release) string { return timeKey(r.CreatedAt) }},
+		"version":    {key: func(r *Release) string { return r.Version }, cmp: compareVersions},
+		"channel":    {key: func(r *Release) string { return r.Channel }},
+	},
+	defaultSort: "-created_at",
 }
 
 // Download godoc
 // @Summary      Download the algorithm binary
-// @Description  Download the algorithm binary for a specific version.
+// @Description  Download the algorithm binary for a specific version. Range requests are supported so interrupted downloads can resume; the ETag is the quoted sha256 of the artifact, usable in If-Range.
 // @Tags         release
 // @Produce      application/octet-stream
-// @Param        version  path  string  true  "Version (e.g. 1.1.0)"
+// @Param        version   path    string  true   "Version (e.g. 1.1.0)"
+// @Param        Range     header  string  false  "Byte range to resume from, e.g. bytes=1048576-"
+// @Param        If-Range  header  string  false  "ETag from the first response; a changed artifact is sent in full"
 // @Success      200  {file}  binary
+// @Success      206  {file}  binary
+// @Header       200,206  {string}  ETag  "quoted sha256 of the artifact"
 // @Failure      400  {object}  map[string]any
 // @Failure      404  {object}  map[string]any
+// @Failure      403  {object}  map[string]any  "channel outside token scope"
+// @Security     BearerAuth
 // @Router       /download/{version} [get]
 func (c *FileController) Download(g *gin.Context) {
 	// /download/<version>
@@ -272,15 +752,54 @@ func (c *FileController) Download(g *gin.Context) {
 		return
 	}
 
-	store.mu.RLock()
-	rel, ok := store.ReleasesByVersion[version]
-	store.mu.RUnlock()
+	c.p.store.mu.RLock()
+	rel, ok := c.p.store.ReleasesByVersion[version]
+	channel := ""
+	if ok {
+		// 影子部署的候选版本可由目标渠道的设备下载
+		channel = c.p.downloadChannel(g, rel)
+	}
+	c.p.store.mu.RUnlock()
 	if !ok {
 		c.ResponseFailure(g, ErrParam, "unknown version")
 		return
 	}
+	if !c.p.allowChannel(g, channel) {
+		return
+	}
+
+	a, err := c.p.artifacts.Open(version)
+	if errors.Is(err, errArtifactNotFound) {
+		c.ResponseFailure(g, ErrNotFound, "artifact missing for version "+version)
+		return
+	}
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	defer a.Close()
+
+	// Serve file；ServeContent 处理 Range / If-Range，ETag 取制品 sha256，续传时制品若已重新发布则返回完整内容
+	g.Header("Content-Disposition", "attachment; filename="+strconv.Quote("algorithm"))
+	g.Header("ETag", strconv.Quote(rel.Sha256))
+	http.ServeContent(g.Writer, g.Request, "algorithm", a.ModTime(), a)
+	c.p.recordDownload(g, rel)
+}
 
-	// Serve file
-	g.Header("Content-Disposition", "attachment; filename="+strconv.Quote(filepath.Base(rel.FilePath)))
-	g.File(rel.FilePath)
+// Healthz godoc
+// @Summary      Health check
+// @Description  Report liveness, whether the server is in read-only mode and, with overload detection enabled, the current load (overloaded, in_flight, check_latency_ms).
+// @Tags         system
+// @Produce      json
+// @Success      200  {object}  map[string]any  "status, read_only, load"
+// @Router       /healthz [get]
+func (c *FileController) Healthz(g *gin.Context) {
+	resp := gin.H{
+		"status":    "ok",
+		"read_only": c.p.stillReadOnly(),
+	}
+	if c.p.shedder != nil {
+		resp["load"] = c.p.shedder.status()
+	}
+	g.JSON(http.StatusOK, resp)
 }
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"log"
+	"time"
+)
+
+// store 的落盘策略：
+//   - FlushInterval == 0：每次变更同步写盘（默认，与发布语义一致）；
+//   - FlushInterval > 0：高频、非关键变更（设备上报等）只标记 dirty，由后台按间隔合并写盘，
+//     崩溃时最多丢失一个间隔内的此类变更。发布始终同步写盘，并顺带刷出所有待写变更。
+
+// scheduleSave persists a non-critical mutation, either immediately or at
+// the next flush tick. Callers hold p.store.mu for writing.
+func (p *Platform) scheduleSave() error {
+	if p.flushInterval == 0 {
+		return p.saveStore(p.store)
+	}
+	p.storeDirty.Store(true)
+	p.storeGen.Add(1)
+	return nil
+}
+
+// Start runs the background workers (batch flusher, event compactor) until
+// stop is closed.
+func (p *Platform) Start(stop <-chan struct{}) {
+	if p.flushInterval > 0 {
+		go p.every(stop, p.flushInterval, func() {
+			if err := p.Flush(); err != nil {
+				log.Printf("store flush: %v", err)
+			}
+		})
+	}
+	go p.every(stop, time.Hour, p.compactEvents)
+	go p.every(stop, time.Hour, p.scrubLocations)
+	go p.every(stop, time.Hour, p.expireApprovals)
+	go p.every(stop, time.Minute, p.expireFreezes)
+	go p.every(stop, time.Minute, p.advanceBisections)
+	go p.every(stop, time.Minute, p.advanceRollouts)
+	// 启动时补上当天的版本分布快照，此后每小时检查是否跨天
+	go func() {
+		p.snapshotVersions()
+		p.every(stop, time.Hour, p.snapshotVersions)
+	}()
+	go p.every(stop, time.Minute, func() { p.statusLimit.prune(p.clock.Now()) })
+	go p.every(stop, time.Minute, p.flushBandwidth)
+	if p.shedder != nil {
+		go p.every(stop, time.Minute, func() { p.shedder.prune(p.clock.Now()) })
+	}
+	for _, r := range p.replicas {
+		go p.replicateLoop(stop, r)
+	}
+	if p.tuf != nil {
+		go p.every(stop, time.Hour, p.renewTUF)
+	}
+	if p.mirror != nil {
+		go p.mirrorLoop(stop)
+	}
+	if p.tokens != nil {
+		go p.every(stop, time.Minute, p.expireBreakGlass)
+	}
+	if p.oidc != nil {
+		go p.every(stop, time.Minute, func() { p.oidc.expire(p.clock.Now()) })
+	}
+}
+
+func (p *Platform) every(stop <-chan struct{}, d time.Duration, fn func()) {
+	t := p.clock.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C():
+			fn()
+		}
+	}
+}
+
+// Flush writes pending batched mutations, e.g. on shutdown.
+func (p *Platform) Flush() error {
+	if err := p.events.Sync(); err != nil {
+		return err
+	}
+	if err := p.bandwidth.flush(); err != nil {
+		return err
+	}
+	if !p.storeDirty.Load() {
+		return nil
+	}
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	return p.saveStore(p.store)
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openBatched opens a platform on dir with batched saves; events stay in
+// memory so several platforms can share the directory.
+func openBatched(t *testing.T, dir string) *Platform {
+	t.Helper()
+	p, err := NewPlatform(Options{
+		DataDir:       dir,
+		ArtifactDir:   filepath.Join(dir, "artifacts"),
+		Events:        NewMemoryEventLog(time.Hour),
+		FlushInterval: time.Hour,
+		Fsync:         "never",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func publishTest(t *testing.T, p *Platform, version string) {
+	t.Helper()
+	in := publishInput{Version: version, Channel: "stable", Format: "binary"}
+	if _, _, err := p.publishRelease(in, strings.NewReader("artifact "+version)); err != nil {
+		t.Fatalf("publish %s: %v", version, err)
+	}
+}
+
+func queueApproval(p *Platform, id string) {
+	p.requestApproval(&Approval{
+		ID:          id,
+		DeviceID:    "dev-" + id,
+		Version:     "1.0.0",
+		Channel:     "stable",
+		State:       ApprovalPending,
+		RequestedAt: p.clock.Now(),
+	})
+}
+
+func TestBatchedSaveCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	p := openBatched(t, dir)
+
+	// 发布同步写盘，崩溃后仍在
+	publishTest(t, p, "1.0.0")
+	if rel := openBatched(t, dir).store.ReleasesByVersion["1.0.0"]; rel == nil {
+		t.Fatal("published release lost after a crash")
+	}
+
+	// 批量变更在下一次 flush 之前只在内存中；进程此时崩溃会丢失它
+	queueApproval(p, "a1")
+	if !p.storeDirty.Load() {
+		t.Fatal("batched mutation not marked dirty")
+	}
+	if a := openBatched(t, dir).store.Approvals["a1"]; a != nil {
+		t.Fatal("batched mutation written before the flush")
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if p.storeDirty.Load() {
+		t.Fatal("store still dirty after flush")
+	}
+	if a := openBatched(t, dir).store.Approvals["a1"]; a == nil {
+		t.Fatal("flushed mutation lost")
+	}
+
+	// 发布顺带刷出待写的批量变更
+	queueApproval(p, "a2")
+	publishTest(t, p, "1.1.0")
+	if p.storeDirty.Load() {
+		t.Fatal("store still dirty after publish")
+	}
+	if a := openBatched(t, dir).store.Approvals["a2"]; a == nil {
+		t.Fatal("publish did not write pending batched mutations")
+	}
+}
+
+func TestCrashDuringSaveKeepsIndex(t *testing.T) {
+	dir := t.TempDir()
+	p := openBatched(t, dir)
+	publishTest(t, p, "1.0.0")
+
+	// 写 tmp 时崩溃：releases.json 不受影响，残留的半截 tmp 被下一次保存覆盖
+	tmp := filepath.Join(dir, "releases.json.tmp")
+	if err := os.WriteFile(tmp, []byte(`{"releases_by_version": {"2.0.0"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	q := openBatched(t, dir)
+	if q.store.ReleasesByVersion["1.0.0"] == nil {
+		t.Fatal("index lost after a crash during save")
+	}
+	if q.store.ReleasesByVersion["2.0.0"] != nil {
+		t.Fatal("half-written index loaded")
+	}
+	publishTest(t, q, "1.1.0")
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("tmp file left behind: %v", err)
+	}
+	r := openBatched(t, dir)
+	for _, v := range []string{"1.0.0", "1.1.0"} {
+		if r.store.ReleasesByVersion[v] == nil {
+			t.Errorf("release %s missing after reload", v)
+		}
+	}
+}
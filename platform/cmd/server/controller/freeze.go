@@ -0,0 +1,340 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 更新冻结：监管审计、重大公开活动期间，整个机队或其中一部分需要暂停更新。冻结按范围圈定设备：
+// global（全部设备）、project（一个应用，app 为空即主应用，见 apps.go）、channel（渠道通配）与
+// group（设备 ID 通配，写法与更新策略的设备组相同）。生效期间 /check 与嵌入式更新器轮询不下发非强制版本，
+// 检查响应带 freeze（id、scope、reason、ends_at），也不登记审批；强制版本（安全修复）、二分定位的待测版本
+// 与召回回滚不受影响。hawkBit 兼容层与 /check 走同一条决定路径（见 checkdecision.go），TUF 导出中
+// 被冻结渠道的最新版本不标 latest_in（见 tufrepo.go）。冻结必须有结束时间（最长 maxFreeze），到期自动
+// 解除并写入审计日志，也可以提前解除；starts_at 可以在将来，便于提前安排活动期间的冻结。
+
+const (
+	FreezeGlobal  = "global"
+	FreezeProject = "project"
+	FreezeChannel = "channel"
+	FreezeGroup   = "group"
+)
+
+// maxFreeze 限制一次冻结的时长，忘记解除的冻结不会无限期挡住更新。
+const maxFreeze = 90 * 24 * time.Hour
+
+// Freeze 是一段时间内对一组设备的更新冻结。
+type Freeze struct {
+	ID        string    `json:"id"`
+	Scope     string    `json:"scope"`              // global | project | channel | group
+	App       string    `json:"app,omitempty"`      // project：应用名，为空即主应用
+	Channels  []string  `json:"channels,omitempty"` // channel：渠道通配
+	Devices   []string  `json:"devices,omitempty"`  // group：设备 ID 通配
+	Reason    string    `json:"reason"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+}
+
+func freezeID(scope, reason string, now time.Time) string {
+	sum := sha256.Sum256([]byte(scope + "\x00" + reason + "\x00" + now.String()))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (f *Freeze) active(now time.Time) bool {
+	return !now.Before(f.StartsAt) && now.Before(f.EndsAt)
+}
+
+// covers reports whether a check of app on channel from device falls in the
+// freeze's scope.
+func (f *Freeze) covers(app, channel, device string) bool {
+	switch f.Scope {
+	case FreezeGlobal:
+		return true
+	case FreezeProject:
+		return app == f.App
+	case FreezeChannel:
+		return matchAny(f.Channels, channel)
+	case FreezeGroup:
+		return device != "" && matchAny(f.Devices, device)
+	}
+	return false
+}
+
+// notice is the freeze field of a check response.
+func (f *Freeze) notice() gin.H {
+	return gin.H{"id": f.ID, "scope": f.Scope, "reason": f.Reason, "ends_at": f.EndsAt}
+}
+
+// activeFreeze returns the freeze in effect for the check that lasts longest,
+// nil when updates are not frozen. Callers hold p.store.mu.
+func (p *Platform) activeFreeze(app, channel, device string) *Freeze {
+	now := p.clock.Now()
+	var out *Freeze
+	for _, f := range p.store.Freezes {
+		if f.active(now) && f.covers(app, channel, device) && (out == nil || f.EndsAt.After(out.EndsAt)) {
+			out = f
+		}
+	}
+	return out
+}
+
+// activeFreezeIDs lists the freezes in effect at now, for noticing when one
+// starts or ends.
+func activeFreezeIDs(freezes map[string]*Freeze, now time.Time) string {
+	var ids []string
+	for id, f := range freezes {
+		if f.active(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// expireFreezes drops and audits freezes whose end has passed.
+func (p *Platform) expireFreezes() {
+	now := p.clock.Now()
+	p.store.mu.Lock()
+	prev := p.store.Freezes
+	var ended []*Freeze
+	next := make(map[string]*Freeze, len(prev))
+	for id, f := range prev {
+		if now.Before(f.EndsAt) {
+			next[id] = f
+		} else {
+			ended = append(ended, f)
+		}
+	}
+	var err error
+	if len(ended) > 0 {
+		p.store.Freezes = next
+		if err = p.saveStore(p.store); err != nil {
+			p.store.Freezes = prev
+		}
+	}
+	// 冻结开始或结束时重建 TUF targets，latest_in 随之变化
+	if ids := activeFreezeIDs(p.store.Freezes, now); ids != p.tufFreezes {
+		p.tufFreezes = ids
+		p.refreshTUF(p.store)
+	}
+	p.store.mu.Unlock()
+	if err != nil {
+		log.Printf("save expired freezes: %v", err)
+		return
+	}
+	for _, f := range ended {
+		_ = p.audit("system", "freeze_expired", f.Reason, map[string]any{"id": f.ID, "scope": f.Scope})
+	}
+}
+
+// Freezes godoc
+// @Summary      List update freezes
+// @Description  Current and scheduled freezes, earliest start first; ended freezes are removed (and audited) automatically.
+// @Tags         policy
+// @Produce      json
+// @Success      200  {object}  map[string]any  "freezes (each with active: whether it is in effect now)"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/freezes [get]
+func (c *PolicyController) Freezes(g *gin.Context) {
+	c.p.expireFreezes()
+	now := c.p.clock.Now()
+	type entry struct {
+		*Freeze
+		Active bool `json:"active"`
+	}
+	c.p.store.mu.RLock()
+	out := make([]entry, 0, len(c.p.store.Freezes))
+	for _, f := range c.p.store.Freezes {
+		out = append(out, entry{f, f.active(now)})
+	}
+	c.p.store.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].StartsAt.Equal(out[j].StartsAt) {
+			return out[i].StartsAt.Before(out[j].StartsAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	g.JSON(http.StatusOK, gin.H{"freezes": out})
+}
+
+// CreateFreeze godoc
+// @Summary      Freeze updates
+// @Description  Until ends_at, checks in the scope are not offered new versions (mandatory releases, bisect pins and recall rollbacks excepted); the check response carries freeze (id, scope, reason, ends_at) and the embedded-updater poll answers 204. Scopes: global, project (app; empty is the primary algorithm), channel (channel globs) and group (device ID globs). A freeze lasts at most 90 days and lifts itself when it ends. Audited.
+// @Tags         policy
+// @Accept       json
+// @Produce      json
+// @Param        body  body  object  true  "{\"scope\": \"channel\", \"channels\": [\"stable\"], \"reason\": \"...\", \"starts_at\": \"2026-11-01T00:00:00Z\", \"ends_at\": \"2026-11-03T00:00:00Z\"}; app for project, devices for group; starts_at defaults to now, duration (e.g. 48h) may replace ends_at"
+// @Success      200  {object}  controller.Freeze
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/freezes [post]
+func (c *PolicyController) CreateFreeze(g *gin.Context) {
+	var body struct {
+		Scope    string    `json:"scope"`
+		App      string    `json:"app"`
+		Channels []string  `json:"channels"`
+		Devices  []string  `json:"devices"`
+		Reason   string    `json:"reason"`
+		StartsAt time.Time `json:"starts_at"`
+		EndsAt   time.Time `json:"ends_at"`
+		Duration string    `json:"duration"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	now := c.p.clock.Now()
+	f := &Freeze{Scope: body.Scope, Reason: strings.TrimSpace(body.Reason), StartsAt: body.StartsAt, EndsAt: body.EndsAt,
+		CreatedAt: now, CreatedBy: c.p.principal(g).Name}
+	var problem string
+	switch body.Scope {
+	case FreezeGlobal:
+	case FreezeProject:
+		f.App = body.App
+		if err := checkAppName(f.App); err != nil {
+			problem = err.Error()
+		}
+	case FreezeChannel:
+		f.Channels = body.Channels
+		if len(f.Channels) == 0 {
+			problem = "a channel freeze needs channels"
+		}
+	case FreezeGroup:
+		f.Devices = body.Devices
+		if len(f.Devices) == 0 {
+			problem = "a group freeze needs devices"
+		}
+	default:
+		problem = "invalid scope (want global, project, channel or group)"
+	}
+	for _, pat := range append(append([]string{}, f.Channels...), f.Devices...) {
+		if _, err := path.Match(pat, ""); err != nil && problem == "" {
+			problem = "invalid pattern " + pat
+		}
+	}
+	if f.StartsAt.IsZero() {
+		f.StartsAt = now
+	}
+	if body.Duration != "" && problem == "" {
+		d, err := time.ParseDuration(body.Duration)
+		switch {
+		case err != nil || d <= 0:
+			problem = "invalid duration"
+		case !f.EndsAt.IsZero():
+			problem = "give ends_at or duration, not both"
+		default:
+			f.EndsAt = f.StartsAt.Add(d)
+		}
+	}
+	switch {
+	case problem != "":
+	case f.Reason == "":
+		problem = "reason is required"
+	case f.EndsAt.IsZero():
+		problem = "ends_at or duration is required"
+	case !f.EndsAt.After(f.StartsAt) || !f.EndsAt.After(now):
+		problem = "ends_at must be after starts_at and in the future"
+	case f.EndsAt.Sub(f.StartsAt) > maxFreeze:
+		problem = "a freeze lasts at most " + maxFreeze.String()
+	}
+	if problem != "" {
+		c.ResponseFailure(g, ErrParam, problem)
+		return
+	}
+	f.ID = freezeID(f.Scope, f.Reason, now)
+
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	prev := c.p.store.Freezes
+	next := make(map[string]*Freeze, len(prev)+1)
+	for k, x := range prev {
+		next[k] = x
+	}
+	next[f.ID] = f
+	c.p.store.Freezes = next
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		c.p.store.Freezes = prev
+	} else {
+		c.p.tufFreezes = activeFreezeIDs(next, c.p.clock.Now())
+		c.p.refreshTUF(c.p.store)
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save freeze"), fsErr(err, "save freeze").Error())
+		return
+	}
+	_ = c.p.audit(f.CreatedBy, "freeze_created", f.Reason, map[string]any{"freeze": f})
+	g.JSON(http.StatusOK, f)
+}
+
+// DeleteFreeze godoc
+// @Summary      Lift an update freeze
+// @Description  Ends (or cancels a scheduled) freeze early; the next checks in its scope are offered updates again. Audited.
+// @Tags         policy
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true   "Freeze ID"
+// @Param        body  body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  map[string]any
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/freezes/{id} [delete]
+func (c *PolicyController) DeleteFreeze(g *gin.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&body); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	id := g.Param("id")
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	prev := c.p.store.Freezes
+	f := prev[id]
+	if f == nil {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "no freeze "+id)
+		return
+	}
+	next := make(map[string]*Freeze, len(prev))
+	for k, x := range prev {
+		if k != id {
+			next[k] = x
+		}
+	}
+	c.p.store.Freezes = next
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		c.p.store.Freezes = prev
+	} else {
+		c.p.tufFreezes = activeFreezeIDs(next, c.p.clock.Now())
+		c.p.refreshTUF(c.p.store)
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save freeze"), fsErr(err, "save freeze").Error())
+		return
+	}
+	_ = c.p.audit(c.p.principal(g).Name, "freeze_lifted", strings.TrimSpace(body.Reason), map[string]any{"id": id, "scope": f.Scope})
+	g.JSON(http.StatusOK, gin.H{"deleted": id})
+}
@@ -0,0 +1,377 @@
+package controller
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hawkBit DDI 兼容层：挂载在 /hawkbit/<tenant>/controller/v1/<controllerId>，
+// 便于迁移期间把已有的 hawkBit 客户端（如 SWUpdate suricatta）直接指向本平台。
+// tenant 映射为渠道（DEFAULT 对应 stable），下发什么与 /check 走同一条决定路径（见 checkdecision.go），
+// 冻结、渐进发布、审批、维护时段与二分定位同样生效。action ID 在版本第一次下发时顺序分配并保存在
+// releases.json 中，设备已安装版本取自最近一次成功的 feedback（记为 report 事件）。
+
+type HawkbitController struct {
+	BaseController
+	p *Platform
+
+	// hashes 缓存 sha1/md5（DDI 要求，发布时只计算了 sha256），键为 version|sha256
+	mu     sync.Mutex
+	hashes map[string]artifactHashes
+}
+
+type artifactHashes struct {
+	SHA1   string `json:"sha1"`
+	MD5    string `json:"md5"`
+	SHA256 string `json:"sha256"`
+}
+
+func NewHawkbitController(p *Platform) *HawkbitController {
+	return &HawkbitController{p: p, hashes: map[string]artifactHashes{}}
+}
+
+// hawkbitPollSleep 是下发给客户端的轮询间隔（HH:MM:SS）。
+const hawkbitPollSleep = "00:05:00"
+
+func hawkbitChannel(tenant string) string {
+	if strings.EqualFold(tenant, "default") {
+		return "stable"
+	}
+	return tenant
+}
+
+// actionID returns the action ID of version, assigning the next one the
+// first time the version is offered. IDs stay small for clients that keep
+// them in an int.
+func (c *HawkbitController) actionID(version string) int64 {
+	s := c.p.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.HawkbitActions[version]; ok {
+		return id
+	}
+	var last int64
+	for _, id := range s.HawkbitActions {
+		last = max(last, id)
+	}
+	if s.HawkbitActions == nil {
+		s.HawkbitActions = map[string]int64{}
+	}
+	s.HawkbitActions[version] = last + 1
+	if err := c.p.scheduleSave(); err != nil {
+		log.Printf("save hawkbit action of %s: %v", version, err)
+	}
+	return last + 1
+}
+
+func (c *HawkbitController) base(g *gin.Context) string {
+	return "/hawkbit/" + g.Param("tenant") + "/controller/v1/" + g.Param("controllerId")
+}
+
+// installedVersion 返回设备最近一次成功 feedback 对应的版本。
+func (c *HawkbitController) installedVersion(deviceID string) string {
+	list, err := c.p.events.Query(EventQuery{DeviceID: deviceID, Type: "report", Limit: 50})
+	if err != nil {
+		return ""
+	}
+	for _, ev := range list {
+		if ev.Data["source"] == "hawkbit" && ev.Data["finished"] == "success" {
+			return ev.Version
+		}
+	}
+	return ""
+}
+
+// Scope rejects tenants (channels) outside the caller's token scope and
+// controllers other than the caller's certificate identity; it runs for every
+// DDI route after Authenticate.
+func (c *HawkbitController) Scope(g *gin.Context) {
+	if _, ok := c.p.bindDevice(g, g.Param("controllerId")); ok {
+		c.p.allowChannel(g, hawkbitChannel(g.Param("tenant")))
+	}
+}
+
+// pending 返回设备现在应安装的版本，已是最新或被冻结、渐进发布、审批、维护时段扣下时返回 nil。
+func (c *HawkbitController) pending(g *gin.Context) *Release {
+	c.p.reloadIfChanged()
+	device := g.Param("controllerId")
+	in := checkRequest{device: device, channel: hawkbitChannel(g.Param("tenant")), current: c.installedVersion(device)}
+	if d := c.p.decide(in, c.p.principal(g)); d.offer {
+		return d.latest
+	}
+	return nil
+}
+
+// Poll godoc
+// @Summary      hawkBit DDI: controller base poll
+// @Description  hawkBit-compatible root resource. Returns the polling interval and, when an update is pending, a deploymentBase link. The tenant is used as the channel (DEFAULT = stable).
+// @Tags         hawkbit
+// @Produce      json
+// @Param        tenant        path  string  true  "Tenant (channel)"
+// @Param        controllerId  path  string  true  "Controller (device) ID"
+// @Success      200  {object}  map[string]any  "config, _links"
+// @Security     BearerAuth
+// @Router       /hawkbit/{tenant}/controller/v1/{controllerId} [get]
+func (c *HawkbitController) Poll(g *gin.Context) {
+	// hawkBit 客户端自带控制器 ID，拆分只能在设备侧改配置，这里只做冲突检测
+	device := g.Param("controllerId")
+	c.p.checkIn(g, device)
+	c.p.recordEvent(&DeviceEvent{
+		DeviceID: device,
+		Type:     "check",
+		Channel:  hawkbitChannel(g.Param("tenant")),
+		Version:  c.installedVersion(device),
+		Data:     map[string]any{"ip": g.ClientIP(), "source": "hawkbit"},
+	})
+
+	links := gin.H{}
+	if rel := c.pending(g); rel != nil {
+		href := c.base(g) + "/deploymentBase/" + strconv.FormatInt(c.actionID(rel.Version), 10)
+		links["deploymentBase"] = gin.H{"href": c.p.ExternalURL(g, href)}
+	}
+	g.JSON(http.StatusOK, gin.H{
+		"config": gin.H{"polling": gin.H{"sleep": hawkbitPollSleep}},
+		"_links": links,
+	})
+}
+
+// DeploymentBase godoc
+// @Summary      hawkBit DDI: deployment
+// @Description  Describe the pending deployment (one chunk with the algorithm artifact and its sha1/md5/sha256 hashes).
+// @Tags         hawkbit
+// @Produce      json
+// @Param        tenant        path  string  true  "Tenant (channel)"
+// @Param        controllerId  path  string  true  "Controller (device) ID"
+// @Param        actionId      path  string  true  "Action ID"
+// @Success      200  {object}  map[string]any  "id, deployment"
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /hawkbit/{tenant}/controller/v1/{controllerId}/deploymentBase/{actionId} [get]
+func (c *HawkbitController) DeploymentBase(g *gin.Context) {
+	rel := c.pending(g)
+	if rel == nil || g.Param("actionId") != strconv.FormatInt(c.actionID(rel.Version), 10) {
+		c.ResponseFailure(g, ErrNotFound, "no such action")
+		return
+	}
+	hs, size, err := c.artifactHashes(rel)
+	if errors.Is(err, errArtifactNotFound) {
+		c.ResponseFailure(g, ErrNotFound, "artifact missing for version "+rel.Version)
+		return
+	}
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	dl := c.base(g) + "/softwaremodules/" + g.Param("actionId") + "/artifacts/algorithm"
+	g.JSON(http.StatusOK, gin.H{
+		"id": g.Param("actionId"),
+		"deployment": gin.H{
+			"download": "forced",
+			"update":   "forced",
+			"chunks": []gin.H{{
+				"part":    "os",
+				"name":    "dronealgo",
+				"version": rel.Version,
+				"artifacts": []gin.H{{
+					"filename": "algorithm",
+					"hashes":   hs,
+					"size":     size,
+					"_links": gin.H{
+						"download-http": gin.H{"href": c.p.ExternalURL(g, dl)},
+						"md5sum-http":   gin.H{"href": c.p.ExternalURL(g, dl+".MD5SUM")},
+					},
+				}},
+			}},
+		},
+	})
+}
+
+// hawkbitFeedback 是 DDI feedback 请求体中用到的字段。
+type hawkbitFeedback struct {
+	Status struct {
+		Execution string `json:"execution"` // proceeding | closed | ...
+		Result    struct {
+			Finished string `json:"finished"` // success | failure | none
+		} `json:"result"`
+		Details []string `json:"details"`
+	} `json:"status"`
+}
+
+// Feedback godoc
+// @Summary      hawkBit DDI: deployment feedback
+// @Description  Record the device's progress/result for an action in the device event log. A closed + success feedback marks the version as installed.
+// @Tags         hawkbit
+// @Accept       json
+// @Produce      json
+// @Param        tenant        path  string  true  "Tenant (channel)"
+// @Param        controllerId  path  string  true  "Controller (device) ID"
+// @Param        actionId      path  string  true  "Action ID"
+// @Success      200
+// @Failure      400  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /hawkbit/{tenant}/controller/v1/{controllerId}/deploymentBase/{actionId}/feedback [post]
+func (c *HawkbitController) Feedback(g *gin.Context) {
+	var fb hawkbitFeedback
+	if err := g.ShouldBindJSON(&fb); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid feedback: "+err.Error())
+		return
+	}
+	version := c.versionForAction(g.Param("actionId"))
+	if version == "" {
+		c.ResponseFailure(g, ErrNotFound, "no such action")
+		return
+	}
+	c.p.recordEvent(&DeviceEvent{
+		DeviceID: g.Param("controllerId"),
+		Type:     "report",
+		Channel:  hawkbitChannel(g.Param("tenant")),
+		Version:  version,
+		Data: map[string]any{
+			"source":    "hawkbit",
+			"action_id": g.Param("actionId"),
+			"execution": fb.Status.Execution,
+			"finished":  fb.Status.Result.Finished,
+			"details":   fb.Status.Details,
+		},
+	})
+	g.Status(http.StatusOK)
+}
+
+// ConfigData godoc
+// @Summary      hawkBit DDI: config data
+// @Description  Accept the device's attributes; they are recorded as a device event.
+// @Tags         hawkbit
+// @Accept       json
+// @Param        tenant        path  string  true  "Tenant (channel)"
+// @Param        controllerId  path  string  true  "Controller (device) ID"
+// @Success      200
+// @Failure      400  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /hawkbit/{tenant}/controller/v1/{controllerId}/configData [put]
+func (c *HawkbitController) ConfigData(g *gin.Context) {
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid config data: "+err.Error())
+		return
+	}
+	c.p.recordEvent(&DeviceEvent{
+		DeviceID: g.Param("controllerId"),
+		Type:     "config",
+		Data:     map[string]any{"source": "hawkbit", "attributes": body.Data},
+	})
+	g.Status(http.StatusOK)
+}
+
+// Artifact godoc
+// @Summary      hawkBit DDI: artifact download
+// @Description  Download the algorithm artifact of a software module (Range supported); the .MD5SUM suffix returns an md5sum-style line.
+// @Tags         hawkbit
+// @Produce      application/octet-stream
+// @Param        tenant        path  string  true  "Tenant (channel)"
+// @Param        controllerId  path  string  true  "Controller (device) ID"
+// @Param        moduleId      path  string  true  "Software module ID (same as action ID)"
+// @Param        filename      path  string  true  "algorithm or algorithm.MD5SUM"
+// @Success      200  {file}  binary
+// @Failure      404  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /hawkbit/{tenant}/controller/v1/{controllerId}/softwaremodules/{moduleId}/artifacts/{filename} [get]
+func (c *HawkbitController) Artifact(g *gin.Context) {
+	version := c.versionForAction(g.Param("moduleId"))
+	name := g.Param("filename")
+	if version == "" || (name != "algorithm" && name != "algorithm.MD5SUM") {
+		c.ResponseFailure(g, ErrNotFound, "no such artifact")
+		return
+	}
+	c.p.store.mu.RLock()
+	rel := c.p.store.ReleasesByVersion[version]
+	c.p.store.mu.RUnlock()
+	if rel == nil {
+		// action 仍在，版本已被删除
+		c.ResponseFailure(g, ErrNotFound, "no such artifact")
+		return
+	}
+	if !c.p.allowChannel(g, rel.Channel) {
+		return
+	}
+
+	if name == "algorithm.MD5SUM" {
+		hs, _, err := c.artifactHashes(rel)
+		if err != nil {
+			c.ResponseFailure(g, ErrNotFound, err.Error())
+			return
+		}
+		g.String(http.StatusOK, "%s  algorithm\n", hs.MD5)
+		return
+	}
+
+	a, err := c.p.artifacts.Open(version)
+	if err != nil {
+		c.ResponseFailure(g, ErrNotFound, "artifact missing for version "+version)
+		return
+	}
+	defer a.Close()
+	g.Header("Content-Disposition", "attachment; filename="+strconv.Quote("algorithm"))
+	http.ServeContent(g.Writer, g.Request, "algorithm", a.ModTime(), a)
+	c.p.recordDownload(g, rel)
+}
+
+// versionForAction 反查 action ID 对应的版本。
+func (c *HawkbitController) versionForAction(id string) string {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return ""
+	}
+	c.p.store.mu.RLock()
+	defer c.p.store.mu.RUnlock()
+	for v, a := range c.p.store.HawkbitActions {
+		if a == n {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c *HawkbitController) artifactHashes(rel *Release) (artifactHashes, int64, error) {
+	key := rel.Version + "|" + rel.Sha256
+	a, err := c.p.artifacts.Open(rel.Version)
+	if err != nil {
+		return artifactHashes{}, 0, err
+	}
+	defer a.Close()
+
+	c.mu.Lock()
+	hs, ok := c.hashes[key]
+	c.mu.Unlock()
+	if ok {
+		return hs, a.Size(), nil
+	}
+
+	h1, h5 := sha1.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(h1, h5), a); err != nil {
+		return artifactHashes{}, 0, fmt.Errorf("hash artifact %s: %w", rel.Version, err)
+	}
+	hs = artifactHashes{
+		SHA1:   hex.EncodeToString(h1.Sum(nil)),
+		MD5:    hex.EncodeToString(h5.Sum(nil)),
+		SHA256: rel.Sha256,
+	}
+	c.mu.Lock()
+	c.hashes[key] = hs
+	c.mu.Unlock()
+	return hs, a.Size(), nil
+}
@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 算法运行状况：agent 在 /check 上附带算法进程的状态（algo_health：running | crashing | stopped）、
+// 运行时长与最近一小时的崩溃次数，记入检查事件。机队健康视图据此区分“已是最新且运行正常”与
+// “已是最新但算法反复崩溃”；开启自动诊断时，算法崩溃的设备在检查响应中收到 collect_diagnostics，
+// agent 随即上报一条 diagnostics 事件（自检结果与运行状况），每台设备每小时至多一次。
+
+const (
+	algoRunning  = "running"
+	algoCrashing = "crashing"
+	algoStopped  = "stopped"
+
+	// diagnosticsEvery 限制同一设备被要求上报诊断的频率。
+	diagnosticsEvery = time.Hour
+	// fleetHealthWindow 内检查过的设备计入机队健康视图。
+	fleetHealthWindow = 24 * time.Hour
+)
+
+var algoStates = map[string]bool{algoRunning: true, algoCrashing: true, algoStopped: true}
+
+// checkHealth copies the algorithm health reported with a check into the
+// event data and returns the state; "" when the agent does not report it.
+func checkHealth(g *gin.Context, data map[string]any) string {
+	state := g.Query("algo_health")
+	if !algoStates[state] {
+		return ""
+	}
+	data["algo_health"] = state
+	if n, err := strconv.Atoi(g.Query("algo_uptime")); err == nil && n >= 0 {
+		data["algo_uptime_s"] = n
+	}
+	if n, err := strconv.Atoi(g.Query("algo_crashes")); err == nil && n >= 0 {
+		data["algo_crashes"] = n
+	}
+	return state
+}
+
+// diagRequester remembers when each device was last asked for diagnostics.
+type diagRequester struct {
+	mu    sync.Mutex
+	asked map[string]time.Time
+}
+
+// requestDiagnostics reports whether device should upload diagnostics now.
+func (p *Platform) requestDiagnostics(device, state string, now time.Time) bool {
+	if p.diagnostics == nil || device == "" || state != algoCrashing {
+		return false
+	}
+	d := p.diagnostics
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.asked[device]) < diagnosticsEvery {
+		return false
+	}
+	// 顺带清理早已过期的记录
+	for id, at := range d.asked {
+		if now.Sub(at) >= diagnosticsEvery {
+			delete(d.asked, id)
+		}
+	}
+	d.asked[device] = now
+	return true
+}
+
+// ChannelHealth 是一个渠道最近检查过的设备按更新与运行状况的分布。
+type ChannelHealth struct {
+	Channel string `json:"channel"`
+	Latest  string `json:"latest,omitempty"`
+	Devices int    `json:"devices"`
+	// Healthy：已是最新且算法运行正常；Crashing / Stopped：已是最新但算法崩溃或未运行；
+	// Outdated：尚未更新到渠道最新版本；Unknown：已是最新但 agent 不上报运行状况。
+	Healthy  int `json:"healthy"`
+	Crashing int `json:"crashing"`
+	Stopped  int `json:"stopped"`
+	Outdated int `json:"outdated"`
+	Unknown  int `json:"unknown"`
+}
+
+// UnhealthyDevice 是已是最新版本但算法运行异常的设备。
+type UnhealthyDevice struct {
+	DeviceID  string    `json:"device_id"`
+	Channel   string    `json:"channel"`
+	Version   string    `json:"version"`
+	Health    string    `json:"health"`
+	Crashes   any       `json:"crashes,omitempty"`
+	LastCheck time.Time `json:"last_check"`
+}
+
+// Health godoc
+// @Summary      Fleet update and algorithm health
+// @Description  Devices that checked in within the last 24 hours, per channel: up to date and healthy, up to date but with the algorithm crashing or stopped, outdated, or up to date without health reporting (older agents). Lists the up-to-date devices whose algorithm is not running cleanly, and the devices whose recent installs (last 30 days, at least 3) mostly exceeded the per-phase install duration SLOs.
+// @Tags         device
+// @Produce      json
+// @Param        channel  query  string  false  "Only this channel"
+// @Success      200  {object}  map[string]any  "channels, unhealthy, slow_devices"
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/fleet/health [get]
+func (c *FleetController) Health(g *gin.Context) {
+	now := c.p.clock.Now()
+	evs, err := c.p.events.Query(EventQuery{Type: "check", Since: now.Add(-fleetHealthWindow)})
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	only := g.Query("channel")
+
+	c.p.store.mu.RLock()
+	latest := make(map[string]string, len(c.p.store.LatestByChannel))
+	for ch, v := range c.p.store.LatestByChannel {
+		latest[ch] = v
+	}
+	c.p.store.mu.RUnlock()
+
+	seen := map[string]bool{}
+	byChannel := map[string]*ChannelHealth{}
+	unhealthy := []UnhealthyDevice{}
+	// Query 按时间倒序返回，设备第一次出现即是它最近一次检查
+	for _, ev := range evs {
+		if ev.DeviceID == "" || seen[ev.DeviceID] || (only != "" && ev.Channel != only) {
+			continue
+		}
+		seen[ev.DeviceID] = true
+		h := byChannel[ev.Channel]
+		if h == nil {
+			h = &ChannelHealth{Channel: ev.Channel, Latest: latest[ev.Channel]}
+			byChannel[ev.Channel] = h
+		}
+		h.Devices++
+		if h.Latest != "" && (ev.Version == "" || version.Newer(h.Latest, ev.Version)) {
+			h.Outdated++
+			continue
+		}
+		state, _ := ev.Data["algo_health"].(string)
+		switch state {
+		case algoRunning:
+			h.Healthy++
+			continue
+		case algoCrashing:
+			h.Crashing++
+		case algoStopped:
+			h.Stopped++
+		default:
+			h.Unknown++
+			continue
+		}
+		unhealthy = append(unhealthy, UnhealthyDevice{
+			DeviceID:  ev.DeviceID,
+			Channel:   ev.Channel,
+			Version:   ev.Version,
+			Health:    state,
+			Crashes:   ev.Data["algo_crashes"],
+			LastCheck: ev.Time,
+		})
+	}
+	channels := make([]*ChannelHealth, 0, len(byChannel))
+	for _, ch := range sortedKeys(byChannel) {
+		channels = append(channels, byChannel[ch])
+	}
+	slow, err := c.p.slowDevices(now, only)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"channels": channels, "unhealthy": unhealthy, "slow_devices": slow})
+}
@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 提示语本地化：检查响应中的 message 会显示在驾驶舱 / 地面站界面上，机组不一定读英文。每条提示有稳定的
+// message_id 供程序判断，文本按语言协商选择：设备上报的 locale（agent 配置项）优先，其次 Accept-Language
+// （按 q 值），都不匹配时用英文；协商结果写入 Content-Language 响应头。内置英文与简体中文，
+// -message-catalog 可追加其它语言或覆盖内置文本。某种语言缺少的条目回落到英文。
+
+const (
+	msgNoRelease        = "no_release"
+	msgUpToDate         = "up_to_date"
+	msgNewVersion       = "new_version"
+	msgPinned           = "pinned"
+	msgApprovalNoDevice = "approval_no_device"
+	msgApprovalRejected = "approval_rejected"
+	msgApprovalPending  = "approval_pending"
+	msgRollback         = "rollback"
+	msgRecalled         = "recalled"
+	msgOutsideWindow    = "outside_window"
+	msgFrozen           = "frozen"
+	msgDevicePinned     = "device_pinned"
+	msgPinUnavailable   = "pin_unavailable"
+)
+
+// defaultLocale 是未协商出其它语言时使用的语言，也是缺失条目的回落。
+const defaultLocale = "en"
+
+// MessageCatalog 是 语言标签 -> message_id -> 文本，文本中的 {name} 由调用方填入（如 {version}）。
+type MessageCatalog map[string]map[string]string
+
+var builtinMessages = MessageCatalog{
+	"en": {
+		msgNoRelease:        "no release in channel",
+		msgUpToDate:         "up to date",
+		msgNewVersion:       "new version available",
+		msgPinned:           "pinned to {version} by bisection {bisect_id}",
+		msgApprovalNoDevice: "update requires operator approval; the check carries no device_id",
+		msgApprovalRejected: "update rejected by operator",
+		msgApprovalPending:  "pending approval",
+		msgRollback:         "{version} was recalled; roll back to the previous version",
+		msgRecalled:         "latest release {version} was recalled",
+		msgOutsideWindow:    "{version} waits for the maintenance window opening at {next_open}",
+		msgFrozen:           "updates are frozen until {until}: {reason}",
+		msgDevicePinned:     "device pinned to {version}",
+		msgPinUnavailable:   "pinned version {version} is not available",
+	},
+	"zh": {
+		msgNoRelease:        "该渠道尚无发布版本",
+		msgUpToDate:         "已是最新版本",
+		msgNewVersion:       "有新版本可用",
+		msgPinned:           "二分定位 {bisect_id} 指定版本 {version}",
+		msgApprovalNoDevice: "更新需要操作员批准；本次检查未携带 device_id",
+		msgApprovalRejected: "操作员已拒绝更新",
+		msgApprovalPending:  "等待操作员批准",
+		msgRollback:         "版本 {version} 已被召回，请回滚到上一个版本",
+		msgRecalled:         "最新版本 {version} 已被召回",
+		msgOutsideWindow:    "版本 {version} 等待维护时段，{next_open} 开始",
+		msgFrozen:           "更新冻结至 {until}：{reason}",
+		msgDevicePinned:     "设备已固定到版本 {version}",
+		msgPinUnavailable:   "固定的版本 {version} 不可用",
+	},
+}
+
+// LoadMessageCatalog reads a catalog file: {"<locale>": {"<message_id>": "<text>"}}.
+// Unknown message IDs are rejected so a typo does not silently fall back
+// to English.
+func LoadMessageCatalog(fp string) (MessageCatalog, error) {
+	b, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	var cat MessageCatalog
+	if err := json.Unmarshal(b, &cat); err != nil {
+		return nil, fmt.Errorf("%s: %w", fp, err)
+	}
+	for loc, msgs := range cat {
+		for id := range msgs {
+			if _, ok := builtinMessages[defaultLocale][id]; !ok {
+				return nil, fmt.Errorf("%s: %s: unknown message_id %q", fp, loc, id)
+			}
+		}
+	}
+	return cat, nil
+}
+
+// mergeMessages overlays extra on the built-in catalog, keyed by normalized
+// locale tags.
+func mergeMessages(extra MessageCatalog) MessageCatalog {
+	out := MessageCatalog{}
+	for _, cat := range []MessageCatalog{builtinMessages, extra} {
+		for loc, msgs := range cat {
+			loc = normalizeLocale(loc)
+			if out[loc] == nil {
+				out[loc] = map[string]string{}
+			}
+			for id, text := range msgs {
+				out[loc][id] = text
+			}
+		}
+	}
+	return out
+}
+
+// normalizeLocale lowercases a language tag and uses - as separator: zh_CN -> zh-cn.
+func normalizeLocale(s string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(s), "_", "-"))
+}
+
+func baseLocale(s string) string {
+	base, _, _ := strings.Cut(s, "-")
+	return base
+}
+
+// negotiateLocale picks the catalog locale for the request: the device's
+// locale query parameter first, then Accept-Language by q-value.
+func (p *Platform) negotiateLocale(g *gin.Context) string {
+	wanted := []string{}
+	if l := g.Query("locale"); l != "" {
+		wanted = append(wanted, l)
+	}
+	wanted = append(wanted, acceptLanguages(g.GetHeader("Accept-Language"))...)
+	for _, w := range wanted {
+		if loc := p.matchLocale(normalizeLocale(w)); loc != "" {
+			return loc
+		}
+	}
+	return defaultLocale
+}
+
+// matchLocale finds the catalog locale for tag: the same tag, its base
+// language (zh-tw -> zh), or another region of it (pt -> pt-br).
+func (p *Platform) matchLocale(tag string) string {
+	if _, ok := p.messages[tag]; ok {
+		return tag
+	}
+	base := baseLocale(tag)
+	if _, ok := p.messages[base]; ok {
+		return base
+	}
+	locs := make([]string, 0, len(p.messages))
+	for loc := range p.messages {
+		locs = append(locs, loc)
+	}
+	sort.Strings(locs)
+	for _, loc := range locs {
+		if baseLocale(loc) == base {
+			return loc
+		}
+	}
+	return ""
+}
+
+// acceptLanguages returns the tags of an Accept-Language header, most
+// preferred first; q=0 and * are dropped.
+func acceptLanguages(h string) []string {
+	type tagQ struct {
+		tag string
+		q   float64
+	}
+	var list []tagQ
+	for _, part := range strings.Split(h, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if tag = strings.TrimSpace(tag); tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		list = append(list, tagQ{tag, q})
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].q > list[j].q })
+	out := make([]string, len(list))
+	for i, t := range list {
+		out[i] = t.tag
+	}
+	return out
+}
+
+// setMessage sets message_id and the message text of a response in the
+// negotiated locale; vars are name, value pairs filling {name}.
+func (p *Platform) setMessage(g *gin.Context, resp gin.H, id string, vars ...string) {
+	loc := p.negotiateLocale(g)
+	text, ok := p.messages[loc][id]
+	if !ok {
+		text = p.messages[defaultLocale][id]
+	}
+	pairs := make([]string, 0, len(vars))
+	for i := 0; i+1 < len(vars); i += 2 {
+		pairs = append(pairs, "{"+vars[i]+"}", vars[i+1])
+	}
+	resp["message_id"] = id
+	resp["message"] = strings.NewReplacer(pairs...).Replace(text)
+	g.Header("Content-Language", loc)
+}
@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/oci"
+)
+
+// Mirror 在发布成功后把制品复制到外部仓库，复用其复制与鉴权基础设施。
+// 镜像是尽力而为的：失败只告警，不影响发布本身。
+//
+// 所有推送由一个后台任务依次执行（见 mirrorLoop）：发布与重新签名只推送版本号 tag，渠道与别名的
+// tag 由任务按当前的渠道指针与别名改指——发布、渐进发布、影子晋升、召回、隔离与恢复、别名改指之后
+// 立即同步，此外每 mirrorResyncInterval 核对一次。并发发布因此不会让较旧的版本最后占住渠道 tag。
+// 渠道最新版本被召回或隔离时，渠道 tag 改指该渠道最新的可用版本；删除的别名在镜像中的 tag 保留。
+type Mirror interface {
+	// Push 推送 rel，打上版本号 tag 与 tags；同一版本号再次推送会以新注解覆盖原 manifest。
+	Push(ctx context.Context, rel *Release, a ArtifactReader, tags ...string) error
+	// Tag 让 tags 指向镜像中已推送的 rel；rel 尚未推送时返回 oci.ErrNotFound。
+	Tag(ctx context.Context, rel *Release, tags ...string) error
+	Describe() string
+}
+
+// ociMirror 以 OCI artifact 形式推送：版本号、渠道（应用的渠道为 <app>_<channel>）与
+// 别名（alias-<name>）各一个 tag，manifest 注解带上 version / channel / sha256。
+type ociMirror struct {
+	client *oci.Client
+}
+
+// NewOCIMirror returns a mirror pushing to the registry repository ref
+// ("host[:port]/repo", use http:// for plain-text registries).
+func NewOCIMirror(ref, username, password string) (Mirror, error) {
+	c, err := oci.NewClient(ref, username, password, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ociMirror{client: c}, nil
+}
+
+func (m *ociMirror) Describe() string { return "oci://" + m.client.Ref() }
+
+// invalidTagChars 是 OCI tag 不允许的字符（如 semver 的 +build）。
+var invalidTagChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+func ociTag(s string) string {
+	return invalidTagChars.ReplaceAllString(s, "_")
+}
+
+func (m *ociMirror) Push(ctx context.Context, rel *Release, a ArtifactReader, tags ...string) error {
+	ann := map[string]string{
+		oci.AnnotationVersion: rel.Version,
+		oci.AnnotationCreated: rel.CreatedAt.UTC().Format(time.RFC3339),
+		oci.AnnotationChannel: rel.Channel,
+		oci.AnnotationSha256:  rel.Sha256,
+		oci.AnnotationFormat:  releaseFormat(rel),
+	}
+	if rel.Notes != "" {
+		ann[oci.AnnotationNotes] = rel.Notes
+	}
+	if rel.CosignBundle != nil {
+		ann[oci.AnnotationCosign] = string(rel.CosignBundle)
+	}
+	if n := rel.ReleaseNotes; n != nil {
+		ann[oci.AnnotationSafety] = rel.SafetyImpact()
+		if n.Breaking {
+			ann[oci.AnnotationBreaking] = "true"
+		}
+	}
+	if rel.Mandatory {
+		ann[oci.AnnotationMandatory] = "true"
+	}
+	if rel.Launch != "" {
+		ann[oci.AnnotationLaunch] = rel.Launch
+	}
+	if rel.ArtifactType != "" {
+		ann[oci.AnnotationArtifactType] = rel.ArtifactType
+	}
+	if rel.Signature != "" {
+		ann[oci.AnnotationSignature] = rel.Signature
+		ann[oci.AnnotationKeyID] = rel.KeyID
+	}
+	return m.client.Push(ctx, a, a.Size(), rel.Sha256, ann, append([]string{ociTag(rel.Version)}, tags...)...)
+}
+
+func (m *ociMirror) Tag(ctx context.Context, rel *Release, tags ...string) error {
+	return m.client.Tag(ctx, ociTag(rel.Version), tags...)
+}
+
+const (
+	// mirrorTimeout 限制单次镜像推送的总时长。
+	mirrorTimeout = 10 * time.Minute
+	// mirrorResyncInterval 是核对渠道与别名 tag 的间隔，补上失败的改指。
+	mirrorResyncInterval = 10 * time.Minute
+)
+
+// mirrorQueue 是等待推送的版本与镜像中渠道、别名 tag 已指向的版本。
+type mirrorQueue struct {
+	kick chan struct{}
+
+	mu      sync.Mutex
+	pending []*Release
+	// tags 只由 mirrorLoop 读写；启动时为空，第一轮把所有 tag 重新指一遍
+	tags map[string]string
+}
+
+func newMirrorQueue() *mirrorQueue {
+	return &mirrorQueue{kick: make(chan struct{}, 1), tags: map[string]string{}}
+}
+
+// mirrorRelease queues a published or re-signed release for the mirror.
+func (p *Platform) mirrorRelease(rel *Release) {
+	if p.mirror == nil {
+		return
+	}
+	p.mirrorQ.mu.Lock()
+	p.mirrorQ.pending = append(p.mirrorQ.pending, rel)
+	p.mirrorQ.mu.Unlock()
+	p.kickMirror()
+}
+
+// kickMirror starts a mirror round now, e.g. after a channel pointer, recall
+// or alias changed.
+func (p *Platform) kickMirror() {
+	if p.mirror == nil {
+		return
+	}
+	select {
+	case p.mirrorQ.kick <- struct{}{}:
+	default:
+	}
+}
+
+// mirrorLoop runs mirror rounds whenever kicked and every
+// mirrorResyncInterval, until stop is closed.
+func (p *Platform) mirrorLoop(stop <-chan struct{}) {
+	t := p.clock.NewTicker(mirrorResyncInterval)
+	defer t.Stop()
+	for {
+		p.mirrorRound()
+		select {
+		case <-stop:
+			return
+		case <-t.C():
+		case <-p.mirrorQ.kick:
+		}
+	}
+}
+
+// mirrorRound pushes the queued releases, then points the channel and alias
+// tags at their current targets.
+func (p *Platform) mirrorRound() {
+	q := p.mirrorQ
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+	for _, rel := range pending {
+		if err := p.mirrorPush(rel); err != nil {
+			p.emitAlert("mirror_failed", rel.Version+" to "+p.mirror.Describe()+": "+err.Error())
+			continue
+		}
+		log.Printf("mirrored %s to %s", rel.Version, p.mirror.Describe())
+		// 渠道与别名 tag 各持有一份 manifest，重新推送（如重新签名）后要跟着改指
+		for tag, v := range q.tags {
+			if v == rel.Version {
+				delete(q.tags, tag)
+			}
+		}
+	}
+
+	p.store.mu.RLock()
+	want := p.store.mirrorTags()
+	p.store.mu.RUnlock()
+	for _, tag := range sortedKeys(want) {
+		rel := want[tag]
+		if q.tags[tag] == rel.Version {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+		err := p.mirror.Tag(ctx, rel, tag)
+		cancel()
+		if errors.Is(err, oci.ErrNotFound) {
+			// 版本从未推送过（例如配置镜像之前发布的），连同制品一起推送
+			err = p.mirrorPush(rel, tag)
+		}
+		if err != nil {
+			p.emitAlert("mirror_failed", "tag "+tag+" -> "+rel.Version+" on "+p.mirror.Describe()+": "+err.Error())
+			continue
+		}
+		q.tags[tag] = rel.Version
+		log.Printf("mirror tag %s -> %s on %s", tag, rel.Version, p.mirror.Describe())
+	}
+}
+
+func (p *Platform) mirrorPush(rel *Release, tags ...string) error {
+	a, err := p.artifacts.Open(rel.Version)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	defer cancel()
+	return p.mirror.Push(ctx, rel, a, tags...)
+}
+
+// mirrorTags maps each channel and alias tag of the mirror to the release it
+// should point at: the channel's latest, or its newest release that is
+// neither recalled nor quarantined when the latest is. Callers hold s.mu.
+func (s *Store) mirrorTags() map[string]*Release {
+	out := map[string]*Release{}
+	for key, v := range s.LatestByChannel {
+		rel := s.ReleasesByVersion[v]
+		if rel == nil || rel.Recall != nil || rel.Quarantine != nil {
+			app, channel := splitLatestKey(key)
+			rel = s.ReleasesByVersion[s.newestFormat(app, channel, "")]
+		}
+		if rel != nil {
+			out[ociTag(key)] = rel
+		}
+	}
+	for name, a := range s.Aliases {
+		if rel := s.ReleasesByVersion[a.Version]; rel != nil && rel.Recall == nil && rel.Quarantine == nil {
+			out[ociTag("alias-"+name)] = rel
+		}
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 设备双向 TLS 认证：服务端以 -client-ca 校验客户端证书（TLS 握手时可选提供），证书的 CN 或 SAN
+// 即设备身份。持证书的调用方是 device 角色，渠道范围取 Channels；它在 /check、下载、上报、设备事件
+// 与 hawkBit 端点上只能以自己的身份出现（device_id 缺省即证书身份，不一致时 403）。同时携带设备令牌时
+// 以证书为准；admin 令牌与管理端会话不受影响。Required 时没有证书的设备请求一律 401：
+// 未配置令牌时不再视为匿名管理员，配置了令牌时设备令牌不能代替证书。
+//
+// 吊销由签发侧负责：更换 CA 或缩短证书有效期，服务端只做链校验与有效期检查。
+
+// 证书中取设备身份的字段。
+const (
+	CertIdentityCN  = "cn"  // Subject CommonName
+	CertIdentitySAN = "san" // 第一个 DNS SAN，没有时依次取 URI、email SAN
+)
+
+// ClientCertPolicy 是客户端证书到设备身份的映射。
+type ClientCertPolicy struct {
+	Identity string   // cn（默认）| san
+	Channels []string // 持证书设备可访问的渠道，缺省为全部
+	Required bool     // 设备请求必须携带证书
+}
+
+func checkClientCertPolicy(c *ClientCertPolicy) error {
+	switch c.Identity {
+	case "":
+		c.Identity = CertIdentityCN
+	case CertIdentityCN, CertIdentitySAN:
+	default:
+		return fmt.Errorf("client cert identity %q: want cn or san", c.Identity)
+	}
+	if len(c.Channels) == 0 {
+		c.Channels = []string{"*"}
+	}
+	return nil
+}
+
+// certIdentity returns the device identity carried by cert.
+func (c *ClientCertPolicy) certIdentity(cert *x509.Certificate) string {
+	if c.Identity == CertIdentityCN {
+		return cert.Subject.CommonName
+	}
+	switch {
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	}
+	return ""
+}
+
+// certPrincipal returns the device identified by the request's verified
+// client certificate, or nil.
+func (p *Platform) certPrincipal(g *gin.Context) *Principal {
+	st := g.Request.TLS
+	if p.clientCerts == nil || st == nil || len(st.VerifiedChains) == 0 {
+		return nil
+	}
+	id := p.clientCerts.certIdentity(st.VerifiedChains[0][0])
+	if id == "" {
+		return nil
+	}
+	return &Principal{Name: id, Role: RoleDevice, Channels: p.clientCerts.Channels, Device: id}
+}
+
+// requireCert reports whether device requests must carry a client certificate.
+func (p *Platform) requireCert() bool {
+	return p.clientCerts != nil && p.clientCerts.Required
+}
+
+// bindDevice returns the device ID a request acts as: callers identified by
+// a certificate may only use their own ID (the default when device is empty).
+// It answers 403 and returns false on a mismatch.
+func (p *Platform) bindDevice(g *gin.Context, device string) (string, bool) {
+	pr := p.principal(g)
+	if pr.Device == "" || device == pr.Device {
+		return device, true
+	}
+	if device == "" {
+		return pr.Device, true
+	}
+	BaseController{}.ResponseFailure(g, ErrForbidden, fmt.Sprintf("client certificate of %s cannot act as device %s", pr.Device, device))
+	return "", false
+}
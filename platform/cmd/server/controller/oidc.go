@@ -0,0 +1,652 @@
+package controller
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCConfig 描述管理端的 OIDC 单点登录（Google、Keycloak 等）。
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL 为空时按请求推导为 <外部地址>/auth/oidc/callback。
+	RedirectURL string
+	// GroupsClaim 是 ID token 中的组声明，默认 groups。
+	GroupsClaim string
+	// Roles 把 IdP 组映射到平台角色；以 email: 开头的键（如 email:alice@example.com）映射单个用户，
+	// 只匹配 IdP 已验证（email_verified）的邮箱，不会与组名混淆。
+	Roles map[string]RoleGrant
+	// SessionTTL 是登录会话的有效期，默认 8 小时。
+	SessionTTL time.Duration
+}
+
+// RoleGrant 是一个 IdP 组获得的角色；device 角色可限定渠道。
+type RoleGrant struct {
+	Role     string
+	Channels []string
+}
+
+// ParseRoleMap parses "group=role,group2=device:chan-a|chan-b".
+func ParseRoleMap(s string) (map[string]RoleGrant, error) {
+	out := map[string]RoleGrant{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		group, spec, ok := strings.Cut(item, "=")
+		if !ok || group == "" {
+			return nil, fmt.Errorf("role mapping %q: want group=role", item)
+		}
+		role, chans, _ := strings.Cut(spec, ":")
+		if role != RoleAdmin && role != RoleDevice && role != RoleBreakGlass {
+			return nil, fmt.Errorf("role mapping %q: role must be admin, device or breakglass", item)
+		}
+		g := RoleGrant{Role: role}
+		if chans != "" {
+			g.Channels = strings.Split(chans, "|")
+		}
+		out[group] = g
+	}
+	return out, nil
+}
+
+const (
+	sessionCookie   = "ota_session"
+	oidcStateCookie = "ota_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+	// maxPendingLogins 限制尚未回调的登录数：登录入口不需要认证，不加限制时反复请求会撑满内存。
+	maxPendingLogins = 10000
+)
+
+// oidcLogin 是一次进行中的登录（授权码 + PKCE）。
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	created  time.Time
+}
+
+type adminSession struct {
+	principal *Principal
+	expires   time.Time
+}
+
+type oidcProvider struct {
+	cfg  OIDCConfig
+	http *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey // kid -> key
+	logins    map[string]*oidcLogin       // state -> login
+	sessions  map[string]*adminSession    // 会话 ID 的 sha256 -> 会话
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func newOIDCProvider(cfg OIDCConfig) (*oidcProvider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("oidc needs an issuer and a client ID")
+	}
+	if len(cfg.Roles) == 0 {
+		return nil, errors.New("oidc needs at least one group-to-role mapping")
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = 8 * time.Hour
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	return &oidcProvider{
+		cfg:      cfg,
+		http:     &http.Client{Timeout: 15 * time.Second},
+		keys:     map[string]crypto.PublicKey{},
+		logins:   map[string]*oidcLogin{},
+		sessions: map[string]*adminSession{},
+	}, nil
+}
+
+// discover 首次登录时才拉取发现文档，IdP 暂时不可用不影响服务启动。
+func (o *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	d := o.discovery
+	o.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+	d = &oidcDiscovery{}
+	if err := o.getJSON(ctx, o.cfg.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != o.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", d.Issuer, o.cfg.Issuer)
+	}
+	o.mu.Lock()
+	o.discovery = d
+	o.mu.Unlock()
+	return d, nil
+}
+
+func (o *oidcProvider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err) // crypto/rand 不可用时无法安全继续
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (o *oidcProvider) redirectURL(p *Platform, g *gin.Context) string {
+	if o.cfg.RedirectURL != "" {
+		return o.cfg.RedirectURL
+	}
+	return p.ExternalURL(g, "/auth/oidc/callback")
+}
+
+// key returns the verification key for kid, refetching the JWKS once when
+// the IdP has rotated keys.
+func (o *oidcProvider) key(ctx context.Context, d *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	k, ok := o.keys[kid]
+	o.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jk := range set.Keys {
+		switch jk.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(jk.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(jk.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[jk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, err1 := base64.RawURLEncoding.DecodeString(jk.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(jk.Y)
+			if err1 != nil || err2 != nil || jk.Crv != "P-256" {
+				continue
+			}
+			keys[jk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	o.mu.Lock()
+	o.keys = keys
+	o.mu.Unlock()
+	if k, ok := keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+}
+
+// verifyIDToken checks the JWS signature (RS256 / ES256) and the standard
+// claims, returning the claim set.
+func (o *oidcProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed id_token")
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &hdr) != nil {
+		return nil, errors.New("oidc: malformed id_token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("oidc: malformed id_token signature")
+	}
+	key, err := o.key(ctx, d, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if hdr.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return nil, errors.New("oidc: invalid id_token signature")
+		}
+	case *ecdsa.PublicKey:
+		if hdr.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, errors.New("oidc: invalid id_token signature")
+		}
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("oidc: malformed id_token payload")
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(pb, &claims); err != nil {
+		return nil, errors.New("oidc: malformed id_token payload")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != o.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: id_token issuer %q", iss)
+	}
+	if !audienceContains(claims["aud"], o.cfg.ClientID) {
+		return nil, errors.New("oidc: id_token is not for this client")
+	}
+	exp, _ := claims["exp"].(float64)
+	if now.After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, errors.New("oidc: id_token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("oidc: id_token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// emailVerified reports whether the IdP vouches for the email claim; some
+// IdPs send the flag as a string.
+func emailVerified(claims map[string]any) bool {
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func audienceContains(aud any, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []any:
+		for _, v := range a {
+			if v == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userRolePrefix marks role mappings of a single user's verified email.
+const userRolePrefix = "email:"
+
+// principalFor maps the IdP groups (and the verified email) to the strongest
+// platform role; device grants merge their channels. preferred_username and
+// sub only name the principal, they never select a role.
+func (o *oidcProvider) principalFor(claims map[string]any) (*Principal, error) {
+	email, _ := claims["email"].(string)
+	name := email
+	if name == "" {
+		name, _ = claims["preferred_username"].(string)
+	}
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+	var keys []string
+	if email != "" && emailVerified(claims) {
+		keys = append(keys, userRolePrefix+email)
+	}
+	if list, ok := claims[o.cfg.GroupsClaim].([]any); ok {
+		for _, v := range list {
+			// 组名不能冒充用户映射
+			if s, ok := v.(string); ok && !strings.HasPrefix(s, userRolePrefix) {
+				// Keycloak 的组是路径形式（/ota-admins），两种写法都可用于映射
+				keys = append(keys, s, strings.TrimPrefix(s, "/"))
+			}
+		}
+	}
+	rank := map[string]int{RoleDevice: 1, RoleBreakGlass: 2, RoleAdmin: 3}
+	pr := &Principal{Name: name}
+	for _, k := range keys {
+		g, ok := o.cfg.Roles[k]
+		if !ok {
+			continue
+		}
+		if rank[g.Role] > rank[pr.Role] {
+			pr.Role = g.Role
+		}
+		pr.Channels = append(pr.Channels, g.Channels...)
+	}
+	if pr.Role == "" {
+		return nil, fmt.Errorf("%s is not in any group mapped to a platform role", name)
+	}
+	return pr, nil
+}
+
+// session resolves the session cookie value.
+func (o *oidcProvider) session(id string, now time.Time) *Principal {
+	sum := sha256.Sum256([]byte(id))
+	key := hex.EncodeToString(sum[:])
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := o.sessions[key]
+	if s == nil {
+		return nil
+	}
+	if !now.Before(s.expires) {
+		delete(o.sessions, key)
+		return nil
+	}
+	return s.principal
+}
+
+// begin records a pending login under state. It reports false when
+// maxPendingLogins logins are pending even after dropping the expired ones.
+func (o *oidcProvider) begin(state string, l *oidcLogin) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.logins) >= maxPendingLogins {
+		o.expireLoginsLocked(l.created)
+		if len(o.logins) >= maxPendingLogins {
+			return false
+		}
+	}
+	o.logins[state] = l
+	return true
+}
+
+func (o *oidcProvider) expireLoginsLocked(now time.Time) {
+	for k, l := range o.logins {
+		if now.Sub(l.created) > oidcStateTTL {
+			delete(o.logins, k)
+		}
+	}
+}
+
+// expire drops finished logins and sessions.
+func (o *oidcProvider) expire(now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expireLoginsLocked(now)
+	for k, s := range o.sessions {
+		if !now.Before(s.expires) {
+			delete(o.sessions, k)
+		}
+	}
+}
+
+// authenticateSession accepts the admin UI session cookie. Unsafe methods
+// must come from the same origin, so another site cannot ride the cookie.
+func (p *Platform) authenticateSession(g *gin.Context) bool {
+	if p.oidc == nil {
+		return false
+	}
+	id, err := g.Cookie(sessionCookie)
+	if err != nil || id == "" {
+		return false
+	}
+	pr := p.oidc.session(id, p.clock.Now())
+	if pr == nil {
+		return false
+	}
+	if g.Request.Method != http.MethodGet && g.Request.Method != http.MethodHead {
+		self, _ := url.Parse(p.ExternalURL(g, ""))
+		if origin := g.GetHeader("Origin"); origin != "" && origin != self.Scheme+"://"+self.Host {
+			BaseController{}.ResponseFailure(g, ErrForbidden, "cross-origin request with session cookie")
+			return true
+		}
+	}
+	g.Set(principalKey, pr)
+	return true
+}
+
+type OIDCController struct {
+	BaseController
+	p *Platform
+}
+
+func NewOIDCController(p *Platform) *OIDCController {
+	return &OIDCController{p: p}
+}
+
+func (c *OIDCController) enabled(g *gin.Context) bool {
+	if c.p.oidc == nil {
+		c.ResponseFailure(g, ErrNotFound, "OIDC login is not configured (start the server with -oidc-issuer)")
+		return false
+	}
+	return true
+}
+
+// Login godoc
+// @Summary      OIDC login
+// @Description  Redirect the browser to the identity provider (authorization code flow with PKCE).
+// @Tags         auth
+// @Success      302
+// @Failure      404  {object}  map[string]any
+// @Failure      429  {object}  map[string]any  "too many pending logins"
+// @Failure      502  {object}  map[string]any
+// @Router       /auth/oidc/login [get]
+func (c *OIDCController) Login(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	o := c.p.oidc
+	d, err := o.discover(g.Request.Context())
+	if err != nil {
+		c.ResponseFailure(g, ErrUpstream, err.Error())
+		return
+	}
+	state, l := randomString(24), &oidcLogin{nonce: randomString(24), verifier: randomString(32), created: c.p.clock.Now()}
+	if !o.begin(state, l) {
+		c.ResponseFailure(g, ErrRateLimited, "too many pending logins, try again in a few minutes")
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(l.verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.cfg.ClientID},
+		"redirect_uri":          {o.redirectURL(c.p, g)},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {l.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	// state 同时写入 cookie，回调时比对，防止登录 CSRF
+	g.SetSameSite(http.SameSiteLaxMode)
+	g.SetCookie(oidcStateCookie, state, int(oidcStateTTL/time.Second), "/auth/oidc", "", c.secure(g), true)
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	g.Redirect(http.StatusFound, d.AuthorizationEndpoint+sep+q.Encode())
+}
+
+func (c *OIDCController) secure(g *gin.Context) bool {
+	return strings.HasPrefix(c.p.ExternalURL(g, ""), "https://")
+}
+
+// Callback godoc
+// @Summary      OIDC callback
+// @Description  Exchange the authorization code, verify the ID token, map IdP groups to a platform role and start an admin session (HttpOnly cookie).
+// @Tags         auth
+// @Param        code   query  string  true  "Authorization code"
+// @Param        state  query  string  true  "State from the login redirect"
+// @Success      302
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      502  {object}  map[string]any
+// @Router       /auth/oidc/callback [get]
+func (c *OIDCController) Callback(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	o := c.p.oidc
+	if e := g.Query("error"); e != "" {
+		c.ResponseFailure(g, ErrForbidden, "identity provider: "+e+" "+g.Query("error_description"))
+		return
+	}
+	state := g.Query("state")
+	cookie, _ := g.Cookie(oidcStateCookie)
+	o.mu.Lock()
+	l := o.logins[state]
+	delete(o.logins, state)
+	o.mu.Unlock()
+	if state == "" || cookie != state || l == nil || c.p.clock.Since(l.created) > oidcStateTTL {
+		c.ResponseFailure(g, ErrParam, "unknown or expired login state, start again at /auth/oidc/login")
+		return
+	}
+	d, err := o.discover(g.Request.Context())
+	if err != nil {
+		c.ResponseFailure(g, ErrUpstream, err.Error())
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {g.Query("code")},
+		"redirect_uri":  {o.redirectURL(c.p, g)},
+		"client_id":     {o.cfg.ClientID},
+		"code_verifier": {l.verifier},
+	}
+	req, err := http.NewRequestWithContext(g.Request.Context(), http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(o.cfg.ClientID), url.QueryEscape(o.cfg.ClientSecret))
+	}
+	resp, err := o.http.Do(req)
+	if err != nil {
+		c.ResponseFailure(g, ErrUpstream, "oidc token endpoint: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tok)
+	if resp.StatusCode != http.StatusOK || tok.IDToken == "" {
+		c.ResponseFailure(g, ErrUpstream, "oidc token endpoint: "+resp.Status+" "+tok.Error)
+		return
+	}
+	claims, err := o.verifyIDToken(g.Request.Context(), d, tok.IDToken, l.nonce, c.p.clock.Now())
+	if err != nil {
+		c.ResponseFailure(g, ErrForbidden, err.Error())
+		return
+	}
+	pr, err := o.principalFor(claims)
+	if err != nil {
+		_ = c.p.audit(claimName(claims), "oidc_denied", "", map[string]any{"ip": g.ClientIP()})
+		c.ResponseFailure(g, ErrForbidden, err.Error())
+		return
+	}
+
+	sid := randomString(32)
+	sum := sha256.Sum256([]byte(sid))
+	expires := c.p.clock.Now().Add(o.cfg.SessionTTL)
+	o.mu.Lock()
+	o.sessions[hex.EncodeToString(sum[:])] = &adminSession{principal: pr, expires: expires}
+	o.mu.Unlock()
+	_ = c.p.audit(pr.Name, "oidc_login", "", map[string]any{"role": pr.Role, "ip": g.ClientIP(), "expires_at": expires})
+
+	g.SetSameSite(http.SameSiteLaxMode)
+	g.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", c.secure(g), true)
+	g.SetCookie(sessionCookie, sid, int(o.cfg.SessionTTL/time.Second), "/", "", c.secure(g), true)
+	g.Redirect(http.StatusFound, "/admin/")
+}
+
+func claimName(claims map[string]any) string {
+	if s, _ := claims["email"].(string); s != "" {
+		return s
+	}
+	s, _ := claims["sub"].(string)
+	return s
+}
+
+// Logout godoc
+// @Summary      End the admin session
+// @Tags         auth
+// @Success      302
+// @Router       /auth/logout [get]
+func (c *OIDCController) Logout(g *gin.Context) {
+	if id, err := g.Cookie(sessionCookie); err == nil && c.p.oidc != nil {
+		sum := sha256.Sum256([]byte(id))
+		c.p.oidc.mu.Lock()
+		s := c.p.oidc.sessions[hex.EncodeToString(sum[:])]
+		delete(c.p.oidc.sessions, hex.EncodeToString(sum[:]))
+		c.p.oidc.mu.Unlock()
+		if s != nil {
+			_ = c.p.audit(s.principal.Name, "oidc_logout", "", nil)
+		}
+	}
+	g.SetCookie(sessionCookie, "", -1, "/", "", c.secure(g), true)
+	g.Redirect(http.StatusFound, "/admin/")
+}
+
+// WhoAmI godoc
+// @Summary      Current caller
+// @Description  Name and role of the authenticated caller (token or admin session).
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  map[string]any  "name, role, channels, oidc"
+// @Failure      401  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/whoami [get]
+func (c *OIDCController) WhoAmI(g *gin.Context) {
+	pr := c.p.principal(g)
+	g.JSON(http.StatusOK, gin.H{
+		"name":     pr.Name,
+		"role":     pr.Role,
+		"channels": pr.Channels,
+		"oidc":     c.p.oidc != nil,
+	})
+}
@@ -0,0 +1,39 @@
+package controller
+
+import "testing"
+
+func TestPrincipalForRoleMapping(t *testing.T) {
+	roles, err := ParseRoleMap("ota-admins=admin,partner=device:partner-*,email:alice@example.com=admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &oidcProvider{cfg: OIDCConfig{GroupsClaim: "groups", Roles: roles}}
+	for _, tc := range []struct {
+		name   string
+		claims map[string]any
+		role   string // 空为拒绝
+	}{
+		{"group", map[string]any{"sub": "u1", "groups": []any{"/ota-admins"}}, RoleAdmin},
+		{"verified email", map[string]any{"email": "alice@example.com", "email_verified": true}, RoleAdmin},
+		{"verified email as string", map[string]any{"email": "alice@example.com", "email_verified": "true"}, RoleAdmin},
+		{"unverified email", map[string]any{"email": "alice@example.com", "email_verified": false}, ""},
+		{"email without flag", map[string]any{"email": "alice@example.com"}, ""},
+		// 用户名、sub 与组名相同不能获得组的角色
+		{"username named like a group", map[string]any{"preferred_username": "ota-admins"}, ""},
+		{"sub named like a group", map[string]any{"sub": "ota-admins"}, ""},
+		{"group named like a user mapping", map[string]any{"sub": "u2", "groups": []any{"email:alice@example.com"}}, ""},
+		{"strongest wins", map[string]any{"sub": "u3", "groups": []any{"partner", "ota-admins"}}, RoleAdmin},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pr, err := o.principalFor(tc.claims)
+			switch {
+			case tc.role == "" && err == nil:
+				t.Fatalf("got role %s, want rejection", pr.Role)
+			case tc.role != "" && err != nil:
+				t.Fatalf("rejected: %v", err)
+			case tc.role != "" && pr.Role != tc.role:
+				t.Fatalf("role %s, want %s", pr.Role, tc.role)
+			}
+		})
+	}
+}
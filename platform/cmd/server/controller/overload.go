@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 过载降级：停机恢复后整个机队同时重连时，服务端按两项指标判断过载——同时处理的请求数（下载等长连接
+// 除外）超过 MaxInFlight，或 /check 完整处理的平均耗时（指数滑动平均）超过 MaxCheckLatency。过载期间：
+//   - /check 对最近检查过的设备直接返回 CheckCacheTTL 内缓存的上一次决定（响应头 X-OTA-Degraded: cached），
+//     不再记录检查事件、不做重复 ID 检测；没有缓存的设备照常完整处理。store 的任何变更（发布、召回、隔离、
+//     冻结、渐进发布比例……）都使缓存的决定失效。
+//   - 统计与列表等非关键端点（deferrable）返回 503 与 Retry-After（RetryAfter 加随机抖动），客户端稍后重试。
+//   - 安装上报、设备事件与遥测的接收、下载、发布与管理操作从不降级。
+// 请求数回落到上限的 3/4 以下、平均耗时回落到上限的 3/4 以下（overloadQuiet 内没有完整处理的检查时视为已回落）
+// 后退出过载。进入与退出过载各发出一次告警，/healthz 给出当前状态。
+
+// OverloadConfig 是过载判断与降级的参数；两项上限都为 0 时不判断过载。
+type OverloadConfig struct {
+	MaxInFlight     int           // 同时处理的请求数上限
+	MaxCheckLatency time.Duration // /check 平均耗时上限
+	CheckCacheTTL   time.Duration // 过载时可以直接返回的检查决定的有效期
+	RetryAfter      time.Duration // 非关键端点建议的重试等待
+}
+
+const (
+	// overloadQuiet 内没有完整处理的检查时，检查耗时不再计入过载判断。
+	overloadQuiet = 10 * time.Second
+	// latencyWeight 是指数滑动平均中新样本的权重。
+	latencyWeight = 0.1
+)
+
+// deferrable 是过载时推迟的端点（方法 + 路由）。
+var deferrable = map[string]bool{
+	"GET /status":                            true,
+	"GET /api/v1/releases":                   true,
+	"GET /api/v1/releases/compare":           true,
+	"GET /api/v1/audit":                      true,
+	"GET /api/v1/devices/:id/events":         true,
+	"GET /api/v1/devices/:id/support-bundle": true,
+	"GET /api/v1/devices/conflicts":          true,
+	"GET /api/v1/crashes":                    true,
+	"GET /api/v1/fleet/versions":             true,
+	"GET /api/v1/fleet/adoption":             true,
+	"GET /api/v1/fleet/health":               true,
+	"GET /api/v1/fleet/resources":            true,
+	"GET /api/v1/reports/cost":               true,
+	"GET /api/v1/doctor":                     true,
+	"GET /api/v1/policies":                   true,
+	"GET /api/v1/approvals":                  true,
+	"GET /api/v1/bisections":                 true,
+	"GET /api/v1/shadows":                    true,
+	"GET /api/v1/log/entries":                true,
+}
+
+// uncounted 是不计入同时处理数的长请求：传输时间取决于制品大小与链路，不反映服务端负载；
+// 剖析端点在过载时也必须可用。
+var uncounted = map[string]bool{
+	"/api/v1/download/:version":       true,
+	"/api/v1/export/:version/:format": true,
+	"/debug/pprof/*name":              true,
+	"/hawkbit/:tenant/controller/v1/:controllerId/softwaremodules/:moduleId/artifacts/:filename": true,
+}
+
+// cachedCheck 是一台设备最近一次完整处理的检查决定。
+type cachedCheck struct {
+	resp  gin.H
+	lang  string // Content-Language
+	newID string // 重复 ID 拆分后的新 ID（X-Device-ID）
+	at    time.Time
+}
+
+// loadShedder 跟踪负载并保存检查决定。
+type loadShedder struct {
+	cfg      OverloadConfig
+	inFlight atomic.Int64
+
+	mu        sync.Mutex
+	latency   time.Duration // /check 完整处理耗时的指数滑动平均
+	sampledAt time.Time
+	over      bool
+	since     time.Time
+	shed      uint64 // 本次过载中推迟的请求数
+	cached    uint64 // 本次过载中以缓存回答的检查数
+	checks    map[string]*cachedCheck
+}
+
+func newLoadShedder(cfg OverloadConfig) *loadShedder {
+	if cfg.MaxInFlight <= 0 && cfg.MaxCheckLatency <= 0 {
+		return nil
+	}
+	if cfg.RetryAfter <= 0 {
+		cfg.RetryAfter = 30 * time.Second
+	}
+	if cfg.CheckCacheTTL <= 0 {
+		cfg.CheckCacheTTL = 15 * time.Minute
+	}
+	return &loadShedder{cfg: cfg, checks: map[string]*cachedCheck{}}
+}
+
+// update re-evaluates the overload state with n requests in flight and
+// returns it with the transition, if any ("entered" or "cleared").
+func (s *loadShedder) update(n int64, now time.Time) (over bool, change string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latency := s.latency
+	if now.Sub(s.sampledAt) > overloadQuiet {
+		latency = 0
+	}
+	busy := func(scale float64) bool {
+		return (s.cfg.MaxInFlight > 0 && float64(n) > scale*float64(s.cfg.MaxInFlight)) ||
+			(s.cfg.MaxCheckLatency > 0 && float64(latency) > scale*float64(s.cfg.MaxCheckLatency))
+	}
+	switch {
+	case !s.over && busy(1):
+		s.over, s.since, s.shed, s.cached = true, now, 0, 0
+		change = "entered"
+	case s.over && !busy(0.75):
+		s.over = false
+		change = "cleared"
+	}
+	return s.over, change
+}
+
+// observe feeds the duration of a fully processed check into the average.
+func (s *loadShedder) observe(d time.Duration, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.latency == 0 || now.Sub(s.sampledAt) > overloadQuiet {
+		s.latency = d
+	} else {
+		s.latency = time.Duration(latencyWeight*float64(d) + (1-latencyWeight)*float64(s.latency))
+	}
+	s.sampledAt = now
+}
+
+// status describes the load for /healthz.
+func (s *loadShedder) status() gin.H {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := gin.H{
+		"overloaded":       s.over,
+		"in_flight":        s.inFlight.Load(),
+		"check_latency_ms": s.latency.Milliseconds(),
+	}
+	if s.over {
+		out["since"], out["shed"], out["cached_checks"] = s.since, s.shed, s.cached
+	}
+	return out
+}
+
+// cachedCheck returns a fresh decision for key while overloaded.
+func (s *loadShedder) cachedCheck(key string, now time.Time) *cachedCheck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.checks[key]
+	if !s.over || c == nil || now.Sub(c.at) > s.cfg.CheckCacheTTL {
+		return nil
+	}
+	s.cached++
+	return c
+}
+
+func (s *loadShedder) storeCheck(key string, c *cachedCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[key] = c
+}
+
+// prune drops decisions too old to be served.
+func (s *loadShedder) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, c := range s.checks {
+		if now.Sub(c.at) > s.cfg.CheckCacheTTL {
+			delete(s.checks, k)
+		}
+	}
+}
+
+// ShedLoad tracks requests in flight and, while the server is overloaded,
+// answers deferrable endpoints with 503 and Retry-After.
+func (p *Platform) ShedLoad(g *gin.Context) {
+	s := p.shedder
+	if s == nil || uncounted[g.FullPath()] {
+		return
+	}
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	over, change := s.update(n, p.clock.Now())
+	switch change {
+	case "entered":
+		p.emitAlert("overload", fmt.Sprintf("%d requests in flight, check latency %dms; deferring listings and serving cached check decisions", n, s.status()["check_latency_ms"]))
+	case "cleared":
+		p.emitAlert("overload_cleared", fmt.Sprintf("%d requests in flight", n))
+	}
+	if over && deferrable[g.Request.Method+" "+g.FullPath()] {
+		s.mu.Lock()
+		s.shed++
+		s.mu.Unlock()
+		// 加随机抖动，避免被推迟的客户端同时重试
+		wait := s.cfg.RetryAfter + time.Duration(rand.Int63n(int64(s.cfg.RetryAfter)))
+		g.Header("Retry-After", strconv.Itoa(int(wait/time.Second)))
+		(BaseController{}).ResponseFailure(g, ErrOverloaded, "server is overloaded, retry later")
+		return
+	}
+	g.Next()
+}
+
+// checkCacheKey identifies a check decision: the device, everything in the
+// request the decision depends on and the store generation, so a decision
+// taken before a recall, freeze or publish is never served after it.
+func (p *Platform) checkCacheKey(g *gin.Context, device, channel, app, current string) string {
+	if device == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s", p.storeGen.Load(), device, app, channel, g.Query("alias"), g.Query("pin"), g.Query("backend"), current, g.Query("region"), g.Query("site"), p.negotiateLocale(g))
+}
+
+// serveCachedCheck answers a check from the cache while overloaded.
+func (p *Platform) serveCachedCheck(g *gin.Context, key string) bool {
+	if p.shedder == nil || key == "" {
+		return false
+	}
+	c := p.shedder.cachedCheck(key, p.clock.Now())
+	if c == nil {
+		return false
+	}
+	if c.newID != "" {
+		g.Header("X-Device-ID", c.newID)
+	}
+	g.Header("Content-Language", c.lang)
+	g.Header("X-OTA-Degraded", "cached")
+	writeCheck(g, c.resp)
+	return true
+}
+
+// rememberCheck stores a fully processed check decision and its duration.
+func (p *Platform) rememberCheck(g *gin.Context, key string, resp gin.H, started time.Time) {
+	if p.shedder == nil {
+		return
+	}
+	now := p.clock.Now()
+	p.shedder.observe(now.Sub(started), now)
+	if key == "" {
+		return
+	}
+	p.shedder.storeCheck(key, &cachedCheck{resp: resp, lang: g.Writer.Header().Get("Content-Language"), newID: g.Writer.Header().Get("X-Device-ID"), at: now})
+}
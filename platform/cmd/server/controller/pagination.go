@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 列表分页：版本、设备、事件、审计、崩溃等列表端点共用同一组查询参数，新端点不必各自发明参数。
+//   - limit：每页条数，各端点有缺省值（一般 100），上限 1000。
+//   - sort：排序字段，前缀 - 为降序，可选字段见各端点文档；排序值相同的按记录的唯一标识排序，顺序稳定。
+//   - cursor：上一页响应中的 next_cursor，不透明；翻页时过滤与排序参数须与第一页相同。游标记录上一页最后一条的
+//     排序值与标识而不是偏移量，翻页期间新增或删除记录不会让其余记录重复或被跳过。
+//   - total：为 true 时响应带符合过滤条件的总数；事件等大列表计数需要完整扫描，默认不计。
+// 响应带 next_cursor，没有下一页时为空。过滤参数仍由各端点定义（channel、type、since……），含义在各端点间一致。
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// pageCursor 是游标的内容：排序方式、上一页最后一条的排序值与唯一标识。
+type pageCursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k,omitempty"`
+	ID   string `json:"i"`
+}
+
+// pageParams 是解析后的分页参数。
+type pageParams struct {
+	Limit int
+	Sort  string // 不含 - 前缀
+	Desc  bool
+	After *pageCursor // 第一页为空
+	Total bool
+}
+
+// sortName is the sort as written in the query, e.g. -created_at.
+func (pp pageParams) sortName() string {
+	if pp.Desc {
+		return "-" + pp.Sort
+	}
+	return pp.Sort
+}
+
+// sortField 是一个可排序字段：key 取排序值，cmp 比较两个排序值（为空时按字符串比较）。
+type sortField[T any] struct {
+	key func(T) string
+	cmp func(a, b string) int
+}
+
+// listSpec 描述一个列表端点的分页方式。
+type listSpec[T any] struct {
+	// id 是记录的唯一标识，排序值相同时按它排序
+	id          func(T) string
+	sorts       map[string]sortField[T]
+	defaultSort string // 如 -created_at
+}
+
+// sortNames lists the accepted sort values for error messages.
+func (s listSpec[T]) sortNames() []string {
+	out := make([]string, 0, 2*len(s.sorts))
+	for name := range s.sorts {
+		out = append(out, name, "-"+name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// parsePage reads limit, sort, cursor and total; it answers 400 and
+// returns false when one is invalid.
+func parsePage[T any](c BaseController, g *gin.Context, spec listSpec[T]) (pageParams, bool) {
+	pp := pageParams{Limit: defaultPageLimit}
+	if v := g.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.ResponseFailure(g, ErrParam, "invalid limit")
+			return pp, false
+		}
+		pp.Limit = min(n, maxPageLimit)
+	}
+	s := g.DefaultQuery("sort", spec.defaultSort)
+	pp.Sort, pp.Desc = strings.TrimPrefix(s, "-"), strings.HasPrefix(s, "-")
+	if _, ok := spec.sorts[pp.Sort]; !ok {
+		c.ResponseFailure(g, ErrParam, fmt.Sprintf("invalid sort %q (want one of %s)", s, strings.Join(spec.sortNames(), ", ")))
+		return pp, false
+	}
+	if v := g.Query("cursor"); v != "" {
+		cur, err := decodeCursor(v)
+		if err != nil || cur.Sort != pp.sortName() {
+			c.ResponseFailure(g, ErrParam, "invalid cursor (pass the same sort as for the first page)")
+			return pp, false
+		}
+		pp.After = cur
+	}
+	if v := g.Query("total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid total")
+			return pp, false
+		}
+		pp.Total = b
+	}
+	return pp, true
+}
+
+func encodeCursor(cur pageCursor) string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cur pageCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// paginate sorts items in place and returns the page after the cursor, the
+// cursor of the next page ("" on the last page) and the number of items.
+func paginate[T any](items []T, spec listSpec[T], pp pageParams) (page []T, next string, total int) {
+	f := spec.sorts[pp.Sort]
+	cmp := f.cmp
+	if cmp == nil {
+		cmp = strings.Compare
+	}
+	// order compares by sort value, then by ID, honouring the direction
+	order := func(ka, ia, kb, ib string) int {
+		n := cmp(ka, kb)
+		if n == 0 {
+			n = strings.Compare(ia, ib)
+		}
+		if pp.Desc {
+			n = -n
+		}
+		return n
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return order(f.key(items[i]), spec.id(items[i]), f.key(items[j]), spec.id(items[j])) < 0
+	})
+	start := 0
+	if pp.After != nil {
+		start = sort.Search(len(items), func(i int) bool {
+			return order(f.key(items[i]), spec.id(items[i]), pp.After.Key, pp.After.ID) > 0
+		})
+	}
+	end := min(start+pp.Limit, len(items))
+	page = items[start:end]
+	if page == nil {
+		page = []T{}
+	}
+	if end < len(items) && len(page) > 0 {
+		last := page[len(page)-1]
+		next = encodeCursor(pageCursor{Sort: pp.sortName(), Key: f.key(last), ID: spec.id(last)})
+	}
+	return page, next, len(items)
+}
+
+// setPage adds next_cursor and, when requested, total to a list response.
+func setPage(resp gin.H, pp pageParams, next string, total int) gin.H {
+	resp["next_cursor"] = next
+	if pp.Total {
+		resp["total"] = total
+	}
+	return resp
+}
+
+// timeKey is a sort value for t that orders as a string.
+func timeKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// seqKey is a sort value for n that orders as a string.
+func seqKey(n uint64) string {
+	return fmt.Sprintf("%020d", n)
+}
+
+// compareVersions orders version strings as the update check does.
+func compareVersions(a, b string) int {
+	switch {
+	case version.Newer(a, b):
+		return 1
+	case version.Newer(b, a):
+		return -1
+	}
+	return 0
+}
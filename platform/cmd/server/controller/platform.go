@@ -0,0 +1,517 @@
+package controller
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/cosign"
+)
+
+// Options 描述一个平台实例的全部依赖与策略。零值字段使用默认实现：
+// 数据目录下的 releases.json、制品目录、分段事件日志与系统时钟。
+type Options struct {
+	DataDir     string
+	ArtifactDir string
+
+	Storage   Storage
+	Artifacts ArtifactStore
+	Events    EventLog
+	Clock     Clock
+	Signer    Signer // 可选
+	Mirror    Mirror // 可选：发布后推送到外部仓库
+	// Replicas 是其它区域中制品与元数据的副本（见 replication.go）
+	Replicas []Replica
+	// AcceptReplication 为真时本实例作为副本，接收主区域推送的制品与元数据
+	AcceptReplication bool
+
+	// Cosign 非空时校验发布附带的 cosign 签名包；RequireCosign 拒绝未签名的发布。
+	Cosign        *cosign.Verifier
+	RequireCosign bool
+
+	// FlushInterval > 0 时高频、非关键变更按间隔合并写盘（见 scheduleSave）。
+	FlushInterval time.Duration
+	// Fsync 为 "always"（默认）或 "never"。
+	Fsync          string
+	EventRetention time.Duration
+	AlertWebhook   string
+	TrustedProxies []string
+
+	// DedupWindow 是设备事件去重键的有效期，默认 72 小时。
+	DedupWindow time.Duration
+	// RetentionByType 为单类设备事件设置更短的保留期，不得超过 EventRetention。
+	RetentionByType map[string]time.Duration
+
+	// RAUCCert / RAUCKey 用于 RAUC bundle 导出（需要 rauc 命令行工具），为空时不支持该格式。
+	RAUCCert string
+	RAUCKey  string
+
+	// TUFKey 非空时在 /tuf/ 下导出 TUF 仓库，它是 root 角色的密钥；TUFRoleKeys 按角色名（targets、snapshot、
+	// timestamp）给出其它角色的密钥，缺省的角色使用 TUFKey。TUFDir 非空时元数据同时落盘。
+	TUFKey      ed25519.PrivateKey
+	TUFRoleKeys map[string]ed25519.PrivateKey
+	TUFDir      string
+
+	// LogKey 非空时维护发布透明日志并以它签名树头；文件后端下条目追加到 <DataDir>/transparency.jsonl。
+	LogKey ed25519.PrivateKey
+
+	// Tokens 非空时 API 需要令牌，检查、下载与列表按令牌的渠道范围授权；为空时不鉴权。
+	Tokens []Token
+	// BreakGlassMax 限制应急提权的最长有效期，默认 1 小时。
+	BreakGlassMax time.Duration
+	// OIDC 非空时管理端可经 IdP 单点登录（会话 cookie），IdP 组按映射获得平台角色。
+	OIDC *OIDCConfig
+	// ClientCerts 非空时经 TLS 校验的客户端证书标识设备（见 mtls.go）；证书校验本身在 TLS 层完成。
+	ClientCerts *ClientCertPolicy
+
+	// PublicChannels 是公开状态页 /status 展示的渠道（path.Match 通配），为空时只展示健康状况。
+	PublicChannels []string
+	// Telemetry 是设备遥测的隐私策略（字段删除、IP 匿名化、位置精度与保留期）。
+	Telemetry TelemetryPolicy
+
+	// StatusRate 是 /status 每个客户端 IP 每分钟允许的请求数，默认 60。
+	StatusRate int
+
+	// ApprovalTTL 是待批安装的有效期，过期后设备下一次检查重新登记，默认 7 天。
+	ApprovalTTL time.Duration
+
+	// RegionMirrors 是按区域或站点固定的制品镜像；RegionNetworks 为不上报区域的设备按来源网段推断区域。
+	RegionMirrors  []RegionMirror
+	RegionNetworks []RegionNetwork
+
+	// CostPerGB 是流量费用报表的单价（每 10^9 字节）。
+	CostPerGB float64
+
+	// ArtifactArch 是发布未声明 arch 时 ELF 制品应有的架构（如 arm64），为空时不检查（见 binmeta.go）。
+	ArtifactArch string
+
+	// AutoDiagnostics 为真时，检查时上报算法崩溃的设备会被要求上报一次诊断（每小时至多一次）。
+	AutoDiagnostics bool
+
+	// InstallSLO 是安装各阶段的耗时上限，机队健康视图据此找出慢设备；为空时不判定。
+	InstallSLO map[string]time.Duration
+
+	// Messages 追加或覆盖检查响应提示语的翻译（见 messages.go），为空时只有内置的英文与简体中文。
+	Messages MessageCatalog
+
+	// Compression 控制 JSON 等响应的压缩（见 compress.go），零值不压缩。
+	Compression CompressionConfig
+
+	// Overload 是过载判断与降级的参数（见 overload.go），零值不判断过载。
+	Overload OverloadConfig
+
+	// Profiling 为真时开放 /debug/pprof/ 与剖析采集 API（仅管理员，见 profiling.go）。
+	Profiling bool
+
+	// AlgoLogRetention 是设备上传的算法日志的保留期（见 algolog.go），默认 7 天。
+	AlgoLogRetention time.Duration
+}
+
+// Platform 持有一个 OTA 服务实例的全部状态；控制器通过构造函数注入它，
+// 同一进程内可以并存多个互不干扰的实例。
+type Platform struct {
+	dataDir     string
+	artifactDir string
+
+	store     *Store
+	storage   Storage
+	artifacts ArtifactStore
+	events    EventLog
+	eventKeys *eventDedup
+	clones    *cloneDetector
+	telemetry TelemetryPolicy
+	retention map[string]time.Duration // 事件类型 -> 保留期
+	clock     Clock
+	signer    Signer
+	mirror    Mirror
+	mirrorQ   *mirrorQueue
+	// tufFreezes 是上次重建 TUF 元数据时生效的冻结，变化时重建（见 expireFreezes）
+	tufFreezes string
+
+	replicas          []*replicaState
+	acceptReplication bool
+
+	cosign        *cosign.Verifier
+	requireCosign bool
+
+	flushInterval time.Duration
+	fsync         bool
+	storeDirty    atomic.Bool
+	// storeGen 在每次 store 变更（写盘、批量标记、加载或复制）时递增，过载时的检查决定缓存以它为键的一部分，
+	// 召回、隔离、冻结、发布等变更之后不会再返回变更前的决定。
+	storeGen atomic.Uint64
+
+	// readOnly 在磁盘写满后置位：检查与下载照常服务，发布被拒绝，直到空间恢复。
+	readOnly     atomic.Bool
+	alertWebhook string
+
+	// trustedProxies 是允许设置 X-Forwarded-* 头的反向代理网段，为空时忽略这些头。
+	trustedProxies []*net.IPNet
+
+	tokens      tokenIndex        // 未配置令牌时为 nil
+	clientCerts *ClientCertPolicy // 不认证客户端证书时为 nil
+	breakGlass  *breakGlass
+	auditLog    *auditLog
+	snapshots   *snapshotLog
+	chanHistory *channelHistory
+	bandwidth   *bandwidthLog
+	algoLogs    *algoLogStore
+	resign      resignState
+	profiles    *profileStore // 关闭剖析时为 nil
+	shedder     *loadShedder  // 不判断过载时为 nil
+	// diagnostics 在关闭自动诊断时为 nil
+	diagnostics *diagRequester
+	oidc        *oidcProvider // 未配置 OIDC 时为 nil
+
+	publicChannels []string
+	statusLimit    *rateLimiter
+
+	approvalTTL time.Duration
+
+	regionMirrors []RegionMirror
+	regionNets    []RegionNetwork
+	costPerGB     float64
+	artifactArch  string
+	installSLO    map[string]time.Duration
+	messages      MessageCatalog
+	compression   CompressionConfig
+
+	raucCert, raucKey string
+
+	tuf  *tufRepo    // 未配置 TUF 时为 nil
+	tlog *releaseLog // 未配置透明日志时为 nil
+}
+
+// NewPlatform validates opts and fills in default implementations.
+func NewPlatform(o Options) (*Platform, error) {
+	if o.Fsync == "" {
+		o.Fsync = "always"
+	}
+	if o.Fsync != "always" && o.Fsync != "never" {
+		return nil, errors.New("fsync policy must be always or never")
+	}
+	if o.FlushInterval < 0 {
+		return nil, errors.New("flush interval must not be negative")
+	}
+	if o.RequireCosign && o.Cosign == nil {
+		return nil, errors.New("requiring cosign signatures needs a cosign key or roots")
+	}
+	if o.EventRetention <= 0 {
+		o.EventRetention = defaultEventRetention
+	}
+	for typ, d := range o.RetentionByType {
+		if d > o.EventRetention {
+			return nil, fmt.Errorf("retention for %s events (%s) exceeds the event retention (%s)", typ, d, o.EventRetention)
+		}
+	}
+	p := &Platform{
+		dataDir:     o.DataDir,
+		artifactDir: o.ArtifactDir,
+		store: &Store{
+			ReleasesByVersion: map[string]*Release{},
+			LatestByChannel:   map[string]string{},
+		},
+		storage:           o.Storage,
+		artifacts:         o.Artifacts,
+		events:            o.Events,
+		eventKeys:         newEventDedup(o.DedupWindow),
+		clones:            newCloneDetector(),
+		telemetry:         o.Telemetry,
+		retention:         o.RetentionByType,
+		clock:             o.Clock,
+		signer:            o.Signer,
+		mirror:            o.Mirror,
+		mirrorQ:           newMirrorQueue(),
+		replicas:          newReplicaStates(o.Replicas),
+		acceptReplication: o.AcceptReplication,
+		cosign:            o.Cosign,
+		requireCosign:     o.RequireCosign,
+		flushInterval:     o.FlushInterval,
+		fsync:             o.Fsync == "always",
+		alertWebhook:      o.AlertWebhook,
+		raucCert:          o.RAUCCert,
+		raucKey:           o.RAUCKey,
+	}
+	if p.clock == nil {
+		p.clock = SystemClock()
+	}
+	if p.storage == nil {
+		p.storage = NewFileStorage(filepath.Join(o.DataDir, "releases.json"), p.fsync)
+	}
+	if p.artifacts == nil {
+		p.artifacts = NewFSArtifactStore(o.ArtifactDir, p.fsync)
+	}
+	if p.events == nil {
+		// 批量模式下事件日志也由 flusher 周期性 Sync
+		ev, err := OpenSegmentLog(filepath.Join(o.DataDir, "events"), o.EventRetention,
+			p.fsync && p.flushInterval == 0, p.fsync)
+		if err != nil {
+			return nil, err
+		}
+		p.events = ev
+	}
+	nets, err := parseTrustedProxies(o.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	p.trustedProxies = nets
+	if o.StatusRate <= 0 {
+		o.StatusRate = 60
+	}
+	p.publicChannels, p.statusLimit = o.PublicChannels, newRateLimiter(o.StatusRate)
+	if o.ApprovalTTL <= 0 {
+		o.ApprovalTTL = 7 * 24 * time.Hour
+	}
+	p.approvalTTL = o.ApprovalTTL
+	p.regionMirrors, p.regionNets = o.RegionMirrors, o.RegionNetworks
+	if o.CostPerGB < 0 {
+		return nil, errors.New("cost per GB must not be negative")
+	}
+	p.costPerGB = o.CostPerGB
+	if o.ArtifactArch != "" && !knownArches[o.ArtifactArch] {
+		return nil, errors.New("unknown artifact arch " + o.ArtifactArch)
+	}
+	p.artifactArch = o.ArtifactArch
+	if o.AutoDiagnostics {
+		p.diagnostics = &diagRequester{asked: map[string]time.Time{}}
+	}
+	p.installSLO = o.InstallSLO
+	p.messages = mergeMessages(o.Messages)
+	p.compression = o.Compression
+	p.shedder = newLoadShedder(o.Overload)
+	if o.BreakGlassMax <= 0 {
+		o.BreakGlassMax = time.Hour
+	}
+	p.breakGlass = &breakGlass{max: o.BreakGlassMax, sessions: map[string]*breakGlassSession{}}
+	auditPath := ""
+	if _, ok := p.storage.(*fileStorage); ok {
+		auditPath = filepath.Join(o.DataDir, "audit.jsonl")
+	}
+	if p.auditLog, err = openAuditLog(auditPath, p.fsync); err != nil {
+		return nil, err
+	}
+	snapPath := ""
+	if auditPath != "" {
+		snapPath = filepath.Join(o.DataDir, "version_snapshots.jsonl")
+	}
+	if p.snapshots, err = openSnapshotLog(snapPath, p.fsync); err != nil {
+		return nil, err
+	}
+	histPath := ""
+	if auditPath != "" {
+		histPath = filepath.Join(o.DataDir, "channel_history.jsonl")
+	}
+	if p.chanHistory, err = openChannelHistory(histPath, p.fsync); err != nil {
+		return nil, err
+	}
+	bwPath := ""
+	if auditPath != "" {
+		bwPath = filepath.Join(o.DataDir, "bandwidth.jsonl")
+	}
+	if p.bandwidth, err = openBandwidthLog(bwPath, p.fsync); err != nil {
+		return nil, err
+	}
+	algoLogDir := ""
+	if auditPath != "" {
+		algoLogDir = filepath.Join(o.DataDir, "algo_logs")
+	}
+	if p.algoLogs, err = openAlgoLogStore(algoLogDir, o.AlgoLogRetention, p.fsync); err != nil {
+		return nil, err
+	}
+	if o.Profiling {
+		profDir := ""
+		if auditPath != "" {
+			profDir = filepath.Join(o.DataDir, "profiles")
+		}
+		if p.profiles, err = openProfileStore(profDir); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.Tokens) > 0 {
+		if p.tokens, err = newTokenIndex(o.Tokens); err != nil {
+			return nil, err
+		}
+	}
+	if o.ClientCerts != nil {
+		cc := *o.ClientCerts
+		if err := checkClientCertPolicy(&cc); err != nil {
+			return nil, err
+		}
+		p.clientCerts = &cc
+	}
+	if o.OIDC != nil {
+		if p.oidc, err = newOIDCProvider(*o.OIDC); err != nil {
+			return nil, err
+		}
+		// 只配置 OIDC 时同样开启鉴权，API 只接受登录会话
+		if p.tokens == nil {
+			p.tokens = tokenIndex{}
+		}
+	}
+	if o.TUFKey != nil {
+		if p.tuf, err = newTUFRepo(o.TUFKey, o.TUFRoleKeys, o.TUFDir); err != nil {
+			return nil, err
+		}
+	}
+	if o.LogKey != nil {
+		path := ""
+		if _, ok := p.storage.(*fileStorage); ok {
+			path = filepath.Join(o.DataDir, "transparency.jsonl")
+		}
+		if p.tlog, err = openReleaseLog(o.LogKey, path, p.fsync, p.clock.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Scaffold creates the data/artifact directories and an empty index if none
+// exists yet. Existing metadata is never overwritten.
+func (p *Platform) Scaffold() error {
+	var dirs []string
+	if _, ok := p.artifacts.(*fsArtifactStore); ok {
+		dirs = append(dirs, p.artifactDir)
+	}
+	if _, ok := p.storage.(*fileStorage); ok {
+		dirs = append(dirs, p.dataDir)
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	if _, err := p.storage.Load(); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return p.saveStore(p.store)
+}
+
+// Init loads the index, bootstrapping an empty one on first run.
+func (p *Platform) Init() error {
+	err := p.loadStore()
+	if os.IsNotExist(err) {
+		// 首次部署：没有 releases.json 时自动初始化空 store
+		log.Printf("store %s not found, initializing empty store", p.storage.Describe())
+		err = p.Scaffold()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := p.seedEventKeys(); err != nil {
+		return fmt.Errorf("event dedup keys: %w", err)
+	}
+
+	if p.tuf != nil {
+		if err := p.backfillSizes(); err != nil {
+			return fmt.Errorf("artifact sizes: %w", err)
+		}
+	}
+
+	p.store.mu.RLock()
+	n := len(p.store.ReleasesByVersion)
+	p.refreshTUF(p.store)
+	err = p.backfillLog(p.store)
+	p.store.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("transparency log: %w", err)
+	}
+	if n == 0 {
+		log.Printf("no releases yet, publish one via POST /api/v1/publish")
+	} else {
+		log.Printf("loaded %d releases from %s", n, p.storage.Describe())
+	}
+	return nil
+}
+
+func (p *Platform) loadStore() error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	tmp, err := p.storage.Load()
+	if err != nil {
+		return err
+	}
+	p.adoptStore(tmp)
+	// 启动时加载，或 releases.json 在服务端之外被修改
+	p.recordLatest(ChangeReload, "")
+	return nil
+}
+
+// adoptStore replaces the in-memory index with a loaded or replicated one;
+// callers hold p.store.mu.
+func (p *Platform) adoptStore(tmp *Store) {
+	p.storeGen.Add(1)
+	p.store.ReleasesByVersion = tmp.ReleasesByVersion
+	p.store.LatestByChannel = tmp.LatestByChannel
+	p.store.DeviceSplits = tmp.DeviceSplits
+	p.store.Policies = tmp.Policies
+	p.store.Approvals = tmp.Approvals
+	p.store.Bisections = tmp.Bisections
+	p.store.Shadows = tmp.Shadows
+	p.store.Aliases = tmp.Aliases
+	p.store.Rollouts = tmp.Rollouts
+	p.store.Windows = tmp.Windows
+	p.store.Freezes = tmp.Freezes
+	p.store.HawkbitActions = tmp.HawkbitActions
+}
+
+// saveStore persists s; callers hold p.store.mu.
+func (p *Platform) saveStore(s *Store) error {
+	p.storeGen.Add(1)
+	if err := p.storage.Save(s); err != nil {
+		return err
+	}
+	// 整个 store 已写出，包含所有批量待写的变更
+	p.storeDirty.Store(false)
+	return nil
+}
+
+// reloadIfChanged picks up external edits of the index, but never while
+// batched mutations are still waiting to be flushed.
+func (p *Platform) reloadIfChanged() {
+	p.store.mu.RLock()
+	changed := p.storage.ChangedExternally()
+	p.store.mu.RUnlock()
+	if !changed || p.storeDirty.Load() {
+		return
+	}
+	if err := p.loadStore(); err != nil && !os.IsNotExist(err) {
+		log.Printf("loadStore warn: %v", err)
+	}
+}
+
+// cloneState copies the index maps so a publish can build the next state
+// without touching what readers currently see.
+func (s *Store) cloneState() *Store {
+	next := &Store{
+		ReleasesByVersion: make(map[string]*Release, len(s.ReleasesByVersion)+1),
+		LatestByChannel:   make(map[string]string, len(s.LatestByChannel)+1),
+	}
+	for k, v := range s.ReleasesByVersion {
+		next.ReleasesByVersion[k] = v
+	}
+	for k, v := range s.LatestByChannel {
+		next.LatestByChannel[k] = v
+	}
+	// 发布不修改拆分记录、策略、审批、二分任务、影子部署、别名、渐进发布与维护时段，共用即可
+	next.DeviceSplits = s.DeviceSplits
+	next.Policies, next.Approvals = s.Policies, s.Approvals
+	next.Bisections, next.Shadows = s.Bisections, s.Shadows
+	next.Aliases, next.Rollouts = s.Aliases, s.Rollouts
+	next.Windows = s.Windows
+	next.Freezes = s.Freezes
+	next.HawkbitActions = s.HawkbitActions
+	return next
+}
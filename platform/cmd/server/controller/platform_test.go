@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/clock"
+)
+
+// newMemoryPlatform returns a platform that keeps everything in memory.
+func newMemoryPlatform(t *testing.T, clk Clock) *Platform {
+	t.Helper()
+	st, err := NewMemoryStorage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPlatform(Options{
+		Storage:   st,
+		Artifacts: NewMemoryArtifactStore(),
+		Events:    NewMemoryEventLog(24 * time.Hour),
+		Clock:     clk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPlatformsAreIndependent(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	a := newMemoryPlatform(t, clock.NewFake(start))
+	b := newMemoryPlatform(t, clock.NewFake(start.Add(time.Hour)))
+
+	rel, _, err := a.publishRelease(publishInput{Version: "1.0.0", Channel: "stable", Format: "binary"}, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rel.CreatedAt.Equal(start) {
+		t.Fatalf("created_at = %v, want the platform clock %v", rel.CreatedAt, start)
+	}
+
+	// 发布只进入 a 的 store 与制品存储
+	if b.store.ReleasesByVersion["1.0.0"] != nil {
+		t.Fatal("release visible on another platform")
+	}
+	if _, err := b.artifacts.Open("1.0.0"); err != errArtifactNotFound {
+		t.Fatalf("artifact on another platform: %v", err)
+	}
+	f, err := a.artifacts.Open("1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "payload" || f.Size() != int64(len(data)) {
+		t.Fatalf("artifact = %q (size %d)", data, f.Size())
+	}
+
+	in := checkRequest{device: "d1", channel: "stable"}
+	if d := a.decide(in, nil); d.latest == nil || !d.offer {
+		t.Fatalf("a: decision %+v, want 1.0.0 offered", d)
+	}
+	if d := b.decide(in, nil); d.latest != nil || d.msg != msgNoRelease {
+		t.Fatalf("b: decision %+v, want no release", d)
+	}
+
+	// 设备事件同样按实例隔离
+	if err := a.recordEvent(&DeviceEvent{DeviceID: "d1", Type: "check", Channel: "stable"}); err != nil {
+		t.Fatal(err)
+	}
+	evA, _ := a.events.Query(EventQuery{DeviceID: "d1"})
+	evB, _ := b.events.Query(EventQuery{DeviceID: "d1"})
+	if len(evA) != 1 || len(evB) != 0 {
+		t.Fatalf("events: a has %d, b has %d; want 1 and 0", len(evA), len(evB))
+	}
+}
@@ -0,0 +1,508 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 分级更新策略：策略按设备 ID 与渠道（path.Match 通配）圈定一组设备，依次匹配、先匹配者生效，
+// 未匹配的设备按 auto 处理。auto 自动安装任何新版本；patch 只自动安装同一 MAJOR.MINOR 内的补丁版本；
+// approval 每台设备的每次安装都需操作员批准。需要批准时 /check 不下发更新，而是在审批队列中
+// 登记一条待批记录，批准后该设备的下一次检查即可拿到更新。超过 ApprovalTTL 未处理、或目标版本
+// 已不再是渠道最新版本的待批记录会过期，设备下一次检查时重新登记。
+
+const (
+	PolicyAuto     = "auto"
+	PolicyPatch    = "patch"
+	PolicyApproval = "approval"
+)
+
+var policyModes = map[string]bool{PolicyAuto: true, PolicyPatch: true, PolicyApproval: true}
+
+// UpdatePolicy 是一个设备组的更新策略。
+type UpdatePolicy struct {
+	Name     string   `json:"name"`
+	Devices  []string `json:"devices,omitempty"`  // 设备 ID 通配；为空匹配所有设备
+	Channels []string `json:"channels,omitempty"` // 渠道通配；为空匹配所有渠道
+	Mode     string   `json:"mode"`               // auto | patch | approval
+}
+
+func (pol *UpdatePolicy) matches(device, channel string) bool {
+	return (len(pol.Devices) == 0 || matchAny(pol.Devices, device)) &&
+		(len(pol.Channels) == 0 || matchAny(pol.Channels, channel))
+}
+
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+)
+
+// Approval 是一台设备安装一个版本的审批记录。
+type Approval struct {
+	ID          string     `json:"id"`
+	DeviceID    string     `json:"device_id"`
+	Version     string     `json:"version"`
+	FromVersion string     `json:"from_version,omitempty"`
+	Channel     string     `json:"channel"`
+	Policy      string     `json:"policy"`
+	State       string     `json:"state"` // pending | approved | rejected | expired
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+}
+
+func approvalID(device, version string) string {
+	sum := sha256.Sum256([]byte(device + "\x00" + version))
+	return hex.EncodeToString(sum[:6])
+}
+
+// policyFor returns the first policy matching the device, nil for auto.
+// Callers hold p.store.mu.
+func (p *Platform) policyFor(device, channel string) *UpdatePolicy {
+	for _, pol := range p.store.Policies {
+		if pol.matches(device, channel) {
+			return pol
+		}
+	}
+	return nil
+}
+
+// approvalGate decides whether an available update may be offered. It
+// returns nil when it may, otherwise the approval record the update waits
+// for (a new pending one if none exists yet). Callers hold p.store.mu.
+func (p *Platform) approvalGate(device, channel, current string, latest *Release) *Approval {
+	pol := p.policyFor(device, channel)
+	if pol == nil || pol.Mode == PolicyAuto {
+		return nil
+	}
+	if pol.Mode == PolicyPatch && current != "" && version.SameMinor(latest.Version, current) {
+		return nil
+	}
+	id := approvalID(device, latest.Version)
+	if a := p.store.Approvals[id]; a != nil && a.State != ApprovalExpired {
+		if a.State == ApprovalApproved {
+			return nil
+		}
+		cp := *a
+		return &cp
+	}
+	return &Approval{
+		ID:          id,
+		DeviceID:    device,
+		Version:     latest.Version,
+		FromVersion: current,
+		Channel:     channel,
+		Policy:      pol.Name,
+		State:       ApprovalPending,
+		RequestedAt: p.clock.Now(),
+	}
+}
+
+// requestApproval queues a pending approval returned by approvalGate.
+func (p *Platform) requestApproval(a *Approval) {
+	if a.DeviceID == "" || a.State != ApprovalPending {
+		return
+	}
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	if old := p.store.Approvals[a.ID]; old != nil && old.State != ApprovalExpired {
+		return
+	}
+	if p.store.Approvals == nil {
+		p.store.Approvals = map[string]*Approval{}
+	}
+	p.store.Approvals[a.ID] = a
+	log.Printf("approval %s: %s %s -> %s waits for an operator (policy %s)", a.ID, a.DeviceID, a.FromVersion, a.Version, a.Policy)
+	if err := p.scheduleSave(); err != nil {
+		log.Printf("save approval %s: %v", a.ID, err)
+	}
+}
+
+// holdForApproval rewrites a check response whose update waits for a.
+func (p *Platform) holdForApproval(g *gin.Context, resp gin.H, a *Approval) {
+	resp["update_available"] = false
+	resp["approval_id"] = a.ID
+	switch {
+	case a.DeviceID == "":
+		resp["approval"] = ApprovalPending
+		p.setMessage(g, resp, msgApprovalNoDevice)
+	case a.State == ApprovalRejected:
+		resp["approval"] = ApprovalRejected
+		p.setMessage(g, resp, msgApprovalRejected)
+	default:
+		resp["approval"] = ApprovalPending
+		p.setMessage(g, resp, msgApprovalPending)
+	}
+}
+
+type PolicyController struct {
+	BaseController
+	p *Platform
+}
+
+func NewPolicyController(p *Platform) *PolicyController {
+	return &PolicyController{p: p}
+}
+
+// Policies godoc
+// @Summary      List update policies
+// @Description  Update policies in evaluation order; the first policy matching a device (ID and channel globs) applies, unmatched devices auto-install.
+// @Tags         policy
+// @Produce      json
+// @Success      200  {object}  map[string]any  "policies"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/policies [get]
+func (c *PolicyController) Policies(g *gin.Context) {
+	c.p.store.mu.RLock()
+	defer c.p.store.mu.RUnlock()
+	out := c.p.store.Policies
+	if out == nil {
+		out = []*UpdatePolicy{}
+	}
+	g.JSON(http.StatusOK, gin.H{"policies": out})
+}
+
+// PutPolicy godoc
+// @Summary      Create or replace an update policy
+// @Description  Modes: auto (install any update), patch (auto-install patch-level updates only, others need approval), approval (every install needs operator approval per device). A new policy is appended to the evaluation order; replacing keeps its position.
+// @Tags         policy
+// @Accept       json
+// @Produce      json
+// @Param        name  path  string  true  "Policy name"
+// @Param        body  body  controller.UpdatePolicy  true  "Policy (name is taken from the path)"
+// @Success      200  {object}  controller.UpdatePolicy
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/policies/{name} [put]
+func (c *PolicyController) PutPolicy(g *gin.Context) {
+	var pol UpdatePolicy
+	if err := g.ShouldBindJSON(&pol); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	pol.Name = g.Param("name")
+	if !policyModes[pol.Mode] {
+		c.ResponseFailure(g, ErrParam, "invalid mode (want auto, patch or approval)")
+		return
+	}
+	for _, pat := range append(append([]string{}, pol.Devices...), pol.Channels...) {
+		if _, err := path.Match(pat, ""); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid pattern "+pat)
+			return
+		}
+	}
+
+	c.p.store.mu.Lock()
+	prev := c.p.store.Policies
+	next := make([]*UpdatePolicy, 0, len(prev)+1)
+	replaced := false
+	for _, old := range prev {
+		if old.Name == pol.Name {
+			old, replaced = &pol, true
+		}
+		next = append(next, old)
+	}
+	if !replaced {
+		next = append(next, &pol)
+	}
+	c.p.store.Policies = next
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		c.p.store.Policies = prev
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save policy"), fsErr(err, "save policy").Error())
+		return
+	}
+	_ = c.p.audit(c.p.principal(g).Name, "policy_set", "", map[string]any{"policy": pol})
+	g.JSON(http.StatusOK, pol)
+}
+
+// DeletePolicy godoc
+// @Summary      Delete an update policy
+// @Tags         policy
+// @Produce      json
+// @Param        name  path  string  true  "Policy name"
+// @Success      200  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/policies/{name} [delete]
+func (c *PolicyController) DeletePolicy(g *gin.Context) {
+	name := g.Param("name")
+	c.p.store.mu.Lock()
+	prev := c.p.store.Policies
+	var next []*UpdatePolicy
+	for _, pol := range prev {
+		if pol.Name != name {
+			next = append(next, pol)
+		}
+	}
+	if len(next) == len(prev) {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "no policy "+name)
+		return
+	}
+	c.p.store.Policies = next
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		c.p.store.Policies = prev
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save policy"), fsErr(err, "save policy").Error())
+		return
+	}
+	_ = c.p.audit(c.p.principal(g).Name, "policy_deleted", "", map[string]any{"policy": name})
+	g.JSON(http.StatusOK, gin.H{"deleted": name})
+}
+
+// Approvals godoc
+// @Summary      List install approvals
+// @Description  Installs waiting for (or decided by) an operator, newest request first.
+// @Tags         policy
+// @Produce      json
+// @Param        state  query  string  false  "Only this state (pending|approved|rejected|expired)"
+// @Success      200  {object}  map[string]any  "approvals"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/approvals [get]
+func (c *PolicyController) Approvals(g *gin.Context) {
+	// 列表前先处理过期，操作员看到的待批项都仍然有效
+	c.p.expireApprovals()
+	state := g.Query("state")
+	c.p.store.mu.RLock()
+	out := make([]Approval, 0, len(c.p.store.Approvals))
+	for _, a := range c.p.store.Approvals {
+		if state == "" || a.State == state {
+			out = append(out, *a)
+		}
+	}
+	c.p.store.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].RequestedAt.After(out[j].RequestedAt) })
+	g.JSON(http.StatusOK, gin.H{"approvals": out})
+}
+
+// Decide godoc
+// @Summary      Approve or reject an install
+// @Description  Approving releases the update to the device at its next check; rejecting keeps it withheld. Decisions are recorded in the audit log; expired approvals cannot be decided.
+// @Tags         policy
+// @Accept       json
+// @Produce      json
+// @Param        id        path  string  true   "Approval ID"
+// @Param        decision  path  string  true   "approve | reject"
+// @Param        body      body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  controller.Approval
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/approvals/{id}/{decision} [post]
+func (c *PolicyController) Decide(g *gin.Context) {
+	state, ok := decisionStates[g.Param("decision")]
+	if !ok {
+		c.ResponseFailure(g, ErrParam, "decision must be approve or reject")
+		return
+	}
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&body); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	id := g.Param("id")
+	decided, err := c.p.decideApprovals(func(a *Approval) bool { return a.ID == id }, state,
+		c.p.principal(g).Name, strings.TrimSpace(body.Reason))
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save approval"), fsErr(err, "save approval").Error())
+		return
+	}
+	if len(decided) == 0 {
+		c.p.store.mu.RLock()
+		a := c.p.store.Approvals[id]
+		c.p.store.mu.RUnlock()
+		if a == nil {
+			c.ResponseFailure(g, ErrNotFound, "approval not found")
+		} else {
+			c.ResponseFailure(g, ErrParam, "approval has expired")
+		}
+		return
+	}
+	g.JSON(http.StatusOK, decided[0])
+}
+
+var decisionStates = map[string]string{"approve": ApprovalApproved, "reject": ApprovalRejected}
+
+// BulkDecide godoc
+// @Summary      Approve or reject many pending installs
+// @Description  Decides every pending approval listed in ids, or, without ids, every pending approval matching all given filters (version, channel, policy, device ID globs). At least one selector is required.
+// @Tags         policy
+// @Accept       json
+// @Produce      json
+// @Param        body  body  object  true  "{\"decision\": \"approve\", \"ids\": [], \"version\": \"\", \"channel\": \"\", \"policy\": \"\", \"devices\": [], \"reason\": \"\"}"
+// @Success      200  {object}  map[string]any  "decided"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/approvals/bulk [post]
+func (c *PolicyController) BulkDecide(g *gin.Context) {
+	var body struct {
+		Decision string   `json:"decision"`
+		IDs      []string `json:"ids"`
+		Version  string   `json:"version"`
+		Channel  string   `json:"channel"`
+		Policy   string   `json:"policy"`
+		Devices  []string `json:"devices"`
+		Reason   string   `json:"reason"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	state, ok := decisionStates[body.Decision]
+	if !ok {
+		c.ResponseFailure(g, ErrParam, "decision must be approve or reject")
+		return
+	}
+	// 不允许空选择器，避免误操作一次批准整个队列
+	if len(body.IDs) == 0 && body.Version == "" && body.Channel == "" && body.Policy == "" && len(body.Devices) == 0 {
+		c.ResponseFailure(g, ErrParam, "ids or at least one of version, channel, policy, devices is required")
+		return
+	}
+	for _, pat := range body.Devices {
+		if _, err := path.Match(pat, ""); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid pattern "+pat)
+			return
+		}
+	}
+	ids := map[string]bool{}
+	for _, id := range body.IDs {
+		ids[id] = true
+	}
+	match := func(a *Approval) bool {
+		if len(ids) > 0 {
+			return ids[a.ID]
+		}
+		return (body.Version == "" || a.Version == body.Version) &&
+			(body.Channel == "" || a.Channel == body.Channel) &&
+			(body.Policy == "" || a.Policy == body.Policy) &&
+			(len(body.Devices) == 0 || matchAny(body.Devices, a.DeviceID))
+	}
+	decided, err := c.p.decideApprovals(func(a *Approval) bool { return a.State == ApprovalPending && match(a) },
+		state, c.p.principal(g).Name, strings.TrimSpace(body.Reason))
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save approval"), fsErr(err, "save approval").Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"decided": decided})
+}
+
+// decideApprovals sets state on every non-expired approval selected by pick,
+// persists and audits the decisions, and returns the decided records.
+func (p *Platform) decideApprovals(pick func(*Approval) bool, state, actor, reason string) ([]Approval, error) {
+	p.store.mu.Lock()
+	now := p.clock.Now()
+	prev := map[string]Approval{}
+	for id, a := range p.store.Approvals {
+		if a.State != ApprovalExpired && pick(a) {
+			prev[id] = *a
+			a.State, a.DecidedAt, a.DecidedBy, a.Reason = state, &now, actor, reason
+		}
+	}
+	if len(prev) == 0 {
+		p.store.mu.Unlock()
+		return nil, nil
+	}
+	err := p.saveStore(p.store)
+	decided := make([]Approval, 0, len(prev))
+	for id, old := range prev {
+		if err != nil {
+			*p.store.Approvals[id] = old
+		} else {
+			decided = append(decided, *p.store.Approvals[id])
+		}
+	}
+	p.store.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(decided, func(i, j int) bool { return decided[i].ID < decided[j].ID })
+	for _, a := range decided {
+		_ = p.audit(actor, "approval_"+state, reason, map[string]any{"id": a.ID, "device_id": a.DeviceID, "version": a.Version})
+	}
+	return decided, nil
+}
+
+// expireApprovals expires pending approvals nobody decided within the TTL
+// and those superseded by a newer request of the same device. The channel's
+// latest version is not the test: devices on an alias or a pin ask for other
+// versions.
+func (p *Platform) expireApprovals() {
+	p.store.mu.Lock()
+	now := p.clock.Now()
+	// 每台设备（每个应用）最近一次请求的审批
+	newest := map[string]*Approval{}
+	approvalKey := func(a *Approval) string {
+		app := ""
+		if rel := p.store.ReleasesByVersion[a.Version]; rel != nil {
+			app = rel.App
+		}
+		return a.DeviceID + "\x00" + app
+	}
+	for _, a := range p.store.Approvals {
+		k := approvalKey(a)
+		if n := newest[k]; n == nil || a.RequestedAt.After(n.RequestedAt) {
+			newest[k] = a
+		}
+	}
+	var expired []Approval
+	for _, a := range p.store.Approvals {
+		if a.State != ApprovalPending {
+			continue
+		}
+		switch n := newest[approvalKey(a)]; {
+		case n != a && n.Version != a.Version:
+			a.Reason = "superseded by " + n.Version
+		case now.Sub(a.RequestedAt) > p.approvalTTL:
+			a.Reason = "no decision within " + p.approvalTTL.String()
+		default:
+			continue
+		}
+		a.State, a.DecidedAt, a.DecidedBy = ApprovalExpired, &now, "system"
+		expired = append(expired, *a)
+	}
+	var err error
+	if len(expired) > 0 {
+		err = p.scheduleSave()
+	}
+	p.store.mu.Unlock()
+	if err != nil {
+		log.Printf("save expired approvals: %v", err)
+	}
+	for _, a := range expired {
+		_ = p.audit("system", "approval_expired", a.Reason, map[string]any{"id": a.ID, "device_id": a.DeviceID, "version": a.Version})
+	}
+}
@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/clock"
+)
+
+func TestApprovalExpiresOnPlatformClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	p := newMemoryPlatform(t, clk)
+	queueApproval(p, "a1")
+
+	clk.Advance(p.approvalTTL)
+	p.expireApprovals()
+	if a := p.store.Approvals["a1"]; a.State != ApprovalPending {
+		t.Fatalf("approval %s at the TTL, want pending", a.State)
+	}
+
+	clk.Advance(time.Second)
+	p.expireApprovals()
+	a := p.store.Approvals["a1"]
+	if a.State != ApprovalExpired || a.DecidedAt == nil || !a.DecidedAt.Equal(clk.Now()) {
+		t.Fatalf("approval %s decided at %v, want expired at %v", a.State, a.DecidedAt, clk.Now())
+	}
+}
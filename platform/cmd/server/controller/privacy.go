@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"log"
+	"math"
+	"net"
+	"time"
+)
+
+// TelemetryPolicy 是服务端的遥测隐私策略：事件落盘前删除、匿名化或降低精度，
+// 位置数据还可单独设置比事件本身更短的保留期（数据驻留要求）。
+type TelemetryPolicy struct {
+	// DropFields 是从不存储的事件字段，如 gps、hostname、device_ips。
+	DropFields []string
+	// AnonymizeIP 把 ip / device_ips 截断为网段（IPv4 /24，IPv6 /48）。
+	AnonymizeIP bool
+	// GPSDecimals 大于 0 时把 gps 坐标四舍五入到该小数位；0 表示保持原精度。
+	GPSDecimals int
+	// LocationRetention 大于 0 时，超过该时长的事件中的 gps 字段被抹去。
+	LocationRetention time.Duration
+}
+
+// locationFields 是受 LocationRetention 约束的事件字段。
+var locationFields = []string{"gps"}
+
+// sanitize applies the policy to ev.Data in place before it is stored.
+func (t *TelemetryPolicy) sanitize(ev *DeviceEvent) {
+	if ev.Data == nil {
+		return
+	}
+	for _, f := range t.DropFields {
+		delete(ev.Data, f)
+	}
+	if t.AnonymizeIP {
+		if s, ok := ev.Data["ip"].(string); ok {
+			ev.Data["ip"] = anonymizeIP(s)
+		}
+		if list, ok := ev.Data["device_ips"].([]any); ok {
+			out := make([]any, 0, len(list))
+			for _, v := range list {
+				if s, ok := v.(string); ok {
+					out = append(out, anonymizeIP(s))
+				}
+			}
+			ev.Data["device_ips"] = out
+		}
+	}
+	if t.GPSDecimals > 0 {
+		if fix, ok := ev.Data["gps"].(map[string]any); ok {
+			p := math.Pow(10, float64(t.GPSDecimals))
+			for _, k := range []string{"lat", "lon"} {
+				if v, ok := fix[k].(float64); ok {
+					fix[k] = math.Round(v*p) / p
+				}
+			}
+		}
+	}
+}
+
+// anonymizeIP keeps only the network part of an address.
+func anonymizeIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// scrubLocations erases location fields from events past LocationRetention.
+func (p *Platform) scrubLocations() {
+	if p.telemetry.LocationRetention <= 0 {
+		return
+	}
+	cutoff := p.clock.Now().Add(-p.telemetry.LocationRetention)
+	n, err := p.events.Edit(func(ev *DeviceEvent) EditAction {
+		if !ev.recorded().Before(cutoff) {
+			return EditKeep
+		}
+		changed := EditKeep
+		for _, f := range locationFields {
+			if _, ok := ev.Data[f]; ok {
+				delete(ev.Data, f)
+				changed = EditChanged
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		log.Printf("event log scrub: %v", err)
+	} else if n > 0 {
+		log.Printf("event log scrub: removed location data from %d events", n)
+	}
+}
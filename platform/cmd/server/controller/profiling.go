@@ -0,0 +1,350 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	rpprof "runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 性能剖析：排查大规模集中检查时的 CPU 尖峰，无需重新部署调试版本。
+//   - /debug/pprof/ 是标准的 net/http/pprof 端点（go tool pprof 可直接使用），只对管理员开放；
+//     受服务端 WriteTimeout（15 秒）限制，经它采集的 CPU 剖析与 trace 须短于 15 秒。
+//   - POST /api/v1/profiles 在后台采集一次剖析并保存：cpu 采集 seconds 秒（默认 30，最多 300），
+//     heap、allocs、goroutine 是即时快照。保存在 <DataDir>/profiles/（内存后端下只在内存中），
+//     最多保留 maxStoredProfiles 个，最早的先删除；GET 列出与下载，下载结果可直接交给 go tool pprof。
+// 同一时间只能有一个 CPU 剖析（含 /debug/pprof/profile）。以上端点只在 -pprof 开启时提供（默认关闭，需要 -auth-tokens）。采集写入审计日志。
+
+const (
+	defaultProfileSeconds = 30
+	maxProfileSeconds     = 300
+	maxStoredProfiles     = 20
+
+	ProfileCapturing = "capturing"
+	ProfileDone      = "done"
+	ProfileFailed    = "failed"
+)
+
+// profileTypes 是可采集的剖析类型；cpu 以外都是 runtime/pprof 的即时快照。
+var profileTypes = map[string]bool{"cpu": true, "heap": true, "allocs": true, "goroutine": true}
+
+// Profile 是一次保存的剖析。
+type Profile struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Seconds    int        `json:"seconds,omitempty"` // 只对 cpu 有意义
+	State      string     `json:"state"`             // capturing | done | failed
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	By         string     `json:"by"`
+	Size       int        `json:"size"`
+}
+
+// profileStore 保存采集结果；dir 为空时数据只在内存中。
+type profileStore struct {
+	mu   sync.Mutex
+	dir  string
+	recs []*Profile // 由旧到新
+	data map[string][]byte
+}
+
+func openProfileStore(dir string) (*profileStore, error) {
+	s := &profileStore{dir: dir, data: map[string][]byte{}}
+	if dir == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.recs); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, "index.json"), err)
+	}
+	// 采集中途退出的记录没有数据
+	for _, r := range s.recs {
+		if r.State == ProfileCapturing {
+			r.State, r.Error = ProfileFailed, "server stopped during the capture"
+		}
+	}
+	return s, nil
+}
+
+// list returns copies of the records, newest first.
+func (s *profileStore) list() []Profile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Profile, 0, len(s.recs))
+	for i := len(s.recs) - 1; i >= 0; i-- {
+		out = append(out, *s.recs[i])
+	}
+	return out
+}
+
+// add records a capture in progress, dropping the oldest profiles beyond
+// maxStoredProfiles.
+func (s *profileStore) add(r *Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, x := range s.recs {
+		if x.Type == "cpu" && r.Type == "cpu" && x.State == ProfileCapturing {
+			return errors.New("a CPU profile is already being captured (" + x.ID + ")")
+		}
+	}
+	s.recs = append(s.recs, r)
+	for len(s.recs) > maxStoredProfiles && s.recs[0].State != ProfileCapturing {
+		old := s.recs[0]
+		s.recs = s.recs[1:]
+		delete(s.data, old.ID)
+		if s.dir != "" {
+			_ = os.Remove(filepath.Join(s.dir, old.ID+".pb.gz"))
+		}
+	}
+	if err := s.saveIndex(); err != nil {
+		log.Printf("profiles: %v", err)
+	}
+	return nil
+}
+
+// finish stores the result of a capture.
+func (s *profileStore) finish(r *Profile, data []byte, err error, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil && s.dir != "" {
+		err = os.WriteFile(filepath.Join(s.dir, r.ID+".pb.gz"), data, 0o644)
+	} else if err == nil {
+		s.data[r.ID] = data
+	}
+	r.FinishedAt, r.State, r.Size = &now, ProfileDone, len(data)
+	if err != nil {
+		r.State, r.Error, r.Size = ProfileFailed, err.Error(), 0
+	}
+	if err := s.saveIndex(); err != nil {
+		log.Printf("profiles: %v", err)
+	}
+}
+
+// get returns the record and data of a finished profile.
+func (s *profileStore) get(id string) (*Profile, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.recs {
+		if r.ID != id {
+			continue
+		}
+		if r.State != ProfileDone {
+			return r, nil, nil
+		}
+		if s.dir == "" {
+			return r, s.data[id], nil
+		}
+		b, err := os.ReadFile(filepath.Join(s.dir, id+".pb.gz"))
+		return r, b, err
+	}
+	return nil, nil, nil
+}
+
+// saveIndex persists the records; callers hold s.mu.
+func (s *profileStore) saveIndex() error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	b, _ := json.MarshalIndent(s.recs, "", "  ")
+	return writeFileAtomic(filepath.Join(s.dir, "index.json"), b)
+}
+
+// captureProfile collects the profile described by r.
+func captureProfile(r *Profile) ([]byte, error) {
+	var buf bytes.Buffer
+	if r.Type != "cpu" {
+		if err := rpprof.Lookup(r.Type).WriteTo(&buf, 0); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	if err := rpprof.StartCPUProfile(&buf); err != nil {
+		return nil, err
+	}
+	time.Sleep(time.Duration(r.Seconds) * time.Second)
+	rpprof.StopCPUProfile()
+	return buf.Bytes(), nil
+}
+
+type ProfileController struct {
+	BaseController
+	p *Platform
+}
+
+func NewProfileController(p *Platform) *ProfileController {
+	return &ProfileController{p: p}
+}
+
+func (c *ProfileController) enabled(g *gin.Context) bool {
+	if c.p.profiles == nil {
+		c.ResponseFailure(g, ErrNotFound, "profiling is disabled (start the server with -pprof)")
+		return false
+	}
+	return true
+}
+
+// Pprof serves the net/http/pprof handlers under /debug/pprof/.
+func (c *ProfileController) Pprof(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	switch name := strings.TrimPrefix(g.Param("name"), "/"); name {
+	case "":
+		pprof.Index(g.Writer, g.Request)
+	case "cmdline":
+		pprof.Cmdline(g.Writer, g.Request)
+	case "profile":
+		pprof.Profile(g.Writer, g.Request)
+	case "symbol":
+		pprof.Symbol(g.Writer, g.Request)
+	case "trace":
+		pprof.Trace(g.Writer, g.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(g.Writer, g.Request)
+	}
+}
+
+type captureRequest struct {
+	Type    string `json:"type"`
+	Seconds int    `json:"seconds"`
+}
+
+// Capture godoc
+// @Summary      Capture a profile
+// @Description  Capture a profile of the server in the background and keep it for download: cpu samples for seconds (default 30, at most 300); heap, allocs and goroutine are snapshots. One CPU profile at a time. The last 20 profiles are kept. Audited.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        body  body  object  false  "{\"type\": \"cpu\", \"seconds\": 30}"
+// @Success      202  {object}  controller.Profile
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any  "profiling is disabled"
+// @Security     BearerAuth
+// @Router       /api/v1/profiles [post]
+func (c *ProfileController) Capture(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	var req captureRequest
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&req); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	if req.Type == "" {
+		req.Type = "cpu"
+	}
+	if !profileTypes[req.Type] {
+		c.ResponseFailure(g, ErrParam, "invalid type (want cpu, heap, allocs or goroutine)")
+		return
+	}
+	switch {
+	case req.Type != "cpu":
+		req.Seconds = 0
+	case req.Seconds == 0:
+		req.Seconds = defaultProfileSeconds
+	case req.Seconds < 0 || req.Seconds > maxProfileSeconds:
+		c.ResponseFailure(g, ErrParam, fmt.Sprintf("invalid seconds (1..%d)", maxProfileSeconds))
+		return
+	}
+	now := c.p.clock.Now()
+	r := &Profile{
+		ID:        req.Type + "-" + now.UTC().Format("20060102T150405.000Z"),
+		Type:      req.Type,
+		Seconds:   req.Seconds,
+		State:     ProfileCapturing,
+		StartedAt: now,
+		By:        c.p.principal(g).Name,
+	}
+	if err := c.p.profiles.add(r); err != nil {
+		c.ResponseFailure(g, ErrParam, err.Error())
+		return
+	}
+	_ = c.p.audit(r.By, "profile_capture", "", map[string]any{"id": r.ID, "type": r.Type, "seconds": r.Seconds})
+	snapshot := *r
+	go func() {
+		data, err := captureProfile(r)
+		if err != nil {
+			log.Printf("profile %s: %v", r.ID, err)
+		}
+		c.p.profiles.finish(r, data, err, c.p.clock.Now())
+	}()
+	g.JSON(http.StatusAccepted, &snapshot)
+}
+
+// List godoc
+// @Summary      List captured profiles
+// @Description  Profiles captured with POST /api/v1/profiles, newest first, including those still being captured.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {array}   controller.Profile
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any  "profiling is disabled"
+// @Security     BearerAuth
+// @Router       /api/v1/profiles [get]
+func (c *ProfileController) List(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	g.JSON(http.StatusOK, c.p.profiles.list())
+}
+
+// Download godoc
+// @Summary      Download a profile
+// @Description  The captured profile in pprof format (gzipped protobuf), for go tool pprof.
+// @Tags         admin
+// @Produce      application/octet-stream
+// @Param        id  path  string  true  "Profile ID"
+// @Success      200  {file}    binary
+// @Failure      400  {object}  map[string]any  "still capturing or failed"
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/profiles/{id} [get]
+func (c *ProfileController) Download(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	id := g.Param("id")
+	r, data, err := c.p.profiles.get(id)
+	switch {
+	case r == nil:
+		c.ResponseFailure(g, ErrNotFound, "unknown profile "+id)
+		return
+	case err != nil:
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	case r.State == ProfileCapturing:
+		c.ResponseFailure(g, ErrParam, "profile "+id+" is still being captured")
+		return
+	case r.State == ProfileFailed:
+		c.ResponseFailure(g, ErrParam, "profile "+id+" failed: "+r.Error)
+		return
+	}
+	g.Header("Content-Disposition", `attachment; filename="`+id+`.pb.gz"`)
+	g.Data(http.StatusOK, "application/octet-stream", data)
+}
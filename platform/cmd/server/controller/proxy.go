@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseTrustedProxies parses proxy IPs/CIDRs used when deriving external
+// URLs. The same list should be handed to gin.Engine.SetTrustedProxies.
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (p *Platform) fromTrustedProxy(g *gin.Context) bool {
+	ip := net.ParseIP(g.RemoteIP())
+	if ip == nil {
+		// 没有对端 IP 说明请求来自 unix socket，只有具备 socket 权限的本机反向代理能连上
+		return true
+	}
+	for _, n := range p.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstHeader 取逗号分隔头部（多级代理追加）的第一个值。
+func firstHeader(g *gin.Context, key string) string {
+	v, _, _ := strings.Cut(g.GetHeader(key), ",")
+	return strings.TrimSpace(v)
+}
+
+// ExternalURL turns a server-relative path into the absolute URL the client
+// used to reach us, honoring X-Forwarded-Proto/Host/Prefix from trusted proxies.
+func (p *Platform) ExternalURL(g *gin.Context, path string) string {
+	scheme := "http"
+	if g.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := g.Request.Host
+	prefix := ""
+	if p.fromTrustedProxy(g) {
+		if v := firstHeader(g, "X-Forwarded-Proto"); v != "" {
+			scheme = v
+		}
+		if v := firstHeader(g, "X-Forwarded-Host"); v != "" {
+			host = v
+		}
+		prefix = strings.TrimSuffix(firstHeader(g, "X-Forwarded-Prefix"), "/")
+	}
+	return scheme + "://" + host + prefix + path
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func proxyContext(remote string, headers map[string]string) *gin.Context {
+	g, _ := gin.CreateTestContext(httptest.NewRecorder())
+	g.Request = httptest.NewRequest("GET", "http://ota.internal:8080/api/v1/check", nil)
+	g.Request.RemoteAddr = remote
+	for k, v := range headers {
+		g.Request.Header.Set(k, v)
+	}
+	return g
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies([]string{"10.0.0.1", " 192.168.0.0/16 ", "", "fd00::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1/32", "192.168.0.0/16", "fd00::1/128"}
+	if len(nets) != len(want) {
+		t.Fatalf("got %d networks, want %d", len(nets), len(want))
+	}
+	for i, n := range nets {
+		if n.String() != want[i] {
+			t.Errorf("network %d = %s, want %s", i, n, want[i])
+		}
+	}
+	if _, err := parseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
+
+func TestExternalURL(t *testing.T) {
+	nets, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Platform{trustedProxies: nets}
+	forwarded := map[string]string{
+		"X-Forwarded-Proto":  "https, http",
+		"X-Forwarded-Host":   "ota.example.com, ota.internal",
+		"X-Forwarded-Prefix": "/ota/",
+	}
+	for _, tc := range []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		tls     bool
+		want    string
+	}{
+		{"direct", "203.0.113.7:40000", nil, false, "http://ota.internal:8080/download/1.0.0"},
+		{"direct tls", "203.0.113.7:40000", nil, true, "https://ota.internal:8080/download/1.0.0"},
+		// 不可信的来源伪造的转发头不影响生成的地址
+		{"spoofed headers", "203.0.113.7:40000", forwarded, false, "http://ota.internal:8080/download/1.0.0"},
+		{"trusted proxy", "10.1.2.3:40000", forwarded, false, "https://ota.example.com/ota/download/1.0.0"},
+		{"trusted proxy without headers", "10.1.2.3:40000", nil, false, "http://ota.internal:8080/download/1.0.0"},
+		// unix socket 上的请求没有对端 IP，视为本机反向代理
+		{"unix socket", "@", forwarded, false, "https://ota.example.com/ota/download/1.0.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := proxyContext(tc.remote, tc.headers)
+			if tc.tls {
+				g.Request.TLS = &tls.ConnectionState{}
+			}
+			if got := p.ExternalURL(g, "/download/1.0.0"); got != tc.want {
+				t.Errorf("ExternalURL = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 数据保留与删除：EventRetention 是设备事件的总保留期（按段删除），RetentionByType 可为单类事件
+// 设置更短的保留期（如心跳 3 天、上报 90 天），由后台每小时逐条清理；
+// /api/v1/purge 按设备或客户（渠道）删除全部设备数据（含算法日志），并写入审计日志。审计日志本身不受影响。
+
+// ParseRetention parses "heartbeat=72h,check=168h".
+func ParseRetention(s string) (map[string]time.Duration, error) {
+	out := map[string]time.Duration{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		typ, v, ok := strings.Cut(item, "=")
+		if !ok || typ == "" {
+			return nil, fmt.Errorf("retention %q: want type=duration", item)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("retention %q: invalid duration", item)
+		}
+		out[typ] = d
+	}
+	return out, nil
+}
+
+// enforceRetention drops events past their per-type retention window.
+func (p *Platform) enforceRetention() {
+	if len(p.retention) == 0 {
+		return
+	}
+	now := p.clock.Now()
+	n, err := p.events.Edit(func(ev *DeviceEvent) EditAction {
+		if d, ok := p.retention[ev.Type]; ok && now.Sub(ev.recorded()) > d {
+			return EditDrop
+		}
+		return EditKeep
+	})
+	if err != nil {
+		log.Printf("event retention: %v", err)
+	} else if n > 0 {
+		log.Printf("event retention: removed %d expired events", n)
+	}
+}
+
+// purgeRequest 描述一次删除：指定设备，或客户的渠道（通配）——后者删除这些渠道上的事件，
+// 以及曾在这些渠道上出现过的设备的全部事件。
+type purgeRequest struct {
+	DeviceIDs []string `json:"device_ids"`
+	Channels  []string `json:"channels"`
+	Reason    string   `json:"reason"`
+	DryRun    bool     `json:"dry_run"`
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Purge godoc
+// @Summary      Purge device data
+// @Description  Delete all stored data (check-ins, install reports, heartbeats, crash reports, algorithm logs) of the given devices, or of a customer identified by its channels (globs): events on those channels and every event of devices seen on them. The purge is recorded in the audit log; dry_run only counts.
+// @Tags         device
+// @Accept       json
+// @Produce      json
+// @Param        body  body  object  true  "{\"device_ids\": [...], \"channels\": [\"acme-*\"], \"reason\": \"...\", \"dry_run\": false}"
+// @Success      200  {object}  map[string]any  "devices, events, algo_logs (devices whose logs were deleted), dry_run"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/purge [post]
+func (c *EventController) Purge(g *gin.Context) {
+	var req purgeRequest
+	if err := g.ShouldBindJSON(&req); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.DeviceIDs) == 0 && len(req.Channels) == 0 {
+		c.ResponseFailure(g, ErrParam, "device_ids or channels is required")
+		return
+	}
+	if req.Reason == "" {
+		c.ResponseFailure(g, ErrParam, "reason is required")
+		return
+	}
+	for _, pat := range req.Channels {
+		if _, err := path.Match(pat, ""); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid channel pattern "+pat)
+			return
+		}
+	}
+
+	devices := map[string]bool{}
+	for _, id := range req.DeviceIDs {
+		devices[id] = true
+	}
+	if len(req.Channels) > 0 {
+		// 先找出客户的设备，再一并删除它们在其它渠道上的事件
+		if _, err := c.p.events.Edit(func(ev *DeviceEvent) EditAction {
+			if ev.Channel != "" && matchAny(req.Channels, ev.Channel) {
+				devices[ev.DeviceID] = true
+			}
+			return EditKeep
+		}); err != nil {
+			c.ResponseFailure(g, ErrInternal, err.Error())
+			return
+		}
+	}
+	match := func(ev *DeviceEvent) bool {
+		return devices[ev.DeviceID] || (ev.Channel != "" && matchAny(req.Channels, ev.Channel))
+	}
+
+	actor := c.p.principal(g).Name
+	if req.DryRun {
+		n := 0
+		_, err := c.p.events.Edit(func(ev *DeviceEvent) EditAction {
+			if match(ev) {
+				n++
+			}
+			return EditKeep
+		})
+		if err != nil {
+			c.ResponseFailure(g, ErrInternal, err.Error())
+			return
+		}
+		g.JSON(http.StatusOK, gin.H{"devices": len(devices), "events": n, "dry_run": true})
+		return
+	}
+	// 先留痕再删除：删除中途失败时审计里也有这次操作
+	if err := c.p.audit(actor, "purge_requested", req.Reason, map[string]any{
+		"device_ids": req.DeviceIDs,
+		"channels":   req.Channels,
+		"devices":    len(devices),
+	}); err != nil {
+		c.ResponseFailure(g, ErrInternal, "audit log unavailable: "+err.Error())
+		return
+	}
+	n, err := c.p.events.Edit(func(ev *DeviceEvent) EditAction {
+		if match(ev) {
+			return EditDrop
+		}
+		return EditKeep
+	})
+	logs := 0
+	if err == nil {
+		logs, err = c.p.algoLogs.purge(devices)
+	}
+	done := map[string]any{"devices": len(devices), "events": n, "algo_logs": logs}
+	if err != nil {
+		done["error"] = err.Error()
+	}
+	_ = c.p.audit(actor, "purge_completed", req.Reason, done)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"devices": len(devices), "events": n, "algo_logs": logs, "dry_run": false})
+}
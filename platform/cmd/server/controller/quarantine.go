@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 隔离渠道：完整性检查发现制品与发布记录不符——重新校验任务（resign.go）或 doctor 修复
+// （doctor.go）算出的 sha256 与记录不同——时，版本被自动移入伪渠道 quarantined，记下触发原因与
+// 可查证的依据（相关接口的相对地址）；它曾是渠道最新版本时，渠道改指同渠道最新的其它版本（没有则删除
+// 渠道指针）。隔离渠道不能发布，也不会有最新版本，/check、别名与影子部署都不再下发该版本；已安装的
+// 设备不回滚（安装时已按记录的 sha256 校验）。恢复需要管理员显式操作并给出原因
+// （POST /api/v1/releases/<version>/restore），版本回到原渠道（或指定的渠道），比该渠道最新版本新时
+// 重新成为最新版本。隔离与恢复写入审计日志与渠道历史，隔离同时发出 release_quarantined 告警。
+
+// QuarantineChannel 是被自动隔离的版本所在的伪渠道。
+const QuarantineChannel = "quarantined"
+
+// 隔离的触发原因。
+const (
+	QuarantineIntegrity   = "integrity_check"   // 重新校验任务
+	QuarantineConsistency = "consistency_check" // doctor 修复
+	QuarantineRollout     = "rollout_halt"      // 渐进发布超出错误预算
+)
+
+// Quarantine 是版本被自动隔离的记录。
+type Quarantine struct {
+	At       time.Time `json:"at"`
+	Trigger  string    `json:"trigger"` // integrity_check | consistency_check | rollout_halt
+	Detail   string    `json:"detail"`
+	Evidence []string  `json:"evidence,omitempty"` // 触发依据的查询地址（相对路径）
+	Channel  string    `json:"channel"`            // 隔离前所在的渠道，恢复时的缺省渠道
+}
+
+// quarantine moves a release to the quarantine channel; nothing happens when
+// it is unknown or already quarantined. Callers hold p.store.mu.
+func (p *Platform) quarantine(v string, q Quarantine, actor string) error {
+	rel := p.store.ReleasesByVersion[v]
+	if rel == nil || rel.Quarantine != nil {
+		return nil
+	}
+	q.At, q.Channel = p.clock.Now(), rel.Channel
+	next := p.store.cloneState()
+	changed := *rel
+	changed.Channel, changed.Quarantine = QuarantineChannel, &q
+	next.ReleasesByVersion[v] = &changed
+	if key := rel.latestKey(); next.LatestByChannel[key] == v {
+		if to := next.newestIn(rel.App, rel.Channel); to == "" {
+			delete(next.LatestByChannel, key)
+		} else {
+			next.LatestByChannel[key] = to
+		}
+	}
+	if err := p.saveStore(next); err != nil {
+		return err
+	}
+	p.store.ReleasesByVersion = next.ReleasesByVersion
+	p.store.LatestByChannel = next.LatestByChannel
+	p.recordLatest(ChangeQuarantine, actor)
+	p.refreshTUF(p.store)
+	_ = p.audit(actor, "release_quarantine", q.Detail, map[string]any{"version": v, "channel": q.Channel, "trigger": q.Trigger, "evidence": q.Evidence})
+	p.emitAlert("release_quarantined", v+" moved from channel "+q.Channel+" to "+QuarantineChannel+": "+q.Detail)
+	return nil
+}
+
+// quarantineMismatch quarantines v after an integrity check found that its
+// artifact does not hash to recorded; a release republished since is left
+// alone. Callers hold p.store.mu.
+func (p *Platform) quarantineMismatch(v, recorded string, q Quarantine, actor string) error {
+	if rel := p.store.ReleasesByVersion[v]; rel == nil || rel.Sha256 != recorded {
+		return nil
+	}
+	return p.quarantine(v, q, actor)
+}
+
+// quarantined reports whether release v has been moved to the quarantine
+// channel.
+func (s *Store) quarantined(v string) bool {
+	rel := s.ReleasesByVersion[v]
+	return rel != nil && rel.Quarantine != nil
+}
+
+// newestIn returns the newest release of an app's channel that is not
+// recalled, "" when the channel has none.
+func (s *Store) newestIn(app, channel string) string {
+	newest := ""
+	for v, rel := range s.ReleasesByVersion {
+		if rel.App == app && rel.Channel == channel && rel.Recall == nil && (newest == "" || version.Newer(v, newest)) {
+			newest = v
+		}
+	}
+	return newest
+}
+
+type restoreRequest struct {
+	Reason  string `json:"reason"`
+	Channel string `json:"channel"`
+}
+
+// Restore godoc
+// @Summary      Restore a quarantined release
+// @Description  Move a release out of the quarantined channel after it was demoted automatically by an integrity check. It returns to the channel it was quarantined from (or the given one) and becomes that channel's latest version again when it is newer than the current one. A reason is required. Audited.
+// @Tags         release
+// @Accept       json
+// @Produce      json
+// @Param        version  path  string  true  "Quarantined version"
+// @Param        body  body  object  true  "{\"reason\": \"...\", \"channel\": \"stable\"}"
+// @Success      200  {object}  Release
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/releases/{version}/restore [post]
+func (c *FileController) Restore(g *gin.Context) {
+	var body restoreRequest
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	body.Reason, body.Channel = strings.TrimSpace(body.Reason), strings.TrimSpace(body.Channel)
+	if body.Reason == "" {
+		c.ResponseFailure(g, ErrParam, "reason is required")
+		return
+	}
+	if strings.Contains(body.Channel, "/") || body.Channel == QuarantineChannel {
+		c.ResponseFailure(g, ErrParam, "invalid channel")
+		return
+	}
+	v := g.Param("version")
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	rel := c.p.store.ReleasesByVersion[v]
+	switch {
+	case rel == nil:
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "unknown version "+v)
+		return
+	case rel.Quarantine == nil:
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, v+" is not quarantined")
+		return
+	}
+	channel := body.Channel
+	if channel == "" {
+		channel = rel.Quarantine.Channel
+	}
+	next := c.p.store.cloneState()
+	changed := *rel
+	changed.Channel, changed.Quarantine = channel, nil
+	next.ReleasesByVersion[v] = &changed
+	key := changed.latestKey()
+	cur := next.LatestByChannel[key]
+	latest := cur == "" || version.Newer(v, cur)
+	if latest {
+		next.LatestByChannel[key] = v
+	}
+	err := c.p.saveStore(next)
+	actor := c.p.principal(g).Name
+	if err == nil {
+		c.p.store.ReleasesByVersion = next.ReleasesByVersion
+		c.p.store.LatestByChannel = next.LatestByChannel
+		c.p.recordLatest(ChangeRestore, actor)
+		c.p.refreshTUF(c.p.store)
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save metadata"), fsErr(err, "save metadata").Error())
+		return
+	}
+	_ = c.p.audit(actor, "release_restore", body.Reason, map[string]any{"version": v, "channel": channel, "latest": latest, "trigger": rel.Quarantine.Trigger})
+	g.JSON(http.StatusOK, &changed)
+}
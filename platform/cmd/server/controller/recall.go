@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 版本召回：现场发现某个已发布版本有问题时，管理员召回它（POST /api/v1/releases/<version>/recall）。
+// 运行该版本的设备在检查响应中收到 rollback，agent 把 algo_current 切回设备上保留的上一个版本，
+// 不重新下载（见 agent 的 slots.go），并把被召回的版本记为坏版本；召回的版本也不再作为更新下发
+// （/check、hawkBit 与第三方更新器轮询）。召回只针对主应用，撤销召回（DELETE）后恢复下发。
+
+// Recall 是版本的召回记录。
+type Recall struct {
+	At     time.Time `json:"at"`
+	By     string    `json:"by"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Recall godoc
+// @Summary      Recall a release
+// @Description  Recall a release of the primary app: devices running it are told to roll back to the previous version kept on the device (rollback in the check response, nothing is downloaded), and it is no longer offered as an update. Audited.
+// @Tags         release
+// @Accept       json
+// @Produce      json
+// @Param        version  path  string  true  "Version to recall"
+// @Param        body  body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  Release
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/releases/{version}/recall [post]
+func (c *FileController) Recall(g *gin.Context) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&body); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	actor := c.p.principal(g).Name
+	c.setRecall(g, &Recall{At: c.p.clock.Now(), By: actor, Reason: strings.TrimSpace(body.Reason)}, actor)
+}
+
+// Unrecall godoc
+// @Summary      Cancel a recall
+// @Description  Withdraw the recall of a release: it is offered again and devices are no longer told to roll back from it. Versions already rolled back stay marked bad on those devices. Audited.
+// @Tags         release
+// @Produce      json
+// @Param        version  path  string  true  "Recalled version"
+// @Success      200  {object}  Release
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/releases/{version}/recall [delete]
+func (c *FileController) Unrecall(g *gin.Context) {
+	c.setRecall(g, nil, c.p.principal(g).Name)
+}
+
+// setRecall replaces the recall record of the release in the path.
+func (c *FileController) setRecall(g *gin.Context, r *Recall, actor string) {
+	v := g.Param("version")
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	rel := c.p.store.ReleasesByVersion[v]
+	switch {
+	case rel == nil:
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "unknown version "+v)
+		return
+	case rel.App != "":
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, "recalls cover only the primary app; "+v+" belongs to "+appLabel(rel.App))
+		return
+	case (r == nil) == (rel.Recall == nil):
+		c.p.store.mu.Unlock()
+		if r == nil {
+			c.ResponseFailure(g, ErrParam, v+" is not recalled")
+		} else {
+			c.ResponseFailure(g, ErrParam, v+" is already recalled")
+		}
+		return
+	}
+	// 与发布一样基于副本构造新状态，保存成功后再切换
+	next := c.p.store.cloneState()
+	changed := *rel
+	changed.Recall = r
+	next.ReleasesByVersion[v] = &changed
+	err := c.p.saveStore(next)
+	if err == nil {
+		c.p.store.ReleasesByVersion = next.ReleasesByVersion
+		c.p.refreshTUF(c.p.store)
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save metadata"), fsErr(err, "save metadata").Error())
+		return
+	}
+	// 镜像的渠道 tag 不指向被召回的版本
+	c.p.kickMirror()
+	action, reason := "release_unrecall", ""
+	if r != nil {
+		action, reason = "release_recall", r.Reason
+	}
+	_ = c.p.audit(actor, action, reason, map[string]any{"version": v, "channel": rel.Channel})
+	g.JSON(http.StatusOK, &changed)
+}
+
+// recalled reports whether the release in use by a device of the primary
+// app has been recalled.
+func (s *Store) recalled(current string) bool {
+	rel := s.ReleasesByVersion[current]
+	return rel != nil && rel.App == "" && rel.Recall != nil
+}
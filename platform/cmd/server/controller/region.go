@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按区域分发制品：设备经 /check 的 region / site 参数（agent 配置项）上报所在区域与站点，
+// 未上报时按来源 IP 所在网段推断区域，结果记入检查事件。区域镜像是同步了制品的 CDN 或缓存，
+// 与服务端 API 同样以 <镜像地址>/download/<version> 提供下载；/check 的 download_url 指向离设备
+// 最近的镜像（站点镜像优先于区域镜像），download_urls 依次列出候选地址，最后一个总是源站。
+// 制品由 sha256 与签名校验，镜像不需要可信。
+
+// RegionMirror 是固定在一个区域（"eu"）或站点（"eu/hangar-3"）的制品镜像。
+type RegionMirror struct {
+	Region string
+	URL    string
+}
+
+// RegionNetwork 把来源网段映射到区域，供不上报区域的设备使用。
+type RegionNetwork struct {
+	Net    *net.IPNet
+	Region string
+}
+
+// ParseRegionMirrors parses "eu=https://eu.example.com/ota,eu/hangar-3=http://10.3.0.5:1573/api/v1".
+func ParseRegionMirrors(s string) ([]RegionMirror, error) {
+	var out []RegionMirror
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		region, base, ok := strings.Cut(item, "=")
+		if !ok || region == "" {
+			return nil, fmt.Errorf("region mirror %q: want region=url", item)
+		}
+		u, err := url.Parse(base)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("region mirror %q: invalid url", item)
+		}
+		out = append(out, RegionMirror{Region: region, URL: strings.TrimSuffix(base, "/")})
+	}
+	return out, nil
+}
+
+// ParseRegionNetworks parses "10.1.0.0/16=eu,172.16.0.0/12=apac".
+func ParseRegionNetworks(s string) ([]RegionNetwork, error) {
+	var out []RegionNetwork
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		cidr, region, ok := strings.Cut(item, "=")
+		if !ok || region == "" {
+			return nil, fmt.Errorf("region network %q: want cidr=region", item)
+		}
+		nets, err := parseTrustedProxies([]string{cidr})
+		if err != nil {
+			return nil, fmt.Errorf("region network %q: %v", item, err)
+		}
+		out = append(out, RegionNetwork{Net: nets[0], Region: region})
+	}
+	return out, nil
+}
+
+// deviceRegion returns the region and site a check comes from: as reported by
+// the device, else the most specific configured network holding its IP.
+func (p *Platform) deviceRegion(g *gin.Context) (region, site string) {
+	region, site = g.Query("region"), g.Query("site")
+	if region != "" || len(p.regionNets) == 0 {
+		return region, site
+	}
+	ip := net.ParseIP(g.ClientIP())
+	if ip == nil {
+		return "", site
+	}
+	best := -1
+	for _, rn := range p.regionNets {
+		if ones, _ := rn.Net.Mask.Size(); rn.Net.Contains(ip) && ones > best {
+			region, best = rn.Region, ones
+		}
+	}
+	return region, site
+}
+
+// downloadURLs lists where a device in region/site should fetch rel from,
+// closest first; the origin URL always comes last.
+func (p *Platform) downloadURLs(region, site string, rel *Release, origin string) []string {
+	var keys []string
+	if region != "" && site != "" {
+		keys = append(keys, region+"/"+site)
+	}
+	if region != "" {
+		keys = append(keys, region)
+	}
+	var out []string
+	for _, key := range keys {
+		for _, m := range p.regionMirrors {
+			if m.Region == key {
+				out = append(out, m.URL+rel.URL)
+			}
+		}
+	}
+	return append(out, origin)
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 结构化发布说明：摘要、是否含不兼容变更、安全影响等级与关联的问题单。安全影响随检查响应下发，
+// agent 据此执行更严格的安装策略（如 experimental 版本只在 beta 机队自动安装）。
+
+const (
+	SafetyNone         = "none"
+	SafetyLow          = "low"
+	SafetyHigh         = "high"
+	SafetyExperimental = "experimental"
+)
+
+// safetyRank 按影响从低到高排序。
+var safetyRank = map[string]int{SafetyNone: 0, SafetyLow: 1, SafetyHigh: 2, SafetyExperimental: 3}
+
+// ReleaseNotes 是发布说明中可由机器解读的部分；自由文本仍在 Release.Notes 中。
+type ReleaseNotes struct {
+	Summary  string   `json:"summary,omitempty"`
+	Breaking bool     `json:"breaking,omitempty"`
+	Safety   string   `json:"safety,omitempty"` // none | low | high | experimental，缺省 none
+	Issues   []string `json:"issues,omitempty"` // 关联的问题单 ID，如 JIRA-123、#45
+}
+
+// SafetyImpact returns the release's safety impact level, "none" if unset.
+func (r *Release) SafetyImpact() string {
+	if r.ReleaseNotes == nil || r.ReleaseNotes.Safety == "" {
+		return SafetyNone
+	}
+	return r.ReleaseNotes.Safety
+}
+
+// parseReleaseNotes reads the summary, breaking, safety and issues form
+// fields of a publish; it returns nil when none is given.
+func parseReleaseNotes(g *gin.Context) (*ReleaseNotes, error) {
+	summary := strings.TrimSpace(g.PostForm("summary"))
+	breaking := strings.TrimSpace(g.PostForm("breaking"))
+	safety := strings.ToLower(strings.TrimSpace(g.PostForm("safety")))
+	issues := strings.TrimSpace(g.PostForm("issues"))
+	if summary == "" && breaking == "" && safety == "" && issues == "" {
+		return nil, nil
+	}
+	n := &ReleaseNotes{Summary: summary, Safety: safety}
+	if breaking != "" {
+		b, err := strconv.ParseBool(breaking)
+		if err != nil {
+			return nil, errors.New("breaking must be true or false")
+		}
+		n.Breaking = b
+	}
+	if n.Safety == "" {
+		n.Safety = SafetyNone
+	}
+	if _, ok := safetyRank[n.Safety]; !ok {
+		return nil, errors.New("invalid safety (want none, low, high or experimental)")
+	}
+	for _, id := range strings.Split(issues, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			n.Issues = append(n.Issues, id)
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,634 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/s3"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 跨区域复制：主区域故障时，紧急回滚不能因为制品与元数据只存在于故障区域而无法进行。配置 -replicate 后，
+// 后台为每个副本各运行一个复制任务（每 30 秒一次，渠道指针变化时立即触发）：先逐个复制副本上缺少的制品，
+// 复制后从副本读回并重新计算 sha256，与发布记录一致才算复制完成；全部制品就绪后再复制元数据
+// （releases.json 整体：版本、渠道指针、别名、策略、渐进发布……），副本的索引因此从不引用缺失的制品。
+// 副本有两种：
+//   - S3（s3://bucket/prefix?region=...，也可指向 MinIO 等兼容存储）：制品存为 artifacts/<version>，
+//     元数据存为 releases.json，是可用于在另一区域恢复实例的冷备；
+//   - 另一平台实例（https://...）：以 -accept-replication 启动，经 /api/v1/replication/ 接收制品与
+//     元数据，可直接接管检查与下载。它接收的元数据覆盖本地索引，不要在副本上发布；副本配置了透明日志时
+//     用自己的日志密钥补记复制来的版本，agent 的 log_public_key 须与接管检查的实例一致。
+// 复制失败时发出 replication_failed 告警（恢复前只发一次），下一轮重试。复制延迟（最早未复制的变更距今的
+// 时间）、待复制的制品数与累计错误数经 GET /api/v1/replication 与 Prometheus 格式的
+// /api/v1/replication/metrics 查询。副本上多出的制品（已在主区域清理的版本）不会被删除。
+// 复制进度只保存在内存中，服务重启后每个制品在副本上重新校验一次（S3 副本为完整回读）。
+
+// replicateInterval 是复制任务的检查间隔。
+const replicateInterval = 30 * time.Second
+
+// replicateTimeout 限制一轮复制的总时长。
+const replicateTimeout = 30 * time.Minute
+
+// Replica 是另一区域中制品与元数据的副本。
+type Replica interface {
+	// PutArtifact copies the artifact of rel to the replica.
+	PutArtifact(ctx context.Context, rel *Release, a ArtifactReader) error
+	// ArtifactSha256 reads the replica's copy of version back and hashes
+	// it; "" when the replica has none.
+	ArtifactSha256(ctx context.Context, version string) (string, error)
+	// PutMetadata replaces the replica's release index.
+	PutMetadata(ctx context.Context, index []byte) error
+	Describe() string
+}
+
+// s3Replica 把制品与元数据复制到 S3 桶。
+type s3Replica struct {
+	client *s3.Client
+}
+
+// NewS3Replica returns a replica in the bucket ref
+// ("s3://bucket/prefix?region=eu-west-1[&endpoint=...]").
+func NewS3Replica(ref, accessKey, secretKey, sessionToken string) (Replica, error) {
+	c, err := s3.NewClient(ref, accessKey, secretKey, sessionToken, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Replica{client: c}, nil
+}
+
+func (r *s3Replica) Describe() string { return r.client.Ref() }
+
+func (r *s3Replica) PutArtifact(ctx context.Context, rel *Release, a ArtifactReader) error {
+	return r.client.Put(ctx, "artifacts/"+rel.Version, a, a.Size(), "application/octet-stream",
+		map[string]string{"sha256": rel.Sha256})
+}
+
+func (r *s3Replica) ArtifactSha256(ctx context.Context, version string) (string, error) {
+	body, err := r.client.Get(ctx, "artifacts/"+version)
+	if errors.Is(err, s3.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (r *s3Replica) PutMetadata(ctx context.Context, index []byte) error {
+	return r.client.Put(ctx, "releases.json", bytes.NewReader(index), int64(len(index)), "application/json", nil)
+}
+
+// platformReplica 把制品与元数据推送到以 -accept-replication 启动的另一平台实例。
+type platformReplica struct {
+	base  string // 不含 /api/v1
+	token string
+	http  *http.Client
+}
+
+// NewPlatformReplica returns a replica on the platform instance at base
+// (e.g. https://ota-eu.example.com), authenticating with an admin token
+// when one is given.
+func NewPlatformReplica(base, token string) (Replica, error) {
+	base = strings.TrimSuffix(base, "/")
+	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		return nil, fmt.Errorf("replica %q: want an http(s) URL or s3://", base)
+	}
+	return &platformReplica{base: base, token: token, http: &http.Client{}}, nil
+}
+
+func (r *platformReplica) Describe() string { return r.base }
+
+func (r *platformReplica) do(ctx context.Context, method, path string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, r.base+"/api/v1/replication"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	return r.http.Do(req)
+}
+
+// replicaError turns a failed response into an error with the replica's
+// detail message.
+func replicaError(resp *http.Response) error {
+	var body struct {
+		Detail string `json:"detail"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(b, &body) == nil && body.Detail != "" {
+		return fmt.Errorf("%s: %s", resp.Status, body.Detail)
+	}
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
+
+func (r *platformReplica) PutArtifact(ctx context.Context, rel *Release, a ArtifactReader) error {
+	resp, err := r.do(ctx, http.MethodPut, "/artifacts/"+rel.Version+"?sha256="+rel.Sha256, a, a.Size())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return replicaError(resp)
+	}
+	return nil
+}
+
+func (r *platformReplica) ArtifactSha256(ctx context.Context, version string) (string, error) {
+	resp, err := r.do(ctx, http.MethodGet, "/artifacts/"+version, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", replicaError(resp)
+	}
+	var body struct {
+		Sha256 string `json:"sha256"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	return body.Sha256, nil
+}
+
+func (r *platformReplica) PutMetadata(ctx context.Context, index []byte) error {
+	resp, err := r.do(ctx, http.MethodPut, "/releases", bytes.NewReader(index), int64(len(index)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return replicaError(resp)
+	}
+	return nil
+}
+
+// replicaState 是一个副本的复制进度。
+type replicaState struct {
+	replica Replica
+	kick    chan struct{}
+
+	mu     sync.Mutex
+	copied map[string]string // 版本 -> 在副本上校验过的 sha256
+	// index 是最近一次复制到副本的元数据的 sha256
+	index       string
+	behindSince time.Time // 副本与本地一致时为零值
+	lastAttempt time.Time
+	lastSuccess time.Time
+	lastError   string
+	failing     bool
+	errors      int64
+	bytesCopied int64
+}
+
+func newReplicaStates(replicas []Replica) []*replicaState {
+	var out []*replicaState
+	for _, r := range replicas {
+		out = append(out, &replicaState{replica: r, kick: make(chan struct{}, 1), copied: map[string]string{}})
+	}
+	return out
+}
+
+// kickReplication starts a replication round on every replica now, e.g.
+// after a channel pointer moved.
+func (p *Platform) kickReplication() {
+	for _, r := range p.replicas {
+		select {
+		case r.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// replicateLoop replicates to r every replicateInterval and whenever it is
+// kicked, until stop is closed.
+func (p *Platform) replicateLoop(stop <-chan struct{}, r *replicaState) {
+	t := p.clock.NewTicker(replicateInterval)
+	defer t.Stop()
+	for {
+		p.replicate(r)
+		select {
+		case <-stop:
+			return
+		case <-t.C():
+		case <-r.kick:
+		}
+	}
+}
+
+// replicate runs one round: missing artifacts first, each verified by
+// reading it back, then the index once every artifact is there.
+func (p *Platform) replicate(r *replicaState) {
+	p.store.mu.RLock()
+	index, err := json.Marshal(p.store)
+	releases := make([]*Release, 0, len(p.store.ReleasesByVersion))
+	for _, rel := range p.store.ReleasesByVersion {
+		releases = append(releases, rel)
+	}
+	p.store.mu.RUnlock()
+	if err != nil {
+		log.Printf("replication: encode index: %v", err)
+		return
+	}
+	sum := sha256.Sum256(index)
+	digest := hex.EncodeToString(sum[:])
+	// 新版本先复制，刚发布的版本尽快可用
+	sort.Slice(releases, func(i, j int) bool { return version.Newer(releases[i].Version, releases[j].Version) })
+
+	now := p.clock.Now()
+	r.mu.Lock()
+	r.lastAttempt = now
+	if digest != r.index && r.behindSince.IsZero() {
+		r.behindSince = now
+	}
+	var pending []*Release
+	for _, rel := range releases {
+		if r.copied[rel.Version] != rel.Sha256 {
+			pending = append(pending, rel)
+		}
+	}
+	upToDate := len(pending) == 0 && digest == r.index
+	r.mu.Unlock()
+	if upToDate {
+		p.replicated(r, "", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replicateTimeout)
+	defer cancel()
+	for _, rel := range pending {
+		if err := p.replicateArtifact(ctx, r, rel); err != nil {
+			p.replicated(r, "", fmt.Errorf("%s: %w", rel.Version, err))
+			return
+		}
+	}
+	if err := r.replica.PutMetadata(ctx, index); err != nil {
+		p.replicated(r, "", fmt.Errorf("metadata: %w", err))
+		return
+	}
+	p.replicated(r, digest, nil)
+}
+
+// replicateArtifact copies rel unless the replica already holds it, and
+// verifies the copy.
+func (p *Platform) replicateArtifact(ctx context.Context, r *replicaState, rel *Release) error {
+	have, err := r.replica.ArtifactSha256(ctx, rel.Version)
+	if err != nil {
+		return err
+	}
+	if have != rel.Sha256 {
+		a, err := p.artifacts.Open(rel.Version)
+		if errors.Is(err, errArtifactNotFound) || os.IsNotExist(err) {
+			// 本地缺失的制品由 doctor 报告，不阻塞其它版本与元数据的复制
+			log.Printf("replication: %s has no artifact here, skipped", rel.Version)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size := a.Size()
+		err = r.replica.PutArtifact(ctx, rel, a)
+		a.Close()
+		if err != nil {
+			return err
+		}
+		if have, err = r.replica.ArtifactSha256(ctx, rel.Version); err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+		if have != rel.Sha256 {
+			return fmt.Errorf("verify: replica copy hashes to %q, want %s", have, rel.Sha256)
+		}
+		r.mu.Lock()
+		r.bytesCopied += size
+		r.mu.Unlock()
+		log.Printf("replicated %s to %s", rel.Version, r.replica.Describe())
+	}
+	r.mu.Lock()
+	r.copied[rel.Version] = rel.Sha256
+	r.mu.Unlock()
+	return nil
+}
+
+// replicated records the outcome of a round; digest is the index now on
+// the replica, "" when it did not change.
+func (p *Platform) replicated(r *replicaState, digest string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errors++
+		r.lastError = err.Error()
+		if !r.failing {
+			r.failing = true
+			p.emitAlert("replication_failed", r.replica.Describe()+": "+err.Error())
+		} else {
+			log.Printf("replication to %s: %v", r.replica.Describe(), err)
+		}
+		return
+	}
+	if digest != "" {
+		r.index = digest
+	}
+	if r.failing {
+		log.Printf("replication to %s recovered", r.replica.Describe())
+	}
+	r.failing, r.lastError = false, ""
+	r.behindSince, r.lastSuccess = time.Time{}, p.clock.Now()
+}
+
+// ReplicaStatus 是一个副本的复制状态。
+type ReplicaStatus struct {
+	Target string `json:"target"`
+	InSync bool   `json:"in_sync"`
+	// LagSeconds 是最早未复制的变更距今的秒数，同步时为 0
+	LagSeconds          float64    `json:"lag_seconds"`
+	BehindSince         *time.Time `json:"behind_since,omitempty"`
+	PendingArtifacts    int        `json:"pending_artifacts"`
+	ReplicatedArtifacts int        `json:"replicated_artifacts"`
+	BytesCopied         int64      `json:"bytes_copied"`
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	Errors              int64      `json:"errors"`
+}
+
+func (p *Platform) replicationStatus() []ReplicaStatus {
+	p.store.mu.RLock()
+	shas := make(map[string]string, len(p.store.ReleasesByVersion))
+	for v, rel := range p.store.ReleasesByVersion {
+		shas[v] = rel.Sha256
+	}
+	p.store.mu.RUnlock()
+	now := p.clock.Now()
+	out := []ReplicaStatus{}
+	for _, r := range p.replicas {
+		r.mu.Lock()
+		s := ReplicaStatus{Target: r.replica.Describe(), BytesCopied: r.bytesCopied, LastError: r.lastError, Errors: r.errors}
+		for v, sha := range shas {
+			if r.copied[v] == sha {
+				s.ReplicatedArtifacts++
+			} else {
+				s.PendingArtifacts++
+			}
+		}
+		if !r.behindSince.IsZero() {
+			since := r.behindSince
+			s.BehindSince, s.LagSeconds = &since, now.Sub(since).Seconds()
+		}
+		s.InSync = r.behindSince.IsZero() && !r.lastSuccess.IsZero() && s.PendingArtifacts == 0
+		if !r.lastAttempt.IsZero() {
+			t := r.lastAttempt
+			s.LastAttempt = &t
+		}
+		if !r.lastSuccess.IsZero() {
+			t := r.lastSuccess
+			s.LastSuccess = &t
+		}
+		r.mu.Unlock()
+		out = append(out, s)
+	}
+	return out
+}
+
+type ReplicationController struct {
+	BaseController
+	p *Platform
+}
+
+func NewReplicationController(p *Platform) *ReplicationController {
+	return &ReplicationController{p: p}
+}
+
+// Status godoc
+// @Summary      Replication status
+// @Description  Per replica (S3 bucket or platform instance started with -accept-replication): whether it is in sync, the replication lag (age of the oldest change not yet copied), artifacts pending and copied, and the last error. Empty when the server runs without -replicate.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  map[string]any  "replicas"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/replication [get]
+func (c *ReplicationController) Status(g *gin.Context) {
+	g.JSON(http.StatusOK, gin.H{"replicas": c.p.replicationStatus()})
+}
+
+// Metrics godoc
+// @Summary      Replication metrics
+// @Description  Replication lag, pending artifacts, copied bytes and errors per replica in the Prometheus text exposition format.
+// @Tags         admin
+// @Produce      plain
+// @Success      200  {string}  string
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/replication/metrics [get]
+func (c *ReplicationController) Metrics(g *gin.Context) {
+	status := c.p.replicationStatus()
+	var b strings.Builder
+	gauge := func(name, help, typ string, value func(s ReplicaStatus) float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, s := range status {
+			fmt.Fprintf(&b, "%s{target=%q} %g\n", name, s.Target, value(s))
+		}
+	}
+	gauge("dronealgo_replication_lag_seconds", "Age of the oldest change not yet copied to the replica.", "gauge",
+		func(s ReplicaStatus) float64 { return s.LagSeconds })
+	gauge("dronealgo_replication_pending_artifacts", "Artifacts not yet copied and verified on the replica.", "gauge",
+		func(s ReplicaStatus) float64 { return float64(s.PendingArtifacts) })
+	gauge("dronealgo_replication_last_success_timestamp_seconds", "Unix time of the last round that left the replica in sync.", "gauge",
+		func(s ReplicaStatus) float64 {
+			if s.LastSuccess == nil {
+				return 0
+			}
+			return float64(s.LastSuccess.Unix())
+		})
+	gauge("dronealgo_replication_copied_bytes_total", "Artifact bytes copied to the replica.", "counter",
+		func(s ReplicaStatus) float64 { return float64(s.BytesCopied) })
+	gauge("dronealgo_replication_errors_total", "Failed replication rounds.", "counter",
+		func(s ReplicaStatus) float64 { return float64(s.Errors) })
+	g.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// acceptsReplication rejects replication pushes unless the server runs as
+// a replica.
+func (c *ReplicationController) acceptsReplication(g *gin.Context) bool {
+	if !c.p.acceptReplication {
+		c.ResponseFailure(g, ErrForbidden, "this server does not accept replication (start it with -accept-replication)")
+		return false
+	}
+	return true
+}
+
+// PutArtifact godoc
+// @Summary      Receive a replicated artifact
+// @Description  Store an artifact pushed by the primary region's replication worker. The body is the raw artifact; it is only committed when it hashes to the sha256 query parameter. Needs -accept-replication.
+// @Tags         admin
+// @Accept       octet-stream
+// @Produce      json
+// @Param        version  path   string  true  "Version"
+// @Param        sha256   query  string  true  "Expected sha256 of the body"
+// @Success      200  {object}  map[string]any  "version, sha256, size"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      503  {object}  map[string]any  "server is read-only"
+// @Security     BearerAuth
+// @Router       /api/v1/replication/artifacts/{version} [put]
+func (c *ReplicationController) PutArtifact(g *gin.Context) {
+	if !c.acceptsReplication(g) {
+		return
+	}
+	v, want := g.Param("version"), g.Query("sha256")
+	if strings.ContainsAny(v, `/\`) || v == "." || v == ".." {
+		c.ResponseFailure(g, ErrParam, "invalid version")
+		return
+	}
+	if len(want) != sha256.Size*2 {
+		c.ResponseFailure(g, ErrParam, "sha256 is required")
+		return
+	}
+	if c.p.stillReadOnly() {
+		c.ResponseFailure(g, ErrReadOnly, "server is read-only after running out of disk space")
+		return
+	}
+	h := sha256.New()
+	counter := &countingReader{r: g.Request.Body}
+	staged, err := c.p.artifacts.Stage(io.TeeReader(counter, h))
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "write artifact "+v), fsErr(err, "write artifact "+v).Error())
+		return
+	}
+	defer staged.Discard()
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		c.ResponseFailure(g, ErrParam, "body hashes to "+got+", want "+want)
+		return
+	}
+	if err := staged.Commit(v); err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "commit artifact"), fsErr(err, "commit artifact").Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"version": v, "sha256": want, "size": counter.n})
+}
+
+// countingReader 统计读过的字节数。
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// GetArtifact godoc
+// @Summary      Verify a replicated artifact
+// @Description  Re-read the stored artifact of a version and return its sha256 and size, so the primary can verify a copy. Needs -accept-replication.
+// @Tags         admin
+// @Produce      json
+// @Param        version  path  string  true  "Version"
+// @Success      200  {object}  map[string]any  "version, sha256, size"
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/replication/artifacts/{version} [get]
+func (c *ReplicationController) GetArtifact(g *gin.Context) {
+	if !c.acceptsReplication(g) {
+		return
+	}
+	v := g.Param("version")
+	a, err := c.p.artifacts.Open(v)
+	if errors.Is(err, errArtifactNotFound) || os.IsNotExist(err) {
+		c.ResponseFailure(g, ErrNotFound, "no artifact for "+v)
+		return
+	}
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	defer a.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, a)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, "read artifact: "+err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"version": v, "sha256": hex.EncodeToString(h.Sum(nil)), "size": n})
+}
+
+// PutReleases godoc
+// @Summary      Receive the replicated release index
+// @Description  Replace this server's release index (releases.json: releases, channel pointers, aliases, policies, rollouts ...) with the primary region's. The primary only sends it once every artifact it references was copied and verified. Needs -accept-replication; do not publish on a replica.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Success      200  {object}  map[string]any  "releases"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      503  {object}  map[string]any  "server is read-only"
+// @Security     BearerAuth
+// @Router       /api/v1/replication/releases [put]
+func (c *ReplicationController) PutReleases(g *gin.Context) {
+	if !c.acceptsReplication(g) {
+		return
+	}
+	b, err := io.ReadAll(g.Request.Body)
+	if err != nil {
+		c.ResponseFailure(g, ErrParam, "read body: "+err.Error())
+		return
+	}
+	next, err := decodeStore(b)
+	if err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid index: "+err.Error())
+		return
+	}
+	if c.p.stillReadOnly() {
+		c.ResponseFailure(g, ErrReadOnly, "server is read-only after running out of disk space")
+		return
+	}
+	c.p.store.mu.Lock()
+	err = c.p.saveStore(next)
+	var logErr error
+	if err == nil {
+		c.p.adoptStore(next)
+		c.p.recordLatest(ChangeReplication, "")
+		c.p.refreshTUF(c.p.store)
+		// 副本的透明日志用自己的密钥记录复制来的版本，agent 在副本上同样能取到包含证明
+		logErr = c.p.backfillLog(c.p.store)
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save metadata"), fsErr(err, "save metadata").Error())
+		return
+	}
+	if logErr != nil {
+		// 主区域稍后重新推送索引时补记
+		c.ResponseFailure(g, ErrInternal, "transparency log: "+logErr.Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"releases": len(next.ReleasesByVersion)})
+}
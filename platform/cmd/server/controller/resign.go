@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/von0000/dronealgo-ota/internal/artifactsig"
+)
+
+// 制品重新校验与重新签名：更换签名密钥（-signing-key）后，旧版本仍带旧密钥的签名，只配置了新公钥的
+// agent 无法安装它们。管理员启动后台任务（POST /api/v1/maintenance/resign），逐个读取已存储的制品，
+// 重新计算 sha256 并与发布记录核对；一致且签名密钥不是当前密钥、签名仍是只签摘要的旧方案（或 force）时
+// 用当前密钥重新签名并保存记录，
+// 无需重新上传。校验和不符的版本不签名，并被移入 quarantined 渠道（见 quarantine.go）；制品缺失只报告。读取按 max_mb_per_sec 限速，
+// 避免与下载争抢磁盘与对象存储带宽；进度通过 GET 查询，DELETE 取消。同一时间只运行一个任务，
+// 进度只保存在内存中，服务重启后重新运行即可（已是当前密钥的版本会跳过）。开始与结束写入审计日志。
+// 透明日志只记录 sha256，重新签名不追加条目；配置了 OCI 镜像时重新推送该版本，manifest 的签名注解随之更新
+// （见 mirror.go）。
+
+const (
+	ResignRunning   = "running"
+	ResignDone      = "done"
+	ResignCancelled = "cancelled"
+
+	defaultResignMBPerSec = 20
+	resignChunk           = 1 << 20
+	// maxResignErrors 限制任务中保留的错误条数。
+	maxResignErrors = 100
+)
+
+// ResignJob 是一次重新校验与重新签名任务的进度。
+type ResignJob struct {
+	State      string     `json:"state"` // running | done | cancelled
+	StartedAt  time.Time  `json:"started_at"`
+	StartedBy  string     `json:"started_by"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// KeyID 是当前签名密钥，未配置签名密钥时为空，任务只做校验。
+	KeyID       string `json:"key_id,omitempty"`
+	Force       bool   `json:"force,omitempty"`
+	MaxMBPerSec int    `json:"max_mb_per_sec"` // 0 为不限速
+	Total       int    `json:"total"`
+	Checked     int    `json:"checked"`
+	BytesRead   int64  `json:"bytes_read"`
+	Current     string `json:"current,omitempty"` // 正在读取的版本
+	Resigned    int    `json:"resigned"`
+	Unchanged   int    `json:"unchanged"` // 校验一致，签名已是当前密钥或未配置签名密钥
+	// Mismatched 与 Missing 需要人工处理，见 /api/v1/doctor。
+	Mismatched []string `json:"mismatched"`
+	Missing    []string `json:"missing"`
+	Errors     []string `json:"errors"`
+}
+
+// resignState 持有当前（或最近一次）任务。
+type resignState struct {
+	mu     sync.Mutex
+	job    *ResignJob
+	cancel context.CancelFunc
+}
+
+// snapshot copies the job for a response, nil before the first run.
+func (s *resignState) snapshot() *ResignJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.job == nil {
+		return nil
+	}
+	j := *s.job
+	j.Mismatched = append([]string{}, j.Mismatched...)
+	j.Missing = append([]string{}, j.Missing...)
+	j.Errors = append([]string{}, j.Errors...)
+	return &j
+}
+
+func (s *resignState) update(fn func(j *ResignJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.job)
+}
+
+type resignRequest struct {
+	Force       bool `json:"force"`
+	MaxMBPerSec *int `json:"max_mb_per_sec"`
+}
+
+// StartResign godoc
+// @Summary      Re-verify and re-sign stored artifacts
+// @Description  Start a background job that re-hashes every stored artifact against its release record and, when its signature was made with another key (or force is set), re-signs the digest with the current signing key so agents trusting only the new key can install old releases without re-uploading them. Without a signing key the job only verifies. Hash mismatches are never signed and move the release to the quarantined channel; missing artifacts are reported. Reads are throttled to max_mb_per_sec (default 20, 0 = unthrottled). One job runs at a time; poll GET for progress. Audited.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        body  body  object  false  "{\"force\": false, \"max_mb_per_sec\": 20}"
+// @Success      202  {object}  controller.ResignJob
+// @Failure      400  {object}  map[string]any  "a job is already running"
+// @Failure      403  {object}  map[string]any
+// @Failure      503  {object}  map[string]any  "server is read-only"
+// @Security     BearerAuth
+// @Router       /api/v1/maintenance/resign [post]
+func (c *DoctorController) StartResign(g *gin.Context) {
+	var req resignRequest
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&req); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	rate := defaultResignMBPerSec
+	if req.MaxMBPerSec != nil {
+		if *req.MaxMBPerSec < 0 {
+			c.ResponseFailure(g, ErrParam, "invalid max_mb_per_sec")
+			return
+		}
+		rate = *req.MaxMBPerSec
+	}
+	if c.p.stillReadOnly() {
+		c.ResponseFailure(g, ErrReadOnly, "server is read-only after running out of disk space")
+		return
+	}
+	job, err := c.p.startResign(c.p.principal(g).Name, req.Force, rate)
+	if err != nil {
+		c.ResponseFailure(g, ErrParam, err.Error())
+		return
+	}
+	g.JSON(http.StatusAccepted, job)
+}
+
+// ResignStatus godoc
+// @Summary      Re-sign job progress
+// @Description  Progress of the running artifact re-verify / re-sign job, or the result of the last one.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  controller.ResignJob
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any  "no job has run since the server started"
+// @Security     BearerAuth
+// @Router       /api/v1/maintenance/resign [get]
+func (c *DoctorController) ResignStatus(g *gin.Context) {
+	job := c.p.resign.snapshot()
+	if job == nil {
+		c.ResponseFailure(g, ErrNotFound, "no re-sign job has run since the server started")
+		return
+	}
+	g.JSON(http.StatusOK, job)
+}
+
+// CancelResign godoc
+// @Summary      Cancel the re-sign job
+// @Description  Stop the running job after the artifact being read; releases already re-signed keep their new signature.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {object}  controller.ResignJob
+// @Failure      400  {object}  map[string]any  "no job is running"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/maintenance/resign [delete]
+func (c *DoctorController) CancelResign(g *gin.Context) {
+	c.p.resign.mu.Lock()
+	running := c.p.resign.job != nil && c.p.resign.job.State == ResignRunning
+	if running {
+		c.p.resign.cancel()
+	}
+	c.p.resign.mu.Unlock()
+	if !running {
+		c.ResponseFailure(g, ErrParam, "no re-sign job is running")
+		return
+	}
+	g.JSON(http.StatusOK, c.p.resign.snapshot())
+}
+
+// startResign starts the job unless one is running.
+func (p *Platform) startResign(actor string, force bool, rate int) (*ResignJob, error) {
+	p.reloadIfChanged()
+	p.store.mu.RLock()
+	versions := make([]*Release, 0, len(p.store.ReleasesByVersion))
+	for _, rel := range p.store.ReleasesByVersion {
+		versions = append(versions, rel)
+	}
+	p.store.mu.RUnlock()
+	// 先处理最早的版本：它们最可能仍带旧密钥的签名
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].CreatedAt.Equal(versions[j].CreatedAt) {
+			return versions[i].CreatedAt.Before(versions[j].CreatedAt)
+		}
+		return versions[i].Version < versions[j].Version
+	})
+
+	job := &ResignJob{
+		State:       ResignRunning,
+		StartedAt:   p.clock.Now(),
+		StartedBy:   actor,
+		Force:       force,
+		MaxMBPerSec: rate,
+		Total:       len(versions),
+		Mismatched:  []string{},
+		Missing:     []string{},
+		Errors:      []string{},
+	}
+	if p.signer != nil {
+		job.KeyID = p.signer.KeyID()
+	}
+	p.resign.mu.Lock()
+	if p.resign.job != nil && p.resign.job.State == ResignRunning {
+		p.resign.mu.Unlock()
+		return nil, errors.New("a re-sign job is already running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.resign.job, p.resign.cancel = job, cancel
+	p.resign.mu.Unlock()
+
+	_ = p.audit(actor, "artifact_resign_start", "", map[string]any{"releases": len(versions), "key_id": job.KeyID, "force": force})
+	go p.runResign(ctx, cancel, versions)
+	return p.resign.snapshot(), nil
+}
+
+func (p *Platform) runResign(ctx context.Context, cancel context.CancelFunc, versions []*Release) {
+	defer cancel()
+	job := p.resign.snapshot()
+	for _, rel := range versions {
+		if ctx.Err() != nil {
+			break
+		}
+		p.resign.update(func(j *ResignJob) { j.Current = rel.Version })
+		sum, err := p.throttledSum(ctx, rel.Version, job.MaxMBPerSec)
+		switch {
+		case ctx.Err() != nil:
+			// 取消时正在读取的版本不计入
+		case errors.Is(err, errArtifactNotFound):
+			p.resign.update(func(j *ResignJob) { j.Missing = append(j.Missing, rel.Version) })
+		case err != nil:
+			p.resignError(rel.Version, err)
+		case sum != rel.Sha256:
+			detail := "sha256 " + sum + " differs from the recorded " + rel.Sha256
+			p.resign.update(func(j *ResignJob) { j.Mismatched = append(j.Mismatched, rel.Version) })
+			p.emitAlert("artifact_hash_mismatch", rel.Version+": "+detail)
+			p.store.mu.Lock()
+			err := p.quarantineMismatch(rel.Version, rel.Sha256, Quarantine{Trigger: QuarantineIntegrity, Detail: detail,
+				Evidence: []string{"/api/v1/maintenance/resign", "/api/v1/doctor"}}, "system")
+			p.store.mu.Unlock()
+			if err != nil {
+				log.Printf("quarantine %s: %v", rel.Version, err)
+			}
+		case p.signer == nil || (rel.KeyID == job.KeyID && rel.Signature != "" && rel.SigScheme == artifactsig.Scheme && !job.Force):
+			p.resign.update(func(j *ResignJob) { j.Unchanged++ })
+		default:
+			if err := p.resignRelease(rel); err != nil {
+				p.resignError(rel.Version, err)
+			} else {
+				p.resign.update(func(j *ResignJob) { j.Resigned++ })
+			}
+		}
+		if ctx.Err() == nil {
+			p.resign.update(func(j *ResignJob) { j.Checked++ })
+		}
+	}
+
+	now := p.clock.Now()
+	p.resign.update(func(j *ResignJob) {
+		j.State, j.Current, j.FinishedAt = ResignDone, "", &now
+		if ctx.Err() != nil {
+			j.State = ResignCancelled
+		}
+	})
+	job = p.resign.snapshot()
+	log.Printf("artifact re-sign %s: %d/%d checked, %d re-signed, %d mismatched, %d missing, %d errors",
+		job.State, job.Checked, job.Total, job.Resigned, len(job.Mismatched), len(job.Missing), len(job.Errors))
+	_ = p.audit(job.StartedBy, "artifact_resign", job.State, map[string]any{
+		"checked":    job.Checked,
+		"resigned":   job.Resigned,
+		"mismatched": job.Mismatched,
+		"missing":    job.Missing,
+		"errors":     len(job.Errors),
+		"key_id":     job.KeyID,
+	})
+}
+
+func (p *Platform) resignError(v string, err error) {
+	log.Printf("artifact re-sign %s: %v", v, err)
+	p.resign.update(func(j *ResignJob) {
+		if len(j.Errors) < maxResignErrors {
+			j.Errors = append(j.Errors, v+": "+err.Error())
+		}
+	})
+}
+
+// throttledSum hashes the artifact of v, reading at most rate MB per second
+// (0 = unthrottled), and counts the bytes read in the job's progress.
+func (p *Platform) throttledSum(ctx context.Context, v string, rate int) (string, error) {
+	a, err := p.artifacts.Open(v)
+	if err != nil {
+		return "", err
+	}
+	defer a.Close()
+	h := sha256.New()
+	buf := make([]byte, resignChunk)
+	start := p.clock.Now()
+	var read int64
+	for {
+		n, err := a.Read(buf)
+		h.Write(buf[:n])
+		read += int64(n)
+		p.resign.update(func(j *ResignJob) { j.BytesRead += int64(n) })
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if rate > 0 {
+			due := start.Add(time.Duration(float64(read) / float64(rate<<20) * float64(time.Second)))
+			if wait := due.Sub(p.clock.Now()); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return "", ctx.Err()
+				case <-p.clock.After(wait):
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resignRelease signs rel with the current key and scheme and saves the
+// record, unless the release was republished while its artifact was read.
+func (p *Platform) resignRelease(rel *Release) error {
+	msg, err := artifactsig.Message(rel.App, rel.Version, rel.Sha256)
+	if err != nil {
+		return fmt.Errorf("recorded release: %w", err)
+	}
+	sig, err := p.signer.Sign(msg)
+	if err != nil {
+		return fmt.Errorf("sign: %w", err)
+	}
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	cur := p.store.ReleasesByVersion[rel.Version]
+	if cur == nil || cur.Sha256 != rel.Sha256 {
+		return errors.New("release changed while its artifact was read; run the job again")
+	}
+	next := p.store.cloneState()
+	changed := *cur
+	changed.Signature = base64.StdEncoding.EncodeToString(sig)
+	changed.KeyID = p.signer.KeyID()
+	changed.SigScheme = artifactsig.Scheme
+	next.ReleasesByVersion[rel.Version] = &changed
+	if err := p.saveStore(next); err != nil {
+		return fsErr(err, "save metadata")
+	}
+	p.store.ReleasesByVersion = next.ReleasesByVersion
+	p.refreshTUF(p.store)
+	// 镜像中的 manifest 注解带着签名，按新签名重新推送
+	p.mirrorRelease(&changed)
+	return nil
+}
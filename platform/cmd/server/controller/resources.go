@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 按版本的资源画像：agent 每 10 秒采样一次算法进程的 CPU、RSS 与文件描述符数，两次心跳之间的均值与
+// 最大值随心跳上报（data.resources）。服务端按心跳时运行的版本汇总，并与基线版本（缺省为设备最多的版本）
+// 比较，新版本在少量设备上多用了多少 CPU 与内存，在推向全机队之前就能看到。
+
+// resourceWindow 是资源画像缺省统计的时间范围。
+const resourceWindow = 24 * time.Hour
+
+// ResourceProfile 是一个版本在统计范围内的资源占用。
+type ResourceProfile struct {
+	Version    string  `json:"version"`
+	Devices    int     `json:"devices"`
+	Heartbeats int     `json:"heartbeats"` // 带资源数据的心跳数
+	CPUPct     float64 `json:"cpu_pct"`    // 心跳区间 CPU 占用的均值（百分比，多核时可超过 100）
+	CPUPctP95  float64 `json:"cpu_pct_p95"`
+	CPUPctMax  float64 `json:"cpu_pct_max"`
+	RSSKB      float64 `json:"rss_kb"`
+	RSSKBMax   float64 `json:"rss_kb_max"`
+	FDs        float64 `json:"fds"`
+	FDsMax     float64 `json:"fds_max"`
+	// CPUDeltaPct / RSSDeltaPct 是相对基线版本均值的变化百分比，基线版本本身与基线为 0 时省略。
+	CPUDeltaPct *float64 `json:"cpu_delta_pct,omitempty"`
+	RSSDeltaPct *float64 `json:"rss_delta_pct,omitempty"`
+}
+
+// resourceSums accumulates the heartbeats of one version.
+type resourceSums struct {
+	devices map[string]bool
+	cpu     []float64
+	prof    ResourceProfile
+}
+
+func (s *resourceSums) add(device string, res map[string]any) {
+	num := func(k string) float64 {
+		f, _ := res[k].(float64)
+		return f
+	}
+	s.devices[device] = true
+	s.cpu = append(s.cpu, num("cpu_pct"))
+	p := &s.prof
+	p.Heartbeats++
+	p.CPUPct += num("cpu_pct")
+	p.CPUPctMax = math.Max(p.CPUPctMax, num("cpu_pct_max"))
+	p.RSSKB += num("rss_kb")
+	p.RSSKBMax = math.Max(p.RSSKBMax, num("rss_kb_max"))
+	p.FDs += num("fds")
+	p.FDsMax = math.Max(p.FDsMax, num("fds_max"))
+}
+
+func (s *resourceSums) profile() ResourceProfile {
+	p := s.prof
+	n := float64(p.Heartbeats)
+	p.Devices = len(s.devices)
+	p.CPUPct, p.RSSKB, p.FDs = round1(p.CPUPct/n), round1(p.RSSKB/n), round1(p.FDs/n)
+	sort.Float64s(s.cpu)
+	p.CPUPctP95 = s.cpu[int(math.Ceil(0.95*n))-1]
+	return p
+}
+
+func round1(f float64) float64 { return math.Round(f*10) / 10 }
+
+// deltaPct is the change from base to v in percent, nil when base is 0.
+func deltaPct(v, base float64) *float64 {
+	if base == 0 {
+		return nil
+	}
+	d := round1((v - base) / base * 100)
+	return &d
+}
+
+// Resources godoc
+// @Summary      Algorithm resource usage per version
+// @Description  Aggregates the CPU, resident memory and open file descriptors of the algorithm process that agents sample and report with their heartbeats, per running version, and compares each version with a baseline (default: the version most devices ran) so a release's extra cost shows up before it reaches the whole fleet.
+// @Tags         device
+// @Produce      json
+// @Param        channel   query  string  false  "Only this channel"
+// @Param        since     query  string  false  "RFC 3339 start of the window, default: 24 hours ago"
+// @Param        baseline  query  string  false  "Version to compare against, default: the version with the most devices"
+// @Success      200  {object}  map[string]any  "baseline, versions ([]controller.ResourceProfile, newest first)"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/fleet/resources [get]
+func (c *FleetController) Resources(g *gin.Context) {
+	since := c.p.clock.Now().Add(-resourceWindow)
+	if v := g.Query("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid since: "+err.Error())
+			return
+		}
+		since = t
+	}
+	evs, err := c.p.events.Query(EventQuery{Type: "heartbeat", Since: since})
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	only := g.Query("channel")
+	byVersion := map[string]*resourceSums{}
+	for _, ev := range evs {
+		res, ok := ev.Data["resources"].(map[string]any)
+		if !ok || ev.Version == "" || (only != "" && ev.Channel != only) {
+			continue
+		}
+		s := byVersion[ev.Version]
+		if s == nil {
+			s = &resourceSums{devices: map[string]bool{}, prof: ResourceProfile{Version: ev.Version}}
+			byVersion[ev.Version] = s
+		}
+		s.add(ev.DeviceID, res)
+	}
+
+	profiles := make([]ResourceProfile, 0, len(byVersion))
+	for _, s := range byVersion {
+		profiles = append(profiles, s.profile())
+	}
+	sort.Slice(profiles, func(i, j int) bool { return version.Newer(profiles[i].Version, profiles[j].Version) })
+	baseline := g.Query("baseline")
+	if baseline == "" {
+		for _, p := range profiles {
+			if b := byVersion[baseline]; b == nil || p.Devices > len(b.devices) {
+				baseline = p.Version
+			}
+		}
+	}
+	if b := byVersion[baseline]; b != nil {
+		base := b.profile()
+		for i := range profiles {
+			if profiles[i].Version != baseline {
+				profiles[i].CPUDeltaPct = deltaPct(profiles[i].CPUPct, base.CPUPct)
+				profiles[i].RSSDeltaPct = deltaPct(profiles[i].RSSKB, base.RSSKB)
+			}
+		}
+	}
+	g.JSON(http.StatusOK, gin.H{"baseline": baseline, "versions": profiles})
+}
@@ -0,0 +1,762 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 渐进发布：渠道的新版本先只下发给一部分设备，按观察到的健康状况逐级放量（缺省 5% → 25% → 100%）。
+// 设备按 ID 与发布 ID 的哈希分桶，桶号小于当前比例的设备在 /check 中得到新版本，其余设备得到基线版本
+// （渠道中上一个版本）；已安装新版本的设备不受影响。后台每分钟统计本级开始以来运行（以新版本检查或
+// 发送心跳）或尝试安装新版本的设备：安装失败、回滚、崩溃事件或检查时上报算法崩溃（algo_health）的
+// 设备计为失败。失败设备超出错误预算（max_failure_rate 乘以 max(设备数, min_devices)）时停止放量
+// （halted，比例归零，已安装的设备不回滚）并告警，版本以健康统计为证据移入隔离渠道（见 quarantine.go），
+// 渠道改指上一个未召回的版本，恢复版本后才能继续放量；soak 时长内未超出且设备数达到 min_devices 时进入
+// 下一级，到 100% 即完成。操作员可以随时暂停、恢复自动放量、手动设定比例或中止（比例归零，直到渠道
+// 发布了更新的版本）；渠道最新版本变化后发布即被取代。所有状态变化写入发布的历史与审计日志。
+// 只针对主应用。
+
+const (
+	RolloutRunning    = "running"
+	RolloutPaused     = "paused"
+	RolloutHalted     = "halted"
+	RolloutCompleted  = "completed"
+	RolloutAborted    = "aborted"
+	RolloutSuperseded = "superseded"
+
+	defaultRolloutSoak        = time.Hour
+	defaultRolloutFailureRate = 0.05
+	defaultRolloutMinDevices  = 5
+)
+
+var defaultRolloutSteps = []int{5, 25, 100}
+
+// RolloutChange 是渐进发布的一次比例或状态变化。
+type RolloutChange struct {
+	At      time.Time `json:"at"`
+	Percent int       `json:"percent"`
+	State   string    `json:"state"`
+	By      string    `json:"by"` // 后台的自动变化为 system
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// RolloutHealth 是本级开始以来运行或尝试安装目标版本的设备的统计。
+type RolloutHealth struct {
+	Since       time.Time `json:"since"`
+	Devices     int       `json:"devices"`
+	Failed      int       `json:"failed"`
+	FailureRate float64   `json:"failure_rate"`
+	// FailedDevices 列出部分失败设备及原因，便于排查。
+	FailedDevices map[string]string `json:"failed_devices,omitempty"`
+	EvaluatedAt   time.Time         `json:"evaluated_at"`
+}
+
+// Rollout 是一次渐进发布。
+type Rollout struct {
+	ID       string `json:"id"`
+	Channel  string `json:"channel"`
+	Version  string `json:"version"`  // 目标版本，渠道最新版本
+	Baseline string `json:"baseline"` // 不在比例内的设备得到的版本，为空时不下发
+	Steps    []int  `json:"steps"`    // 逐级的比例，最后一级为 100
+	Step     int    `json:"step"`     // 当前（或恢复时继续的）一级在 Steps 中的下标
+	Percent  int    `json:"percent"`  // 当前比例，halted 与 aborted 为 0
+	// SoakMinutes 是每一级至少观察的时长，MaxFailureRate 与 MinDevices 决定能否进入下一级。
+	SoakMinutes    int       `json:"soak_minutes"`
+	MaxFailureRate float64   `json:"max_failure_rate"`
+	MinDevices     int       `json:"min_devices"`
+	State          string    `json:"state"` // running | paused | halted | completed | aborted | superseded
+	StepStartedAt  time.Time `json:"step_started_at"`
+	// Health 是最近一次自动评估的结果。
+	Health     *RolloutHealth  `json:"health,omitempty"`
+	History    []RolloutChange `json:"history"`
+	CreatedBy  string          `json:"created_by"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+func (r *Rollout) clone() Rollout {
+	out := *r
+	out.Steps = append([]int(nil), r.Steps...)
+	out.History = append([]RolloutChange(nil), r.History...)
+	if r.Health != nil {
+		h := *r.Health
+		out.Health = &h
+	}
+	return out
+}
+
+// holding reports whether the rollout still decides who gets its version.
+// An aborted rollout keeps holding it back until the channel moves on.
+func (r *Rollout) holding() bool {
+	switch r.State {
+	case RolloutRunning, RolloutPaused, RolloutHalted, RolloutAborted:
+		return true
+	}
+	return false
+}
+
+// open reports whether the rollout can still change.
+func (r *Rollout) open() bool {
+	return r.State == RolloutRunning || r.State == RolloutPaused || r.State == RolloutHalted
+}
+
+// change records a new percent and state.
+func (r *Rollout) change(now time.Time, percent int, state, by, reason string) {
+	r.Percent, r.State = percent, state
+	r.History = append(r.History, RolloutChange{At: now, Percent: percent, State: state, By: by, Reason: reason})
+	if !r.open() {
+		r.FinishedAt = &now
+	}
+}
+
+// startStep moves to Steps[i] and restarts the soak.
+func (r *Rollout) startStep(now time.Time, i int, by, reason string) {
+	r.Step, r.StepStartedAt = i, now
+	state := RolloutRunning
+	if r.Steps[i] == 100 {
+		state = RolloutCompleted
+	}
+	r.change(now, r.Steps[i], state, by, reason)
+}
+
+// rolloutBucket places a device in one of 100 buckets; a new rollout ID
+// reshuffles the devices so the same ones do not always go first.
+func rolloutBucket(id, device string) int {
+	sum := sha256.Sum256([]byte(id + "\x00" + device))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// rolloutHold returns the rollout holding latest back from device and the
+// baseline release it gets instead (nil when there is none). Callers hold
+// p.store.mu.
+func (p *Platform) rolloutHold(device, channel, current string, latest *Release) (*Rollout, *Release) {
+	if latest == nil {
+		return nil, nil
+	}
+	var ro *Rollout
+	for _, r := range p.store.Rollouts {
+		// 同一版本被多次发布时以最近开始的为准
+		if r.holding() && r.Channel == channel && r.Version == latest.Version && (ro == nil || r.CreatedAt.After(ro.CreatedAt)) {
+			ro = r
+		}
+	}
+	if ro == nil || current == ro.Version || (current != "" && version.Newer(current, ro.Version)) {
+		return nil, nil
+	}
+	if device != "" && rolloutBucket(ro.ID, device) < ro.Percent {
+		return nil, nil
+	}
+	base := p.store.ReleasesByVersion[ro.Baseline]
+	if base != nil && base.Quarantine != nil {
+		base = nil
+	}
+	return ro, base
+}
+
+// rolloutBaseline is the newest release of channel older than v that is
+// neither recalled nor quarantined. Callers hold p.store.mu.
+func (s *Store) rolloutBaseline(channel, v string) string {
+	base := ""
+	for rv, rel := range s.ReleasesByVersion {
+		if rel.App != "" || rel.Channel != channel || rel.Recall != nil || !version.Newer(v, rv) {
+			continue
+		}
+		if base == "" || version.Newer(rv, base) {
+			base = rv
+		}
+	}
+	return base
+}
+
+// rolloutHealth counts the devices that ran or tried to install the
+// rollout's version since its current step started.
+func (p *Platform) rolloutHealth(ro Rollout, now time.Time) (RolloutHealth, error) {
+	h := RolloutHealth{Since: ro.StepStartedAt, EvaluatedAt: now}
+	var evs []*DeviceEvent
+	for _, q := range []EventQuery{
+		{Type: "report", Since: ro.StepStartedAt},
+		{Type: "check", Version: ro.Version, Since: ro.StepStartedAt},
+		{Type: "crash", Version: ro.Version, Since: ro.StepStartedAt},
+		{Type: "heartbeat", Version: ro.Version, Since: ro.StepStartedAt},
+	} {
+		list, err := p.events.Query(q)
+		if err != nil {
+			return h, err
+		}
+		evs = append(evs, list...)
+	}
+	failed := map[string]string{}
+	seen := map[string]bool{}
+	for _, ev := range evs {
+		if ev.DeviceID == "" {
+			continue
+		}
+		// 以新版本检查或发送心跳的设备即在运行它
+		var failure string
+		switch ev.Type {
+		case "report":
+			status, _ := ev.Data["status"].(string)
+			switch {
+			case ev.Data["to"] == ro.Version && status == "success":
+			case ev.Data["to"] == ro.Version && status == "failure":
+				failure = fmt.Sprintf("install failed: %v", ev.Data["error"])
+			case ev.Data["from"] == ro.Version && (status == "rolled_back" || status == "rollback_failed"):
+				failure = status
+				if reason, _ := ev.Data["reason"].(string); reason != "" {
+					failure += ": " + reason
+				}
+			default:
+				continue
+			}
+		case "check":
+			if ev.Data["algo_health"] == algoCrashing {
+				failure = "algorithm crashing"
+			}
+		case "crash":
+			failure = fmt.Sprintf("crash: %v", ev.Data["exit"])
+		}
+		seen[ev.DeviceID] = true
+		if failure != "" {
+			failed[ev.DeviceID] = failure
+		}
+	}
+	h.Devices, h.Failed = len(seen), len(failed)
+	if h.Devices > 0 {
+		h.FailureRate = float64(h.Failed) / float64(h.Devices)
+	}
+	if len(failed) > 0 {
+		h.FailedDevices = map[string]string{}
+		for _, d := range sortedKeys(failed)[:min(len(failed), 20)] {
+			h.FailedDevices[d] = failed[d]
+		}
+	}
+	return h, nil
+}
+
+// overBudget reports whether the failures exhaust the error budget; below
+// min_devices the budget is that of min_devices, so a few early failures
+// already halt the rollout.
+func (r *Rollout) overBudget(h RolloutHealth) bool {
+	return float64(h.Failed) > r.MaxFailureRate*float64(max(h.Devices, r.MinDevices))
+}
+
+// advanceRollouts evaluates the running rollouts: a breached error budget
+// halts them, a clean soak with enough devices moves them to the next step.
+func (p *Platform) advanceRollouts() {
+	p.store.mu.RLock()
+	var work []Rollout
+	for _, r := range p.store.Rollouts {
+		if r.open() {
+			work = append(work, r.clone())
+		}
+	}
+	p.store.mu.RUnlock()
+	if len(work) == 0 {
+		return
+	}
+
+	// 事件查询不持有 store 锁
+	now := p.clock.Now()
+	health := map[string]RolloutHealth{}
+	for _, r := range work {
+		if r.State != RolloutRunning {
+			continue
+		}
+		h, err := p.rolloutHealth(r, now)
+		if err != nil {
+			log.Printf("rollout %s: %v", r.ID, err)
+			continue
+		}
+		health[r.ID] = h
+	}
+
+	type notice struct {
+		action, detail string
+		ro             Rollout
+	}
+	var notices []notice
+	p.store.mu.Lock()
+	// 在副本上修改，保存成功后再换入，保存失败时内存中的状态与磁盘一致
+	rollouts := make(map[string]*Rollout, len(p.store.Rollouts))
+	for id, r := range p.store.Rollouts {
+		rollouts[id] = r
+	}
+	changed := false
+	for _, w := range work {
+		cur := p.store.Rollouts[w.ID]
+		if cur == nil || cur.State != w.State || !cur.StepStartedAt.Equal(w.StepStartedAt) {
+			continue // 期间被手动调整
+		}
+		next := cur.clone()
+		r := &next
+		// 停止放量时被隔离的版本不再是渠道最新版本，发布保持 halted，直到版本恢复后重新放量
+		if latest := p.store.LatestByChannel[r.Channel]; latest != r.Version && !(r.State == RolloutHalted && p.store.quarantined(r.Version)) {
+			r.change(now, r.Percent, RolloutSuperseded, "system", "the "+r.Channel+" channel moved to "+latest)
+			rollouts[r.ID], changed = r, true
+			notices = append(notices, notice{"rollout_superseded", "", r.clone()})
+			continue
+		}
+		h, ok := health[r.ID]
+		if !ok {
+			continue
+		}
+		// 统计与状态都没有变化时不必每分钟保存
+		if r.Health == nil || r.Health.Devices != h.Devices || r.Health.Failed != h.Failed {
+			changed = true
+		}
+		r.Health = &h
+		switch {
+		case r.overBudget(h):
+			detail := fmt.Sprintf("%d of %d devices failed at %d%% (max failure rate %g)", h.Failed, h.Devices, r.Percent, r.MaxFailureRate)
+			r.change(now, 0, RolloutHalted, "system", detail)
+			changed = true
+			notices = append(notices, notice{"rollout_halted", detail, r.clone()})
+		case now.Sub(r.StepStartedAt) >= time.Duration(r.SoakMinutes)*time.Minute && h.Devices >= r.MinDevices:
+			detail := fmt.Sprintf("%d of %d devices failed over %d minutes at %d%%", h.Failed, h.Devices, r.SoakMinutes, r.Percent)
+			r.startStep(now, r.Step+1, "system", detail)
+			changed = true
+			action := "rollout_advanced"
+			if r.State == RolloutCompleted {
+				action = "rollout_completed"
+			}
+			notices = append(notices, notice{action, detail, r.clone()})
+		}
+		rollouts[r.ID] = r
+	}
+	var err error
+	if changed {
+		next := p.store.cloneState()
+		next.Rollouts = rollouts
+		if err = p.saveStore(next); err == nil {
+			p.store.Rollouts = rollouts
+			// 超出错误预算的版本移入隔离渠道，渠道改指上一个可用版本，健康统计作为证据
+			for _, n := range notices {
+				if n.action != "rollout_halted" {
+					continue
+				}
+				q := Quarantine{Trigger: QuarantineRollout, Detail: "rollout " + n.ro.ID + " halted: " + n.detail,
+					Evidence: []string{"/api/v1/rollouts/" + n.ro.ID, "/api/v1/crashes?version=" + n.ro.Version}}
+				if qerr := p.quarantine(n.ro.Version, q, "system"); qerr != nil {
+					log.Printf("rollout %s: quarantine %s: %v", n.ro.ID, n.ro.Version, qerr)
+				}
+			}
+		}
+	}
+	p.store.mu.Unlock()
+	if !changed {
+		return
+	}
+	if err != nil {
+		log.Printf("save rollouts: %v", err)
+		return
+	}
+	for _, n := range notices {
+		_ = p.audit("system", n.action, n.detail, map[string]any{"id": n.ro.ID, "channel": n.ro.Channel, "version": n.ro.Version, "percent": n.ro.Percent})
+		switch n.action {
+		case "rollout_halted":
+			p.emitAlert(n.action, fmt.Sprintf("rollout %s of %s to %s halted: %s", n.ro.ID, n.ro.Version, n.ro.Channel, n.detail))
+		case "rollout_completed":
+			p.emitAlert(n.action, fmt.Sprintf("rollout %s of %s to %s reached 100%%", n.ro.ID, n.ro.Version, n.ro.Channel))
+		}
+	}
+}
+
+type RolloutController struct {
+	BaseController
+	p *Platform
+}
+
+func NewRolloutController(p *Platform) *RolloutController {
+	return &RolloutController{p: p}
+}
+
+// Start godoc
+// @Summary      Start a progressive rollout
+// @Description  Offers a channel's new primary release to a growing share of its devices (default 5%, 25%, 100%). Devices are bucketed by a hash of their ID; those outside the current percentage get the baseline, the previous release of the channel. The version defaults to the channel's latest; a newer release from another channel (e.g. beta) is moved into the channel and becomes its latest in the same save. Every minute the server counts the devices that ran (checked in or sent heartbeats with) or tried to install the version during the current step: failed installs, rollbacks, crashes and crashing algorithm health count as failures. More failures than max_failure_rate × max(devices, min_devices) halt the rollout, raise an alert and quarantine the release with the rollout health as evidence (the channel moves back to its previous release that is not recalled); a soak of soak_minutes without that and at least min_devices devices advances it to the next step.
+// @Tags         rollout
+// @Accept       json
+// @Produce      json
+// @Param        body  body  object  true  "{\"channel\": \"stable\", \"version\": \"2.1.0\", \"steps\": [5, 25, 100], \"soak_minutes\": 60, \"max_failure_rate\": 0.05, \"min_devices\": 5, \"reason\": \"...\"}"
+// @Success      200  {object}  controller.Rollout
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/rollouts [post]
+func (c *RolloutController) Start(g *gin.Context) {
+	var body struct {
+		Channel        string   `json:"channel"`
+		Version        string   `json:"version"`
+		Steps          []int    `json:"steps"`
+		SoakMinutes    int      `json:"soak_minutes"`
+		MaxFailureRate *float64 `json:"max_failure_rate"`
+		MinDevices     int      `json:"min_devices"`
+		Reason         string   `json:"reason"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	body.Channel, body.Version = strings.TrimSpace(body.Channel), strings.TrimSpace(body.Version)
+	if body.Channel == "" || strings.Contains(body.Channel, "/") || body.Channel == QuarantineChannel {
+		c.ResponseFailure(g, ErrParam, "a valid channel is required")
+		return
+	}
+	if len(body.Steps) == 0 {
+		body.Steps = defaultRolloutSteps
+	}
+	for i, s := range body.Steps {
+		if s < 1 || s > 100 || (i > 0 && s <= body.Steps[i-1]) {
+			c.ResponseFailure(g, ErrParam, "steps must be increasing percentages between 1 and 100")
+			return
+		}
+	}
+	if body.Steps[len(body.Steps)-1] != 100 {
+		c.ResponseFailure(g, ErrParam, "the last step must be 100")
+		return
+	}
+	if body.SoakMinutes < 0 || body.MinDevices < 0 {
+		c.ResponseFailure(g, ErrParam, "soak_minutes and min_devices must not be negative")
+		return
+	}
+	if body.SoakMinutes == 0 {
+		body.SoakMinutes = int(defaultRolloutSoak / time.Minute)
+	}
+	if body.MinDevices == 0 {
+		body.MinDevices = defaultRolloutMinDevices
+	}
+	rate := defaultRolloutFailureRate
+	if body.MaxFailureRate != nil {
+		rate = *body.MaxFailureRate
+	}
+	if rate < 0 || rate >= 1 {
+		c.ResponseFailure(g, ErrParam, "max_failure_rate must be at least 0 and below 1")
+		return
+	}
+	actor := c.p.principal(g).Name
+	now := c.p.clock.Now()
+
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	latest := c.p.store.LatestByChannel[body.Channel]
+	if body.Version == "" {
+		body.Version = latest
+	}
+	rel := c.p.store.ReleasesByVersion[body.Version]
+	var problem string
+	switch {
+	case body.Version == "":
+		problem = "the " + body.Channel + " channel has no release"
+	case rel == nil:
+		problem = "unknown version " + body.Version
+	case rel.App != "":
+		problem = "rollouts cover the primary algorithm only, " + body.Version + " belongs to app " + rel.App
+	case rel.Quarantine != nil:
+		problem = body.Version + " is quarantined"
+	case rel.Recall != nil:
+		problem = body.Version + " is recalled"
+	case body.Version != latest && latest != "" && !version.Newer(body.Version, latest):
+		problem = body.Version + " is not newer than the latest " + body.Channel + " release " + latest
+	}
+	for _, r := range c.p.store.Rollouts {
+		if problem == "" && r.open() && r.Channel == body.Channel {
+			problem = "channel already has an open rollout (" + r.ID + ")"
+		}
+	}
+	if problem != "" {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, problem)
+		return
+	}
+	r := &Rollout{
+		ID:             shadowID(body.Channel, body.Version, now),
+		Channel:        body.Channel,
+		Version:        body.Version,
+		Baseline:       latest,
+		Steps:          body.Steps,
+		SoakMinutes:    body.SoakMinutes,
+		MaxFailureRate: rate,
+		MinDevices:     body.MinDevices,
+		CreatedBy:      actor,
+		CreatedAt:      now,
+	}
+	if body.Version == latest {
+		r.Baseline = c.p.store.rolloutBaseline(body.Channel, body.Version)
+	}
+	r.startStep(now, 0, actor, strings.TrimSpace(body.Reason))
+	next := c.p.store
+	if body.Version != latest {
+		// 与影子部署的提升一样，切换渠道指针与创建发布在同一次保存中完成，不会有设备提前拿到新版本
+		next = c.p.store.cloneState()
+		moved := *rel
+		moved.Channel = body.Channel
+		next.ReleasesByVersion[body.Version] = &moved
+		next.LatestByChannel[body.Channel] = body.Version
+	}
+	if c.p.store.Rollouts == nil {
+		c.p.store.Rollouts = map[string]*Rollout{}
+	}
+	c.p.store.Rollouts[r.ID] = r
+	next.Rollouts = c.p.store.Rollouts
+	err := c.p.saveStore(next)
+	if err != nil {
+		delete(c.p.store.Rollouts, r.ID)
+	} else if next != c.p.store {
+		c.p.store.ReleasesByVersion = next.ReleasesByVersion
+		c.p.store.LatestByChannel = next.LatestByChannel
+		c.p.recordLatest(ChangeRollout, actor)
+		c.p.refreshTUF(c.p.store)
+	}
+	out := r.clone()
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save rollout"), fsErr(err, "save rollout").Error())
+		return
+	}
+	_ = c.p.audit(actor, "rollout_started", strings.TrimSpace(body.Reason), map[string]any{"id": out.ID, "channel": out.Channel, "version": out.Version, "baseline": out.Baseline, "steps": out.Steps})
+	g.JSON(http.StatusOK, out)
+}
+
+// List godoc
+// @Summary      List progressive rollouts
+// @Description  Progressive rollouts, newest first.
+// @Tags         rollout
+// @Produce      json
+// @Param        state  query  string  false  "Only this state (running|paused|halted|completed|aborted|superseded)"
+// @Success      200  {object}  map[string]any  "rollouts"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/rollouts [get]
+func (c *RolloutController) List(g *gin.Context) {
+	state := g.Query("state")
+	c.p.store.mu.RLock()
+	out := make([]Rollout, 0, len(c.p.store.Rollouts))
+	for _, r := range c.p.store.Rollouts {
+		if state == "" || r.State == state {
+			out = append(out, r.clone())
+		}
+	}
+	c.p.store.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	g.JSON(http.StatusOK, gin.H{"rollouts": out})
+}
+
+// Get godoc
+// @Summary      Get a progressive rollout
+// @Description  The rollout with its step history; health is evaluated on the spot for the current step.
+// @Tags         rollout
+// @Produce      json
+// @Param        id  path  string  true  "Rollout ID"
+// @Success      200  {object}  controller.Rollout
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/rollouts/{id} [get]
+func (c *RolloutController) Get(g *gin.Context) {
+	c.p.store.mu.RLock()
+	r := c.p.store.Rollouts[g.Param("id")]
+	var out Rollout
+	if r != nil {
+		out = r.clone()
+	}
+	c.p.store.mu.RUnlock()
+	if r == nil {
+		c.ResponseFailure(g, ErrNotFound, "rollout not found")
+		return
+	}
+	if out.open() {
+		h, err := c.p.rolloutHealth(out, c.p.clock.Now())
+		if err != nil {
+			c.ResponseFailure(g, ErrInternal, err.Error())
+			return
+		}
+		out.Health = &h
+	}
+	g.JSON(http.StatusOK, out)
+}
+
+// SetPercent godoc
+// @Summary      Set a rollout's percentage
+// @Description  Manual override: offers the version to the given share of devices and pauses automatic advancing; 100 completes the rollout, 0 holds the version back from devices that do not have it yet. Resuming continues from the first step at or above the percentage.
+// @Tags         rollout
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true  "Rollout ID"
+// @Param        body  body  object  true  "{\"percent\": 50, \"reason\": \"...\"}"
+// @Success      200  {object}  controller.Rollout
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/rollouts/{id}/percent [post]
+func (c *RolloutController) SetPercent(g *gin.Context) {
+	var body struct {
+		Percent *int   `json:"percent"`
+		Reason  string `json:"reason"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	if body.Percent == nil || *body.Percent < 0 || *body.Percent > 100 {
+		c.ResponseFailure(g, ErrParam, "percent between 0 and 100 is required")
+		return
+	}
+	n := *body.Percent
+	c.override(g, "rollout_percent_set", body.Reason, func(r *Rollout, now time.Time, actor, reason string) string {
+		state := RolloutPaused
+		if n == 100 {
+			state = RolloutCompleted
+		}
+		r.Step = len(r.Steps) - 1
+		for i, s := range r.Steps {
+			if s >= n {
+				r.Step = i
+				break
+			}
+		}
+		r.StepStartedAt = now
+		r.change(now, n, state, actor, reason)
+		return ""
+	})
+}
+
+// Pause godoc
+// @Summary      Pause a progressive rollout
+// @Description  Stops automatic advancing and halting; the current percentage stays.
+// @Tags         rollout
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true   "Rollout ID"
+// @Param        body  body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  controller.Rollout
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/rollouts/{id}/pause [post]
+func (c *RolloutController) Pause(g *gin.Context) {
+	c.overrideWithReason(g, "rollout_paused", func(r *Rollout, now time.Time, actor, reason string) string {
+		if r.State != RolloutRunning {
+			return "rollout is " + r.State
+		}
+		r.change(now, r.Percent, RolloutPaused, actor, reason)
+		return ""
+	})
+}
+
+// Resume godoc
+// @Summary      Resume a progressive rollout
+// @Description  Returns a paused or halted rollout to automatic control at its current step, with a fresh soak; the health count starts over. A release quarantined by the halt must be restored first.
+// @Tags         rollout
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true   "Rollout ID"
+// @Param        body  body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  controller.Rollout
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/rollouts/{id}/resume [post]
+func (c *RolloutController) Resume(g *gin.Context) {
+	c.overrideWithReason(g, "rollout_resumed", func(r *Rollout, now time.Time, actor, reason string) string {
+		if r.State == RolloutRunning {
+			return "rollout is already running"
+		}
+		if c.p.store.quarantined(r.Version) {
+			return r.Version + " is quarantined; restore it first"
+		}
+		r.Health = nil
+		r.startStep(now, r.Step, actor, reason)
+		return ""
+	})
+}
+
+// Abort godoc
+// @Summary      Abort a progressive rollout
+// @Description  Ends the rollout at 0%: devices that do not have the version yet keep getting the baseline until the channel moves to a newer release. Devices that installed it are not rolled back (recall the release for that).
+// @Tags         rollout
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true   "Rollout ID"
+// @Param        body  body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  controller.Rollout
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/rollouts/{id}/abort [post]
+func (c *RolloutController) Abort(g *gin.Context) {
+	c.overrideWithReason(g, "rollout_aborted", func(r *Rollout, now time.Time, actor, reason string) string {
+		r.change(now, 0, RolloutAborted, actor, reason)
+		return ""
+	})
+}
+
+// overrideWithReason reads an optional {"reason"} body and applies fn.
+func (c *RolloutController) overrideWithReason(g *gin.Context, action string, fn func(r *Rollout, now time.Time, actor, reason string) string) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&body); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	c.override(g, action, body.Reason, fn)
+}
+
+// override applies a manual change to an open rollout; fn returns a
+// problem to refuse it.
+func (c *RolloutController) override(g *gin.Context, action, reason string, fn func(r *Rollout, now time.Time, actor, reason string) string) {
+	reason = strings.TrimSpace(reason)
+	actor := c.p.principal(g).Name
+	now := c.p.clock.Now()
+
+	c.p.store.mu.Lock()
+	r := c.p.store.Rollouts[g.Param("id")]
+	if r == nil {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "rollout not found")
+		return
+	}
+	if !r.open() {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, "rollout is already "+r.State)
+		return
+	}
+	prev := r.clone()
+	if problem := fn(r, now, actor, reason); problem != "" {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, problem)
+		return
+	}
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		*r = prev
+	}
+	out := r.clone()
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save rollout"), fsErr(err, "save rollout").Error())
+		return
+	}
+	_ = c.p.audit(actor, action, reason, map[string]any{"id": out.ID, "channel": out.Channel, "version": out.Version, "percent": out.Percent, "state": out.State})
+	g.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,438 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 影子部署：候选版本（通常发布在 beta 等其它渠道）在目标渠道的设备上作为被动的第二个进程运行，
+// 与现役版本并行 soak。候选版本须带 shadow_args（发布时给出，如 --port=9101 --no-actuate），
+// agent 以这些参数启动它——换一个端口、不输出执行器指令——并周期上报两个进程各自写出的指标。
+// 操作员在对比视图中确认候选版本的表现后批准提升：渠道 latest 切换到候选版本，设备按正常流程更新；
+// 也可以中止，设备在下一次检查时停止影子进程。开始、提升与中止写入审计日志。
+
+const (
+	ShadowRunning  = "running"
+	ShadowPromoted = "promoted"
+	ShadowAborted  = "aborted"
+
+	defaultShadowSoak = 24 * time.Hour
+)
+
+// ShadowDeployment 是一次影子部署。
+type ShadowDeployment struct {
+	ID      string `json:"id"`
+	Channel string `json:"channel"` // 在该渠道的设备上影子运行，提升时切换其 latest
+	Version string `json:"version"` // 候选版本
+	// Devices 限定参与的设备（path.Match 通配），为空时渠道内所有设备参与。
+	Devices     []string   `json:"devices,omitempty"`
+	SoakMinutes int        `json:"soak_minutes"` // 每台设备影子运行的时长
+	State       string     `json:"state"`        // running | promoted | aborted
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedBy  string     `json:"finished_by,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+}
+
+func (s *ShadowDeployment) clone() ShadowDeployment {
+	out := *s
+	out.Devices = append([]string(nil), s.Devices...)
+	return out
+}
+
+// ShadowMetric 比较同一指标在现役进程与影子进程上的设备均值。
+type ShadowMetric struct {
+	Name   string  `json:"name"`
+	Active float64 `json:"active"`
+	Shadow float64 `json:"shadow"`
+	// DeltaPct 是影子相对现役的变化百分比，现役均值为 0 时省略。
+	DeltaPct *float64 `json:"delta_pct,omitempty"`
+	Devices  int      `json:"devices"` // 两个进程都上报了该指标的设备数
+}
+
+// ShadowReport 是影子部署及其按设备最近一次上报汇总的对比结果。
+type ShadowReport struct {
+	ShadowDeployment
+	Reporting     int            `json:"reporting"`      // 上报过对比指标的设备数
+	Soaked        int            `json:"soaked"`         // 已跑满 soak 时长的设备数
+	ShadowCrashes int            `json:"shadow_crashes"` // 影子进程意外退出的次数合计
+	Metrics       []ShadowMetric `json:"metrics"`
+}
+
+func shadowID(channel, ver string, now time.Time) string {
+	sum := sha256.Sum256([]byte(channel + "\x00" + ver + "\x00" + now.String()))
+	return hex.EncodeToString(sum[:6])
+}
+
+// shadowFor returns the running shadow deployment device takes part in and
+// its candidate release. Callers hold p.store.mu.
+func (p *Platform) shadowFor(device, channel, current string) (*ShadowDeployment, *Release) {
+	if device == "" {
+		return nil, nil
+	}
+	for _, s := range p.store.Shadows {
+		if s.State != ShadowRunning || s.Channel != channel || (len(s.Devices) > 0 && !matchAny(s.Devices, device)) {
+			continue
+		}
+		// 已经作为现役版本运行候选版本的设备无需影子运行
+		if current == s.Version || (current != "" && version.Newer(current, s.Version)) {
+			continue
+		}
+		if rel := p.store.ReleasesByVersion[s.Version]; rel != nil && rel.Quarantine == nil {
+			return s, rel
+		}
+	}
+	return nil, nil
+}
+
+// downloadChannel is the channel a download of rel is authorized against:
+// its own, or the channel of a running shadow deployment of it that the
+// caller may access. Callers hold p.store.mu.
+func (p *Platform) downloadChannel(g *gin.Context, rel *Release) string {
+	pr := p.principal(g)
+	if pr.CanAccess(rel.Channel) {
+		return rel.Channel
+	}
+	for _, s := range p.store.Shadows {
+		if s.State == ShadowRunning && s.Version == rel.Version && pr.CanAccess(s.Channel) {
+			return s.Channel
+		}
+	}
+	return rel.Channel
+}
+
+// compareShadow aggregates the latest shadow report of each device, events
+// newest first.
+func compareShadow(s ShadowDeployment, evs []*DeviceEvent) ShadowReport {
+	rep := ShadowReport{ShadowDeployment: s, Metrics: []ShadowMetric{}}
+	type sums struct {
+		active, shadow float64
+		n              int
+	}
+	metrics := map[string]*sums{}
+	seen := map[string]bool{}
+	for _, ev := range evs {
+		if ev.Data["shadow_id"] != s.ID || ev.DeviceID == "" || seen[ev.DeviceID] {
+			continue
+		}
+		seen[ev.DeviceID] = true
+		rep.Reporting++
+		if final, _ := ev.Data["final"].(bool); final {
+			rep.Soaked++
+		}
+		if n, ok := ev.Data["crashes"].(float64); ok {
+			rep.ShadowCrashes += int(n)
+		}
+		active, _ := ev.Data["active"].(map[string]any)
+		shadow, _ := ev.Data["shadow"].(map[string]any)
+		for name, sv := range shadow {
+			a, aok := active[name].(float64)
+			b, bok := sv.(float64)
+			if !aok || !bok {
+				continue
+			}
+			m := metrics[name]
+			if m == nil {
+				m = &sums{}
+				metrics[name] = m
+			}
+			m.active += a
+			m.shadow += b
+			m.n++
+		}
+	}
+	for _, name := range sortedKeys(metrics) {
+		m := metrics[name]
+		sm := ShadowMetric{Name: name, Active: m.active / float64(m.n), Shadow: m.shadow / float64(m.n), Devices: m.n}
+		if sm.Active != 0 {
+			d := (sm.Shadow - sm.Active) / sm.Active * 100
+			sm.DeltaPct = &d
+		}
+		rep.Metrics = append(rep.Metrics, sm)
+	}
+	return rep
+}
+
+type ShadowController struct {
+	BaseController
+	p *Platform
+}
+
+func NewShadowController(p *Platform) *ShadowController {
+	return &ShadowController{p: p}
+}
+
+// Start godoc
+// @Summary      Start a shadow deployment
+// @Description  Runs a candidate release as a passive second process next to the active version on the devices of a channel, optionally limited to device ID globs. The candidate must have been published with shadow_args (e.g. a different port and no actuator output) and be newer than the channel's latest. Agents soak it for soak_minutes (default 24 hours) and upload comparison metrics.
+// @Tags         shadow
+// @Accept       json
+// @Produce      json
+// @Param        body  body  object  true  "{\"channel\": \"stable\", \"version\": \"2.1.0\", \"devices\": [\"test-*\"], \"soak_minutes\": 1440}"
+// @Success      200  {object}  controller.ShadowDeployment
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/shadows [post]
+func (c *ShadowController) Start(g *gin.Context) {
+	var body struct {
+		Channel     string   `json:"channel"`
+		Version     string   `json:"version"`
+		Devices     []string `json:"devices"`
+		SoakMinutes int      `json:"soak_minutes"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	body.Channel, body.Version = strings.TrimSpace(body.Channel), strings.TrimSpace(body.Version)
+	if body.Channel == "" || body.Version == "" {
+		c.ResponseFailure(g, ErrParam, "channel and version are required")
+		return
+	}
+	for _, pat := range body.Devices {
+		if _, err := path.Match(pat, ""); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid pattern "+pat)
+			return
+		}
+	}
+	if body.SoakMinutes < 0 {
+		c.ResponseFailure(g, ErrParam, "soak_minutes must not be negative")
+		return
+	}
+	if body.SoakMinutes == 0 {
+		body.SoakMinutes = int(defaultShadowSoak / time.Minute)
+	}
+	actor := c.p.principal(g).Name
+	now := c.p.clock.Now()
+
+	c.p.store.mu.Lock()
+	rel := c.p.store.ReleasesByVersion[body.Version]
+	var problem string
+	switch {
+	case rel == nil:
+		problem = "unknown version " + body.Version
+	case rel.Quarantine != nil:
+		problem = body.Version + " is quarantined"
+	case len(rel.ShadowArgs) == 0:
+		problem = body.Version + " was published without shadow_args and would drive the actuators"
+	case releaseFormat(rel) != "binary":
+		problem = "shadow deployments need a binary artifact, " + body.Version + " is " + releaseFormat(rel)
+	case rel.ArtifactType != "":
+		problem = "shadow deployments need a binary artifact, " + body.Version + " is an " + rel.ArtifactType + " image"
+	case rel.App != "":
+		problem = "shadow deployments cover the primary algorithm only, " + body.Version + " belongs to app " + rel.App
+	}
+	if latest := c.p.store.LatestByChannel[body.Channel]; problem == "" && latest != "" && !version.Newer(body.Version, latest) {
+		problem = body.Version + " is not newer than the latest " + body.Channel + " release " + latest
+	}
+	for _, s := range c.p.store.Shadows {
+		if problem == "" && s.State == ShadowRunning && s.Channel == body.Channel {
+			problem = "channel already has a running shadow deployment (" + s.ID + ")"
+		}
+	}
+	if problem != "" {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, problem)
+		return
+	}
+	s := &ShadowDeployment{
+		ID:          shadowID(body.Channel, body.Version, now),
+		Channel:     body.Channel,
+		Version:     body.Version,
+		Devices:     body.Devices,
+		SoakMinutes: body.SoakMinutes,
+		State:       ShadowRunning,
+		CreatedBy:   actor,
+		CreatedAt:   now,
+	}
+	if c.p.store.Shadows == nil {
+		c.p.store.Shadows = map[string]*ShadowDeployment{}
+	}
+	c.p.store.Shadows[s.ID] = s
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		delete(c.p.store.Shadows, s.ID)
+	}
+	out := s.clone()
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save shadow deployment"), fsErr(err, "save shadow deployment").Error())
+		return
+	}
+	_ = c.p.audit(actor, "shadow_started", "", map[string]any{"id": s.ID, "channel": s.Channel, "version": s.Version, "devices": s.Devices})
+	g.JSON(http.StatusOK, out)
+}
+
+// List godoc
+// @Summary      List shadow deployments
+// @Description  Shadow deployments, newest first.
+// @Tags         shadow
+// @Produce      json
+// @Param        state  query  string  false  "Only this state (running|promoted|aborted)"
+// @Success      200  {object}  map[string]any  "shadows"
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/shadows [get]
+func (c *ShadowController) List(g *gin.Context) {
+	state := g.Query("state")
+	c.p.store.mu.RLock()
+	out := make([]ShadowDeployment, 0, len(c.p.store.Shadows))
+	for _, s := range c.p.store.Shadows {
+		if state == "" || s.State == state {
+			out = append(out, s.clone())
+		}
+	}
+	c.p.store.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	g.JSON(http.StatusOK, gin.H{"shadows": out})
+}
+
+// Get godoc
+// @Summary      Compare a shadow deployment with the active version
+// @Description  The deployment with the latest report of every participating device aggregated: devices reporting and done soaking, shadow process crashes, and per metric the mean on the active and the shadow process with the relative change.
+// @Tags         shadow
+// @Produce      json
+// @Param        id  path  string  true  "Shadow deployment ID"
+// @Success      200  {object}  controller.ShadowReport
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/shadows/{id} [get]
+func (c *ShadowController) Get(g *gin.Context) {
+	c.p.store.mu.RLock()
+	s := c.p.store.Shadows[g.Param("id")]
+	var dep ShadowDeployment
+	if s != nil {
+		dep = s.clone()
+	}
+	c.p.store.mu.RUnlock()
+	if s == nil {
+		c.ResponseFailure(g, ErrNotFound, "shadow deployment not found")
+		return
+	}
+	evs, err := c.p.events.Query(EventQuery{Type: "shadow", Since: dep.CreatedAt})
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, compareShadow(dep, evs))
+}
+
+// Promote godoc
+// @Summary      Promote a shadow deployment
+// @Description  Approves the candidate: it becomes the latest release of the deployment's channel and devices update to it as usual (device-group policies still apply). Refused when the channel has moved past the candidate meanwhile.
+// @Tags         shadow
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true   "Shadow deployment ID"
+// @Param        body  body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  controller.ShadowDeployment
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/shadows/{id}/promote [post]
+func (c *ShadowController) Promote(g *gin.Context) {
+	c.finish(g, ShadowPromoted)
+}
+
+// Abort godoc
+// @Summary      Abort a shadow deployment
+// @Description  Stops the shadow deployment; agents stop the shadow process at their next check. The channel's latest release is unchanged.
+// @Tags         shadow
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true   "Shadow deployment ID"
+// @Param        body  body  object  false  "{\"reason\": \"...\"}"
+// @Success      200  {object}  controller.ShadowDeployment
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/shadows/{id}/abort [post]
+func (c *ShadowController) Abort(g *gin.Context) {
+	c.finish(g, ShadowAborted)
+}
+
+// finish ends a running shadow deployment; promotion also points the
+// channel at the candidate in the same save.
+func (c *ShadowController) finish(g *gin.Context, state string) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if g.Request.ContentLength != 0 {
+		if err := g.ShouldBindJSON(&body); err != nil {
+			c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+			return
+		}
+	}
+	actor := c.p.principal(g).Name
+	now := c.p.clock.Now()
+
+	c.p.store.mu.Lock()
+	s := c.p.store.Shadows[g.Param("id")]
+	if s == nil {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "shadow deployment not found")
+		return
+	}
+	if s.State != ShadowRunning {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrParam, "shadow deployment is already "+s.State)
+		return
+	}
+	next := c.p.store
+	if state == ShadowPromoted {
+		rel := c.p.store.ReleasesByVersion[s.Version]
+		latest := c.p.store.LatestByChannel[s.Channel]
+		if rel == nil || (latest != "" && !version.Newer(s.Version, latest)) {
+			c.p.store.mu.Unlock()
+			c.ResponseFailure(g, ErrParam, "cannot promote "+s.Version+": the "+s.Channel+" channel is at "+latest)
+			return
+		}
+		if rel.Quarantine != nil {
+			c.p.store.mu.Unlock()
+			c.ResponseFailure(g, ErrParam, "cannot promote "+s.Version+": it is quarantined")
+			return
+		}
+		// 与发布一样基于副本构造新状态，保存成功后再切换
+		next = c.p.store.cloneState()
+		promoted := *rel
+		promoted.Channel = s.Channel
+		next.ReleasesByVersion[s.Version] = &promoted
+		next.LatestByChannel[s.Channel] = s.Version
+	}
+	prev := *s
+	s.State, s.FinishedBy, s.FinishedAt, s.Reason = state, actor, &now, strings.TrimSpace(body.Reason)
+	err := c.p.saveStore(next)
+	if err != nil {
+		*s = prev
+	} else if next != c.p.store {
+		c.p.store.ReleasesByVersion = next.ReleasesByVersion
+		c.p.store.LatestByChannel = next.LatestByChannel
+		c.p.recordLatest(ChangePromote, actor)
+		c.p.refreshTUF(c.p.store)
+	}
+	out := s.clone()
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save shadow deployment"), fsErr(err, "save shadow deployment").Error())
+		return
+	}
+	_ = c.p.audit(actor, "shadow_"+state, out.Reason, map[string]any{"id": out.ID, "channel": out.Channel, "version": out.Version})
+	g.JSON(http.StatusOK, out)
+}
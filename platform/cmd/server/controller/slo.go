@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 安装耗时 SLO：agent 在安装报告中上报各阶段耗时（phases_ms：download、verify、install、restart、
+// health_confirm）。设备最近的成功安装中多数有阶段超出 -install-slo 时，机队健康视图把它列为慢设备，
+// 并给出多数安装都超时的阶段——持续的慢安装通常意味着 SD 卡老化，值得在故障之前更换。
+
+const (
+	// DefaultInstallSLO 是 -install-slo 的缺省值。
+	DefaultInstallSLO = "download=15m,verify=2m,install=2m,restart=1m,health_confirm=2m"
+
+	// installSLOWindow 内的成功安装参与判定，每台设备取最近 slowRecentInstalls 次，
+	// 至少 slowMinInstalls 次才判定，避免一次偶然的慢安装就把设备标记为慢设备。
+	installSLOWindow   = 30 * 24 * time.Hour
+	slowRecentInstalls = 5
+	slowMinInstalls    = 3
+)
+
+var installPhases = map[string]bool{"download": true, "verify": true, "install": true, "restart": true, "health_confirm": true}
+
+// ParseInstallSLO parses "download=15m,verify=2m".
+func ParseInstallSLO(s string) (map[string]time.Duration, error) {
+	out := map[string]time.Duration{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		phase, v, ok := strings.Cut(item, "=")
+		if !ok || !installPhases[phase] {
+			return nil, fmt.Errorf("install slo %q: want phase=duration with phase download, verify, install, restart or health_confirm", item)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("install slo %q: invalid duration", item)
+		}
+		out[phase] = d
+	}
+	return out, nil
+}
+
+// SlowDevice 是最近的安装多数超出 SLO 的设备。
+type SlowDevice struct {
+	DeviceID string `json:"device_id"`
+	Channel  string `json:"channel"`
+	Installs int    `json:"installs"` // 参与判定的最近成功安装次数
+	Slow     int    `json:"slow"`     // 其中有阶段超出 SLO 的次数
+	// Phases 是多数安装中超出 SLO 的阶段及其在这些安装中的最长耗时（毫秒）。
+	Phases      map[string]int64 `json:"phases_ms"`
+	LastInstall time.Time        `json:"last_install"`
+}
+
+// slowDevices evaluates the recent successful installs of every device
+// against the SLOs; only is an optional channel filter.
+func (p *Platform) slowDevices(now time.Time, only string) ([]SlowDevice, error) {
+	out := []SlowDevice{}
+	if len(p.installSLO) == 0 {
+		return out, nil
+	}
+	evs, err := p.events.Query(EventQuery{Type: "report", Since: now.Add(-installSLOWindow)})
+	if err != nil {
+		return nil, err
+	}
+	byDevice := map[string]*SlowDevice{}
+	over := map[string]map[string]int{}
+	// Query 按时间倒序返回，每台设备先看到的是最近的安装
+	for _, ev := range evs {
+		phases, ok := ev.Data["phases_ms"].(map[string]any)
+		if !ok || ev.DeviceID == "" || ev.Data["status"] != "success" || (only != "" && ev.Channel != only) {
+			continue
+		}
+		d := byDevice[ev.DeviceID]
+		if d == nil {
+			d = &SlowDevice{DeviceID: ev.DeviceID, Channel: ev.Channel, Phases: map[string]int64{}, LastInstall: ev.Time}
+			byDevice[ev.DeviceID] = d
+			over[ev.DeviceID] = map[string]int{}
+		}
+		if d.Installs == slowRecentInstalls {
+			continue
+		}
+		d.Installs++
+		slow := false
+		for phase, limit := range p.installSLO {
+			ms, _ := phases[phase].(float64)
+			if time.Duration(ms)*time.Millisecond <= limit {
+				continue
+			}
+			slow = true
+			over[ev.DeviceID][phase]++
+			d.Phases[phase] = max(d.Phases[phase], int64(ms))
+		}
+		if slow {
+			d.Slow++
+		}
+	}
+	for id, d := range byDevice {
+		if d.Installs < slowMinInstalls || d.Slow*2 <= d.Installs {
+			continue
+		}
+		for phase, n := range over[id] {
+			if n*2 <= d.Installs {
+				delete(d.Phases, phase)
+			}
+		}
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out, nil
+}
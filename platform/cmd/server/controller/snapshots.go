@@ -0,0 +1,283 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/von0000/dronealgo-ota/internal/version"
+)
+
+// 机队版本分布快照：每天（UTC）记录一次各渠道设备的版本分布，追加到
+// <DataDir>/version_snapshots.jsonl（内存后端下只保存在内存中）。快照不受设备事件保留期影响，
+// 可以画出长期的升级曲线，并回答“哪天 90% 的设备升到了 2.3.0”而无需重放原始事件。
+// 一台设备计入它最近一次带版本的事件所在的渠道与版本，只统计 snapshotActive 内出现过的设备。
+
+const snapshotActive = 7 * 24 * time.Hour
+
+// VersionSnapshot 是某一天一个渠道的版本分布。
+type VersionSnapshot struct {
+	Date     string         `json:"date"` // YYYY-MM-DD（UTC）
+	Time     time.Time      `json:"time"`
+	Channel  string         `json:"channel"`
+	Devices  int            `json:"devices"`
+	Versions map[string]int `json:"versions"` // 版本 -> 设备数
+}
+
+type snapshotLog struct {
+	mu    sync.Mutex
+	path  string
+	fsync bool
+	recs  []VersionSnapshot
+	last  string // 最近一次快照的日期；没有设备的日子不产生记录
+}
+
+func openSnapshotLog(path string, fsync bool) (*snapshotLog, error) {
+	l := &snapshotLog{path: path, fsync: fsync}
+	if path == "" {
+		return l, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		var s VersionSnapshot
+		if err := json.Unmarshal(sc.Bytes(), &s); err == nil {
+			l.recs = append(l.recs, s)
+		}
+	}
+	return l, sc.Err()
+}
+
+func (l *snapshotLog) lastDate() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last == "" && len(l.recs) > 0 {
+		return l.recs[len(l.recs)-1].Date
+	}
+	return l.last
+}
+
+func (l *snapshotLog) append(date string, snaps []VersionSnapshot) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recs = append(l.recs, snaps...)
+	l.last = date
+	if l.path == "" || len(snaps) == 0 {
+		return nil
+	}
+	var buf []byte
+	for _, s := range snaps {
+		b, _ := json.Marshal(s)
+		buf = append(append(buf, b...), '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if l.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// list returns the snapshots of channel (all channels when empty) whose date
+// lies in [since, until], oldest first; empty bounds are open.
+func (l *snapshotLog) list(channel, since, until string) []VersionSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := []VersionSnapshot{}
+	for _, s := range l.recs {
+		if (channel == "" || s.Channel == channel) && (since == "" || s.Date >= since) && (until == "" || s.Date <= until) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// snapshotVersions records today's distribution unless it already exists.
+func (p *Platform) snapshotVersions() {
+	now := p.clock.Now().UTC()
+	today := now.Format(time.DateOnly)
+	if p.snapshots.lastDate() >= today {
+		return
+	}
+	snaps, err := p.versionDistribution(now)
+	if err != nil {
+		log.Printf("version snapshot: %v", err)
+		return
+	}
+	for i := range snaps {
+		snaps[i].Date = today
+	}
+	if err := p.snapshots.append(today, snaps); err != nil {
+		log.Printf("version snapshot: %v", err)
+		return
+	}
+	log.Printf("version snapshot %s: %d channels", today, len(snaps))
+}
+
+// versionDistribution counts active devices per channel and version.
+func (p *Platform) versionDistribution(now time.Time) ([]VersionSnapshot, error) {
+	seen := map[string]bool{}
+	byChannel := map[string]*VersionSnapshot{}
+	// 逐条扫描而不是一次取出一周的事件；按时间倒序，设备第一次出现即是它最近的状态
+	err := p.events.Scan(EventQuery{Since: now.Add(-snapshotActive)}, func(ev *DeviceEvent) bool {
+		if ev.DeviceID == "" || ev.Version == "" || ev.Channel == "" || seen[ev.DeviceID] {
+			return true
+		}
+		seen[ev.DeviceID] = true
+		s := byChannel[ev.Channel]
+		if s == nil {
+			s = &VersionSnapshot{Time: now, Channel: ev.Channel, Versions: map[string]int{}}
+			byChannel[ev.Channel] = s
+		}
+		s.Devices++
+		s.Versions[ev.Version]++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]VersionSnapshot, 0, len(byChannel))
+	for _, ch := range sortedKeys(byChannel) {
+		out = append(out, *byChannel[ch])
+	}
+	return out, nil
+}
+
+// AdoptionPoint 是某一天已达到目标版本（含更新版本）的设备占比。
+type AdoptionPoint struct {
+	Date    string  `json:"date"`
+	Devices int     `json:"devices"`
+	Reached int     `json:"reached"`
+	Share   float64 `json:"share"`
+}
+
+type FleetController struct {
+	BaseController
+	p *Platform
+}
+
+func NewFleetController(p *Platform) *FleetController {
+	return &FleetController{p: p}
+}
+
+// VersionHistory godoc
+// @Summary      Daily version distribution snapshots
+// @Description  One snapshot per day and channel of how many active devices (seen in the last 7 days) run each version, oldest first.
+// @Tags         device
+// @Produce      json
+// @Param        channel  query  string  false  "Only this channel"
+// @Param        since    query  string  false  "First date (YYYY-MM-DD)"
+// @Param        until    query  string  false  "Last date (YYYY-MM-DD)"
+// @Success      200  {object}  map[string]any  "snapshots"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/fleet/versions [get]
+func (c *FleetController) VersionHistory(g *gin.Context) {
+	since, until, ok := c.dateRange(g)
+	if !ok {
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"snapshots": c.p.snapshots.list(g.Query("channel"), since, until)})
+}
+
+// Adoption godoc
+// @Summary      Adoption of a version over time
+// @Description  Per daily snapshot, the share of a channel's devices running the version or newer, and the first date the share reached the threshold (null if never).
+// @Tags         device
+// @Produce      json
+// @Param        channel  query  string  false  "Channel, default: stable"
+// @Param        version  query  string  true   "Target version"
+// @Param        share    query  number  false  "Threshold share between 0 and 1, default: 0.9"
+// @Param        since    query  string  false  "First date (YYYY-MM-DD)"
+// @Param        until    query  string  false  "Last date (YYYY-MM-DD)"
+// @Success      200  {object}  map[string]any  "channel, version, share, reached_on, series"
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/fleet/adoption [get]
+func (c *FleetController) Adoption(g *gin.Context) {
+	target := g.Query("version")
+	if target == "" {
+		c.ResponseFailure(g, ErrParam, "version is required")
+		return
+	}
+	threshold := 0.9
+	if v := g.Query("share"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > 1 {
+			c.ResponseFailure(g, ErrParam, "share must be a number in (0, 1]")
+			return
+		}
+		threshold = f
+	}
+	since, until, ok := c.dateRange(g)
+	if !ok {
+		return
+	}
+	channel := g.DefaultQuery("channel", "stable")
+
+	series := []AdoptionPoint{}
+	var reachedOn *string
+	for _, s := range c.p.snapshots.list(channel, since, until) {
+		pt := AdoptionPoint{Date: s.Date, Devices: s.Devices}
+		for v, n := range s.Versions {
+			if v == target || version.Newer(v, target) {
+				pt.Reached += n
+			}
+		}
+		if pt.Devices > 0 {
+			pt.Share = float64(pt.Reached) / float64(pt.Devices)
+		}
+		if reachedOn == nil && pt.Devices > 0 && pt.Share >= threshold {
+			date := s.Date
+			reachedOn = &date
+		}
+		series = append(series, pt)
+	}
+	g.JSON(http.StatusOK, gin.H{
+		"channel":    channel,
+		"version":    target,
+		"share":      threshold,
+		"reached_on": reachedOn,
+		"series":     series,
+	})
+}
+
+func (c BaseController) dateRange(g *gin.Context) (since, until string, ok bool) {
+	since, until = g.Query("since"), g.Query("until")
+	for _, d := range []string{since, until} {
+		if _, err := time.Parse(time.DateOnly, d); d != "" && err != nil {
+			c.ResponseFailure(g, ErrParam, "dates must be YYYY-MM-DD")
+			return "", "", false
+		}
+	}
+	return since, until, true
+}
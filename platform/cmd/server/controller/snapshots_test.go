@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSegmentLogScan(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	// 两个段：第一段是一周前的事件，第二段是最近的
+	for n, hours := range [][]int{{0, 1}, {200, 201, 202}} {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("segment-%06d.jsonl", n+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range hours {
+			at := start.Add(time.Duration(h) * time.Hour)
+			b, _ := json.Marshal(DeviceEvent{Seq: uint64(h + 1), Received: at, Time: at, DeviceID: "d1", Type: "heartbeat"})
+			f.Write(append(b, '\n'))
+		}
+		f.Close()
+	}
+	l, err := OpenSegmentLog(dir, 30*24*time.Hour, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seqs []uint64
+	collect := func(ev *DeviceEvent) bool {
+		seqs = append(seqs, ev.Seq)
+		return true
+	}
+	if err := l.Scan(EventQuery{Since: start.Add(100 * time.Hour)}, collect); err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 3 || seqs[0] != 203 || seqs[2] != 201 {
+		t.Fatalf("scan since 100h = %v, want 203 202 201", seqs)
+	}
+
+	seqs = nil
+	if err := l.Scan(EventQuery{Limit: 4}, collect); err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 4 || seqs[3] != 2 {
+		t.Fatalf("scan with limit 4 = %v", seqs)
+	}
+
+	seqs = nil
+	if err := l.Scan(EventQuery{}, func(ev *DeviceEvent) bool {
+		seqs = append(seqs, ev.Seq)
+		return len(seqs) < 2
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 2 {
+		t.Fatalf("scan stopped after %d events, want 2", len(seqs))
+	}
+}
+
+func TestVersionDistribution(t *testing.T) {
+	now := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	p := newMemoryPlatform(t, nil)
+	for _, ev := range []DeviceEvent{
+		// d1 一周前在 beta，之后才换到 stable：只计入最近的状态
+		{DeviceID: "d1", Channel: "beta", Version: "0.9.0", Received: now.Add(-6 * 24 * time.Hour)},
+		{DeviceID: "d1", Channel: "stable", Version: "1.0.0", Received: now.Add(-time.Hour)},
+		{DeviceID: "d2", Channel: "stable", Version: "1.1.0", Received: now.Add(-2 * time.Hour)},
+		// 超出活跃期的设备不计入
+		{DeviceID: "d3", Channel: "stable", Version: "0.8.0", Received: now.Add(-8 * 24 * time.Hour)},
+	} {
+		ev.Type = "check"
+		if err := p.events.Append(&ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snaps, err := p.versionDistribution(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 || snaps[0].Channel != "stable" || snaps[0].Devices != 2 ||
+		snaps[0].Versions["1.0.0"] != 1 || snaps[0].Versions["1.1.0"] != 1 {
+		t.Fatalf("distribution = %+v", snaps)
+	}
+}
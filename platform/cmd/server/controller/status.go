@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 公开状态页只暴露粗粒度信息：公开渠道的最新版本与发布时间、服务健康状况。
+// 设备、事件与非公开渠道一律不出现，也不受令牌鉴权，因此按来源 IP 限流。
+
+const statusMaxAge = 30 * time.Second
+
+// ChannelStatus 是公开渠道的最新版本。
+type ChannelStatus struct {
+	Version     string    `json:"version"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// PublicStatus 是状态页的响应体。
+type PublicStatus struct {
+	Status      string                   `json:"status"` // ok | degraded
+	Channels    map[string]ChannelStatus `json:"channels"`
+	LastPublish *time.Time               `json:"last_publish,omitempty"`
+}
+
+// rateLimiter 是按客户端 IP 的令牌桶：每分钟补充 perMinute 个，桶容量同为 perMinute。
+type rateLimiter struct {
+	mu        sync.Mutex
+	perMinute float64
+	buckets   map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{perMinute: float64(perMinute), buckets: map[string]*bucket{}}
+}
+
+// allow takes one token for key, returning how long to wait when empty.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: l.perMinute, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * l.perMinute
+	if b.tokens > l.perMinute {
+		b.tokens = l.perMinute
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.perMinute * float64(time.Minute))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops buckets that have refilled completely.
+func (l *rateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > time.Minute {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func (p *Platform) isPublicChannel(ch string) bool {
+	for _, pat := range p.publicChannels {
+		if ok, _ := path.Match(pat, ch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Platform) publicStatus() PublicStatus {
+	st := PublicStatus{Status: "ok", Channels: map[string]ChannelStatus{}}
+	if p.stillReadOnly() {
+		st.Status = "degraded"
+	}
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+	for ch, v := range p.store.LatestByChannel {
+		rel := p.store.ReleasesByVersion[v]
+		if rel == nil || !p.isPublicChannel(ch) {
+			continue
+		}
+		st.Channels[ch] = ChannelStatus{Version: rel.Version, PublishedAt: rel.CreatedAt}
+		if st.LastPublish == nil || rel.CreatedAt.After(*st.LastPublish) {
+			t := rel.CreatedAt
+			st.LastPublish = &t
+		}
+	}
+	return st
+}
+
+type StatusController struct {
+	BaseController
+	p *Platform
+}
+
+func NewStatusController(p *Platform) *StatusController {
+	return &StatusController{p: p}
+}
+
+// Status godoc
+// @Summary      Public status
+// @Description  Unauthenticated, cacheable platform status for a customer-facing status page: latest version per public channel (-status-channels), last publish time and service health. Rate-limited per client IP (-status-rate); supports If-None-Match.
+// @Tags         system
+// @Produce      json
+// @Success      200  {object}  controller.PublicStatus
+// @Success      304
+// @Failure      429  {object}  map[string]any
+// @Router       /status [get]
+func (c *StatusController) Status(g *gin.Context) {
+	if ok, wait := c.p.statusLimit.allow(g.ClientIP(), c.p.clock.Now()); !ok {
+		g.Header("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		c.ResponseFailure(g, ErrRateLimited, "too many status requests, retry later")
+		return
+	}
+	st := c.p.publicStatus()
+	body, _ := json.Marshal(st)
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	g.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(statusMaxAge/time.Second)))
+	g.Header("ETag", etag)
+	g.Header("Access-Control-Allow-Origin", "*")
+	if g.GetHeader("If-None-Match") == etag {
+		g.Status(http.StatusNotModified)
+		return
+	}
+	g.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Storage 持久化版本索引（releases + latest_by_channel）。
+// 调用方持有 Store.mu，实现本身无需加锁。
+type Storage interface {
+	// Load returns the persisted index, or an error satisfying
+	// os.IsNotExist when nothing has been stored yet.
+	Load() (*Store, error)
+	// Save persists the whole index.
+	Save(s *Store) error
+	// ChangedExternally reports whether the index was modified by someone
+	// other than this process since the last Load/Save.
+	ChangedExternally() bool
+	// Describe names the backend for logs.
+	Describe() string
+}
+
+func decodeStore(b []byte) (*Store, error) {
+	tmp := &Store{}
+	tmp.ReleasesByVersion = map[string]*Release{}
+	tmp.LatestByChannel = map[string]string{}
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(tmp); err != nil {
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// syncDir fsyncs a directory so a rename inside it survives power loss.
+func syncDir(dir string, fsync bool) error {
+	if !fsync {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// fileStorage 是默认后端：releases.json 先写 tmp 再 rename。
+type fileStorage struct {
+	path    string
+	fsync   bool
+	lastMod time.Time // 最近一次加载/写入时的 mtime
+}
+
+// NewFileStorage keeps the index in the JSON file at path.
+func NewFileStorage(path string, fsync bool) Storage {
+	return &fileStorage{path: path, fsync: fsync}
+}
+
+func (fs *fileStorage) Describe() string { return fs.path }
+
+func (fs *fileStorage) Load() (*Store, error) {
+	b, err := os.ReadFile(fs.path)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := decodeStore(b)
+	if err != nil {
+		return nil, err
+	}
+	fs.recordMod()
+	return tmp, nil
+}
+
+func (fs *fileStorage) Save(s *Store) error {
+	if err := os.MkdirAll(filepath.Dir(fs.path), 0755); err != nil {
+		return err
+	}
+	tmp := fs.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	// 写入或落盘失败（如 ENOSPC）时删除半截的 tmp 文件，releases.json 保持原样
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if fs.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			_ = os.Remove(tmp)
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, fs.path); err != nil {
+		return err
+	}
+	fs.recordMod()
+	return syncDir(filepath.Dir(fs.path), fs.fsync)
+}
+
+func (fs *fileStorage) ChangedExternally() bool {
+	fi, err := os.Stat(fs.path)
+	if err != nil {
+		return false
+	}
+	return !fi.ModTime().Equal(fs.lastMod)
+}
+
+func (fs *fileStorage) recordMod() {
+	if fi, err := os.Stat(fs.path); err == nil {
+		fs.lastMod = fi.ModTime()
+	}
+}
+
+// memoryStorage 是参考实现：保存序列化后的副本，读写之间不共享任何指针。
+type memoryStorage struct {
+	data []byte
+}
+
+// NewMemoryStorage keeps the index in memory, optionally seeded with a
+// releases.json-shaped document.
+func NewMemoryStorage(seed []byte) (Storage, error) {
+	m := &memoryStorage{}
+	if len(seed) > 0 {
+		if _, err := decodeStore(seed); err != nil {
+			return nil, errors.New("seed: " + err.Error())
+		}
+		m.data = seed
+	}
+	return m, nil
+}
+
+func (m *memoryStorage) Describe() string { return "memory" }
+
+func (m *memoryStorage) Load() (*Store, error) {
+	if m.data == nil {
+		return nil, os.ErrNotExist
+	}
+	return decodeStore(m.data)
+}
+
+func (m *memoryStorage) Save(s *Store) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	m.data = b
+	return nil
+}
+
+func (m *memoryStorage) ChangedExternally() bool { return false }
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStore() *Store {
+	return &Store{
+		ReleasesByVersion: map[string]*Release{"1.0.0": {Version: "1.0.0", Channel: "stable"}},
+		LatestByChannel:   map[string]string{"stable": "1.0.0"},
+	}
+}
+
+func testStorageRoundTrip(t *testing.T, st Storage) {
+	t.Helper()
+	if _, err := st.Load(); !os.IsNotExist(err) {
+		t.Fatalf("Load before Save: %v, want not exist", err)
+	}
+	s := testStore()
+	if err := st.Save(s); err != nil {
+		t.Fatal(err)
+	}
+	// 保存后的修改不影响已持久化的内容
+	s.ReleasesByVersion["1.0.0"].Channel = "beta"
+	s.LatestByChannel["beta"] = "1.0.0"
+
+	got, err := st.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel := got.ReleasesByVersion["1.0.0"]; rel == nil || rel.Channel != "stable" {
+		t.Fatalf("loaded release %+v, want channel stable", rel)
+	}
+	if len(got.LatestByChannel) != 1 || got.LatestByChannel["stable"] != "1.0.0" {
+		t.Fatalf("loaded latest %v", got.LatestByChannel)
+	}
+	if st.ChangedExternally() {
+		t.Fatal("own save reported as an external change")
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	st, err := NewMemoryStorage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorageRoundTrip(t, st)
+}
+
+func TestMemoryStorageSeed(t *testing.T) {
+	st, err := NewMemoryStorage([]byte(`{"releases_by_version": {"2.0.0": {"version": "2.0.0"}}, "latest_by_channel": {"stable": "2.0.0"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := st.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ReleasesByVersion["2.0.0"] == nil || s.LatestByChannel["stable"] != "2.0.0" {
+		t.Fatalf("seed not loaded: %+v", s)
+	}
+	if _, err := NewMemoryStorage([]byte(`{"releases_by_version": [`)); err == nil {
+		t.Fatal("malformed seed accepted")
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "releases.json")
+	st := NewFileStorage(path, false)
+	testStorageRoundTrip(t, st)
+
+	// 其它进程改写 releases.json 后能被发现
+	if err := os.WriteFile(path, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	edited := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, edited, edited); err != nil {
+		t.Fatal(err)
+	}
+	if !st.ChangedExternally() {
+		t.Fatal("external edit not detected")
+	}
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 支持包：把一台设备在时间窗口内（缺省 30 天）的资料打成一个 tar.gz，附到供应商的支持工单上：
+//   - timeline.json：设备事件时间线（最多 supportMaxEvents 条）；
+//   - reports.json：其中的安装上报；
+//   - crashes.json：崩溃事件按（版本, 退出原因）分组，带次数与首末时间；
+//   - releases.json：设备涉及过的版本（当前版本、安装的来源与目标、回滚目标）的发布记录；
+//   - audit.json：与该设备相关的审计记录（data.device_id 为该设备，或请求路径指向该设备）；
+//   - manifest.json：设备、窗口、生成时间与生成人。
+// 事件在入库时已按遥测隐私策略处理，包内不再另做脱敏。每次导出都记入审计日志。
+
+const (
+	supportDefaultDays = 30
+	supportMaxDays     = 365
+	supportMaxEvents   = 5000
+)
+
+// crashGroup 是同一版本、同一退出原因的崩溃。
+type crashGroup struct {
+	App     string    `json:"app,omitempty"` // 多应用设备上其它应用的崩溃（见 apps.go）
+	Version string    `json:"version"`
+	Exit    string    `json:"exit"`
+	Count   int       `json:"count"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+}
+
+// SupportBundle godoc
+// @Summary      Device support bundle
+// @Description  A tar.gz for a vendor support ticket: the device's event timeline, install reports, crash groups (by version and exit reason), the releases it ran or moved between, and the audit records concerning it, over the last days (default 30). The export itself is audited.
+// @Tags         device
+// @Produce      application/gzip
+// @Param        id    path   string  true   "Device ID"
+// @Param        days  query  int     false  "Days of history, default 30, at most 365"
+// @Success      200  {file}  binary
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/devices/{id}/support-bundle [get]
+func (c *EventController) SupportBundle(g *gin.Context) {
+	device := g.Param("id")
+	days := supportDefaultDays
+	if v := g.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > supportMaxDays {
+			c.ResponseFailure(g, ErrParam, fmt.Sprintf("invalid days (want 1-%d)", supportMaxDays))
+			return
+		}
+		days = n
+	}
+	now := c.p.clock.Now()
+	since := now.AddDate(0, 0, -days)
+	events, err := c.p.events.Query(EventQuery{DeviceID: device, Since: since, Limit: supportMaxEvents})
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	if len(events) == 0 {
+		c.ResponseFailure(g, ErrNotFound, fmt.Sprintf("no events from %s in the last %d days", device, days))
+		return
+	}
+	reports := []*DeviceEvent{}
+	for _, ev := range events {
+		if ev.Type == "report" {
+			reports = append(reports, ev)
+		}
+	}
+	recs, err := c.p.auditLog.list(since, math.MaxInt)
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, "read audit log: "+err.Error())
+		return
+	}
+	audit := deviceAudit(recs, device)
+	actor := c.p.principal(g).Name
+	manifest := gin.H{
+		"device_id":    device,
+		"since":        since,
+		"generated_at": now,
+		"generated_by": actor,
+		"events":       len(events),
+		"truncated":    len(events) == supportMaxEvents,
+	}
+	files := []struct {
+		name string
+		v    any
+	}{
+		{"manifest.json", manifest},
+		{"timeline.json", events},
+		{"reports.json", reports},
+		{"crashes.json", crashGroups(events)},
+		{"releases.json", c.p.touchedReleases(events)},
+		{"audit.json", audit},
+	}
+	_ = c.p.audit(actor, "support_bundle", "", map[string]any{"device_id": device, "days": days})
+
+	dir := "support-" + device + "-" + now.UTC().Format("20060102")
+	g.Header("Content-Disposition", "attachment; filename="+strconv.Quote(dir+".tar.gz"))
+	g.Header("Content-Type", "application/gzip")
+	g.Status(http.StatusOK)
+	zw := gzip.NewWriter(g.Writer)
+	tw := tar.NewWriter(zw)
+	for _, f := range files {
+		b, _ := json.MarshalIndent(f.v, "", "  ")
+		h := &tar.Header{Name: dir + "/" + f.name, Mode: 0o644, Size: int64(len(b)), ModTime: now}
+		if err = tw.WriteHeader(h); err == nil {
+			_, err = tw.Write(b)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Printf("support bundle %s: %v", device, err)
+		g.Abort()
+	}
+}
+
+// crashGroups groups crash events by version and exit reason, most frequent
+// first.
+func crashGroups(events []*DeviceEvent) []*crashGroup {
+	byKey := map[string]*crashGroup{}
+	out := []*crashGroup{}
+	for _, ev := range events {
+		if ev.Type != "crash" {
+			continue
+		}
+		exit := fmt.Sprint(ev.Data["exit"])
+		app, _ := ev.Data["app"].(string)
+		ver := ev.Version
+		if app != "" {
+			ver, _ = ev.Data["version"].(string)
+		}
+		key := app + "\x00" + ver + "\x00" + exit
+		cg := byKey[key]
+		if cg == nil {
+			cg = &crashGroup{App: app, Version: ver, Exit: exit, First: ev.Time, Last: ev.Time}
+			byKey[key] = cg
+			out = append(out, cg)
+		}
+		cg.Count++
+		if ev.Time.Before(cg.First) {
+			cg.First = ev.Time
+		}
+		if ev.Time.After(cg.Last) {
+			cg.Last = ev.Time
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Count > out[j].Count })
+	return out
+}
+
+// touchedReleases returns the releases the events mention: the version the
+// device ran, the from/to/rolled-back-to versions of install reports and
+// the versions other apps reported.
+func (p *Platform) touchedReleases(events []*DeviceEvent) []*Release {
+	seen := map[string]bool{}
+	for _, ev := range events {
+		seen[ev.Version] = true
+		for _, k := range []string{"from", "to", "rolled_back_to", "version", "current"} {
+			if v, ok := ev.Data[k].(string); ok {
+				seen[v] = true
+			}
+		}
+	}
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+	out := []*Release{}
+	for v := range seen {
+		if rel := p.store.ReleasesByVersion[v]; rel != nil {
+			out = append(out, rel)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// deviceAudit keeps the records about device: data.device_id names it, or a
+// break-glass request addressed it.
+func deviceAudit(recs []AuditRecord, device string) []AuditRecord {
+	prefix := "/api/v1/devices/" + url.PathEscape(device)
+	out := []AuditRecord{}
+	for _, r := range recs {
+		id, _ := r.Data["device_id"].(string)
+		uri, _ := r.Data["path"].(string)
+		path, _, _ := strings.Cut(uri, "?")
+		if id == device || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			out = append(out, r)
+		}
+	}
+	return out
+}
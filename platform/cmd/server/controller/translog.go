@@ -0,0 +1,245 @@
+package controller
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/tlog"
+)
+
+// LogEntry 是透明日志的叶子：每次发布追加一条（同一版本重新发布也会追加），永不修改。
+// 叶子哈希覆盖的是该条目序列化后的原始字节。
+type LogEntry struct {
+	Index   uint64    `json:"index"`
+	Version string    `json:"version"`
+	Channel string    `json:"channel"`
+	Sha256  string    `json:"sha256"`
+	Format  string    `json:"format"`
+	Time    time.Time `json:"time"`
+}
+
+// releaseLog 是只追加、哈希链接的发布记录（RFC 6962 Merkle 树 + 签名树头）。
+// 审计方可以拉取全部条目复算树根，agent 安装前校验包含证明并与上次见过的树头做一致性校验，
+// 服务端若对不同设备展示不同的历史就会被发现。
+type releaseLog struct {
+	mu     sync.RWMutex
+	key    ed25519.PrivateKey
+	path   string // 为空时仅保存在内存中
+	fsync  bool
+	leaves [][]byte
+	hashes []tlog.Hash
+	latest map[string]uint64 // 版本 -> 最近一条叶子的下标
+	head   tlog.TreeHead
+}
+
+func openReleaseLog(key ed25519.PrivateKey, path string, fsync bool, now time.Time) (*releaseLog, error) {
+	l := &releaseLog{key: key, path: path, fsync: fsync, latest: map[string]uint64{}}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			defer f.Close()
+			sc := bufio.NewScanner(f)
+			sc.Buffer(make([]byte, 64<<10), 1<<20)
+			for sc.Scan() {
+				var e LogEntry
+				if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.Index != uint64(len(l.leaves)) {
+					// 只追加文件中间损坏无法安全修复，交由运维处理
+					return nil, fmt.Errorf("transparency log %s: corrupt entry %d", path, len(l.leaves))
+				}
+				l.add(append([]byte(nil), sc.Bytes()...), &e)
+			}
+			if err := sc.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	l.sign(now)
+	return l, nil
+}
+
+func (l *releaseLog) add(leaf []byte, e *LogEntry) {
+	l.leaves = append(l.leaves, leaf)
+	l.hashes = append(l.hashes, tlog.LeafHash(leaf))
+	l.latest[e.Version] = e.Index
+}
+
+func (l *releaseLog) sign(now time.Time) {
+	l.head = tlog.SignTreeHead(l.key, uint64(len(l.hashes)), tlog.RootHash(l.hashes), now.UnixMilli())
+}
+
+// append records rel as the next leaf and signs a new tree head.
+func (l *releaseLog) append(rel *Release, now time.Time) (*LogEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e := &LogEntry{
+		Index:   uint64(len(l.leaves)),
+		Version: rel.Version,
+		Channel: rel.Channel,
+		Sha256:  rel.Sha256,
+		Format:  releaseFormat(rel),
+		Time:    rel.CreatedAt.UTC(),
+	}
+	leaf, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if l.path != "" {
+		if err := l.persist(leaf); err != nil {
+			return nil, err
+		}
+	}
+	l.add(leaf, e)
+	l.sign(now)
+	return e, nil
+}
+
+func (l *releaseLog) persist(leaf []byte) error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(leaf, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if l.fsync {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func (l *releaseLog) treeHead() tlog.TreeHead {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.head
+}
+
+// has reports whether the newest leaf for rel.Version records rel's digest.
+func (l *releaseLog) has(rel *Release) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i, ok := l.latest[rel.Version]
+	if !ok {
+		return false
+	}
+	var e LogEntry
+	_ = json.Unmarshal(l.leaves[i], &e)
+	return e.Sha256 == rel.Sha256
+}
+
+// logProof 是一个版本的包含证明，附带证明所对应的签名树头。
+type logProof struct {
+	LeafIndex uint64        `json:"leaf_index"`
+	Leaf      []byte        `json:"leaf"` // 叶子原始字节（base64），叶子哈希 = SHA-256(0x00 || leaf)
+	Entry     *LogEntry     `json:"entry"`
+	AuditPath []string      `json:"audit_path"`
+	TreeHead  tlog.TreeHead `json:"tree_head"`
+}
+
+// inclusion proves the newest leaf of version against the current tree head.
+func (l *releaseLog) inclusion(version string) (*logProof, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i, ok := l.latest[version]
+	if !ok {
+		return nil, errArtifactNotFound
+	}
+	path, err := tlog.InclusionProof(l.hashes[:l.head.TreeSize], int(i))
+	if err != nil {
+		return nil, err
+	}
+	var e LogEntry
+	_ = json.Unmarshal(l.leaves[i], &e)
+	return &logProof{LeafIndex: i, Leaf: l.leaves[i], Entry: &e, AuditPath: tlog.FormatHashes(path), TreeHead: l.head}, nil
+}
+
+// consistency proves that the tree of size first is a prefix of the tree of
+// size second.
+func (l *releaseLog) consistency(first, second uint64) ([]string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if first > second || second > uint64(len(l.hashes)) {
+		return nil, errors.New("tree sizes out of range")
+	}
+	proof, err := tlog.ConsistencyProof(l.hashes[:second], int(first))
+	if err != nil {
+		return nil, err
+	}
+	return tlog.FormatHashes(proof), nil
+}
+
+// logLeaf 是条目列表中的一项：原始字节与解码后的内容。
+type logLeaf struct {
+	Leaf  []byte    `json:"leaf,omitempty"`
+	Entry *LogEntry `json:"entry,omitempty"`
+	// LeafHash 代替令牌范围之外的条目：审计方仍能复算树根，但看不到版本与渠道
+	LeafHash string `json:"leaf_hash,omitempty"`
+}
+
+// entries returns leaves [start, end), capped at the tree size.
+// Entries of channels allow rejects only carry their leaf hash.
+func (l *releaseLog) entries(start, end uint64, allow func(channel string) bool) []logLeaf {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if end > uint64(len(l.leaves)) {
+		end = uint64(len(l.leaves))
+	}
+	out := []logLeaf{}
+	for i := start; i < end; i++ {
+		var e LogEntry
+		_ = json.Unmarshal(l.leaves[i], &e)
+		if !allow(e.Channel) {
+			out = append(out, logLeaf{LeafHash: tlog.FormatHashes(l.hashes[i : i+1])[0]})
+			continue
+		}
+		out = append(out, logLeaf{Leaf: l.leaves[i], Entry: &e})
+	}
+	return out
+}
+
+// backfillLog appends releases published before the log was enabled (or
+// missing from it), oldest first. Callers hold s.mu.
+func (p *Platform) backfillLog(s *Store) error {
+	if p.tlog == nil {
+		return nil
+	}
+	var missing []*Release
+	for _, rel := range s.ReleasesByVersion {
+		if !p.tlog.has(rel) {
+			missing = append(missing, rel)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if !missing[i].CreatedAt.Equal(missing[j].CreatedAt) {
+			return missing[i].CreatedAt.Before(missing[j].CreatedAt)
+		}
+		return missing[i].Version < missing[j].Version
+	})
+	for _, rel := range missing {
+		if _, err := p.tlog.append(rel, p.clock.Now()); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("transparency log: recorded %d existing releases", len(missing))
+	}
+	return nil
+}
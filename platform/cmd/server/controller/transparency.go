@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TransparencyController struct {
+	BaseController
+	p *Platform
+}
+
+func NewTransparencyController(p *Platform) *TransparencyController {
+	return &TransparencyController{p: p}
+}
+
+func (c *TransparencyController) enabled(g *gin.Context) bool {
+	if c.p.tlog == nil {
+		c.ResponseFailure(g, ErrNotFound, "transparency log is not enabled (start the server with -log-key)")
+		return false
+	}
+	return true
+}
+
+// TreeHead godoc
+// @Summary      Signed tree head
+// @Description  Current signed tree head of the release transparency log. The ed25519 signature covers "dronealgo-ota transparency log\n<tree_size>\n<root_hash>\n<timestamp>\n".
+// @Tags         transparency
+// @Produce      json
+// @Success      200  {object}  map[string]any  "tree_size, root_hash, timestamp, key_id, signature"
+// @Failure      404  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/log/sth [get]
+func (c *TransparencyController) TreeHead(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	g.JSON(http.StatusOK, c.p.tlog.treeHead())
+}
+
+// Entries godoc
+// @Summary      Log entries
+// @Description  Leaves [start, end) of the transparency log, for auditors recomputing the tree. Each item has the raw leaf (base64) and its decoded entry; leaves of channels outside the caller's token scope only carry leaf_hash.
+// @Tags         transparency
+// @Produce      json
+// @Param        start  query  int  false  "First leaf index, default 0"
+// @Param        end    query  int  false  "One past the last leaf index, default start+1000"
+// @Success      200  {object}  map[string]any  "entries"
+// @Failure      400  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/log/entries [get]
+func (c *TransparencyController) Entries(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	start, err := strconv.ParseUint(g.DefaultQuery("start", "0"), 10, 64)
+	if err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid start")
+		return
+	}
+	end := start + 1000
+	if v := g.Query("end"); v != "" {
+		if end, err = strconv.ParseUint(v, 10, 64); err != nil || end < start {
+			c.ResponseFailure(g, ErrParam, "invalid end")
+			return
+		}
+		if end-start > 1000 {
+			end = start + 1000
+		}
+	}
+	g.JSON(http.StatusOK, gin.H{"entries": c.p.tlog.entries(start, end, c.p.principal(g).CanAccess)})
+}
+
+// Proof godoc
+// @Summary      Inclusion proof for a release
+// @Description  Audit path proving the newest log entry of a version is included in the returned signed tree head.
+// @Tags         transparency
+// @Produce      json
+// @Param        version  query  string  true  "Release version"
+// @Success      200  {object}  map[string]any  "leaf_index, leaf, entry, audit_path, tree_head"
+// @Failure      400  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      403  {object}  map[string]any  "channel outside token scope"
+// @Security     BearerAuth
+// @Router       /api/v1/log/proof [get]
+func (c *TransparencyController) Proof(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	v := g.Query("version")
+	if v == "" {
+		c.ResponseFailure(g, ErrParam, "version is required")
+		return
+	}
+	proof, err := c.p.tlog.inclusion(v)
+	if errors.Is(err, errArtifactNotFound) {
+		c.ResponseFailure(g, ErrNotFound, "version "+v+" is not in the log")
+		return
+	}
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	if !c.p.allowChannel(g, proof.Entry.Channel) {
+		return
+	}
+	g.JSON(http.StatusOK, proof)
+}
+
+// Consistency godoc
+// @Summary      Consistency proof
+// @Description  Proof that the log at tree size first is a prefix of the log at tree size second.
+// @Tags         transparency
+// @Produce      json
+// @Param        first   query  int  true  "Older tree size"
+// @Param        second  query  int  true  "Newer tree size"
+// @Success      200  {object}  map[string]any  "first, second, proof"
+// @Failure      400  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/log/consistency [get]
+func (c *TransparencyController) Consistency(g *gin.Context) {
+	if !c.enabled(g) {
+		return
+	}
+	first, err1 := strconv.ParseUint(g.Query("first"), 10, 64)
+	second, err2 := strconv.ParseUint(g.Query("second"), 10, 64)
+	if err1 != nil || err2 != nil {
+		c.ResponseFailure(g, ErrParam, "first and second tree sizes are required")
+		return
+	}
+	proof, err := c.p.tlog.consistency(first, second)
+	if err != nil {
+		c.ResponseFailure(g, ErrParam, err.Error())
+		return
+	}
+	g.JSON(http.StatusOK, gin.H{"first": first, "second": second, "proof": proof})
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TUFController struct {
+	BaseController
+	p *Platform
+}
+
+func NewTUFController(p *Platform) *TUFController {
+	return &TUFController{p: p}
+}
+
+// Serve godoc
+// @Summary      TUF repository
+// @Description  Serve the TUF repository: /tuf/metadata/{root,targets,snapshot,timestamp}.json (plus N.root.json) and /tuf/targets/<version>/algorithm. Enabled with -tuf-key. Callers whose token covers only some channels get a targets.json listing only those channels' targets, signed with the same version.
+// @Tags         tuf
+// @Produce      json
+// @Produce      application/octet-stream
+// @Param        file  path  string  true  "metadata/<role>.json or targets/<version>/algorithm"
+// @Success      200  {file}  binary
+// @Failure      404  {object}  map[string]any
+// @Failure      403  {object}  map[string]any  "channel outside token scope"
+// @Security     BearerAuth
+// @Router       /tuf/{file} [get]
+func (c *TUFController) Serve(g *gin.Context) {
+	if c.p.tuf == nil {
+		c.ResponseFailure(g, ErrNotFound, "TUF export is not enabled (start the server with -tuf-key)")
+		return
+	}
+	file := strings.TrimPrefix(g.Param("file"), "/")
+	if name, ok := strings.CutPrefix(file, "metadata/"); ok {
+		// 令牌只覆盖部分渠道时，targets 只列出这些渠道的目标
+		if pr := c.p.principal(g); name == "targets.json" && !pr.IsAdmin() {
+			b, err := c.p.tuf.targetsFor(pr.Name+"|"+strings.Join(pr.Channels, ","), pr.CanAccess)
+			if err != nil {
+				c.ResponseFailure(g, ErrNotFound, "no such metadata "+name)
+				return
+			}
+			g.Data(http.StatusOK, "application/json", b)
+			return
+		}
+		b, ok := c.p.tuf.file(name)
+		if !ok {
+			c.ResponseFailure(g, ErrNotFound, "no such metadata "+name)
+			return
+		}
+		g.Data(http.StatusOK, "application/json", b)
+		return
+	}
+	target, ok := strings.CutPrefix(file, "targets/")
+	version, name, _ := strings.Cut(target, "/")
+	if !ok || name != "algorithm" {
+		c.ResponseFailure(g, ErrNotFound, "no such file")
+		return
+	}
+	c.p.store.mu.RLock()
+	rel, known := c.p.store.ReleasesByVersion[version]
+	c.p.store.mu.RUnlock()
+	if !known {
+		c.ResponseFailure(g, ErrNotFound, "no such target")
+		return
+	}
+	if !c.p.allowChannel(g, rel.Channel) {
+		return
+	}
+	a, err := c.p.artifacts.Open(version)
+	if err != nil {
+		c.ResponseFailure(g, ErrNotFound, "artifact missing for version "+version)
+		return
+	}
+	defer a.Close()
+	g.Header("Content-Disposition", "attachment; filename="+strconv.Quote("algorithm"))
+	http.ServeContent(g.Writer, g.Request, "algorithm", a.ModTime(), a)
+	c.p.recordDownload(g, rel)
+}
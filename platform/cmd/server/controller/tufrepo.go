@@ -0,0 +1,481 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TUF（The Update Framework）仓库导出：每次发布后在 /tuf/ 下重新生成 root / targets /
+// snapshot / timestamp 元数据，第三方 TUF 客户端与审计工具可直接使用现成工具消费。
+// 目标文件路径为 <version>/algorithm，内容直接取自制品存储；元数据可选落盘到 -tuf-dir。
+// 每个角色一把 ed25519 密钥（threshold 1）：-tuf-key 是 root 密钥，targets / snapshot / timestamp 各自的
+// 密钥未配置时退回 root 密钥并在启动时告警。角色密钥更换后以 root 密钥签发新版本 root.json，客户端按 TUF
+// 流程自动更新；root 密钥本身更换时新 root.json 需离线分发。不启用 consistent snapshot。
+// 目标的长度取自发布时记录的制品大小（Release.Size），重建元数据时不打开制品；旧记录在启动时补齐。
+// 冻结期间被冻结渠道的最新版本不标 latest_in（强制版本除外），冻结开始与结束时随之重建。
+// 令牌只覆盖部分渠道的调用方取到的 targets.json 只含这些渠道的目标，以相同的版本号与有效期另行签名，
+// snapshot 只记录 targets 的版本号，仍与之匹配。
+
+const tufSpecVersion = "1.0.31"
+
+// 各角色元数据的有效期；timestamp 由后台任务在过期前续签。
+const (
+	tufRootExpiry      = 365 * 24 * time.Hour
+	tufTargetsExpiry   = 90 * 24 * time.Hour
+	tufTimestampExpiry = 24 * time.Hour
+)
+
+// tufRoles 是 TUF 的四个顶层角色。
+var tufRoles = []string{"root", "targets", "snapshot", "timestamp"}
+
+type tufRepo struct {
+	mu     sync.RWMutex
+	keys   map[string]ed25519.PrivateKey // 角色 -> 签名密钥
+	keyIDs map[string]string
+	rekey  bool   // 角色密钥与已发布的 root.json 不一致，需签发新 root 并重签其它角色
+	dir    string // 为空时仅保存在内存
+
+	meta     map[string][]byte // 文件名 -> 已签名元数据
+	versions map[string]int    // 角色 -> 当前版本
+	expires  map[string]time.Time
+	targets  map[string]any    // 最近一次的 targets 内容，用于判断是否需要升版本
+	scoped   map[string][]byte // 按令牌范围裁剪后签名的 targets.json，targets 变化时清空
+}
+
+// newTUFRepo signs root with root and every other role with its key in
+// roleKeys, falling back to root.
+func newTUFRepo(root ed25519.PrivateKey, roleKeys map[string]ed25519.PrivateKey, dir string) (*tufRepo, error) {
+	r := &tufRepo{
+		keys:     map[string]ed25519.PrivateKey{},
+		keyIDs:   map[string]string{},
+		dir:      dir,
+		meta:     map[string][]byte{},
+		versions: map[string]int{},
+		expires:  map[string]time.Time{},
+	}
+	for role := range roleKeys {
+		if role == "root" || !slices.Contains(tufRoles, role) {
+			return nil, errors.New("tuf: unknown role key " + role)
+		}
+	}
+	for _, role := range tufRoles {
+		key := roleKeys[role]
+		if key == nil {
+			if role != "root" {
+				log.Printf("tuf: no %s key, signing %s metadata with the root key", role, role)
+			}
+			key = root
+		}
+		r.keys[role] = key
+		r.keyIDs[role] = tufKeyID(key.Public().(ed25519.PublicKey))
+	}
+	if dir != "" {
+		if err := os.MkdirAll(filepath.Join(dir, "metadata"), 0755); err != nil {
+			return nil, err
+		}
+		r.loadExisting()
+	}
+	return r, nil
+}
+
+// loadExisting 从已落盘的元数据恢复版本号，保证重启后版本单调递增。
+func (r *tufRepo) loadExisting() {
+	for _, role := range tufRoles {
+		b, err := os.ReadFile(filepath.Join(r.dir, "metadata", role+".json"))
+		if err != nil {
+			continue
+		}
+		var env struct {
+			Signed struct {
+				Version int            `json:"version"`
+				Expires time.Time      `json:"expires"`
+				Targets map[string]any `json:"targets"`
+				Keys    map[string]any `json:"keys"`
+				Roles   map[string]struct {
+					KeyIDs []string `json:"keyids"`
+				} `json:"roles"`
+			} `json:"signed"`
+		}
+		if json.Unmarshal(b, &env) != nil {
+			continue
+		}
+		r.versions[role] = env.Signed.Version
+		r.expires[role] = env.Signed.Expires
+		r.meta[role+".json"] = b
+		if role == "root" {
+			r.meta[strconv.Itoa(env.Signed.Version)+".root.json"] = b
+			if _, ok := env.Signed.Keys[r.keyIDs["root"]]; !ok {
+				log.Printf("tuf: root key changed, clients must be given the new root.json out of band")
+				delete(r.meta, "root.json")
+				r.rekey = true
+				continue
+			}
+			for _, rl := range tufRoles {
+				if ids := env.Signed.Roles[rl].KeyIDs; len(ids) != 1 || ids[0] != r.keyIDs[rl] {
+					log.Printf("tuf: %s key changed, publishing a new root.json", rl)
+					r.rekey = true
+				}
+			}
+		}
+		if role == "targets" {
+			r.targets = env.Signed.Targets
+		}
+	}
+}
+
+func tufKeyID(pub ed25519.PublicKey) string {
+	b, _ := canonicalJSON(tufKey(pub))
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func tufKey(pub ed25519.PublicKey) map[string]any {
+	return map[string]any{
+		"keytype": "ed25519",
+		"scheme":  "ed25519",
+		"keyval":  map[string]any{"public": hex.EncodeToString(pub)},
+	}
+}
+
+func tufExpires(t time.Time) string {
+	return t.UTC().Truncate(time.Second).Format("2006-01-02T15:04:05Z")
+}
+
+// envelope 生成 {"signed":…,"signatures":[…]} 信封。调用方持有 r.mu。
+func (r *tufRepo) envelope(role string, signed map[string]any, expires time.Time) ([]byte, error) {
+	signed["_type"] = role
+	signed["spec_version"] = tufSpecVersion
+	signed["version"] = r.versions[role]
+	signed["expires"] = tufExpires(expires)
+	cj, err := canonicalJSON(signed)
+	if err != nil {
+		return nil, err
+	}
+	return canonicalJSON(map[string]any{
+		"signed": signed,
+		"signatures": []any{map[string]any{
+			"keyid": r.keyIDs[role],
+			"sig":   hex.EncodeToString(ed25519.Sign(r.keys[role], cj)),
+		}},
+	})
+}
+
+// sign 签名并记录到 meta。调用方持有 r.mu。
+func (r *tufRepo) sign(role string, signed map[string]any, expires time.Time) error {
+	b, err := r.envelope(role, signed, expires)
+	if err != nil {
+		return err
+	}
+	name := role + ".json"
+	r.meta[name] = b
+	r.expires[role] = expires
+	if role == "root" {
+		r.meta[strconv.Itoa(r.versions[role])+".root.json"] = b
+	}
+	if r.dir == "" {
+		return nil
+	}
+	if err := writeFileAtomic(filepath.Join(r.dir, "metadata", name), b); err != nil {
+		return err
+	}
+	if role == "root" {
+		return writeFileAtomic(filepath.Join(r.dir, "metadata", strconv.Itoa(r.versions[role])+".root.json"), b)
+	}
+	return nil
+}
+
+// update 以给定的目标集合重建元数据：内容未变时只续签 timestamp（若临近过期）。
+func (r *tufRepo) update(now time.Time, targets map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 首次生成、密钥更换或临近过期时签发新版本 root
+	rekey := r.rekey
+	if _, ok := r.meta["root.json"]; !ok || rekey || now.Add(tufRootExpiry/12).After(r.expires["root"]) {
+		r.versions["root"]++
+		keys := map[string]any{}
+		roles := map[string]any{}
+		for _, role := range tufRoles {
+			keys[r.keyIDs[role]] = tufKey(r.keys[role].Public().(ed25519.PublicKey))
+			roles[role] = map[string]any{"keyids": []any{r.keyIDs[role]}, "threshold": 1}
+		}
+		if err := r.sign("root", map[string]any{
+			"consistent_snapshot": false,
+			"keys":                keys,
+			"roles":               roles,
+		}, now.Add(tufRootExpiry)); err != nil {
+			return err
+		}
+	}
+
+	r.rekey = false
+	// 密钥更换后其它角色以新密钥重签
+	changed := rekey || r.meta["targets.json"] == nil || !sameJSON(r.targets, targets) ||
+		now.Add(tufTargetsExpiry/3).After(r.expires["targets"])
+	if changed {
+		r.versions["targets"]++
+		if err := r.sign("targets", map[string]any{"targets": targets}, now.Add(tufTargetsExpiry)); err != nil {
+			return err
+		}
+		r.targets, r.scoped = targets, nil
+		r.versions["snapshot"]++
+		if err := r.sign("snapshot", map[string]any{
+			"meta": map[string]any{"targets.json": map[string]any{"version": r.versions["targets"]}},
+		}, now.Add(tufTargetsExpiry)); err != nil {
+			return err
+		}
+	}
+	if !changed && r.meta["timestamp.json"] != nil && now.Add(tufTimestampExpiry/2).Before(r.expires["timestamp"]) {
+		return nil
+	}
+	snap := r.meta["snapshot.json"]
+	sum := sha256.Sum256(snap)
+	r.versions["timestamp"]++
+	return r.sign("timestamp", map[string]any{
+		"meta": map[string]any{"snapshot.json": map[string]any{
+			"version": r.versions["snapshot"],
+			"length":  len(snap),
+			"hashes":  map[string]any{"sha256": hex.EncodeToString(sum[:])},
+		}},
+	}, now.Add(tufTimestampExpiry))
+}
+
+func (r *tufRepo) file(name string) ([]byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.meta[name]
+	return b, ok
+}
+
+// targetsFor returns targets.json holding only the targets of channels
+// allow accepts, signed with the version and expiry of the full one; key
+// names the scope for caching.
+func (r *tufRepo) targetsFor(key string, allow func(channel string) bool) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.scoped[key]; ok {
+		return b, nil
+	}
+	if r.meta["targets.json"] == nil {
+		return nil, errors.New("no targets yet")
+	}
+	out := map[string]any{}
+	for name, t := range r.targets {
+		entry, _ := t.(map[string]any)
+		custom, _ := entry["custom"].(map[string]any)
+		if ch, _ := custom["channel"].(string); allow(ch) {
+			out[name] = t
+		}
+	}
+	b, err := r.envelope("targets", map[string]any{"targets": out}, r.expires["targets"])
+	if err != nil {
+		return nil, err
+	}
+	if r.scoped == nil {
+		r.scoped = map[string][]byte{}
+	}
+	r.scoped[key] = b
+	return b, nil
+}
+
+func sameJSON(a, b any) bool {
+	x, err1 := canonicalJSON(a)
+	y, err2 := canonicalJSON(b)
+	return err1 == nil && err2 == nil && bytes.Equal(x, y)
+}
+
+// tufTargets 由当前版本集合生成 targets 条目；调用方持有 p.store.mu。
+func (p *Platform) tufTargets(s *Store) map[string]any {
+	channels := map[string][]string{}
+	frozen := map[string]*Freeze{}
+	for ch, v := range s.LatestByChannel {
+		// 冻结期间渠道最新的非强制版本不标 latest_in，按它选版本的客户端停在原版本（见 freeze.go）
+		app, channel := splitLatestKey(ch)
+		if f := p.activeFreeze(app, channel, ""); f != nil && (s.ReleasesByVersion[v] == nil || !s.ReleasesByVersion[v].Mandatory) {
+			frozen[v] = f
+			continue
+		}
+		channels[v] = append(channels[v], ch)
+	}
+	out := map[string]any{}
+	for v, rel := range s.ReleasesByVersion {
+		// 制品缺失的旧记录在启动时补不到大小，不列为目标
+		if rel.Size == 0 {
+			continue
+		}
+		latest := channels[v]
+		sort.Strings(latest)
+		custom := map[string]any{"version": v, "channel": rel.Channel, "format": releaseFormat(rel)}
+		if len(latest) > 0 {
+			custom["latest_in"] = strings.Join(latest, ",")
+		}
+		if f := frozen[v]; f != nil {
+			custom["frozen_until"] = f.EndsAt.UTC().Format(time.RFC3339)
+		}
+		out[v+"/algorithm"] = map[string]any{
+			"length": rel.Size,
+			"hashes": map[string]any{"sha256": rel.Sha256},
+			"custom": custom,
+		}
+	}
+	return out
+}
+
+// backfillSizes records the artifact size of releases published before
+// sizes were recorded, so rebuilding TUF metadata never opens artifacts.
+// Artifacts are opened without holding p.store.mu.
+func (p *Platform) backfillSizes() error {
+	p.store.mu.RLock()
+	var missing []string
+	for v, rel := range p.store.ReleasesByVersion {
+		if rel.Size == 0 {
+			missing = append(missing, v)
+		}
+	}
+	p.store.mu.RUnlock()
+	sizes := map[string]int64{}
+	for _, v := range missing {
+		a, err := p.artifacts.Open(v)
+		if err != nil {
+			log.Printf("tuf: skip %s: %v", v, err)
+			continue
+		}
+		sizes[v] = a.Size()
+		a.Close()
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+	for v, n := range sizes {
+		if rel := p.store.ReleasesByVersion[v]; rel != nil && rel.Size == 0 {
+			cp := *rel
+			cp.Size = n
+			p.store.ReleasesByVersion[v] = &cp
+		}
+	}
+	return p.scheduleSave()
+}
+
+// releaseFormat 返回制品格式，早期发布没有记录格式时视为 binary。
+func releaseFormat(rel *Release) string {
+	if rel.Format == "" {
+		return "binary"
+	}
+	return rel.Format
+}
+
+// refreshTUF 在发布后重建 TUF 元数据；调用方持有 p.store.mu。
+// 失败只记录日志：TUF 是附加的导出视图，不影响原生发布流程。
+func (p *Platform) refreshTUF(s *Store) {
+	if p.tuf == nil {
+		return
+	}
+	if err := p.tuf.update(p.clock.Now(), p.tufTargets(s)); err != nil {
+		log.Printf("tuf: update metadata: %v", err)
+	}
+}
+
+// renewTUF 定期续签 timestamp（及临近过期的 targets）。
+func (p *Platform) renewTUF() {
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+	p.refreshTUF(p.store)
+}
+
+// writeFileAtomic 通过临时文件 + rename 写出，读者不会看到半截元数据。
+func writeFileAtomic(fp string, b []byte) error {
+	tmp := fp + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fp)
+}
+
+// canonicalJSON 按 TUF 使用的规范 JSON 编码：键排序、无空白、仅整数。
+func canonicalJSON(v any) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeCanonical(&buf, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeCanonical(buf *bytes.Buffer, v any) error {
+	switch x := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(x))
+	case json.Number:
+		if _, err := x.Int64(); err != nil {
+			return errors.New("canonical json: non-integer number " + x.String())
+		}
+		buf.WriteString(x.String())
+	case string:
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(x); err != nil {
+			return err
+		}
+		buf.Truncate(buf.Len() - 1) // 去掉 Encode 追加的换行
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range x {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeCanonical(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeCanonical(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := encodeCanonical(buf, x[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return errors.New("canonical json: unsupported value")
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/von0000/dronealgo-ota/internal/clock"
+)
+
+func testKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+type tufEnvelope struct {
+	Signed     json.RawMessage `json:"signed"`
+	Signatures []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// verifyTUF checks that the role's metadata is signed by key and returns
+// its signed part.
+func verifyTUF(t *testing.T, r *tufRepo, role string, key ed25519.PrivateKey) map[string]any {
+	t.Helper()
+	b, ok := r.file(role + ".json")
+	if !ok {
+		t.Fatalf("no %s.json", role)
+	}
+	var env tufEnvelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatal(err)
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	sig, _ := hex.DecodeString(env.Signatures[0].Sig)
+	if len(env.Signatures) != 1 || env.Signatures[0].KeyID != tufKeyID(pub) || !ed25519.Verify(pub, env.Signed, sig) {
+		t.Fatalf("%s.json is not signed by its role key", role)
+	}
+	var signed map[string]any
+	if err := json.Unmarshal(env.Signed, &signed); err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestTUFRoleKeys(t *testing.T) {
+	root, targets := testKey(t), testKey(t)
+	dir := t.TempDir()
+	r, err := newTUFRepo(root, map[string]ed25519.PrivateKey{"targets": targets}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := r.update(now, map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	signed := verifyTUF(t, r, "root", root)
+	if keys := signed["keys"].(map[string]any); len(keys) != 2 {
+		t.Fatalf("root lists %d keys, want 2", len(keys))
+	}
+	verifyTUF(t, r, "targets", targets)
+	// 未配置密钥的角色使用 root 密钥
+	verifyTUF(t, r, "snapshot", root)
+	verifyTUF(t, r, "timestamp", root)
+
+	// 更换 targets 密钥：以 root 密钥签发新 root，并用新密钥重签 targets
+	rotated := testKey(t)
+	r, err = newTUFRepo(root, map[string]ed25519.PrivateKey{"targets": rotated, "timestamp": rotated}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.update(now.Add(time.Hour), map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if v := verifyTUF(t, r, "root", root)["version"]; v != 2.0 {
+		t.Fatalf("root version %v after a role key change, want 2", v)
+	}
+	if v := verifyTUF(t, r, "targets", rotated)["version"]; v != 2.0 {
+		t.Fatalf("targets version %v after a key change, want 2", v)
+	}
+	verifyTUF(t, r, "timestamp", rotated)
+	if _, ok := r.file("1.root.json"); !ok {
+		t.Fatal("previous root version no longer served")
+	}
+
+	if _, err := newTUFRepo(root, map[string]ed25519.PrivateKey{"root": rotated}, ""); err == nil {
+		t.Fatal("root key accepted as a role key")
+	}
+}
+
+// countingArtifacts fails the test if an artifact is opened while
+// p.store.mu is held.
+type countingArtifacts struct {
+	ArtifactStore
+	t     *testing.T
+	p     **Platform
+	opens int
+}
+
+func (c *countingArtifacts) Open(version string) (ArtifactReader, error) {
+	c.opens++
+	if p := *c.p; p != nil && !p.store.mu.TryLock() {
+		c.t.Errorf("artifact %s opened under the store lock", version)
+	} else if p != nil {
+		p.store.mu.Unlock()
+	}
+	return c.ArtifactStore.Open(version)
+}
+
+func TestTUFTargetsUseRecordedSizes(t *testing.T) {
+	var p *Platform
+	arts := &countingArtifacts{ArtifactStore: NewMemoryArtifactStore(), t: t, p: &p}
+	// 旧记录没有 size，启动时补齐
+	staged, err := arts.Stage(strings.NewReader("legacy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.Commit("0.9.0"); err != nil {
+		t.Fatal(err)
+	}
+	st, err := NewMemoryStorage([]byte(`{"releases_by_version": {"0.9.0": {"version": "0.9.0", "channel": "stable", "sha256": "00"}, "0.8.0": {"version": "0.8.0", "channel": "stable"}}, "latest_by_channel": {"stable": "0.9.0"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = NewPlatform(Options{
+		Storage:   st,
+		Artifacts: arts,
+		Events:    NewMemoryEventLog(time.Hour),
+		Clock:     clock.NewFake(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)),
+		TUFKey:    testKey(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.store.ReleasesByVersion["0.9.0"].Size; n != int64(len("legacy")) {
+		t.Fatalf("backfilled size %d", n)
+	}
+
+	arts.opens = 0
+	if _, _, err := p.publishRelease(publishInput{Version: "1.0.0", Channel: "stable", Format: "binary"}, strings.NewReader("payload")); err != nil {
+		t.Fatal(err)
+	}
+	p.renewTUF()
+	if arts.opens != 0 {
+		t.Fatalf("rebuilding TUF metadata opened %d artifacts", arts.opens)
+	}
+	b, _ := p.tuf.file("targets.json")
+	var env struct {
+		Signed struct {
+			Targets map[string]struct {
+				Length int64 `json:"length"`
+			} `json:"targets"`
+		} `json:"signed"`
+	}
+	if err := json.Unmarshal(b, &env); err != nil {
+		t.Fatal(err)
+	}
+	got := env.Signed.Targets
+	if len(got) != 2 || got["1.0.0/algorithm"].Length != int64(len("payload")) || got["0.9.0/algorithm"].Length != int64(len("legacy")) {
+		t.Fatalf("targets %+v; want 1.0.0 and 0.9.0 with their sizes, 0.8.0 (no artifact) left out", got)
+	}
+}
@@ -0,0 +1,324 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 设备维护时段：机队分布在多个时区，统一的 “UTC 02:00” 在一些地方是正午。每台设备可以有自己的安装时段：
+// agent 的 update_gate 为 window 时，检查带上时段（window，如 01:00-05:00，多个以逗号分隔）与时区（tz，
+// IANA 名称，未配置时为当前 UTC 偏移，如 +08:00）；管理员也可以在服务端为设备设置时段
+// （PUT /api/v1/devices/<id>/window），优先于设备上报。/check 只在设备当地时间落在时段内时下发新版本，
+// 时段外返回 update_available=false 与 window（时段、时区、下次开放时间），等同于延后到时段开始。
+// 强制版本（安全修复）、二分定位的待测版本与召回回滚不受时段限制；设备上报的时段无法解析时按没有时段处理，
+// 并记入检查事件（window_error）。
+
+// MaintenanceWindow 是管理员为一台设备设置的安装时段。
+type MaintenanceWindow struct {
+	DeviceID  string    `json:"device_id"`
+	Windows   []string  `json:"windows"`            // HH:MM-HH:MM，结束早于开始时跨午夜
+	Timezone  string    `json:"timezone,omitempty"` // IANA 名称或 UTC 偏移（+08:00），为空即 UTC
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by"`
+}
+
+// daySpan 是一天中的时段，以午夜起的分钟计。
+type daySpan struct{ start, end int }
+
+// windowSchedule 是解析后的时段与时区。
+type windowSchedule struct {
+	windows []string
+	spans   []daySpan
+	loc     *time.Location
+}
+
+var (
+	clockRe  = regexp.MustCompile(`^([01]?\d|2[0-4]):([0-5]\d)$`)
+	offsetRe = regexp.MustCompile(`^(?:UTC)?([+-])(\d{2}):(\d{2})$`)
+)
+
+// parseWindows validates window specs and a time zone.
+func parseWindows(specs []string, tz string) (*windowSchedule, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("no windows")
+	}
+	s := &windowSchedule{loc: time.UTC}
+	if tz != "" {
+		loc, err := parseZone(tz)
+		if err != nil {
+			return nil, err
+		}
+		s.loc = loc
+	}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		from, to, _ := strings.Cut(spec, "-")
+		start, ok1 := parseMinute(from)
+		end, ok2 := parseMinute(to)
+		if !ok1 || !ok2 || start == end {
+			return nil, fmt.Errorf("window %q: want HH:MM-HH:MM", spec)
+		}
+		s.windows = append(s.windows, spec)
+		s.spans = append(s.spans, daySpan{start, end})
+	}
+	return s, nil
+}
+
+func parseMinute(s string) (int, bool) {
+	m := clockRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	mm, _ := strconv.Atoi(m[2])
+	if h*60+mm > 24*60 {
+		return 0, false
+	}
+	return h*60 + mm, true
+}
+
+// parseZone accepts an IANA zone name or a fixed UTC offset.
+func parseZone(tz string) (*time.Location, error) {
+	if m := offsetRe.FindStringSubmatch(tz); m != nil {
+		h, _ := strconv.Atoi(m[2])
+		mm, _ := strconv.Atoi(m[3])
+		if h > 14 || mm > 59 {
+			return nil, fmt.Errorf("time zone %q: offset out of range", tz)
+		}
+		off := (h*60 + mm) * 60
+		if m[1] == "-" {
+			off = -off
+		}
+		return time.FixedZone("UTC"+m[1]+m[2]+":"+m[3], off), nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("time zone %q: %w", tz, err)
+	}
+	return loc, nil
+}
+
+// open reports whether now falls inside a window, and otherwise when the
+// next one starts.
+func (s *windowSchedule) open(now time.Time) (bool, time.Time) {
+	local := now.In(s.loc)
+	m := local.Hour()*60 + local.Minute()
+	var next time.Time
+	for _, sp := range s.spans {
+		in := m >= sp.start && m < sp.end
+		if sp.end < sp.start {
+			in = m >= sp.start || m < sp.end
+		}
+		if in {
+			return true, time.Time{}
+		}
+		y, mo, d := local.Date()
+		t := time.Date(y, mo, d, 0, sp.start, 0, 0, s.loc)
+		if !t.After(now) {
+			t = time.Date(y, mo, d+1, 0, sp.start, 0, 0, s.loc)
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return false, next
+}
+
+// deviceWindow returns the schedule that applies to device: the one set by
+// an operator, else the one the check reports. Callers hold p.store.mu.
+func (p *Platform) deviceWindow(device string, reported *windowSchedule) (*windowSchedule, string) {
+	if w := p.store.Windows[device]; w != nil && device != "" {
+		// PUT 时已校验
+		if s, err := parseWindows(w.Windows, w.Timezone); err == nil {
+			return s, "server"
+		}
+	}
+	if reported != nil {
+		return reported, "device"
+	}
+	return nil, ""
+}
+
+// reportedWindow parses the window a check reports, recording it (or why
+// it was ignored) in the event data.
+func reportedWindow(g *gin.Context, data map[string]any) *windowSchedule {
+	spec := g.Query("window")
+	if spec == "" {
+		return nil
+	}
+	tz := g.Query("tz")
+	data["window"], data["tz"] = spec, tz
+	s, err := parseWindows(strings.Split(spec, ","), tz)
+	if err != nil {
+		data["window_error"] = err.Error()
+		return nil
+	}
+	return s
+}
+
+// windowHold returns the window field of a check response and when the
+// window opens if device is outside its maintenance window, nil when the
+// update may be offered now. Callers hold p.store.mu.
+func (p *Platform) windowHold(device string, reported *windowSchedule) (gin.H, time.Time) {
+	s, source := p.deviceWindow(device, reported)
+	if s == nil {
+		return nil, time.Time{}
+	}
+	open, next := s.open(p.clock.Now())
+	if open {
+		return nil, time.Time{}
+	}
+	return gin.H{"windows": s.windows, "timezone": s.loc.String(), "source": source, "next_open": next}, next
+}
+
+// DeviceWindow godoc
+// @Summary      Get a device's maintenance window
+// @Description  The window set for the device on the server, the window its agent last reported with a check, which of them applies (the server's wins) and whether it is open now.
+// @Tags         policy
+// @Produce      json
+// @Param        id  path  string  true  "Device ID"
+// @Success      200  {object}  map[string]any  "device_id, configured (controller.MaintenanceWindow, null when unset), reported (windows, timezone, reported_at; null when the device reports none), source (server|device, empty when none applies), open, next_open"
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/devices/{id}/window [get]
+func (c *PolicyController) DeviceWindow(g *gin.Context) {
+	device := g.Param("id")
+	evs, err := c.p.events.Query(EventQuery{DeviceID: device, Type: "check", Limit: 1})
+	if err != nil {
+		c.ResponseFailure(g, ErrInternal, err.Error())
+		return
+	}
+	var reported gin.H
+	var rs *windowSchedule
+	if len(evs) > 0 {
+		if spec, _ := evs[0].Data["window"].(string); spec != "" {
+			tz, _ := evs[0].Data["tz"].(string)
+			reported = gin.H{"windows": strings.Split(spec, ","), "timezone": tz, "reported_at": evs[0].Time}
+			if msg, _ := evs[0].Data["window_error"].(string); msg != "" {
+				reported["error"] = msg
+			} else {
+				rs, _ = parseWindows(strings.Split(spec, ","), tz)
+			}
+		}
+	}
+	c.p.store.mu.RLock()
+	configured := c.p.store.Windows[device]
+	s, source := c.p.deviceWindow(device, rs)
+	c.p.store.mu.RUnlock()
+	resp := gin.H{"device_id": device, "configured": configured, "reported": reported, "source": source, "open": true}
+	if s != nil {
+		open, next := s.open(c.p.clock.Now())
+		resp["open"] = open
+		if !open {
+			resp["next_open"] = next
+		}
+	}
+	g.JSON(http.StatusOK, resp)
+}
+
+// PutDeviceWindow godoc
+// @Summary      Set a device's maintenance window
+// @Description  Checks from the device are offered new versions only while its local time is inside one of the windows; overrides the window the agent reports. Mandatory releases, bisect pins and recall rollbacks are not held. Audited.
+// @Tags         policy
+// @Accept       json
+// @Produce      json
+// @Param        id    path  string  true  "Device ID"
+// @Param        body  body  object  true  "{\"windows\": [\"22:00-03:00\"], \"timezone\": \"America/Denver\", \"reason\": \"...\"}; windows end earlier than they start cross midnight, timezone is an IANA name or UTC offset such as +08:00 (default UTC)"
+// @Success      200  {object}  controller.MaintenanceWindow
+// @Failure      400  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/devices/{id}/window [put]
+func (c *PolicyController) PutDeviceWindow(g *gin.Context) {
+	var body struct {
+		Windows  []string `json:"windows"`
+		Timezone string   `json:"timezone"`
+		Reason   string   `json:"reason"`
+	}
+	if err := g.ShouldBindJSON(&body); err != nil {
+		c.ResponseFailure(g, ErrParam, "invalid body: "+err.Error())
+		return
+	}
+	device := g.Param("id")
+	s, err := parseWindows(body.Windows, strings.TrimSpace(body.Timezone))
+	if err != nil {
+		c.ResponseFailure(g, ErrParam, err.Error())
+		return
+	}
+	actor := c.p.principal(g).Name
+	w := &MaintenanceWindow{DeviceID: device, Windows: s.windows, Timezone: strings.TrimSpace(body.Timezone),
+		UpdatedAt: c.p.clock.Now(), UpdatedBy: actor}
+
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	prev := c.p.store.Windows
+	next := make(map[string]*MaintenanceWindow, len(prev)+1)
+	for k, x := range prev {
+		next[k] = x
+	}
+	next[device] = w
+	c.p.store.Windows = next
+	err = c.p.saveStore(c.p.store)
+	if err != nil {
+		c.p.store.Windows = prev
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save window"), fsErr(err, "save window").Error())
+		return
+	}
+	_ = c.p.audit(actor, "window_set", strings.TrimSpace(body.Reason),
+		map[string]any{"device_id": device, "windows": w.Windows, "timezone": w.Timezone})
+	g.JSON(http.StatusOK, w)
+}
+
+// DeleteDeviceWindow godoc
+// @Summary      Remove a device's maintenance window
+// @Description  The window the device's agent reports applies again, if any. Audited.
+// @Tags         policy
+// @Produce      json
+// @Param        id  path  string  true  "Device ID"
+// @Success      200  {object}  map[string]any
+// @Failure      403  {object}  map[string]any
+// @Failure      404  {object}  map[string]any
+// @Failure      500  {object}  map[string]any
+// @Security     BearerAuth
+// @Router       /api/v1/devices/{id}/window [delete]
+func (c *PolicyController) DeleteDeviceWindow(g *gin.Context) {
+	device := g.Param("id")
+	c.p.reloadIfChanged()
+	c.p.store.mu.Lock()
+	prev := c.p.store.Windows
+	if prev[device] == nil {
+		c.p.store.mu.Unlock()
+		c.ResponseFailure(g, ErrNotFound, "no window set for "+device)
+		return
+	}
+	next := make(map[string]*MaintenanceWindow, len(prev))
+	for k, x := range prev {
+		if k != device {
+			next[k] = x
+		}
+	}
+	c.p.store.Windows = next
+	err := c.p.saveStore(c.p.store)
+	if err != nil {
+		c.p.store.Windows = prev
+	}
+	c.p.store.mu.Unlock()
+	if err != nil {
+		c.ResponseFailure(g, c.p.fsErrCode(err, "save window"), fsErr(err, "save window").Error())
+		return
+	}
+	_ = c.p.audit(c.p.principal(g).Name, "window_deleted", "", map[string]any{"device_id": device})
+	g.JSON(http.StatusOK, gin.H{"deleted": device})
+}
@@ -15,33 +15,79 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/v1/check": {
+        "/api/v1/approvals": {
             "get": {
-                "description": "Check whether a newer version is available under the channel.",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Installs waiting for (or decided by) an operator, newest request first.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "release"
+                    "policy"
                 ],
-                "summary": "Check for updates",
+                "summary": "List install approvals",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Channel (stable|beta), default: stable",
-                        "name": "channel",
+                        "description": "Only this state (pending|approved|rejected|expired)",
+                        "name": "state",
                         "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "approvals",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/approvals/bulk": {
+            "post": {
+                "security": [
                     {
-                        "type": "string",
-                        "description": "Current version on device",
-                        "name": "current",
-                        "in": "query"
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Decides every pending approval listed in ids, or, without ids, every pending approval matching all given filters (version, channel, policy, device ID globs). At least one selector is required.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "Approve or reject many pending installs",
+                "parameters": [
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "update_available, latest, message",
+                        "description": "decided",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
@@ -54,6 +100,13 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -64,52 +117,151 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/v1/publish": {
+        "/api/v1/approvals/{id}/{decision}": {
             "post": {
-                "description": "Upload the algorithm binary and create a release record.",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Approving releases the update to the device at its next check; rejecting keeps it withheld. Decisions are recorded in the audit log; expired approvals cannot be decided.",
                 "consumes": [
-                    "multipart/form-data"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "release"
+                    "policy"
                 ],
-                "summary": "Publish an algorithm artifact",
+                "summary": "Approve or reject an install",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Version (e.g. 1.1.0)",
-                        "name": "version",
-                        "in": "formData",
+                        "description": "Approval ID",
+                        "name": "id",
+                        "in": "path",
                         "required": true
                     },
                     {
                         "type": "string",
-                        "description": "Channel (stable|beta), default: stable",
-                        "name": "channel",
-                        "in": "formData"
+                        "description": "approve | reject",
+                        "name": "decision",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Approval"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/audit": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Audited actions (break-glass access, approvals, policies, bisections, shadow deployments, recalls, purges, support bundles…), newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Audit log",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "RFC3339 lower bound",
+                        "name": "since",
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Release notes",
-                        "name": "notes",
-                        "in": "formData"
+                        "description": "Only this actor",
+                        "name": "actor",
+                        "in": "query"
                     },
                     {
-                        "type": "file",
-                        "description": "Algorithm binary",
-                        "name": "file",
-                        "in": "formData",
-                        "required": true
+                        "type": "string",
+                        "description": "Only this action (e.g. breakglass_grant)",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, default 100, at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "time, actor or action, - for descending (default -time)",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the number of matching records",
+                        "name": "total",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "records, next_cursor, total",
                         "schema": {
-                            "$ref": "#/definitions/controller.Release"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "400": {
@@ -119,6 +271,13 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -128,33 +287,6206 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "controller.Release": {
-            "type": "object",
-            "properties": {
-                "channel": {
-                    "description": "e.g. \"stable\", \"beta\"",
-                    "type": "string"
-                },
-                "created_at": {
-                    "type": "string"
-                },
-                "notes": {
-                    "type": "string"
-                },
-                "sha256": {
-                    "type": "string"
-                },
-                "url": {
-                    "description": "relative: /download/\u003cversion\u003e",
-                    "type": "string"
-                },
-                "version": {
-                    "type": "string"
+        },
+        "/api/v1/bisections": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Bisections with their steps, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bisect"
+                ],
+                "summary": "List bisections",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this state (running|done|aborted)",
+                        "name": "state",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "bisections",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Steps a test device through the releases between a known-good and a regressed version, binary-search style. Each candidate is pinned for the device via /check, installed and soaked; a crash or a crashing algorithm health report during the soak marks it bad, otherwise good. Candidates that fail to install, or are not installed within an hour, are skipped.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bisect"
+                ],
+                "summary": "Start bisecting a field regression",
+                "parameters": [
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Bisection"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
                 }
             }
+        },
+        "/api/v1/bisections/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bisect"
+                ],
+                "summary": "Show a bisection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bisection ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Bisection"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/bisections/{id}/abort": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stops a running bisection and unpins the device; its next check resumes the channel's normal updates.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "bisect"
+                ],
+                "summary": "Abort a bisection",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bisection ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Bisection"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/breakglass": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Exchange a personal breakglass-role token for a short-lived admin token. A reason is required; the grant, every request made with the temporary token and its expiry are audited, and an alert is sent.",
+                "consumes": [
+                    "application/x-www-form-urlencoded"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Break-glass: request temporary admin rights",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Why elevated access is needed (at least 10 characters)",
+                        "name": "reason",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Requested lifetime, e.g. 30m (default 30m, capped by -breakglass-max)",
+                        "name": "duration",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "token, operator, expires_at",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revoke the temporary admin token used for this request before it expires.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Break-glass: end the session",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/check": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Check whether a newer version is available under the channel. Device-group update policies may withhold it until an operator approves the install, and a device's maintenance window until its local time is inside the window. Responses carry an ETag over their content; a poll with a matching If-None-Match is answered 304 without a body.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Check for updates",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Channel (stable|beta), default: stable",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Application on multi-app devices (e.g. landing), default: the primary algorithm",
+                        "name": "app",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version alias the device follows instead of the channel's latest (e.g. stable-eu); token scope and policies use the channel of its target",
+                        "name": "alias",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Current version on device",
+                        "name": "current",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version the device is pinned to (agent pinned_version), offered instead of the channel's or alias's latest even when older; nothing is offered while it is unknown, recalled or quarantined",
+                        "name": "pin",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Device ID, recorded in the device event log",
+                        "name": "device_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Agent install backend (binary|deb|rpm); only releases of that artifact format are offered (the channel's newest one in the format when its latest is another), and it is recorded in the device event log",
+                        "name": "backend",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Device region (e.g. eu), selects region-pinned artifact mirrors; derived from the client IP when omitted",
+                        "name": "region",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Device site within the region (e.g. hangar-3)",
+                        "name": "site",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Algorithm process state (running|crashing|stopped)",
+                        "name": "algo_health",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Seconds the algorithm process has been running",
+                        "name": "algo_uptime",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Algorithm crashes within the last hour",
+                        "name": "algo_crashes",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Device's preferred maintenance windows in its local time, comma-separated (e.g. 01:00-05:00); updates are offered only inside them unless a server-side window is set",
+                        "name": "window",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Time zone of window (IANA name or UTC offset such as +08:00), default UTC",
+                        "name": "tz",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Device locale for message (e.g. zh-CN), preferred over Accept-Language",
+                        "name": "locale",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Preferred languages for message",
+                        "name": "Accept-Language",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Hardware-derived instance fingerprint, used to detect duplicated device IDs",
+                        "name": "X-Device-Instance",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the previous check response; 304 while the decision is unchanged",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "update_available, latest, download_url (closest mirror), download_urls (closest first, origin last), message (localized), message_id, safety, breaking, mandatory, collect_diagnostics; approval, approval_id when a device-group policy withholds the update; pinned (version, plus bisect_id while the device is being bisected) when the device is bisected or pinned by its agent (pin); shadow (id, soak_minutes, release, download_urls) while the device takes part in a shadow deployment; rollback when the device's version was recalled (a recalled latest is not offered); alias (name, version) when following an alias; rollout (id, version, percent) while a progressive rollout holds the channel's latest back from the device; window (windows, timezone, source, next_open) while the device is outside its maintenance window; freeze (id, scope, reason, ends_at) while an update freeze covers the check",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        },
+                        "headers": {
+                            "Content-Language": {
+                                "type": "string",
+                                "description": "Locale of message"
+                            },
+                            "ETag": {
+                                "type": "string",
+                                "description": "digest of the response; unchanged while the channel's latest release and the device's decision are"
+                            },
+                            "X-Device-ID": {
+                                "type": "string",
+                                "description": "New device ID after a duplicate-ID split"
+                            }
+                        }
+                    },
+                    "304": {
+                        "description": "decision unchanged since the If-None-Match ETag",
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "digest of the response; unchanged while the channel's latest release and the device's decision are"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "channel outside token scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "unknown alias",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/crashes": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the algorithm crashes reported by agents across the fleet, newest first (by server-assigned seq; since/until filter on the server record time). Crashes of other apps on multi-app devices carry the app and its version in data.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Algorithm crashes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this device",
+                        "name": "device_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only devices running this version",
+                        "name": "version",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this channel",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 lower bound of the server record time",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 upper bound of the server record time",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, default 100, at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "seq (recording order), - for descending (default -seq, newest first)",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the number of matching crashes (scans the whole log)",
+                        "name": "total",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "crashes, next_cursor, total",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/devices/{id}/window": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The window set for the device on the server, the window its agent last reported with a check, which of them applies (the server's wins) and whether it is open now.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "Get a device's maintenance window",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "device_id, configured (controller.MaintenanceWindow, null when unset), reported (windows, timezone, reported_at; null when the device reports none), source (server|device, empty when none applies), open, next_open",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Checks from the device are offered new versions only while its local time is inside one of the windows; overrides the window the agent reports. Mandatory releases, bisect pins and recall rollbacks are not held. Audited.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "Set a device's maintenance window",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\"windows\": [\"22:00-03:00\"], \"timezone\": \"America/Denver\", \"reason\": \"...\"}; windows end earlier than they start cross midnight, timezone is an IANA name or UTC offset such as +08:00 (default UTC)",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.MaintenanceWindow"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The window the device's agent reports applies again, if any. Audited.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "Remove a device's maintenance window",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/devices/conflicts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Device IDs that several physical devices appear to share (different instance fingerprints, or alternating IPs for agents without one).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Duplicate device IDs",
+                "responses": {
+                    "200": {
+                        "description": "conflicts, next_cursor, total",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                },
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size, default 100, at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "last_seen, first_seen or device_id, - for descending (default -last_seen)",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the number of conflicts",
+                        "name": "total",
+                        "in": "query"
+                    }
+                ]
+            }
+        },
+        "/api/v1/devices/{id}/events": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List a device's events (checks, reports, heartbeats, crashes, ID conflicts), newest first. Events are ordered by server-assigned sequence numbers: seq across the fleet and device_seq (1, 2, 3, ...) within the device; received is when the server recorded the event, and since/until filter on it. time is the device-reported time, informational only since device clocks may be wrong.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Device event timeline",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Event type (check|report|heartbeat|crash|register|diagnostics|shadow|conflict)",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 lower bound of the server record time",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 upper bound of the server record time",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, default 100, at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "seq (recording order), - for descending (default -seq, newest first)",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the number of matching events (scans the whole log)",
+                        "name": "total",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "device_id, events, next_cursor, total",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Accept a batch of events (install reports, heartbeats, crashes, registrations, diagnostics, shadow comparison metrics) from a device, typically flushed from the agent's offline queue. Events carrying a key already seen within the dedup window (-dedup-window, default 72h) are acknowledged but not recorded again; with an Idempotency-Key header, events without a key get \u003cheader\u003e/\u003cindex\u003e. The body may be sent with Content-Encoding: gzip (at most 8 MB either way).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Upload device events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "gzip",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Batch key; events without a key are deduplicated as \u003ckey\u003e/\u003cindex\u003e",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "accepted, duplicates",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "415": {
+                        "description": "unsupported Content-Encoding",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "an event could not be recorded; send the batch again",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/devices/{id}/logs": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The most recent algorithm output lines uploaded by a device, oldest first. truncated is true when older lines also matched.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Device algorithm logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time; only lines read at or after it",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time; only lines read before it",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this app (\"\" is the primary algorithm); all apps when omitted",
+                        "name": "app",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only lines written by this version",
+                        "name": "version",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only lines containing this text",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "At most this many lines, default 1000, at most 10000",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "lines, truncated",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/devices/{id}/split": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Give each instance fingerprint seen under the ID a distinct device ID. The first instance keeps the original ID unless mapped explicitly; the others default to \u003cid\u003e-\u003cfingerprint prefix\u003e. Past events carrying an instance fingerprint move to the new IDs, and the next check response tells each agent its new ID (X-Device-ID header).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Split a duplicated device ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "device_id, assigned, events_moved",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/devices/{id}/support-bundle": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "A tar.gz for a vendor support ticket: the device's event timeline, install reports, crash groups (by version and exit reason), the releases it ran or moved between, and the audit records concerning it, over the last days (default 30). The export itself is audited.",
+                "produces": [
+                    "application/gzip"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Device support bundle",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Days of history, default 30, at most 365",
+                        "name": "days",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/doctor": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Audit release records against the artifact store and device events: missing artifacts, sha256 mismatches, artifacts without a record, leftover uploads, channels whose latest version is missing or unservable, and devices running versions without a record.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Check platform consistency",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ConsistencyReport"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/doctor/repair": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Run the consistency check and fix what is safe to fix: repoint channels at their newest intact release, move releases whose artifact fails its sha256 to the quarantined channel, delete artifacts without a record and leftover uploads. Missing artifacts and devices on unknown versions are only reported. The repair is recorded in the audit log.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Repair platform inconsistencies",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ConsistencyReport"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "503": {
+                        "description": "server is read-only",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/export/{version}/{format}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Package a release as a SWUpdate (.swu), RAUC (.raucb) or Mender (.mender) bundle, or as a sideload bundle (.ota: a tar of release.json, transparency.json when the server keeps a transparency log, and the artifact) that agents without connectivity install from their sideload_dir (e.g. a USB stick) with the same verification as online updates. RAUC export requires the rauc tool and a signing certificate configured on the server; recalled and quarantined releases cannot be exported in any format.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "Export a release for an embedded updater",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Version (e.g. 1.1.0)",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bundle format (swupdate|rauc|mender|sideload)",
+                        "name": "format",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Install path on the device, default: /opt/dronealgo/algorithm",
+                        "name": "path",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RAUC compatible / Mender device type, default: dronealgo",
+                        "name": "target",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RAUC slot class, default: algo",
+                        "name": "slot",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "channel outside token scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/fleet/adoption": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Per daily snapshot, the share of a channel's devices running the version or newer, and the first date the share reached the threshold (null if never).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Adoption of a version over time",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Channel, default: stable",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Target version",
+                        "name": "version",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "number",
+                        "description": "Threshold share between 0 and 1, default: 0.9",
+                        "name": "share",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "First date (YYYY-MM-DD)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last date (YYYY-MM-DD)",
+                        "name": "until",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "channel, version, share, reached_on, series",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/fleet/data-usage": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the data each device's agent sent and received in its current billing period, from its latest heartbeat, heaviest users first, with the monthly cap where one is configured. Devices at their cap only download mandatory releases until the next period.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Device data usage against monthly caps",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this channel",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 start of the window searched for heartbeats, default: 7 days ago",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only devices at their cap",
+                        "name": "capped",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "devices ([]controller.DeviceDataUsage), capped (count)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/fleet/health": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Devices that checked in within the last 24 hours, per channel: up to date and healthy, up to date but with the algorithm crashing or stopped, outdated, or up to date without health reporting (older agents). Lists the up-to-date devices whose algorithm is not running cleanly, and the devices whose recent installs (last 30 days, at least 3) mostly exceeded the per-phase install duration SLOs.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Fleet update and algorithm health",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this channel",
+                        "name": "channel",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "channels, unhealthy, slow_devices",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/fleet/resources": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Aggregates the CPU, resident memory and open file descriptors of the algorithm process that agents sample and report with their heartbeats, per running version, and compares each version with a baseline (default: the version most devices ran) so a release's extra cost shows up before it reaches the whole fleet.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Algorithm resource usage per version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this channel",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 start of the window, default: 24 hours ago",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Version to compare against, default: the version with the most devices",
+                        "name": "baseline",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "baseline, versions ([]controller.ResourceProfile, newest first)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/fleet/versions": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "One snapshot per day and channel of how many active devices (seen in the last 7 days) run each version, oldest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Daily version distribution snapshots",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this channel",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "First date (YYYY-MM-DD)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last date (YYYY-MM-DD)",
+                        "name": "until",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "snapshots",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/freezes": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Current and scheduled freezes, earliest start first; ended freezes are removed (and audited) automatically.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "List update freezes",
+                "responses": {
+                    "200": {
+                        "description": "freezes (each with active: whether it is in effect now)",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Until ends_at, checks in the scope are not offered new versions (mandatory releases, bisect pins and recall rollbacks excepted); the check response carries freeze (id, scope, reason, ends_at) and the embedded-updater poll answers 204. Scopes: global, project (app; empty is the primary algorithm), channel (channel globs) and group (device ID globs). A freeze lasts at most 90 days and lifts itself when it ends. Audited.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "Freeze updates",
+                "parameters": [
+                    {
+                        "description": "{\"scope\": \"channel\", \"channels\": [\"stable\"], \"reason\": \"...\", \"starts_at\": \"2026-11-01T00:00:00Z\", \"ends_at\": \"2026-11-03T00:00:00Z\"}; app for project, devices for group; starts_at defaults to now, duration (e.g. 48h) may replace ends_at",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Freeze"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/freezes/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Ends (or cancels a scheduled) freeze early; the next checks in its scope are offered updates again. Audited.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "Lift an update freeze",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Freeze ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\"reason\": \"...\"}",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/log/consistency": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Proof that the log at tree size first is a prefix of the log at tree size second.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transparency"
+                ],
+                "summary": "Consistency proof",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Older tree size",
+                        "name": "first",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Newer tree size",
+                        "name": "second",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "first, second, proof",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/log/entries": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Leaves [start, end) of the transparency log, for auditors recomputing the tree. Each item has the raw leaf (base64) and its decoded entry; leaves of channels outside the caller's token scope only carry leaf_hash.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transparency"
+                ],
+                "summary": "Log entries",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "First leaf index, default 0",
+                        "name": "start",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "One past the last leaf index, default start+1000",
+                        "name": "end",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "entries",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/log/proof": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Audit path proving the newest log entry of a version is included in the returned signed tree head.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transparency"
+                ],
+                "summary": "Inclusion proof for a release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Release version",
+                        "name": "version",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "leaf_index, leaf, entry, audit_path, tree_head",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "channel outside token scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/log/sth": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Current signed tree head of the release transparency log. The ed25519 signature covers \"dronealgo-ota transparency log\\n\u003ctree_size\u003e\\n\u003croot_hash\u003e\\n\u003ctimestamp\u003e\\n\".",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transparency"
+                ],
+                "summary": "Signed tree head",
+                "responses": {
+                    "200": {
+                        "description": "tree_size, root_hash, timestamp, key_id, signature",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/policies": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Update policies in evaluation order; the first policy matching a device (ID and channel globs) applies, unmatched devices auto-install.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "List update policies",
+                "responses": {
+                    "200": {
+                        "description": "policies",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/policies/{name}": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Modes: auto (install any update), patch (auto-install patch-level updates only, others need approval), approval (every install needs operator approval per device). A new policy is appended to the evaluation order; replacing keeps its position.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "Create or replace an update policy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Policy name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Policy (name is taken from the path)",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controller.UpdatePolicy"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.UpdatePolicy"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "policy"
+                ],
+                "summary": "Delete an update policy",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Policy name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/publish": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Upload the algorithm binary and create a release record. ELF binaries are inspected on upload (architecture, stripped, embedded version string, Go build info, stored as binary) and rejected when they contradict the expected arch or, for tagged Go builds, the version.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Publish an algorithm artifact",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Version (e.g. 1.1.0)",
+                        "name": "version",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Channel (stable|beta), default: stable",
+                        "name": "channel",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Application on multi-app devices (e.g. landing), or agent for the agent program itself (plain binary); default: the primary algorithm",
+                        "name": "app",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Release notes",
+                        "name": "notes",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Structured notes: one-line summary (also used as notes when notes is empty)",
+                        "name": "summary",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Structured notes: the release contains breaking changes",
+                        "name": "breaking",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Structured notes: safety impact (none|low|high|experimental), default: none",
+                        "name": "safety",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Structured notes: comma-separated linked issue IDs",
+                        "name": "issues",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Artifact format (binary|deb|rpm), default: binary",
+                        "name": "format",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Campaign the download traffic is billed to (e.g. vision/2.3-rollout), default: the version",
+                        "name": "campaign",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Install even on devices below their battery threshold (e.g. a safety fix)",
+                        "name": "mandatory",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Space-separated arguments that run the algorithm passively for shadow deployments (e.g. --port=9101 --no-actuate)",
+                        "name": "shadow_args",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Launch template for interpreted algorithms (e.g. python3 {dir}/main.py --model {dir}/model.onnx; variables {dir}, {version}, {install_dir}); the file is then a tar.gz or zip bundle",
+                        "name": "launch",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Artifact type (binary|oci), default: binary; oci: the file is an image archive (docker save or OCI image layout tar, optionally gzipped) holding one image, which agents load and run as a container",
+                        "name": "artifact_type",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Expected architecture of an ELF binary (GOARCH name, e.g. arm64), default: the server's -artifact-arch; the publish is rejected when the artifact differs",
+                        "name": "arch",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Algorithm binary, or a tar.gz / zip package with a manifest.json (entrypoint and per-file sha256)",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "cosign sign-blob --bundle output; required when the server enforces cosign",
+                        "name": "cosign_bundle",
+                        "in": "formData"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Release"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "503": {
+                        "description": "read-only after disk full",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "507": {
+                        "description": "disk full",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/purge": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Delete all stored data (check-ins, install reports, heartbeats, crash reports, algorithm logs) of the given devices, or of a customer identified by its channels (globs): events on those channels and every event of devices seen on them. The purge is recorded in the audit log; dry_run only counts.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Purge device data",
+                "parameters": [
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "devices, events, algo_logs (devices whose logs were deleted), dry_run",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/releases": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the releases visible to the caller's token, newest first, with the latest version of each visible channel.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "List releases",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this channel",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this application (\"\" lists every app)",
+                        "name": "app",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, default 100, at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created_at, version or channel, - for descending (default -created_at)",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the number of matching releases",
+                        "name": "total",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "releases, latest_by_channel (keyed \u003capp\u003e/\u003cchannel\u003e for apps other than the primary), next_cursor, total",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/releases/compare": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "What changes between two releases: artifact size delta, whether the sha256 changed, old and new values of each changed metadata field (channel, format, notes, safety impact, breaking, mandatory, launch template, shadow args, signing, architecture, Go version…), issues added and removed, and, when the tar.gz bundles carry an SBOM (CycloneDX or SPDX JSON), the dependencies added, removed or changed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Compare two releases",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Old version (e.g. 1.2.0)",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "New version (e.g. 1.3.0)",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "from, to, size, sha256, changes, issues, sbom",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "channel outside token scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/releases/{version}/recall": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Recall a release of the primary app: devices running it are told to roll back to the previous version kept on the device (rollback in the check response, nothing is downloaded), and it is no longer offered as an update. Audited.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Recall a release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Version to recall",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Release"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Withdraw the recall of a release: it is offered again and devices are no longer told to roll back from it. Versions already rolled back stay marked bad on those devices. Audited.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Cancel a recall",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Recalled version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Release"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/releases/{version}/restore": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Move a release out of the quarantined channel after it was demoted automatically by an integrity check. It returns to the channel it was quarantined from (or the given one) and becomes that channel's latest version again when it is newer than the current one. A reason is required. Audited.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Restore a quarantined release",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Quarantined version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Release"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/replication": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Per replica (S3 bucket or platform instance started with -accept-replication): whether it is in sync, the replication lag (age of the oldest change not yet copied), artifacts pending and copied, and the last error. Empty when the server runs without -replicate.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Replication status",
+                "responses": {
+                    "200": {
+                        "description": "replicas",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/replication/artifacts/{version}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Re-read the stored artifact of a version and return its sha256 and size, so the primary can verify a copy. Needs -accept-replication.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Verify a replicated artifact",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "version, sha256, size",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Store an artifact pushed by the primary region's replication worker. The body is the raw artifact; it is only committed when it hashes to the sha256 query parameter. Needs -accept-replication.",
+                "consumes": [
+                    "application/octet-stream"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Receive a replicated artifact",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Version",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Expected sha256 of the body",
+                        "name": "sha256",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "version, sha256, size",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "503": {
+                        "description": "server is read-only",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/replication/metrics": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replication lag, pending artifacts, copied bytes and errors per replica in the Prometheus text exposition format.",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Replication metrics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/replication/releases": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replace this server's release index (releases.json: releases, channel pointers, aliases, policies, rollouts ...) with the primary region's. The primary only sends it once every artifact it references was copied and verified. Needs -accept-replication; do not publish on a replica.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Receive the replicated release index",
+                "responses": {
+                    "200": {
+                        "description": "releases",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "503": {
+                        "description": "server is read-only",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/aliases": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Named pointers to concrete versions, sorted by name, with the channel of each target.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "List version aliases",
+                "responses": {
+                    "200": {
+                        "description": "aliases",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/aliases/{name}": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Point the alias at a version in one step; agents subscribed to it are offered the version on their next check if it is newer than what they run. An alias stays with the app of its first target. With expect, the alias is only moved while it still points at that version. Audited.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Create or retarget a version alias",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Alias name (letters, digits, ., _ and -), e.g. stable-eu",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\"version\": \"1.2.0\", \"expect\": \"1.1.0\", \"reason\": \"...\"}",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Alias"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Agents still subscribed to the alias fail their checks until it is recreated. Audited.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Delete a version alias",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Alias name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/channels/{channel}/history": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Every change of the channel's latest version, oldest first: when, from which version to which, why (publish, shadow_promote, rollout, repair, quarantine, restore, reload, replication) and by whom. The history is append-only and outlives event retention.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Channel history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Channel",
+                        "name": "channel",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "App, default: the primary algorithm",
+                        "name": "app",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time; only changes at or after it",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time; only changes at or before it",
+                        "name": "until",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "app, channel, changes",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/channels/{channel}/latest": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The version /check offered as the channel's latest at the given time (unless it was recalled then; recalls are in the audit log), with the change that made it latest. version is empty when the channel had been removed by then. 404 when the history has no record of the channel at or before that time.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Latest version of a channel at a point in time",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Channel",
+                        "name": "channel",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "App, default: the primary algorithm",
+                        "name": "app",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 time, default: now",
+                        "name": "at",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "app, channel, at, version, change",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/report": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Record the outcome of one update attempt as a report event in the device's timeline, for updaters other than the agent (which batches its reports through the device events endpoint). Reports carrying a key (or Idempotency-Key header) already seen within the dedup window (-dedup-window, default 72h) are acknowledged but not recorded again.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Report an install result",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Deduplication key, used when the body has no key",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "description": "{\"device_id\", \"channel\", \"from\", \"to\", \"status\" (success|failure|cancelled|deferred|rolled_back|rollback_failed), \"error\", \"duration_ms\", \"started_at\", \"finished_at\", \"key\"}",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "recorded",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "the event could not be recorded; send it again",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/logs": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Accept a batch of algorithm output lines captured by the agent (algo_log.upload), tagged with the app and the version that wrote them. dropped is the number of lines the agent discarded while it could not upload; it is stored as a marker line. Lines longer than 4096 bytes are cut. The body may be sent with Content-Encoding: gzip.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Upload algorithm logs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "gzip",
+                        "name": "Content-Encoding",
+                        "in": "header"
+                    },
+                    {
+                        "description": "{\"device_id\": \"...\", \"lines\": [{\"time\", \"app\", \"version\", \"line\"}], \"dropped\": 0}",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "accepted",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "415": {
+                        "description": "unsupported Content-Encoding",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/reports/cost": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Bytes of artifacts served by this server per campaign and device region, priced at the configured $/GB (1 GB = 10^9 bytes). Traffic served by region mirrors is not included.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "device"
+                ],
+                "summary": "Bandwidth and cost per campaign",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this campaign",
+                        "name": "campaign",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "First date (YYYY-MM-DD)",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last date (YYYY-MM-DD)",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Price per GB, default: the server's -cost-per-gb",
+                        "name": "price_per_gb",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also return the daily rows",
+                        "name": "daily",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "price_per_gb, campaigns (per campaign and region), totals (per campaign), days when daily",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rollouts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Progressive rollouts, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "List progressive rollouts",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this state (running|paused|halted|completed|aborted|superseded)",
+                        "name": "state",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "rollouts",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Offers a channel's new primary release to a growing share of its devices (default 5%, 25%, 100%). Devices are bucketed by a hash of their ID; those outside the current percentage get the baseline, the previous release of the channel. The version defaults to the channel's latest; a newer release from another channel (e.g. beta) is moved into the channel and becomes its latest in the same save. Every minute the server counts the devices that ran (checked in or sent heartbeats with) or tried to install the version during the current step: failed installs, rollbacks, crashes and crashing algorithm health count as failures. More failures than max_failure_rate × max(devices, min_devices) halt the rollout, raise an alert and quarantine the release with the rollout health as evidence (the channel moves back to its previous release that is not recalled); a soak of soak_minutes without that and at least min_devices devices advances it to the next step.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Start a progressive rollout",
+                "parameters": [
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Rollout"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rollouts/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The rollout with its step history; health is evaluated on the spot for the current step.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Get a progressive rollout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rollout ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Rollout"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rollouts/{id}/abort": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Ends the rollout at 0%: devices that do not have the version yet keep getting the baseline until the channel moves to a newer release. Devices that installed it are not rolled back (recall the release for that).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Abort a progressive rollout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rollout ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Rollout"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rollouts/{id}/pause": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stops automatic advancing and halting; the current percentage stays.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Pause a progressive rollout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rollout ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Rollout"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rollouts/{id}/percent": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Manual override: offers the version to the given share of devices and pauses automatic advancing; 100 completes the rollout, 0 holds the version back from devices that do not have it yet. Resuming continues from the first step at or above the percentage.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Set a rollout's percentage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rollout ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Rollout"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rollouts/{id}/resume": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns a paused or halted rollout to automatic control at its current step, with a fresh soak; the health count starts over. A release quarantined by the halt must be restored first.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rollout"
+                ],
+                "summary": "Resume a progressive rollout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rollout ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Rollout"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/shadows": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Shadow deployments, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shadow"
+                ],
+                "summary": "List shadow deployments",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this state (running|promoted|aborted)",
+                        "name": "state",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "shadows",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Runs a candidate release as a passive second process next to the active version on the devices of a channel, optionally limited to device ID globs. The candidate must have been published with shadow_args (e.g. a different port and no actuator output) and be newer than the channel's latest. Agents soak it for soak_minutes (default 24 hours) and upload comparison metrics.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shadow"
+                ],
+                "summary": "Start a shadow deployment",
+                "parameters": [
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ShadowDeployment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/shadows/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The deployment with the latest report of every participating device aggregated: devices reporting and done soaking, shadow process crashes, and per metric the mean on the active and the shadow process with the relative change.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shadow"
+                ],
+                "summary": "Compare a shadow deployment with the active version",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Shadow deployment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ShadowReport"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/shadows/{id}/abort": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stops the shadow deployment; agents stop the shadow process at their next check. The channel's latest release is unchanged.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shadow"
+                ],
+                "summary": "Abort a shadow deployment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Shadow deployment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ShadowDeployment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/shadows/{id}/promote": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Approves the candidate: it becomes the latest release of the deployment's channel and devices update to it as usual (device-group policies still apply). Refused when the channel has moved past the candidate meanwhile.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shadow"
+                ],
+                "summary": "Promote a shadow deployment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Shadow deployment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ShadowDeployment"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/updater/{format}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Minimal polling endpoint for SWUpdate/RAUC/Mender-based fleets: 204 when the device is up to date, otherwise the version and an absolute bundle URL.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "export"
+                ],
+                "summary": "Poll for updates (embedded updaters)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bundle format (swupdate|rauc|mender)",
+                        "name": "format",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Channel (stable|beta), default: stable",
+                        "name": "channel",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Current version on device",
+                        "name": "current",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Device ID, recorded in the device event log",
+                        "name": "device_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "version, format, notes, sha256, download_url",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "204": {
+                        "description": "up to date, no release in channel, or the update is held (freeze, rollout, approval, maintenance window)"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "channel outside token scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/whoami": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Name and role of the authenticated caller (token or admin session).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "auth"
+                ],
+                "summary": "Current caller",
+                "responses": {
+                    "200": {
+                        "description": "name, role, channels, oidc",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/auth/logout": {
+            "get": {
+                "tags": [
+                    "auth"
+                ],
+                "summary": "End the admin session",
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    }
+                }
+            }
+        },
+        "/auth/oidc/callback": {
+            "get": {
+                "description": "Exchange the authorization code, verify the ID token, map IdP groups to a platform role and start an admin session (HttpOnly cookie).",
+                "tags": [
+                    "auth"
+                ],
+                "summary": "OIDC callback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Authorization code",
+                        "name": "code",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "State from the login redirect",
+                        "name": "state",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/auth/oidc/login": {
+            "get": {
+                "description": "Redirect the browser to the identity provider (authorization code flow with PKCE).",
+                "tags": [
+                    "auth"
+                ],
+                "summary": "OIDC login",
+                "responses": {
+                    "302": {
+                        "description": "Found"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "429": {
+                        "description": "too many pending logins",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/download/{version}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Download the algorithm binary for a specific version. Range requests are supported so interrupted downloads can resume; the ETag is the quoted sha256 of the artifact, usable in If-Range.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "release"
+                ],
+                "summary": "Download the algorithm binary",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Version (e.g. 1.1.0)",
+                        "name": "version",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Byte range to resume from, e.g. bytes=1048576-",
+                        "name": "Range",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from the first response; a changed artifact is sent in full",
+                        "name": "If-Range",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "quoted sha256 of the artifact"
+                            }
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "quoted sha256 of the artifact"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "channel outside token scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/hawkbit/{tenant}/controller/v1/{controllerId}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "hawkBit-compatible root resource. Returns the polling interval and, when an update is pending, a deploymentBase link. The tenant is used as the channel (DEFAULT = stable).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "hawkbit"
+                ],
+                "summary": "hawkBit DDI: controller base poll",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant (channel)",
+                        "name": "tenant",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Controller (device) ID",
+                        "name": "controllerId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "config, _links",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/hawkbit/{tenant}/controller/v1/{controllerId}/configData": {
+            "put": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Accept the device's attributes; they are recorded as a device event.",
+                "consumes": [
+                    "application/json"
+                ],
+                "tags": [
+                    "hawkbit"
+                ],
+                "summary": "hawkBit DDI: config data",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant (channel)",
+                        "name": "tenant",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Controller (device) ID",
+                        "name": "controllerId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/hawkbit/{tenant}/controller/v1/{controllerId}/deploymentBase/{actionId}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Describe the pending deployment (one chunk with the algorithm artifact and its sha1/md5/sha256 hashes).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "hawkbit"
+                ],
+                "summary": "hawkBit DDI: deployment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant (channel)",
+                        "name": "tenant",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Controller (device) ID",
+                        "name": "controllerId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Action ID",
+                        "name": "actionId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "id, deployment",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/hawkbit/{tenant}/controller/v1/{controllerId}/deploymentBase/{actionId}/feedback": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Record the device's progress/result for an action in the device event log. A closed + success feedback marks the version as installed.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "hawkbit"
+                ],
+                "summary": "hawkBit DDI: deployment feedback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant (channel)",
+                        "name": "tenant",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Controller (device) ID",
+                        "name": "controllerId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Action ID",
+                        "name": "actionId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/hawkbit/{tenant}/controller/v1/{controllerId}/softwaremodules/{moduleId}/artifacts/{filename}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Download the algorithm artifact of a software module (Range supported); the .MD5SUM suffix returns an md5sum-style line.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "hawkbit"
+                ],
+                "summary": "hawkBit DDI: artifact download",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant (channel)",
+                        "name": "tenant",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Controller (device) ID",
+                        "name": "controllerId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Software module ID (same as action ID)",
+                        "name": "moduleId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "algorithm or algorithm.MD5SUM",
+                        "name": "filename",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Report liveness, whether the server is in read-only mode and, with overload detection enabled, the current load (overloaded, in_flight, check_latency_ms).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Health check",
+                "responses": {
+                    "200": {
+                        "description": "status, read_only, load",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/status": {
+            "get": {
+                "description": "Unauthenticated, cacheable platform status for a customer-facing status page: latest version per public channel (-status-channels), last publish time and service health. Rate-limited per client IP (-status-rate); supports If-None-Match.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "system"
+                ],
+                "summary": "Public status",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.PublicStatus"
+                        }
+                    },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/tuf/{file}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Serve the TUF repository: /tuf/metadata/{root,targets,snapshot,timestamp}.json (plus N.root.json) and /tuf/targets/\u003cversion\u003e/algorithm. Enabled with -tuf-key. Callers whose token covers only some channels get a targets.json listing only those channels' targets, signed with the same version.",
+                "produces": [
+                    "application/json",
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "tuf"
+                ],
+                "summary": "TUF repository",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "metadata/\u003crole\u003e.json or targets/\u003cversion\u003e/algorithm",
+                        "name": "file",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "403": {
+                        "description": "channel outside token scope",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/maintenance/resign": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Progress of the running artifact re-verify / re-sign job, or the result of the last one.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Re-sign job progress",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ResignJob"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "no job has run since the server started",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Start a background job that re-hashes every stored artifact against its release record and, when its signature was made with another key (or force is set), re-signs the digest with the current signing key so agents trusting only the new key can install old releases without re-uploading them. Without a signing key the job only verifies. Hash mismatches are never signed and move the release to the quarantined channel; missing artifacts are reported. Reads are throttled to max_mb_per_sec (default 20, 0 = unthrottled). One job runs at a time; poll GET for progress. Audited.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Re-verify and re-sign stored artifacts",
+                "parameters": [
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ResignJob"
+                        }
+                    },
+                    "400": {
+                        "description": "a job is already running",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "503": {
+                        "description": "server is read-only",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stop the running job after the artifact being read; releases already re-signed keep their new signature.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Cancel the re-sign job",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/controller.ResignJob"
+                        }
+                    },
+                    "400": {
+                        "description": "no job is running",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/profiles": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Profiles captured with POST /api/v1/profiles, newest first, including those still being captured.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List captured profiles",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/controller.Profile"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "profiling is disabled",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Capture a profile of the server in the background and keep it for download: cpu samples for seconds (default 30, at most 300); heap, allocs and goroutine are snapshots. One CPU profile at a time. The last 20 profiles are kept. Audited.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Capture a profile",
+                "parameters": [
+                    {
+                        "description": "{\\",
+                        "name": "body",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/controller.Profile"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "profiling is disabled",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/profiles/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The captured profile in pprof format (gzipped protobuf), for go tool pprof.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Download a profile",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Profile ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "still capturing or failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "controller.Alias": {
+            "type": "object",
+            "properties": {
+                "app": {
+                    "description": "目标版本所属应用，改指不能跨应用",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "updated_by": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.Approval": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string"
+                },
+                "decided_at": {
+                    "type": "string"
+                },
+                "decided_by": {
+                    "type": "string"
+                },
+                "device_id": {
+                    "type": "string"
+                },
+                "from_version": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "policy": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "requested_at": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "pending | approved | rejected | expired",
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.BinaryInfo": {
+            "type": "object",
+            "properties": {
+                "arch": {
+                    "description": "GOARCH 风格：amd64、arm64、arm、386、riscv64……",
+                    "type": "string"
+                },
+                "go": {
+                    "description": "非 Go 程序为空",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/controller.GoBuildInfo"
+                        }
+                    ]
+                },
+                "stripped": {
+                    "description": "没有符号表",
+                    "type": "boolean"
+                },
+                "version_embedded": {
+                    "description": "制品中含有发布的版本号字符串",
+                    "type": "boolean"
+                }
+            }
+        },
+        "controller.BisectStep": {
+            "type": "object",
+            "properties": {
+                "decided_at": {
+                    "type": "string"
+                },
+                "detail": {
+                    "type": "string"
+                },
+                "installed_at": {
+                    "description": "设备首次以该版本检查的时间",
+                    "type": "string"
+                },
+                "result": {
+                    "description": "good | bad | skipped，进行中为空",
+                    "type": "string"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.Bisection": {
+            "type": "object",
+            "properties": {
+                "bad": {
+                    "type": "string"
+                },
+                "candidates": {
+                    "description": "good 与 bad 之间的已发布版本，由旧到新",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "device_id": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "first_bad": {
+                    "type": "string"
+                },
+                "good": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last_good": {
+                    "description": "LastGood 是收敛时最后一个判为正常的版本；中间有跳过的版本时二者之间可能不止一个版本。",
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "soak_minutes": {
+                    "type": "integer"
+                },
+                "state": {
+                    "description": "running | done | aborted",
+                    "type": "string"
+                },
+                "steps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/controller.BisectStep"
+                    }
+                }
+            }
+        },
+        "controller.ChannelStatus": {
+            "type": "object",
+            "properties": {
+                "published_at": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.ConsistencyReport": {
+            "type": "object",
+            "properties": {
+                "artifacts": {
+                    "description": "无法枚举制品的存储为 -1",
+                    "type": "integer"
+                },
+                "issues": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/controller.Inconsistency"
+                    }
+                },
+                "releases": {
+                    "type": "integer"
+                },
+                "repaired": {
+                    "type": "integer"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.DeviceDataUsage": {
+            "type": "object",
+            "properties": {
+                "cap_bytes": {
+                    "description": "未配置上限时省略",
+                    "type": "integer"
+                },
+                "capped": {
+                    "type": "boolean"
+                },
+                "channel": {
+                    "type": "string"
+                },
+                "device_id": {
+                    "type": "string"
+                },
+                "download_bytes": {
+                    "type": "integer"
+                },
+                "period_start": {
+                    "description": "计费周期开始日期",
+                    "type": "string"
+                },
+                "reported": {
+                    "type": "string"
+                },
+                "upload_bytes": {
+                    "type": "integer"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.Freeze": {
+            "type": "object",
+            "properties": {
+                "app": {
+                    "description": "project：应用名，为空即主应用",
+                    "type": "string"
+                },
+                "channels": {
+                    "description": "channel：渠道通配",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "devices": {
+                    "description": "group：设备 ID 通配",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "ends_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "scope": {
+                    "description": "global | project | channel | group",
+                    "type": "string"
+                },
+                "starts_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.GoBuildInfo": {
+            "type": "object",
+            "properties": {
+                "go_version": {
+                    "type": "string"
+                },
+                "modified": {
+                    "description": "vcs.modified：构建时工作区有未提交的修改",
+                    "type": "boolean"
+                },
+                "module": {
+                    "description": "main 模块",
+                    "type": "string"
+                },
+                "path": {
+                    "description": "main 包路径",
+                    "type": "string"
+                },
+                "revision": {
+                    "description": "vcs.revision",
+                    "type": "string"
+                },
+                "version": {
+                    "description": "main 模块版本，本地构建为 (devel)",
+                    "type": "string"
+                }
+            }
+        },
+        "controller.Inconsistency": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "type": "string"
+                },
+                "detail": {
+                    "type": "string"
+                },
+                "devices": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "fixable": {
+                    "type": "boolean"
+                },
+                "fixed": {
+                    "type": "boolean"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.PublicStatus": {
+            "type": "object",
+            "properties": {
+                "channels": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/controller.ChannelStatus"
+                    }
+                },
+                "last_publish": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "ok | degraded",
+                    "type": "string"
+                }
+            }
+        },
+        "controller.Quarantine": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "channel": {
+                    "description": "隔离前所在的渠道，恢复时的缺省渠道",
+                    "type": "string"
+                },
+                "detail": {
+                    "type": "string"
+                },
+                "evidence": {
+                    "description": "触发依据的查询地址（相对路径）",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "trigger": {
+                    "description": "integrity_check | consistency_check | rollout_halt",
+                    "type": "string"
+                }
+            }
+        },
+        "controller.Recall": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "by": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.Release": {
+            "type": "object",
+            "properties": {
+                "app": {
+                    "type": "string",
+                    "description": "所属应用，为空即主应用（见 apps.go）"
+                },
+                "artifact_type": {
+                    "description": "ArtifactType 为 oci 时制品是容器镜像归档（docker save 或 OCI image layout 的 tar），agent 导入后以\n容器运行，ImageID 是其中镜像的 ID（config 摘要），见 internal/oci/archive.go；为空即 binary。",
+                    "type": "string"
+                },
+                "binary": {
+                    "description": "Binary 是发布时从 ELF 制品中提取的架构与构建信息（见 binmeta.go），其它制品与旧记录为空。",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/controller.BinaryInfo"
+                        }
+                    ]
+                },
+                "campaign": {
+                    "description": "下载流量计入的发布活动，为空时即版本号（见 bandwidth.go）",
+                    "type": "string"
+                },
+                "channel": {
+                    "description": "e.g. \"stable\", \"beta\"",
+                    "type": "string"
+                },
+                "cosign_bundle": {
+                    "description": "CosignBundle 是 CI 用 cosign sign-blob --bundle 生成的签名包，agent 安装前可再次校验。",
+                    "type": "object"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "format": {
+                    "description": "制品格式：binary（缺省）| deb | rpm",
+                    "type": "string"
+                },
+                "image_id": {
+                    "type": "string"
+                },
+                "key_id": {
+                    "type": "string"
+                },
+                "launch": {
+                    "description": "Launch 是启动模板（如 python3 {dir}/main.py --model {dir}/model.onnx），非空时制品是 tar.gz 或 zip 包，\nagent 解压后按模板直接启动解释器，见 internal/launch。",
+                    "type": "string"
+                },
+                "mandatory": {
+                    "description": "Mandatory 的版本在设备端不受电量门限限制（如安全修复）。",
+                    "type": "boolean"
+                },
+                "notes": {
+                    "type": "string"
+                },
+                "package": {
+                    "description": "Package 非空（tar.gz | zip）时制品是带 manifest.json 的多文件包，Launch 取自清单的入口，\nagent 解压后按清单逐个校验文件，见 internal/bundle。",
+                    "type": "string"
+                },
+                "quarantine": {
+                    "description": "Quarantine 非空时版本已被完整性检查自动移入 quarantined 渠道，不再下发（见 quarantine.go）。",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/controller.Quarantine"
+                        }
+                    ]
+                },
+                "recall": {
+                    "description": "Recall 非空时版本已被召回，运行它的设备回滚到上一个版本（见 recall.go）。",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/controller.Recall"
+                        }
+                    ]
+                },
+                "release_notes": {
+                    "description": "ReleaseNotes 是结构化的发布说明（见 releasenotes.go），旧记录为空。",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/controller.ReleaseNotes"
+                        }
+                    ]
+                },
+                "sha256": {
+                    "type": "string"
+                },
+                "shadow_args": {
+                    "description": "ShadowArgs 是影子运行时追加的命令行参数（换端口、不输出执行器指令，见 shadow.go），为空的版本不能影子部署。",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "signature": {
+                    "description": "base64，对 sha256 摘要的分离签名",
+                    "type": "string"
+                },
+                "url": {
+                    "description": "relative: /download/\u003cversion\u003e",
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                },
+                "size": {
+                    "description": "制品字节数，发布时记录；旧记录为 0",
+                    "type": "integer"
+                }
+            }
+        },
+        "controller.ReleaseNotes": {
+            "type": "object",
+            "properties": {
+                "breaking": {
+                    "type": "boolean"
+                },
+                "issues": {
+                    "description": "关联的问题单 ID，如 JIRA-123、#45",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "safety": {
+                    "description": "none | low | high | experimental，缺省 none",
+                    "type": "string"
+                },
+                "summary": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.Rollout": {
+            "type": "object",
+            "properties": {
+                "baseline": {
+                    "description": "不在比例内的设备得到的版本，为空时不下发",
+                    "type": "string"
+                },
+                "channel": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "health": {
+                    "description": "Health 是最近一次自动评估的结果。",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/controller.RolloutHealth"
+                        }
+                    ]
+                },
+                "history": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/controller.RolloutChange"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "max_failure_rate": {
+                    "type": "number"
+                },
+                "min_devices": {
+                    "type": "integer"
+                },
+                "percent": {
+                    "description": "当前比例，halted 与 aborted 为 0",
+                    "type": "integer"
+                },
+                "soak_minutes": {
+                    "description": "SoakMinutes 是每一级至少观察的时长，MaxFailureRate 与 MinDevices 决定能否进入下一级。",
+                    "type": "integer"
+                },
+                "state": {
+                    "description": "running | paused | halted | completed | aborted | superseded",
+                    "type": "string"
+                },
+                "step": {
+                    "description": "当前（或恢复时继续的）一级在 Steps 中的下标",
+                    "type": "integer"
+                },
+                "step_started_at": {
+                    "type": "string"
+                },
+                "steps": {
+                    "description": "逐级的比例，最后一级为 100",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "version": {
+                    "description": "目标版本，渠道最新版本",
+                    "type": "string"
+                }
+            }
+        },
+        "controller.RolloutChange": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "by": {
+                    "description": "后台的自动变化为 system",
+                    "type": "string"
+                },
+                "percent": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.RolloutHealth": {
+            "type": "object",
+            "properties": {
+                "devices": {
+                    "type": "integer"
+                },
+                "evaluated_at": {
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "failed_devices": {
+                    "description": "FailedDevices 列出部分失败设备及原因，便于排查。",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "failure_rate": {
+                    "type": "number"
+                },
+                "since": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.ShadowDeployment": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "description": "在该渠道的设备上影子运行，提升时切换其 latest",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "devices": {
+                    "description": "Devices 限定参与的设备（path.Match 通配），为空时渠道内所有设备参与。",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "finished_by": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "soak_minutes": {
+                    "description": "每台设备影子运行的时长",
+                    "type": "integer"
+                },
+                "state": {
+                    "description": "running | promoted | aborted",
+                    "type": "string"
+                },
+                "version": {
+                    "description": "候选版本",
+                    "type": "string"
+                }
+            }
+        },
+        "controller.ShadowMetric": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "type": "number"
+                },
+                "delta_pct": {
+                    "description": "DeltaPct 是影子相对现役的变化百分比，现役均值为 0 时省略。",
+                    "type": "number"
+                },
+                "devices": {
+                    "description": "两个进程都上报了该指标的设备数",
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "shadow": {
+                    "type": "number"
+                }
+            }
+        },
+        "controller.ShadowReport": {
+            "type": "object",
+            "properties": {
+                "channel": {
+                    "description": "在该渠道的设备上影子运行，提升时切换其 latest",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "devices": {
+                    "description": "Devices 限定参与的设备（path.Match 通配），为空时渠道内所有设备参与。",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "finished_by": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "metrics": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/controller.ShadowMetric"
+                    }
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "reporting": {
+                    "description": "上报过对比指标的设备数",
+                    "type": "integer"
+                },
+                "shadow_crashes": {
+                    "description": "影子进程意外退出的次数合计",
+                    "type": "integer"
+                },
+                "soak_minutes": {
+                    "description": "每台设备影子运行的时长",
+                    "type": "integer"
+                },
+                "soaked": {
+                    "description": "已跑满 soak 时长的设备数",
+                    "type": "integer"
+                },
+                "state": {
+                    "description": "running | promoted | aborted",
+                    "type": "string"
+                },
+                "version": {
+                    "description": "候选版本",
+                    "type": "string"
+                }
+            }
+        },
+        "controller.UpdatePolicy": {
+            "type": "object",
+            "properties": {
+                "channels": {
+                    "description": "渠道通配；为空匹配所有渠道",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "devices": {
+                    "description": "设备 ID 通配；为空匹配所有设备",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mode": {
+                    "description": "auto | patch | approval",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "controller.ResignJob": {
+            "type": "object",
+            "properties": {
+                "bytes_read": {
+                    "type": "integer"
+                },
+                "checked": {
+                    "type": "integer"
+                },
+                "current": {
+                    "type": "string",
+                    "description": "正在读取的版本"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "force": {
+                    "type": "boolean"
+                },
+                "key_id": {
+                    "type": "string",
+                    "description": "KeyID 是当前签名密钥，未配置签名密钥时为空，任务只做校验。"
+                },
+                "max_mb_per_sec": {
+                    "type": "integer",
+                    "description": "0 为不限速"
+                },
+                "mismatched": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "description": "Mismatched 与 Missing 需要人工处理，见 /api/v1/doctor。"
+                },
+                "missing": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "resigned": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "started_by": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string",
+                    "description": "running | done | cancelled"
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "unchanged": {
+                    "type": "integer",
+                    "description": "校验一致，签名已是当前密钥或未配置签名密钥"
+                }
+            }
+        },
+        "controller.MaintenanceWindow": {
+            "type": "object",
+            "properties": {
+                "device_id": {
+                    "type": "string"
+                },
+                "timezone": {
+                    "description": "IANA 名称或 UTC 偏移（+08:00），为空即 UTC",
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "updated_by": {
+                    "type": "string"
+                },
+                "windows": {
+                    "description": "HH:MM-HH:MM，结束早于开始时跨午夜",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "controller.Profile": {
+            "type": "object",
+            "properties": {
+                "by": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "seconds": {
+                    "type": "integer",
+                    "description": "只对 cpu 有意义"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string",
+                    "description": "capturing | done | failed"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "\"Bearer \u003ctoken\u003e\" when the server runs with -auth-tokens; the admin UI may use an OIDC session cookie instead. With -client-ca, devices may authenticate with a TLS client certificate instead of a token.",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
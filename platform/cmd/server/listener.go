@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart 是 systemd socket activation 传入的第一个文件描述符（SD_LISTEN_FDS_START）。
+const listenFdsStart = 3
+
+// newListener resolves the listen spec:
+//
+//	unix:///run/ota.sock   filesystem socket, chmod'ed to socketMode
+//	unix://@ota            Linux abstract socket
+//	systemd:               socket handed over by systemd (LISTEN_FDS)
+//	"" (empty)             plain TCP on addr/network
+//
+// A systemd-provided socket always wins so unit files need no extra flags.
+func newListener(spec, network, addr string, socketMode os.FileMode) (net.Listener, func(), error) {
+	noop := func() {}
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, noop, err
+	}
+	if spec == "systemd:" {
+		return nil, noop, errors.New("-listen systemd: given but no socket passed by systemd")
+	}
+
+	if path, ok := strings.CutPrefix(spec, "unix://"); ok {
+		if path == "" {
+			return nil, noop, errors.New("empty unix socket path")
+		}
+		if strings.HasPrefix(path, "@") {
+			ln, err := net.Listen("unix", path)
+			return ln, noop, err
+		}
+		// 清理上次异常退出残留的 socket 文件（仅当它确实是 socket）
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, noop, err
+		}
+		ln.(*net.UnixListener).SetUnlinkOnClose(true)
+		if err := os.Chmod(path, socketMode); err != nil {
+			_ = ln.Close()
+			return nil, noop, err
+		}
+		return ln, func() { _ = os.Remove(path) }, nil
+	}
+
+	if spec != "" {
+		if hostport, ok := strings.CutPrefix(spec, "tcp://"); ok {
+			addr = hostport
+		} else {
+			return nil, noop, errors.New("unsupported -listen spec: " + spec)
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	return ln, noop, err
+}
+
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
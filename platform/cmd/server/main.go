@@ -2,44 +2,374 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"flag"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+	// 设备维护时段按 IANA 时区计算，容器等精简主机上可能没有 zoneinfo
+	_ "time/tzdata"
 
 	"github.com/von0000/dronealgo-ota/platform/cmd/server/docs"
 
+	"github.com/von0000/dronealgo-ota/internal/clock"
+	"github.com/von0000/dronealgo-ota/internal/cosign"
 	"github.com/von0000/dronealgo-ota/platform/cmd/server/controller"
 	"github.com/von0000/dronealgo-ota/platform/cmd/server/router"
 )
 
-var addr = flag.String("addr", "127.0.0.1:1573", "server addr")
+var (
+	addr    = flag.String("addr", "127.0.0.1:1573", "server addr, e.g. [::]:1573 or :1573 for dual-stack")
+	network = flag.String("network", "tcp", "listen network: tcp (dual-stack) | tcp4 | tcp6")
+	listen  = flag.String("listen", "", "listener spec overriding -addr: tcp://host:port | unix:///run/ota.sock | unix://@name | systemd:")
+	sockMod = flag.Uint("socket-mode", 0o660, "permission bits of the unix socket file")
+	dataDir = flag.String("data-dir", "../../data", "directory holding releases.json")
+	artDir  = flag.String("artifact-dir", "../../artifacts", "directory holding uploaded artifacts")
+	backend = flag.String("store", "file", "metadata backend: file | memory")
+	artBack = flag.String("artifact-store", "fs", "artifact backend: fs | memory")
+	seed    = flag.String("seed", "", "releases.json-shaped fixture loaded into the memory store")
+	doInit  = flag.Bool("init", false, "scaffold data/artifact directories and an empty store, then exit")
+	doCheck = flag.Bool("doctor", false, "check releases, artifacts and device events for inconsistencies, print the report and exit")
+	doRepar = flag.Bool("repair", false, "with -doctor, also repair the fixable inconsistencies")
+	flushIv = flag.Duration("store-flush-interval", 0, "batch non-critical store writes at this interval (0 = write-through)")
+	fsyncPo = flag.String("store-fsync", "always", "fsync policy for store and artifact writes: always | never")
+	evRetnt = flag.Duration("event-retention", 30*24*time.Hour, "how long device events are kept")
+	logRetn = flag.Duration("algo-log-retention", 7*24*time.Hour, "how long algorithm logs uploaded by devices are kept")
+	dedupWn = flag.Duration("dedup-window", 72*time.Hour, "device event keys seen within this window are not recorded again (retransmitted reports and heartbeats)")
+	typRetn = flag.String("retention-by-type", "", "shorter retention per event type, e.g. heartbeat=72h,check=168h")
+	webhook = flag.String("alert-webhook", "", "URL receiving JSON alerts (e.g. disk full)")
+	proxies = flag.String("trusted-proxies", "", "comma-separated proxy IPs/CIDRs allowed to set X-Forwarded-* headers")
+	raucCrt = flag.String("rauc-cert", "", "certificate used to sign exported RAUC bundles")
+	raucKey = flag.String("rauc-key", "", "private key used to sign exported RAUC bundles")
+	tufKeyF = flag.String("tuf-key", "", "file holding the base64 ed25519 private key; enables the TUF repository under /tuf/")
+	tufDir  = flag.String("tuf-dir", "", "also write TUF metadata to this directory")
+	tufTgtK = flag.String("tuf-targets-key", "", "file holding the base64 ed25519 private key of the TUF targets role (default -tuf-key)")
+	tufSnpK = flag.String("tuf-snapshot-key", "", "file holding the base64 ed25519 private key of the TUF snapshot role (default -tuf-key)")
+	tufTsK  = flag.String("tuf-timestamp-key", "", "file holding the base64 ed25519 private key of the TUF timestamp role (default -tuf-key)")
+	signKey = flag.String("signing-key", "", "file holding the base64 ed25519 private key; signs artifact digests at publish time for agents with artifact_public_key")
+	logKeyF = flag.String("log-key", "", "file holding the base64 ed25519 private key; enables the release transparency log under /api/v1/log/")
+	tlsCert = flag.String("tls-cert", "", "serve HTTPS with this certificate (PEM); with -tls-key")
+	tlsKeyF = flag.String("tls-key", "", "private key (PEM) of -tls-cert")
+	clntCAs = flag.String("client-ca", "", "CA bundle (PEM) verifying device client certificates; enables mutual TLS device authentication (needs -tls-cert)")
+	crtIdnt = flag.String("client-cert-identity", "cn", "certificate field holding the device ID: cn | san (first DNS, URI or email SAN)")
+	crtChan = flag.String("client-cert-channels", "", "comma-separated channels (globs) devices authenticated by certificate may access; empty allows all")
+	reqCert = flag.Bool("require-client-cert", false, "reject device requests without a verified client certificate (admin tokens and sessions still work)")
+	tokensF = flag.String("auth-tokens", "", "JSON file of API tokens with roles and channel scopes; empty disables auth")
+	bgLimit = flag.Duration("breakglass-max", time.Hour, "longest lifetime of a break-glass temporary admin token")
+	oidcIss = flag.String("oidc-issuer", "", "OIDC issuer URL (Google, Keycloak realm ...) enabling SSO login for the admin UI")
+	oidcCli = flag.String("oidc-client-id", "", "OIDC client ID (client secret from $OIDC_CLIENT_SECRET)")
+	oidcRed = flag.String("oidc-redirect-url", "", "OIDC redirect URL; default <external URL>/auth/oidc/callback")
+	oidcGrp = flag.String("oidc-groups-claim", "groups", "ID token claim listing the user's groups")
+	oidcMap = flag.String("oidc-roles", "", "IdP group (or email:<verified address>) to role mapping, e.g. ota-admins=admin,oncall=breakglass,partner=device:partner-*,email:alice@example.com=admin")
+	oidcTTL = flag.Duration("oidc-session-ttl", 8*time.Hour, "lifetime of an admin UI login session")
+	pubChan = flag.String("status-channels", "stable", "comma-separated channels (globs) shown on the public /status endpoint")
+	stRate  = flag.Int("status-rate", 60, "requests per minute per client IP allowed on /status")
+	dropFld = flag.String("telemetry-drop", "", "comma-separated device event fields never stored, e.g. gps,hostname,device_ips")
+	anonIPs = flag.Bool("anonymize-ip", false, "store IP addresses truncated to /24 (IPv4) or /48 (IPv6)")
+	gpsDecs = flag.Int("gps-decimals", 0, "round stored GPS coordinates to this many decimals (0 keeps full precision)")
+	locRetn = flag.Duration("location-retention", 0, "erase GPS data from device events older than this (0 keeps it as long as the event)")
+	ociRepo = flag.String("oci-mirror", "", "push published artifacts to this OCI repository, e.g. registry.example.com/dronealgo/algorithm")
+	ociUser = flag.String("oci-username", "", "registry username for -oci-mirror (password from $OCI_PASSWORD)")
+	replTo  = flag.String("replicate", "", "comma-separated replicas in other regions receiving artifacts and metadata: s3://bucket/prefix?region=eu-west-1 (credentials from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY, $AWS_SESSION_TOKEN; add &endpoint=URL for S3-compatible stores) or the URL of a platform instance started with -accept-replication (admin token from $REPLICA_TOKEN)")
+	replAcc = flag.Bool("accept-replication", false, "run as a replica: accept artifacts and the release index pushed by another region's -replicate (do not publish here)")
+	cosKey  = flag.String("cosign-key", "", "cosign public key (PEM) verifying the cosign_bundle uploaded with a release")
+	cosRoot = flag.String("cosign-roots", "", "Fulcio root certificates (PEM) for keyless cosign bundles")
+	cosIdnt = flag.String("cosign-identity", "", "required certificate identity (SAN) for keyless cosign bundles")
+	cosIssr = flag.String("cosign-issuer", "", "required OIDC issuer for keyless cosign bundles")
+	rekorPk = flag.String("rekor-key", "", "Rekor public key (PEM); when set the bundle must carry a valid transparency-log entry")
+	reqCosn = flag.Bool("require-cosign", false, "reject releases published without a valid cosign_bundle")
+	regMirr = flag.String("region-mirrors", "", "region- or site-pinned artifact mirrors serving <url>/download/<version>, e.g. eu=https://eu.example.com/ota,eu/hangar-3=http://10.3.0.5:1573/api/v1")
+	regNets = flag.String("region-networks", "", "client networks mapped to a region for devices not reporting one, e.g. 10.1.0.0/16=eu,172.16.0.0/12=apac")
+	artArch = flag.String("artifact-arch", "", "architecture ELF binary releases must have when publish does not declare arch (GOARCH name, e.g. arm64); empty skips the check")
+	costGB  = flag.Float64("cost-per-gb", 0, "price per GB (10^9 bytes) of artifact download traffic in the campaign cost report")
+	autoDia = flag.Bool("auto-diagnostics", true, "ask devices whose algorithm keeps crashing to upload diagnostics (at most hourly per device)")
+	instSLO = flag.String("install-slo", controller.DefaultInstallSLO, "per-phase install duration SLOs; devices whose recent installs mostly exceed them are flagged in fleet health (empty disables)")
+	apprTTL = flag.Duration("approval-ttl", 7*24*time.Hour, "pending install approvals older than this expire")
+	msgCatF = flag.String("message-catalog", "", "JSON file of check response message translations (locale -> message_id -> text), added to the built-in en and zh")
+	zipEncs = flag.String("compress", "gzip", "response encodings offered to clients, in preference order (supported: gzip); empty disables compression")
+	zipMin  = flag.Int("compress-min-size", 1024, "responses smaller than this many bytes are sent uncompressed")
+	zipType = flag.String("compress-types", "application/json", "comma-separated content types (globs) eligible for compression")
+	ovlFlgt = flag.Int("overload-inflight", 512, "requests in flight (downloads excluded) above which the server is overloaded; 0 disables the check")
+	ovlLate = flag.Duration("overload-latency", 2*time.Second, "average /check latency above which the server is overloaded; 0 disables the check")
+	ovlTTL  = flag.Duration("overload-check-cache", 15*time.Minute, "while overloaded, answer /check with the device's previous decision if it is this recent")
+	ovlWait = flag.Duration("overload-retry-after", 30*time.Second, "Retry-After suggested for listings deferred while overloaded (plus jitter)")
+	pprofOn = flag.Bool("pprof", false, "serve /debug/pprof/ and the profile capture API to admins (needs -auth-tokens)")
+	tmScale = flag.Float64("time-scale", 1, "run the platform clock this many times faster than real time (fleet simulation)")
+)
 
 // @title DroneAlgo-OTA API
 // @version 1.0
 // @description OTA platform for drone avoidance algorithms.
 // @BasePath /
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description "Bearer <token>" when the server runs with -auth-tokens; the admin UI may use an OIDC session cookie instead. With -client-ca, devices may authenticate with a TLS client certificate instead of a token.
 func main() {
+	flag.Parse()
+
+	// 仅信任配置的反向代理：直连时忽略 X-Forwarded-*，代理后取真实客户端 IP
+	var trusted []string
+	if *proxies != "" {
+		trusted = strings.Split(*proxies, ",")
+	}
+	opts := controller.Options{
+		DataDir:          *dataDir,
+		ArtifactDir:      *artDir,
+		FlushInterval:    *flushIv,
+		Fsync:            *fsyncPo,
+		EventRetention:   *evRetnt,
+		AlgoLogRetention: *logRetn,
+		AlertWebhook:     *webhook,
+		TrustedProxies:   trusted,
+		BreakGlassMax:    *bgLimit,
+		StatusRate:       *stRate,
+		RAUCCert:         *raucCrt,
+		RAUCKey:          *raucKey,
+	}
+	opts.Telemetry = controller.TelemetryPolicy{
+		AnonymizeIP:       *anonIPs,
+		GPSDecimals:       *gpsDecs,
+		LocationRetention: *locRetn,
+	}
+	opts.ApprovalTTL = *apprTTL
+	opts.DedupWindow = *dedupWn
+	opts.CostPerGB = *costGB
+	opts.ArtifactArch = *artArch
+	opts.AutoDiagnostics = *autoDia
+	opts.Profiling = *pprofOn
+	opts.Overload = controller.OverloadConfig{MaxInFlight: *ovlFlgt, MaxCheckLatency: *ovlLate, CheckCacheTTL: *ovlTTL, RetryAfter: *ovlWait}
+	if *regMirr != "" {
+		m, err := controller.ParseRegionMirrors(*regMirr)
+		if err != nil {
+			log.Fatalf("region mirrors: %v", err)
+		}
+		opts.RegionMirrors = m
+	}
+	if *regNets != "" {
+		n, err := controller.ParseRegionNetworks(*regNets)
+		if err != nil {
+			log.Fatalf("region networks: %v", err)
+		}
+		opts.RegionNetworks = n
+	}
+	if *instSLO != "" {
+		slo, err := controller.ParseInstallSLO(*instSLO)
+		if err != nil {
+			log.Fatalf("install slo: %v", err)
+		}
+		opts.InstallSLO = slo
+	}
+	if *typRetn != "" {
+		r, err := controller.ParseRetention(*typRetn)
+		if err != nil {
+			log.Fatalf("retention: %v", err)
+		}
+		opts.RetentionByType = r
+	}
+	if *dropFld != "" {
+		opts.Telemetry.DropFields = strings.Split(*dropFld, ",")
+	}
+	if *pubChan != "" {
+		opts.PublicChannels = strings.Split(*pubChan, ",")
+	}
+	if *tufKeyF != "" {
+		opts.TUFKey, opts.TUFDir = loadKey("tuf key", *tufKeyF), *tufDir
+		opts.TUFRoleKeys = map[string]ed25519.PrivateKey{}
+		for role, fp := range map[string]string{"targets": *tufTgtK, "snapshot": *tufSnpK, "timestamp": *tufTsK} {
+			if fp != "" {
+				opts.TUFRoleKeys[role] = loadKey("tuf "+role+" key", fp)
+			}
+		}
+	} else if *tufTgtK != "" || *tufSnpK != "" || *tufTsK != "" {
+		log.Fatal("TUF role keys need -tuf-key")
+	}
+	if *logKeyF != "" {
+		opts.LogKey = loadKey("log key", *logKeyF)
+	}
+	if *signKey != "" {
+		opts.Signer = controller.NewEd25519Signer(loadKey("signing key", *signKey))
+	}
+	if *msgCatF != "" {
+		cat, err := controller.LoadMessageCatalog(*msgCatF)
+		if err != nil {
+			log.Fatalf("message catalog: %v", err)
+		}
+		opts.Messages = cat
+	}
+	comp, err := controller.ParseCompression(*zipEncs, *zipType, *zipMin)
+	if err != nil {
+		log.Fatalf("compression: %v", err)
+	}
+	opts.Compression = comp
+	if *tokensF != "" {
+		tokens, err := controller.LoadTokens(*tokensF)
+		if err != nil {
+			log.Fatalf("auth tokens: %v", err)
+		}
+		opts.Tokens = tokens
+	}
+	// 没有令牌时所有调用方都是匿名管理员，剖析端点会对任何人开放
+	if *pprofOn && *tokensF == "" {
+		log.Fatal("-pprof needs -auth-tokens")
+	}
+	var tlsCfg *tls.Config
+	if *tlsCert != "" || *tlsKeyF != "" {
+		if tlsCfg, err = newTLSConfig(*tlsCert, *tlsKeyF, *clntCAs); err != nil {
+			log.Fatalf("tls: %v", err)
+		}
+	}
+	if *clntCAs != "" {
+		if tlsCfg == nil {
+			log.Fatal("-client-ca needs -tls-cert and -tls-key")
+		}
+		opts.ClientCerts = &controller.ClientCertPolicy{Identity: *crtIdnt, Required: *reqCert}
+		if *crtChan != "" {
+			opts.ClientCerts.Channels = strings.Split(*crtChan, ",")
+		}
+	} else if *reqCert {
+		log.Fatal("-require-client-cert needs -client-ca")
+	}
+	if *oidcIss != "" {
+		roles, err := controller.ParseRoleMap(*oidcMap)
+		if err != nil {
+			log.Fatalf("oidc roles: %v", err)
+		}
+		opts.OIDC = &controller.OIDCConfig{
+			Issuer:       *oidcIss,
+			ClientID:     *oidcCli,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  *oidcRed,
+			GroupsClaim:  *oidcGrp,
+			Roles:        roles,
+			SessionTTL:   *oidcTTL,
+		}
+	}
+	if *ociRepo != "" {
+		m, err := controller.NewOCIMirror(*ociRepo, *ociUser, os.Getenv("OCI_PASSWORD"))
+		if err != nil {
+			log.Fatalf("oci mirror: %v", err)
+		}
+		opts.Mirror = m
+	}
+	for _, target := range strings.Split(*replTo, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		var r controller.Replica
+		var err error
+		if strings.HasPrefix(target, "s3://") {
+			r, err = controller.NewS3Replica(target, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"))
+		} else {
+			r, err = controller.NewPlatformReplica(target, os.Getenv("REPLICA_TOKEN"))
+		}
+		if err != nil {
+			log.Fatalf("replicate: %v", err)
+		}
+		opts.Replicas = append(opts.Replicas, r)
+	}
+	opts.AcceptReplication = *replAcc
+	if *cosKey != "" || *cosRoot != "" {
+		v, err := loadCosign()
+		if err != nil {
+			log.Fatalf("cosign: %v", err)
+		}
+		opts.Cosign, opts.RequireCosign = v, *reqCosn
+	} else if *reqCosn {
+		log.Fatal("-require-cosign needs -cosign-key or -cosign-roots")
+	}
+	if *tmScale > 1 {
+		opts.Clock = clock.Scaled(*tmScale)
+		log.Printf("simulated time: clock runs at %gx", *tmScale)
+	}
+	switch *backend {
+	case "file":
+	case "memory":
+		var fixture []byte
+		if *seed != "" {
+			b, err := os.ReadFile(*seed)
+			if err != nil {
+				log.Fatalf("seed: %v", err)
+			}
+			fixture = b
+		}
+		st, err := controller.NewMemoryStorage(fixture)
+		if err != nil {
+			log.Fatalf("memory store: %v", err)
+		}
+		opts.Storage = st
+		opts.Events = controller.NewMemoryEventLog(*evRetnt)
+	default:
+		log.Fatalf("unknown -store %q (want file or memory)", *backend)
+	}
+	switch *artBack {
+	case "fs":
+	case "memory":
+		opts.Artifacts = controller.NewMemoryArtifactStore()
+	default:
+		log.Fatalf("unknown -artifact-store %q (want fs or memory)", *artBack)
+	}
+	p, err := controller.NewPlatform(opts)
+	if err != nil {
+		log.Fatalf("platform: %v", err)
+	}
+	if *doInit {
+		if err := p.Scaffold(); err != nil {
+			log.Fatalf("init: %v", err)
+		}
+		log.Printf("initialized data dir %s and artifact dir %s", *dataDir, *artDir)
+		return
+	}
+	if *doCheck {
+		if err := p.Init(); err != nil {
+			log.Fatalf("load store: %v", err)
+		}
+		rep, err := p.CheckConsistency(*doRepar, "cli")
+		if err != nil {
+			log.Fatalf("doctor: %v", err)
+		}
+		fmt.Print(rep)
+		// 仍有未修复的问题时以 1 退出，便于在部署流水线中使用
+		for _, is := range rep.Issues {
+			if !is.Fixed {
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
 	gin.SetMode(gin.ReleaseMode)
 	h2s := &http2.Server{}
 	g := gin.Default()
 
 	docs.SwaggerInfo.BasePath = "/"
 
-	// 进程启动时尝试加载一次 store（见 file.go 中的 Export 函数）
-	err := controller.InitStore()
-	if err != nil {
-		return
+	if err := g.SetTrustedProxies(trusted); err != nil {
+		log.Fatalf("trusted proxies: %v", err)
 	}
 
-	router.SetRouters(g)
+	// 进程启动时加载一次 store，首次部署时自动初始化
+	if err := p.Init(); err != nil {
+		log.Fatalf("load store: %v", err)
+	}
+
+	router.SetRouters(g, p)
+
+	stopBg := make(chan struct{})
+	p.Start(stopBg)
 
 	s := &http.Server{
 		Addr:           *addr,
@@ -47,10 +377,25 @@ func main() {
 		WriteTimeout:   15 * time.Second,
 		ReadTimeout:    15 * time.Second,
 		MaxHeaderBytes: 100 << 20,
+		TLSConfig:      tlsCfg,
 	}
+	if tlsCfg != nil {
+		if err := http2.ConfigureServer(s, h2s); err != nil {
+			log.Fatalf("http2: %v", err)
+		}
+	}
+	ln, cleanup, err := newListener(*listen, *network, *addr, os.FileMode(*sockMod))
+	if err != nil {
+		log.Fatalf("listen error: %v", err)
+	}
+	defer cleanup()
 	go func() {
-		log.Printf("server listening on %s", *addr)
-		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("server listening on %s (%s)", ln.Addr(), ln.Addr().Network())
+		serve := s.Serve
+		if tlsCfg != nil {
+			serve = func(ln net.Listener) error { return s.ServeTLS(ln, "", "") }
+		}
+		if err := serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("listen error: %v", err)
 		}
 	}()
@@ -66,5 +411,47 @@ func main() {
 		log.Printf("server shutdown: %v", err)
 		_ = s.Close()
 	}
+	close(stopBg)
+	if err := p.Flush(); err != nil {
+		log.Printf("final store flush: %v", err)
+	}
 	log.Println("server exited")
 }
+
+// loadCosign builds the publish-time cosign trust policy from flags.
+func loadCosign() (*cosign.Verifier, error) {
+	v := &cosign.Verifier{Identity: *cosIdnt, Issuer: *cosIssr}
+	var err error
+	if *cosKey != "" {
+		if v.Key, err = cosign.LoadPublicKey(*cosKey); err != nil {
+			return nil, err
+		}
+	}
+	if *cosRoot != "" {
+		if v.Roots, err = cosign.LoadRoots(*cosRoot); err != nil {
+			return nil, err
+		}
+	}
+	if *rekorPk != "" {
+		if v.Rekor, err = cosign.LoadPublicKey(*rekorPk); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.Check(); err != nil {
+		return nil, fmt.Errorf("-cosign-roots needs -rekor-key: %w", err)
+	}
+	return v, nil
+}
+
+// loadKey reads a base64 ed25519 private key (as printed by cfgsign -gen-key).
+func loadKey(what, fp string) ed25519.PrivateKey {
+	raw, err := os.ReadFile(fp)
+	if err != nil {
+		log.Fatalf("%s: %v", what, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		log.Fatalf("%s: %s is not a base64 ed25519 private key", what, fp)
+	}
+	return key
+}
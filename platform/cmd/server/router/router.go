@@ -1,13 +1,20 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"github.com/von0000/dronealgo-ota/platform/cmd/server/controller"
+	"github.com/von0000/dronealgo-ota/platform/cmd/server/web"
 )
 
-func SetRouters(r *gin.Engine) {
+func SetRouters(r *gin.Engine, p *controller.Platform) {
+	// 先于所有路由注册；只压缩允许列表中的类型，下载等二进制内容原样透传
+	r.Use(p.CompressResponses)
+	// 过载时推迟非关键端点（见 overload.go）
+	r.Use(p.ShedLoad)
 	r.GET("/swagger/*any",
 		ginSwagger.WrapHandler(
 			swaggerFiles.Handler,
@@ -15,11 +22,167 @@ func SetRouters(r *gin.Engine) {
 			ginSwagger.URL("/swagger/doc.json"), // 可选，显式指定文档地址
 		),
 	)
-	v1 := r.Group("/api/v1")
-	fileAPI := &controller.FileController{}
+	r.StaticFS("/admin", http.FS(web.Admin()))
+
+	fileAPI := controller.NewFileController(p)
+	r.GET("/healthz", fileAPI.Healthz)
+	// 公开状态页：不鉴权，按 IP 限流，只含公开渠道
+	r.GET("/status", controller.NewStatusController(p).Status)
+
+	// 配置了令牌时 API 都需要认证；渠道范围在各处理函数中按请求的渠道检查
+	v1 := r.Group("/api/v1", p.Authenticate)
 	{
-		v1.POST("/publish", fileAPI.Publish)
+		v1.POST("/publish", p.RequireAdmin, fileAPI.Publish)
 		v1.GET("/check", fileAPI.Check)
 		v1.GET("/download/:version", fileAPI.Download)
+		v1.GET("/releases", fileAPI.List)
+		v1.GET("/releases/compare", fileAPI.Compare)
+		v1.POST("/releases/:version/recall", p.RequireAdmin, fileAPI.Recall)
+		v1.DELETE("/releases/:version/recall", p.RequireAdmin, fileAPI.Unrecall)
+		v1.POST("/releases/:version/restore", p.RequireAdmin, fileAPI.Restore)
+		v1.GET("/aliases", p.RequireAdmin, fileAPI.Aliases)
+		v1.PUT("/aliases/:name", p.RequireAdmin, fileAPI.PutAlias)
+		v1.DELETE("/aliases/:name", p.RequireAdmin, fileAPI.DeleteAlias)
+		v1.GET("/channels/:channel/history", p.RequireAdmin, fileAPI.ChannelHistory)
+		v1.GET("/channels/:channel/latest", p.RequireAdmin, fileAPI.ChannelLatestAt)
+	}
+	bgAPI := controller.NewBreakGlassController(p)
+	{
+		v1.POST("/breakglass", bgAPI.Grant)
+		v1.DELETE("/breakglass", bgAPI.Revoke)
+		v1.GET("/audit", p.RequireAdmin, bgAPI.Audit)
+	}
+	// 管理端单点登录：浏览器会话 cookie 与令牌一样经 Authenticate 认证
+	oidcAPI := controller.NewOIDCController(p)
+	{
+		r.GET("/auth/oidc/login", oidcAPI.Login)
+		r.GET("/auth/oidc/callback", oidcAPI.Callback)
+		r.GET("/auth/logout", oidcAPI.Logout)
+		v1.GET("/whoami", oidcAPI.WhoAmI)
+	}
+	eventAPI := controller.NewEventController(p)
+	{
+		v1.GET("/devices/:id/events", p.RequireAdmin, eventAPI.Timeline)
+		v1.GET("/devices/:id/support-bundle", p.RequireAdmin, eventAPI.SupportBundle)
+		v1.POST("/devices/:id/events", controller.DecodeBody, eventAPI.Ingest)
+		v1.POST("/report", eventAPI.Report)
+		v1.POST("/logs", controller.DecodeBody, eventAPI.IngestLogs)
+		v1.GET("/devices/:id/logs", p.RequireAdmin, eventAPI.Logs)
+		v1.POST("/purge", p.RequireAdmin, eventAPI.Purge)
+		v1.GET("/devices/conflicts", p.RequireAdmin, eventAPI.Conflicts)
+		v1.GET("/crashes", p.RequireAdmin, eventAPI.Crashes)
+		v1.POST("/devices/:id/split", p.RequireAdmin, eventAPI.Split)
+	}
+	fleetAPI := controller.NewFleetController(p)
+	{
+		v1.GET("/fleet/versions", p.RequireAdmin, fleetAPI.VersionHistory)
+		v1.GET("/fleet/adoption", p.RequireAdmin, fleetAPI.Adoption)
+		v1.GET("/fleet/health", p.RequireAdmin, fleetAPI.Health)
+		v1.GET("/fleet/resources", p.RequireAdmin, fleetAPI.Resources)
+		v1.GET("/fleet/data-usage", p.RequireAdmin, fleetAPI.DataUsage)
+	}
+	reportAPI := controller.NewReportController(p)
+	{
+		v1.GET("/reports/cost", p.RequireAdmin, reportAPI.Cost)
+	}
+	exportAPI := controller.NewExportController(p)
+	{
+		v1.GET("/export/:version/:format", exportAPI.Export)
+		v1.GET("/updater/:format", exportAPI.Poll)
+	}
+
+	doctorAPI := controller.NewDoctorController(p)
+	{
+		v1.GET("/doctor", p.RequireAdmin, doctorAPI.Check)
+		v1.POST("/doctor/repair", p.RequireAdmin, doctorAPI.Repair)
+		v1.POST("/maintenance/resign", p.RequireAdmin, doctorAPI.StartResign)
+		v1.GET("/maintenance/resign", p.RequireAdmin, doctorAPI.ResignStatus)
+		v1.DELETE("/maintenance/resign", p.RequireAdmin, doctorAPI.CancelResign)
+	}
+
+	policyAPI := controller.NewPolicyController(p)
+	{
+		v1.GET("/policies", p.RequireAdmin, policyAPI.Policies)
+		v1.PUT("/policies/:name", p.RequireAdmin, policyAPI.PutPolicy)
+		v1.DELETE("/policies/:name", p.RequireAdmin, policyAPI.DeletePolicy)
+		v1.GET("/devices/:id/window", p.RequireAdmin, policyAPI.DeviceWindow)
+		v1.PUT("/devices/:id/window", p.RequireAdmin, policyAPI.PutDeviceWindow)
+		v1.DELETE("/devices/:id/window", p.RequireAdmin, policyAPI.DeleteDeviceWindow)
+		v1.GET("/freezes", p.RequireAdmin, policyAPI.Freezes)
+		v1.POST("/freezes", p.RequireAdmin, policyAPI.CreateFreeze)
+		v1.DELETE("/freezes/:id", p.RequireAdmin, policyAPI.DeleteFreeze)
+		v1.GET("/approvals", p.RequireAdmin, policyAPI.Approvals)
+		v1.POST("/approvals/bulk", p.RequireAdmin, policyAPI.BulkDecide)
+		v1.POST("/approvals/:id/:decision", p.RequireAdmin, policyAPI.Decide)
+	}
+
+	bisectAPI := controller.NewBisectController(p)
+	{
+		v1.GET("/bisections", p.RequireAdmin, bisectAPI.List)
+		v1.POST("/bisections", p.RequireAdmin, bisectAPI.Start)
+		v1.GET("/bisections/:id", p.RequireAdmin, bisectAPI.Get)
+		v1.POST("/bisections/:id/abort", p.RequireAdmin, bisectAPI.Abort)
+	}
+
+	shadowAPI := controller.NewShadowController(p)
+	{
+		v1.GET("/shadows", p.RequireAdmin, shadowAPI.List)
+		v1.POST("/shadows", p.RequireAdmin, shadowAPI.Start)
+		v1.GET("/shadows/:id", p.RequireAdmin, shadowAPI.Get)
+		v1.POST("/shadows/:id/promote", p.RequireAdmin, shadowAPI.Promote)
+		v1.POST("/shadows/:id/abort", p.RequireAdmin, shadowAPI.Abort)
+	}
+
+	rolloutAPI := controller.NewRolloutController(p)
+	{
+		v1.GET("/rollouts", p.RequireAdmin, rolloutAPI.List)
+		v1.POST("/rollouts", p.RequireAdmin, rolloutAPI.Start)
+		v1.GET("/rollouts/:id", p.RequireAdmin, rolloutAPI.Get)
+		v1.POST("/rollouts/:id/percent", p.RequireAdmin, rolloutAPI.SetPercent)
+		v1.POST("/rollouts/:id/pause", p.RequireAdmin, rolloutAPI.Pause)
+		v1.POST("/rollouts/:id/resume", p.RequireAdmin, rolloutAPI.Resume)
+		v1.POST("/rollouts/:id/abort", p.RequireAdmin, rolloutAPI.Abort)
+	}
+
+	// 跨区域复制：主区域查询复制状态，副本（-accept-replication）接收制品与元数据
+	replAPI := controller.NewReplicationController(p)
+	{
+		v1.GET("/replication", p.RequireAdmin, replAPI.Status)
+		v1.GET("/replication/metrics", p.RequireAdmin, replAPI.Metrics)
+		v1.PUT("/replication/artifacts/:version", p.RequireAdmin, replAPI.PutArtifact)
+		v1.GET("/replication/artifacts/:version", p.RequireAdmin, replAPI.GetArtifact)
+		v1.PUT("/replication/releases", p.RequireAdmin, replAPI.PutReleases)
+	}
+
+	logAPI := controller.NewTransparencyController(p)
+	{
+		v1.GET("/log/sth", logAPI.TreeHead)
+		v1.GET("/log/entries", logAPI.Entries)
+		v1.GET("/log/proof", logAPI.Proof)
+		v1.GET("/log/consistency", logAPI.Consistency)
+	}
+
+	// 性能剖析：标准 pprof 端点与后台采集，只对管理员开放
+	profAPI := controller.NewProfileController(p)
+	{
+		r.GET("/debug/pprof/*name", p.Authenticate, p.RequireAdmin, profAPI.Pprof)
+		r.POST("/debug/pprof/*name", p.Authenticate, p.RequireAdmin, profAPI.Pprof)
+		v1.GET("/profiles", p.RequireAdmin, profAPI.List)
+		v1.POST("/profiles", p.RequireAdmin, profAPI.Capture)
+		v1.GET("/profiles/:id", p.RequireAdmin, profAPI.Download)
+	}
+
+	tufAPI := controller.NewTUFController(p)
+	r.GET("/tuf/*file", p.Authenticate, tufAPI.Serve)
+
+	// hawkBit DDI 兼容端点，客户端的 server URL 配置为 http(s)://<host>/hawkbit
+	hawkbitAPI := controller.NewHawkbitController(p)
+	ddi := r.Group("/hawkbit/:tenant/controller/v1/:controllerId", p.Authenticate, hawkbitAPI.Scope)
+	{
+		ddi.GET("", hawkbitAPI.Poll)
+		ddi.GET("/deploymentBase/:actionId", hawkbitAPI.DeploymentBase)
+		ddi.POST("/deploymentBase/:actionId/feedback", hawkbitAPI.Feedback)
+		ddi.PUT("/configData", hawkbitAPI.ConfigData)
+		ddi.GET("/softwaremodules/:moduleId/artifacts/:filename", hawkbitAPI.Artifact)
 	}
 }
@@ -0,0 +1,101 @@
+const out = document.getElementById('out');
+const token = document.getElementById('token');
+
+// 令牌只保存在当前标签页的 sessionStorage 中
+token.value = sessionStorage.getItem('token') || '';
+token.addEventListener('change', () => {
+  sessionStorage.setItem('token', token.value);
+  whoami();
+});
+
+function authHeaders() {
+  return token.value ? { Authorization: 'Bearer ' + token.value } : {};
+}
+
+// 未填令牌时请求带上单点登录的会话 cookie（同源 fetch 默认携带）
+async function whoami() {
+  const el = document.getElementById('whoami');
+  const resp = await fetch('../api/v1/whoami', { headers: authHeaders() });
+  if (!resp.ok) {
+    el.textContent = '未登录';
+    return;
+  }
+  const me = await resp.json();
+  el.textContent = me.name + '（' + me.role + '）';
+}
+whoami();
+
+async function show(resp) {
+  const text = await resp.text();
+  try {
+    out.textContent = JSON.stringify(JSON.parse(text), null, 2);
+  } catch (e) {
+    out.textContent = text;
+  }
+}
+
+document.getElementById('publish').addEventListener('submit', async (ev) => {
+  ev.preventDefault();
+  const resp = await fetch('../api/v1/publish', { method: 'POST', body: new FormData(ev.target), headers: authHeaders() });
+  await show(resp);
+});
+
+document.getElementById('check').addEventListener('submit', async (ev) => {
+  ev.preventDefault();
+  const channel = new FormData(ev.target).get('channel') || 'stable';
+  const resp = await fetch('../api/v1/check?channel=' + encodeURIComponent(channel), { headers: authHeaders() });
+  await show(resp);
+});
+
+// 发布前审阅：两个版本的大小、哈希、元数据、关联问题与 SBOM 依赖的差异
+document.getElementById('compare').addEventListener('submit', async (ev) => {
+  ev.preventDefault();
+  const q = new URLSearchParams(new FormData(ev.target));
+  const resp = await fetch('../api/v1/releases/compare?' + q, { headers: authHeaders() });
+  await show(resp);
+});
+
+// 审批队列：勾选待批安装后批量批准或拒绝
+async function loadApprovals() {
+  const table = document.getElementById('approvals-list');
+  const resp = await fetch('../api/v1/approvals?state=pending', { headers: authHeaders() });
+  if (!resp.ok) {
+    await show(resp);
+    return;
+  }
+  const { approvals } = await resp.json();
+  table.replaceChildren();
+  const head = table.insertRow();
+  for (const h of ['', '设备', '当前', '目标', '渠道', '策略', '登记时间']) {
+    head.appendChild(document.createElement('th')).textContent = h;
+  }
+  for (const a of approvals) {
+    const row = table.insertRow();
+    const box = document.createElement('input');
+    box.type = 'checkbox';
+    box.value = a.id;
+    row.insertCell().appendChild(box);
+    for (const v of [a.device_id, a.from_version, a.version, a.channel, a.policy, new Date(a.requested_at).toLocaleString()]) {
+      row.insertCell().textContent = v || '';
+    }
+  }
+}
+
+document.getElementById('approvals-refresh').addEventListener('click', loadApprovals);
+
+document.getElementById('approvals').addEventListener('submit', async (ev) => {
+  ev.preventDefault();
+  const ids = [...ev.target.querySelectorAll('input[type=checkbox]:checked')].map((b) => b.value);
+  if (ids.length === 0) {
+    out.textContent = '未选择待批项';
+    return;
+  }
+  const body = { decision: ev.submitter.value, ids, reason: new FormData(ev.target).get('reason') };
+  const resp = await fetch('../api/v1/approvals/bulk', {
+    method: 'POST',
+    body: JSON.stringify(body),
+    headers: { ...authHeaders(), 'Content-Type': 'application/json' },
+  });
+  await show(resp);
+  await loadApprovals();
+});
@@ -0,0 +1,19 @@
+// Package web embeds the static admin UI so the server ships as one binary.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed admin
+var assets embed.FS
+
+// Admin returns the admin UI file tree rooted at admin/.
+func Admin() fs.FS {
+	sub, err := fs.Sub(assets, "admin")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}